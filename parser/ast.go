@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// StageKind classifies the stages of an expression.
+type StageKind int
+
+const (
+	InvalidStage   StageKind = iota // Neither a query, a pipe operation nor a reference
+	QueryStage                      // A query in an expression language, e.g. "jsonpath:order.id", built in or registered
+	PipeStage                       // A pipe operation, e.g. "substring(0, 5)"
+	ReferenceStage                  // A registered expression, e.g. "@orderTotal"
+)
+
+func (k StageKind) String() string {
+	switch k {
+	case InvalidStage:
+		return "invalid"
+	case QueryStage:
+		return "query"
+	case PipeStage:
+		return "pipe"
+	case ReferenceStage:
+		return "reference"
+	default:
+		return fmt.Sprintf("StageKind(%d)", int(k))
+	}
+}
+
+// Expression is the syntax tree of an expression: the pipeline of its
+// stages, with the sub-expressions of collection pipes parsed in turn.
+// Expressions are immutable once parsed, and shared between evaluations.
+type Expression struct {
+	Source string // The expression as written
+	Stages []*Stage
+}
+
+// Stage is one stage of an Expression.
+type Stage struct {
+	Kind     StageKind
+	Text     string        // The stage as written, without the whitespace around it or after the prefix of a query
+	Offset   int           // Byte offset of the stage in the Source of its Expression
+	Language string        // Prefix of a query, e.g. "jsonpath:"
+	Query    string        // Query after the prefix
+	Pipe     string        // Name of a pipe operation
+	Args     []interface{} // Arguments of a pipe operation: string, float64 or bool
+	Sub      *Expression   // Sub-expression of a collection pipe; for filter, without its comparison
+	Name     string        // Name of a referenced expression
+	Err      error         // Why the stage is invalid; a pipe with invalid arguments is still a PipeStage
+}
+
+// ParseExpression parses expression into its syntax tree without evaluating
+// it. Stages are separated by "|" outside quotes and brackets, with any
+// whitespace around it. Whitespace after a language prefix and before the
+// arguments of a pipe is ignored too, except in regex: stages, where it is
+// part of the pattern. The error reports the first stage that cannot be
+// parsed. Any "name:" prefix starts a query, so that languages registered
+// with RegisterLanguage parse too; whether pipe names, languages and
+// references are known to an engine is checked by ExpressionEngine.Lint.
+func ParseExpression(expression string) (*Expression, error) {
+	parsed := parseExpression(expression)
+	for i, stage := range parsed.Stages {
+		if err := stage.syntaxError(); err != nil {
+			return parsed, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("invalid stage %d ('%s')", i, stage.Text), InnerError: err}
+		}
+	}
+	return parsed, nil
+}
+
+// parseExpression parses expression, leaving the errors on its stages.
+func parseExpression(expression string) *Expression {
+	tokens := tokenizePipeline(expression)
+	stages := make([]*Stage, len(tokens))
+	for i, token := range tokens {
+		stages[i] = parseStage(token.text)
+		stages[i].Offset = token.offset
+	}
+	return &Expression{Source: expression, Stages: stages}
+}
+
+// parseStage parses one stage, without the whitespace around it.
+func parseStage(text string) *Stage {
+	stage := &Stage{Text: text}
+	if name, ok := reference(text); ok {
+		stage.Kind, stage.Name = ReferenceStage, name
+		return stage
+	}
+	if prefix, ok := queryPrefix(text); ok { // A built-in language
+		stage.Kind, stage.Language, stage.Query = QueryStage, prefix, strings.TrimPrefix(text, prefix)
+		if prefix != regexPrefix {
+			stage.Query = strings.TrimLeftFunc(stage.Query, unicode.IsSpace)
+			stage.Text = prefix + stage.Query
+		}
+		return stage
+	}
+	if layout, ok := strings.CutPrefix(text, fixedWidthToJSONPipe); ok {
+		stage.Kind, stage.Pipe = PipeStage, fixedWidthToJSONPipe
+		if layout = strings.TrimSpace(layout); layout == "" {
+			stage.Err = fmt.Errorf("pipe operation '%s' needs a layout name", text)
+		} else {
+			stage.Args = []interface{}{layout}
+		}
+		return stage
+	}
+	call, isCall, err := parsePipeCall(text)
+	switch {
+	case text == "":
+		stage.Err = errors.New("empty stage")
+	case !isCall:
+		prefix, ok := languagePrefix(text)
+		if !ok {
+			stage.Err = errors.New("unknown expression language or pipe operation")
+			break
+		}
+		// A language registered with an engine
+		stage.Kind, stage.Language = QueryStage, prefix
+		stage.Query = strings.TrimLeftFunc(strings.TrimPrefix(text, prefix), unicode.IsSpace)
+		stage.Text = prefix + stage.Query
+	default:
+		stage.Kind, stage.Pipe, stage.Args, stage.Err = PipeStage, call.name, call.args, err
+		if err == nil && isCollectionPipe(call.name) && len(call.args) > 0 {
+			sub := call.args[0].(string)
+			if call.name == filterPipe {
+				sub = parsePredicate(sub).expression
+			}
+			stage.Sub = parseExpression(sub)
+		}
+	}
+	return stage
+}
+
+// syntaxError returns the error of the stage or of a stage of its
+// sub-expression.
+func (s *Stage) syntaxError() error {
+	if s.Err != nil {
+		return s.Err
+	}
+	if s.Sub != nil {
+		for _, stage := range s.Sub.Stages {
+			if err := stage.syntaxError(); err != nil {
+				return fmt.Errorf("in the sub-expression '%s': %w", s.Sub.Source, err)
+			}
+		}
+	}
+	return nil
+}
+
+// call returns the pipe operation of a pipe stage.
+func (s *Stage) call() pipeCall {
+	if s.Kind != PipeStage {
+		return pipeCall{}
+	}
+	return pipeCall{name: s.Pipe, args: s.Args, sub: s.Sub}
+}
+
+// queryPrefix returns the expression language prefix text starts with.
+func queryPrefix(text string) (string, bool) {
+	for _, prefix := range queryPrefixes {
+		if strings.HasPrefix(text, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
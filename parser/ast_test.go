@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	type stage struct {
+		Kind     StageKind
+		Text     string
+		Language string
+		Query    string
+		Pipe     string
+		Args     []interface{}
+		Sub      []string // Texts of the sub-expression's stages
+		Name     string
+	}
+	tests := []struct {
+		name       string
+		expression string
+		want       []stage
+		wantErr    string
+	}{
+		{name: "query", expression: "jsonpath:order.id", want: []stage{
+			{Kind: QueryStage, Text: "jsonpath:order.id", Language: jsonpathPrefix, Query: "order.id"},
+		}},
+		{name: "pipes with arguments", expression: ` xpath://a  |substring(0, 3)|  default("n|a") `, want: []stage{
+			{Kind: QueryStage, Text: "xpath://a", Language: xpathPrefix, Query: "//a"},
+			{Kind: PipeStage, Text: "substring(0, 3)", Pipe: substringPipe, Args: []interface{}{float64(0), float64(3)}},
+			{Kind: PipeStage, Text: `default("n|a")`, Pipe: defaultPipe, Args: []interface{}{"n|a"}},
+		}},
+		{name: "collection pipe", expression: "jsonpath:items | map(jsonpath:price | toNumber)", want: []stage{
+			{Kind: QueryStage, Text: "jsonpath:items", Language: jsonpathPrefix, Query: "items"},
+			{Kind: PipeStage, Text: "map(jsonpath:price | toNumber)", Pipe: mapPipe, Args: []interface{}{"jsonpath:price | toNumber"}, Sub: []string{"jsonpath:price", "toNumber"}},
+		}},
+		{name: "filter without comparison", expression: "jsonpath:items | filter(jsonpath:qty > 2)", want: []stage{
+			{Kind: QueryStage, Text: "jsonpath:items", Language: jsonpathPrefix, Query: "items"},
+			{Kind: PipeStage, Text: "filter(jsonpath:qty > 2)", Pipe: filterPipe, Args: []interface{}{"jsonpath:qty > 2"}, Sub: []string{"jsonpath:qty"}},
+		}},
+		{name: "reference and fixed-width pipe", expression: "@records | fixedWidthToJSON: customer", want: []stage{
+			{Kind: ReferenceStage, Text: "@records", Name: "records"},
+			{Kind: PipeStage, Text: "fixedWidthToJSON: customer", Pipe: fixedWidthToJSONPipe, Args: []interface{}{"customer"}},
+		}},
+		{name: "unknown pipe parses", expression: "jsonpath:a | nosuchpipe", want: []stage{
+			{Kind: QueryStage, Text: "jsonpath:a", Language: jsonpathPrefix, Query: "a"},
+			{Kind: PipeStage, Text: "nosuchpipe", Pipe: "nosuchpipe"},
+		}},
+		{name: "empty stage", expression: "jsonpath:a | ", wantErr: "invalid stage 1 ('') (Caused by: empty stage)"},
+		{name: "language that is not built in", expression: "acme: order/total", want: []stage{
+			{Kind: QueryStage, Text: "acme:order/total", Language: "acme:", Query: "order/total"},
+		}},
+		{name: "neither query nor pipe", expression: "order id", wantErr: "invalid stage 0 ('order id') (Caused by: unknown expression language or pipe operation"},
+		{name: "invalid arguments", expression: `jsonpath:a | substring(0, "1)`, wantErr: "invalid arguments of 'substring'"},
+		{name: "missing layout", expression: "jsonpath:a | fixedWidthToJSON:", wantErr: "needs a layout name"},
+		{name: "invalid sub-expression", expression: "jsonpath:a | map(jsonpath:b | )", wantErr: "in the sub-expression 'jsonpath:b |': empty stage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if parsed.Source != tt.expression {
+				t.Errorf("Source: got %q, want %q", parsed.Source, tt.expression)
+			}
+			var got []stage
+			for _, s := range parsed.Stages {
+				g := stage{Kind: s.Kind, Text: s.Text, Language: s.Language, Query: s.Query, Pipe: s.Pipe, Args: s.Args, Name: s.Name}
+				if s.Sub != nil {
+					for _, sub := range s.Sub.Stages {
+						g.Sub = append(g.Sub, sub.Text)
+					}
+				}
+				got = append(got, g)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsedExpressionsAreCached(t *testing.T) {
+	engine := NewEngine()
+	payload := mustPayload(t, `{"items": [{"qty": 1}, {"qty": 3}]}`, "application/json")
+	for i := 0; i < 3; i++ {
+		result, err := engine.Evaluate(payload, "jsonpath:items | filter(jsonpath:qty > 2) | map(jsonpath:qty)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result.Value, []interface{}{float64(3)}) {
+			t.Fatalf("got %#v", result.Value)
+		}
+	}
+	// The sub-expressions are parsed with the expression that contains them
+	if got := engine.expressionCache.len(); got != 1 {
+		t.Errorf("cached %d entries, want 1", got)
+	}
+}
@@ -0,0 +1,244 @@
+package parser
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/tidwall/gjson"
+)
+
+// confluentMagicByte prefixes messages framed with the Confluent wire format:
+// magic byte, 4-byte big-endian schema ID, Avro binary body.
+const confluentMagicByte = 0x0
+
+// SchemaRegistryClient resolves Avro writer schemas by their registry ID.
+type SchemaRegistryClient interface {
+	SchemaByID(id int) (string, error)
+}
+
+// ConfluentRegistryOptions configures a ConfluentSchemaRegistry.
+type ConfluentRegistryOptions struct {
+	HTTPClient *http.Client // Client used for requests; nil uses a client with a 10s timeout
+	Username   string       // Basic auth user (e.g. a Confluent Cloud API key); empty disables auth
+	Password   string       // Basic auth password (e.g. a Confluent Cloud API secret)
+}
+
+// ConfluentSchemaRegistry is a SchemaRegistryClient for the Confluent Schema
+// Registry REST API. Fetched schemas are cached since IDs are immutable.
+type ConfluentSchemaRegistry struct {
+	baseURL string
+	options ConfluentRegistryOptions
+
+	mu    sync.RWMutex
+	cache map[int]string
+}
+
+// NewConfluentSchemaRegistry creates a client for the registry at baseURL
+// (e.g. "https://psrc-xxxx.region.aws.confluent.cloud").
+func NewConfluentSchemaRegistry(baseURL string, options ConfluentRegistryOptions) *ConfluentSchemaRegistry {
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &ConfluentSchemaRegistry{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		options: options,
+		cache:   make(map[int]string),
+	}
+}
+
+// SchemaByID fetches GET {baseURL}/schemas/ids/{id}.
+func (sr *ConfluentSchemaRegistry) SchemaByID(id int) (string, error) {
+	sr.mu.RLock()
+	schema, ok := sr.cache[id]
+	sr.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/schemas/ids/%d", sr.baseURL, id), nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid schema registry request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if sr.options.Username != "" {
+		req.SetBasicAuth(sr.options.Username, sr.options.Password)
+	}
+	resp, err := sr.options.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("schema registry request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("schema registry returned %s for schema id %d", resp.Status, id)
+	}
+	var body struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid schema registry response for schema id %d: %w", id, err)
+	}
+
+	sr.mu.Lock()
+	sr.cache[id] = body.Schema
+	sr.mu.Unlock()
+	return body.Schema, nil
+}
+
+// AvroSchemas holds the Avro writer schemas available to a PayloadFactory:
+// schemas registered by name and, optionally, a schema registry client for
+// Confluent-framed messages. Compiled codecs are cached. It is safe for concurrent use.
+type AvroSchemas struct {
+	mu       sync.RWMutex
+	named    map[string]*goavro.Codec
+	byID     map[int]*goavro.Codec
+	registry SchemaRegistryClient
+}
+
+func NewAvroSchemas() *AvroSchemas {
+	return &AvroSchemas{
+		named: make(map[string]*goavro.Codec),
+		byID:  make(map[int]*goavro.Codec),
+	}
+}
+
+// Register compiles schema and stores it under name.
+func (as *AvroSchemas) Register(name, schema string) error {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return fmt.Errorf("invalid Avro schema %s: %w", name, err)
+	}
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.named[name] = codec
+	return nil
+}
+
+// SetRegistry sets the client used to resolve schema IDs of Confluent-framed messages.
+func (as *AvroSchemas) SetRegistry(registry SchemaRegistryClient) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.registry = registry
+	as.byID = make(map[int]*goavro.Codec)
+}
+
+func (as *AvroSchemas) codecByName(name string) (*goavro.Codec, error) {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	codec, ok := as.named[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown Avro schema: %s", name)
+	}
+	return codec, nil
+}
+
+func (as *AvroSchemas) codecByID(id int) (*goavro.Codec, error) {
+	as.mu.RLock()
+	codec, ok := as.byID[id]
+	registry := as.registry
+	as.mu.RUnlock()
+	if ok {
+		return codec, nil
+	}
+	if registry == nil {
+		return nil, fmt.Errorf("no schema registry configured to resolve Avro schema id %d", id)
+	}
+	schema, err := registry.SchemaByID(id)
+	if err != nil {
+		return nil, err
+	}
+	codec, err = goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema for id %d: %w", id, err)
+	}
+	as.mu.Lock()
+	as.byID[id] = codec
+	as.mu.Unlock()
+	return codec, nil
+}
+
+// AvroPayload handles Avro binary data decoded with a writer schema.
+// The decoded datum is exposed as a JSON view, so it is queried with jsonpath
+// expressions. Union values keep Avro's JSON encoding, e.g. {"string": "x"}.
+type AvroPayload struct {
+	rawContent  []byte
+	native      interface{}
+	schemaID    int // Registry schema ID, -1 when the schema was supplied inline
+	jsonResult  gjson.Result
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// NewAvroPayload decodes content with the given writer schema codec.
+func NewAvroPayload(content []byte, codec *goavro.Codec) (*AvroPayload, error) {
+	return newAvroPayload(content, content, codec, -1)
+}
+
+// NewAvroPayloadFromRegistry decodes a Confluent-framed message, resolving its
+// writer schema through schemas.
+func NewAvroPayloadFromRegistry(content []byte, schemas *AvroSchemas) (*AvroPayload, error) {
+	if len(content) < 5 || content[0] != confluentMagicByte {
+		return nil, &ErrEvaluationFailed{Reason: "Avro payload is not framed with the Confluent wire format and no schema was given"}
+	}
+	schemaID := int(binary.BigEndian.Uint32(content[1:5]))
+	codec, err := schemas.codecByID(schemaID)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to resolve Avro writer schema", InnerError: err}
+	}
+	return newAvroPayload(content, content[5:], codec, schemaID)
+}
+
+func newAvroPayload(content, datum []byte, codec *goavro.Codec, schemaID int) (*AvroPayload, error) {
+	native, _, err := codec.NativeFromBinary(datum)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "Avro decoding failed", InnerError: err}
+	}
+	jsonView, err := marshalJSONView(native)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert Avro datum to JSON view", InnerError: err}
+	}
+	return &AvroPayload{
+		rawContent:  content,
+		native:      native,
+		schemaID:    schemaID,
+		jsonResult:  gjson.ParseBytes(jsonView),
+		contentType: "avro/binary",
+	}, nil
+}
+
+func (ap *AvroPayload) GetRawBytes() []byte {
+	return ap.rawContent
+}
+
+func (ap *AvroPayload) GetContentType() string {
+	return ap.contentType
+}
+
+// SchemaID returns the registry ID of the writer schema, or -1 for inline schemas.
+func (ap *AvroPayload) SchemaID() int {
+	return ap.schemaID
+}
+
+// Query evaluates a gjson path against the decoded datum.
+func (ap *AvroPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(ap.jsonResult, expression)
+}
+
+func (ap *AvroPayload) jsonView() gjson.Result {
+	return ap.jsonResult
+}
+
+// AsString returns the decoded datum as JSON, since the raw bytes are binary.
+func (ap *AvroPayload) AsString() (string, error) {
+	return ap.jsonResult.Raw, nil
+}
+
+func (ap *AvroPayload) GetUnderlying() interface{} {
+	return ap.native
+}
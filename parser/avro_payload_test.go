@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+const testOrderSchema = `{
+	"type": "record", "name": "Order",
+	"fields": [
+		{"name": "id", "type": "string"},
+		{"name": "total", "type": "double"},
+		{"name": "items", "type": {"type": "array", "items": "string"}}
+	]
+}`
+
+func encodeTestOrder(t *testing.T) []byte {
+	t.Helper()
+	codec, err := goavro.NewCodec(testOrderSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datum, err := codec.BinaryFromNative(nil, map[string]interface{}{
+		"id": "A-1", "total": 42.5, "items": []interface{}{"pen", "ink"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return datum
+}
+
+func TestAvroPayloadInlineSchema(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterAvroSchema("order-v1", testOrderSchema); err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext(encodeTestOrder(t), "avro/binary; schema=order-v1", engine)
+
+	tests := []struct {
+		expression string
+		want       interface{}
+	}{
+		{"jsonpath:id", "A-1"},
+		{"jsonpath:total", 42.5},
+		{"jsonpath:items.1", "ink"},
+	}
+	for _, tt := range tests {
+		result, err := msgCtx.EvaluateExpression(tt.expression)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.expression, err)
+		}
+		if result.Value != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expression, result.Value, tt.want)
+		}
+	}
+}
+
+func TestAvroPayloadConfluentRegistry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "key" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/schemas/ids/7" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"schema": testOrderSchema})
+	}))
+	defer server.Close()
+
+	framed := []byte{confluentMagicByte, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(framed[1:5], 7)
+	framed = append(framed, encodeTestOrder(t)...)
+
+	tests := []struct {
+		name    string
+		options ConfluentRegistryOptions
+		wantErr bool
+		wantID  string
+	}{
+		{name: "with credentials", options: ConfluentRegistryOptions{Username: "key", Password: "secret"}, wantID: "A-1"},
+		{name: "without credentials", options: ConfluentRegistryOptions{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetSchemaRegistry(NewConfluentSchemaRegistry(server.URL, tt.options))
+			result, err := NewMessageContext(framed, "avro/binary", engine).EvaluateExpression("jsonpath:id")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.wantID {
+				t.Errorf("id = %v, want %v", result.Value, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAvroPayloadRejectsUnframedContentWithoutSchema(t *testing.T) {
+	_, err := NewMessageContext([]byte{1, 2, 3}, "avro/binary", NewEngine()).EvaluateExpression("jsonpath:id")
+	if err == nil {
+		t.Fatal("expected an error for unframed Avro content")
+	}
+}
@@ -0,0 +1,168 @@
+package parser
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// ProcessOptions configures ProcessDirectory.
+type ProcessOptions struct {
+	Engine    *ExpressionEngine // Engine to evaluate with; a new engine when nil
+	Recursive bool              // Also process files in subdirectories
+
+	// Progress, if set, is called after each file has been processed with the
+	// number of files done and the total. Calls are serialized.
+	Progress func(done, total int)
+}
+
+// FileResult holds the values extracted from one file, keyed by expression.
+// Expressions that failed are missing from Values and reported as FileErrors.
+type FileResult struct {
+	Path   string
+	Values map[string]QueryResult
+}
+
+// ProcessDirectory evaluates expressions against every regular file in dir,
+// parsing each file as contentType, using up to workers goroutines (the number
+// of CPUs when workers <= 0). Results and errors are sent as files complete,
+// and both channels are closed when the whole directory is done. The channels
+// are buffered to hold every outcome of the run, as dir is listed before
+// ProcessDirectory returns, so callers may read them together or one after
+// the other, e.g. all results and then all errors.
+// Errors are *FileError values, except for a failure to list dir, which is
+// returned as is. A file that cannot be read or parsed has a single FileError
+// with no Expression and no result. Once ctx is done, no more files are
+// started and nothing more is sent; callers can tell an incomplete run by
+// ctx.Err().
+func ProcessDirectory(ctx context.Context, dir, contentType string, expressions []string, workers int, options ProcessOptions) (<-chan FileResult, <-chan error) {
+	engine := options.Engine
+	if engine == nil {
+		engine = NewEngine()
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	paths, err := listFiles(dir, options.Recursive)
+	if err != nil {
+		results, errs := make(chan FileResult), make(chan error, 1)
+		errs <- err
+		close(results)
+		close(errs)
+		return results, errs
+	}
+	// Each file sends a result and an error per failed expression, or a
+	// single error if it cannot be read or parsed
+	results := make(chan FileResult, len(paths))
+	errs := make(chan error, len(paths)*max(len(expressions), 1))
+
+	go func() {
+		defer close(results)
+		defer close(errs)
+
+		jobs := make(chan string)
+		var progressMu sync.Mutex
+		done := 0
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for path := range jobs {
+					if !processFile(ctx, engine, path, contentType, expressions, results, errs) {
+						continue // Cancelled: skip the remaining jobs
+					}
+					if options.Progress != nil {
+						progressMu.Lock()
+						done++
+						options.Progress(done, len(paths))
+						progressMu.Unlock()
+					}
+				}
+			}()
+		}
+	feed:
+		for _, path := range paths {
+			select {
+			case jobs <- path:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(jobs)
+		wg.Wait()
+	}()
+	return results, errs
+}
+
+// processFile evaluates all expressions against one file and sends the
+// outcome. It reports false if ctx was done before the outcome was sent.
+func processFile(ctx context.Context, engine *ExpressionEngine, path, contentType string, expressions []string, results chan<- FileResult, errs chan<- error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		errs <- &FileError{Path: path, Err: err}
+		return true
+	}
+	msgCtx := NewMessageContext(raw, contentType, engine)
+	if _, err := msgCtx.GetProcessedPayload(); err != nil {
+		errs <- &FileError{Path: path, Err: err}
+		return true
+	}
+	values := make(map[string]QueryResult, len(expressions))
+	var failed []error
+	for _, expression := range expressions {
+		result, err := msgCtx.EvaluateExpressionContext(ctx, expression)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err != nil {
+			failed = append(failed, &FileError{Path: path, Expression: expression, Err: err})
+			continue
+		}
+		values[expression] = result
+	}
+	for _, err := range failed {
+		errs <- err
+	}
+	results <- FileResult{Path: path, Values: values}
+	return true
+}
+
+// listFiles returns the regular files in dir in lexical order.
+func listFiles(dir string, recursive bool) ([]string, error) {
+	var paths []string
+	if !recursive {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+		return paths, nil
+	}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// collectDirectory drains both ProcessDirectory channels.
+func collectDirectory(results <-chan FileResult, errs <-chan error) (map[string]FileResult, []error) {
+	got := make(map[string]FileResult)
+	var gotErrs []error
+	for results != nil || errs != nil {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			got[filepath.Base(r.Path)] = r
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			gotErrs = append(gotErrs, err)
+		}
+	}
+	return got, gotErrs
+}
+
+func TestProcessDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.json":        `{"id": "a", "n": 1}`,
+		"b.json":        `{"id": "b"}`,
+		"bad.json":      `{`,
+		"sub/c.json":    `{"id": "c", "n": 3}`,
+		"sub/deep.json": `{"id": "deep", "n": 4}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		dir         string
+		recursive   bool
+		workers     int
+		wantResults []string // File names with a result
+		wantErrs    int
+		wantFiles   int // Files processed, as reported to Progress
+	}{
+		// b.json lacks "n", bad.json cannot be parsed
+		{name: "top level", dir: dir, workers: 2, wantResults: []string{"a.json", "b.json"}, wantErrs: 2, wantFiles: 3},
+		{name: "recursive", dir: dir, recursive: true, wantResults: []string{"a.json", "b.json", "c.json", "deep.json"}, wantErrs: 2, wantFiles: 5},
+		{name: "single worker", dir: filepath.Join(dir, "sub"), workers: 1, wantResults: []string{"c.json", "deep.json"}, wantFiles: 2},
+		{name: "missing directory", dir: filepath.Join(dir, "missing"), wantErrs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var progress []int
+			total := -1
+			results, errs := ProcessDirectory(context.Background(), tt.dir, "application/json", []string{"jsonpath:id", "jsonpath:n"}, tt.workers, ProcessOptions{
+				Recursive: tt.recursive,
+				Progress: func(done, all int) {
+					progress = append(progress, done)
+					total = all
+				},
+			})
+			got, gotErrs := collectDirectory(results, errs)
+
+			var names []string
+			for name := range got {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if len(names) != len(tt.wantResults) {
+				t.Fatalf("results for %v, want %v", names, tt.wantResults)
+			}
+			for i := range names {
+				if names[i] != tt.wantResults[i] {
+					t.Fatalf("results for %v, want %v", names, tt.wantResults)
+				}
+			}
+			if len(gotErrs) != tt.wantErrs {
+				t.Fatalf("got %d errors (%v), want %d", len(gotErrs), gotErrs, tt.wantErrs)
+			}
+			if tt.wantFiles > 0 {
+				if total != tt.wantFiles || len(progress) != total || progress[total-1] != total {
+					t.Errorf("progress calls %v with total %d", progress, total)
+				}
+			}
+		})
+	}
+
+	t.Run("values and file errors", func(t *testing.T) {
+		got, gotErrs := collectDirectory(ProcessDirectory(context.Background(), dir, "application/json", []string{"jsonpath:n"}, 0, ProcessOptions{}))
+		if got["a.json"].Values["jsonpath:n"].Value != float64(1) {
+			t.Errorf("a.json n = %#v", got["a.json"].Values["jsonpath:n"].Value)
+		}
+		if _, ok := got["b.json"].Values["jsonpath:n"]; ok {
+			t.Error("failed expression should be missing from Values")
+		}
+		if _, ok := got["bad.json"]; ok {
+			t.Error("a file that cannot be parsed should have no result")
+		}
+		for _, err := range gotErrs {
+			var fileErr *FileError
+			if !errors.As(err, &fileErr) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			switch filepath.Base(fileErr.Path) {
+			case "b.json":
+				if fileErr.Expression != "jsonpath:n" {
+					t.Errorf("b.json: got expression %q, want jsonpath:n", fileErr.Expression)
+				}
+			case "bad.json":
+				if fileErr.Expression != "" {
+					t.Errorf("bad.json: got expression %q, want a file-level error", fileErr.Expression)
+				}
+			default:
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+	})
+
+	t.Run("channels read one after the other", func(t *testing.T) {
+		results, errs := ProcessDirectory(context.Background(), dir, "application/json", []string{"jsonpath:id", "jsonpath:n", "jsonpath:missing"}, 1, ProcessOptions{Recursive: true})
+		done := make(chan struct{})
+		var gotResults, gotErrs int
+		go func() {
+			defer close(done)
+			for range results {
+				gotResults++
+			}
+			for range errs {
+				gotErrs++
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("reading the results before the errors deadlocked")
+		}
+		// Every file but bad.json misses "missing", and b.json "n"
+		if gotResults != 4 || gotErrs != 6 {
+			t.Errorf("got %d results and %d errors, want 4 and 6", gotResults, gotErrs)
+		}
+	})
+}
+
+func TestProcessDirectoryCancel(t *testing.T) {
+	dir := t.TempDir()
+	const files = 50
+	for i := 0; i < files; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%02d.json", i)), []byte(`{"id": 1}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results, errs := ProcessDirectory(ctx, dir, "application/json", []string{"jsonpath:id", "jsonpath:missing"}, 2, ProcessOptions{})
+	// Read one outcome, then stop draining
+	select {
+	case <-results:
+	case <-errs:
+	}
+	cancel()
+
+	// The workers and the feeder give up their sends, closing both channels
+	done := make(chan int)
+	go func() {
+		got, _ := collectDirectory(results, errs)
+		done <- len(got)
+	}()
+	select {
+	case got := <-done:
+		if got >= files-1 {
+			t.Errorf("got %d more results after cancelling, want fewer than %d", got, files-1)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channels not closed after cancelling")
+	}
+}
@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// BinaryPayload handles opaque binary data ("application/octet-stream").
+// The query language ("bytes:" expressions) is:
+//
+//	all              the whole content, as bytes
+//	range(start,end) bytes start (inclusive) to end (exclusive); end may be
+//	                 omitted for the rest of the content
+//	length           the number of bytes
+//	md5, sha1, sha256 the hex-encoded digest of the content
+//
+// Byte results can be encoded with the hex and base64Encode pipes, e.g.
+// "bytes:range(0,4) | hex".
+type BinaryPayload struct {
+	rawContent  []byte
+	contentType string
+}
+
+// NewBinaryPayload wraps content. It never fails.
+func NewBinaryPayload(content []byte) (*BinaryPayload, error) {
+	return &BinaryPayload{rawContent: content, contentType: "application/octet-stream"}, nil
+}
+
+func (bp *BinaryPayload) GetRawBytes() []byte {
+	return bp.rawContent
+}
+
+func (bp *BinaryPayload) GetContentType() string {
+	return bp.contentType
+}
+
+// Query evaluates a bytes query (see BinaryPayload).
+func (bp *BinaryPayload) Query(expression string) (QueryResult, error) {
+	expression = strings.TrimSpace(expression)
+	switch expression {
+	case "all":
+		return QueryResult{Value: bp.rawContent, Type: BytesResult}, nil
+	case "length":
+		return QueryResult{Value: float64(len(bp.rawContent)), Type: NumberResult}, nil
+	case md5Pipe, sha1Pipe, sha256Pipe:
+		return QueryResult{Value: hex.EncodeToString(digest(expression, bp.rawContent)), Type: StringResult}, nil
+	}
+
+	if !strings.HasPrefix(expression, "range(") || !strings.HasSuffix(expression, ")") {
+		return QueryResult{}, &ErrUnsupportedExpression{Expression: expression}
+	}
+	args := strings.Split(expression[len("range("):len(expression)-1], ",")
+	if len(args) > 2 {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "range takes a start and an optional end"}
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(args[0]))
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "invalid range start", InnerError: err}
+	}
+	end := len(bp.rawContent)
+	if len(args) == 2 {
+		if end, err = strconv.Atoi(strings.TrimSpace(args[1])); err != nil {
+			return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "invalid range end", InnerError: err}
+		}
+	}
+	if start < 0 || start > end || end > len(bp.rawContent) {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("range [%d,%d) out of bounds for %d byte(s)", start, end, len(bp.rawContent)), InnerError: ErrNoMatch}
+	}
+	return QueryResult{Value: bp.rawContent[start:end], Type: BytesResult}, nil
+}
+
+// AsString returns the content hex-encoded, since it is not text.
+func (bp *BinaryPayload) AsString() (string, error) {
+	return hex.EncodeToString(bp.rawContent), nil
+}
+
+func (bp *BinaryPayload) GetUnderlying() interface{} {
+	return bp.rawContent
+}
+
+// decodeBase64 decodes standard or URL-safe base64, with or without padding.
+// Whitespace such as the line breaks of MIME-wrapped content is ignored.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+	encoding := base64.StdEncoding
+	if strings.ContainsAny(s, "-_") {
+		encoding = base64.URLEncoding
+	}
+	if len(s)%4 != 0 { // Padding omitted
+		encoding = encoding.WithPadding(base64.NoPadding)
+	}
+	return encoding.DecodeString(s)
+}
+
+// digest returns the digest of data with algorithm: md5, sha1, sha256, or
+// crc32 (IEEE, as 4 big-endian bytes).
+func digest(algorithm string, data []byte) []byte {
+	switch algorithm {
+	case md5Pipe:
+		sum := md5.Sum(data)
+		return sum[:]
+	case sha1Pipe:
+		sum := sha1.Sum(data)
+		return sum[:]
+	case crc32Pipe:
+		return binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(data))
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBinaryPayloadQuery(t *testing.T) {
+	// A PNG signature followed by some data
+	content := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 'd', 'a', 't', 'a'}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantType   ResultType
+		wantErr    bool
+	}{
+		{name: "all", expression: "bytes:all", want: content, wantType: BytesResult},
+		{name: "length", expression: "bytes:length", want: float64(12), wantType: NumberResult},
+		{name: "range", expression: "bytes:range(1,4)", want: []byte("PNG"), wantType: BytesResult},
+		{name: "open range", expression: "bytes:range(8)", want: []byte("data"), wantType: BytesResult},
+		{name: "empty range", expression: "bytes:range(3,3)", want: []byte{}, wantType: BytesResult},
+		{name: "range to hex", expression: "bytes:range(0,4) | hex", want: "89504e47", wantType: StringResult},
+		{name: "all to base64", expression: "bytes:all | base64Encode", want: "iVBORw0KGgpkYXRh", wantType: StringResult},
+		{name: "base64 round trip", expression: "bytes:all | base64Encode | base64Decode", want: content, wantType: BytesResult},
+		{name: "range as text", expression: "bytes:range(8) | regex:d(at)a", want: "at", wantType: StringResult},
+		{name: "range out of bounds", expression: "bytes:range(4,13)", wantErr: true},
+		{name: "reversed range", expression: "bytes:range(4,2)", wantErr: true},
+		{name: "negative start", expression: "bytes:range(-1,2)", wantErr: true},
+		{name: "too many arguments", expression: "bytes:range(1,2,3)", wantErr: true},
+		{name: "unknown query", expression: "bytes:crc32", wantErr: true},
+		{name: "hex of number", expression: "bytes:length | hex", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(content, "application/octet-stream", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestBinaryPayloadDigests(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "md5", want: "900150983cd24fb0d6963f7d28e17f72"},
+		{query: "sha1", want: "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{query: "sha256", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+	payload, _ := NewBinaryPayload([]byte("abc"))
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			result, err := payload.Query(tt.query)
+			if err != nil || result.Value != tt.want {
+				t.Errorf("got %v, %v, want %s", result.Value, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestPipes(t *testing.T) {
+	body := []byte(`{"text":"abc","payload":{"a":1},"count":3}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "sha256 to hex", expression: "jsonpath:text | sha256 | toHex", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{name: "sha1", expression: "jsonpath:text | sha1 | hex", want: "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{name: "md5 to base64", expression: "jsonpath:text | md5 | base64Encode", want: "kAFQmDzST7DWlj99KOF/cg=="},
+		{name: "crc32", expression: "jsonpath:text | crc32 | hex", want: "352441c2"},
+		{name: "raw digest", expression: "jsonpath:text | crc32", want: []byte{0x35, 0x24, 0x41, 0xc2}},
+		{name: "object hashed as json", expression: "jsonpath:payload | sha256 | toHex", want: "015abd7f5cc57a2dd94b7590f04ad8084273905ee33ec5cebeae62276a97f862"},
+		{name: "digest of digest", expression: "jsonpath:text | sha256 | sha256 | hex", want: "4f8b42c22dd3729b519ba6f68d2da7cc5b2d606d05daed5ad5128cc03e6c6358"},
+		{name: "number input", expression: "jsonpath:count | sha256", wantErr: "requires string, array or object input"},
+		{name: "no arguments", expression: `jsonpath:text | sha256("key")`, wantErr: "takes no arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestBase64DecodePipe(t *testing.T) {
+	invoice := "<invoice><total>99.50</total></invoice>"
+	body := fmt.Sprintf(`{"doc":{"content":%q,"wrapped":%q,"url":%q,"unpadded":%q,"text":"hello world"},"bad":"not base64!"}`,
+		base64.StdEncoding.EncodeToString([]byte(invoice)),
+		"PGludm9pY2U+PHRvdGFsPjk5LjUwPC90\r\nb3RhbD48L2ludm9pY2U+",
+		base64.URLEncoding.EncodeToString([]byte{0xfb, 0xff, 0xbf}),
+		base64.RawStdEncoding.EncodeToString([]byte("ab")))
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantType   ResultType
+		wantErr    string
+	}{
+		{name: "end to end", expression: "jsonpath:doc.content | base64Decode | extractAsXML | xpath:/invoice/total", want: "99.50", wantType: StringResult},
+		{name: "text result", expression: "jsonpath:doc.content | base64Decode", want: invoice, wantType: StringResult},
+		{name: "line-wrapped", expression: "jsonpath:doc.wrapped | base64Decode", want: invoice, wantType: StringResult},
+		{name: "url-safe binary", expression: "jsonpath:doc.url | base64Decode", want: []byte{0xfb, 0xff, 0xbf}, wantType: BytesResult},
+		{name: "binary to hex", expression: "jsonpath:doc.url | base64Decode | hex", want: "fbffbf", wantType: StringResult},
+		{name: "unpadded", expression: "jsonpath:doc.unpadded | base64Decode", want: "ab", wantType: StringResult},
+		{name: "encode and decode", expression: "jsonpath:doc.text | base64Encode | base64Decode", want: "hello world", wantType: StringResult},
+		{name: "invalid input", expression: "jsonpath:bad | base64Decode", wantErr: "invalid base64 input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(body), "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
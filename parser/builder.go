@@ -0,0 +1,205 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PipelineBuilder composes an expression stage by stage, e.g.
+//
+//	expr, err := parser.Pipeline().XPath("/order/details/text()").ExtractJSON().JSONPath("item").Build()
+//
+// so that Go code does not build expressions by concatenating strings:
+// pipe arguments are quoted, and a "|" in a query that would split the
+// stage is escaped where the language allows it. Each method returns a new
+// builder and leaves the one it is called on unchanged, so a builder can be
+// the shared prefix of several expressions. Errors are reported by Build.
+type PipelineBuilder struct {
+	engine *ExpressionEngine // Compiles the expression; a new engine if nil
+	stages []builderStage
+	err    error // The first error of a method
+}
+
+// builderStage is a stage of a PipelineBuilder, as written in the
+// expression and as it must parse.
+type builderStage struct {
+	text  string
+	kind  StageKind
+	query string // Query of a query stage
+}
+
+// Pipeline starts an expression that Build compiles with a new engine,
+// which knows the built-in languages and pipes only.
+func Pipeline() *PipelineBuilder {
+	return &PipelineBuilder{}
+}
+
+// Pipeline starts an expression that Build compiles with this engine, so
+// that it can use the languages, pipes and expressions registered on it.
+func (ee *ExpressionEngine) Pipeline() *PipelineBuilder {
+	return &PipelineBuilder{engine: ee}
+}
+
+// Query adds a query in the expression language written with the prefix
+// language + ":", e.g. Query("css", "div.total").
+func (b *PipelineBuilder) Query(language, query string) *PipelineBuilder {
+	text := language + ":" + query
+	if language == "" || strings.IndexFunc(language, func(r rune) bool { return !isPipeNameRune(r) }) >= 0 {
+		return b.fail(fmt.Errorf("invalid expression language '%s'", language))
+	}
+	return b.add(builderStage{text: escapeStageText(text), kind: QueryStage, query: query})
+}
+
+// escapeStageText escapes each "|" that would split the stage text, e.g. of
+// an XPath union, where the language allows it.
+func escapeStageText(text string) string {
+	tokens := scanStages(text, false)
+	if len(tokens) < 2 {
+		return text
+	}
+	if _, keepEscapes := stageSyntax(text); keepEscapes {
+		return text
+	}
+	var sb strings.Builder
+	last := 0
+	for _, token := range tokens[1:] {
+		sb.WriteString(text[last : token.offset-1])
+		sb.WriteString(`\|`)
+		last = token.offset
+	}
+	return sb.String() + text[last:]
+}
+
+// XPath adds an xpath: query.
+func (b *PipelineBuilder) XPath(query string) *PipelineBuilder {
+	return b.Query(strings.TrimSuffix(xpathPrefix, ":"), query)
+}
+
+// JSONPath adds a jsonpath: query.
+func (b *PipelineBuilder) JSONPath(query string) *PipelineBuilder {
+	return b.Query(strings.TrimSuffix(jsonpathPrefix, ":"), query)
+}
+
+// ExtractJSON adds the extractAsJSON pipe, which parses the previous result
+// as a JSON payload for the queries after it.
+func (b *PipelineBuilder) ExtractJSON() *PipelineBuilder {
+	return b.Pipe(extractAsJSONPipe)
+}
+
+// ExtractXML adds the extractAsXML pipe, which parses the previous result
+// as an XML payload for the queries after it.
+func (b *PipelineBuilder) ExtractXML() *PipelineBuilder {
+	return b.Pipe(extractAsXMLPipe)
+}
+
+// Map adds the map pipe, which evaluates the expression of sub against each
+// element of the previous result.
+func (b *PipelineBuilder) Map(sub *PipelineBuilder) *PipelineBuilder {
+	return b.Pipe(mapPipe, sub)
+}
+
+// Reference adds the stages of the expression registered as name (see
+// RegisterExpression).
+func (b *PipelineBuilder) Reference(name string) *PipelineBuilder {
+	if !isExpressionName(name) {
+		return b.fail(fmt.Errorf("invalid expression name '%s'", name))
+	}
+	return b.add(builderStage{text: referencePrefix + name, kind: ReferenceStage})
+}
+
+// Pipe adds the pipe operation name with args, e.g. Pipe("substring", 0, 5)
+// or Pipe("default", "N/A"). Arguments are strings, booleans, integers or
+// floating-point numbers, or a *PipelineBuilder for the sub-expression of a
+// collection pipe such as filter or sortBy.
+func (b *PipelineBuilder) Pipe(name string, args ...interface{}) *PipelineBuilder {
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isPipeNameRune(r) }) >= 0 {
+		return b.fail(fmt.Errorf("invalid pipe name '%s'", name))
+	}
+	if len(args) == 0 {
+		return b.add(builderStage{text: name, kind: PipeStage})
+	}
+	texts := make([]string, len(args))
+	for i, arg := range args {
+		text, err := formatPipeArg(arg)
+		if err != nil {
+			return b.fail(fmt.Errorf("argument %d of '%s': %w", i, name, err))
+		}
+		texts[i] = text
+	}
+	return b.add(builderStage{text: name + "(" + strings.Join(texts, ", ") + ")", kind: PipeStage})
+}
+
+// String returns the expression built so far.
+func (b *PipelineBuilder) String() string {
+	texts := make([]string, len(b.stages))
+	for i, stage := range b.stages {
+		texts[i] = stage.text
+	}
+	return strings.Join(texts, " | ")
+}
+
+// Build compiles the expression, like ExpressionEngine.Compile does. It
+// fails with the first error of the methods that built it, or when a stage
+// cannot be written so that it parses as the stage it was given as, e.g. a
+// jsonpath: query containing "|" outside brackets.
+func (b *PipelineBuilder) Build() (*CompiledExpression, error) {
+	expression := b.String()
+	if b.err != nil {
+		return nil, &ErrEvaluationFailed{Expression: expression, Reason: "invalid pipeline", InnerError: b.err}
+	}
+	if len(b.stages) == 0 {
+		return nil, &ErrEvaluationFailed{Expression: expression, Reason: "empty pipeline"}
+	}
+	parsed := parseExpression(expression)
+	for i, stage := range b.stages {
+		if len(parsed.Stages) != len(b.stages) || parsed.Stages[i].Kind != stage.kind || stage.kind == QueryStage && parsed.Stages[i].Query != stage.query {
+			return nil, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("stage %d ('%s') cannot be written as a single stage", i, stage.text)}
+		}
+	}
+	engine := b.engine
+	if engine == nil {
+		engine = NewEngine()
+	}
+	return engine.Compile(expression)
+}
+
+// add returns a builder with stage after the stages of b.
+func (b *PipelineBuilder) add(stage builderStage) *PipelineBuilder {
+	next := *b
+	next.stages = append(b.stages[:len(b.stages):len(b.stages)], stage)
+	return &next
+}
+
+// fail returns a builder that keeps the first error of b, or err.
+func (b *PipelineBuilder) fail(err error) *PipelineBuilder {
+	next := *b
+	if next.err == nil {
+		next.err = err
+	}
+	return &next
+}
+
+// formatPipeArg writes arg as parsePipeArg reads it.
+func formatPipeArg(arg interface{}) (string, error) {
+	switch v := arg.(type) {
+	case string:
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+		return `"` + r.Replace(v) + `"`, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case *PipelineBuilder:
+		if v.err != nil {
+			return "", v.err
+		}
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", arg)
+	}
+}
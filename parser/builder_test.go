@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPipelineBuilder(t *testing.T) {
+	xmlBody := []byte(`<order><details>{"item": "book", "tags": [" b ", "a"]}</details><line>1</line><line>2</line></order>`)
+	jsonBody := []byte(`{"store": {"book": [{"title": "Go", "price": 30}, {"title": "C", "price": 5}, {"title": "Rust", "price": 40}]}, "note": "say \"hi\"\tnow"}`)
+
+	tests := []struct {
+		name        string
+		builder     *PipelineBuilder
+		body        []byte
+		contentType string
+		wantText    string
+		want        interface{}
+	}{
+		{
+			name:        "mixed content",
+			builder:     Pipeline().XPath("/order/details/text()").ExtractJSON().JSONPath("item"),
+			body:        xmlBody,
+			contentType: "application/xml",
+			wantText:    "xpath:/order/details/text() | extractAsJSON | jsonpath:item",
+			want:        "book",
+		},
+		{
+			name:        "pipe arguments",
+			builder:     Pipeline().XPath("/order/details/text()").ExtractJSON().JSONPath("tags").Map(Pipeline().JSONPath("@this").Pipe("trim")).Pipe("join", "|"),
+			body:        xmlBody,
+			contentType: "application/xml",
+			wantText:    `xpath:/order/details/text() | extractAsJSON | jsonpath:tags | map(jsonpath:@this | trim) | join("|")`,
+			want:        "b|a",
+		},
+		{
+			name:        "XPath union",
+			builder:     Pipeline().XPath("count(/order/line | /order/details)"),
+			body:        xmlBody,
+			contentType: "application/xml",
+			wantText:    "xpath:count(/order/line | /order/details)",
+			want:        float64(3),
+		},
+		{
+			name:        "escaped XPath union",
+			builder:     Pipeline().XPath("/order/line | /order/missing").Pipe("last"),
+			body:        xmlBody,
+			contentType: "application/xml",
+			wantText:    `xpath:/order/line \| /order/missing | last`,
+			want:        "2",
+		},
+		{
+			name:        "quoted string",
+			builder:     Pipeline().JSONPath("note").Pipe("replace", "\"hi\"\t", "hello "),
+			body:        jsonBody,
+			contentType: "application/json",
+			wantText:    `jsonpath:note | replace("\"hi\"\t", "hello ")`,
+			want:        "say hello now",
+		},
+		{
+			name:        "collection pipes",
+			builder:     Pipeline().JSONPath("store.book").Pipe("filter", Pipeline().JSONPath("price")).Pipe("sortBy", Pipeline().JSONPath("price"), "desc").Pipe("limit", 2).Map(Pipeline().JSONPath("title")),
+			body:        jsonBody,
+			contentType: "application/json",
+			wantText:    `jsonpath:store.book | filter(jsonpath:price) | sortBy(jsonpath:price, "desc") | limit(2) | map(jsonpath:title)`,
+			want:        []interface{}{"Rust", "Go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.builder.String(); got != tt.wantText {
+				t.Errorf("String() = %q, want %q", got, tt.wantText)
+			}
+			compiled, err := tt.builder.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := compiled.EvaluateMessage(NewMessageContext(tt.body, tt.contentType, NewEngine()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipelineBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *PipelineBuilder
+		wantErr string
+	}{
+		{name: "empty", builder: Pipeline(), wantErr: "empty pipeline"},
+		{name: "split jsonpath", builder: Pipeline().JSONPath("a|b"), wantErr: "stage 0 ('jsonpath:a|b') cannot be written as a single stage"},
+		{name: "pipe name", builder: Pipeline().JSONPath("a").Pipe("to upper"), wantErr: "invalid pipe name 'to upper'"},
+		{name: "argument type", builder: Pipeline().JSONPath("a").Pipe("default", []string{"x"}), wantErr: "argument 0 of 'default': unsupported type []string"},
+		{name: "language", builder: Pipeline().Query("", "a"), wantErr: "invalid expression language ''"},
+		{name: "first error kept", builder: Pipeline().Reference("a b").Pipe("1 2"), wantErr: "invalid expression name 'a b'"},
+		{name: "unknown pipe", builder: Pipeline().JSONPath("a").Pipe("shout"), wantErr: "unknown expression language or pipe operation"},
+		{name: "type error", builder: Pipeline().XPath("count(//a)").ExtractJSON(), wantErr: "extractAsJSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.builder.Build(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPipelineBuilderEngine(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterExpression("embeddedOrder", "xpath:/order/details/text() | extractAsJSON"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterPipe("shout", func(_ context.Context, input PipeInput) (QueryResult, error) {
+		return QueryResult{Value: strings.ToUpper(input.Text) + "!"}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A shared prefix is not changed by the builders that extend it
+	order := engine.Pipeline().Reference("embeddedOrder")
+	item := order.JSONPath("item").Pipe("shout")
+	count := order.JSONPath("tags.#")
+	if got := order.String(); got != "@embeddedOrder" {
+		t.Errorf("prefix changed to %q", got)
+	}
+
+	msgCtx := NewMessageContext([]byte(`<order><details>{"item": "book", "tags": ["a", "b"]}</details></order>`), "application/xml", engine)
+	for builder, want := range map[*PipelineBuilder]interface{}{item: "BOOK!", count: float64(2)} {
+		compiled, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := compiled.EvaluateMessage(msgCtx); err != nil || result.Value != want {
+			t.Errorf("%s: got %v, %v, want %v", builder, result.Value, err, want)
+		}
+	}
+
+	if _, err := Pipeline().JSONPath("item").Pipe("shout").Build(); err == nil {
+		t.Error("expected an error for a pipe that is only registered on another engine")
+	}
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"github.com/fxamacker/cbor/v2"
+	"github.com/tidwall/gjson"
+)
+
+// CBORPayload handles CBOR data.
+// The document is decoded into a JSON view, so it is queried with jsonpath
+// expressions and can be piped into JSON transforms. Byte strings appear
+// base64-encoded, date/time tags as RFC 3339 strings and other tags as their
+// content.
+type CBORPayload struct {
+	rawContent  []byte
+	native      interface{}
+	jsonResult  gjson.Result
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// NewCBORPayload decodes a single CBOR data item.
+func NewCBORPayload(content []byte) (*CBORPayload, error) {
+	var native interface{}
+	if err := cbor.Unmarshal(content, &native); err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "CBOR decoding failed", InnerError: err}
+	}
+	jsonView, err := marshalJSONView(untagCBOR(native))
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert CBOR document to JSON view", InnerError: err}
+	}
+	return &CBORPayload{
+		rawContent:  content,
+		native:      native,
+		jsonResult:  gjson.ParseBytes(jsonView),
+		contentType: "application/cbor",
+	}, nil
+}
+
+// untagCBOR replaces tags the decoder does not interpret with their content.
+func untagCBOR(v interface{}) interface{} {
+	switch val := v.(type) {
+	case cbor.Tag:
+		return untagCBOR(val.Content)
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(val))
+		for k, item := range val {
+			out[k] = untagCBOR(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = untagCBOR(item)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+func (cp *CBORPayload) GetRawBytes() []byte {
+	return cp.rawContent
+}
+
+func (cp *CBORPayload) GetContentType() string {
+	return cp.contentType
+}
+
+// Query evaluates a gjson path against the decoded document.
+func (cp *CBORPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(cp.jsonResult, expression)
+}
+
+func (cp *CBORPayload) jsonView() gjson.Result {
+	return cp.jsonResult
+}
+
+// AsString returns the decoded document as JSON, since the raw bytes are binary.
+func (cp *CBORPayload) AsString() (string, error) {
+	return cp.jsonResult.Raw, nil
+}
+
+func (cp *CBORPayload) GetUnderlying() interface{} {
+	return cp.native
+}
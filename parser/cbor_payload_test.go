@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestCBORPayloadQuery(t *testing.T) {
+	doc, err := cbor.Marshal(map[interface{}]interface{}{
+		"device":  "sensor-7",
+		"temp":    21.5,
+		"ok":      true,
+		"samples": []interface{}{1, 2, 3},
+		1:         "integer key",
+		"raw":     []byte("hi"),
+		"at":      cbor.Tag{Number: 0, Content: "2024-01-02T03:04:05Z"},
+		"tagged":  cbor.Tag{Number: 4000, Content: "inner"},
+		"nan":     math.NaN(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "string", expression: "jsonpath:device", want: "sensor-7"},
+		{name: "float", expression: "jsonpath:temp", want: 21.5},
+		{name: "boolean", expression: "jsonpath:ok", want: true},
+		{name: "array", expression: "jsonpath:samples", want: []interface{}{float64(1), float64(2), float64(3)}},
+		{name: "integer key", expression: "jsonpath:1", want: "integer key"},
+		{name: "byte string is base64", expression: "jsonpath:raw", want: "aGk="},
+		{name: "unknown tag unwrapped", expression: "jsonpath:tagged", want: "inner"},
+		{name: "non-finite float", expression: "jsonpath:nan", want: "NaN"},
+		{name: "date/time tag", expression: "jsonpath:at", want: "2024-01-02T03:04:05Z"},
+		{name: "missing path", expression: "jsonpath:nope", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext(doc, "application/cbor", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCBORPayloadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "empty", content: nil},
+		{name: "truncated", content: []byte{0x82, 0x01}},
+		{name: "trailing data", content: []byte{0x01, 0x02}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCBORPayload(tt.content); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// xmlDeclarationEncoding matches the encoding pseudo-attribute of an XML
+// declaration, which must be rewritten once the content is UTF-8.
+var xmlDeclarationEncoding = regexp.MustCompile(`^(<\?xml[^>]*?\sencoding\s*=\s*)(?:"[^"]*"|'[^']*')`)
+
+// decodeCharset transcodes raw to UTF-8 according to the charset parameter of
+// contentType, e.g. "application/xml; charset=ISO-8859-1". Charset names are
+// IANA names or aliases (ISO-8859-1, windows-1252, UTF-16, UTF-16LE,
+// Shift_JIS, EUC-JP, GBK, ...). raw is returned unchanged when there is no
+// charset parameter or it names UTF-8 or US-ASCII. A leading byte order mark
+// is dropped, and the encoding declared by an XML declaration is replaced
+// with UTF-8 so the XML parser does not decode the content a second time.
+func decodeCharset(raw []byte, contentType string) ([]byte, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["charset"] == "" {
+		return raw, nil // Payload types that need parameters report invalid content types themselves
+	}
+	name := strings.ToLower(strings.TrimSpace(params["charset"]))
+	if name == "utf-8" || name == "utf8" || name == "us-ascii" {
+		return raw, nil
+	}
+
+	encoding, err := ianaindex.IANA.Encoding(name)
+	if err != nil || encoding == nil {
+		return nil, fmt.Errorf("unsupported charset %q in content type %s", params["charset"], contentType)
+	}
+	decoded, err := encoding.NewDecoder().Bytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s content: %w", params["charset"], err)
+	}
+	decoded = bytes.TrimPrefix(decoded, []byte("\ufeff"))
+	return xmlDeclarationEncoding.ReplaceAll(decoded, []byte(`${1}"UTF-8"`)), nil
+}
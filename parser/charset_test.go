@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encodeTestContent encodes UTF-8 content into enc.
+func encodeTestContent(t *testing.T, enc encoding.Encoding, content string) []byte {
+	t.Helper()
+	encoded, err := enc.NewEncoder().Bytes([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestCharsetDecoding(t *testing.T) {
+	tests := []struct {
+		name        string
+		encoding    encoding.Encoding
+		content     string
+		contentType string
+		expression  string
+		want        interface{}
+	}{
+		{name: "latin-1 json", encoding: charmap.ISO8859_1, content: `{"city":"Zürich"}`, contentType: "application/json; charset=ISO-8859-1", expression: "jsonpath:city", want: "Zürich"},
+		{name: "latin-1 alias", encoding: charmap.ISO8859_1, content: `{"city":"Malmö"}`, contentType: "application/json; charset=latin1", expression: "jsonpath:city", want: "Malmö"},
+		{name: "windows-1252 xml", encoding: charmap.Windows1252, content: "<p>price 5€</p>", contentType: "application/xml; charset=windows-1252", expression: "xpath:/p/text()", want: "price 5€"},
+		{name: "xml declaration rewritten", encoding: charmap.ISO8859_1, content: `<?xml version="1.0" encoding="ISO-8859-1"?><name>Renée</name>`, contentType: "text/xml; charset=iso-8859-1", expression: "xpath:/name/text()", want: "Renée"},
+		{name: "utf-16 with bom", encoding: unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), content: `{"name":"Łukasz"}`, contentType: "application/json; charset=UTF-16", expression: "jsonpath:name", want: "Łukasz"},
+		{name: "utf-16le without bom", encoding: unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), content: `{"name":"Ωmega"}`, contentType: "application/json; charset=utf-16le", expression: "jsonpath:name", want: "Ωmega"},
+		{name: "utf-16be xml", encoding: unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), content: `<?xml version='1.0' encoding='UTF-16'?><a>ß</a>`, contentType: "application/xml; charset=UTF-16BE", expression: "xpath:/a/text()", want: "ß"},
+		{name: "shift_jis csv", encoding: japanese.ShiftJIS, content: "name,city\n山田,東京\n", contentType: "text/csv; charset=Shift_JIS", expression: "csv:0.city", want: "東京"},
+		{name: "quoted charset", encoding: charmap.ISO8859_1, content: "café", contentType: `text/plain; charset="iso-8859-1"`, expression: "text:", want: "café"},
+		{name: "utf-8 unchanged", encoding: unicode.UTF8, content: `{"city":"Zürich"}`, contentType: "application/json; charset=utf-8", expression: "jsonpath:city", want: "Zürich"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := encodeTestContent(t, tt.encoding, tt.content)
+			result, err := NewMessageContext(raw, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestCharsetDecodingErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		wantErr     string
+	}{
+		{name: "unknown charset", contentType: "application/json; charset=klingon", wantErr: `unsupported charset "klingon"`},
+		{name: "charset without decoder", contentType: "application/json; charset=ISO-2022-CN", wantErr: `unsupported charset "ISO-2022-CN"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPayloadFactory().CreatePayload([]byte(`{}`), tt.contentType)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,548 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"golang.org/x/net/html"
+)
+
+// nodeSet holds the nodes an XPath query selected, in the order of the
+// texts in the result, so that each can be queried on its own.
+type nodeSet interface {
+	// payload returns node i as a document whose XPath context is the node,
+	// or nil if the node is not an element.
+	payload(i int) (PayloadObject, error)
+	// subset returns the nodes at indices, in that order.
+	subset(indices []int) nodeSet
+}
+
+// xmlNodeSet is a node-set of an XML document; attributes are nil.
+type xmlNodeSet struct {
+	nodes []*xmlquery.Node
+}
+
+func (s *xmlNodeSet) payload(i int) (PayloadObject, error) {
+	node := s.nodes[i]
+	if node == nil || node.Type != xmlquery.ElementNode {
+		return nil, nil
+	}
+	return &XMLPayload{rawContent: []byte(node.OutputXML(true)), parsedDoc: node, contentType: "application/xml"}, nil
+}
+
+func (s *xmlNodeSet) subset(indices []int) nodeSet {
+	nodes := make([]*xmlquery.Node, len(indices))
+	for i, index := range indices {
+		nodes[i] = s.nodes[index]
+	}
+	return &xmlNodeSet{nodes}
+}
+
+// selectedXMLElements returns the nodes of result if it is a node-set of an
+// XML document that has only elements or documents.
+func selectedXMLElements(result QueryResult) ([]*xmlquery.Node, bool) {
+	selected, ok := result.nodes.(*xmlNodeSet)
+	if !ok || len(selected.nodes) == 0 {
+		return nil, false
+	}
+	for _, node := range selected.nodes {
+		if node == nil || node.Type != xmlquery.ElementNode && node.Type != xmlquery.DocumentNode {
+			return nil, false
+		}
+	}
+	return selected.nodes, true
+}
+
+// htmlNodeSet is a node-set of an HTML document; attributes are nil.
+type htmlNodeSet struct {
+	nodes []*html.Node
+}
+
+func (s *htmlNodeSet) payload(i int) (PayloadObject, error) {
+	node := s.nodes[i]
+	if node == nil || node.Type != html.ElementNode {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := html.Render(&buf, node); err != nil {
+		return nil, err
+	}
+	return &HTMLPayload{rawContent: buf.Bytes(), parsedDoc: node, contentType: "text/html"}, nil
+}
+
+func (s *htmlNodeSet) subset(indices []int) nodeSet {
+	nodes := make([]*html.Node, len(indices))
+	for i, index := range indices {
+		nodes[i] = s.nodes[index]
+	}
+	return &htmlNodeSet{nodes}
+}
+
+// isCollectionPipe reports whether name is a pipe that evaluates a
+// sub-expression against each element of an array or node-set.
+func isCollectionPipe(name string) bool {
+	return name == mapPipe || name == filterPipe || name == sortByPipe
+}
+
+// collectionPipe evaluates map, filter or sortBy on result, evaluating the
+// parsed sub-expression of call against each element.
+func (ee *ExpressionEngine) collectionPipe(ctx context.Context, call pipeCall, result QueryResult) (QueryResult, error) {
+	switch call.name {
+	case filterPipe:
+		return ee.filterElements(ctx, call, result)
+	case sortByPipe:
+		return ee.sortElements(ctx, call, result)
+	}
+	return ee.mapElements(ctx, call, result)
+}
+
+// collectionValues returns the elements of an array or node-set result: the
+// values of an array, or the texts of the nodes.
+func collectionValues(result QueryResult) ([]interface{}, error) {
+	switch value := result.Value.(type) {
+	case []interface{}:
+		return value, nil
+	case []string:
+		elements := make([]interface{}, len(value))
+		for i, text := range value {
+			elements[i] = text
+		}
+		return elements, nil
+	case nil:
+		if result.Type == NodeSetResult {
+			return []interface{}{}, nil
+		}
+		return nil, fmt.Errorf("requires an array or node-set, got no value")
+	case string:
+		if result.nodes != nil {
+			return []interface{}{value}, nil // A node-set of one node
+		}
+	}
+	return nil, fmt.Errorf("requires an array or node-set, got %s", result.Type)
+}
+
+// collectionElements returns the elements of an array or node-set result
+// and, for each, the payload sub-expressions are evaluated against: XML and
+// HTML elements are queried with the element as the XPath context node, and
+// anything else (array elements, attributes and text nodes) as JSON, whose
+// root is @this.
+func (ee *ExpressionEngine) collectionElements(result QueryResult) ([]interface{}, []PayloadObject, error) {
+	elements, err := collectionValues(result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payloads := make([]PayloadObject, len(elements))
+	for i, element := range elements {
+		var err error
+		if result.nodes != nil {
+			payloads[i], err = result.nodes.payload(i)
+		}
+		if err == nil && payloads[i] == nil {
+			var raw []byte
+			if raw, err = marshalJSONView(element); err == nil {
+				payloads[i], err = ee.parsePayload(ee.payloadFactory, raw, "application/json")
+			}
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+	return elements, payloads, nil
+}
+
+// mapElements evaluates the sub-expression of a map pipe call against each
+// element of result and returns the results as an array.
+func (ee *ExpressionEngine) mapElements(ctx context.Context, call pipeCall, result QueryResult) (QueryResult, error) {
+	_, payloads, err := ee.collectionElements(result)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	maxElements := maxResultElements(ctx)
+	values := make([]interface{}, 0, len(payloads))
+	for i, payload := range payloads {
+		if err := checkResultElements(maxElements, i+1, ""); err != nil {
+			return QueryResult{}, err
+		}
+		mapped, err := ee.runPipeline(ctx, payload, call.sub)
+		if err != nil {
+			return QueryResult{}, fmt.Errorf("element %d: %w", i, err)
+		}
+		values = append(values, mapped.Value)
+	}
+	return QueryResult{Value: values, Type: ArrayResult}, nil
+}
+
+// predicate is the argument of a filter pipe: a sub-expression, optionally
+// compared with a literal, e.g. "jsonpath:price > 10".
+type predicate struct {
+	expression string
+	operator   string      // "" to test the result itself
+	literal    interface{} // string, float64 or bool
+}
+
+// comparisonOperators are the operators of predicates, the longer first.
+var comparisonOperators = []string{"==", "!=", ">=", "<=", "=", ">", "<"}
+
+// parsePredicate splits s into a sub-expression and a comparison with a
+// literal at its end. Operators inside quotes or brackets belong to the
+// sub-expression, and so does a comparison that is not followed by a single
+// literal, such as "xpath:price > 10 and @id".
+func parsePredicate(s string) predicate {
+	s = strings.TrimSpace(s)
+	var quote byte
+	depth := 0
+	at, operator := -1, ""
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0:
+			for _, op := range comparisonOperators {
+				if strings.HasPrefix(s[i:], op) {
+					at, operator = i, op
+					i += len(op) - 1
+					break
+				}
+			}
+		}
+	}
+	if at < 0 {
+		return predicate{expression: s}
+	}
+	literal, rest, err := parsePipeArg(strings.TrimSpace(s[at+len(operator):]))
+	expression := strings.TrimSpace(s[:at])
+	if err != nil || strings.TrimSpace(rest) != "" || expression == "" {
+		return predicate{expression: s}
+	}
+	if operator == "=" {
+		operator = "=="
+	}
+	return predicate{expression: expression, operator: operator, literal: literal}
+}
+
+// matches reports whether the result of the predicate's sub-expression
+// satisfies it. Without a comparison, any value other than a missing or
+// empty one and false matches. A number literal compares numerically, a
+// string literal as text, and a boolean only with == and !=; results that
+// cannot be converted do not match.
+func (p predicate) matches(result QueryResult) bool {
+	if p.operator == "" {
+		return !isAbsent(result) && result.Value != false
+	}
+	var cmp int
+	switch literal := p.literal.(type) {
+	case float64:
+		n, err := toNumber(result)
+		if err != nil {
+			return false
+		}
+		cmp = compareOrdered(n, literal)
+	case string:
+		s, err := toText(result)
+		if err != nil {
+			return false
+		}
+		cmp = strings.Compare(s, literal)
+	case bool:
+		b, err := toBoolean(result)
+		if err != nil {
+			return false
+		}
+		if b != literal {
+			cmp = 1
+		}
+		if p.operator != "==" && p.operator != "!=" {
+			return false
+		}
+	}
+	switch p.operator {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	}
+	return cmp <= 0
+}
+
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// filterElements keeps the elements of result that match the predicate of
+// a filter pipe call. An element whose sub-expression fails, e.g. on a
+// missing field, does not match. Arrays stay arrays, and node-sets stay
+// node-sets, so that later collection pipes still see the nodes.
+func (ee *ExpressionEngine) filterElements(ctx context.Context, call pipeCall, result QueryResult) (QueryResult, error) {
+	pred := parsePredicate(call.args[0].(string))
+	elements, payloads, err := ee.collectionElements(result)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	var kept []int
+	for i, payload := range payloads {
+		tested, err := ee.runPipeline(ctx, payload, call.sub)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return QueryResult{}, ctxErr
+			}
+			continue
+		}
+		if pred.matches(tested) {
+			kept = append(kept, i)
+		}
+	}
+	return collectionResult(result, elements, kept), nil
+}
+
+// sortDescending returns whether a sortBy pipe call sorts in descending
+// order: its second argument is asc (the default) or desc.
+func sortDescending(call pipeCall) (bool, error) {
+	if len(call.args) < 2 {
+		return false, nil
+	}
+	switch order, _ := call.args[1].(string); strings.ToLower(order) {
+	case "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+	return false, fmt.Errorf("argument 2 of '%s' must be asc or desc, got %v", call.name, call.args[1])
+}
+
+// sortElements orders the elements of result by the key a sortBy pipe
+// call's sub-expression yields for each. Keys compare as numbers when every
+// key converts to one (so XML text such as "9.5" sorts numerically), and as
+// text otherwise. Elements without a key, because the sub-expression fails
+// or yields nothing, come last in either order; the sort is stable.
+func (ee *ExpressionEngine) sortElements(ctx context.Context, call pipeCall, result QueryResult) (QueryResult, error) {
+	descending, err := sortDescending(call)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	elements, payloads, err := ee.collectionElements(result)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	keys := make([]QueryResult, len(payloads))
+	present := make([]bool, len(payloads))
+	numbers := make([]float64, len(payloads))
+	texts := make([]string, len(payloads))
+	numeric := true
+	for i, payload := range payloads {
+		key, err := ee.runPipeline(ctx, payload, call.sub)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return QueryResult{}, ctxErr
+			}
+			continue
+		}
+		if isAbsent(key) {
+			continue
+		}
+		keys[i], present[i] = key, true
+		if n, err := toNumber(key); err == nil {
+			numbers[i] = n
+		} else {
+			numeric = false
+		}
+	}
+	if !numeric {
+		for i, key := range keys {
+			if present[i] {
+				if texts[i], err = toText(key); err != nil {
+					return QueryResult{}, fmt.Errorf("element %d: %w", i, err)
+				}
+			}
+		}
+	}
+
+	order := make([]int, len(elements))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if !present[i] || !present[j] {
+			return present[i] && !present[j]
+		}
+		var cmp int
+		if numeric {
+			cmp = compareOrdered(numbers[i], numbers[j])
+		} else {
+			cmp = strings.Compare(texts[i], texts[j])
+		}
+		if descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	return collectionResult(result, elements, order), nil
+}
+
+// collectionResult returns the elements at indices of a result in its
+// shape: an array, or a node-set like XPath returns it.
+func collectionResult(result QueryResult, elements []interface{}, indices []int) QueryResult {
+	if result.nodes == nil {
+		values := make([]interface{}, len(indices))
+		for i, index := range indices {
+			values[i] = elements[index]
+		}
+		return QueryResult{Value: values, Type: ArrayResult}
+	}
+	texts := make([]string, len(indices))
+	for i, index := range indices {
+		texts[i] = elements[index].(string)
+	}
+	nodes := result.nodes.subset(indices)
+	switch len(texts) {
+	case 0:
+		return QueryResult{Value: nil, Type: NodeSetResult}
+	case 1:
+		return QueryResult{Value: texts[0], Type: StringResult, nodes: nodes}
+	}
+	return QueryResult{Value: texts, Type: NodeSetResult, nodes: nodes}
+}
+
+// flatten returns the elements of an array or node-set result with nested
+// arrays replaced by their elements, down to depth levels (all levels when
+// depth is negative). It stops once there are more than maxElements
+// elements, unless maxElements is 0.
+func flatten(result QueryResult, depth, maxElements int) (QueryResult, error) {
+	elements, err := collectionValues(result)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	flat, err := flattenValues(make([]interface{}, 0, len(elements)), elements, depth, maxElements)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	return QueryResult{Value: flat, Type: ArrayResult}, nil
+}
+
+func flattenValues(flat, elements []interface{}, depth, maxElements int) ([]interface{}, error) {
+	for _, element := range elements {
+		var err error
+		if nested, ok := element.([]interface{}); ok && depth != 0 {
+			flat, err = flattenValues(flat, nested, depth-1, maxElements)
+		} else {
+			flat = append(flat, element)
+			err = checkResultElements(maxElements, len(flat), "")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return flat, nil
+}
+
+// unique returns the elements of an array or node-set result without
+// repeated values, keeping the first of each. Elements are equal when their
+// JSON encodings are, so 1 and "1" differ and objects compare by content.
+func unique(result QueryResult) (QueryResult, error) {
+	elements, err := collectionValues(result)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	seen := make(map[string]bool, len(elements))
+	kept := make([]int, 0, len(elements))
+	for i, element := range elements {
+		raw, err := marshalJSONView(element)
+		if err != nil {
+			return QueryResult{}, fmt.Errorf("element %d: %w", i, err)
+		}
+		if !seen[string(raw)] {
+			seen[string(raw)] = true
+			kept = append(kept, i)
+		}
+	}
+	return collectionResult(result, elements, kept), nil
+}
+
+// sliceCollection returns the elements of an array or node-set result from
+// zero-based start to end (exclusive), clamped to the number of elements.
+// A negative end is the number of elements.
+func sliceCollection(result QueryResult, start, end int) (QueryResult, error) {
+	elements, err := collectionValues(result)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	if end < 0 || end > len(elements) {
+		end = len(elements)
+	}
+	start = min(start, end)
+	indices := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		indices = append(indices, i)
+	}
+	return collectionResult(result, elements, indices), nil
+}
+
+// pickElement returns the first or, if last is set, the last element of an
+// array or node-set result. A node stays a node, so later collection pipes
+// can still query it. An empty collection is an error, which a following
+// default pipe replaces.
+func pickElement(result QueryResult, last bool) (QueryResult, error) {
+	elements, err := collectionValues(result)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	if len(elements) == 0 {
+		return QueryResult{}, fmt.Errorf("no elements: %w", ErrNoMatch)
+	}
+	index := 0
+	if last {
+		index = len(elements) - 1
+	}
+	if result.nodes != nil {
+		return collectionResult(result, elements, []int{index}), nil
+	}
+	return elementResult(elements[index]), nil
+}
+
+// elementResult returns an array element as a result of its own type, with
+// null as a scalar like jsonpath returns it.
+func elementResult(element interface{}) QueryResult {
+	switch element.(type) {
+	case string:
+		return QueryResult{Value: element, Type: StringResult}
+	case float64:
+		return QueryResult{Value: element, Type: NumberResult}
+	case bool:
+		return QueryResult{Value: element, Type: BooleanResult}
+	case []interface{}:
+		return QueryResult{Value: element, Type: ArrayResult}
+	case map[string]interface{}:
+		return QueryResult{Value: element, Type: ObjectResult}
+	case []byte:
+		return QueryResult{Value: element, Type: BytesResult}
+	case nil:
+		return QueryResult{Value: nil, Type: ScalarResult}
+	}
+	return QueryResult{Value: element, Type: UnknownResult}
+}
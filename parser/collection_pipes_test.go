@@ -0,0 +1,354 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMapPipe(t *testing.T) {
+	jsonBody := []byte(`{"store":{"book":[{"title":"Dune","price":9.5,"tags":["sf"]},{"title":"Emma","price":12,"tags":[]}]},"ids":["a1","b2"],"name":"shop","empty":[]}`)
+	xmlBody := []byte(`<store><book id="1"><title>Dune</title><price>9.5</price></book><book id="2"><title>Emma</title><price>12</price></book></store>`)
+	htmlBody := []byte(`<ul><li><a href="/a">A</a></li><li><a href="/b">B</a></li></ul>`)
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     string
+	}{
+		{name: "field of objects", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | map(jsonpath:title)", want: []interface{}{"Dune", "Emma"}},
+		{name: "numbers", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | map(jsonpath:price)", want: []interface{}{9.5, float64(12)}},
+		{name: "nested arrays", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | map(jsonpath:tags.#)", want: []interface{}{float64(1), float64(0)}},
+		{name: "element itself", body: jsonBody, contentType: "application/json", expression: "jsonpath:ids | map(jsonpath:@this)", want: []interface{}{"a1", "b2"}},
+		{name: "chained maps", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | map(jsonpath:title) | map(jsonpath:@this)", want: []interface{}{"Dune", "Emma"}},
+		{name: "empty array", body: jsonBody, contentType: "application/json", expression: "jsonpath:empty | map(jsonpath:title)", want: []interface{}{}},
+		{name: "xml elements", body: xmlBody, contentType: "application/xml", expression: "xpath://book | map(xpath:title)", want: []interface{}{"Dune", "Emma"}},
+		{name: "xml attributes of elements", body: xmlBody, contentType: "application/xml", expression: "xpath://book | map(xpath:string(@id))", want: []interface{}{"1", "2"}},
+		{name: "xml single node", body: xmlBody, contentType: "application/xml", expression: "xpath://book[1] | map(xpath:number(price) * 2)", want: []interface{}{float64(19)}},
+		{name: "xml text nodes as JSON", body: xmlBody, contentType: "application/xml", expression: "xpath://title/text() | map(jsonpath:@this)", want: []interface{}{"Dune", "Emma"}},
+		{name: "xml attributes as JSON", body: xmlBody, contentType: "application/xml", expression: "xpath://book/@id | map(jsonpath:@this)", want: []interface{}{"1", "2"}},
+		{name: "xml no nodes", body: xmlBody, contentType: "application/xml", expression: "xpath://magazine | map(xpath:title)", want: []interface{}{}},
+		{name: "html elements", body: htmlBody, contentType: "text/html", expression: "css:li | map(xpath:string(a/@href))", want: []interface{}{"/a", "/b"}},
+		{name: "html attributes as JSON", body: htmlBody, contentType: "text/html", expression: "xpath://a/@href | map(jsonpath:@this)", want: []interface{}{"/a", "/b"}},
+		{name: "missing field", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | map(jsonpath:author)", wantErr: "element 0"},
+		{name: "string input", body: jsonBody, contentType: "application/json", expression: "jsonpath:name | map(jsonpath:@this)", wantErr: "requires an array or node-set"},
+		{name: "no argument", body: jsonBody, contentType: "application/json", expression: "jsonpath:ids | map()", wantErr: "takes 1 argument(s), got 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(tt.body, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != ArrayResult {
+				t.Errorf("got %#v (%s), want %#v", result.Value, result.Type, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterPipe(t *testing.T) {
+	jsonBody := []byte(`{"store":{"book":[{"title":"Dune","price":9.5,"inStock":true,"genre":"sf"},{"title":"Emma","price":12,"inStock":false,"genre":"classic"},{"title":"Solaris","price":"15","inStock":true}]},"nums":[3,14,7],"name":"shop"}`)
+	xmlBody := []byte(`<store><book id="1"><title>Dune</title><price>9.5</price></book><book id="2"><title>Emma</title><price>12</price></book><book id="3" used="yes"><title>Solaris</title><price>15</price></book></store>`)
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		expression  string
+		want        interface{}
+		wantType    ResultType
+		wantErr     string
+	}{
+		{name: "number comparison", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | filter(jsonpath:price > 10) | map(jsonpath:title)", want: []interface{}{"Emma", "Solaris"}, wantType: ArrayResult},
+		{name: "less or equal", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | filter(jsonpath:price <= 12) | map(jsonpath:title)", want: []interface{}{"Dune", "Emma"}, wantType: ArrayResult},
+		{name: "string equality", body: jsonBody, contentType: "application/json", expression: `jsonpath:store.book | filter(jsonpath:genre == "sf") | map(jsonpath:title)`, want: []interface{}{"Dune"}, wantType: ArrayResult},
+		{name: "not equal skips missing", body: jsonBody, contentType: "application/json", expression: `jsonpath:store.book | filter(jsonpath:genre != "sf") | map(jsonpath:title)`, want: []interface{}{"Emma"}, wantType: ArrayResult},
+		{name: "boolean equality", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | filter(jsonpath:inStock == false) | map(jsonpath:title)", want: []interface{}{"Emma"}, wantType: ArrayResult},
+		{name: "truthiness", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | filter(jsonpath:inStock) | map(jsonpath:title)", want: []interface{}{"Dune", "Solaris"}, wantType: ArrayResult},
+		{name: "presence", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | filter(jsonpath:genre) | map(jsonpath:title)", want: []interface{}{"Dune", "Emma"}, wantType: ArrayResult},
+		{name: "scalars", body: jsonBody, contentType: "application/json", expression: "jsonpath:nums | filter(jsonpath:@this >= 7)", want: []interface{}{float64(14), float64(7)}, wantType: ArrayResult},
+		{name: "nothing matches", body: jsonBody, contentType: "application/json", expression: "jsonpath:nums | filter(jsonpath:@this > 100)", want: []interface{}{}, wantType: ArrayResult},
+		{name: "gjson query is not split", body: jsonBody, contentType: "application/json", expression: `jsonpath:store | filter(jsonpath:#(price>10)) | map(jsonpath:@this)`, wantErr: "requires an array or node-set"},
+		{name: "xml comparison", body: xmlBody, contentType: "application/xml", expression: "xpath://book | filter(xpath:price > 10) | map(xpath:title)", want: []interface{}{"Emma", "Solaris"}, wantType: ArrayResult},
+		{name: "xml attribute equality", body: xmlBody, contentType: "application/xml", expression: "xpath://book | filter(xpath:@id = '2') | map(xpath:title)", want: []interface{}{"Emma"}, wantType: ArrayResult},
+		{name: "xpath boolean", body: xmlBody, contentType: "application/xml", expression: "xpath://book | filter(xpath:price > 10 and @used) | map(xpath:title)", want: []interface{}{"Solaris"}, wantType: ArrayResult},
+		{name: "node-set result", body: xmlBody, contentType: "application/xml", expression: "xpath://book | filter(xpath:price < 13)", want: []string{"Dune9.5", "Emma12"}, wantType: NodeSetResult},
+		{name: "single node", body: xmlBody, contentType: "application/xml", expression: "xpath://book | filter(xpath:@id = '1')", want: "Dune9.5", wantType: StringResult},
+		{name: "no nodes", body: xmlBody, contentType: "application/xml", expression: "xpath://book | filter(xpath:price > 100)", want: nil, wantType: NodeSetResult},
+		{name: "string input", body: jsonBody, contentType: "application/json", expression: "jsonpath:name | filter(jsonpath:@this)", wantErr: "requires an array or node-set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(tt.body, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestParsePredicate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want predicate
+	}{
+		{in: "jsonpath:price > 10", want: predicate{expression: "jsonpath:price", operator: ">", literal: float64(10)}},
+		{in: `jsonpath:genre=="sf"`, want: predicate{expression: "jsonpath:genre", operator: "==", literal: "sf"}},
+		{in: "xpath:@id = '2'", want: predicate{expression: "xpath:@id", operator: "==", literal: "2"}},
+		{in: "jsonpath:inStock != true", want: predicate{expression: "jsonpath:inStock", operator: "!=", literal: true}},
+		{in: "jsonpath:inStock", want: predicate{expression: "jsonpath:inStock"}},
+		{in: "jsonpath:items.#(qty>1)#", want: predicate{expression: "jsonpath:items.#(qty>1)#"}},
+		{in: "xpath:price > 10 and @used", want: predicate{expression: "xpath:price > 10 and @used"}},
+		{in: `xpath:title = "a > b"`, want: predicate{expression: "xpath:title", operator: "==", literal: "a > b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := parsePredicate(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByPipe(t *testing.T) {
+	jsonBody := []byte(`{"store":{"book":[{"title":"Emma","price":12,"date":"2024-03-01"},{"title":"Dune","price":9.5,"date":"2023-11-20"},{"title":"Solaris","price":15},{"title":"Ubik","price":9.5,"date":"2024-01-15"}]},"nums":[3,14,7],"words":["pear","Apple","fig"],"name":"shop"}`)
+	xmlBody := []byte(`<store><book id="1"><title>Dune</title><price>9.5</price></book><book id="2"><title>Emma</title><price>12</price></book><book id="3"><title>Solaris</title><price>100</price></book></store>`)
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		expression  string
+		want        interface{}
+		wantType    ResultType
+		wantErr     string
+	}{
+		{name: "ascending numbers, stable", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | sortBy(jsonpath:price) | map(jsonpath:title)", want: []interface{}{"Dune", "Ubik", "Emma", "Solaris"}, wantType: ArrayResult},
+		{name: "descending", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | sortBy(jsonpath:price, desc) | map(jsonpath:title)", want: []interface{}{"Solaris", "Emma", "Dune", "Ubik"}, wantType: ArrayResult},
+		{name: "quoted order", body: jsonBody, contentType: "application/json", expression: `jsonpath:store.book | sortBy(jsonpath:price, "desc") | map(jsonpath:title)`, want: []interface{}{"Solaris", "Emma", "Dune", "Ubik"}, wantType: ArrayResult},
+		{name: "missing keys last", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | sortBy(jsonpath:date, desc) | map(jsonpath:title)", want: []interface{}{"Emma", "Ubik", "Dune", "Solaris"}, wantType: ArrayResult},
+		{name: "scalars", body: jsonBody, contentType: "application/json", expression: "jsonpath:nums | sortBy(jsonpath:@this)", want: []interface{}{float64(3), float64(7), float64(14)}, wantType: ArrayResult},
+		{name: "text", body: jsonBody, contentType: "application/json", expression: "jsonpath:words | sortBy(jsonpath:@this, asc)", want: []interface{}{"Apple", "fig", "pear"}, wantType: ArrayResult},
+		{name: "top-N with filter", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | filter(jsonpath:price < 13) | sortBy(jsonpath:price, desc) | map(jsonpath:title)", want: []interface{}{"Emma", "Dune", "Ubik"}, wantType: ArrayResult},
+		{name: "xml text sorts numerically", body: xmlBody, contentType: "application/xml", expression: "xpath://book | sortBy(xpath:price, desc) | map(xpath:title)", want: []interface{}{"Solaris", "Emma", "Dune"}, wantType: ArrayResult},
+		{name: "node-set result", body: xmlBody, contentType: "application/xml", expression: "xpath://book/title | sortBy(xpath:., desc)", want: []string{"Solaris", "Emma", "Dune"}, wantType: NodeSetResult},
+		{name: "xpath with commas", body: xmlBody, contentType: "application/xml", expression: "xpath://book | sortBy(xpath:concat(title, '-', @id), desc) | map(xpath:string(@id))", want: []interface{}{"3", "2", "1"}, wantType: ArrayResult},
+		{name: "invalid order", body: jsonBody, contentType: "application/json", expression: "jsonpath:nums | sortBy(jsonpath:@this, down)", wantErr: "must be asc or desc"},
+		{name: "string input", body: jsonBody, contentType: "application/json", expression: "jsonpath:name | sortBy(jsonpath:@this)", wantErr: "requires an array or node-set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(tt.body, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestFlattenPipe(t *testing.T) {
+	jsonBody := []byte(`{"store":{"book":[{"tags":["sf","classic"]},{"tags":[]},{"tags":["drama"]}]},"deep":[1,[2,[3,[4]]]],"name":"shop"}`)
+	xmlBody := []byte(`<store><book>Dune</book><book>Emma</book></store>`)
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     string
+	}{
+		{name: "tags of books", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book.#.tags | flatten", want: []interface{}{"sf", "classic", "drama"}},
+		{name: "before join", body: jsonBody, contentType: "application/json", expression: `jsonpath:store.book.#.tags | flatten | join(",")`, want: "sf,classic,drama"},
+		{name: "all levels", body: jsonBody, contentType: "application/json", expression: "jsonpath:deep | flatten", want: []interface{}{float64(1), float64(2), float64(3), float64(4)}},
+		{name: "one level", body: jsonBody, contentType: "application/json", expression: "jsonpath:deep | flatten(1)", want: []interface{}{float64(1), float64(2), []interface{}{float64(3), []interface{}{float64(4)}}}},
+		{name: "depth zero", body: jsonBody, contentType: "application/json", expression: "jsonpath:deep | flatten(0)", want: []interface{}{float64(1), []interface{}{float64(2), []interface{}{float64(3), []interface{}{float64(4)}}}}},
+		{name: "after map", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | map(jsonpath:tags) | flatten", want: []interface{}{"sf", "classic", "drama"}},
+		{name: "node-set", body: xmlBody, contentType: "application/xml", expression: "xpath://book | flatten", want: []interface{}{"Dune", "Emma"}},
+		{name: "string input", body: jsonBody, contentType: "application/json", expression: "jsonpath:name | flatten", wantErr: "requires an array or node-set"},
+		{name: "negative depth", body: jsonBody, contentType: "application/json", expression: "jsonpath:deep | flatten(-1)", wantErr: "must be a non-negative integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(tt.body, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v (%s), want %#v", result.Value, result.Type, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniquePipe(t *testing.T) {
+	jsonBody := []byte(`{"order":{"lines":[{"customerId":"c1"},{"customerId":"c2"},{"customerId":"c1"},{"customerId":"c3"},{"customerId":"c2"}]},"mixed":[1,"1",1,{"a":1,"b":2},{"b":2,"a":1},null,null],"name":"shop"}`)
+	xmlBody := []byte(`<order><line><customer id="c1"/></line><line><customer id="c2"/></line><line><customer id="c1"/></line></order>`)
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		expression  string
+		want        interface{}
+		wantType    ResultType
+		wantErr     string
+	}{
+		{name: "first of each kept", body: jsonBody, contentType: "application/json", expression: "jsonpath:order.lines.#.customerId | unique", want: []interface{}{"c1", "c2", "c3"}, wantType: ArrayResult},
+		{name: "dedupe", body: jsonBody, contentType: "application/json", expression: "jsonpath:order.lines.#.customerId | dedupe", want: []interface{}{"c1", "c2", "c3"}, wantType: ArrayResult},
+		{name: "types and objects", body: jsonBody, contentType: "application/json", expression: "jsonpath:mixed | unique", want: []interface{}{float64(1), "1", map[string]interface{}{"a": float64(1), "b": float64(2)}, nil}, wantType: ArrayResult},
+		{name: "node-set", body: xmlBody, contentType: "application/xml", expression: "xpath://customer/@id | unique", want: []string{"c1", "c2"}, wantType: NodeSetResult},
+		{name: "then toString", body: jsonBody, contentType: "application/json", expression: "jsonpath:order.lines.#.customerId | unique | toString", want: `["c1","c2","c3"]`, wantType: StringResult},
+		{name: "string input", body: jsonBody, contentType: "application/json", expression: "jsonpath:name | unique", wantErr: "requires an array or node-set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(tt.body, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestSlicePipes(t *testing.T) {
+	jsonBody := []byte(`{"results":[0,1,2,3,4,5,6,7,8,9],"name":"shop"}`)
+	xmlBody := []byte(`<store><book>Dune</book><book>Emma</book><book>Solaris</book></store>`)
+	numbers := func(ns ...float64) []interface{} {
+		values := make([]interface{}, len(ns))
+		for i, n := range ns {
+			values[i] = n
+		}
+		return values
+	}
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		expression  string
+		want        interface{}
+		wantType    ResultType
+		wantErr     string
+	}{
+		{name: "slice", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | slice(2, 5)", want: numbers(2, 3, 4), wantType: ArrayResult},
+		{name: "slice to the end", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | slice(8)", want: numbers(8, 9), wantType: ArrayResult},
+		{name: "slice clamped", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | slice(8, 50)", want: numbers(8, 9), wantType: ArrayResult},
+		{name: "slice past the end", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | slice(20, 30)", want: numbers(), wantType: ArrayResult},
+		{name: "slice end before start", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | slice(5, 2)", want: numbers(), wantType: ArrayResult},
+		{name: "limit", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | limit(3)", want: numbers(0, 1, 2), wantType: ArrayResult},
+		{name: "limit zero", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | limit(0)", want: numbers(), wantType: ArrayResult},
+		{name: "offset then limit", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | offset(4) | limit(2)", want: numbers(4, 5), wantType: ArrayResult},
+		{name: "top-N", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | sortBy(jsonpath:@this, desc) | limit(2)", want: numbers(9, 8), wantType: ArrayResult},
+		{name: "node-set", body: xmlBody, contentType: "application/xml", expression: "xpath://book | offset(1)", want: []string{"Emma", "Solaris"}, wantType: NodeSetResult},
+		{name: "node-set keeps nodes", body: xmlBody, contentType: "application/xml", expression: "xpath://book | limit(1) | map(xpath:name())", want: []interface{}{"book"}, wantType: ArrayResult},
+		{name: "negative", body: jsonBody, contentType: "application/json", expression: "jsonpath:results | limit(-1)", wantErr: "must be a non-negative integer"},
+		{name: "not a number", body: jsonBody, contentType: "application/json", expression: `jsonpath:results | slice("1")`, wantErr: "must be a non-negative integer"},
+		{name: "string input", body: jsonBody, contentType: "application/json", expression: "jsonpath:name | limit(1)", wantErr: "requires an array or node-set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(tt.body, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestFirstAndLastPipes(t *testing.T) {
+	jsonBody := []byte(`{"store":{"book":[{"title":"Dune","price":9.5},{"title":"Emma","price":12},{"title":"Ubik","price":8}]},"nums":[3,14,7],"nested":[[1,2],[3]],"nulls":[null],"empty":[],"name":"shop"}`)
+	xmlBody := []byte(`<store><book id="1"><title>Dune</title></book><book id="2"><title>Emma</title></book></store>`)
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		expression  string
+		want        interface{}
+		wantType    ResultType
+		wantErr     string
+	}{
+		{name: "first number", body: jsonBody, contentType: "application/json", expression: "jsonpath:nums | first", want: float64(3), wantType: NumberResult},
+		{name: "last number", body: jsonBody, contentType: "application/json", expression: "jsonpath:nums | last", want: float64(7), wantType: NumberResult},
+		{name: "object then query", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | filter(jsonpath:price < 10) | last | extractAsJSON | jsonpath:title", want: "Ubik", wantType: StringResult},
+		{name: "array element", body: jsonBody, contentType: "application/json", expression: "jsonpath:nested | first", want: []interface{}{float64(1), float64(2)}, wantType: ArrayResult},
+		{name: "null element", body: jsonBody, contentType: "application/json", expression: "jsonpath:nulls | first", want: nil, wantType: ScalarResult},
+		{name: "after sort", body: jsonBody, contentType: "application/json", expression: "jsonpath:store.book | sortBy(jsonpath:price) | first | toString", want: `{"price":8,"title":"Ubik"}`, wantType: StringResult},
+		{name: "node", body: xmlBody, contentType: "application/xml", expression: "xpath://book | last", want: "Emma", wantType: StringResult},
+		{name: "node stays a node", body: xmlBody, contentType: "application/xml", expression: "xpath://book | last | map(xpath:string(@id))", want: []interface{}{"2"}, wantType: ArrayResult},
+		{name: "empty fails", body: jsonBody, contentType: "application/json", expression: "jsonpath:empty | first", wantErr: "no elements"},
+		{name: "empty with default", body: jsonBody, contentType: "application/json", expression: `jsonpath:store.book | filter(jsonpath:price > 100) | first | default("none")`, want: "none", wantType: StringResult},
+		{name: "no nodes with default", body: xmlBody, contentType: "application/xml", expression: "xpath://magazine | last | default(0)", want: float64(0), wantType: NumberResult},
+		{name: "string input", body: jsonBody, contentType: "application/json", expression: "jsonpath:name | first", wantErr: "requires an array or node-set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(tt.body, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// computedScopeKey is the context key of the computedScope of an evaluation.
+type computedScopeKey struct{}
+
+// computedScope carries what "computed:" expressions need through the
+// pipeline: the document fields are evaluated against, the message context
+// whose fields and cache apply, and the fields being computed.
+type computedScope struct {
+	mc      *MessageContext // Nil when evaluating through the engine directly
+	payload PayloadObject   // The original payload, whatever stage is running
+	chain   []string        // Fields being computed, outermost first, to detect cycles
+}
+
+// withComputedScope makes payload the document of computed fields, unless ctx
+// already belongs to an evaluation (e.g. of another computed field).
+func withComputedScope(ctx context.Context, mc *MessageContext, payload PayloadObject) context.Context {
+	if _, ok := ctx.Value(computedScopeKey{}).(*computedScope); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, computedScopeKey{}, &computedScope{mc: mc, payload: payload})
+}
+
+// RegisterComputedField defines a virtual field that expressions on any
+// message evaluated by this engine can read as "computed:name". The
+// expression is evaluated against the original payload the first time a
+// message needs the field; message contexts cache the result. Fields may use
+// other computed fields.
+func (ee *ExpressionEngine) RegisterComputedField(name, expression string) error {
+	if err := validateComputedField(ee, name, expression); err != nil {
+		return err
+	}
+	ee.computedFields.Store(name, expression)
+	return nil
+}
+
+// RegisterComputedField defines a virtual field for this message only,
+// overriding an engine field of the same name (see
+// ExpressionEngine.RegisterComputedField). Cached field values are discarded.
+func (mc *MessageContext) RegisterComputedField(name, expression string) error {
+	if err := validateComputedField(mc.engine, name, expression); err != nil {
+		return err
+	}
+	mc.computedLock.Lock()
+	defer mc.computedLock.Unlock()
+	if mc.computedFields == nil {
+		mc.computedFields = make(map[string]string)
+	}
+	mc.computedFields[name] = expression
+	mc.computedCache = nil // Other fields may depend on this one
+	return nil
+}
+
+// validateComputedField rejects names that cannot be written in an expression
+// and expressions that can never be evaluated.
+func validateComputedField(ee *ExpressionEngine, name, expression string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n|") {
+		return fmt.Errorf("invalid computed field name '%s'", name)
+	}
+	for _, w := range ee.Lint(expression) {
+		if w.Severity == SeverityError {
+			return fmt.Errorf("invalid expression for computed field '%s': %s", name, w)
+		}
+	}
+	return nil
+}
+
+// computedField evaluates a "computed:" reference.
+func (ee *ExpressionEngine) computedField(ctx context.Context, pld PayloadObject, name string) (QueryResult, error) {
+	name = strings.TrimSpace(name)
+	scope, ok := ctx.Value(computedScopeKey{}).(*computedScope)
+	if !ok {
+		scope = &computedScope{payload: pld}
+	}
+	for _, outer := range scope.chain {
+		if outer == name {
+			return QueryResult{}, &ErrEvaluationFailed{
+				Expression: computedPrefix + name,
+				Reason:     fmt.Sprintf("computed field cycle: %s -> %s", strings.Join(scope.chain, " -> "), name),
+			}
+		}
+	}
+
+	expression, found := "", false
+	if scope.mc != nil {
+		if result, cached := scope.mc.cachedComputedField(name); cached {
+			return result, nil
+		}
+		expression, found = scope.mc.computedFieldExpression(name)
+	}
+	if !found {
+		if value, ok := ee.computedFields.Load(name); ok {
+			expression, found = value.(string), true
+		}
+	}
+	if !found {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: computedPrefix + name, Reason: fmt.Sprintf("unknown computed field '%s'", name)}
+	}
+
+	inner := &computedScope{mc: scope.mc, payload: scope.payload, chain: append(scope.chain[:len(scope.chain):len(scope.chain)], name)}
+	result, err := ee.evaluatePipeline(context.WithValue(ctx, computedScopeKey{}, inner), scope.payload, expression)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: computedPrefix + name, Reason: fmt.Sprintf("computing field '%s' failed", name), InnerError: err}
+	}
+	if scope.mc != nil {
+		scope.mc.cacheComputedField(name, result) // Failures are not cached: they may be timeouts
+	}
+	return result, nil
+}
+
+func (mc *MessageContext) computedFieldExpression(name string) (string, bool) {
+	mc.computedLock.Lock()
+	defer mc.computedLock.Unlock()
+	expression, ok := mc.computedFields[name]
+	return expression, ok
+}
+
+func (mc *MessageContext) cachedComputedField(name string) (QueryResult, bool) {
+	mc.computedLock.Lock()
+	defer mc.computedLock.Unlock()
+	result, ok := mc.computedCache[name]
+	return result, ok
+}
+
+func (mc *MessageContext) cacheComputedField(name string, result QueryResult) {
+	mc.computedLock.Lock()
+	defer mc.computedLock.Unlock()
+	if mc.computedCache == nil {
+		mc.computedCache = make(map[string]QueryResult)
+	}
+	mc.computedCache[name] = result
+}
@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const computedOrder = `{"order": {"id": "A-7", "items": [{"sku": "x", "price": 2.5, "qty": 2}, {"sku": "y", "price": 10, "qty": 1}], "note": "{\"gift\": true}"}}`
+
+func TestComputedFields(t *testing.T) {
+	engine := NewEngine()
+	for name, expression := range map[string]string{
+		"orderId":  "jsonpath:order.id",
+		"prices":   "jsonpath:order.items.#.price",
+		"gift":     "jsonpath:order.note | extractAsJSON | jsonpath:gift",
+		"id":       "computed:orderId",
+		"selfLoop": "computed:selfLoop",
+		"loopA":    "computed:loopB",
+		"loopB":    "computed:loopA",
+		"missing":  "jsonpath:order.total",
+	} {
+		if err := engine.RegisterComputedField(name, expression); err != nil {
+			t.Fatalf("RegisterComputedField(%s): %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		local      map[string]string // Fields registered on the message context
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "engine field", expression: "computed:orderId", want: "A-7"},
+		{name: "array field", expression: "computed:prices", want: []interface{}{2.5, float64(10)}},
+		{name: "piped field", expression: "computed:gift", want: true},
+		{name: "field using field", expression: "computed:id", want: "A-7"},
+		{name: "field piped further", expression: "computed:orderId | regex:-(\\d+)", want: "7"},
+		{name: "context field", expression: "computed:firstSku", local: map[string]string{"firstSku": "jsonpath:order.items.0.sku"}, want: "x"},
+		{name: "context overrides engine", expression: "computed:id", local: map[string]string{"orderId": "jsonpath:order.items.1.sku"}, want: "y"},
+		{name: "evaluated against original payload", expression: "jsonpath:order.note | extractAsJSON | computed:orderId", want: "A-7"},
+		{name: "unknown field", expression: "computed:nope", wantErr: "unknown computed field 'nope'"},
+		{name: "self cycle", expression: "computed:selfLoop", wantErr: "computed field cycle: selfLoop -> selfLoop"},
+		{name: "cycle", expression: "computed:loopA", wantErr: "computed field cycle: loopA -> loopB -> loopA"},
+		{name: "failing field", expression: "computed:missing", wantErr: "computing field 'missing' failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(computedOrder), "application/json", engine)
+			for name, expression := range tt.local {
+				if err := msgCtx.RegisterComputedField(name, expression); err != nil {
+					t.Fatalf("RegisterComputedField(%s): %v", name, err)
+				}
+			}
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v (value %v), want error containing %q", err, result.Value, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputedFieldsCachedPerContext(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterComputedField("orderId", "jsonpath:order.id"); err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext([]byte(computedOrder), "application/json", engine)
+	if _, err := msgCtx.EvaluateExpression("computed:orderId"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cached value survives a changed engine definition...
+	if err := engine.RegisterComputedField("orderId", "jsonpath:order.items.0.sku"); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := msgCtx.EvaluateExpression("computed:orderId"); result.Value != "A-7" {
+		t.Errorf("got %v, want cached A-7", result.Value)
+	}
+	// ...but not a definition on the context itself
+	if err := msgCtx.RegisterComputedField("orderId", "jsonpath:order.items.1.sku"); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := msgCtx.EvaluateExpression("computed:orderId"); result.Value != "y" {
+		t.Errorf("got %v, want y", result.Value)
+	}
+	// Other contexts compute their own value
+	other := NewMessageContext([]byte(computedOrder), "application/json", engine)
+	if result, _ := other.EvaluateExpression("computed:orderId"); result.Value != "x" {
+		t.Errorf("got %v, want x", result.Value)
+	}
+}
+
+func TestComputedFieldsWithEngineEvaluate(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterComputedField("orderId", "jsonpath:order.id"); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := NewJSONPayload([]byte(computedOrder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.Evaluate(payload, "computed:orderId")
+	if err != nil || result.Value != "A-7" {
+		t.Fatalf("got %v, %v", result.Value, err)
+	}
+}
+
+func TestRegisterComputedFieldInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		field      string
+		expression string
+	}{
+		{name: "empty name", field: "", expression: "jsonpath:a"},
+		{name: "name with pipe", field: "a|b", expression: "jsonpath:a"},
+		{name: "name with space", field: "a b", expression: "jsonpath:a"},
+		{name: "unknown language", field: "a", expression: "sql:select 1"},
+		{name: "invalid xpath", field: "a", expression: "xpath:/a["},
+	}
+	engine := NewEngine()
+	msgCtx := NewMessageContext([]byte(computedOrder), "application/json", engine)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := engine.RegisterComputedField(tt.field, tt.expression); err == nil {
+				t.Error("engine accepted the field")
+			}
+			if err := msgCtx.RegisterComputedField(tt.field, tt.expression); err == nil {
+				t.Error("message context accepted the field")
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newConcurrencyTestEngine returns an engine with a stylesheet, a Jolt spec,
+// a computed field, a key and a custom pipe registered.
+func newConcurrencyTestEngine(t *testing.T) *ExpressionEngine {
+	engine := NewEngine()
+	if err := engine.RegisterStylesheet("invoiceToSummary", []byte(testStylesheets["invoiceToSummary"])); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterJoltSpec("rename", []byte(`[{"operation": "shift", "spec": {"id": "ref"}}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterComputedField("lineCount", "xpath:count(//line)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterKey("hmac", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterPipe("exclaim", func(ctx context.Context, input PipeInput) (QueryResult, error) {
+		return QueryResult{Value: fmt.Sprint(input.Result.Value, "!"), Type: StringResult}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func TestConcurrentEvaluation(t *testing.T) {
+	engine := newConcurrencyTestEngine(t)
+	engine.SetRecorder(NewRecorder(&lockedBuffer{}, RecorderOptions{}))
+	compiled, err := engine.Compile("jsonpath:order.id | upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		payload     func(i int) string
+		contentType string
+		evaluate    func(mc *MessageContext) (QueryResult, error)
+		want        func(i int) interface{}
+	}{
+		{
+			name: "xslt",
+			payload: func(i int) string {
+				return fmt.Sprintf(`<invoice id="INV-%d"><line><amount>%d</amount></line></invoice>`, i, i)
+			},
+			contentType: "application/xml",
+			evaluate: func(mc *MessageContext) (QueryResult, error) {
+				return mc.EvaluateExpression(`xpath:/ | xslt("invoiceToSummary") | xpath:/summary/total`)
+			},
+			want: func(i int) interface{} { return fmt.Sprint(i) },
+		},
+		{
+			name:        "jolt",
+			payload:     func(i int) string { return fmt.Sprintf(`{"order": {"id": "A-%d"}}`, i) },
+			contentType: "application/json",
+			evaluate: func(mc *MessageContext) (QueryResult, error) {
+				return mc.EvaluateExpression(`jsonpath:order | jolt("rename") | jsonpath:ref | exclaim`)
+			},
+			want: func(i int) interface{} { return fmt.Sprintf("A-%d!", i) },
+		},
+		{
+			name:        "computed field",
+			payload:     func(i int) string { return "<order>" + string(bytes.Repeat([]byte("<line/>"), i)) + "</order>" },
+			contentType: "application/xml",
+			evaluate:    func(mc *MessageContext) (QueryResult, error) { return mc.EvaluateExpression("computed:lineCount") },
+			want:        func(i int) interface{} { return float64(i) },
+		},
+		{
+			name:        "compiled expression",
+			payload:     func(i int) string { return fmt.Sprintf(`{"order": {"id": "a-%d"}}`, i) },
+			contentType: "application/json",
+			evaluate:    compiled.EvaluateMessage,
+			want:        func(i int) interface{} { return fmt.Sprintf("A-%d", i) },
+		},
+		{
+			name:        "csv",
+			payload:     func(i int) string { return fmt.Sprintf("id,qty\nA,%d\n", i) },
+			contentType: "text/csv",
+			evaluate:    func(mc *MessageContext) (QueryResult, error) { return mc.EvaluateExpression("csv:0.qty") },
+			want:        func(i int) interface{} { return fmt.Sprint(i) },
+		},
+		{
+			name:        "hmac",
+			payload:     func(i int) string { return fmt.Sprintf(`{"id": "%d"}`, i%2) },
+			contentType: "application/json",
+			evaluate: func(mc *MessageContext) (QueryResult, error) {
+				return mc.EvaluateExpression(`jsonpath:id | hmacSHA256("hmac") | hex`)
+			},
+			want: func(i int) interface{} {
+				mac := hmac.New(sha256.New, []byte("secret"))
+				mac.Write([]byte(fmt.Sprint(i % 2)))
+				return hex.EncodeToString(mac.Sum(nil))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for i := 1; i <= 8; i++ {
+				shared := NewMessageContext([]byte(tt.payload(i)), tt.contentType, engine)
+				for g := 0; g < 4; g++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						for j := 0; j < 10; j++ {
+							// Alternate between a context shared with other goroutines and a new one
+							mc := shared
+							if j%2 == 1 {
+								mc = NewMessageContext([]byte(tt.payload(i)), tt.contentType, engine)
+							}
+							result, err := tt.evaluate(mc)
+							if err != nil {
+								t.Errorf("unexpected error: %v", err)
+								return
+							}
+							if result.Value != tt.want(i) {
+								t.Errorf("got %#v, want %#v", result.Value, tt.want(i))
+								return
+							}
+						}
+					}(i)
+				}
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that is safe for concurrent writes.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestConcurrentConfiguration(t *testing.T) {
+	engine := newConcurrencyTestEngine(t)
+	msgCtx := NewMessageContext([]byte(`<invoice id="INV-1"><line><amount>3</amount></line></invoice>`), "application/xml", engine)
+	tests := []struct {
+		name      string
+		configure func(i int) error
+	}{
+		{name: "register stylesheet", configure: func(i int) error {
+			return engine.RegisterStylesheet("invoiceToSummary", []byte(testStylesheets["invoiceToSummary"]))
+		}},
+		{name: "register pipe", configure: func(i int) error {
+			return engine.RegisterPipe(fmt.Sprintf("pipe%d", i), func(ctx context.Context, input PipeInput) (QueryResult, error) { return input.Result, nil })
+		}},
+		{name: "register computed field", configure: func(i int) error {
+			return engine.RegisterComputedField(fmt.Sprintf("field%d", i), "xpath:/invoice/@id")
+		}},
+		{name: "register computed field on the context", configure: func(i int) error {
+			return msgCtx.RegisterComputedField(fmt.Sprintf("field%d", i), "xpath:/invoice/@id")
+		}},
+		{name: "resize expression cache", configure: func(i int) error {
+			engine.SetExpressionCacheSize(i % 3)
+			return nil
+		}},
+		{name: "set timeouts", configure: func(i int) error {
+			engine.SetStageTimeout(time.Duration(i) * time.Hour)
+			engine.SetEvaluationTimeout(time.Duration(i) * time.Hour)
+			return nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for i := 1; i <= 8; i++ {
+				wg.Add(2)
+				go func(i int) {
+					defer wg.Done()
+					if err := tt.configure(i); err != nil {
+						t.Errorf("configure: %v", err)
+					}
+				}(i)
+				go func() {
+					defer wg.Done()
+					result, err := msgCtx.EvaluateExpression(`xpath:/ | xslt("invoiceToSummary") | xpath:/summary/total`)
+					if err != nil || result.Value != "3" {
+						t.Errorf("got %#v, %v, want \"3\"", result.Value, err)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodeContentEncoding undoes the HTTP Content-Encoding contentEncoding, e.g.
+// "gzip" or "deflate, br". Codings are listed in the order they were
+// applied, so they are removed from last to first. Supported codings are
+// gzip (x-gzip), deflate (zlib, or raw DEFLATE as sent by some servers), br
+// and identity. An empty contentEncoding returns raw unchanged.
+//
+// Unless maxBytes is 0, each decoded result may be at most maxBytes long:
+// decoding stops as soon as it produces more, so that a small compression
+// bomb cannot exhaust memory, and fails with ErrLimitExceeded, whose Actual
+// is then only a lower bound.
+func decodeContentEncoding(raw []byte, contentEncoding string, maxBytes int) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		var reader io.Reader
+		switch coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			gzipReader, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				return nil, fmt.Errorf("invalid gzip content: %w", err)
+			}
+			reader = gzipReader
+		case "deflate":
+			zlibReader, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				reader = flate.NewReader(bytes.NewReader(raw)) // No zlib header
+			} else {
+				reader = zlibReader
+			}
+		case "br":
+			reader = brotli.NewReader(bytes.NewReader(raw))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", coding)
+		}
+		if maxBytes > 0 {
+			reader = io.LimitReader(reader, int64(maxBytes)+1)
+		}
+		decoded, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s content: %w", coding, err)
+		}
+		if err := checkLimit("MaxPayloadBytes", maxBytes, len(decoded), ""); err != nil {
+			return nil, err
+		}
+		raw = decoded
+	}
+	return raw, nil
+}
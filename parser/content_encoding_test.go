@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressTestContent applies a single coding to content.
+func compressTestContent(t *testing.T, coding string, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case "flate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown test coding %s", coding)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodedMessageContext(t *testing.T) {
+	document := []byte(`{"order":{"id":"A-17","total":42.5}}`)
+
+	tests := []struct {
+		name            string
+		raw             []byte
+		contentEncoding string
+		wantErr         string
+	}{
+		{name: "gzip", raw: compressTestContent(t, "gzip", document), contentEncoding: "gzip"},
+		{name: "x-gzip", raw: compressTestContent(t, "gzip", document), contentEncoding: "x-gzip"},
+		{name: "deflate", raw: compressTestContent(t, "zlib", document), contentEncoding: "deflate"},
+		{name: "raw deflate", raw: compressTestContent(t, "flate", document), contentEncoding: "Deflate"},
+		{name: "brotli", raw: compressTestContent(t, "br", document), contentEncoding: "br"},
+		{name: "gzip then brotli", raw: compressTestContent(t, "br", compressTestContent(t, "gzip", document)), contentEncoding: "gzip, br"},
+		{name: "identity", raw: document, contentEncoding: "identity"},
+		{name: "not encoded", raw: document},
+		{name: "unsupported coding", raw: document, contentEncoding: "compress", wantErr: "unsupported content encoding: compress"},
+		{name: "corrupt gzip", raw: document, contentEncoding: "gzip", wantErr: "invalid gzip content"},
+		{name: "truncated brotli", raw: compressTestContent(t, "br", document)[:10], contentEncoding: "br", wantErr: "invalid br content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewEncodedMessageContext(tt.raw, "application/json", tt.contentEncoding, NewEngine()).EvaluateExpression("jsonpath:order.id")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != "A-17" {
+				t.Errorf("got %#v, want %q", result.Value, "A-17")
+			}
+		})
+	}
+}
+
+func TestReplayEncodedPayload(t *testing.T) {
+	var buf bytes.Buffer
+	engine := NewEngine()
+	engine.SetRecorder(NewRecorder(&buf, RecorderOptions{IncludePayloads: true}))
+	raw := compressTestContent(t, "gzip", []byte(`{"a":"x"}`))
+	if _, err := NewEncodedMessageContext(raw, "application/json", "gzip", engine).EvaluateExpression("jsonpath:a"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Replay(context.Background(), &buf, NewEngine())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Replayed != 1 || len(report.Diffs) != 0 {
+		t.Errorf("got %+v, want one replay without diffs", report)
+	}
+}
+
+func TestDecompressionPipes(t *testing.T) {
+	order := []byte(`{"id":"A-7","lines":[1,2,3]}`)
+	blobs := map[string]string{
+		"gzip":   base64.StdEncoding.EncodeToString(compressTestContent(t, "gzip", order)),
+		"zlib":   base64.StdEncoding.EncodeToString(compressTestContent(t, "zlib", order)),
+		"flate":  base64.StdEncoding.EncodeToString(compressTestContent(t, "flate", order)),
+		"binary": base64.StdEncoding.EncodeToString(compressTestContent(t, "gzip", []byte{0xff, 0xfe})),
+		"plain":  base64.StdEncoding.EncodeToString(order),
+	}
+	body, err := json.Marshal(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "gunzip and query", expression: "jsonpath:gzip | base64Decode | gunzip | extractAsJSON | jsonpath:id", want: "A-7"},
+		{name: "gunzip text", expression: "jsonpath:gzip | base64Decode | gunzip", want: string(order)},
+		{name: "inflate zlib", expression: "jsonpath:zlib | base64Decode | inflate | extractAsJSON | jsonpath:lines.#", want: float64(3)},
+		{name: "inflate raw deflate", expression: "jsonpath:flate | base64Decode | inflate", want: string(order)},
+		{name: "binary result", expression: "jsonpath:binary | base64Decode | gunzip", want: []byte{0xff, 0xfe}},
+		{name: "not gzip", expression: "jsonpath:plain | base64Decode | gunzip", wantErr: "invalid gzip content"},
+		{name: "gzip is not zlib", expression: "jsonpath:plain | base64Decode | inflate", wantErr: "pipe operation 'inflate' failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	// 1 MiB of zeros compresses to about a kilobyte
+	bomb := bytes.Repeat([]byte{'0'}, 1<<20)
+	gzipped := compressTestContent(t, "gzip", bomb)
+	body, err := json.Marshal(map[string]string{
+		"gzip": base64.StdEncoding.EncodeToString(gzipped),
+		"zlib": base64.StdEncoding.EncodeToString(compressTestContent(t, "zlib", bomb)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine()
+	engine.SetLimits(EngineLimits{MaxPayloadBytes: 64 << 10})
+
+	tests := []struct {
+		name           string
+		msgCtx         *MessageContext
+		expression     string
+		wantExpression string
+	}{
+		{name: "content encoding", msgCtx: NewMessageContext(gzipped, "application/json", engine, WithContentEncoding("gzip")), expression: "jsonpath:$"},
+		{name: "gunzip", msgCtx: NewMessageContext(body, "application/json", engine), expression: "jsonpath:gzip | base64Decode | gunzip", wantExpression: "jsonpath:gzip | base64Decode | gunzip"},
+		{name: "inflate", msgCtx: NewMessageContext(body, "application/json", engine), expression: "jsonpath:zlib | base64Decode | inflate", wantExpression: "jsonpath:zlib | base64Decode | inflate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.msgCtx.EvaluateExpression(tt.expression)
+			var limitErr *ErrLimitExceeded
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected ErrLimitExceeded, got %v", err)
+			}
+			// Decoding stops at the first byte over the limit
+			if limitErr.Limit != "MaxPayloadBytes" || limitErr.Actual != 64<<10+1 || limitErr.Expression != tt.wantExpression {
+				t.Errorf("got %+v", limitErr)
+			}
+		})
+	}
+}
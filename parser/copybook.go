@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// copybookStatementEnd is a period ending a copybook entry; periods inside
+// pictures such as 9(5).99 are followed by more characters.
+var copybookStatementEnd = regexp.MustCompile(`\.(\s|$)`)
+
+// ParseCopybook builds a FixedWidthLayout from a COBOL copybook record
+// description such as
+//
+//	01 CUSTOMER-RECORD.
+//	   05 CUST-ID      PIC 9(6).
+//	   05 CUST-NAME    PIC X(20).
+//	   05 FILLER       PIC X(2).
+//	   05 BALANCE      PIC S9(7)V99.
+//	   05 PHONE        PIC X(10) OCCURS 2 TIMES.
+//
+// Elementary items become fields named after the item, in order: X and A
+// pictures are text, 9 pictures (with an optional S sign and V implied
+// decimal point) are numbers, and edited pictures (Z, '.', ',') are text.
+// FILLER items only take up space, group items only group, and 88-level
+// condition names are ignored. Lines starting with '*' are comments, as are
+// lines with '*' in column 7 of fixed-format source. Binary and packed usages
+// (COMP, COMP-3), REDEFINES and OCCURS on groups are not supported.
+func ParseCopybook(spec string) (FixedWidthLayout, error) {
+	var lines []string
+	for _, line := range strings.Split(spec, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "*") || (len(line) > 6 && line[6] == '*') {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	var layout FixedWidthLayout
+	position := 0
+	for _, statement := range copybookStatementEnd.Split(strings.Join(lines, "\n"), -1) {
+		tokens := strings.Fields(statement)
+		if len(tokens) == 0 {
+			continue
+		}
+		level, err := strconv.Atoi(tokens[0])
+		if err != nil || level < 1 || (level > 49 && level != 66 && level != 77 && level != 88) {
+			return FixedWidthLayout{}, fmt.Errorf("invalid copybook entry '%s': expected a level number", strings.Join(tokens, " "))
+		}
+		if level == 88 {
+			continue // Condition names describe values, not storage
+		}
+		if level == 66 {
+			return FixedWidthLayout{}, fmt.Errorf("copybook RENAMES (level 66) is not supported")
+		}
+		name, clauses := "FILLER", tokens[1:]
+		if len(clauses) > 0 && !isCopybookKeyword(clauses[0]) {
+			name, clauses = clauses[0], clauses[1:]
+		}
+
+		var picture string
+		occurs := 0
+		for i := 0; i < len(clauses); i++ {
+			switch keyword := strings.ToUpper(clauses[i]); keyword {
+			case "PIC", "PICTURE":
+				if i+1 < len(clauses) && strings.EqualFold(clauses[i+1], "IS") {
+					i++
+				}
+				if i+1 >= len(clauses) {
+					return FixedWidthLayout{}, fmt.Errorf("copybook item %s: PIC without a picture", name)
+				}
+				i++
+				picture = strings.ToUpper(clauses[i])
+			case "OCCURS":
+				if i+1 >= len(clauses) {
+					return FixedWidthLayout{}, fmt.Errorf("copybook item %s: OCCURS without a count", name)
+				}
+				i++
+				if occurs, err = strconv.Atoi(clauses[i]); err != nil || occurs < 1 {
+					return FixedWidthLayout{}, fmt.Errorf("copybook item %s: invalid OCCURS count '%s'", name, clauses[i])
+				}
+				if i+1 < len(clauses) && strings.EqualFold(clauses[i+1], "TIMES") {
+					i++
+				}
+			case "VALUE", "VALUES":
+				i = len(clauses) // Initial values do not affect the layout
+			case "DISPLAY", "USAGE", "IS":
+			case "REDEFINES", "COMP", "COMP-1", "COMP-2", "COMP-3", "COMP-4", "COMP-5", "COMPUTATIONAL", "COMPUTATIONAL-3", "BINARY", "PACKED-DECIMAL":
+				return FixedWidthLayout{}, fmt.Errorf("copybook item %s: %s is not supported", name, keyword)
+			default:
+				return FixedWidthLayout{}, fmt.Errorf("copybook item %s: unexpected '%s'", name, clauses[i])
+			}
+		}
+
+		if picture == "" { // A group item
+			if occurs > 0 {
+				return FixedWidthLayout{}, fmt.Errorf("copybook item %s: OCCURS on group items is not supported", name)
+			}
+			continue
+		}
+		length, fieldType, scale, err := parsePicture(picture)
+		if err != nil {
+			return FixedWidthLayout{}, fmt.Errorf("copybook item %s: %w", name, err)
+		}
+		if !strings.EqualFold(name, "FILLER") {
+			layout.Fields = append(layout.Fields, FixedWidthField{Name: name, Start: position, Length: length, Type: fieldType, Scale: scale, Occurs: occurs})
+		}
+		position += length * max(occurs, 1)
+	}
+	if err := layout.validate(); err != nil {
+		return FixedWidthLayout{}, fmt.Errorf("invalid copybook: %w", err)
+	}
+	return layout, nil
+}
+
+func isCopybookKeyword(token string) bool {
+	switch strings.ToUpper(token) {
+	case "PIC", "PICTURE", "OCCURS", "VALUE", "VALUES", "REDEFINES", "USAGE":
+		return true
+	}
+	return false
+}
+
+// parsePicture returns the character length, type and implied decimal places
+// of a PIC string such as "S9(7)V99" or "X(20)".
+func parsePicture(picture string) (int, FixedWidthFieldType, int, error) {
+	length, scale := 0, 0
+	numeric, edited, afterV := true, false, false
+	for i := 0; i < len(picture); i++ {
+		symbol := picture[i]
+		count := 1
+		if i+1 < len(picture) && picture[i+1] == '(' {
+			end := strings.IndexByte(picture[i:], ')')
+			if end < 0 {
+				return 0, 0, 0, fmt.Errorf("invalid picture '%s'", picture)
+			}
+			n, err := strconv.Atoi(picture[i+2 : i+end])
+			if err != nil || n < 1 {
+				return 0, 0, 0, fmt.Errorf("invalid picture '%s'", picture)
+			}
+			count = n
+			i += end
+		}
+		switch symbol {
+		case 'S':
+			continue // The sign shares a digit position
+		case 'V':
+			afterV = true
+			continue
+		case '9':
+			if afterV {
+				scale += count
+			}
+		case 'X', 'A':
+			numeric = false
+		case 'Z', '.', ',', '-', '+', '*', '$', 'B', '0', '/':
+			edited = true
+		default:
+			return 0, 0, 0, fmt.Errorf("unsupported picture symbol '%c' in '%s'", symbol, picture)
+		}
+		length += count
+	}
+	if length == 0 {
+		return 0, 0, 0, fmt.Errorf("invalid picture '%s'", picture)
+	}
+	if !numeric || edited {
+		return length, FixedWidthText, 0, nil
+	}
+	return length, FixedWidthNumber, scale, nil
+}
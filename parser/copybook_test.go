@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCopybook(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want []FixedWidthField
+	}{
+		{
+			name: "record with filler, sign and occurs",
+			spec: `
+      * Customer master record
+       01 CUSTOMER-RECORD.
+          05 CUST-ID      PIC 9(6).
+          05 CUST-NAME    PIC X(20).
+          05 FILLER       PIC X(2).
+          05 BALANCE      PIC S9(7)V99.
+          05 PHONE        PIC X(10) OCCURS 2 TIMES.`,
+			want: []FixedWidthField{
+				{Name: "CUST-ID", Start: 0, Length: 6, Type: FixedWidthNumber},
+				{Name: "CUST-NAME", Start: 6, Length: 20},
+				{Name: "BALANCE", Start: 28, Length: 9, Type: FixedWidthNumber, Scale: 2},
+				{Name: "PHONE", Start: 37, Length: 10, Occurs: 2},
+			},
+		},
+		{
+			name: "nested groups, picture is and values",
+			spec: `01 ORDER.
+  05 HEADER.
+    10 ORDER-NO PICTURE IS 9(4).
+    10 STATUS PIC X VALUE 'N'.
+      88 IS-NEW VALUE 'N'.
+  05 AMOUNT PIC 999V9 USAGE DISPLAY.`,
+			want: []FixedWidthField{
+				{Name: "ORDER-NO", Start: 0, Length: 4, Type: FixedWidthNumber},
+				{Name: "STATUS", Start: 4, Length: 1},
+				{Name: "AMOUNT", Start: 5, Length: 4, Type: FixedWidthNumber, Scale: 1},
+			},
+		},
+		{
+			name: "edited and alphabetic pictures, unnamed filler",
+			spec: "01 R. 05 PRICE PIC ZZ9.99. 05 PIC XX. 05 CODE PIC A(3). 05 QTY pic 9(3).",
+			want: []FixedWidthField{
+				{Name: "PRICE", Start: 0, Length: 6},
+				{Name: "CODE", Start: 8, Length: 3},
+				{Name: "QTY", Start: 11, Length: 3, Type: FixedWidthNumber},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, err := ParseCopybook(tt.spec)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(layout.Fields, tt.want) {
+				t.Errorf("got %+v, want %+v", layout.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCopybookInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr string
+	}{
+		{name: "empty", spec: "", wantErr: "layout has no fields"},
+		{name: "missing level", spec: "CUST-ID PIC 9(6).", wantErr: "expected a level number"},
+		{name: "packed decimal", spec: "05 AMOUNT PIC S9(5) COMP-3.", wantErr: "COMP-3 is not supported"},
+		{name: "redefines", spec: "05 A PIC X(2). 05 B REDEFINES A PIC 99.", wantErr: "REDEFINES is not supported"},
+		{name: "group occurs", spec: "05 LINES OCCURS 3. 10 SKU PIC X(4).", wantErr: "OCCURS on group items is not supported"},
+		{name: "bad occurs", spec: "05 A PIC X OCCURS MANY.", wantErr: "invalid OCCURS count 'MANY'"},
+		{name: "bad picture", spec: "05 A PIC X(.", wantErr: "invalid picture 'X('"},
+		{name: "unsupported symbol", spec: "05 A PIC 9(3)E.", wantErr: "unsupported picture symbol 'E'"},
+		{name: "picture missing", spec: "05 A PIC.", wantErr: "PIC without a picture"},
+		{name: "unknown clause", spec: "05 A PIC X JUSTIFIED.", wantErr: "unexpected 'JUSTIFIED'"},
+		{name: "duplicate name", spec: "05 A PIC X. 05 A PIC 9.", wantErr: "missing or duplicate field name 'A'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCopybook(tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+)
+
+// RegisterKey stores a secret key that the HMAC and AES pipes of expressions
+// evaluated by this engine can refer to by ref, e.g.
+// "jsonpath:payload | hmacSHA256(\"webhook\") | hex", so that keys do not
+// appear in expressions. AES keys are 16, 24 or 32 bytes long. The key is copied. Registering a ref again replaces
+// its key; passing a nil key removes it. Engines that have this engine as
+// their fallback can use its keys too.
+func (ee *ExpressionEngine) RegisterKey(ref string, key []byte) error {
+	if ref == "" {
+		return fmt.Errorf("key reference is empty")
+	}
+	if key == nil {
+		ee.keys.Delete(ref)
+		return nil
+	}
+	ee.keys.Store(ref, append([]byte(nil), key...))
+	return nil
+}
+
+// key returns the key registered as ref on this engine or on its fallback
+// chain.
+func (ee *ExpressionEngine) key(ref string) ([]byte, bool) {
+	for e := ee; e != nil; e = e.fallback.Load() {
+		if key, ok := e.keys.Load(ref); ok {
+			return key.([]byte), true
+		}
+	}
+	return nil, false
+}
+
+// pipeKey returns the key named by the first argument of call.
+func (ee *ExpressionEngine) pipeKey(call pipeCall) ([]byte, error) {
+	ref, err := stringArg(call, 0)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := ee.key(ref)
+	if !ok {
+		return nil, fmt.Errorf("no key is registered as '%s'", ref)
+	}
+	return key, nil
+}
+
+// hmacPipeHashes are the hash functions of the HMAC pipes.
+var hmacPipeHashes = map[string]func() hash.Hash{
+	hmacSHA256Pipe: sha256.New,
+	hmacSHA1Pipe:   sha1.New,
+}
+
+// hmacDigest returns the HMAC of data computed by the HMAC pipe call.
+func (ee *ExpressionEngine) hmacDigest(call pipeCall, data []byte) ([]byte, error) {
+	key, err := ee.pipeKey(call)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(hmacPipeHashes[call.name], key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// aesGCM returns the AES-GCM cipher with the key of the AES pipe call, and
+// its additional authenticated data, if any.
+func (ee *ExpressionEngine) aesGCM(call pipeCall) (cipher.AEAD, []byte, error) {
+	key, err := ee.pipeKey(call)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	var additionalData []byte
+	if len(call.args) > 1 {
+		data, err := stringArg(call, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		additionalData = []byte(data)
+	}
+	return gcm, additionalData, nil
+}
+
+// aesEncrypt encrypts plaintext with a random nonce, which precedes the
+// ciphertext and its authentication tag in the result.
+func (ee *ExpressionEngine) aesEncrypt(call pipeCall, plaintext []byte) ([]byte, error) {
+	gcm, additionalData, err := ee.aesGCM(call)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// aesDecrypt decrypts and authenticates the output of aesEncrypt.
+func (ee *ExpressionEngine) aesDecrypt(call pipeCall, sealed []byte) ([]byte, error) {
+	gcm, additionalData, err := ee.aesGCM(call)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: wrong key or tampered ciphertext")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHMACPipes(t *testing.T) {
+	body := []byte(`{"data":"what do ya want for nothing?","payload":{"a":1},"count":3}`)
+	base := NewEngine()
+	if err := base.RegisterKey("jefe", []byte("Jefe")); err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine()
+	if err := engine.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterKey("empty", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "hmac sha256 (RFC 4231)", expression: `jsonpath:data | hmacSHA256("jefe") | hex`, want: "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{name: "hmac sha1 (RFC 2202)", expression: `jsonpath:data | hmacSHA1("jefe") | toHex`, want: "effcdf6ae5eb2fa2d27416d5f184df9c259a7c79"},
+		{name: "base64 signature", expression: `jsonpath:data | hmacSHA256("jefe") | base64Encode`, want: "W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM="},
+		{name: "empty key", expression: `jsonpath:data | hmacSHA256("empty") | hex`, want: "76d9e7194e7dbc3aa00bbe8ffb9f6fcb5a932170f971f948bb2ab61607d2b9d6"},
+		{name: "unknown key", expression: `jsonpath:data | hmacSHA256("missing")`, wantErr: "no key is registered as 'missing'"},
+		{name: "key ref not a string", expression: "jsonpath:data | hmacSHA256(1)", wantErr: "must be a string"},
+		{name: "missing key ref", expression: "jsonpath:data | hmacSHA256", wantErr: "takes 1 argument(s), got 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterKey(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterKey("", []byte("k")); err == nil {
+		t.Error("engine accepted an empty key reference")
+	}
+
+	key := []byte("secret")
+	if err := engine.RegisterKey("k", key); err != nil {
+		t.Fatal(err)
+	}
+	key[0] = 'S' // The engine keeps its own copy
+	if got, ok := engine.key("k"); !ok || string(got) != "secret" {
+		t.Errorf("got %q, %v, want the registered key", got, ok)
+	}
+
+	if err := engine.RegisterKey("k", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := engine.key("k"); ok {
+		t.Error("key still registered after removal")
+	}
+}
+
+// sealForTest encrypts plaintext like aesEncrypt, with a fixed nonce.
+func sealForTest(t *testing.T, key, plaintext, additionalData []byte) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := bytes.Repeat([]byte{7}, gcm.NonceSize())
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, additionalData))
+}
+
+func TestAESPipes(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	sealed := sealForTest(t, key, []byte(`{"number":"4111111111111111"}`), nil)
+	tampered := []byte(sealed)
+	tampered[20] ^= 1
+	fields, err := json.Marshal(map[string]string{
+		"secret":   sealed,
+		"withAAD":  sealForTest(t, key, []byte("bound"), []byte("order-7")),
+		"binary":   sealForTest(t, key, []byte{0xff, 0x00}, nil),
+		"tampered": string(tampered),
+		"short":    base64.StdEncoding.EncodeToString([]byte("tiny")),
+		"card":     "4111111111111111",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine()
+	for ref, k := range map[string][]byte{"cards": key, "aes128": bytes.Repeat([]byte("a"), 16), "other": bytes.Repeat([]byte("o"), 32), "short": []byte("12345")} {
+		if err := engine.RegisterKey(ref, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "decrypt and query", expression: `jsonpath:secret | base64Decode | aesDecrypt("cards") | extractAsJSON | jsonpath:number`, want: "4111111111111111"},
+		{name: "additional data", expression: `jsonpath:withAAD | base64Decode | aesDecrypt("cards", "order-7")`, want: "bound"},
+		{name: "binary plaintext", expression: `jsonpath:binary | base64Decode | aesDecrypt("cards")`, want: []byte{0xff, 0x00}},
+		{name: "round trip", expression: `jsonpath:card | aesEncrypt("cards") | aesDecrypt("cards")`, want: "4111111111111111"},
+		{name: "round trip aes-128", expression: `jsonpath:card | aesEncrypt("aes128", "x") | base64Encode | base64Decode | aesDecrypt("aes128", "x")`, want: "4111111111111111"},
+		{name: "missing additional data", expression: `jsonpath:withAAD | base64Decode | aesDecrypt("cards")`, wantErr: "wrong key or tampered ciphertext"},
+		{name: "wrong key", expression: `jsonpath:secret | base64Decode | aesDecrypt("other")`, wantErr: "wrong key or tampered ciphertext"},
+		{name: "tampered", expression: `jsonpath:tampered | base64Decode | aesDecrypt("cards")`, wantErr: "pipe operation 'aesDecrypt' failed"},
+		{name: "too short", expression: `jsonpath:short | base64Decode | aesDecrypt("cards")`, wantErr: "ciphertext is too short"},
+		{name: "invalid key size", expression: `jsonpath:card | aesEncrypt("short")`, wantErr: "invalid key size"},
+		{name: "unknown key", expression: `jsonpath:card | aesEncrypt("missing")`, wantErr: "no key is registered as 'missing'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(fields, "application/json", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+
+	// Each encryption uses a new nonce
+	ctx := NewMessageContext(fields, "application/json", engine)
+	first, err := ctx.EvaluateExpression(`jsonpath:card | aesEncrypt("cards")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ctx.EvaluateExpression(`jsonpath:card | aesEncrypt("cards")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Value.([]byte)) != 12+16+16 || bytes.Equal(first.Value.([]byte), second.Value.([]byte)) {
+		t.Errorf("got %x and %x, want distinct nonce-prefixed ciphertexts", first.Value, second.Value)
+	}
+}
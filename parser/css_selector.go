@@ -0,0 +1,279 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cssToXPath translates a CSS selector into an equivalent XPath expression.
+// Supported: type and universal selectors, #id, .class, attribute selectors
+// ([a], [a=v], [a~=v], [a|=v], [a^=v], [a$=v], [a*=v]), :first-child,
+// :last-child, :nth-child(n), the descendant, child (>), adjacent (+) and
+// general sibling (~) combinators, and selector groups (a, b).
+func cssToXPath(selector string) (string, error) {
+	p := &cssParser{input: selector}
+	var groups []string
+	for {
+		expr, err := p.parseSelector()
+		if err != nil {
+			return "", err
+		}
+		groups = append(groups, expr)
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		if !p.consume(',') {
+			return "", fmt.Errorf("unexpected '%c' at offset %d", p.peek(), p.pos)
+		}
+	}
+	return strings.Join(groups, " | "), nil
+}
+
+type cssParser struct {
+	input string
+	pos   int
+}
+
+func (p *cssParser) eof() bool  { return p.pos >= len(p.input) }
+func (p *cssParser) peek() byte { return p.input[p.pos] }
+
+func (p *cssParser) consume(c byte) bool {
+	if !p.eof() && p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// skipSpace skips whitespace and reports whether any was skipped.
+func (p *cssParser) skipSpace() bool {
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\n\r\f", p.peek()) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// parseSelector parses one complex selector (compounds joined by combinators).
+func (p *cssParser) parseSelector() (string, error) {
+	p.skipSpace()
+	var sb strings.Builder
+	axis := "//" // The first compound may match anywhere in the document
+	for {
+		tag, conditions, err := p.parseCompound()
+		if err != nil {
+			return "", err
+		}
+		switch axis {
+		case "+":
+			sb.WriteString("/following-sibling::*[1]")
+			if tag != "*" {
+				conditions = append([]string{"self::" + tag}, conditions...)
+			}
+		case "~":
+			sb.WriteString("/following-sibling::" + tag)
+		default:
+			sb.WriteString(axis + tag)
+		}
+		for _, condition := range conditions {
+			sb.WriteString("[" + condition + "]")
+		}
+
+		hadSpace := p.skipSpace()
+		if p.eof() || p.peek() == ',' {
+			return sb.String(), nil
+		}
+		switch {
+		case p.consume('>'):
+			axis = "/"
+		case p.consume('+'):
+			axis = "+"
+		case p.consume('~'):
+			axis = "~"
+		case hadSpace:
+			axis = "//"
+		default:
+			return "", fmt.Errorf("unexpected '%c' at offset %d", p.peek(), p.pos)
+		}
+		p.skipSpace()
+	}
+}
+
+// parseCompound parses a type selector followed by id, class, attribute and
+// pseudo-class conditions.
+func (p *cssParser) parseCompound() (string, []string, error) {
+	tag := "*"
+	hasType := p.consume('*')
+	if !hasType {
+		if name := p.parseIdent(); name != "" {
+			tag = strings.ToLower(name) // HTML element names are case-insensitive
+			hasType = true
+		}
+	}
+	var conditions []string
+	for !p.eof() {
+		switch {
+		case p.consume('#'):
+			id := p.parseIdent()
+			if id == "" {
+				return "", nil, fmt.Errorf("expected an id after '#' at offset %d", p.pos)
+			}
+			conditions = append(conditions, "@id="+xpathLiteral(id))
+		case p.consume('.'):
+			class := p.parseIdent()
+			if class == "" {
+				return "", nil, fmt.Errorf("expected a class name after '.' at offset %d", p.pos)
+			}
+			conditions = append(conditions, containsWord("@class", class))
+		case p.consume('['):
+			condition, err := p.parseAttribute()
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, condition)
+		case p.consume(':'):
+			condition, err := p.parsePseudoClass()
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, condition)
+		default:
+			return p.endCompound(tag, hasType, conditions)
+		}
+	}
+	return p.endCompound(tag, hasType, conditions)
+}
+
+// endCompound rejects empty compound selectors (e.g. "a >" or ", b").
+func (p *cssParser) endCompound(tag string, hasType bool, conditions []string) (string, []string, error) {
+	if !hasType && len(conditions) == 0 {
+		return "", nil, fmt.Errorf("expected a selector at offset %d", p.pos)
+	}
+	return tag, conditions, nil
+}
+
+// parseAttribute parses the inside of an attribute selector, after '['.
+func (p *cssParser) parseAttribute() (string, error) {
+	p.skipSpace()
+	name := p.parseIdent()
+	if name == "" {
+		return "", fmt.Errorf("expected an attribute name at offset %d", p.pos)
+	}
+	attr := "@" + strings.ToLower(name)
+	p.skipSpace()
+	if p.consume(']') {
+		return attr, nil
+	}
+
+	operator := ""
+	if !p.eof() && strings.IndexByte("~|^$*", p.peek()) >= 0 {
+		operator = string(p.peek())
+		p.pos++
+	}
+	if !p.consume('=') {
+		return "", fmt.Errorf("expected '=' in attribute selector at offset %d", p.pos)
+	}
+	p.skipSpace()
+	value, err := p.parseValue()
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace()
+	if !p.consume(']') {
+		return "", fmt.Errorf("expected ']' at offset %d", p.pos)
+	}
+
+	literal := xpathLiteral(value)
+	switch operator {
+	case "~":
+		return containsWord(attr, value), nil
+	case "|":
+		return fmt.Sprintf("%s=%s or starts-with(%s, %s)", attr, literal, attr, xpathLiteral(value+"-")), nil
+	case "^":
+		return fmt.Sprintf("starts-with(%s, %s)", attr, literal), nil
+	case "$":
+		return fmt.Sprintf("ends-with(%s, %s)", attr, literal), nil
+	case "*":
+		return fmt.Sprintf("contains(%s, %s)", attr, literal), nil
+	}
+	return attr + "=" + literal, nil
+}
+
+// parsePseudoClass parses a structural pseudo-class, after ':'.
+func (p *cssParser) parsePseudoClass() (string, error) {
+	name := strings.ToLower(p.parseIdent())
+	switch name {
+	case "first-child":
+		return "not(preceding-sibling::*)", nil
+	case "last-child":
+		return "not(following-sibling::*)", nil
+	case "nth-child":
+		if !p.consume('(') {
+			return "", fmt.Errorf("expected '(' after :nth-child at offset %d", p.pos)
+		}
+		end := strings.IndexByte(p.input[p.pos:], ')')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated :nth-child at offset %d", p.pos)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(p.input[p.pos : p.pos+end]))
+		if err != nil || n < 1 {
+			return "", fmt.Errorf(":nth-child supports positive integers only, got '%s'", p.input[p.pos:p.pos+end])
+		}
+		p.pos += end + 1
+		return fmt.Sprintf("count(preceding-sibling::*)=%d", n-1), nil
+	}
+	return "", fmt.Errorf("unsupported pseudo-class ':%s'", name)
+}
+
+// parseIdent parses a CSS identifier (letters, digits, '-' and '_').
+func (p *cssParser) parseIdent() string {
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+// parseValue parses an attribute value, quoted or as an identifier.
+func (p *cssParser) parseValue() (string, error) {
+	if !p.eof() && (p.peek() == '"' || p.peek() == '\'') {
+		quote := p.peek()
+		end := strings.IndexByte(p.input[p.pos+1:], quote)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string at offset %d", p.pos)
+		}
+		value := p.input[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return value, nil
+	}
+	value := p.parseIdent()
+	if value == "" {
+		return "", fmt.Errorf("expected an attribute value at offset %d", p.pos)
+	}
+	return value, nil
+}
+
+// containsWord builds an XPath test for a whitespace-separated word in attr.
+func containsWord(attr, word string) string {
+	return fmt.Sprintf("contains(concat(' ', normalize-space(%s), ' '), %s)", attr, xpathLiteral(" "+word+" "))
+}
+
+// xpathLiteral quotes s as an XPath string literal.
+func xpathLiteral(s string) string {
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`
+	}
+	// Contains both quote kinds: concatenate single-quoted parts with "'"
+	parts := strings.Split(s, "'")
+	return "concat('" + strings.Join(parts, `', "'", '`) + "')"
+}
@@ -0,0 +1,265 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// CSVOptions configures how CSV content is split into records.
+type CSVOptions struct {
+	Delimiter rune // Field separator, defaults to ','
+	Quote     rune // Quote character, defaults to '"'
+	HasHeader bool // Treat the first record as column names
+}
+
+// DefaultCSVOptions returns RFC 4180 settings with a header row.
+func DefaultCSVOptions() CSVOptions {
+	return CSVOptions{Delimiter: ',', Quote: '"', HasHeader: true}
+}
+
+// CSVPayload handles CSV data.
+// Like JSONPayload it keeps the raw bytes and only does the work needed for
+// querying on demand: records are parsed on the first query and cached.
+//
+// Queries use gjson paths over a JSON view of the records. With a header row
+// every record is an object keyed by column name ("0.amount", "#.amount"),
+// otherwise every record is an array of fields ("0.1", "#.1"). "#" returns the
+// number of records.
+type CSVPayload struct {
+	rawContent  []byte
+	options     CSVOptions
+	contentType string
+
+	parseOnce  sync.Once
+	headers    []string
+	records    [][]string
+	jsonView   []byte
+	jsonResult gjson.Result
+	parseErr   error
+}
+
+// NewCSVPayload creates a new CSVPayload. Parsing is deferred until the payload
+// is first queried.
+func NewCSVPayload(content []byte, options CSVOptions) (*CSVPayload, error) {
+	if options.Delimiter == 0 {
+		options.Delimiter = ','
+	}
+	if options.Quote == 0 {
+		options.Quote = '"'
+	}
+	if options.Delimiter == options.Quote {
+		return nil, &ErrEvaluationFailed{Reason: "CSV delimiter and quote character must differ"}
+	}
+	return &CSVPayload{
+		rawContent:  content,
+		options:     options,
+		contentType: "text/csv",
+	}, nil
+}
+
+func (cp *CSVPayload) GetRawBytes() []byte {
+	return cp.rawContent
+}
+
+func (cp *CSVPayload) GetContentType() string {
+	return cp.contentType
+}
+
+// ensureParsed parses the records and builds the JSON view exactly once.
+func (cp *CSVPayload) ensureParsed() error {
+	cp.parseOnce.Do(func() {
+		records, err := splitCSV(string(cp.rawContent), cp.options.Delimiter, cp.options.Quote)
+		if err != nil {
+			cp.parseErr = &ErrEvaluationFailed{Reason: "CSV parsing failed", InnerError: err}
+			return
+		}
+		if cp.options.HasHeader && len(records) > 0 {
+			cp.headers = records[0]
+			records = records[1:]
+		}
+		cp.records = records
+
+		cp.jsonView, err = json.Marshal(cp.recordsAsJSON())
+		if err != nil {
+			cp.parseErr = &ErrEvaluationFailed{Reason: "failed to convert CSV records to JSON view", InnerError: err}
+			return
+		}
+		cp.jsonResult = gjson.ParseBytes(cp.jsonView)
+	})
+	return cp.parseErr
+}
+
+// recordsAsJSON returns the records as objects keyed by header, or as arrays of
+// fields when the payload has no header row.
+func (cp *CSVPayload) recordsAsJSON() []interface{} {
+	out := make([]interface{}, 0, len(cp.records))
+	for _, record := range cp.records {
+		if cp.headers == nil {
+			out = append(out, record)
+			continue
+		}
+		obj := make(map[string]interface{}, len(cp.headers))
+		for i, field := range record {
+			if i < len(cp.headers) {
+				obj[cp.headers[i]] = field
+			}
+		}
+		out = append(out, obj)
+	}
+	return out
+}
+
+// Query evaluates a gjson path against the JSON view of the CSV records.
+func (cp *CSVPayload) Query(expression string) (QueryResult, error) {
+	if err := cp.ensureParsed(); err != nil {
+		return QueryResult{}, err
+	}
+	return queryJSONView(cp.jsonResult, expression)
+}
+
+// AsJSON returns the records as a JSON array (objects when a header row is present).
+func (cp *CSVPayload) AsJSON() ([]byte, error) {
+	if err := cp.ensureParsed(); err != nil {
+		return nil, err
+	}
+	return cp.jsonView, nil
+}
+
+// Headers returns the column names, or nil when the payload has no header row.
+func (cp *CSVPayload) Headers() ([]string, error) {
+	if err := cp.ensureParsed(); err != nil {
+		return nil, err
+	}
+	return cp.headers, nil
+}
+
+func (cp *CSVPayload) AsString() (string, error) {
+	return string(cp.rawContent), nil
+}
+
+func (cp *CSVPayload) GetUnderlying() interface{} {
+	if err := cp.ensureParsed(); err != nil {
+		return nil
+	}
+	return cp.records
+}
+
+// splitCSV splits content into records. encoding/csv only supports '"' as the
+// quote character, so a small RFC 4180 style scanner is used instead.
+// Like encoding/csv, empty lines are skipped.
+func splitCSV(content string, delimiter, quote rune) ([][]string, error) {
+	var records [][]string
+	var record []string
+	var field strings.Builder
+	inQuotes := false
+	lineHasContent := false // Distinguishes an empty line from a record with one empty field
+	line := 1
+
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if inQuotes {
+			switch {
+			case r == quote && i+1 < len(runes) && runes[i+1] == quote:
+				field.WriteRune(quote) // Escaped quote
+				i++
+			case r == quote:
+				inQuotes = false
+			default:
+				if r == '\n' {
+					line++
+				}
+				field.WriteRune(r)
+			}
+			continue
+		}
+
+		switch r {
+		case quote:
+			if field.Len() > 0 {
+				return nil, fmt.Errorf("line %d: unexpected quote in unquoted field", line)
+			}
+			inQuotes = true
+			lineHasContent = true
+		case delimiter:
+			record = append(record, field.String())
+			field.Reset()
+			lineHasContent = true
+		case '\r':
+			// Part of a CRLF line ending; a bare CR is kept as data
+			if i+1 < len(runes) && runes[i+1] == '\n' {
+				continue
+			}
+			field.WriteRune(r)
+			lineHasContent = true
+		case '\n':
+			if lineHasContent {
+				records = append(records, append(record, field.String()))
+			}
+			record = nil
+			field.Reset()
+			lineHasContent = false
+			line++
+		default:
+			field.WriteRune(r)
+			lineHasContent = true
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("line %d: unterminated quoted field", line)
+	}
+	// Flush the last record unless the content ended with a newline
+	if lineHasContent {
+		records = append(records, append(record, field.String()))
+	}
+	return records, nil
+}
+
+// csvOptionsFromParams reads CSV settings from media type parameters, e.g.
+//
+//	text/csv; header=absent; delimiter=";"
+//
+// Values that are not RFC 2045 tokens, such as ";", must be quoted.
+func csvOptionsFromParams(params map[string]string) (CSVOptions, error) {
+	options := DefaultCSVOptions()
+	if header, ok := params["header"]; ok {
+		switch strings.ToLower(header) {
+		case "present":
+			options.HasHeader = true
+		case "absent":
+			options.HasHeader = false
+		default:
+			return options, fmt.Errorf("invalid CSV header parameter: %s", header)
+		}
+	}
+	if delimiter, ok := params["delimiter"]; ok {
+		r, err := singleRune(delimiter, "delimiter")
+		if err != nil {
+			return options, err
+		}
+		options.Delimiter = r
+	}
+	if quote, ok := params["quote"]; ok {
+		r, err := singleRune(quote, "quote")
+		if err != nil {
+			return options, err
+		}
+		options.Quote = r
+	}
+	return options, nil
+}
+
+func singleRune(value, name string) (rune, error) {
+	if value == `\t` || strings.EqualFold(value, "tab") {
+		return '\t', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("CSV %s parameter must be a single character, got %q", name, value)
+	}
+	return runes[0], nil
+}
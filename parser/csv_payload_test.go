@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCSV(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		delimiter rune
+		quote     rune
+		want      [][]string
+		wantErr   bool
+	}{
+		{name: "simple", content: "a,b\n1,2", delimiter: ',', quote: '"', want: [][]string{{"a", "b"}, {"1", "2"}}},
+		{name: "trailing newline", content: "a,b\n1,2\n", delimiter: ',', quote: '"', want: [][]string{{"a", "b"}, {"1", "2"}}},
+		{name: "CRLF line endings", content: "a,b\r\n1,2\r\n", delimiter: ',', quote: '"', want: [][]string{{"a", "b"}, {"1", "2"}}},
+		{name: "blank lines are skipped", content: "a,b\n\n1,2\n\n", delimiter: ',', quote: '"', want: [][]string{{"a", "b"}, {"1", "2"}}},
+		{name: "blank CRLF lines are skipped", content: "a,b\r\n\r\n1,2\r\n", delimiter: ',', quote: '"', want: [][]string{{"a", "b"}, {"1", "2"}}},
+		{name: "quoted empty field is a record", content: "a\n\"\"\n", delimiter: ',', quote: '"', want: [][]string{{"a"}, {""}}},
+		{name: "empty trailing field", content: "a,\n", delimiter: ',', quote: '"', want: [][]string{{"a", ""}}},
+		{name: "quoted delimiter and newline", content: "\"x,y\",\"line1\nline2\"", delimiter: ',', quote: '"', want: [][]string{{"x,y", "line1\nline2"}}},
+		{name: "escaped quote", content: `"say ""hi"""`, delimiter: ',', quote: '"', want: [][]string{{`say "hi"`}}},
+		{name: "custom delimiter and quote", content: "1;'a;b'\n2;c", delimiter: ';', quote: '\'', want: [][]string{{"1", "a;b"}, {"2", "c"}}},
+		{name: "empty content", content: "", delimiter: ',', quote: '"', want: nil},
+		{name: "unterminated quote", content: "\"abc", delimiter: ',', quote: '"', wantErr: true},
+		{name: "quote inside unquoted field", content: "ab\"c", delimiter: ',', quote: '"', wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitCSV(tt.content, tt.delimiter, tt.quote)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVPayloadQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     bool
+	}{
+		{name: "record count", content: "a,b\n1,2\n\n", contentType: "text/csv", expression: "csv:#", want: float64(1)},
+		{name: "field by header", content: "id,name\n1,\"Doe, J\"\n", contentType: "text/csv", expression: "csv:0.name", want: "Doe, J"},
+		{name: "column", content: "id,amount\n1,10.5\n2,3\n", contentType: "text/csv", expression: "csv:#.amount", want: []interface{}{"10.5", "3"}},
+		{name: "no header", content: "1,x\n2,y\n", contentType: "text/csv; header=absent", expression: "csv:1.1", want: "y"},
+		{name: "quoted delimiter parameter", content: "a;b\n1;2\n", contentType: `text/csv; delimiter=";"`, expression: "csv:0.b", want: "2"},
+		{name: "tab delimiter", content: "a\tb\n1\t2\n", contentType: `text/csv; delimiter="\t"`, expression: "csv:0.b", want: "2"},
+		{name: "unquoted delimiter parameter is rejected", content: "a;b\n1;2\n", contentType: "text/csv; header=absent; delimiter=;", expression: "csv:#", wantErr: true},
+		{name: "invalid header parameter", content: "a\n", contentType: "text/csv; header=maybe", expression: "csv:#", wantErr: true},
+		{name: "jsonpath is rejected", content: "a\n1\n", contentType: "text/csv", expression: "jsonpath:0.a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(tt.content), tt.contentType, NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVToJSONPipe(t *testing.T) {
+	msgCtx := NewMessageContext([]byte(`{"export":"sku,qty\nA,1\nB,2"}`), "application/json", NewEngine())
+	result, err := msgCtx.EvaluateExpression("jsonpath:export | csvToJSON | jsonpath:1.sku")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Value != "B" {
+		t.Errorf("got %v, want B", result.Value)
+	}
+}
+
+func TestExtractAsCSVPipe(t *testing.T) {
+	body := []byte(`{"export":"sku,qty\nA,1\nB,2","semicolons":"A;1\nB;2","report":"<r><rows>sku:qty&#10;C:3</rows></r>"}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "header row", expression: "jsonpath:export | extractAsCSV | csv:1.sku", want: "B"},
+		{name: "record count", expression: "jsonpath:export | extractAsCSV() | csv:#", want: float64(2)},
+		{name: "delimiter without header", expression: `jsonpath:semicolons | extractAsCSV(";", false) | csv:1.1`, want: "2"},
+		{name: "then json", expression: "jsonpath:export | extractAsCSV | csv:#.qty | extractAsJSON | jsonpath:1", want: "2"},
+		{name: "from xml", expression: `jsonpath:report | extractAsXML | xpath:/r/rows/text() | extractAsCSV(":") | csv:0.qty`, want: "3"},
+		{name: "delimiter not a string", expression: "jsonpath:export | extractAsCSV(1)", wantErr: "must be a delimiter string"},
+		{name: "header not a boolean", expression: `jsonpath:export | extractAsCSV(",", "no")`, wantErr: "must be true or false"},
+		{name: "delimiter too long", expression: `jsonpath:export | extractAsCSV(";;")`, wantErr: "failed to create intermediate CSV payload"},
+		{name: "too many arguments", expression: `jsonpath:export | extractAsCSV(",", true, 1)`, wantErr: "takes 0 to 2 arguments, got 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
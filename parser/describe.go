@@ -0,0 +1,288 @@
+package parser
+
+import "sort"
+
+// EngineDescription is machine-readable metadata about what an engine can
+// evaluate, e.g. for building expression editors or generating reference
+// documentation. It marshals to JSON.
+type EngineDescription struct {
+	Languages    []LanguageInfo    `json:"languages"`
+	Pipes        []PipeInfo        `json:"pipes"`
+	Functions    []FunctionInfo    `json:"functions"`
+	PayloadTypes []PayloadTypeInfo `json:"payloadTypes"`
+}
+
+// LanguageInfo describes an expression language.
+type LanguageInfo struct {
+	Prefix      string   `json:"prefix"`
+	Description string   `json:"description"`
+	Payloads    []string `json:"payloads"` // Go types of the payloads it queries; empty if it works on any payload
+	Example     string   `json:"example"`
+}
+
+// PipeInfo describes a pipe and its signature.
+type PipeInfo struct {
+	Name          string      `json:"name"`
+	Description   string      `json:"description"`
+	Input         ValueKind   `json:"input"`
+	Output        ValueKind   `json:"output"`
+	OutputPayload PayloadKind `json:"outputPayload,omitempty"` // Payload the next stages query; empty if unchanged
+	Example       string      `json:"example"`
+}
+
+// FunctionInfo describes a named query of a language, or a computed field
+// registered on the engine.
+type FunctionInfo struct {
+	Language    string    `json:"language"` // Prefix of the language
+	Name        string    `json:"name"`
+	Signature   string    `json:"signature"` // How it is written; the expression of a computed field
+	Description string    `json:"description"`
+	Output      ValueKind `json:"output"`
+	Example     string    `json:"example"`
+}
+
+// PayloadTypeInfo describes a payload type and the content types that create it.
+type PayloadTypeInfo struct {
+	Name         string      `json:"name"` // Go type
+	Kind         PayloadKind `json:"kind,omitempty"`
+	ContentTypes []string    `json:"contentTypes"`
+	Languages    []string    `json:"languages"`
+}
+
+// languageInfos lists the languages of evaluateSingleExpression, in the order of queryPrefixes.
+var languageInfos = []LanguageInfo{
+	{xpathPrefix, "XPath 1.0 over XML, SOAP and HTML documents", []string{"XMLPayload", "SOAPPayload", "HTMLPayload"}, "xpath:/order/id/text()"},
+	{jsonpathPrefix, "GJSON path over JSON and documents with a JSON view", []string{"JSONPayload", "NDJSONPayload", "TOMLPayload", "FormPayload", "JWTPayload", "FixedWidthPayload", "LogPayload", "AvroPayload", "MessagePackPayload", "CBORPayload"}, "jsonpath:order.items.#.sku"},
+	{yamlpathPrefix, "GJSON path over the JSON view of YAML documents", []string{"YAMLPayload"}, "yamlpath:spec.replicas"},
+	{csvPrefix, "Row and column selection in CSV tables", []string{"CSVPayload"}, "csv:0.name"},
+	{protopathPrefix, "GJSON path over the JSON mapping of protobuf messages", []string{"ProtobufPayload"}, "protopath:items.0.sku"},
+	{frontmatterPrefix, "Path into the front matter of a document", []string{"TextPayload", "MarkdownPayload"}, "frontmatter:title"},
+	{bodyPrefix, "The body of a front-matter document", []string{"TextPayload", "MarkdownPayload"}, "body:"},
+	{markdownPrefix, "Headings, sections, tables, links and code blocks of Markdown", []string{"MarkdownPayload"}, "md:heading('Pricing').table[0]"},
+	{cssPrefix, "CSS selectors over HTML documents", []string{"HTMLPayload"}, "css:div.price"},
+	{textPrefix, "The text, or its lines; the previous result after a pipe", []string{"TextPayload"}, "text:lines[0]"},
+	{regexPrefix, "The first match of a Go regular expression; the previous result after a pipe", []string{"TextPayload"}, `regex:order (\d+)`},
+	{ndjsonPrefix, "Records of newline-delimited JSON", []string{"NDJSONPayload"}, "ndjson:record(0).id"},
+	{partPrefix, "Selects a multipart part and queries it in the next stages", []string{"MultipartPayload"}, "part:metadata | jsonpath:id"},
+	{formPrefix, "Field path over URL-encoded forms", []string{"FormPayload"}, "form:items[0].qty"},
+	{computedPrefix, "A computed field registered on the engine or message", nil, "computed:total"},
+	{propPrefix, "Key or prefix lookup in Java properties and .env files", []string{"PropertiesPayload"}, "prop:db.*"},
+	{bytesPrefix, "Byte ranges, length and digests of binary content", []string{"BinaryPayload"}, "bytes:range(0,4)"},
+	{parquetPrefix, "Rows, row groups and column values of Parquet files", []string{"ParquetPayload"}, `parquet:rows(0..10).col("amount")`},
+	{jwtPrefix, "GJSON path over the header, claims and verification of a JWT", []string{"JWTPayload"}, "jwt:claims.sub"},
+	{fixedPrefix, "GJSON path over the records of a fixed-width file, by field name", []string{"FixedWidthPayload"}, "fixed:0.CUST-ID"},
+	{logPrefix, "GJSON path over the entries of syslog (RFC 5424) or logfmt lines", []string{"LogPayload"}, `log:#(severityName=="err")#.message`},
+}
+
+// pipeInfos lists the pipes of evaluateStage.
+var pipeInfos = []struct {
+	name, description, example string
+}{
+	{extractAsJSONPipe, "Parses the previous result as JSON", "xpath:/a/json/text() | extractAsJSON | jsonpath:id"},
+	{extractAsXMLPipe, "Parses the previous result as XML", "jsonpath:note | extractAsXML | xpath:/note/to"},
+	{extractAsYAMLPipe, "Parses the previous result as YAML", "jsonpath:manifest | extractAsYAML | yamlpath:kind"},
+	{extractAutoPipe, "Parses the previous result as JSON, XML or YAML, whichever it looks like, decoding it first if it is base64 of one of them", "jsonpath:note | extractAuto | xpath:/note/to"},
+	{csvToJSONPipe, "Converts a CSV result to an array of JSON records", "jsonpath:report | csvToJSON | jsonpath:0.name"},
+	{jsonToXMLPipe + "([root[, attributePrefix]])", "Converts a JSON result to XML under a root element (\"root\" by default) and continues with it as XML; members named with the attribute prefix (\"@\" by default) become attributes and \"#text\" becomes text", `jsonpath:order | jsonToXml("order") | xpath:/order/id`},
+	{xmlToJSONPipe + "([attributePrefix])", "Converts an XML result to JSON, e.g. {\"order\": {\"@id\": \"7\", \"#text\": \"...\"}}, and continues with it as JSON; repeated elements become arrays", `jsonpath:orderXml | xmlToJson | jsonpath:order.@id`},
+	{c14nPipe + "([inclusivePrefixes])", "Converts an XML result to exclusive canonical XML (without comments), e.g. before signing or hashing it; inclusivePrefixes is the space-separated InclusiveNamespaces PrefixList", `jsonpath:signedInfo | c14n`},
+	{prettyPrintPipe + "([indent])", "Indents a JSON or XML result, by two spaces unless indent is a string or a number of spaces", "jsonpath:order | prettyPrint"},
+	{minifyPipe, "Removes insignificant whitespace from a JSON or XML result, and comments from XML", "jsonpath:orderXml | minify"},
+	{extractAsCSVPipe + "([delimiter[, header]])", "Parses the previous result as CSV, with a header row unless header is false", `jsonpath:export | extractAsCSV(";") | csv:#.sku`},
+	{hexPipe, "Hex-encodes bytes or a string", "bytes:range(0,4) | hex"},
+	{toHexPipe, "Same as hex", "jsonpath:payload | sha256 | toHex"},
+	{base64EncodePipe, "Base64-encodes bytes or a string", "bytes:all | base64Encode"},
+	{sha256Pipe, "Computes the SHA-256 digest of the previous result, as bytes; arrays and objects are hashed as JSON", "jsonpath:payload | sha256 | toHex"},
+	{sha1Pipe, "Computes the SHA-1 digest of the previous result, as bytes", "jsonpath:payload | sha1 | hex"},
+	{md5Pipe, "Computes the MD5 digest of the previous result, as bytes", "jsonpath:payload | md5 | base64Encode"},
+	{hmacSHA256Pipe + "(keyRef)", "Computes the HMAC-SHA256 of the previous result, as bytes, with the key registered as keyRef", `jsonpath:payload | hmacSHA256("webhook") | hex`},
+	{hmacSHA1Pipe + "(keyRef)", "Computes the HMAC-SHA1 of the previous result, as bytes, with the key registered as keyRef", `jsonpath:payload | hmacSHA1("partner") | base64Encode`},
+	{aesEncryptPipe + "(keyRef[, additionalData])", "Encrypts the previous result with AES-GCM and the key registered as keyRef; the result is the nonce, ciphertext and tag, as bytes", `jsonpath:card | aesEncrypt("cards") | base64Encode`},
+	{aesDecryptPipe + "(keyRef[, additionalData])", "Decrypts the output of aesEncrypt; the result is a string when it is UTF-8 text and bytes otherwise", `jsonpath:secret | base64Decode | aesDecrypt("cards") | extractAsJSON | jsonpath:number`},
+	{crc32Pipe, "Computes the CRC-32 (IEEE) checksum of the previous result, as 4 big-endian bytes", "jsonpath:payload | crc32 | hex"},
+	{base64DecodePipe, "Decodes standard or URL-safe base64, padded or not; a string if the result is UTF-8 text, bytes otherwise", "jsonpath:doc.content | base64Decode | extractAsXML | xpath:/invoice/total"},
+	{gunzipPipe, "Decompresses gzip data; the result is a string when it is UTF-8 text and bytes otherwise", "jsonpath:blob | base64Decode | gunzip | extractAsJSON | jsonpath:id"},
+	{inflatePipe, "Decompresses zlib or raw DEFLATE data; the result is a string when it is UTF-8 text and bytes otherwise", "jsonpath:blob | base64Decode | inflate"},
+	{trimPipe + "([cutset])", "Removes surrounding whitespace, or the characters of cutset, from the previous result", `jsonpath:customer.name | trim`},
+	{upperPipe, "Converts the previous result to upper case", "jsonpath:country | upper"},
+	{lowerPipe, "Converts the previous result to lower case", "jsonpath:email | trim | lower"},
+	{replacePipe + "(old, new)", "Replaces every occurrence of old in the previous result with new", `jsonpath:sku | replace("_", "-")`},
+	{regexReplacePipe + "(pattern, replacement)", "Replaces every match of a Go regular expression in the previous result; the replacement can refer to capture groups as $1 or ${name}", `jsonpath:phone | regexReplace("^\\+44\\s*", "0")`},
+	{splitPipe + "(separator)", "Splits the previous result around each separator into an array of strings", `jsonpath:tags | split(",")`},
+	{joinPipe + "(separator)", "Joins the elements of an array result with separator; elements that are not strings are written as JSON", `jsonpath:tags | split(",") | join("-")`},
+	{urlEncodePipe + `(["query" | "path"])`, "Percent-encodes the previous result for a query string (spaces as '+', the default) or a path segment", "jsonpath:search | urlEncode"},
+	{urlDecodePipe + `(["query" | "path"])`, "Decodes a percent-encoded query string (with '+' as space, the default) or path", "jsonpath:callbackUrl | urlDecode | regex:token=([^&]+)"},
+	{xsltPipe + "(stylesheet)", "Transforms the previous result, an XML document, with the XSLT stylesheet registered as stylesheet; the next stages query the output, parsed by the stylesheet's output media type", `xpath:/invoice | xslt("invoiceToSummary") | xpath:/summary/total`},
+	{joltPipe + "(spec)", "Reshapes the previous result, JSON, with the Jolt shift, default and remove operations of the spec registered as spec; the next stages query the output", `jsonpath:order | jolt("orderToInvoice") | jsonpath:invoice.total`},
+	{extractAsProtobufPipe + "(messageType)", "Decodes the previous result, bytes or base64 text, as a protobuf message of a type from registered descriptors, for protopath: queries; the result is the message in text format", `jsonpath:envelope.body | extractAsProtobuf("com.acme.Order") | protopath:order_id`},
+	{substringPipe + "(start[, end])", "The characters of the previous result from zero-based start to end (exclusive, default the end)", "jsonpath:order.id | substring(0, 3)"},
+	{defaultPipe + "(value)", "Yields value, a string, number or boolean, when the previous stage matches nothing or produces no value, an empty string or an empty array; other results pass through", `jsonpath:customer.nickname | default("N/A")`},
+	{ifPresentPipe, "Runs the next stages only when the previous stage succeeds with a result that is not missing or empty; otherwise the pipeline yields an AbsentResult without an error, or the value of a default pipe after it", "jsonpath:attachment | ifPresent | base64Decode | extractAsJSON | jsonpath:id"},
+	{ifEmptyPipe, "Ends the pipeline with the previous result when it is present; when the previous stage matches nothing or produces no value, an empty string or an empty array, runs the next stages instead, passing them an empty string", "jsonpath:customer.nickname | ifEmpty | jsonpath:customer.name"},
+	{toNumberPipe, "Converts a numeric string (surrounding whitespace allowed) or a boolean (1 or 0) to a number", "jsonpath:order.total | toNumber"},
+	{toIntPipe, "Like toNumber, then truncates toward zero; the result is a number without a fraction", "xpath:/order/qty/text() | toInt"},
+	{toBooleanPipe, `Converts "true", "yes", "on", "1" and "false", "no", "off", "0" (in any case) or a number (zero is false) to a boolean`, "xpath:/order/gift/text() | toBoolean"},
+	{toStringPipe, "Converts the previous result to a string: numbers in their shortest form, booleans as true or false, arrays and objects as JSON", "jsonpath:order.id | toString"},
+	{mapPipe + "(expression)", "Evaluates expression against each element of an array or XPath node-set and returns the results as an array; XML and HTML elements are the XPath context node, other elements are queried as JSON (the element itself is @this)", "jsonpath:store.book | map(jsonpath:title)"},
+	{filterPipe + "(expression [op literal])", "Keeps the elements of an array or XPath node-set whose sub-expression result compares with literal (==, !=, >, >=, <, <=), or, without a comparison, is present, not empty and not false", "jsonpath:store.book | filter(jsonpath:price > 10) | map(jsonpath:title)"},
+	{sortByPipe + "(expression[, asc | desc])", "Orders the elements of an array or XPath node-set by the result of expression for each: numerically when every key is a number, as text otherwise; elements without a key come last", "jsonpath:store.book | sortBy(jsonpath:price, desc) | map(jsonpath:title)"},
+	{flattenPipe + "([depth])", "Replaces nested arrays in an array or XPath node-set by their elements, all levels deep or depth levels", `jsonpath:store.book.#.tags | flatten | join(",")`},
+	{uniquePipe, "Removes repeated elements from an array or XPath node-set, keeping the first of each; elements are equal when their JSON encodings are", "jsonpath:order.lines.#.customerId | unique"},
+	{dedupePipe, "Same as unique", "xpath://line/customer/@id | dedupe"},
+	{slicePipe + "(start[, end])", "The elements of an array or XPath node-set from zero-based start to end (exclusive, default the end)", "jsonpath:results | slice(5, 15)"},
+	{limitPipe + "(n)", "The first n elements of an array or XPath node-set", "jsonpath:store.book | sortBy(jsonpath:price, desc) | limit(3)"},
+	{offsetPipe + "(n)", "The elements of an array or XPath node-set after the first n", "jsonpath:results | offset(20) | limit(10)"},
+	{firstPipe, "The first element of an array or XPath node-set; an empty one fails, unless default follows", "jsonpath:store.book | filter(jsonpath:price < 10) | first | extractAsJSON | jsonpath:title"},
+	{lastPipe, "The last element of an array or XPath node-set; an empty one fails, unless default follows", `xpath://event | sortBy(xpath:@at) | last | default("none")`},
+	{fixedWidthToJSONPipe + "<layout>", "Converts fixed-width records to an array of JSON records using a registered layout", "xpath:/batch/records/text() | fixedWidthToJSON:customer | jsonpath:#.CUST-ID"},
+}
+
+// functionInfos lists the named queries of languages.
+var functionInfos = []FunctionInfo{
+	{Language: xpathPrefix, Name: "doc", Signature: "doc(n)[/path]", Description: "The document at one-based index n of a multi-document XML payload, or a location path within it", Example: "xpath:doc(2)//id"},
+	{Language: textPrefix, Name: "lines", Signature: "lines", Description: "All lines, as an array", Example: "text:lines"},
+	{Language: textPrefix, Name: "lines", Signature: "lines[i]", Description: "The line at zero-based index i", Example: "text:lines[0]"},
+	{Language: ndjsonPrefix, Name: "count", Signature: "count", Description: "The number of records", Example: "ndjson:count"},
+	{Language: ndjsonPrefix, Name: "record", Signature: "record(i)[.path]", Description: "The record at zero-based index i, or a gjson path within it", Example: "ndjson:record(0).id"},
+	{Language: ndjsonPrefix, Name: "map", Signature: "map(path)", Description: "A gjson path evaluated on every record, as an array", Example: "ndjson:map(id)"},
+	{Language: bytesPrefix, Name: "all", Signature: "all", Description: "The whole content", Example: "bytes:all"},
+	{Language: bytesPrefix, Name: "range", Signature: "range(start[,end])", Description: "Bytes start (inclusive) to end (exclusive, default the end of the content)", Example: "bytes:range(0,4)"},
+	{Language: bytesPrefix, Name: "length", Signature: "length", Description: "The number of bytes", Example: "bytes:length"},
+	{Language: bytesPrefix, Name: "md5", Signature: "md5", Description: "The hex-encoded MD5 digest", Example: "bytes:md5"},
+	{Language: bytesPrefix, Name: "sha1", Signature: "sha1", Description: "The hex-encoded SHA-1 digest", Example: "bytes:sha1"},
+	{Language: bytesPrefix, Name: "sha256", Signature: "sha256", Description: "The hex-encoded SHA-256 digest", Example: "bytes:sha256"},
+	{Language: parquetPrefix, Name: "rowGroup", Signature: "rowGroup(i)", Description: "The rows of row group i", Example: "parquet:rowGroup(0).count"},
+	{Language: parquetPrefix, Name: "rows", Signature: "rows(a..b)", Description: "Rows a (inclusive) to b (exclusive), as objects; rows(i) for one row", Example: "parquet:rows(0..10)"},
+	{Language: parquetPrefix, Name: "col", Signature: `col("name")`, Description: "The values of a column", Example: `parquet:col("amount")`},
+	{Language: parquetPrefix, Name: "count", Signature: "count", Description: "The number of rows", Example: "parquet:count"},
+	{Language: parquetPrefix, Name: "columns", Signature: "columns", Description: "The column names", Example: "parquet:columns"},
+	{Language: parquetPrefix, Name: "schema", Signature: "schema", Description: "The columns with their types", Example: "parquet:schema"},
+	{Language: parquetPrefix, Name: "rowGroups", Signature: "rowGroups", Description: "The row groups, with their first row and row count", Example: "parquet:rowGroups"},
+}
+
+// payloadTypeInfos lists the payloads of PayloadFactory.CreatePayload.
+var payloadTypeInfos = []PayloadTypeInfo{
+	{"XMLPayload", PayloadXML, []string{"application/xml", "text/xml", "application/*+xml"}, []string{xpathPrefix}},
+	{"SOAPPayload", PayloadXML, []string{"text/xml", "application/soap+xml"}, []string{xpathPrefix}},
+	{"HTMLPayload", PayloadXML, []string{"text/html"}, []string{xpathPrefix, cssPrefix}},
+	{"JSONPayload", PayloadJSON, []string{"application/json", "application/*+json"}, []string{jsonpathPrefix}},
+	{"NDJSONPayload", PayloadJSON, []string{"application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines"}, []string{ndjsonPrefix, jsonpathPrefix}},
+	{"YAMLPayload", PayloadYAML, []string{"application/yaml", "text/yaml", "application/x-yaml"}, []string{yamlpathPrefix}},
+	{"TOMLPayload", PayloadJSON, []string{"application/toml", "application/x-toml"}, []string{jsonpathPrefix}},
+	{"PropertiesPayload", PayloadUnknown, []string{"text/x-java-properties", "text/x-properties", "text/x-dotenv", "application/x-dotenv"}, []string{propPrefix}},
+	{"MarkdownPayload", PayloadUnknown, []string{"text/markdown", "text/x-markdown"}, []string{markdownPrefix, frontmatterPrefix, bodyPrefix}},
+	{"TextPayload", PayloadText, []string{"text/plain"}, []string{textPrefix, regexPrefix, frontmatterPrefix, bodyPrefix}},
+	{"CSVPayload", PayloadCSV, []string{"text/csv"}, []string{csvPrefix}},
+	{"ProtobufPayload", PayloadUnknown, []string{"application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf", "text/x-protobuf", "application/x-protobuf-text", "application/x-protobuf+json", "application/protobuf+json"}, []string{protopathPrefix}},
+	{"AvroPayload", PayloadJSON, []string{"avro/binary", "application/avro"}, []string{jsonpathPrefix}},
+	{"MessagePackPayload", PayloadJSON, []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, []string{jsonpathPrefix}},
+	{"CBORPayload", PayloadJSON, []string{"application/cbor"}, []string{jsonpathPrefix}},
+	{"BinaryPayload", PayloadBinary, []string{"application/octet-stream"}, []string{bytesPrefix}},
+	{"JWTPayload", PayloadJSON, []string{"application/jwt"}, []string{jwtPrefix, jsonpathPrefix}},
+	{"FixedWidthPayload", PayloadJSON, []string{"text/x-fixed-width"}, []string{fixedPrefix, jsonpathPrefix}},
+	{"LogPayload", PayloadJSON, []string{"text/x-syslog", "application/x-syslog", "text/x-logfmt", "application/x-logfmt"}, []string{logPrefix, jsonpathPrefix}},
+	{"ParquetPayload", PayloadUnknown, []string{"application/vnd.apache.parquet", "application/x-parquet"}, []string{parquetPrefix}},
+	{"FormPayload", PayloadJSON, []string{"application/x-www-form-urlencoded"}, []string{formPrefix, jsonpathPrefix}},
+	{"MultipartPayload", PayloadMultipart, []string{"multipart/form-data", "multipart/related"}, []string{partPrefix}},
+}
+
+// Describe returns metadata about the languages, pipes, functions and payload
+// types of the engine. Pipes registered with RegisterPipe are listed after
+// the built-in ones, without an example, and computed fields registered with
+// RegisterComputedField are listed as functions of "computed:". Pipe
+// signatures and function outputs are the ones Compile checks.
+func (ee *ExpressionEngine) Describe() EngineDescription {
+	desc := EngineDescription{
+		Languages:    append([]LanguageInfo(nil), languageInfos...),
+		PayloadTypes: append([]PayloadTypeInfo(nil), payloadTypeInfos...),
+	}
+	for _, p := range pipeInfos {
+		sig := signatureOf(p.name, 1)
+		info := PipeInfo{Name: p.name, Description: p.description, Input: sig.input, Output: sig.output, Example: p.example}
+		if !sig.keepsPayload {
+			info.OutputPayload = sig.outputPayload
+		}
+		desc.Pipes = append(desc.Pipes, info)
+	}
+	for _, f := range functionInfos {
+		f.Output = signatureOf(f.Example, 0).output
+		desc.Functions = append(desc.Functions, f)
+	}
+
+	var registered []PipeInfo
+	ee.pipes.Range(func(key, _ interface{}) bool {
+		sig := registeredPipeSignature
+		registered = append(registered, PipeInfo{Name: key.(string), Description: "Registered pipe", Input: sig.input, Output: sig.output})
+		return true
+	})
+	sort.Slice(registered, func(i, j int) bool { return registered[i].Name < registered[j].Name })
+	desc.Pipes = append(desc.Pipes, registered...)
+
+	var languages []LanguageInfo
+	ee.languages.Range(func(key, _ interface{}) bool {
+		prefix := key.(string)
+		languages = append(languages, LanguageInfo{Prefix: prefix, Description: "Registered language", Payloads: []string{}, Example: prefix})
+		return true
+	})
+	sort.Slice(languages, func(i, j int) bool { return languages[i].Prefix < languages[j].Prefix })
+	desc.Languages = append(desc.Languages, languages...)
+
+	var computed []FunctionInfo
+	ee.computedFields.Range(func(key, value interface{}) bool {
+		name, expression := key.(string), value.(string)
+		computed = append(computed, FunctionInfo{
+			Language: computedPrefix, Name: name, Signature: expression,
+			Description: "Computed field", Output: ee.computedOutputKind(expression), Example: computedPrefix + name,
+		})
+		return true
+	})
+	sort.Slice(computed, func(i, j int) bool { return computed[i].Name < computed[j].Name })
+	desc.Functions = append(desc.Functions, computed...)
+
+	var named []FunctionInfo
+	if expressions := ee.expressions.Load(); expressions != nil {
+		for name, expression := range *expressions {
+			named = append(named, FunctionInfo{
+				Language: referencePrefix, Name: name, Signature: expression,
+				Description: "Registered expression", Output: ee.computedOutputKind(expression), Example: referencePrefix + name,
+			})
+		}
+	}
+	sort.Slice(named, func(i, j int) bool { return named[i].Name < named[j].Name })
+	desc.Functions = append(desc.Functions, named...)
+
+	var xpathFunctions []FunctionInfo
+	ee.xpathFunctions.Range(func(key, _ interface{}) bool {
+		call := xpathFunctionPrefix + key.(string) + "(...)"
+		xpathFunctions = append(xpathFunctions, FunctionInfo{
+			Language: xpathPrefix, Name: key.(string), Signature: call,
+			Description: "Registered XPath function", Output: KindString | KindNumber | KindBoolean, Example: xpathPrefix + call,
+		})
+		return true
+	})
+	sort.Slice(xpathFunctions, func(i, j int) bool { return xpathFunctions[i].Name < xpathFunctions[j].Name })
+	desc.Functions = append(desc.Functions, xpathFunctions...)
+
+	var modifiers []FunctionInfo
+	ee.jsonModifiers.Range(func(key, _ interface{}) bool {
+		modifier := "@" + key.(string)
+		modifiers = append(modifiers, FunctionInfo{
+			Language: jsonpathPrefix, Name: key.(string), Signature: modifier + "[:arg]",
+			Description: "Registered JSONPath modifier", Output: KindAny, Example: jsonpathPrefix + "value." + modifier,
+		})
+		return true
+	})
+	sort.Slice(modifiers, func(i, j int) bool { return modifiers[i].Name < modifiers[j].Name })
+	desc.Functions = append(desc.Functions, modifiers...)
+	return desc
+}
+
+// computedOutputKind is the result kind of a computed field expression, or
+// KindAny when it does not type check.
+func (ee *ExpressionEngine) computedOutputKind(expression string) ValueKind {
+	compiled, err := ee.Compile(expression)
+	if err != nil {
+		return KindAny
+	}
+	return compiled.ResultKind()
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDescribeCoversEngine(t *testing.T) {
+	desc := NewEngine().Describe()
+
+	languages := make(map[string]bool)
+	for _, l := range desc.Languages {
+		languages[l.Prefix] = true
+	}
+	for _, prefix := range queryPrefixes {
+		if !languages[prefix] {
+			t.Errorf("language %s is not described", prefix)
+		}
+	}
+
+	factory := NewPayloadFactory()
+	for _, p := range desc.PayloadTypes {
+		for _, contentType := range p.ContentTypes {
+			_, err := factory.CreatePayload(nil, contentType)
+			var unsupported *ErrUnsupportedContentType
+			if errors.As(err, &unsupported) {
+				t.Errorf("%s lists unsupported content type %s", p.Name, contentType)
+			}
+		}
+	}
+}
+
+func TestDescribeExamplesCompile(t *testing.T) {
+	engine := NewEngine()
+	desc := engine.Describe()
+
+	var examples []string
+	for _, l := range desc.Languages {
+		examples = append(examples, l.Example)
+	}
+	for _, p := range desc.Pipes {
+		examples = append(examples, p.Example)
+	}
+	for _, f := range desc.Functions {
+		examples = append(examples, f.Example)
+	}
+	for _, example := range examples {
+		t.Run(example, func(t *testing.T) {
+			if _, err := engine.Compile(example); err != nil {
+				t.Errorf("example does not compile: %v", err)
+			}
+		})
+	}
+}
+
+func TestDescribeSignatures(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterComputedField("orderCount", "xpath:count(//order)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterXPathFunction("uuid", func([]interface{}) (interface{}, error) { return "id", nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterJSONPathModifier("maskPAN", func(json, _ string) string { return json }); err != nil {
+		t.Fatal(err)
+	}
+	desc := engine.Describe()
+
+	pipes := make(map[string]PipeInfo)
+	for _, p := range desc.Pipes {
+		pipes[p.Name] = p
+	}
+	functions := make(map[string]FunctionInfo)
+	for _, f := range desc.Functions {
+		functions[f.Language+f.Signature] = f
+	}
+
+	tests := []struct {
+		name string
+		got  ValueKind
+		want ValueKind
+	}{
+		{name: "extractAsXML input", got: pipes[extractAsXMLPipe].Input, want: KindString},
+		{name: "hex input", got: pipes[hexPipe].Input, want: KindString | KindBytes},
+		{name: "base64Encode output", got: pipes[base64EncodePipe].Output, want: KindString},
+		{name: "substring input", got: pipes["substring(start[, end])"].Input, want: KindString},
+		{name: "bytes range output", got: functions["bytes:range(start[,end])"].Output, want: KindBytes},
+		{name: "bytes length output", got: functions["bytes:length"].Output, want: KindNumber},
+		{name: "text lines output", got: functions["text:lines"].Output, want: KindArray},
+		{name: "computed field output", got: functions["computed:xpath:count(//order)"].Output, want: KindNumber},
+		{name: "XPath function output", got: functions["xpath:pp:uuid(...)"].Output, want: KindString | KindNumber | KindBoolean},
+		{name: "JSONPath modifier output", got: functions["jsonpath:@maskPAN[:arg]"].Output, want: KindAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %s, want %s", tt.got, tt.want)
+			}
+		})
+	}
+	if got := pipes[extractAsJSONPipe].OutputPayload; got != PayloadJSON {
+		t.Errorf("extractAsJSON output payload: got %q", got)
+	}
+	if got := pipes[hexPipe].OutputPayload; got != PayloadUnknown {
+		t.Errorf("hex output payload: got %q", got)
+	}
+}
+
+func TestDescribeJSON(t *testing.T) {
+	data, err := json.Marshal(NewEngine().Describe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"prefix":"bytes:"`,
+		`"name":"hex","description":"Hex-encodes bytes or a string","input":"string|bytes","output":"string"`,
+		`"name":"BinaryPayload","kind":"binary-payload","contentTypes":["application/octet-stream"]`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("JSON does not contain %s", want)
+		}
+	}
+}
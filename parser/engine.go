@@ -1,126 +1,1037 @@
 package parser
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 const (
 	xpathPrefix       = "xpath:"
 	jsonpathPrefix    = "jsonpath:"
+	yamlpathPrefix    = "yamlpath:"
+	csvPrefix         = "csv:"
+	protopathPrefix   = "protopath:"
+	frontmatterPrefix = "frontmatter:"
+	bodyPrefix        = "body:"
+	markdownPrefix    = "md:"
+	cssPrefix         = "css:"
+	textPrefix        = "text:"
+	regexPrefix       = "regex:"
+	ndjsonPrefix      = "ndjson:"
+	partPrefix        = "part:"
+	formPrefix        = "form:"
+	computedPrefix    = "computed:"
+	propPrefix        = "prop:"
+	bytesPrefix       = "bytes:"
+	parquetPrefix     = "parquet:"
+	jwtPrefix         = "jwt:"
+	fixedPrefix       = "fixed:"
+	logPrefix         = "log:"
 	extractAsJSONPipe = "extractAsJSON"
 	extractAsXMLPipe  = "extractAsXML"
+	extractAsYAMLPipe = "extractAsYAML"
+	extractAutoPipe   = "extractAuto" // JSON, XML, YAML or base64 of them, sniffed from the result
+	csvToJSONPipe     = "csvToJSON"
+	extractAsCSVPipe  = "extractAsCSV" // extractAsCSV([delimiter[, header]])
+	jsonToXMLPipe     = "jsonToXml"    // jsonToXml([root[, attributePrefix]])
+	xmlToJSONPipe     = "xmlToJson"    // xmlToJson([attributePrefix])
+	xsltPipe          = "xslt"         // xslt(stylesheet), registered with RegisterStylesheet
+	joltPipe          = "jolt"         // jolt(spec), registered with RegisterJoltSpec
+	c14nPipe          = "c14n"         // c14n([inclusivePrefixes]): exclusive XML canonicalization
+	prettyPrintPipe   = "prettyPrint"  // prettyPrint([indent]) of JSON or XML
+	minifyPipe        = "minify"
+	hexPipe           = "hex"
+	toHexPipe         = "toHex" // Same as hex
+	base64EncodePipe  = "base64Encode"
+	base64DecodePipe  = "base64Decode"
+	gunzipPipe        = "gunzip"
+	inflatePipe       = "inflate" // zlib or raw DEFLATE
+	sha256Pipe        = "sha256"  // Digests of the previous result, as bytes
+	sha1Pipe          = "sha1"
+	md5Pipe           = "md5"
+	crc32Pipe         = "crc32"
+	hmacSHA256Pipe    = "hmacSHA256" // hmacSHA256(keyRef), with a key registered with RegisterKey
+	hmacSHA1Pipe      = "hmacSHA1"   // hmacSHA1(keyRef)
+	aesEncryptPipe    = "aesEncrypt" // aesEncrypt(keyRef[, additionalData]): AES-GCM, the nonce first
+	aesDecryptPipe    = "aesDecrypt" // aesDecrypt(keyRef[, additionalData])
+	urlEncodePipe     = "urlEncode"  // urlEncode(["query" | "path"])
+	urlDecodePipe     = "urlDecode"  // urlDecode(["query" | "path"])
+	trimPipe          = "trim"       // trim([cutset]): surrounding whitespace, or the characters of cutset
+	upperPipe         = "upper"
+	lowerPipe         = "lower"
+	replacePipe       = "replace"      // replace(old, new): every occurrence of old
+	regexReplacePipe  = "regexReplace" // regexReplace(pattern, replacement): replacement may refer to groups as $1
+	splitPipe         = "split"        // split(separator) into an array of strings
+	joinPipe          = "join"         // join(separator) of an array
+	substringPipe     = "substring"    // substring(start[, end]) of the previous result, in characters
+	defaultPipe       = "default"      // default(value) for a missing or empty previous result
+	ifPresentPipe     = "ifPresent"    // Runs the next stages only when the previous result is present
+	ifEmptyPipe       = "ifEmpty"      // Runs the next stages only when the previous result is missing or empty
+	toNumberPipe      = "toNumber"
+	toIntPipe         = "toInt" // A number truncated toward zero
+	toBooleanPipe     = "toBoolean"
+	toStringPipe      = "toString"
+	mapPipe           = "map"     // map(expression) evaluated against each element of an array or node-set
+	filterPipe        = "filter"  // filter(expression [op literal]) keeps the matching elements
+	sortByPipe        = "sortBy"  // sortBy(expression[, asc | desc]) orders the elements by a key
+	flattenPipe       = "flatten" // flatten([depth]) of nested arrays, all levels by default
+	uniquePipe        = "unique"
+	dedupePipe        = "dedupe" // Same as unique
+	slicePipe         = "slice"  // slice(start[, end]) of an array or node-set
+	limitPipe         = "limit"  // limit(n): the first n elements
+	offsetPipe        = "offset" // offset(n): the elements after the first n
+	firstPipe         = "first"
+	lastPipe          = "last"
+	// extractAsProtobuf(messageType) decodes bytes or base64 text with registered descriptors
+	extractAsProtobufPipe = "extractAsProtobuf"
+	// fixedWidthToJSONPipe is followed by a layout name, e.g. "fixedWidthToJSON:customer"
+	fixedWidthToJSONPipe = "fixedWidthToJSON:"
 )
 
 // ExpressionEngine parses and evaluates expressions against payloads.
+//
+// An engine is safe for concurrent use: one engine can evaluate expressions
+// against any number of payloads and message contexts in parallel, and its
+// Register and Set methods may be called while evaluations are running.
+// Evaluations that are already running may or may not see such changes.
 type ExpressionEngine struct {
-	// Potentially cache compiled expressions if expressions are often reused
-	// For PoC, we re-evaluate prefixes each time.
-	payloadFactory *PayloadFactory // To create intermediate payloads for mixed content
+	payloadFactory  *PayloadFactory  // To create intermediate payloads for mixed content
+	expressionCache *expressionCache // Parsed pipelines and compiled queries (see SetExpressionCacheSize)
+
+	// Timeouts are stored as nanoseconds so they can be changed while other
+	// goroutines evaluate expressions. 0 = unlimited.
+	evaluationTimeout atomic.Int64 // Max duration of a whole Evaluate call
+	stageTimeout      atomic.Int64 // Max duration of a single pipeline stage
+
+	fallback atomic.Pointer[ExpressionEngine] // Receives expressions and pipes this engine does not understand
+	recorder atomic.Pointer[Recorder]         // Captures evaluations when set
+	metrics  atomic.Pointer[MetricsSink]      // Receives measurements when set (see SetMetricsSink)
+	logger   atomic.Pointer[Logger]           // Receives diagnostic messages when set (see SetLogger)
+
+	jsonPathDualRun atomic.Pointer[DualRunOptions] // Verifies jsonpath expressions against a standard JSONPath evaluator
+
+	computedFields sync.Map // Computed field name -> expression (see RegisterComputedField)
+	pipes          sync.Map // Pipe name -> PipeFunc (see RegisterPipe)
+	languages      sync.Map // Language prefix -> EvaluatorFunc (see RegisterLanguage)
+	keys           sync.Map // Key reference -> secret key (see RegisterKey)
+	stylesheets    sync.Map // Stylesheet name -> *sync.Pool of *stylesheet (see RegisterStylesheet)
+	joltSpecs      sync.Map // Jolt spec name -> []joltOperation (see RegisterJoltSpec)
+	xpathFunctions sync.Map // XPath function name -> XPathFunc (see RegisterXPathFunction)
+	jsonModifiers  sync.Map // JSONPath modifier name -> JSONPathModifierFunc (see RegisterJSONPathModifier)
+
+	keyConvention atomic.Pointer[KeyConvention] // Converts keys of jsonpath and yamlpath expressions when set
+
+	expressions     atomic.Pointer[map[string]string] // Expression name -> expression, replaced as a whole (see RegisterExpression, SetExpressions)
+	expressionsLock sync.Mutex                        // Serializes changes of expressions
+
+	limits          atomic.Pointer[EngineLimits]    // Resource limits of evaluations (see SetLimits)
+	lenient         atomic.Bool                     // Queries that match nothing yield AbsentResult (see SetStrictMode)
+	xpathNamespaces atomic.Pointer[xpathNamespaces] // Prefixes of XPath expressions (see SetXPathNamespaces)
 }
 
-func NewEngine() *ExpressionEngine {
-	return &ExpressionEngine{
-		payloadFactory: NewPayloadFactory(),
+// NewEngine creates an engine configured by opts, e.g.
+// NewEngine(WithCache(4096), WithLimits(EngineLimits{MaxStages: 16})).
+func NewEngine(opts ...Option) *ExpressionEngine {
+	ee := &ExpressionEngine{
+		payloadFactory:  NewPayloadFactory(),
+		expressionCache: newExpressionCache(DefaultExpressionCacheSize),
 	}
+	ee.expressionCache.onLookup = ee.reportCacheLookup
+	ee.expressionCache.onEvict = ee.logCacheEviction
+	for _, opt := range opts {
+		opt(ee)
+	}
+	return ee
 }
 
 // Evaluate processes the full expression string, handling prefixes and pipes.
 func (ee *ExpressionEngine) Evaluate(currentPayload PayloadObject, fullExpression string) (QueryResult, error) {
-	parts := strings.Split(fullExpression, "|")
+	return ee.EvaluateContext(context.Background(), currentPayload, fullExpression)
+}
+
+// EvaluateContext is like Evaluate but stops when ctx is done. The context is
+// checked between pipeline stages and passed to payloads that can observe it
+// during a query (e.g. XPath node-set iteration or the walk over a JSON
+// array or object result). An exceeded deadline is reported as ErrTimeout.
+func (ee *ExpressionEngine) EvaluateContext(ctx context.Context, currentPayload PayloadObject, fullExpression string) (QueryResult, error) {
+	return ee.evaluateRecorded(ctx, currentPayload, ee.parse(fullExpression))
+}
+
+// evaluateRecorded evaluates a parsed expression and reports the evaluation
+// to the recorder and the MetricsSink of the engine, if any.
+func (ee *ExpressionEngine) evaluateRecorded(ctx context.Context, currentPayload PayloadObject, expression *Expression) (QueryResult, error) {
+	ctx = withComputedScope(ctx, nil, currentPayload)
+	ctx = ee.withEvaluationBudget(ctx)
+	if err := checkLimit("MaxPayloadBytes", evaluationLimits(ctx).MaxPayloadBytes, len(currentPayload.GetRawBytes()), expression.Source); err != nil {
+		return QueryResult{}, err
+	}
+	return ee.observeEvaluation(currentPayload.GetRawBytes(), currentPayload.GetContentType(), "", expression.Source, func() (QueryResult, error) {
+		return ee.runPipeline(ctx, currentPayload, expression)
+	})
+}
+
+// evaluatePipeline evaluates fullExpression without recording.
+func (ee *ExpressionEngine) evaluatePipeline(ctx context.Context, currentPayload PayloadObject, fullExpression string) (QueryResult, error) {
+	return ee.runPipeline(ctx, currentPayload, ee.parse(fullExpression))
+}
+
+// runPipeline evaluates the stages of a parsed expression in turn, each on
+// the result and payload the one before it leaves.
+func (ee *ExpressionEngine) runPipeline(ctx context.Context, currentPayload PayloadObject, expression *Expression) (QueryResult, error) {
+	fullExpression := expression.Source
+	stages, err := ee.expandReferences(expression.Stages, nil)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+	}
+	ctx = ee.withEvaluationBudget(ctx)
+	limits := evaluationLimits(ctx)
+	if err := checkLimit("MaxStages", limits.MaxStages, len(stages), fullExpression); err != nil {
+		return QueryResult{}, err
+	}
+	ctx = withExpressionCache(ctx, ee.expressionCache)
+	ctx = withXPathNamespaces(ctx, ee.xpathNamespaces.Load())
+	ctx = withXPathFunctions(ctx, ee)
 	var currentResult QueryResult
-	var err error
 
 	// Initial payload for the first part of the expression
 	activePayload := currentPayload
 
-	for i, part := range parts {
-		trimmedPart := strings.TrimSpace(part)
-		if i == 0 { // First part is always an expression
-			currentResult, err = ee.evaluateSingleExpression(activePayload, trimmedPart)
-			if err != nil {
-				return QueryResult{}, fmt.Errorf("error in expression part '%s': %w", trimmedPart, err)
-			}
-		} else { // Subsequent parts are transformations or chained expressions
-			// Ensure previous result was a string to be re-parsed
-			prevResultStr, ok := currentResult.Value.(string)
-			if !ok {
-				return QueryResult{}, &ErrEvaluationFailed{
-					Expression: fullExpression,
-					Reason:     fmt.Sprintf("pipe operation '%s' requires string input from previous step, got %T", trimmedPart, currentResult.Value),
-				}
+	startTime := time.Now()
+	if timeout := time.Duration(ee.evaluationTimeout.Load()); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Only the final stage sees how it yields its result
+	finalCtx := ctx
+	if options := finalStageOptions(ctx); options.all || options.sink != nil {
+		ctx = withFinalStage(ctx, finalStage{})
+	}
+
+	lenient := ee.lenient.Load()
+	for i, stage := range stages {
+		if currentResult.Type == AbsentResult && !handlesAbsence(stage) {
+			continue // Only default and conditional pipes use an absent result
+		}
+		stageCtx := ctx
+		if i == len(stages)-1 {
+			stageCtx = finalCtx
+		}
+		traced := traceStage(ctx, fullExpression, i, stage, currentResult)
+		result, payload, err := ee.runStage(stageCtx, activePayload, currentResult, i, stage, fullExpression, startTime)
+		traced(result, err)
+		if err != nil {
+			switch {
+			case defaultReplaces(stages, i, err):
+				// A following default or conditional pipe handles the missing result
+				result, payload = QueryResult{Value: nil, Type: UnknownResult}, activePayload
+			case lenient && errors.Is(err, ErrNoMatch):
+				result, payload = QueryResult{Value: nil, Type: AbsentResult}, activePayload
+			default:
+				return QueryResult{}, err
 			}
+		}
+		if err := checkLimit("MaxResultElements", limits.MaxResultElements, resultElements(result), fullExpression); err != nil {
+			return QueryResult{}, err
+		}
+		currentResult, activePayload = result, payload
+		if isIfEmptyStage(stage) && !isAbsent(currentResult) {
+			break // The present result is the result of the pipeline
+		}
+	}
+	return currentResult, nil
+}
+
+// RegisterDescriptorSet registers a serialized protobuf FileDescriptorSet so that
+// payloads of its message types can be decoded by message contexts using this engine.
+func (ee *ExpressionEngine) RegisterDescriptorSet(raw []byte) error {
+	return ee.payloadFactory.descriptors.RegisterFileDescriptorSetBytes(raw)
+}
+
+// Descriptors returns the protobuf descriptor registry used by the engine.
+func (ee *ExpressionEngine) Descriptors() *DescriptorRegistry {
+	return ee.payloadFactory.descriptors
+}
+
+// RegisterAvroSchema registers an Avro writer schema under name. Payloads reference
+// it through the content type, e.g. "avro/binary; schema=order-v1".
+func (ee *ExpressionEngine) RegisterAvroSchema(name, schema string) error {
+	return ee.payloadFactory.avroSchemas.Register(name, schema)
+}
+
+// SetSchemaRegistry sets the client used to resolve writer schemas of
+// Confluent-framed Avro payloads (content type "avro/binary" without a schema).
+func (ee *ExpressionEngine) SetSchemaRegistry(registry SchemaRegistryClient) {
+	ee.payloadFactory.avroSchemas.SetRegistry(registry)
+}
+
+// RegisterPayloadType makes contexts and pipes of this engine parse payloads
+// of contentType with ctor (see PayloadFactory.Register). Such payloads are
+// queried with the prefix whose content type check they pass, e.g.
+// "jsonpath:" for a payload reporting "application/json", and their Query
+// method evaluates the expression.
+func (ee *ExpressionEngine) RegisterPayloadType(contentType string, ctor func([]byte) (PayloadObject, error)) {
+	ee.payloadFactory.Register(contentType, ctor)
+}
+
+// RegisterFixedWidthLayout registers a fixed-width record layout under name.
+// Payloads reference it through the content type, e.g.
+// "text/x-fixed-width; layout=customer", and the fixedWidthToJSON pipe by
+// name, e.g. "fixedWidthToJSON:customer". Layouts can be built from a COBOL
+// copybook with ParseCopybook.
+func (ee *ExpressionEngine) RegisterFixedWidthLayout(name string, layout FixedWidthLayout) error {
+	return ee.payloadFactory.fixedWidthLayouts.Register(name, layout)
+}
+
+// SetContentSniffing controls how payloads with an empty or
+// "application/octet-stream" content type are parsed. When enabled (the
+// default), payloads that look like HTML, XML, JSON, NDJSON, YAML (starting
+// with "---" or "%YAML") or Parquet are parsed as that format, and anything
+// else is a BinaryPayload. When disabled, octet-stream payloads are always
+// binary and an empty content type is unsupported.
+func (ee *ExpressionEngine) SetContentSniffing(enabled bool) {
+	ee.payloadFactory.sniffingDisabled.Store(!enabled)
+}
+
+// SetXMLOptions sets how XML payloads, including SOAP envelopes, are
+// parsed, e.g. SetXMLOptions(XMLOptions{DTD: DTDForbid}) to reject documents
+// with a DOCTYPE declaration. It only affects payloads parsed afterwards.
+func (ee *ExpressionEngine) SetXMLOptions(options XMLOptions) {
+	ee.payloadFactory.xmlOptions.Store(&options)
+}
+
+// SetXPathNamespaces binds namespace prefixes to URIs in XPath expressions,
+// e.g. {"ord": "urn:example:orders"} for "xpath:/ord:order/ord:id". The
+// bindings replace any set before; nil removes them.
+func (ee *ExpressionEngine) SetXPathNamespaces(namespaces map[string]string) {
+	if namespaces == nil {
+		ee.xpathNamespaces.Store(nil)
+		return
+	}
+	ee.xpathNamespaces.Store(newXPathNamespaces(namespaces))
+}
+
+// SetJWTVerifier makes "application/jwt" payloads check their signature with
+// verifier (e.g. HMACVerifier or PublicKeyVerifier): tokens it rejects fail
+// to parse, and accepted ones report "verified": true. Passing nil decodes
+// tokens without verification.
+func (ee *ExpressionEngine) SetJWTVerifier(verifier JWTVerifier) {
+	if verifier == nil {
+		ee.payloadFactory.jwtVerifier.Store(nil)
+		return
+	}
+	ee.payloadFactory.jwtVerifier.Store(&verifier)
+}
+
+// SetEvaluationTimeout limits the total time a single Evaluate call may take.
+// Zero (the default) disables the limit. It is safe to call while expressions
+// are being evaluated; running evaluations keep the previous value.
+func (ee *ExpressionEngine) SetEvaluationTimeout(timeout time.Duration) {
+	ee.evaluationTimeout.Store(int64(timeout))
+}
+
+// SetStageTimeout limits the time each pipeline stage may take.
+// Zero (the default) disables the limit. It is safe to call while expressions
+// are being evaluated.
+func (ee *ExpressionEngine) SetStageTimeout(timeout time.Duration) {
+	ee.stageTimeout.Store(int64(timeout))
+}
+
+// SetStrictMode controls what a query that matches nothing yields, e.g. a
+// jsonpath: key missing from the document. In strict mode (the default) the
+// evaluation fails with an error wrapping ErrNoMatch. Otherwise the result is
+// an AbsentResult with no value: the stages after the query are skipped,
+// except default pipes, which replace it. XPath queries that select no nodes
+// yield an empty node-set in both modes.
+func (ee *ExpressionEngine) SetStrictMode(strict bool) {
+	ee.lenient.Store(!strict)
+}
+
+// SetFallback makes the engine delegate expression prefixes and pipe operations
+// it does not understand to fallback, so that product-specific engines can be
+// layered over a shared base engine. The delegated stage still runs against the
+// payload parsed by this engine. Passing nil removes the fallback. It returns an
+// error if the fallback chain would loop back to this engine.
+func (ee *ExpressionEngine) SetFallback(fallback *ExpressionEngine) error {
+	for e := fallback; e != nil; e = e.fallback.Load() {
+		if e == ee {
+			return fmt.Errorf("engine fallback would create a cycle")
+		}
+	}
+	ee.fallback.Store(fallback)
+	return nil
+}
+
+// Fallback returns the engine unknown expressions are delegated to, or nil.
+func (ee *ExpressionEngine) Fallback() *ExpressionEngine {
+	return ee.fallback.Load()
+}
+
+// SetRecorder makes the engine capture every evaluation to recorder, for later
+// comparison with Replay. Passing nil stops recording.
+func (ee *ExpressionEngine) SetRecorder(recorder *Recorder) {
+	ee.recorder.Store(recorder)
+}
+
+// runStage evaluates one pipeline stage, enforcing the context deadline and the
+// stage timeout. Stages run on the calling goroutine: a stage that cannot observe
+// its context runs to completion, and its result is discarded if it overran.
+func (ee *ExpressionEngine) runStage(ctx context.Context, activePayload PayloadObject, currentResult QueryResult, index int, stage *Stage, fullExpression string, startTime time.Time) (QueryResult, PayloadObject, error) {
+	if err := ctx.Err(); err != nil {
+		return QueryResult{}, nil, stageContextError(err, index, stage.Text, fullExpression, startTime)
+	}
+
+	stageCtx := ctx
+	if timeout := time.Duration(ee.stageTimeout.Load()); timeout > 0 {
+		var cancel context.CancelFunc
+		stageCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result, payload, err := ee.evaluateStage(stageCtx, activePayload, currentResult, index, stage, fullExpression)
+	if ctxErr := stageCtx.Err(); ctxErr != nil {
+		return QueryResult{}, nil, stageContextError(ctxErr, index, stage.Text, fullExpression, startTime)
+	}
+	return result, payload, err
+}
+
+// stageContextError reports an exceeded deadline as ErrTimeout and any other
+// context error (cancellation) as ErrEvaluationFailed.
+func stageContextError(ctxErr error, index int, part, fullExpression string, startTime time.Time) error {
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		return &ErrTimeout{
+			Expression: fullExpression,
+			Stage:      part,
+			StageIndex: index,
+			Elapsed:    time.Since(startTime),
+			Cause:      ctxErr,
+		}
+	}
+	return &ErrEvaluationFailed{
+		Expression: fullExpression,
+		Reason:     fmt.Sprintf("evaluation canceled in stage %d ('%s')", index, part),
+		InnerError: ctxErr,
+	}
+}
+
+// evaluateStage evaluates a single part of a piped expression and returns the
+// new result together with the payload subsequent stages should query.
+func (ee *ExpressionEngine) evaluateStage(ctx context.Context, activePayload PayloadObject, currentResult QueryResult, index int, stage *Stage, fullExpression string) (QueryResult, PayloadObject, error) {
+	trimmedPart := stage.Text
+	// Selecting a multipart part switches subsequent stages to the part's body
+	if stage.Language == partPrefix {
+		return ee.evaluatePartStage(ctx, activePayload, stage, fullExpression)
+	}
+
+	if index == 0 { // First part is always an expression
+		result, err := ee.evaluateSingleExpression(ctx, activePayload, stage)
+		if err != nil {
+			return QueryResult{}, nil, fmt.Errorf("error in expression part '%s': %w", trimmedPart, err)
+		}
+		return result, activePayload, nil
+	}
+
+	// default, the conditional pipes, the coercions and the collection pipes
+	// accept a previous result of any type
+	isCall := stage.Kind == PipeStage
+	if call := stage.call(); isCall && stage.Err == nil && (isValuePipe(call.name) || isCollectionPipe(call.name)) {
+		if err := checkPipeArgs(call); err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+		var result QueryResult
+		var err error
+		if isCollectionPipe(call.name) {
+			result, err = ee.collectionPipe(ctx, call, currentResult)
+		} else {
+			result, err = valuePipe(ctx, call, currentResult)
+		}
+		var limitErr *ErrLimitExceeded
+		if errors.As(err, &limitErr) && limitErr.Expression == "" {
+			return QueryResult{}, nil, &ErrLimitExceeded{Limit: limitErr.Limit, Max: limitErr.Max, Actual: limitErr.Actual, Expression: fullExpression}
+		}
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return result, activePayload, nil
+	}
 
-			// Check if the part is exactly a standalone transformation operation
-			if trimmedPart == extractAsJSONPipe || trimmedPart == extractAsXMLPipe {
-				// These are standalone transformation operations
-				pipeOperation := trimmedPart
-
-				switch pipeOperation {
-				case extractAsJSONPipe:
-					// Create a new JSONPayload from the string result of the previous step
-					intermediatePayload, err := ee.payloadFactory.CreatePayload([]byte(prevResultStr), "application/json")
-					if err != nil {
-						return QueryResult{}, &ErrEvaluationFailed{
-							Expression: fullExpression,
-							Reason:     fmt.Sprintf("failed to create intermediate JSON payload for pipe '%s'", pipeOperation),
-							InnerError: err,
-						}
-					}
-					activePayload = intermediatePayload
-					// Since this is a standalone transformation with no query, return the entire document
-					// This matches the behavior when users just want to convert formats without queries
-					currentResult = QueryResult{Value: prevResultStr, Type: StringResult}
-				case extractAsXMLPipe:
-					// Create a new XMLPayload from the string result
-					intermediatePayload, err := ee.payloadFactory.CreatePayload([]byte(prevResultStr), "application/xml")
-					if err != nil {
-						return QueryResult{}, &ErrEvaluationFailed{
-							Expression: fullExpression,
-							Reason:     fmt.Sprintf("failed to create intermediate XML payload for pipe '%s'", pipeOperation),
-							InnerError: err,
-						}
-					}
-					activePayload = intermediatePayload
-					// Since this is a standalone transformation with no query, return the entire document
-					currentResult = QueryResult{Value: prevResultStr, Type: StringResult}
-				}
-				continue
+	// Subsequent parts are transformations or chained expressions
+	// Ensure previous result was a string (or JSON array/object) to be re-parsed
+	prevResultStr, ok := pipeInput(currentResult)
+	if !ok && !convertsXMLNodes(stage.Pipe, currentResult) {
+		return QueryResult{}, nil, &ErrEvaluationFailed{
+			Expression: fullExpression,
+			Reason:     fmt.Sprintf("pipe operation '%s' requires string, array or object input from previous step, got %T", trimmedPart, currentResult.Value),
+		}
+	}
+
+	// Pipe operations are a name, optionally with arguments, e.g. "substring(0, 5)"
+	call := stage.call()
+	if isCall && stage.Err != nil {
+		return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("invalid pipe operation '%s'", trimmedPart), InnerError: stage.Err}
+	}
+	if _, builtIn := pipeArity[call.name]; isCall && builtIn {
+		if err := checkPipeArgs(call); err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+	}
+	switch pipeOperation := call.name; pipeOperation {
+	case extractAsJSONPipe:
+		// Create a new JSONPayload from the string result of the previous step
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, []byte(prevResultStr), "application/json", "JSON", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		// Since this is a standalone transformation with no query, return the entire document
+		// This matches the behavior when users just want to convert formats without queries
+		return QueryResult{Value: prevResultStr, Type: StringResult}, intermediatePayload, nil
+	case extractAsXMLPipe:
+		// Create a new XMLPayload from the string result
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, []byte(prevResultStr), "application/xml", "XML", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: prevResultStr, Type: StringResult}, intermediatePayload, nil
+	case extractAsYAMLPipe:
+		// Create a new YAMLPayload from the string result
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, []byte(prevResultStr), "application/yaml", "YAML", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: prevResultStr, Type: StringResult}, intermediatePayload, nil
+	case extractAutoPipe:
+		// Create a JSON, XML or YAML payload from the string result, or from the text it holds in base64
+		content, contentType, formatName, err := sniffEmbeddedFormat(prevResultStr)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, content, contentType, formatName, pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: string(content), Type: StringResult}, intermediatePayload, nil
+	case extractAsCSVPipe:
+		// Create a new CSVPayload from the string result, e.g. extractAsCSV(";", false)
+		contentType, err := csvPipeContentType(call)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, []byte(prevResultStr), contentType, "CSV", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: prevResultStr, Type: StringResult}, intermediatePayload, nil
+	case extractAsProtobufPipe:
+		// Decode bytes, or base64 text, as a message of a registered type, e.g. extractAsProtobuf("com.acme.Order")
+		raw, contentType, err := protobufPipeInput(call, currentResult, prevResultStr)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, raw, contentType, "protobuf", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		text, err := intermediatePayload.AsString()
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return QueryResult{Value: text, Type: StringResult}, intermediatePayload, nil
+	case xsltPipe:
+		// Transform the XML result and continue with the output, parsed by its content type
+		doc, err := ee.xsltSource(currentResult, prevResultStr)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("failed to create intermediate XML payload for pipe '%s'", pipeOperation), InnerError: err}
+		}
+		output, contentType, err := ee.xsltTransform(ctx, call, doc)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		if mediaType(contentType) == "text/plain" {
+			return QueryResult{Value: output, Type: StringResult}, newPlainTextPayload(output), nil
+		}
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, []byte(output), contentType, "transformed", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: output, Type: StringResult}, intermediatePayload, nil
+	case joltPipe:
+		// Reshape the JSON result and continue with the output as a JSONPayload
+		transformed, err := ee.joltTransform(call, prevResultStr)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, transformed, "application/json", "JSON", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: string(transformed), Type: StringResult}, intermediatePayload, nil
+	case csvToJSONPipe:
+		// Parse the string result as CSV and continue with its records as a JSON array
+		csvPayload, err := ee.createIntermediatePayload(ctx, []byte(prevResultStr), "text/csv", "CSV", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		records, err := csvPayload.(*CSVPayload).AsJSON()
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: "failed to convert CSV records to JSON", InnerError: err}
+		}
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, records, "application/json", "JSON", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: string(records), Type: StringResult}, intermediatePayload, nil
+	case jsonToXMLPipe:
+		// Convert the JSON result and continue with it as an XMLPayload
+		root, attrPrefix, err := jsonToXMLOptions(call)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+		converted, err := jsonToXML(prevResultStr, root, attrPrefix)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, []byte(converted), "application/xml", "XML", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: converted, Type: StringResult}, intermediatePayload, nil
+	case xmlToJSONPipe:
+		// Parse the XML result and continue with its JSON view as a JSONPayload
+		attrPrefix, err := attrPrefixOption(call)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+		nodes, err := ee.xmlToJSONSource(currentResult, prevResultStr)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("failed to create intermediate XML payload for pipe '%s'", pipeOperation), InnerError: err}
+		}
+		converted, err := xmlNodesToJSON(nodes, attrPrefix)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, []byte(converted), "application/json", "JSON", pipeOperation, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: converted, Type: StringResult}, intermediatePayload, nil
+	case c14nPipe:
+		inclusive, err := inclusivePrefixes(call)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+		var canonical string
+		if nodes, ok := selectedXMLElements(currentResult); ok {
+			canonical, err = canonicalXMLNodes(nodes, inclusive)
+		} else {
+			var entities map[string]string
+			if entities, err = ee.payloadFactory.strictXMLEntities([]byte(prevResultStr)); err == nil {
+				canonical, err = canonicalXML([]byte(prevResultStr), entities, inclusive)
+			}
+		}
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return QueryResult{Value: canonical, Type: StringResult}, activePayload, nil
+	case prettyPrintPipe, minifyPipe:
+		indent := ""
+		if pipeOperation == prettyPrintPipe {
+			var err error
+			if indent, err = formatIndent(call); err != nil {
+				return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+			}
+		}
+		formatted, err := ee.formatDocument(prevResultStr, indent)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return QueryResult{Value: formatted, Type: StringResult}, activePayload, nil
+	case hexPipe, toHexPipe:
+		return QueryResult{Value: hex.EncodeToString([]byte(prevResultStr)), Type: StringResult}, activePayload, nil
+	case sha256Pipe, sha1Pipe, md5Pipe, crc32Pipe:
+		return QueryResult{Value: digest(pipeOperation, []byte(prevResultStr)), Type: BytesResult}, activePayload, nil
+	case hmacSHA256Pipe, hmacSHA1Pipe:
+		mac, err := ee.hmacDigest(call, []byte(prevResultStr))
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return QueryResult{Value: mac, Type: BytesResult}, activePayload, nil
+	case aesEncryptPipe:
+		sealed, err := ee.aesEncrypt(call, []byte(prevResultStr))
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return QueryResult{Value: sealed, Type: BytesResult}, activePayload, nil
+	case aesDecryptPipe:
+		plaintext, err := ee.aesDecrypt(call, []byte(prevResultStr))
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		if utf8.Valid(plaintext) {
+			return QueryResult{Value: string(plaintext), Type: StringResult}, activePayload, nil
+		}
+		return QueryResult{Value: plaintext, Type: BytesResult}, activePayload, nil
+	case base64EncodePipe:
+		return QueryResult{Value: base64.StdEncoding.EncodeToString([]byte(prevResultStr)), Type: StringResult}, activePayload, nil
+	case base64DecodePipe:
+		decoded, err := decodeBase64(prevResultStr)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: "invalid base64 input", InnerError: err}
+		}
+		if utf8.Valid(decoded) {
+			return QueryResult{Value: string(decoded), Type: StringResult}, activePayload, nil
+		}
+		return QueryResult{Value: decoded, Type: BytesResult}, activePayload, nil
+	case gunzipPipe, inflatePipe:
+		coding := "gzip"
+		if pipeOperation == inflatePipe {
+			coding = "deflate"
+		}
+		decoded, err := decodeContentEncoding([]byte(prevResultStr), coding, evaluationLimits(ctx).MaxPayloadBytes)
+		var limitErr *ErrLimitExceeded
+		if errors.As(err, &limitErr) {
+			limitErr.Expression = fullExpression
+			return QueryResult{}, nil, limitErr
+		}
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		if utf8.Valid(decoded) {
+			return QueryResult{Value: string(decoded), Type: StringResult}, activePayload, nil
+		}
+		return QueryResult{Value: decoded, Type: BytesResult}, activePayload, nil
+	case trimPipe, upperPipe, lowerPipe, replacePipe, regexReplacePipe, urlEncodePipe, urlDecodePipe:
+		result, err := transformString(call, prevResultStr)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return QueryResult{Value: result, Type: StringResult}, activePayload, nil
+	case splitPipe:
+		separator, err := stringArg(call, 0)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+		// Split no further than one part over the limit, which fails anyway
+		maxElements, n := maxResultElements(ctx), -1
+		if maxElements > 0 {
+			n = maxElements + 1
+		}
+		parts := strings.SplitN(prevResultStr, separator, n)
+		if err := checkResultElements(maxElements, len(parts), fullExpression); err != nil {
+			return QueryResult{}, nil, err
+		}
+		values := make([]interface{}, len(parts))
+		for i, part := range parts {
+			values[i] = part
+		}
+		return QueryResult{Value: values, Type: ArrayResult}, activePayload, nil
+	case joinPipe:
+		joined, err := joinArray(call, currentResult)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return QueryResult{Value: joined, Type: StringResult}, activePayload, nil
+	case substringPipe:
+		start, err := intArg(call, 0)
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
+		}
+		end := len(prevResultStr) // At least the number of characters
+		if len(call.args) > 1 {
+			if end, err = intArg(call, 1); err != nil {
+				return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: err.Error()}
 			}
+		}
+		return QueryResult{Value: substring(prevResultStr, start, end), Type: StringResult}, activePayload, nil
+	}
+
+	// Fixed-width records are parsed with a registered layout and continue as a JSON array
+	if call.name == fixedWidthToJSONPipe {
+		fixedPayload, err := ee.createIntermediatePayload(ctx, []byte(prevResultStr), "text/x-fixed-width; layout="+call.args[0].(string), "fixed-width", trimmedPart, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		records := fixedPayload.(*FixedWidthPayload).AsJSON()
+		intermediatePayload, err := ee.createIntermediatePayload(ctx, records, "application/json", "JSON", trimmedPart, fullExpression)
+		if err != nil {
+			return QueryResult{}, nil, err
+		}
+		return QueryResult{Value: string(records), Type: StringResult}, intermediatePayload, nil
+	}
+
+	// Pipes registered by the application transform the previous result
+	if fn, ok := ee.registeredPipe(call.name); isCall && ok {
+		result, err := fn(ctx, PipeInput{Result: currentResult, Text: prevResultStr, Args: call.args})
+		if err != nil {
+			return QueryResult{}, nil, &ErrEvaluationFailed{Expression: fullExpression, Reason: fmt.Sprintf("pipe operation '%s' failed", call.name), InnerError: err}
+		}
+		return result, activePayload, nil
+	}
 
-			// Handle direct expression cases (xpath: or jsonpath:)
-			if strings.HasPrefix(trimmedPart, jsonpathPrefix) || strings.HasPrefix(trimmedPart, xpathPrefix) {
-				// Direct query without transformation operator
-				// For cases like "xpath:... | jsonpath:..."
-				currentResult, err = ee.evaluateSingleExpression(activePayload, trimmedPart)
-				if err != nil {
-					return QueryResult{}, fmt.Errorf("error in expression part '%s': %w", trimmedPart, err)
-				}
-				continue
+	// Text and regex stages work on the previous result rather than the payload,
+	// e.g. "jsonpath:message | regex:order (\d+)"
+	if stage.Language == textPrefix || stage.Language == regexPrefix {
+		textPayload := newPlainTextPayload(prevResultStr)
+		result, err := ee.evaluateSingleExpression(ctx, textPayload, stage)
+		if err != nil {
+			return QueryResult{}, nil, fmt.Errorf("error in expression part '%s': %w", trimmedPart, err)
+		}
+		return result, textPayload, nil
+	}
+
+	// Handle direct expression cases (xpath:, jsonpath:, ...)
+	if stage.Kind == QueryStage {
+		// Direct query without transformation operator
+		// For cases like "xpath:... | jsonpath:..."
+		result, err := ee.evaluateSingleExpression(ctx, activePayload, stage)
+		if err != nil {
+			return QueryResult{}, nil, fmt.Errorf("error in expression part '%s': %w", trimmedPart, err)
+		}
+		return result, activePayload, nil
+	}
+
+	// Let a fallback engine handle operations this engine does not know
+	if fallback := ee.fallback.Load(); fallback != nil {
+		ee.logDebug("delegating pipe to fallback engine", "stage", trimmedPart, "expression", fullExpression)
+		return fallback.evaluateStage(ctx, activePayload, currentResult, index, stage, fullExpression)
+	}
+
+	// For all other cases, assume it's an unsupported pipe operation
+	return QueryResult{}, nil, &ErrUnsupportedExpression{Expression: fmt.Sprintf("unsupported pipe operation: %s", trimmedPart)}
+}
+
+// evaluatePartStage selects a part of a multipart payload and parses its body
+// by the part's content type for the next stages. Parts of a content type the
+// factory does not support are continued as plain text.
+func (ee *ExpressionEngine) evaluatePartStage(ctx context.Context, activePayload PayloadObject, stage *Stage, fullExpression string) (QueryResult, PayloadObject, error) {
+	result, err := ee.evaluateSingleExpression(ctx, activePayload, stage)
+	if err != nil {
+		return QueryResult{}, nil, fmt.Errorf("error in expression part '%s': %w", stage.Text, err)
+	}
+	part, err := activePayload.(*MultipartPayload).Part(stage.Query)
+	if err != nil {
+		return QueryResult{}, nil, err
+	}
+	if err := useIntermediatePayload(ctx, len(part.Body), fullExpression); err != nil {
+		return QueryResult{}, nil, err
+	}
+	partPayload, err := ee.parsePayload(ee.payloadFactory, part.Body, part.ContentType)
+	if err != nil {
+		var unsupported *ErrUnsupportedContentType
+		if !errors.As(err, &unsupported) {
+			return QueryResult{}, nil, &ErrEvaluationFailed{
+				Expression: fullExpression,
+				Reason:     fmt.Sprintf("failed to parse part '%s' as %s", stage.Query, part.ContentType),
+				InnerError: err,
 			}
+		}
+		ee.logDebug("continuing part of unsupported content type as plain text", "part", stage.Query, "contentType", part.ContentType)
+		partPayload = newPlainTextPayload(string(part.Body))
+	}
+	return result, partPayload, nil
+}
 
-			// For all other cases, assume it's an unsupported pipe operation
-			return QueryResult{}, &ErrUnsupportedExpression{Expression: fmt.Sprintf("unsupported pipe operation: %s", trimmedPart)}
+// pipeInput returns the previous stage's result as text for the next stage.
+// Array and object results (e.g. a whole root array) are serialized as JSON,
+// and byte results are passed as they are.
+func pipeInput(result QueryResult) (string, bool) {
+	switch result.Type {
+	case BytesResult:
+		raw, ok := result.Value.([]byte)
+		return string(raw), ok
+	case ArrayResult, ObjectResult:
+		raw, err := marshalJSONView(result.Value)
+		if err != nil {
+			return "", false
 		}
+		return string(raw), true
 	}
-	return currentResult, nil
+	str, ok := result.Value.(string)
+	return str, ok
 }
 
-// evaluateSingleExpression evaluates a simple, non-piped expression part.
-func (ee *ExpressionEngine) evaluateSingleExpression(pld PayloadObject, expressionPart string) (QueryResult, error) {
-	if strings.HasPrefix(expressionPart, xpathPrefix) {
-		if pld.GetContentType() != "application/xml" && pld.GetContentType() != "text/xml" {
-			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "XPath", PayloadType: pld.GetContentType(), Reason: "XPath requires XML payload"}
+// convertsXMLNodes reports whether pipe converts the XML elements result
+// selected rather than their text, so it also takes several of them.
+func convertsXMLNodes(pipe string, result QueryResult) bool {
+	_, ok := selectedXMLElements(result)
+	return ok && (pipe == xmlToJSONPipe || pipe == c14nPipe)
+}
+
+// createIntermediatePayload parses a previous string result into a new payload for a pipe.
+func (ee *ExpressionEngine) createIntermediatePayload(ctx context.Context, raw []byte, contentType, formatName, pipeOperation, fullExpression string) (PayloadObject, error) {
+	if err := useIntermediatePayload(ctx, len(raw), fullExpression); err != nil {
+		return nil, err
+	}
+	intermediatePayload, err := ee.parsePayload(ee.payloadFactory, raw, contentType)
+	if err != nil {
+		ee.logDebug("failed to parse intermediate payload", "pipe", pipeOperation, "contentType", contentType, "expression", fullExpression, "error", err)
+		return nil, &ErrEvaluationFailed{
+			Expression: fullExpression,
+			Reason:     fmt.Sprintf("failed to create intermediate %s payload for pipe '%s'", formatName, pipeOperation),
+			InnerError: err,
 		}
-		actualExpr := strings.TrimPrefix(expressionPart, xpathPrefix)
-		return pld.Query(actualExpr)
-	} else if strings.HasPrefix(expressionPart, jsonpathPrefix) {
-		if pld.GetContentType() != "application/json" {
+	}
+	return intermediatePayload, nil
+}
+
+// queryPrefixes lists the expression languages understood by evaluateSingleExpression.
+var queryPrefixes = []string{xpathPrefix, jsonpathPrefix, yamlpathPrefix, csvPrefix, protopathPrefix, frontmatterPrefix, bodyPrefix, markdownPrefix, cssPrefix, textPrefix, regexPrefix, ndjsonPrefix, partPrefix, formPrefix, computedPrefix, propPrefix, bytesPrefix, parquetPrefix, jwtPrefix, fixedPrefix, logPrefix}
+
+// evaluateSingleExpression evaluates a query stage against pld.
+func (ee *ExpressionEngine) evaluateSingleExpression(ctx context.Context, pld PayloadObject, stage *Stage) (QueryResult, error) {
+	switch stage.Language {
+	case xpathPrefix:
+		if mt := mediaType(pld.GetContentType()); !isXMLMediaType(mt) && mt != "text/html" {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "XPath", PayloadType: pld.GetContentType(), Reason: "XPath requires XML or HTML payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case jsonpathPrefix:
+		if _, isJSONView := pld.(jsonViewPayload); !isJSONMediaType(mediaType(pld.GetContentType())) && !isJSONView {
 			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "JSONPath", PayloadType: pld.GetContentType(), Reason: "JSONPath requires JSON payload"}
 		}
-		actualExpr := strings.TrimPrefix(expressionPart, jsonpathPrefix)
-		return pld.Query(actualExpr)
+		actualExpr := ee.convertPathKeys(stage.Query)
+		if view, ok := pld.(jsonViewPayload); ok {
+			if selected, ok := ee.selectModifiedJSON(view.jsonView(), actualExpr); ok {
+				return convertGJSONResultContext(ctx, selected, actualExpr)
+			}
+		}
+		result, err := queryPayload(ctx, pld, actualExpr)
+		if dualRun := ee.jsonPathDualRun.Load(); dualRun != nil && !wantsAllMatches(ctx) {
+			dualRun.compareInBackground(ee, pld, actualExpr, result, err)
+		}
+		return result, err
+	case yamlpathPrefix:
+		switch mediaType(pld.GetContentType()) {
+		case "application/yaml", "text/yaml", "application/x-yaml":
+		default:
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "YAMLPath", PayloadType: pld.GetContentType(), Reason: "YAMLPath requires YAML payload"}
+		}
+		actualExpr := ee.convertPathKeys(stage.Query)
+		return queryPayload(ctx, pld, actualExpr)
+	case csvPrefix:
+		if mediaType(pld.GetContentType()) != "text/csv" {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "CSV", PayloadType: pld.GetContentType(), Reason: "CSV queries require CSV payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case protopathPrefix:
+		switch mediaType(pld.GetContentType()) {
+		case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		default:
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "ProtoPath", PayloadType: pld.GetContentType(), Reason: "ProtoPath requires protobuf payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case frontmatterPrefix:
+		fmDocument, ok := pld.(frontMatterDocument)
+		if !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "FrontMatter", PayloadType: pld.GetContentType(), Reason: "front matter queries require a front-matter document"}
+		}
+		return fmDocument.QueryFrontMatter(stage.Query)
+	case bodyPrefix:
+		fmDocument, ok := pld.(frontMatterDocument)
+		if !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Body", PayloadType: pld.GetContentType(), Reason: "body access requires a front-matter document"}
+		}
+		if strings.TrimSpace(stage.Query) != "" {
+			return QueryResult{}, &ErrUnsupportedExpression{Expression: stage.Text}
+		}
+		return QueryResult{Value: fmDocument.Body(), Type: StringResult}, nil
+	case markdownPrefix:
+		if _, ok := pld.(*MarkdownPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Markdown", PayloadType: pld.GetContentType(), Reason: "Markdown queries require a Markdown payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case cssPrefix:
+		if _, ok := pld.(*HTMLPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "CSS", PayloadType: pld.GetContentType(), Reason: "CSS selectors require HTML payload"}
+		}
+		xpathExpr, err := cssToXPath(stage.Query)
+		if err != nil {
+			return QueryResult{}, &ErrEvaluationFailed{Expression: stage.Text, Reason: "invalid CSS selector", InnerError: err}
+		}
+		return queryPayload(ctx, pld, xpathExpr)
+	case textPrefix:
+		if _, ok := pld.(*TextPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Text", PayloadType: pld.GetContentType(), Reason: "text queries require a text payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case regexPrefix:
+		textPayload, ok := pld.(*TextPayload)
+		if !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Regex", PayloadType: pld.GetContentType(), Reason: "regex queries require a text payload"}
+		}
+		pattern := stage.Query
+		re, err := compileRegex(ctx, pattern)
+		if err != nil {
+			return QueryResult{}, &ErrEvaluationFailed{Expression: pattern, Reason: "regex compilation failed", InnerError: err}
+		}
+		return textPayload.queryRegex(re, pattern)
+	case ndjsonPrefix:
+		switch mediaType(pld.GetContentType()) {
+		case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		default:
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "NDJSON", PayloadType: pld.GetContentType(), Reason: "NDJSON queries require NDJSON payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case partPrefix:
+		if _, ok := pld.(*MultipartPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Part", PayloadType: pld.GetContentType(), Reason: "part selection requires a multipart payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case formPrefix:
+		if _, ok := pld.(*FormPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Form", PayloadType: pld.GetContentType(), Reason: "form queries require a URL-encoded form payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case propPrefix:
+		if _, ok := pld.(*PropertiesPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Properties", PayloadType: pld.GetContentType(), Reason: "property lookups require a properties or .env payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case bytesPrefix:
+		if _, ok := pld.(*BinaryPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Bytes", PayloadType: pld.GetContentType(), Reason: "byte queries require a binary payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case parquetPrefix:
+		if _, ok := pld.(*ParquetPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Parquet", PayloadType: pld.GetContentType(), Reason: "Parquet queries require a Parquet payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case jwtPrefix:
+		if _, ok := pld.(*JWTPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "JWT", PayloadType: pld.GetContentType(), Reason: "JWT queries require a JWT payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case fixedPrefix:
+		if _, ok := pld.(*FixedWidthPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Fixed-width", PayloadType: pld.GetContentType(), Reason: "fixed-width queries require a fixed-width payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case logPrefix:
+		if _, ok := pld.(*LogPayload); !ok {
+			return QueryResult{}, &ErrInvalidPayloadForOperation{Operation: "Log", PayloadType: pld.GetContentType(), Reason: "log queries require a syslog or logfmt payload"}
+		}
+		return queryPayload(ctx, pld, stage.Query)
+	case computedPrefix:
+		return ee.computedField(ctx, pld, stage.Query)
+	}
+	// Languages registered by the application
+	if stage.Kind == QueryStage {
+		if fn, ok := ee.registeredLanguage(stage.Language); ok {
+			return fn(ctx, pld, stage.Query)
+		}
+	}
+	if fallback := ee.fallback.Load(); fallback != nil {
+		ee.logDebug("delegating query to fallback engine", "stage", stage.Text)
+		return fallback.evaluateSingleExpression(ctx, pld, stage)
 	}
-	// Add other expression types (regex, etc.) here
-	return QueryResult{}, &ErrUnsupportedExpression{Expression: expressionPart}
+	return QueryResult{}, &ErrUnsupportedExpression{Expression: stage.Text}
 }
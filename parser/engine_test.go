@@ -0,0 +1,301 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateContextTimeouts(t *testing.T) {
+	const embedded = `{"productInfo": "<product><name>Widget</name></product>"}`
+	const expression = "jsonpath:productInfo | extractAsXML | xpath:/product/name/text()"
+
+	expired := func() (context.Context, context.CancelFunc) {
+		return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	}
+	canceled := func() (context.Context, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx, cancel
+	}
+	background := func() (context.Context, context.CancelFunc) {
+		return context.Background(), func() {}
+	}
+
+	tests := []struct {
+		name              string
+		ctx               func() (context.Context, context.CancelFunc)
+		evaluationTimeout time.Duration
+		stageTimeout      time.Duration
+		wantTimeout       bool
+		wantCause         error
+		wantStageIndex    int
+		wantCanceled      bool
+	}{
+		{name: "no limits", ctx: background},
+		{name: "generous limits", ctx: background, evaluationTimeout: time.Minute, stageTimeout: time.Minute},
+		{name: "expired context deadline", ctx: expired, wantTimeout: true, wantCause: context.DeadlineExceeded},
+		{name: "stage timeout overrun", ctx: background, stageTimeout: time.Nanosecond, wantTimeout: true, wantCause: context.DeadlineExceeded},
+		{name: "evaluation timeout overrun", ctx: background, evaluationTimeout: time.Nanosecond, wantTimeout: true, wantCause: context.DeadlineExceeded},
+		{name: "canceled context", ctx: canceled, wantCanceled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetEvaluationTimeout(tt.evaluationTimeout)
+			engine.SetStageTimeout(tt.stageTimeout)
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			result, err := NewMessageContext([]byte(embedded), "application/json", engine).EvaluateExpressionContext(ctx, expression)
+			switch {
+			case tt.wantTimeout:
+				var timeoutErr *ErrTimeout
+				if !errors.As(err, &timeoutErr) {
+					t.Fatalf("expected ErrTimeout, got %v", err)
+				}
+				if !errors.Is(err, tt.wantCause) {
+					t.Errorf("cause = %v, want %v", timeoutErr.Cause, tt.wantCause)
+				}
+				if timeoutErr.StageIndex != tt.wantStageIndex || timeoutErr.Stage != "jsonpath:productInfo" {
+					t.Errorf("stage = %d (%q), want %d", timeoutErr.StageIndex, timeoutErr.Stage, tt.wantStageIndex)
+				}
+			case tt.wantCanceled:
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected context.Canceled, got %v", err)
+				}
+				var timeoutErr *ErrTimeout
+				if errors.As(err, &timeoutErr) {
+					t.Errorf("cancellation must not be reported as ErrTimeout")
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.Value != "Widget" {
+					t.Errorf("got %v, want Widget", result.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestXPathNodeIterationObservesContext(t *testing.T) {
+	xml := "<r>" + strings.Repeat("<a>x</a>", 1000) + "</r>"
+	payload, err := NewXMLPayload([]byte(xml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := payload.QueryContext(ctx, "//a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	result, err := payload.QueryContext(context.Background(), "//a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := len(result.Value.([]string)); got != 1000 {
+		t.Errorf("got %d nodes, want 1000", got)
+	}
+}
+
+func TestJSONWalkObservesContext(t *testing.T) {
+	payload, err := NewJSONPayload([]byte(`{"items":[` + strings.Repeat(`{"a":1},`, 999) + `{"a":1}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := payload.QueryContext(ctx, "items"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	result, err := payload.QueryContext(context.Background(), "items")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := len(result.Value.([]interface{})); got != 1000 {
+		t.Errorf("got %d elements, want 1000", got)
+	}
+}
+
+func TestTimeoutSettersAreSafeDuringEvaluation(t *testing.T) {
+	engine := NewEngine()
+	msgCtx := NewMessageContext([]byte(`{"a":"b"}`), "application/json", engine)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			engine.SetStageTimeout(time.Duration(i+1) * time.Minute)
+			engine.SetEvaluationTimeout(time.Duration(i+1) * time.Minute)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := msgCtx.EvaluateExpression("jsonpath:a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	<-done
+}
+
+func TestSetFallback(t *testing.T) {
+	base, product, team := NewEngine(), NewEngine(), NewEngine()
+	if err := product.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := team.SetFallback(product); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		engine   *ExpressionEngine
+		fallback *ExpressionEngine
+		wantErr  bool
+	}{
+		{name: "self", engine: base, fallback: base, wantErr: true},
+		{name: "indirect cycle", engine: base, fallback: team, wantErr: true},
+		{name: "sibling", engine: NewEngine(), fallback: team},
+		{name: "clear", engine: NewEngine(), fallback: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.engine.SetFallback(tt.fallback)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if tt.engine.Fallback() != nil {
+					t.Error("fallback was set despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.engine.Fallback() != tt.fallback {
+				t.Error("fallback not stored")
+			}
+		})
+	}
+}
+
+func TestFallbackChainEvaluation(t *testing.T) {
+	base, product := NewEngine(), NewEngine()
+	if err := product.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := NewJSONPayload([]byte(`{"a": "b"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "known prefix", expression: "jsonpath:a", want: "b"},
+		{name: "unknown prefix through the chain", expression: "nope:a", wantErr: true},
+		{name: "unknown pipe through the chain", expression: "jsonpath:a | nopePipe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := product.Evaluate(payload, tt.expression)
+			if tt.wantErr {
+				var unsupported *ErrUnsupportedExpression
+				if !errors.As(err, &unsupported) {
+					t.Fatalf("expected ErrUnsupportedExpression, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestLenientMissingPaths(t *testing.T) {
+	const payload = `{"customer": {"name": "Ann"}, "lines": "a\nb"}`
+	tests := []struct {
+		name       string
+		expression string
+		want       QueryResult
+		wantErr    string // Failures lenient mode still reports
+	}{
+		{name: "missing key", expression: "jsonpath:customer.title", want: QueryResult{Type: AbsentResult}},
+		{name: "later stages skipped", expression: "jsonpath:customer.title | upper | extractAsJSON", want: QueryResult{Type: AbsentResult}},
+		{name: "later default replaces", expression: `jsonpath:customer.title | upper | default("N/A")`, want: QueryResult{Value: "N/A", Type: StringResult}},
+		{name: "missing in intermediate payload", expression: "jsonpath:lines | extractAsJSON", wantErr: "Invalid JSON content"},
+		{name: "present key", expression: "jsonpath:customer.name | upper", want: QueryResult{Value: "ANN", Type: StringResult}},
+		{name: "regex without a match", expression: `jsonpath:customer.name | regex:id=(\d+) | upper`, want: QueryResult{Type: AbsentResult}},
+		{name: "regex without a match and default", expression: `jsonpath:customer.name | regex:id=(\d+) | default("none")`, want: QueryResult{Value: "none", Type: StringResult}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lenient := NewEngine(WithStrictMode(false))
+			result, err := NewMessageContext([]byte(payload), "application/json", lenient).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want.Value || result.Type != tt.want.Type {
+				t.Errorf("got %v (%s), want %v (%s)", result.Value, result.Type, tt.want.Value, tt.want.Type)
+			}
+
+			_, err = NewMessageContext([]byte(payload), "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.want.Type == AbsentResult && !errors.Is(err, ErrNoMatch) {
+				t.Errorf("strict mode: expected ErrNoMatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLenientMissingParts(t *testing.T) {
+	const form = "--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"comment\"\r\n" +
+		"\r\n" +
+		"rush order\r\n" +
+		"--XyZ--\r\n"
+	const contentType = "multipart/form-data; boundary=XyZ"
+	tests := []struct {
+		name       string
+		expression string
+		want       QueryResult
+	}{
+		{name: "missing part name", expression: "part:invoice", want: QueryResult{Type: AbsentResult}},
+		{name: "part index out of range", expression: "part:3 | jsonpath:total", want: QueryResult{Type: AbsentResult}},
+		{name: "missing part with default", expression: `part:invoice | default("none")`, want: QueryResult{Value: "none", Type: StringResult}},
+		{name: "regex without a match", expression: "part:comment | regex:FATAL", want: QueryResult{Type: AbsentResult}},
+		{name: "present part", expression: `part:comment | regex:(\w+) order`, want: QueryResult{Value: "rush", Type: StringResult}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lenient := NewEngine(WithStrictMode(false))
+			result, err := NewMessageContext([]byte(form), contentType, lenient).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want.Value || result.Type != tt.want.Type {
+				t.Errorf("got %v (%s), want %v (%s)", result.Value, result.Type, tt.want.Value, tt.want.Type)
+			}
+
+			_, err = NewMessageContext([]byte(form), contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.want.Type == AbsentResult && !errors.Is(err, ErrNoMatch) {
+				t.Errorf("strict mode: expected ErrNoMatch, got %v", err)
+			}
+		})
+	}
+}
@@ -1,6 +1,15 @@
 package parser
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoMatch is the cause of an ErrEvaluationFailed when a query selects
+// nothing, e.g. a jsonpath: key that is not in the document. Engines in
+// lenient mode (see SetStrictMode) report it as an AbsentResult instead.
+var ErrNoMatch = errors.New("no value matches the query")
 
 // ErrUnsupportedExpression is returned when the expression type is not supported.
 type ErrUnsupportedExpression struct {
@@ -27,6 +36,15 @@ func (e *ErrEvaluationFailed) Error() string {
 func (e *ErrEvaluationFailed) Unwrap() error { return e.InnerError }
 
 
+// ErrUnsupportedContentType is returned when no payload type handles a content type.
+type ErrUnsupportedContentType struct {
+	ContentType string
+}
+
+func (e *ErrUnsupportedContentType) Error() string {
+	return fmt.Sprintf("unsupported content type: %s", e.ContentType)
+}
+
 // ErrInvalidPayloadForOperation is returned when an operation is attempted on an unsuitable payload.
 type ErrInvalidPayloadForOperation struct {
 	Operation   string
@@ -37,3 +55,78 @@ type ErrInvalidPayloadForOperation struct {
 func (e *ErrInvalidPayloadForOperation) Error() string {
 	return fmt.Sprintf("invalid payload for operation '%s': payload type '%s'. Reason: %s", e.Operation, e.PayloadType, e.Reason)
 }
+
+// ErrPipelineType is returned by Compile when a pipeline stage cannot accept
+// what the stages before it produce.
+type ErrPipelineType struct {
+	Expression string
+	Stage      string
+	StageIndex int // Zero-based position of the stage in the pipeline
+	Reason     string
+}
+
+func (e *ErrPipelineType) Error() string {
+	return fmt.Sprintf("type error in expression '%s' at stage %d ('%s'): %s", e.Expression, e.StageIndex, e.Stage, e.Reason)
+}
+
+// ErrTimeout is returned when an evaluation deadline is exceeded.
+// It records the pipeline stage that was executing so slow expressions can be
+// told apart from failing ones.
+type ErrTimeout struct {
+	Expression string
+	Stage      string        // The stage (pipe part) that was executing
+	StageIndex int           // Zero-based position of the stage in the pipeline
+	Elapsed    time.Duration // Time spent on the expression when it was aborted
+	Cause      error         // Usually context.DeadlineExceeded
+}
+
+func (e *ErrTimeout) Error() string {
+	return fmt.Sprintf("evaluation timed out for expression '%s' in stage %d ('%s') after %s", e.Expression, e.StageIndex, e.Stage, e.Elapsed)
+}
+func (e *ErrTimeout) Unwrap() error { return e.Cause }
+
+// ErrLimitExceeded is returned when an evaluation exceeds one of the
+// EngineLimits of its engine.
+type ErrLimitExceeded struct {
+	Limit      string // The EngineLimits field that was exceeded, e.g. "MaxStages"
+	Max        int
+	Actual     int
+	Expression string // Empty when the message payload itself is too large
+}
+
+func (e *ErrLimitExceeded) Error() string {
+	if e.Expression == "" {
+		return fmt.Sprintf("limit %s exceeded: %d > %d", e.Limit, e.Actual, e.Max)
+	}
+	return fmt.Sprintf("limit %s exceeded for expression '%s': %d > %d", e.Limit, e.Expression, e.Actual, e.Max)
+}
+
+// ExpressionError is reported by EvaluateBatch for each expression of the
+// batch that fails, and by EvaluateTemplate for the expression of the
+// template that fails.
+type ExpressionError struct {
+	Index      int // Position of the expression in the batch or template
+	Expression string
+	Err        error
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("expression %d ('%s'): %v", e.Index, e.Expression, e.Err)
+}
+func (e *ExpressionError) Unwrap() error { return e.Err }
+
+// FileError is reported by ProcessDirectory when a file cannot be read or parsed,
+// or when one of the expressions fails on it.
+type FileError struct {
+	Path       string
+	Expression string // Empty when the file itself could not be read or parsed
+	Err        error
+}
+
+func (e *FileError) Error() string {
+	if e.Expression == "" {
+		return fmt.Sprintf("processing %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("processing %s: expression '%s': %v", e.Path, e.Expression, e.Err)
+}
+func (e *FileError) Unwrap() error { return e.Err }
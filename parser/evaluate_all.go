@@ -0,0 +1,221 @@
+package parser
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// finalStageKey is the context key of the finalStage options of an
+// evaluation.
+type finalStageKey struct{}
+
+// finalStage holds how the final stage of an evaluation yields its result.
+// Only the final stage sees them, not the stages before it or its
+// sub-expressions.
+type finalStage struct {
+	all  bool                   // Yield every match of the query (see EvaluateAll)
+	sink func(QueryResult) bool // Receives the elements of the result one at a time (see EvaluateStream); false stops
+}
+
+// withFinalStage sets how the final stage of the evaluation under ctx
+// yields its result.
+func withFinalStage(ctx context.Context, options finalStage) context.Context {
+	return context.WithValue(ctx, finalStageKey{}, options)
+}
+
+// finalStageOptions returns how the stage evaluated under ctx yields its
+// result.
+func finalStageOptions(ctx context.Context) finalStage {
+	options, _ := ctx.Value(finalStageKey{}).(finalStage)
+	return options
+}
+
+// wantsAllMatches reports whether the stage evaluated under ctx should
+// yield every match of its query.
+func wantsAllMatches(ctx context.Context) bool {
+	return finalStageOptions(ctx).all
+}
+
+// EvaluateAll is like Evaluate but returns every value the expression
+// matches, as a node-set or array result, instead of the first one: XPath
+// node-sets are returned even when they have one node or none, and jsonpath
+// wildcards ("*" and "?") in the final stage follow every matching key and
+// array element, where gjson stops at the first. A final stage that matches
+// nothing yields an empty array. Array results of pipes are returned as they
+// are, and any other result as an array of that one value.
+func (ee *ExpressionEngine) EvaluateAll(currentPayload PayloadObject, fullExpression string) (QueryResult, error) {
+	return ee.EvaluateAllContext(context.Background(), currentPayload, fullExpression)
+}
+
+// EvaluateAllContext is like EvaluateAll but stops when ctx is done (see
+// EvaluateContext).
+func (ee *ExpressionEngine) EvaluateAllContext(ctx context.Context, currentPayload PayloadObject, fullExpression string) (QueryResult, error) {
+	result, err := ee.EvaluateContext(withFinalStage(ctx, finalStage{all: true}), currentPayload, fullExpression)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	return allMatches(result), nil
+}
+
+// EvaluateAll evaluates an expression against the message payload and
+// returns every value it matches (see ExpressionEngine.EvaluateAll).
+func (mc *MessageContext) EvaluateAll(fullExpression string) (QueryResult, error) {
+	return mc.EvaluateAllContext(context.Background(), fullExpression)
+}
+
+// EvaluateAllContext is like EvaluateAll but honors the cancellation and
+// deadline of ctx.
+func (mc *MessageContext) EvaluateAllContext(ctx context.Context, fullExpression string) (QueryResult, error) {
+	result, err := mc.EvaluateExpressionContext(withFinalStage(ctx, finalStage{all: true}), fullExpression)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	return allMatches(result), nil
+}
+
+// allMatches returns result as the list of the values it holds.
+func allMatches(result QueryResult) QueryResult {
+	switch value := result.Value.(type) {
+	case []string:
+		return QueryResult{Value: value, Type: NodeSetResult, nodes: result.nodes}
+	case []interface{}:
+		return QueryResult{Value: value, Type: ArrayResult}
+	case string:
+		if result.nodes != nil { // A node-set of one node
+			return QueryResult{Value: []string{value}, Type: NodeSetResult, nodes: result.nodes}
+		}
+	case nil:
+		if result.Type == NodeSetResult {
+			return QueryResult{Value: []string{}, Type: NodeSetResult}
+		}
+		if result.Type == AbsentResult {
+			return QueryResult{Value: []interface{}{}, Type: ArrayResult}
+		}
+	}
+	return QueryResult{Value: []interface{}{result.Value}, Type: ArrayResult}
+}
+
+// queryAllJSON returns every value path matches in doc. gjson stops at the
+// first key a "*" or "?" wildcard matches; here every matching key and array
+// element is followed. A path whose "#" components already select every
+// element yields the elements of the array gjson returns.
+func queryAllJSON(doc gjson.Result, path string) []gjson.Result {
+	if path = strings.TrimSpace(path); path == "" || path == "$" {
+		return []gjson.Result{doc}
+	}
+	components := splitJSONPath(path)
+	for i, component := range components {
+		if hasWildcard(component) {
+			base := doc
+			if i > 0 {
+				base = doc.Get(strings.Join(components[:i], "."))
+			}
+			rest := strings.Join(components[i+1:], ".")
+			var matches []gjson.Result
+			base.ForEach(func(key, value gjson.Result) bool {
+				if !matchWildcard(component, key.String()) {
+					return true
+				}
+				if rest == "" {
+					matches = append(matches, value)
+				} else {
+					matches = append(matches, queryAllJSON(value, rest)...)
+				}
+				return true
+			})
+			return matches
+		}
+		if component == "#" || strings.HasPrefix(component, "#(") && strings.HasSuffix(component, ")#") {
+			result := doc.Get(path)
+			if !result.Exists() {
+				return nil
+			}
+			return result.Array()
+		}
+	}
+	if result := doc.Get(path); result.Exists() {
+		return []gjson.Result{result}
+	}
+	return nil
+}
+
+// splitJSONPath splits a gjson path at the dots outside brackets, quotes and
+// escapes.
+func splitJSONPath(path string) []string {
+	var components []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == '.' && depth == 0:
+			components = append(components, path[start:i])
+			start = i + 1
+		}
+	}
+	return append(components, path[start:])
+}
+
+// hasWildcard reports whether a path component is a key pattern with an
+// unescaped "*" or "?"; queries and modifiers are not patterns.
+func hasWildcard(component string) bool {
+	if strings.HasPrefix(component, "#") || strings.HasPrefix(component, "@") {
+		return false
+	}
+	for i := 0; i < len(component); i++ {
+		switch component[i] {
+		case '\\':
+			i++
+		case '*', '?':
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard reports whether key matches pattern, where "*" matches any
+// run of characters, "?" any one character, and "\" escapes the next one.
+func matchWildcard(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(key); i >= 0; i-- {
+				if matchWildcard(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if key == "" {
+				return false
+			}
+			_, size := utf8.DecodeRuneInString(key)
+			pattern, key = pattern[1:], key[size:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+		if key == "" || key[0] != pattern[0] {
+			return false
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return key == ""
+}
@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvaluateAll(t *testing.T) {
+	engine := NewEngine()
+	jsonCtx := NewMessageContext([]byte(`{
+		"orders": {"a1": {"id": 1, "tags": ["x"]}, "b2": {"id": 2, "tags": []}, "a3": {"id": 3}},
+		"items": [{"sku": "A"}, {"sku": "B"}],
+		"name": "Ann"
+	}`), "application/json", engine)
+	xmlCtx := NewMessageContext([]byte(`<r><a>1</a><a>2</a><b>3</b></r>`), "application/xml", engine)
+
+	tests := []struct {
+		name       string
+		msgCtx     *MessageContext
+		expression string
+		want       interface{}
+		wantType   ResultType
+	}{
+		{name: "object wildcard", msgCtx: jsonCtx, expression: "jsonpath:orders.*.id", want: []interface{}{1.0, 2.0, 3.0}, wantType: ArrayResult},
+		{name: "key pattern", msgCtx: jsonCtx, expression: "jsonpath:orders.a?.id", want: []interface{}{1.0, 3.0}, wantType: ArrayResult},
+		{name: "wildcard with missing keys", msgCtx: jsonCtx, expression: "jsonpath:orders.*.tags", want: []interface{}{[]interface{}{"x"}, []interface{}{}}, wantType: ArrayResult},
+		{name: "array wildcard", msgCtx: jsonCtx, expression: "jsonpath:items.*.sku", want: []interface{}{"A", "B"}, wantType: ArrayResult},
+		{name: "hash path", msgCtx: jsonCtx, expression: "jsonpath:items.#.sku", want: []interface{}{"A", "B"}, wantType: ArrayResult},
+		{name: "single value", msgCtx: jsonCtx, expression: "jsonpath:name", want: []interface{}{"Ann"}, wantType: ArrayResult},
+		{name: "no match", msgCtx: jsonCtx, expression: "jsonpath:orders.z*", want: []interface{}{}, wantType: ArrayResult},
+		{name: "only the final stage", msgCtx: jsonCtx, expression: "jsonpath:orders.* | toString", want: []interface{}{`{"id":1,"tags":["x"]}`}, wantType: ArrayResult},
+		{name: "node-set", msgCtx: xmlCtx, expression: "xpath://a", want: []string{"1", "2"}, wantType: NodeSetResult},
+		{name: "node-set of one node", msgCtx: xmlCtx, expression: "xpath://b", want: []string{"3"}, wantType: NodeSetResult},
+		{name: "empty node-set", msgCtx: xmlCtx, expression: "xpath://c", want: []string{}, wantType: NodeSetResult},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.msgCtx.EvaluateAll(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
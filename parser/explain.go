@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan describes how an engine would evaluate an expression, without
+// evaluating it, e.g. to debug mixed-content pipelines. It marshals to JSON.
+type Plan struct {
+	Expression string        `json:"expression"`
+	Stages     []PlanStage   `json:"stages"` // With references to registered expressions expanded
+	Result     ValueKind     `json:"result"` // Kinds of result the expression can produce
+	Warnings   []LintWarning `json:"warnings,omitempty"`
+}
+
+// PlanStage describes one stage of a Plan.
+type PlanStage struct {
+	Index         int         `json:"index"`
+	Stage         string      `json:"stage"`
+	Kind          StageKind   `json:"kind"`
+	Language      string      `json:"language,omitempty"` // Prefix of a query
+	Pipe          string      `json:"pipe,omitempty"`     // Name of a pipe operation
+	Input         ValueKind   `json:"input"`              // Kinds of the previous result it accepts; none if it does not use it
+	Output        ValueKind   `json:"output"`             // Kinds of result it can produce after the stages before it
+	Payload       PayloadKind `json:"payload,omitempty"`  // Payload it queries; empty if it does not query one or it is not known before evaluation
+	Intermediate  bool        `json:"intermediate"`       // It parses a new payload the next stages query
+	OutputPayload PayloadKind `json:"outputPayload,omitempty"`
+}
+
+// MarshalText encodes the kind as its String form, e.g. in Explain output.
+func (k StageKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// String renders the plan as one line per stage, followed by the warnings,
+// e.g.
+//
+//	0 query     xpath:/order/details/text() -> string|nodeset
+//	1 pipe      extractAsJSON (input string|array|object|bytes) -> string, new json-payload
+//	2 query     jsonpath:items on json-payload -> string|number|boolean|array|object|bytes
+func (p *Plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", p.Expression)
+	for _, s := range p.Stages {
+		fmt.Fprintf(&b, "%d %-9s %s", s.Index, s.Kind, s.Stage)
+		if s.Input != KindNone {
+			fmt.Fprintf(&b, " (input %s)", s.Input)
+		}
+		if s.Payload != PayloadUnknown {
+			fmt.Fprintf(&b, " on %s", s.Payload)
+		}
+		fmt.Fprintf(&b, " -> %s", s.Output)
+		if s.Intermediate {
+			payload := s.OutputPayload
+			if payload == PayloadUnknown {
+				payload = "payload of unknown kind"
+			}
+			fmt.Fprintf(&b, ", new %s", payload)
+		}
+		b.WriteByte('\n')
+	}
+	for _, w := range p.Warnings {
+		fmt.Fprintf(&b, "%s\n", w)
+	}
+	return b.String()
+}
+
+// Explain returns the plan of expression: its stages after references are
+// expanded, the language or pipe of each, the kinds of result each accepts
+// and produces, the payload each queries, and which stages parse an
+// intermediate payload. Nothing is evaluated. The plan is returned even if
+// the expression would not compile, with the error Compile reports; the
+// plan is nil only if a reference cannot be expanded.
+func (ee *ExpressionEngine) Explain(expression string) (*Plan, error) {
+	parsed := parseExpression(expression)
+	plan := &Plan{Expression: expression, Warnings: ee.lint(parsed)}
+	expanded, err := ee.expandReferences(parsed.Stages, nil)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Expression: expression, Reason: err.Error()}
+	}
+	plan.Stages, plan.Result, err = ee.planStages(expression, expanded)
+	for _, w := range plan.Warnings {
+		if w.Severity == SeverityError {
+			return plan, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("invalid stage %d ('%s'): %s", w.StageIndex, w.Stage, w.Message)}
+		}
+	}
+	return plan, err
+}
+
+// planStages types the expanded stages of expression in turn, each against
+// what the stages before it produce. The error is the first *ErrPipelineType;
+// the stages after it are typed as if it had been accepted.
+func (ee *ExpressionEngine) planStages(expression string, expanded []*Stage) ([]PlanStage, ValueKind, error) {
+	var result ValueKind
+	var stopped ValueKind // Results an ifEmpty pipe can end the pipeline with
+	var typeErr error
+	payload := PayloadUnknown
+	stages := make([]PlanStage, len(expanded))
+	for i, s := range expanded {
+		stage := s.Text
+		sig := signatureOf(stage, i)
+		if _, ok := ee.registeredPipe(s.Pipe); s.Kind == PipeStage && ok {
+			sig = registeredPipeSignature
+		}
+		if _, builtIn := queryPrefix(s.Language); s.Kind == QueryStage && !builtIn {
+			sig = registeredLanguageSignature
+		}
+		if typeErr == nil && i > 0 && sig.input != KindNone && result&sig.input == 0 {
+			typeErr = &ErrPipelineType{
+				Expression: expression, StageIndex: i, Stage: stage,
+				Reason: fmt.Sprintf("expects %s input, but the previous stage produces %s", sig.input, result),
+			}
+		}
+		if typeErr == nil && sig.payloads != nil && payload != PayloadUnknown && !containsPayloadKind(sig.payloads, payload) {
+			typeErr = &ErrPipelineType{
+				Expression: expression, StageIndex: i, Stage: stage,
+				Reason: fmt.Sprintf("queries a %s, but the payload at this stage is a %s", joinPayloadKinds(sig.payloads), payload),
+			}
+		}
+		planned := PlanStage{Index: i, Stage: stage, Kind: s.Kind, Language: s.Language, Pipe: s.Pipe, Input: sig.input}
+		if sig.payloads != nil {
+			planned.Payload = payload
+		}
+		if sig.keepsResult {
+			result |= sig.output
+		} else {
+			result = sig.output
+		}
+		if !sig.keepsPayload {
+			payload = sig.outputPayload
+			planned.Intermediate, planned.OutputPayload = true, payload
+		}
+		planned.Output = result
+		stages[i] = planned
+		if isIfEmptyStage(s) {
+			stopped |= result
+		}
+	}
+	return stages, result | stopped, typeErr
+}
@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterExpression("details", "xpath:/order/details/text()"); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := engine.Explain("@details | extractAsJSON | jsonpath:items | map(jsonpath:sku) | join(\",\")")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []PlanStage{
+		{Index: 0, Stage: "xpath:/order/details/text()", Kind: QueryStage, Language: xpathPrefix, Output: KindNodeSet | KindString},
+		{Index: 1, Stage: "extractAsJSON", Kind: PipeStage, Pipe: extractAsJSONPipe, Input: pipeInputKinds, Output: KindString, Intermediate: true, OutputPayload: PayloadJSON},
+		{Index: 2, Stage: "jsonpath:items", Kind: QueryStage, Language: jsonpathPrefix, Payload: PayloadJSON, Output: KindAny &^ KindNodeSet},
+		{Index: 3, Stage: "map(jsonpath:sku)", Kind: PipeStage, Pipe: mapPipe, Input: KindArray | KindNodeSet | KindString, Output: KindArray},
+		{Index: 4, Stage: `join(",")`, Kind: PipeStage, Pipe: joinPipe, Input: KindArray, Output: KindString},
+	}
+	if len(plan.Stages) != len(want) {
+		t.Fatalf("got %d stages, want %d", len(plan.Stages), len(want))
+	}
+	for i := range want {
+		if plan.Stages[i] != want[i] {
+			t.Errorf("stage %d = %+v, want %+v", i, plan.Stages[i], want[i])
+		}
+	}
+	if plan.Result != KindString {
+		t.Errorf("result = %s, want string", plan.Result)
+	}
+
+	plan, err = engine.Explain("jsonpath:doc | extractAsXML | jsonpath:id")
+	var typeErr *ErrPipelineType
+	if !errors.As(err, &typeErr) || typeErr.StageIndex != 2 {
+		t.Errorf("expected a type error at stage 2, got %v", err)
+	}
+	if plan == nil || len(plan.Stages) != 3 || plan.Stages[2].Payload != PayloadXML {
+		t.Errorf("expected the whole plan with the error, got %+v", plan)
+	}
+}
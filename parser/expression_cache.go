@@ -0,0 +1,250 @@
+package parser
+
+import (
+	"container/list"
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/antchfx/xpath"
+)
+
+// DefaultExpressionCacheSize is the number of expressions, and of XPath
+// expressions and regular expressions within them, an engine keeps parsed.
+const DefaultExpressionCacheSize = 1024
+
+// expressionCacheKey is the context key of the expressionCache of an evaluation.
+type expressionCacheKey struct{}
+
+// Kinds of expressionCache entries
+const (
+	cachedPipeline = iota // *Expression: a full expression parsed into its stages
+	cachedXPath           // *sync.Pool of *xpath.Expr
+	cachedRegex           // *regexp.Regexp
+)
+
+// expressionCache is a least recently used cache of parsed pipelines and
+// compiled XPath expressions and regular expressions. gjson paths have no
+// compiled form, so jsonpath and yamlpath stages are only split. It is safe
+// for concurrent use; a nil cache caches nothing.
+type expressionCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[cacheEntryKey]*list.Element
+	order    *list.List                  // Of *cacheEntry, most recently used first
+	onLookup func(kind int, hit bool)    // Reports each lookup when set
+	onEvict  func(kind int, text string) // Reports each eviction when set
+}
+
+type cacheEntryKey struct {
+	kind int
+	text string
+}
+
+type cacheEntry struct {
+	key   cacheEntryKey
+	value interface{}
+}
+
+func newExpressionCache(capacity int) *expressionCache {
+	return &expressionCache{capacity: capacity, entries: make(map[cacheEntryKey]*list.Element), order: list.New()}
+}
+
+// get returns the entry of kind for text, computing and adding it with
+// create when it is missing. Failures of create are not cached.
+func (c *expressionCache) get(kind int, text string, create func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return create()
+	}
+	key := cacheEntryKey{kind, text}
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		c.reportLookup(kind, true)
+		return element.Value.(*cacheEntry).value, nil
+	}
+	c.mu.Unlock()
+	c.reportLookup(kind, false)
+
+	// Created outside the lock: concurrent misses may both create the value
+	value, err := create()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*cacheEntry).value, nil
+	}
+	var evicted []cacheEntryKey
+	if c.capacity > 0 {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key, value})
+		evicted = c.evict()
+	}
+	c.mu.Unlock()
+	c.reportEvictions(evicted)
+	return value, nil
+}
+
+func (c *expressionCache) reportLookup(kind int, hit bool) {
+	if c.onLookup != nil {
+		c.onLookup(kind, hit)
+	}
+}
+
+// resize changes the number of entries kept, evicting the least recently
+// used ones if there are more.
+func (c *expressionCache) resize(capacity int) {
+	c.mu.Lock()
+	c.capacity = capacity
+	evicted := c.evict()
+	c.mu.Unlock()
+	c.reportEvictions(evicted)
+}
+
+// len returns the number of entries.
+func (c *expressionCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// evict removes the least recently used entries beyond the capacity and
+// returns their keys, to be reported once the lock is released.
+func (c *expressionCache) evict() []cacheEntryKey {
+	var evicted []cacheEntryKey
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		key := oldest.Value.(*cacheEntry).key
+		delete(c.entries, key)
+		if c.onEvict != nil {
+			evicted = append(evicted, key)
+		}
+	}
+	return evicted
+}
+
+func (c *expressionCache) reportEvictions(evicted []cacheEntryKey) {
+	for _, key := range evicted {
+		c.onEvict(key.kind, key.text)
+	}
+}
+
+// SetExpressionCacheSize sets how many expressions the engine keeps parsed,
+// DefaultExpressionCacheSize by default. Expressions are cached by their full
+// text along with the XPath expressions and regular expressions they use, and
+// the least recently used ones are dropped first. Zero disables the cache. It
+// is safe to call while expressions are being evaluated.
+func (ee *ExpressionEngine) SetExpressionCacheSize(size int) {
+	ee.expressionCache.resize(max(size, 0))
+}
+
+// parse returns the syntax tree of fullExpression. The tree is shared
+// between evaluations and must not be modified.
+func (ee *ExpressionEngine) parse(fullExpression string) *Expression {
+	parsed, _ := ee.expressionCache.get(cachedPipeline, fullExpression, func() (interface{}, error) {
+		return parseExpression(fullExpression), nil
+	})
+	return parsed.(*Expression)
+}
+
+// withExpressionCache makes payload queries under ctx use cache.
+func withExpressionCache(ctx context.Context, cache *expressionCache) context.Context {
+	if cache == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, expressionCacheKey{}, cache)
+}
+
+// xpathNamespacesKey is the context key of the xpathNamespaces of an evaluation.
+type xpathNamespacesKey struct{}
+
+// xpathNamespaces binds prefixes to namespace URIs in XPath expressions.
+type xpathNamespaces struct {
+	bindings map[string]string
+	key      string // The bindings in a canonical form, to cache expressions compiled with them apart
+}
+
+func newXPathNamespaces(bindings map[string]string) *xpathNamespaces {
+	ns := &xpathNamespaces{bindings: make(map[string]string, len(bindings))}
+	prefixes := make([]string, 0, len(bindings))
+	for prefix, uri := range bindings {
+		ns.bindings[prefix] = uri
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	var key strings.Builder
+	for _, prefix := range prefixes {
+		key.WriteString("\x00" + prefix + "=" + bindings[prefix])
+	}
+	ns.key = key.String()
+	return ns
+}
+
+// withXPathNamespaces makes XPath queries under ctx resolve prefixes with ns.
+func withXPathNamespaces(ctx context.Context, ns *xpathNamespaces) context.Context {
+	if ns == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, xpathNamespacesKey{}, ns)
+}
+
+// compileXPath compiles expression with the namespace bindings of the
+// evaluation, or takes it from the cache of the evaluation. A compiled
+// expression keeps state while it is evaluated, so each one is used by one
+// evaluation at a time: call release once the result has been read.
+func compileXPath(ctx context.Context, expression string) (expr *xpath.Expr, release func(), err error) {
+	ns, _ := ctx.Value(xpathNamespacesKey{}).(*xpathNamespaces)
+	compile := func() (*xpath.Expr, error) { return compileXPathNS(expression, ns) }
+	cache, _ := ctx.Value(expressionCacheKey{}).(*expressionCache)
+	if cache == nil {
+		expr, err = compile()
+		return expr, func() {}, err
+	}
+	key := expression
+	if ns != nil {
+		key += ns.key
+	}
+	pool, err := cache.get(cachedXPath, key, func() (interface{}, error) {
+		expr, err := compile()
+		if err != nil {
+			return nil, err
+		}
+		pool := &sync.Pool{New: func() interface{} {
+			expr, _ := compile() // Compiled once already
+			return expr
+		}}
+		pool.Put(expr)
+		return pool, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	expr = pool.(*sync.Pool).Get().(*xpath.Expr)
+	return expr, func() { pool.(*sync.Pool).Put(expr) }, nil
+}
+
+// compileXPathNS compiles expression with the prefixes of ns, if any.
+func compileXPathNS(expression string, ns *xpathNamespaces) (*xpath.Expr, error) {
+	if ns == nil {
+		return xpath.Compile(expression)
+	}
+	return xpath.CompileWithNS(expression, ns.bindings)
+}
+
+// compileRegex compiles pattern, or takes it from the cache of the evaluation.
+func compileRegex(ctx context.Context, pattern string) (*regexp.Regexp, error) {
+	cache, _ := ctx.Value(expressionCacheKey{}).(*expressionCache)
+	re, err := cache.get(cachedRegex, pattern, func() (interface{}, error) {
+		return regexp.Compile(pattern)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return re.(*regexp.Regexp), nil
+}
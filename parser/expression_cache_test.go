@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestExpressionCacheEviction(t *testing.T) {
+	tests := []struct {
+		name        string
+		capacity    int
+		gets        []string
+		wantCreated []string // Keys whose value had to be created, in order
+		wantLen     int
+	}{
+		{name: "hits", capacity: 2, gets: []string{"a", "b", "a", "b"}, wantCreated: []string{"a", "b"}, wantLen: 2},
+		{name: "least recently used is evicted", capacity: 2, gets: []string{"a", "b", "a", "c", "a", "b"}, wantCreated: []string{"a", "b", "c", "b"}, wantLen: 2},
+		{name: "disabled", capacity: 0, gets: []string{"a", "a"}, wantCreated: []string{"a", "a"}, wantLen: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newExpressionCache(tt.capacity)
+			var created []string
+			for _, key := range tt.gets {
+				value, err := cache.get(cachedPipeline, key, func() (interface{}, error) {
+					created = append(created, key)
+					return key + "!", nil
+				})
+				if err != nil || value != key+"!" {
+					t.Fatalf("get(%q) = %v, %v", key, value, err)
+				}
+			}
+			if !reflect.DeepEqual(created, tt.wantCreated) {
+				t.Errorf("created %q, want %q", created, tt.wantCreated)
+			}
+			if got := cache.len(); got != tt.wantLen {
+				t.Errorf("len = %d, want %d", got, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestExpressionCacheSkipsFailures(t *testing.T) {
+	cache := newExpressionCache(4)
+	calls := 0
+	create := func() (interface{}, error) {
+		calls++
+		return nil, errors.New("invalid")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.get(cachedXPath, "//[", create); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if calls != 2 || cache.len() != 0 {
+		t.Errorf("got %d calls and %d entries, want 2 and 0", calls, cache.len())
+	}
+}
+
+func TestSetExpressionCacheSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		expressions []string
+		wantLen     int
+	}{
+		// Each XPath expression caches its pipeline and its compiled XPath
+		{name: "default", size: DefaultExpressionCacheSize, expressions: []string{"xpath:/order/id", "xpath:/order/id", "xpath:count(//line) | toString"}, wantLen: 4},
+		{name: "bounded", size: 3, expressions: []string{"xpath:/order/id", "xpath:count(//line) | toString", "xpath:/order/id"}, wantLen: 3},
+		{name: "disabled", size: 0, expressions: []string{"xpath:/order/id", "xpath:/order/id"}, wantLen: 0},
+		{name: "negative disables", size: -1, expressions: []string{"xpath:/order/id"}, wantLen: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetExpressionCacheSize(tt.size)
+			msgCtx := NewMessageContext([]byte(`<order><id>A-1</id><line/><line/></order>`), "application/xml", engine)
+			want := map[string]interface{}{"xpath:/order/id": "A-1", "xpath:count(//line) | toString": "2"}
+			for _, expression := range tt.expressions {
+				result, err := msgCtx.EvaluateExpression(expression)
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", expression, err)
+				}
+				if result.Value != want[expression] {
+					t.Errorf("%s: got %#v, want %#v", expression, result.Value, want[expression])
+				}
+			}
+			if got := engine.expressionCache.len(); got != tt.wantLen {
+				t.Errorf("cache has %d entries, want %d", got, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestExpressionCacheConcurrentEvaluation(t *testing.T) {
+	engine := NewEngine()
+	tests := []struct {
+		name        string
+		contentType string
+		payload     func(i int) string
+		expression  string
+		want        func(i int) interface{}
+	}{
+		{
+			name:        "xpath",
+			contentType: "application/xml",
+			payload:     func(i int) string { return fmt.Sprintf("<order><id>%d</id></order>", i) },
+			expression:  "xpath:/order/id",
+			want:        func(i int) interface{} { return fmt.Sprint(i) },
+		},
+		{
+			name:        "css",
+			contentType: "text/html",
+			payload:     func(i int) string { return fmt.Sprintf("<html><body><p class=\"id\">%d</p></body></html>", i) },
+			expression:  "css:p.id",
+			want:        func(i int) interface{} { return fmt.Sprint(i) },
+		},
+		{
+			name:        "regex",
+			contentType: "text/plain",
+			payload:     func(i int) string { return fmt.Sprintf("order %d shipped", i) },
+			expression:  `regex:order (\d+)`,
+			want:        func(i int) interface{} { return fmt.Sprint(i) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 20; j++ {
+						result, err := engine.Evaluate(mustPayload(t, tt.payload(i), tt.contentType), tt.expression)
+						if err != nil {
+							t.Errorf("unexpected error: %v", err)
+							return
+						}
+						if result.Value != tt.want(i) {
+							t.Errorf("got %#v, want %#v", result.Value, tt.want(i))
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func mustPayload(t *testing.T, content, contentType string) PayloadObject {
+	payload, err := NewPayloadFactory().CreatePayload([]byte(content), contentType)
+	if err != nil {
+		t.Error(err)
+	}
+	return payload
+}
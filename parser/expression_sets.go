@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadExpressionSet reads named expressions from a JSON or YAML file that
+// maps names to expressions, e.g.
+//
+//	orderId: jsonpath:order.id
+//	embeddedOrder: xpath:/order/details/text() | extractAsJSON
+//
+// for SetExpressions. Files starting with '{' are read as JSON.
+func LoadExpressionSet(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read expression set: %w", err)
+	}
+	expressions, err := ParseExpressionSet(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expression set %s: %w", path, err)
+	}
+	return expressions, nil
+}
+
+// ParseExpressionSet parses named expressions from JSON or YAML (see
+// LoadExpressionSet).
+func ParseExpressionSet(data []byte) (map[string]string, error) {
+	expressions := map[string]string{}
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = json.Unmarshal(data, &expressions)
+	} else {
+		err = yaml.Unmarshal(data, &expressions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return expressions, nil
+}
+
+// SetExpressions replaces all the expressions registered on the engine with
+// expressions, e.g. to apply a changed expression set without a restart.
+// The expressions may use each other in any order, and those of the
+// fallback chain. The whole set is checked first, as RegisterExpression
+// checks one expression, and the registered set is left unchanged if any
+// fails: the error joins one error per failing expression. Otherwise the set
+// is swapped in atomically: each evaluation expands its references from
+// either the old set or the new one.
+func (ee *ExpressionEngine) SetExpressions(expressions map[string]string) error {
+	set := make(map[string]string, len(expressions))
+	for name, expression := range expressions {
+		set[name] = expression
+	}
+	ee.expressionsLock.Lock()
+	defer ee.expressionsLock.Unlock()
+	sets := []map[string]string{set}
+	for e := ee.fallback.Load(); e != nil; e = e.fallback.Load() {
+		if fallbackSet := e.expressions.Load(); fallbackSet != nil {
+			sets = append(sets, *fallbackSet)
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if err := ee.checkNamedExpression(lookupExpressions(sets), name, set[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	ee.expressions.Store(&set)
+	return nil
+}
+
+// LoadExpressions replaces the expressions registered on the engine with
+// those of a JSON or YAML file (see LoadExpressionSet and SetExpressions).
+// Calling it again when the file changes reloads them.
+func (ee *ExpressionEngine) LoadExpressions(path string) error {
+	expressions, err := LoadExpressionSet(path)
+	if err != nil {
+		return err
+	}
+	return ee.SetExpressions(expressions)
+}
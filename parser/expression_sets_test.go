@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadExpressions(t *testing.T) {
+	dir := t.TempDir()
+	yamlSet := filepath.Join(dir, "expressions.yaml")
+	jsonSet := filepath.Join(dir, "expressions.json")
+	files := map[string]string{
+		// References may come before the expressions they use
+		yamlSet: "total: '@order | extractAsJSON | jsonpath:total'\norder: jsonpath:order\n",
+		jsonSet: `{"total": "jsonpath:order.total | toNumber", "id": "jsonpath:order.id"}`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engine := NewEngine()
+	msgCtx := NewMessageContext([]byte(`{"order": {"id": "A1", "total": "12.5"}}`), "application/json", engine)
+	if err := engine.LoadExpressions(yamlSet); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := msgCtx.EvaluateNamed("total"); err != nil || result.Value != "12.5" {
+		t.Fatalf("got %v, %v, want 12.5 from the YAML set", result.Value, err)
+	}
+
+	if err := engine.LoadExpressions(jsonSet); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := msgCtx.EvaluateNamed("total"); err != nil || result.Value != 12.5 {
+		t.Fatalf("got %v, %v, want 12.5 as a number from the JSON set", result.Value, err)
+	}
+	if _, err := msgCtx.EvaluateNamed("order"); err == nil {
+		t.Error("expected an error for an expression of the replaced set")
+	}
+
+	if err := engine.LoadExpressions(filepath.Join(dir, "missing.yaml")); err == nil || !strings.Contains(err.Error(), "failed to read expression set") {
+		t.Errorf("got %v, want a read error", err)
+	}
+}
+
+func TestSetExpressionsInvalid(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.SetExpressions(map[string]string{"id": "jsonpath:id"}); err != nil {
+		t.Fatal(err)
+	}
+	err := engine.SetExpressions(map[string]string{
+		"a":       "@b | trim",
+		"b":       "@a",
+		"bad":     "jsonpath:id | noSuchPipe",
+		"missing": "@nowhere",
+		"nested":  "jsonpath:items | map(@nested)",
+		"ok":      "jsonpath:id",
+	})
+	if err == nil {
+		t.Fatal("expected an error for an invalid set")
+	}
+	for _, want := range []string{"refers to itself through a -> b -> a", "invalid expression 'bad'", "no expression is registered as 'nowhere'", "nested -> nested"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "'ok'") {
+		t.Errorf("error %q reports a valid expression", err)
+	}
+	if _, ok := engine.namedExpression("id"); !ok {
+		t.Error("a failed SetExpressions changed the registered set")
+	}
+
+	if _, err := ParseExpressionSet([]byte("[1, 2]")); err == nil {
+		t.Error("expected an error for a set that is not a map")
+	}
+}
+
+func TestSetExpressionsWhileEvaluating(t *testing.T) {
+	engine := NewEngine()
+	sets := []map[string]string{
+		{"value": "@inner", "inner": "jsonpath:a"},
+		{"value": "@inner | upper", "inner": "jsonpath:b"},
+	}
+	if err := engine.SetExpressions(sets[0]); err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext([]byte(`{"a": "x", "b": "y"}`), "application/json", engine)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				// Mixing the sets would give "X" or "y"
+				if result, err := msgCtx.EvaluateNamed("value"); err != nil || result.Value != "x" && result.Value != "Y" {
+					t.Errorf("got %v, %v, want x or Y", result.Value, err)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 200; j++ {
+		if err := engine.SetExpressions(sets[j%2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
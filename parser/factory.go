@@ -1,31 +1,271 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
+	"mime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	// No aliasing needed here if no conflicts
 )
 
+// PayloadConstructor parses raw content into a PayloadObject.
+type PayloadConstructor func(raw []byte) (PayloadObject, error)
+
 // PayloadFactory creates PayloadObjects based on content type.
-type PayloadFactory struct{}
+type PayloadFactory struct {
+	descriptors       *DescriptorRegistry         // Protobuf message types known to this factory
+	avroSchemas       *AvroSchemas                // Avro writer schemas known to this factory
+	fixedWidthLayouts *FixedWidthLayouts          // Fixed-width record layouts known to this factory
+	jwtVerifier       atomic.Pointer[JWTVerifier] // Verifies JWT payloads when set
+	sniffingDisabled  atomic.Bool                 // Skips content sniffing of unknown payloads
+	xmlOptions        atomic.Pointer[XMLOptions]  // Parses XML payloads with these options when set
+
+	customMu sync.RWMutex
+	custom   map[string]PayloadConstructor // Application payload types by media type
+}
 
 func NewPayloadFactory() *PayloadFactory {
-	return &PayloadFactory{}
+	return &PayloadFactory{
+		descriptors:       NewDescriptorRegistry(),
+		avroSchemas:       NewAvroSchemas(),
+		fixedWidthLayouts: NewFixedWidthLayouts(),
+		custom:            make(map[string]PayloadConstructor),
+	}
+}
+
+// Register makes the factory parse payloads of contentType with ctor, e.g. a
+// proprietary format such as SWIFT MT messages. Only the media type is used,
+// so "application/x-swift-mt; charset=utf-8" matches a registration of
+// "application/x-swift-mt", and content is transcoded to UTF-8 according to
+// its charset before ctor is called. Registrations take precedence over the
+// built-in payload types. Registering a nil ctor removes the registration.
+func (pf *PayloadFactory) Register(contentType string, ctor func([]byte) (PayloadObject, error)) {
+	pf.customMu.Lock()
+	defer pf.customMu.Unlock()
+	if ctor == nil {
+		delete(pf.custom, mediaType(contentType))
+		return
+	}
+	pf.custom[mediaType(contentType)] = ctor
+}
+
+// customConstructor returns the constructor registered for mt, if any.
+func (pf *PayloadFactory) customConstructor(mt string) (PayloadConstructor, bool) {
+	pf.customMu.RLock()
+	defer pf.customMu.RUnlock()
+	ctor, ok := pf.custom[mt]
+	return ctor, ok
 }
 
 // CreatePayload inspects content type and returns the appropriate PayloadObject.
-// For PoC, parsing happens within the NewXYZPayload constructors.
+// For PoC, parsing happens within the NewXYZPayload constructors. Content in
+// the charset named by the content type is transcoded to UTF-8 first.
 func (pf *PayloadFactory) CreatePayload(raw []byte, contentType string) (PayloadObject, error) {
+	raw, err := decodeCharset(raw, contentType)
+	if err != nil {
+		return nil, err
+	}
 	// Normalize content type (e.g., "application/json; charset=utf-8" -> "application/json")
-	normalizedContentType := strings.ToLower(strings.Split(contentType, ";")[0])
+	normalizedContentType := mediaType(contentType)
+
+	if ctor, ok := pf.customConstructor(normalizedContentType); ok {
+		payload, err := ctor(raw)
+		if err == nil && payload == nil {
+			err = fmt.Errorf("constructor of content type %s returned no payload", normalizedContentType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+
+	// Payloads of unknown type are parsed as the format they look like, if any
+	if (normalizedContentType == "" || normalizedContentType == "application/octet-stream") && !pf.sniffingDisabled.Load() {
+		if sniffed := sniffContentType(raw); sniffed != "" {
+			if payload, err := pf.CreatePayload(bytes.TrimPrefix(raw, []byte("\ufeff")), sniffed); err == nil {
+				return payload, nil
+			}
+		}
+		return NewBinaryPayload(raw)
+	}
 
 	switch normalizedContentType {
-	case "application/xml", "text/xml":
-		return NewXMLPayload(raw)
+	case "application/xml":
+		return pf.createXMLPayload(raw)
+	case "text/xml":
+		// SOAP 1.1 envelopes travel as text/xml
+		xp, err := pf.createXMLPayload(raw)
+		if err != nil {
+			return nil, err
+		}
+		if xp.documents != nil || soapEnvelope(xp.parsedDoc) == nil {
+			return xp, nil
+		}
+		return newSOAPPayload(xp)
+	case "application/soap+xml":
+		xp, err := pf.createXMLPayload(raw)
+		if err != nil {
+			return nil, err
+		}
+		return newSOAPPayload(xp)
+	case "text/html":
+		return NewHTMLPayload(raw)
 	case "application/json":
 		return NewJSONPayload(raw)
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return NewNDJSONPayload(raw)
+	case "application/yaml", "text/yaml", "application/x-yaml":
+		return NewYAMLPayload(raw)
+	case "application/toml", "application/x-toml":
+		return NewTOMLPayload(raw)
+	case "text/x-java-properties", "text/x-properties":
+		return NewPropertiesPayload(raw, JavaProperties)
+	case "text/x-dotenv", "application/x-dotenv":
+		return NewPropertiesPayload(raw, DotEnv)
+	case "text/markdown", "text/x-markdown":
+		return NewMarkdownPayload(raw)
+	case "text/plain":
+		// Plain text bodies may also carry front matter (e.g. CMS notification templates)
+		return NewTextPayload(raw)
+	case "text/csv":
+		_, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content type %s: %w", contentType, err)
+		}
+		options, err := csvOptionsFromParams(params)
+		if err != nil {
+			return nil, err
+		}
+		return NewCSVPayload(raw, options)
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return pf.createProtobufPayload(raw, contentType, ProtobufBinary)
+	case "text/x-protobuf", "application/x-protobuf-text":
+		return pf.createProtobufPayload(raw, contentType, ProtobufText)
+	case "application/x-protobuf+json", "application/protobuf+json":
+		return pf.createProtobufPayload(raw, contentType, ProtobufJSON)
+	case "avro/binary", "application/avro":
+		return pf.createAvroPayload(raw, contentType)
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return NewMessagePackPayload(raw)
+	case "application/cbor":
+		return NewCBORPayload(raw)
+	case "application/octet-stream":
+		return NewBinaryPayload(raw)
+	case "application/vnd.apache.parquet", "application/x-parquet":
+		return NewParquetPayload(raw)
+	case "application/x-www-form-urlencoded":
+		return NewFormPayload(raw)
+	case "text/x-syslog", "application/x-syslog":
+		return NewLogPayload(raw, LogFormatSyslog)
+	case "text/x-logfmt", "application/x-logfmt":
+		return NewLogPayload(raw, LogFormatLogfmt)
+	case "text/x-fixed-width":
+		return pf.createFixedWidthPayload(raw, contentType)
+	case "application/jwt":
+		if verifier := pf.jwtVerifier.Load(); verifier != nil {
+			return NewVerifiedJWTPayload(raw, *verifier)
+		}
+		return NewJWTPayload(raw)
+	case "multipart/form-data", "multipart/related":
+		return NewMultipartPayload(raw, contentType)
 	// Add cases for other types here
 	default:
-		return nil, fmt.Errorf("unsupported content type: %s", contentType)
+		if isJSONMediaType(normalizedContentType) { // e.g. application/problem+json
+			return NewJSONPayload(raw)
+		}
+		if isXMLMediaType(normalizedContentType) { // e.g. application/atom+xml, image/svg+xml
+			return pf.createXMLPayload(raw)
+		}
+		return nil, &ErrUnsupportedContentType{ContentType: contentType}
+	}
+}
+
+// createXMLPayload parses raw as XML with the factory's XML options.
+func (pf *PayloadFactory) createXMLPayload(raw []byte) (*XMLPayload, error) {
+	if options := pf.xmlOptions.Load(); options != nil {
+		return NewXMLPayloadWithOptions(raw, *options)
+	}
+	return NewXMLPayload(raw)
+}
+
+// strictXMLEntities applies the DTD policy of the factory's XML options to
+// raw, which is parsed without repair, and returns the entities it declares.
+func (pf *PayloadFactory) strictXMLEntities(raw []byte) (map[string]string, error) {
+	var options XMLOptions
+	if current := pf.xmlOptions.Load(); current != nil {
+		options = *current
+	}
+	options.Repair = false
+	return xmlEntities(raw, options)
+}
+
+// createProtobufPayload decodes raw using the message type named by the content
+// type, e.g. "application/x-protobuf; messageType=com.acme.Order". An
+// "encoding" parameter (binary, text or json) overrides the encoding implied
+// by the media type.
+func (pf *PayloadFactory) createProtobufPayload(raw []byte, contentType string, encoding ProtobufEncoding) (PayloadObject, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %s: %w", contentType, err)
+	}
+	messageType := params["messagetype"] // mime lower-cases parameter names
+	if messageType == "" {
+		messageType = params["proto"]
+	}
+	if messageType == "" {
+		return nil, fmt.Errorf("protobuf content type %s does not name a message type", contentType)
+	}
+	switch strings.ToLower(params["encoding"]) {
+	case "":
+	case "binary":
+		encoding = ProtobufBinary
+	case "text", "textproto":
+		encoding = ProtobufText
+	case "json":
+		encoding = ProtobufJSON
+	default:
+		return nil, fmt.Errorf("unsupported protobuf encoding %q in content type %s", params["encoding"], contentType)
+	}
+	descriptor, err := pf.descriptors.FindMessage(messageType)
+	if err != nil {
+		return nil, err
+	}
+	return newProtobufPayload(raw, descriptor, pf.descriptors.resolver(), encoding)
+}
+
+// createAvroPayload decodes raw with the schema named by the content type
+// ("avro/binary; schema=order-v1"), or with the writer schema referenced by
+// the Confluent wire-format header when no schema is named.
+func (pf *PayloadFactory) createAvroPayload(raw []byte, contentType string) (PayloadObject, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %s: %w", contentType, err)
+	}
+	if schemaName := params["schema"]; schemaName != "" {
+		codec, err := pf.avroSchemas.codecByName(schemaName)
+		if err != nil {
+			return nil, err
+		}
+		return NewAvroPayload(raw, codec)
+	}
+	return NewAvroPayloadFromRegistry(raw, pf.avroSchemas)
+}
+
+// createFixedWidthPayload parses raw with the layout named by the content
+// type, e.g. "text/x-fixed-width; layout=customer-v1".
+func (pf *PayloadFactory) createFixedWidthPayload(raw []byte, contentType string) (PayloadObject, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %s: %w", contentType, err)
+	}
+	if params["layout"] == "" {
+		return nil, fmt.Errorf("fixed-width content type %s does not name a layout", contentType)
+	}
+	layout, err := pf.fixedWidthLayouts.layout(params["layout"])
+	if err != nil {
+		return nil, err
 	}
+	return NewFixedWidthPayload(raw, layout)
 }
@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newSwiftMTPayload converts the ":tag:value" fields of a SWIFT MT message
+// block to a JSON object keyed by tag.
+func newSwiftMTPayload(raw []byte) (PayloadObject, error) {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 || parts[0] != "" {
+			return nil, fmt.Errorf("invalid MT field %q", line)
+		}
+		fields["f"+parts[1]] = parts[2]
+	}
+	converted, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONPayload(converted)
+}
+
+func TestRegisterPayloadType(t *testing.T) {
+	message := ":20:REF-1001\n:32A:240501EUR1250,00\n:59:ACME GMBH\n"
+
+	tests := []struct {
+		name        string
+		register    map[string]func([]byte) (PayloadObject, error)
+		contentType string
+		raw         string
+		expression  string
+		want        interface{}
+		wantErr     string
+	}{
+		{
+			name:        "custom format",
+			register:    map[string]func([]byte) (PayloadObject, error){"application/x-swift-mt": newSwiftMTPayload},
+			contentType: "application/x-swift-mt",
+			raw:         message,
+			expression:  "jsonpath:f32A",
+			want:        "240501EUR1250,00",
+		},
+		{
+			name:        "parameters and case ignored",
+			register:    map[string]func([]byte) (PayloadObject, error){"Application/X-Swift-MT; variant=103": newSwiftMTPayload},
+			contentType: "application/x-swift-mt; charset=utf-8",
+			raw:         message,
+			expression:  "jsonpath:f20",
+			want:        "REF-1001",
+		},
+		{
+			name:        "constructor error",
+			register:    map[string]func([]byte) (PayloadObject, error){"application/x-swift-mt": newSwiftMTPayload},
+			contentType: "application/x-swift-mt",
+			raw:         "garbage",
+			expression:  "jsonpath:f20",
+			wantErr:     `invalid MT field "garbage"`,
+		},
+		{
+			name: "overrides built-in type",
+			register: map[string]func([]byte) (PayloadObject, error){"text/csv": func(raw []byte) (PayloadObject, error) {
+				return NewJSONPayload([]byte(`{"rows":` + fmt.Sprint(strings.Count(string(raw), "\n")) + `}`))
+			}},
+			contentType: "text/csv",
+			raw:         "a,b\n1,2\n",
+			expression:  "jsonpath:rows",
+			want:        float64(2),
+		},
+		{
+			name:        "nil payload",
+			register:    map[string]func([]byte) (PayloadObject, error){"application/x-empty": func([]byte) (PayloadObject, error) { return nil, nil }},
+			contentType: "application/x-empty",
+			expression:  "jsonpath:a",
+			wantErr:     "constructor of content type application/x-empty returned no payload",
+		},
+		{
+			name:        "unregistered",
+			contentType: "application/x-swift-mt",
+			raw:         message,
+			expression:  "jsonpath:f20",
+			wantErr:     "unsupported content type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			for contentType, ctor := range tt.register {
+				engine.RegisterPayloadType(contentType, ctor)
+			}
+			result, err := NewMessageContext([]byte(tt.raw), tt.contentType, engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterPayloadTypeRemoval(t *testing.T) {
+	factory := NewPayloadFactory()
+	factory.Register("text/plain", newSwiftMTPayload)
+	if _, err := factory.CreatePayload([]byte("hello"), "text/plain"); err == nil {
+		t.Fatal("expected the registered constructor to reject plain text")
+	}
+
+	factory.Register("text/plain", nil)
+	payload, err := factory.CreatePayload([]byte("hello"), "text/plain")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := payload.(*TextPayload); !ok {
+		t.Errorf("got %T, want the built-in *TextPayload", payload)
+	}
+}
+
+func TestRegisteredPayloadInMultipart(t *testing.T) {
+	engine := NewEngine()
+	engine.RegisterPayloadType("application/x-swift-mt", newSwiftMTPayload)
+	body := "--b\r\nContent-Disposition: form-data; name=\"mt\"\r\nContent-Type: application/x-swift-mt\r\n\r\n:20:REF-7\r\n--b--\r\n"
+
+	result, err := NewMessageContext([]byte(body), `multipart/form-data; boundary=b`, engine).EvaluateExpression("part:mt | jsonpath:f20")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Value != "REF-7" {
+		t.Errorf("got %#v, want %q", result.Value, "REF-7")
+	}
+}
@@ -0,0 +1,246 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// FixedWidthFieldType is how a fixed-width field is converted.
+type FixedWidthFieldType int
+
+const (
+	FixedWidthText   FixedWidthFieldType = iota // Trailing spaces are trimmed
+	FixedWidthNumber                            // Integer, or decimal with Scale implied digits (COBOL PIC 9(5)V99)
+)
+
+// FixedWidthField is a field of a fixed-width record. Positions count
+// characters from 0.
+type FixedWidthField struct {
+	Name   string
+	Start  int
+	Length int
+	Type   FixedWidthFieldType
+	Scale  int // Implied decimal places of a FixedWidthNumber without a '.'
+	Occurs int // Number of consecutive repetitions, read as an array; 0 for a single value
+}
+
+// FixedWidthLayout describes the records of a FixedWidthPayload. Records are
+// lines; when RecordLength is set, content without line breaks (e.g. a
+// mainframe dataset) is split every RecordLength characters instead.
+type FixedWidthLayout struct {
+	Fields       []FixedWidthField
+	RecordLength int
+}
+
+// validate checks that fields have names and do not overlap.
+func (fl FixedWidthLayout) validate() error {
+	if len(fl.Fields) == 0 {
+		return fmt.Errorf("layout has no fields")
+	}
+	names := make(map[string]bool, len(fl.Fields))
+	covered := make(map[int]string)
+	for _, f := range fl.Fields {
+		if f.Name == "" || names[f.Name] {
+			return fmt.Errorf("missing or duplicate field name '%s'", f.Name)
+		}
+		names[f.Name] = true
+		if f.Start < 0 || f.Length <= 0 || f.Occurs < 0 || f.Scale < 0 {
+			return fmt.Errorf("invalid position of field '%s'", f.Name)
+		}
+		for i := f.Start; i < f.end(); i++ {
+			if other, ok := covered[i]; ok {
+				return fmt.Errorf("field '%s' overlaps field '%s' at position %d", f.Name, other, i)
+			}
+			covered[i] = f.Name
+		}
+	}
+	return nil
+}
+
+// end returns the position after the field, including its repetitions.
+func (f FixedWidthField) end() int {
+	return f.Start + f.Length*max(f.Occurs, 1)
+}
+
+// FixedWidthLayouts holds the layouts available to a PayloadFactory by name.
+// It is safe for concurrent use.
+type FixedWidthLayouts struct {
+	mu      sync.RWMutex
+	layouts map[string]FixedWidthLayout
+}
+
+func NewFixedWidthLayouts() *FixedWidthLayouts {
+	return &FixedWidthLayouts{layouts: make(map[string]FixedWidthLayout)}
+}
+
+// Register validates layout and stores it under name.
+func (fl *FixedWidthLayouts) Register(name string, layout FixedWidthLayout) error {
+	if err := layout.validate(); err != nil {
+		return fmt.Errorf("invalid fixed-width layout %s: %w", name, err)
+	}
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.layouts[name] = layout
+	return nil
+}
+
+func (fl *FixedWidthLayouts) layout(name string) (FixedWidthLayout, error) {
+	fl.mu.RLock()
+	defer fl.mu.RUnlock()
+	layout, ok := fl.layouts[name]
+	if !ok {
+		return FixedWidthLayout{}, fmt.Errorf("unknown fixed-width layout: %s", name)
+	}
+	return layout, nil
+}
+
+// FixedWidthPayload handles fixed-width text records, such as legacy
+// mainframe files. Every record is converted to an object keyed by field name
+// when the payload is created, and the records are exposed as a JSON array,
+// so queries ("fixed:" or jsonpath expressions) are gjson paths such as
+// "0.CUST-ID" or "#.BALANCE". Number fields may have a leading or trailing
+// sign; blank numbers are null.
+type FixedWidthPayload struct {
+	rawContent  []byte
+	layout      FixedWidthLayout
+	jsonResult  gjson.Result
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// NewFixedWidthPayload splits content into records and converts their fields.
+func NewFixedWidthPayload(content []byte, layout FixedWidthLayout) (*FixedWidthPayload, error) {
+	if err := layout.validate(); err != nil {
+		return nil, fmt.Errorf("invalid fixed-width layout: %w", err)
+	}
+	records := splitFixedWidthRecords(string(content), layout.RecordLength)
+	values := make([]map[string]interface{}, len(records))
+	for i, record := range records {
+		value, err := layout.parseRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixed-width record %d: %w", i+1, err)
+		}
+		values[i] = value
+	}
+	view, err := json.Marshal(values)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert fixed-width records to JSON view", InnerError: err}
+	}
+	return &FixedWidthPayload{rawContent: content, layout: layout, jsonResult: gjson.ParseBytes(view), contentType: "text/x-fixed-width"}, nil
+}
+
+// splitFixedWidthRecords returns the non-blank lines of content, or chunks of
+// recordLength characters when content has no line breaks.
+func splitFixedWidthRecords(content string, recordLength int) [][]rune {
+	var records [][]rune
+	if recordLength > 0 && !strings.ContainsAny(content, "\r\n") {
+		runes := []rune(content)
+		for start := 0; start < len(runes); start += recordLength {
+			records = append(records, runes[start:min(start+recordLength, len(runes))])
+		}
+		return records
+	}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) != "" {
+			records = append(records, []rune(line))
+		}
+	}
+	return records
+}
+
+// parseRecord converts the fields of one record. Fields past the end of a
+// short record are read as blanks.
+func (fl FixedWidthLayout) parseRecord(record []rune) (map[string]interface{}, error) {
+	value := make(map[string]interface{}, len(fl.Fields))
+	for _, f := range fl.Fields {
+		if f.Occurs == 0 {
+			v, err := f.parse(record, f.Start)
+			if err != nil {
+				return nil, err
+			}
+			value[f.Name] = v
+			continue
+		}
+		items := make([]interface{}, f.Occurs)
+		for i := range items {
+			v, err := f.parse(record, f.Start+i*f.Length)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		value[f.Name] = items
+	}
+	return value, nil
+}
+
+func (f FixedWidthField) parse(record []rune, start int) (interface{}, error) {
+	var text string
+	if start < len(record) {
+		text = string(record[start:min(start+f.Length, len(record))])
+	}
+	if f.Type == FixedWidthText {
+		return strings.TrimRight(text, " "), nil
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	sign := ""
+	switch {
+	case strings.HasPrefix(text, "-") || strings.HasPrefix(text, "+"):
+		sign, text = text[:1], text[1:]
+	case strings.HasSuffix(text, "-") || strings.HasSuffix(text, "+"):
+		sign, text = text[len(text)-1:], text[:len(text)-1]
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || strings.ContainsAny(text, "eEnNiI") {
+		return nil, fmt.Errorf("field '%s' is not a number: %q", f.Name, text)
+	}
+	if !strings.Contains(text, ".") && f.Scale > 0 {
+		number /= math.Pow10(f.Scale)
+	}
+	if sign == "-" {
+		number = -number
+	}
+	return number, nil
+}
+
+func (fp *FixedWidthPayload) GetRawBytes() []byte {
+	return fp.rawContent
+}
+
+func (fp *FixedWidthPayload) GetContentType() string {
+	return fp.contentType
+}
+
+// Query evaluates a gjson path against the JSON view of the records.
+func (fp *FixedWidthPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(fp.jsonResult, expression)
+}
+
+func (fp *FixedWidthPayload) jsonView() gjson.Result {
+	return fp.jsonResult
+}
+
+// AsJSON returns the records as a JSON array of objects.
+func (fp *FixedWidthPayload) AsJSON() []byte {
+	return []byte(fp.jsonResult.Raw)
+}
+
+func (fp *FixedWidthPayload) AsString() (string, error) {
+	return string(fp.rawContent), nil
+}
+
+func (fp *FixedWidthPayload) GetUnderlying() interface{} {
+	return fp.jsonResult.Value()
+}
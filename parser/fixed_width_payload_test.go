@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testCustomerLayout = FixedWidthLayout{
+	Fields: []FixedWidthField{
+		{Name: "ID", Start: 0, Length: 4, Type: FixedWidthNumber},
+		{Name: "NAME", Start: 4, Length: 8},
+		{Name: "BALANCE", Start: 12, Length: 6, Type: FixedWidthNumber, Scale: 2},
+		{Name: "PHONE", Start: 18, Length: 3, Occurs: 2},
+	},
+	RecordLength: 24,
+}
+
+func TestFixedWidthPayloadQuery(t *testing.T) {
+	records := "0001ALICE   012345555666\r\n" +
+		"0002BOB     -00150777\n" +
+		"\n" +
+		"0003CAROL   1.5"
+
+	tests := []struct {
+		name       string
+		content    string
+		expression string
+		want       interface{}
+	}{
+		{name: "number field", content: records, expression: "fixed:0.ID", want: float64(1)},
+		{name: "text field trimmed", content: records, expression: "fixed:0.NAME", want: "ALICE"},
+		{name: "implied decimals", content: records, expression: "fixed:0.BALANCE", want: 123.45},
+		{name: "leading sign", content: records, expression: "fixed:1.BALANCE", want: -1.5},
+		{name: "explicit decimal point", content: records, expression: "fixed:2.BALANCE", want: 1.5},
+		{name: "occurs", content: records, expression: "fixed:0.PHONE.1", want: "666"},
+		{name: "short record", content: records, expression: "fixed:1.PHONE.1", want: ""},
+		{name: "blank lines skipped", content: records, expression: "fixed:#", want: float64(3)},
+		{name: "all names", content: records, expression: "fixed:#.NAME", want: []interface{}{"ALICE", "BOB", "CAROL"}},
+		{name: "jsonpath", content: records, expression: "jsonpath:#(ID==2).NAME", want: "BOB"},
+		{name: "record length split", content: "0001ALICE   000100111222" + "0002BOB     00020+333444", expression: "fixed:1.BALANCE", want: 0.2},
+		{name: "trailing sign", content: "0004DAVE    00250-", expression: "fixed:0.BALANCE", want: -2.5},
+		{name: "blank number", content: "    EVE", expression: "fixed:0.ID", want: nil},
+	}
+	engine := NewEngine()
+	if err := engine.RegisterFixedWidthLayout("customer", testCustomerLayout); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(tt.content), "text/x-fixed-width; layout=customer", engine).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixedWidthToJSONPipe(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterFixedWidthLayout("customer", testCustomerLayout); err != nil {
+		t.Fatal(err)
+	}
+	message := []byte(`{"batch":"0001ALICE   012345555666\n0002BOB     -00150777"}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "query converted records", expression: "jsonpath:batch | fixedWidthToJSON:customer | jsonpath:1.NAME", want: "BOB"},
+		{name: "conversion result", expression: "jsonpath:batch | fixedWidthToJSON:customer", want: `[{"BALANCE":123.45,"ID":1,"NAME":"ALICE","PHONE":["555","666"]},{"BALANCE":-1.5,"ID":2,"NAME":"BOB","PHONE":["777",""]}]`},
+		{name: "unknown layout", expression: "jsonpath:batch | fixedWidthToJSON:order", wantErr: "unknown fixed-width layout: order"},
+		{name: "invalid number", expression: "jsonpath:batch | regex:ALICE | fixedWidthToJSON:customer", wantErr: "field 'ID' is not a number"},
+		{name: "fixed query on json", expression: "fixed:0.ID", wantErr: "fixed-width queries require a fixed-width payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(message, "application/json", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixedWidthLayoutInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  FixedWidthLayout
+		wantErr string
+	}{
+		{name: "no fields", layout: FixedWidthLayout{}, wantErr: "layout has no fields"},
+		{name: "unnamed field", layout: FixedWidthLayout{Fields: []FixedWidthField{{Length: 2}}}, wantErr: "missing or duplicate field name ''"},
+		{name: "duplicate name", layout: FixedWidthLayout{Fields: []FixedWidthField{{Name: "A", Length: 2}, {Name: "A", Start: 2, Length: 2}}}, wantErr: "missing or duplicate field name 'A'"},
+		{name: "zero length", layout: FixedWidthLayout{Fields: []FixedWidthField{{Name: "A"}}}, wantErr: "invalid position of field 'A'"},
+		{name: "overlap", layout: FixedWidthLayout{Fields: []FixedWidthField{{Name: "A", Length: 4}, {Name: "B", Start: 3, Length: 2}}}, wantErr: "field 'B' overlaps field 'A' at position 3"},
+		{name: "occurs overlap", layout: FixedWidthLayout{Fields: []FixedWidthField{{Name: "A", Length: 2, Occurs: 3}, {Name: "B", Start: 5, Length: 1}}}, wantErr: "field 'B' overlaps field 'A' at position 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewEngine().RegisterFixedWidthLayout("layout", tt.layout)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFixedWidthPayloadFactory(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterFixedWidthLayout("customer", testCustomerLayout); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		wantErr     string
+	}{
+		{name: "registered layout", contentType: "text/x-fixed-width; layout=customer"},
+		{name: "missing layout", contentType: "text/x-fixed-width", wantErr: "does not name a layout"},
+		{name: "unknown layout", contentType: "text/x-fixed-width; layout=order", wantErr: "unknown fixed-width layout: order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := engine.payloadFactory.CreatePayload([]byte("0001ALICE"), tt.contentType)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, ok := payload.(*FixedWidthPayload); !ok {
+				t.Fatalf("got %T, want *FixedWidthPayload", payload)
+			}
+		})
+	}
+}
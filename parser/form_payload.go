@@ -0,0 +1,230 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// FormPayload handles URL-encoded form bodies
+// ("application/x-www-form-urlencoded"). Keys and values are URL-decoded and
+// nested by their key syntax:
+//
+//	name=ann                    {"name": "ann"}
+//	tag=a&tag=b                 {"tag": ["a", "b"]}        (repeated keys)
+//	items[0].qty=2              {"items": [{"qty": "2"}]}
+//	address[city]=Oslo          {"address": {"city": "Oslo"}}
+//	ids[]=4&ids[]=7             {"ids": ["4", "7"]}
+//
+// Values stay strings. "form:" expressions use the same key syntax
+// (form:items[0].qty) and may use any other gjson path syntax; the document is
+// also exposed as JSON, so jsonpath expressions work too.
+type FormPayload struct {
+	rawContent  []byte
+	native      map[string]interface{}
+	jsonResult  gjson.Result
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// formSegment is one step of a form key: a map key, an array index, or an
+// append ("[]").
+type formSegment struct {
+	key    string
+	index  int
+	isIdx  bool
+	append bool
+}
+
+// NewFormPayload decodes a URL-encoded form body.
+func NewFormPayload(content []byte) (*FormPayload, error) {
+	native := map[string]interface{}{}
+	for _, pair := range bytes.Split(content, []byte("&")) {
+		pair = bytes.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(string(pair), "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Reason: fmt.Sprintf("invalid form key '%s'", rawKey), InnerError: err}
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Reason: fmt.Sprintf("invalid value for form key '%s'", key), InnerError: err}
+		}
+		segments, err := parseFormKey(key)
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Reason: fmt.Sprintf("invalid form key '%s'", key), InnerError: err}
+		}
+		updated, err := insertFormValue(native, segments, value)
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Reason: fmt.Sprintf("conflicting form key '%s'", key), InnerError: err}
+		}
+		native = updated.(map[string]interface{})
+	}
+	jsonView, err := marshalJSONView(native)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert form to JSON view", InnerError: err}
+	}
+	return &FormPayload{
+		rawContent:  content,
+		native:      native,
+		jsonResult:  gjson.ParseBytes(jsonView),
+		contentType: "application/x-www-form-urlencoded",
+	}, nil
+}
+
+// parseFormKey splits "items[0].qty" into its segments.
+func parseFormKey(key string) ([]formSegment, error) {
+	var segments []formSegment
+	var name strings.Builder
+	flush := func() {
+		if name.Len() > 0 {
+			segments = append(segments, formSegment{key: name.String()})
+			name.Reset()
+		}
+	}
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(key[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated '['")
+			}
+			inner := key[i+1 : i+end]
+			switch index, err := strconv.Atoi(inner); {
+			case inner == "":
+				segments = append(segments, formSegment{append: true})
+			case err == nil && index >= 0:
+				segments = append(segments, formSegment{index: index, isIdx: true})
+			default:
+				segments = append(segments, formSegment{key: inner})
+			}
+			i += end
+		default:
+			name.WriteByte(key[i])
+		}
+	}
+	flush()
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty key")
+	}
+	return segments, nil
+}
+
+// insertFormValue stores value at segments below node and returns the
+// (possibly new) node. A repeated leaf turns into an array of values.
+func insertFormValue(node interface{}, segments []formSegment, value string) (interface{}, error) {
+	if len(segments) == 0 {
+		switch existing := node.(type) {
+		case nil:
+			return value, nil
+		case string:
+			return []interface{}{existing, value}, nil
+		case []interface{}:
+			return append(existing, value), nil
+		default:
+			return nil, fmt.Errorf("a value and nested keys share a name")
+		}
+	}
+
+	segment, rest := segments[0], segments[1:]
+	switch {
+	case segment.append || segment.isIdx:
+		if node == nil {
+			node = []interface{}{}
+		}
+		array, ok := node.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("an array and a non-array share a name")
+		}
+		index := len(array)
+		if segment.isIdx {
+			index = segment.index
+		}
+		for len(array) <= index {
+			array = append(array, nil)
+		}
+		element, err := insertFormValue(array[index], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		array[index] = element
+		return array, nil
+	default:
+		if node == nil {
+			node = map[string]interface{}{}
+		}
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("nested keys and a non-object share a name")
+		}
+		child, err := insertFormValue(object[segment.key], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		object[segment.key] = child
+		return object, nil
+	}
+}
+
+func (fp *FormPayload) GetRawBytes() []byte {
+	return fp.rawContent
+}
+
+func (fp *FormPayload) GetContentType() string {
+	return fp.contentType
+}
+
+// Query evaluates a form path such as "items[0].qty" (see FormPayload).
+func (fp *FormPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(fp.jsonResult, formPathToGJSON(expression))
+}
+
+// formPathToGJSON rewrites bracket segments as gjson path segments:
+// "items[0].qty" becomes "items.0.qty" and "address[city]" "address.city".
+func formPathToGJSON(path string) string {
+	path = strings.TrimSpace(path)
+	if !strings.Contains(path, "[") {
+		return path
+	}
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '[' {
+			sb.WriteByte(path[i])
+			continue
+		}
+		end := strings.IndexByte(path[i:], ']')
+		if end < 0 {
+			sb.WriteString(path[i:])
+			break
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(path[i+1 : i+end])
+		i += end
+	}
+	return sb.String()
+}
+
+func (fp *FormPayload) jsonView() gjson.Result {
+	return fp.jsonResult
+}
+
+func (fp *FormPayload) AsString() (string, error) {
+	return string(fp.rawContent), nil
+}
+
+func (fp *FormPayload) GetUnderlying() interface{} {
+	return fp.native
+}
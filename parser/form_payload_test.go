@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormPayloadQuery(t *testing.T) {
+	const form = "customer=Ann+O%27Neil&tag=vip&tag=new&items[0].sku=A1&items[0].qty=2&items[1].sku=B%2F7" +
+		"&address[city]=Oslo&ids[]=4&ids[]=7&note=&flag"
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "decoded value", expression: "form:customer", want: "Ann O'Neil"},
+		{name: "repeated key", expression: "form:tag", want: []interface{}{"vip", "new"}},
+		{name: "indexed field", expression: "form:items[0].qty", want: "2"},
+		{name: "decoded nested value", expression: "form:items[1].sku", want: "B/7"},
+		{name: "bracket key", expression: "form:address[city]", want: "Oslo"},
+		{name: "dotted key", expression: "form:address.city", want: "Oslo"},
+		{name: "appended values", expression: "form:ids", want: []interface{}{"4", "7"}},
+		{name: "empty value", expression: "form:note", want: ""},
+		{name: "key without value", expression: "form:flag", want: ""},
+		{name: "gjson syntax", expression: "form:items.#.sku", want: []interface{}{"A1", "B/7"}},
+		{name: "jsonpath view", expression: "jsonpath:items.1.sku", want: "B/7"},
+		{name: "missing key", expression: "form:items[2].sku", wantErr: true},
+		{name: "wrong payload for xpath", expression: "xpath:/a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(form), "application/x-www-form-urlencoded", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFormPayloadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "bad escape", content: "a=%zz", wantErr: "invalid value for form key 'a'"},
+		{name: "bad key escape", content: "%=1", wantErr: "invalid form key '%'"},
+		{name: "unterminated bracket", content: "a[0=1", wantErr: "invalid form key 'a[0'"},
+		{name: "value then nested key", content: "a=1&a[b]=2", wantErr: "conflicting form key 'a[b]'"},
+		{name: "nested key then value", content: "a[b]=2&a=1", wantErr: "conflicting form key 'a'"},
+		{name: "object then array", content: "a[b]=2&a[0]=1", wantErr: "conflicting form key 'a[0]'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFormPayload([]byte(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// defaultIndent is the indentation of prettyPrint without an argument, and
+// maxIndent the most spaces it can be given.
+const (
+	defaultIndent = "  "
+	maxIndent     = 16
+)
+
+// formatIndent returns the indentation of a prettyPrint pipe call: a string,
+// or a number of spaces.
+func formatIndent(call pipeCall) (string, error) {
+	if len(call.args) == 0 {
+		return defaultIndent, nil
+	}
+	if _, ok := call.args[0].(float64); ok {
+		n, err := intArg(call, 0)
+		if err != nil {
+			return "", err
+		}
+		if n > maxIndent {
+			return "", fmt.Errorf("indent of %d spaces is more than %d", n, maxIndent)
+		}
+		return strings.Repeat(" ", n), nil
+	}
+	return stringArg(call, 0)
+}
+
+// formatDocument pretty-prints a JSON or XML document with indent, or
+// minifies it when indent is empty. XML is parsed with the DTD policy of the
+// engine's XML options.
+func (ee *ExpressionEngine) formatDocument(s, indent string) (string, error) {
+	isJSON, err := isJSONText(s)
+	if err != nil {
+		return "", err
+	}
+	if isJSON {
+		return formatJSON(s, indent), nil
+	}
+	entities, err := ee.payloadFactory.strictXMLEntities([]byte(s))
+	if err != nil {
+		return "", err
+	}
+	return formatXML([]byte(s), entities, indent)
+}
+
+// isJSONText reports whether s is a JSON document rather than XML.
+func isJSONText(s string) (bool, error) {
+	trimmed := strings.TrimSpace(s)
+	switch {
+	case json.Valid([]byte(trimmed)):
+		return true, nil
+	case strings.HasPrefix(trimmed, "<"):
+		return false, nil
+	}
+	return false, fmt.Errorf("input is neither JSON nor XML")
+}
+
+// formatJSON indents a JSON document, or removes its insignificant
+// whitespace when indent is empty.
+func formatJSON(s, indent string) string {
+	var buf bytes.Buffer
+	if indent == "" {
+		json.Compact(&buf, []byte(strings.TrimSpace(s))) // Already validated by isJSONText
+	} else {
+		json.Indent(&buf, []byte(strings.TrimSpace(s)), "", indent)
+	}
+	return buf.String()
+}
+
+// xmlFormatNode is a node of an XML document being reformatted: an element
+// with its children, or character data, a comment, a processing instruction
+// or a directive.
+type xmlFormatNode struct {
+	token    xml.Token
+	children []*xmlFormatNode
+}
+
+// formatXML reformats an XML document. With an indent, element-only content
+// is indented one element per line; otherwise whitespace between elements
+// and comments are removed. Text is kept as it is, so elements with mixed
+// content are written unchanged apart from their escaping. Prefixes and the
+// order of attributes are kept, and entities must be declared in entities.
+func formatXML(content []byte, entities map[string]string, indent string) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.Entity = entities
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	document := &xmlFormatNode{}
+	stack := []*xmlFormatNode{document}
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		parent := stack[len(stack)-1]
+		switch token := token.(type) {
+		case xml.EndElement:
+			start, ok := parent.token.(xml.StartElement)
+			if !ok || start.Name != token.Name {
+				return "", fmt.Errorf("unexpected end element </%s>", qualifiedName(token.Name.Space, token.Name.Local))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.StartElement:
+			node := &xmlFormatNode{token: token.Copy()}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		default:
+			parent.children = append(parent.children, &xmlFormatNode{token: xml.CopyToken(token)})
+		}
+	}
+	if len(stack) > 1 {
+		start := stack[len(stack)-1].token.(xml.StartElement)
+		return "", fmt.Errorf("element <%s> is not closed", qualifiedName(start.Name.Space, start.Name.Local))
+	}
+
+	var out strings.Builder
+	first := true
+	for _, node := range document.children {
+		if !writeFormattedXML(&out, node, indent, 0, first) {
+			continue
+		}
+		first = false
+	}
+	if first {
+		return "", fmt.Errorf("the document has no element")
+	}
+	return out.String(), nil
+}
+
+// writeFormattedXML writes node at depth and reports whether it wrote
+// anything: whitespace between elements and, when minifying, comments are
+// dropped. first is whether node is the first node written at its level.
+func writeFormattedXML(out *strings.Builder, node *xmlFormatNode, indent string, depth int, first bool) bool {
+	switch token := node.token.(type) {
+	case xml.CharData:
+		if len(bytes.TrimSpace(token)) == 0 {
+			return false
+		}
+		writeFormattedBreak(out, indent, depth, first)
+		writeCanonicalText(out, string(token), false)
+	case xml.Comment:
+		if indent == "" {
+			return false
+		}
+		writeFormattedBreak(out, indent, depth, first)
+		out.WriteString("<!--" + string(token) + "-->")
+	case xml.ProcInst:
+		writeFormattedBreak(out, indent, depth, first)
+		out.WriteString("<?" + token.Target)
+		if inst := strings.TrimLeft(string(token.Inst), " \t\r\n"); inst != "" {
+			out.WriteString(" " + inst)
+		}
+		out.WriteString("?>")
+	case xml.Directive:
+		writeFormattedBreak(out, indent, depth, first)
+		out.WriteString("<!" + string(token) + ">")
+	case xml.StartElement:
+		writeFormattedBreak(out, indent, depth, first)
+		name := writeXMLStartTag(out, token, len(node.children) == 0)
+		if len(node.children) == 0 {
+			return true
+		}
+		if hasText(node) {
+			// Text is significant, so mixed content is written as it is
+			for _, child := range node.children {
+				writeInlineXML(out, child, indent)
+			}
+		} else {
+			wrote := false
+			for _, child := range node.children {
+				if writeFormattedXML(out, child, indent, depth+1, !wrote) {
+					wrote = true
+				}
+			}
+			if wrote {
+				writeFormattedBreak(out, indent, depth, false)
+			}
+		}
+		out.WriteString("</" + name + ">")
+	}
+	return true
+}
+
+// writeFormattedBreak starts a new line at depth when indenting, unless node
+// is the first node of the document.
+func writeFormattedBreak(out *strings.Builder, indent string, depth int, first bool) {
+	if indent == "" || (first && depth == 0) {
+		return
+	}
+	out.WriteString("\n" + strings.Repeat(indent, depth))
+}
+
+// writeInlineXML writes node and its descendants without changing their
+// whitespace.
+func writeInlineXML(out *strings.Builder, node *xmlFormatNode, indent string) {
+	switch token := node.token.(type) {
+	case xml.CharData:
+		writeCanonicalText(out, string(token), false)
+	case xml.StartElement:
+		name := writeXMLStartTag(out, token, len(node.children) == 0)
+		if len(node.children) == 0 {
+			return
+		}
+		for _, child := range node.children {
+			writeInlineXML(out, child, indent)
+		}
+		out.WriteString("</" + name + ">")
+	default:
+		writeFormattedXML(out, node, indent, 0, true)
+	}
+}
+
+// writeXMLStartTag writes the start tag of element, as an empty-element tag
+// if empty is set, and returns the element's qualified name.
+func writeXMLStartTag(out *strings.Builder, element xml.StartElement, empty bool) string {
+	name := qualifiedName(element.Name.Space, element.Name.Local)
+	out.WriteString("<" + name)
+	for _, attr := range element.Attr {
+		out.WriteString(" " + qualifiedName(attr.Name.Space, attr.Name.Local) + `="`)
+		writeCanonicalText(out, attr.Value, true)
+		out.WriteString(`"`)
+	}
+	if empty {
+		out.WriteString("/>")
+	} else {
+		out.WriteString(">")
+	}
+	return name
+}
+
+// hasText reports whether element node has character data other than
+// whitespace.
+func hasText(node *xmlFormatNode) bool {
+	for _, child := range node.children {
+		if text, ok := child.token.(xml.CharData); ok && len(bytes.TrimSpace(text)) > 0 {
+			return true
+		}
+	}
+	return false
+}
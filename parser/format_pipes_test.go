@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFormatPipes(t *testing.T) {
+	documents := map[string]string{
+		"json":   "{ \"b\" : [1, 2],\n \"a\": {\"c\": \"<x>\"} }",
+		"xml":    "<?xml version=\"1.0\"?>\n<!-- order -->\n<o:order xmlns:o=\"urn:o\" id=\"7\">\n  <o:line sku=\"a&amp;b\">  2 </o:line>\n\n<empty/><note>a <b>bold</b> move</note><!-- end --></o:order>\n",
+		"entity": "<!DOCTYPE r [<!ENTITY co \"ACME\">]><r> <c>&co;</c> </r>",
+		"text":   "plain text",
+		"broken": "<a><b></a>",
+	}
+	body, err := json.Marshal(documents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		options    XMLOptions
+		want       string
+		wantErr    string
+	}{
+		{name: "pretty json", expression: "jsonpath:json | prettyPrint", want: "{\n  \"b\": [\n    1,\n    2\n  ],\n  \"a\": {\n    \"c\": \"<x>\"\n  }\n}"},
+		{name: "pretty json with tab", expression: `jsonpath:json | prettyPrint("\t")`, want: "{\n\t\"b\": [\n\t\t1,\n\t\t2\n\t],\n\t\"a\": {\n\t\t\"c\": \"<x>\"\n\t}\n}"},
+		{name: "minify json", expression: "jsonpath:json | minify", want: `{"b":[1,2],"a":{"c":"<x>"}}`},
+		{name: "array result", expression: "jsonpath:json | extractAsJSON | jsonpath:b | prettyPrint(1)", want: "[\n 1,\n 2\n]"},
+		{name: "pretty xml", expression: "jsonpath:xml | prettyPrint", want: "<?xml version=\"1.0\"?>\n<!-- order -->\n<o:order xmlns:o=\"urn:o\" id=\"7\">\n  <o:line sku=\"a&amp;b\">  2 </o:line>\n  <empty/>\n  <note>a <b>bold</b> move</note>\n  <!-- end -->\n</o:order>"},
+		{name: "minify xml", expression: "jsonpath:xml | minify", want: `<?xml version="1.0"?><o:order xmlns:o="urn:o" id="7"><o:line sku="a&amp;b">  2 </o:line><empty/><note>a <b>bold</b> move</note></o:order>`},
+		{name: "minify then pretty", expression: "jsonpath:xml | minify | prettyPrint(4)", want: "<?xml version=\"1.0\"?>\n<o:order xmlns:o=\"urn:o\" id=\"7\">\n    <o:line sku=\"a&amp;b\">  2 </o:line>\n    <empty/>\n    <note>a <b>bold</b> move</note>\n</o:order>"},
+		{name: "dtd entity", expression: "jsonpath:entity | minify", options: XMLOptions{DTD: DTDInternalSubset}, want: `<!DOCTYPE r [<!ENTITY co "ACME">]><r><c>ACME</c></r>`},
+		{name: "neither json nor xml", expression: "jsonpath:text | prettyPrint", wantErr: "input is neither JSON nor XML"},
+		{name: "malformed xml", expression: "jsonpath:broken | minify", wantErr: "unexpected end element </a>"},
+		{name: "indent too wide", expression: "jsonpath:json | prettyPrint(40)", wantErr: "more than 16"},
+		{name: "minify takes no arguments", expression: "jsonpath:json | minify(2)", wantErr: "takes no arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetXMLOptions(tt.options)
+			result, err := NewMessageContext(body, "application/json", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got  %q\nwant %q", result.Value, tt.want)
+			}
+		})
+	}
+}
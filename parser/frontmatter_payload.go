@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"bytes"
+)
+
+// FrontMatterPayload handles documents made of a YAML front-matter block
+// followed by a free-form body (Markdown or plain text, i.e. "text/markdown"
+// or "text/plain"):
+//
+//	---
+//	title: Order shipped
+//	channel: email
+//	---
+//	Hello **{{name}}**, your order is on its way.
+//
+// Queries address the front matter with gjson paths; the body is exposed
+// unchanged through Body.
+type FrontMatterPayload struct {
+	rawContent  []byte
+	frontMatter *YAMLPayload // Nil when the document has no front matter
+	body        string
+	contentType string
+}
+
+// frontMatterDocument is implemented by payloads that expose YAML front matter
+// and a body, and can therefore be used with frontmatter: and body: expressions.
+type frontMatterDocument interface {
+	PayloadObject
+	QueryFrontMatter(expression string) (QueryResult, error)
+	Body() string
+}
+
+// NewFrontMatterPayload splits a Markdown document into front matter and body.
+// A document that does not start with a "---" line has no front matter and
+// is treated as body only.
+func NewFrontMatterPayload(content []byte) (*FrontMatterPayload, error) {
+	return newFrontMatterPayload(content, "text/markdown")
+}
+
+// newFrontMatterPayload is NewFrontMatterPayload for a body of the given
+// content type ("text/markdown" or "text/plain").
+func newFrontMatterPayload(content []byte, contentType string) (*FrontMatterPayload, error) {
+	fmBytes, body, found := splitFrontMatter(content)
+	payload := &FrontMatterPayload{
+		rawContent:  content,
+		body:        string(body),
+		contentType: contentType,
+	}
+	if found {
+		frontMatter, err := NewYAMLPayload(fmBytes)
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Reason: "front matter parsing failed", InnerError: err}
+		}
+		payload.frontMatter = frontMatter
+	}
+	return payload, nil
+}
+
+// splitFrontMatter separates a leading "---" delimited YAML block from the body.
+// The closing delimiter may be "---" or "...".
+func splitFrontMatter(content []byte) (frontMatter, body []byte, found bool) {
+	rest := bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+	firstLine, rest, ok := cutLine(rest)
+	if !ok || string(bytes.TrimRight(firstLine, " \t")) != "---" {
+		return nil, content, false
+	}
+	start := len(content) - len(rest)
+	for offset := start; ; {
+		line, next, more := cutLine(content[offset:])
+		trimmed := string(bytes.TrimRight(line, " \t"))
+		if trimmed == "---" || trimmed == "..." {
+			return content[start:offset], next, true
+		}
+		if !more {
+			return nil, content, false // Unterminated block, not front matter
+		}
+		offset = len(content) - len(next)
+	}
+}
+
+// cutLine returns the first line of b (without its line ending) and the
+// remainder. more is false when b contained no line ending.
+func cutLine(b []byte) (line, rest []byte, more bool) {
+	idx := bytes.IndexByte(b, '\n')
+	if idx < 0 {
+		return b, nil, false
+	}
+	return bytes.TrimSuffix(b[:idx], []byte("\r")), b[idx+1:], true
+}
+
+func (fp *FrontMatterPayload) GetRawBytes() []byte {
+	return fp.rawContent
+}
+
+func (fp *FrontMatterPayload) GetContentType() string {
+	return fp.contentType
+}
+
+// Query evaluates a gjson path against the front matter.
+func (fp *FrontMatterPayload) Query(expression string) (QueryResult, error) {
+	if fp.frontMatter == nil {
+		return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: expression, Reason: "document has no front matter", InnerError: ErrNoMatch}
+	}
+	return fp.frontMatter.Query(expression)
+}
+
+// QueryFrontMatter evaluates a gjson path against the front matter.
+func (fp *FrontMatterPayload) QueryFrontMatter(expression string) (QueryResult, error) {
+	return fp.Query(expression)
+}
+
+// Body returns the document content following the front matter.
+func (fp *FrontMatterPayload) Body() string {
+	return fp.body
+}
+
+// HasFrontMatter reports whether the document started with a front-matter block.
+func (fp *FrontMatterPayload) HasFrontMatter() bool {
+	return fp.frontMatter != nil
+}
+
+func (fp *FrontMatterPayload) AsString() (string, error) {
+	return string(fp.rawContent), nil
+}
+
+func (fp *FrontMatterPayload) GetUnderlying() interface{} {
+	if fp.frontMatter == nil {
+		return nil
+	}
+	return fp.frontMatter.GetUnderlying()
+}
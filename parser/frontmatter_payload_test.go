@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		name            string
+		content         string
+		wantFrontMatter string
+		wantBody        string
+		wantFound       bool
+	}{
+		{name: "front matter and body", content: "---\ntitle: A\n---\nHello\n", wantFrontMatter: "title: A\n", wantBody: "Hello\n", wantFound: true},
+		{name: "CRLF line endings", content: "---\r\ntitle: A\r\n---\r\nHello", wantFrontMatter: "title: A\r\n", wantBody: "Hello", wantFound: true},
+		{name: "dot closing delimiter", content: "---\ntitle: A\n...\nHello", wantFrontMatter: "title: A\n", wantBody: "Hello", wantFound: true},
+		{name: "byte order mark", content: "\xef\xbb\xbf---\ntitle: A\n---\n", wantFrontMatter: "title: A\n", wantBody: "", wantFound: true},
+		{name: "trailing spaces on delimiters", content: "--- \ntitle: A\n---  \nHello", wantFrontMatter: "title: A\n", wantBody: "Hello", wantFound: true},
+		{name: "closing delimiter at end of input", content: "---\ntitle: A\n---", wantFrontMatter: "title: A\n", wantBody: "", wantFound: true},
+		{name: "empty front matter", content: "---\n---\nHello", wantFrontMatter: "", wantBody: "Hello", wantFound: true},
+		{name: "no front matter", content: "Hello\n---\nWorld", wantBody: "Hello\n---\nWorld"},
+		{name: "unterminated front matter", content: "---\ntitle: A\nHello", wantBody: "---\ntitle: A\nHello"},
+		{name: "delimiter only", content: "---", wantBody: "---"},
+		{name: "empty document", content: "", wantBody: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frontMatter, body, found := splitFrontMatter([]byte(tt.content))
+			if found != tt.wantFound {
+				t.Fatalf("found = %v, want %v", found, tt.wantFound)
+			}
+			if found && string(frontMatter) != tt.wantFrontMatter {
+				t.Errorf("front matter = %q, want %q", frontMatter, tt.wantFrontMatter)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestFrontMatterPayloadQuery(t *testing.T) {
+	const doc = "---\ntitle: Order shipped\ntags: [a, b]\n---\nHello **Bob**\n"
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     bool
+	}{
+		{name: "markdown front matter", content: doc, contentType: "text/markdown", expression: "frontmatter:title", want: "Order shipped"},
+		{name: "plain text front matter", content: doc, contentType: "text/plain", expression: "frontmatter:tags.1", want: "b"},
+		{name: "body", content: doc, contentType: "text/markdown", expression: "body:", want: "Hello **Bob**\n"},
+		{name: "body without front matter", content: "Just text", contentType: "text/plain", expression: "body:", want: "Just text"},
+		{name: "query without front matter", content: "Just text", contentType: "text/plain", expression: "frontmatter:title", wantErr: true},
+		{name: "body does not take a path", content: doc, contentType: "text/markdown", expression: "body:x", wantErr: true},
+		{name: "invalid front matter", content: "---\ntitle: [\n---\n", contentType: "text/markdown", expression: "body:", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(tt.content), tt.contentType, NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
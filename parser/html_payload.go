@@ -0,0 +1,277 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// HTMLPayload handles HTML documents. Content is parsed the way browsers do
+// (unclosed tags, stray end tags and unquoted attributes are repaired), so
+// real-world markup that the strict XML parser rejects can still be queried,
+// with XPath or with CSS selectors.
+type HTMLPayload struct {
+	rawContent  []byte
+	parsedDoc   *html.Node
+	contentType string
+}
+
+// NewHTMLPayload parses content as HTML.
+func NewHTMLPayload(content []byte) (*HTMLPayload, error) {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "HTML parsing failed", InnerError: err}
+	}
+	return &HTMLPayload{
+		rawContent:  content,
+		parsedDoc:   doc,
+		contentType: "text/html",
+	}, nil
+}
+
+func (hp *HTMLPayload) GetRawBytes() []byte {
+	return hp.rawContent
+}
+
+func (hp *HTMLPayload) GetContentType() string {
+	return hp.contentType
+}
+
+// Query evaluates an XPath expression against the HTML document.
+func (hp *HTMLPayload) Query(expression string) (QueryResult, error) {
+	return hp.QueryContext(context.Background(), expression)
+}
+
+// QueryContext is like Query but stops iterating over a node-set result once ctx is done.
+// Node-set results are reduced to the text of each node (or the value of each
+// attribute), like XMLPayload does.
+func (hp *HTMLPayload) QueryContext(ctx context.Context, expression string) (QueryResult, error) {
+	exprCompiled, release, err := compileXPathAt(ctx, expression, newHTMLNavigator(hp.parsedDoc))
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "XPath compilation failed", InnerError: err}
+	}
+	defer release()
+
+	switch result := exprCompiled.Evaluate(newHTMLNavigator(hp.parsedDoc)).(type) {
+	case string:
+		return QueryResult{Value: result, Type: StringResult}, nil
+	case float64:
+		return QueryResult{Value: result, Type: NumberResult}, nil
+	case bool:
+		return QueryResult{Value: result, Type: BooleanResult}, nil
+	case *xpath.NodeIterator:
+		var results []string
+		var nodes []*html.Node
+		maxElements := maxResultElements(ctx)
+		for result.MoveNext() {
+			if err := ctx.Err(); err != nil {
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "XPath evaluation interrupted", InnerError: err}
+			}
+			if err := checkResultElements(maxElements, len(results)+1, expression); err != nil {
+				return QueryResult{}, err
+			}
+			nav := result.Current().(*htmlNavigator)
+			results = append(results, nav.Value())
+			if nav.attr >= 0 {
+				nodes = append(nodes, nil) // Attributes are represented by their value
+			} else {
+				nodes = append(nodes, nav.curr)
+			}
+		}
+		switch len(results) {
+		case 0:
+			return QueryResult{Value: nil, Type: NodeSetResult}, nil
+		case 1:
+			return QueryResult{Value: results[0], Type: StringResult, nodes: &htmlNodeSet{nodes}}, nil
+		}
+		return QueryResult{Value: results, Type: NodeSetResult, nodes: &htmlNodeSet{nodes}}, nil
+	default:
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("unexpected XPath result type: %T", result)}
+	}
+}
+
+// QuerySelector evaluates a CSS selector against the HTML document.
+// Results have the same shape as XPath node-set results.
+func (hp *HTMLPayload) QuerySelector(selector string) (QueryResult, error) {
+	expression, err := cssToXPath(selector)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: selector, Reason: "invalid CSS selector", InnerError: err}
+	}
+	return hp.Query(expression)
+}
+
+// AsString returns the repaired document serialized as HTML.
+func (hp *HTMLPayload) AsString() (string, error) {
+	var buf bytes.Buffer
+	if err := html.Render(&buf, hp.parsedDoc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (hp *HTMLPayload) GetUnderlying() interface{} {
+	return hp.parsedDoc
+}
+
+// htmlNavigator implements xpath.NodeNavigator over an html.Node tree.
+// Doctype nodes are skipped.
+type htmlNavigator struct {
+	root, curr *html.Node
+	attr       int // Index of the current attribute, -1 when on the node itself
+}
+
+func newHTMLNavigator(root *html.Node) *htmlNavigator {
+	return &htmlNavigator{root: root, curr: root, attr: -1}
+}
+
+func (n *htmlNavigator) NodeType() xpath.NodeType {
+	switch n.curr.Type {
+	case html.DocumentNode:
+		return xpath.RootNode
+	case html.TextNode:
+		return xpath.TextNode
+	case html.CommentNode:
+		return xpath.CommentNode
+	case html.ElementNode:
+		if n.attr != -1 {
+			return xpath.AttributeNode
+		}
+		return xpath.ElementNode
+	}
+	panic(fmt.Sprintf("unknown HTML node type: %v", n.curr.Type))
+}
+
+func (n *htmlNavigator) LocalName() string {
+	if n.attr != -1 {
+		return n.curr.Attr[n.attr].Key
+	}
+	return n.curr.Data
+}
+
+func (n *htmlNavigator) Prefix() string {
+	if n.attr != -1 {
+		return n.curr.Attr[n.attr].Namespace
+	}
+	return ""
+}
+
+func (n *htmlNavigator) Value() string {
+	switch n.curr.Type {
+	case html.CommentNode, html.TextNode:
+		return n.curr.Data
+	case html.ElementNode:
+		if n.attr != -1 {
+			return n.curr.Attr[n.attr].Val
+		}
+	}
+	return htmlInnerText(n.curr)
+}
+
+func (n *htmlNavigator) Copy() xpath.NodeNavigator {
+	c := *n
+	return &c
+}
+
+func (n *htmlNavigator) MoveToRoot() {
+	n.curr, n.attr = n.root, -1
+}
+
+func (n *htmlNavigator) MoveToParent() bool {
+	if n.attr != -1 {
+		n.attr = -1
+		return true
+	}
+	if n.curr.Parent == nil {
+		return false
+	}
+	n.curr = n.curr.Parent
+	return true
+}
+
+func (n *htmlNavigator) MoveToNextAttribute() bool {
+	if n.attr >= len(n.curr.Attr)-1 {
+		return false
+	}
+	n.attr++
+	return true
+}
+
+func (n *htmlNavigator) MoveToChild() bool {
+	if n.attr != -1 {
+		return false
+	}
+	return n.moveTo(skipDoctype(n.curr.FirstChild, true))
+}
+
+func (n *htmlNavigator) MoveToFirst() bool {
+	if n.attr != -1 || n.curr.Parent == nil {
+		return false
+	}
+	return n.moveTo(skipDoctype(n.curr.Parent.FirstChild, true))
+}
+
+func (n *htmlNavigator) MoveToNext() bool {
+	if n.attr != -1 {
+		return false
+	}
+	return n.moveTo(skipDoctype(n.curr.NextSibling, true))
+}
+
+func (n *htmlNavigator) MoveToPrevious() bool {
+	if n.attr != -1 {
+		return false
+	}
+	return n.moveTo(skipDoctype(n.curr.PrevSibling, false))
+}
+
+func (n *htmlNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	node, ok := other.(*htmlNavigator)
+	if !ok || node.root != n.root {
+		return false
+	}
+	n.curr, n.attr = node.curr, node.attr
+	return true
+}
+
+func (n *htmlNavigator) moveTo(node *html.Node) bool {
+	if node == nil {
+		return false
+	}
+	n.curr = node
+	return true
+}
+
+// skipDoctype returns the first sibling from start, moving forward or backward,
+// that is not a doctype.
+func skipDoctype(start *html.Node, forward bool) *html.Node {
+	node := start
+	for node != nil && node.Type == html.DoctypeNode {
+		if forward {
+			node = node.NextSibling
+		} else {
+			node = node.PrevSibling
+		}
+	}
+	return node
+}
+
+// htmlInnerText returns the concatenated text of all text nodes below node.
+func htmlInnerText(node *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(node)
+	return sb.String()
+}
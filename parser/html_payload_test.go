@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Tag soup: unclosed <li> and <p>, unquoted attributes, a stray end tag.
+const testHTML = `<!DOCTYPE html>
+<html><head><title>Shop</title></head>
+<body>
+<div id=main class="content wide">
+  <h1>Products</h1>
+  <ul class=items>
+    <li class="item sale" data-sku=A1><a href="/a1">Pen</a>
+    <li class=item data-sku=B2><a href="/b2">Ink</a>
+    <li class=item data-sku=C3-x><a href='/c3'>Pad</a>
+  </ul>
+  <p>First <b>bold</b>
+  <p>Second</span>
+</div>
+</body></html>`
+
+func TestHTMLPayloadQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "xpath text", expression: "xpath://h1", want: "Products"},
+		{name: "xpath attribute", expression: "xpath://li[2]/a/@href", want: "/b2"},
+		{name: "xpath repaired list", expression: "xpath:count(//li)", want: float64(3)},
+		{name: "xpath repaired paragraphs", expression: "xpath://p", want: []string{"First bold\n  ", "Second\n"}},
+		{name: "xpath string function", expression: "xpath:string(//title)", want: "Shop"},
+		{name: "css type", expression: "css:h1", want: "Products"},
+		{name: "css id and descendant", expression: "css:#main li a", want: []string{"Pen", "Ink", "Pad"}},
+		{name: "css class", expression: "css:li.sale a", want: "Pen"},
+		{name: "css child combinator", expression: "css:ul > li:nth-child(2) > a", want: "Ink"},
+		{name: "css last child", expression: "css:li:last-child a", want: "Pad"},
+		{name: "css first child", expression: "css:ul li:first-child a", want: "Pen"},
+		{name: "css attribute equals", expression: "css:[data-sku=B2] a", want: "Ink"},
+		{name: "css attribute prefix", expression: "css:a[href^='/c']", want: "Pad"},
+		{name: "css attribute suffix", expression: `css:a[href$="1"]`, want: "Pen"},
+		{name: "css attribute word", expression: "css:div[class~=wide] h1", want: "Products"},
+		{name: "css adjacent sibling", expression: "css:li.sale + li a", want: "Ink"},
+		{name: "css general sibling", expression: "css:li.sale ~ li a", want: []string{"Ink", "Pad"}},
+		{name: "css group", expression: "css:title, h1", want: []string{"Shop", "Products"}},
+		{name: "css no match", expression: "css:table", want: nil},
+		{name: "invalid css", expression: "css:li >", wantErr: true},
+		{name: "unsupported pseudo-class", expression: "css:li:hover", wantErr: true},
+		{name: "invalid xpath", expression: "xpath://[", wantErr: true},
+		{name: "unbound xpath variable", expression: "xpath:$body//li", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(testHTML), "text/html; charset=utf-8", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLPayloadQuerySelector(t *testing.T) {
+	payload, err := NewHTMLPayload([]byte(testHTML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "|=" cannot go through the engine, which splits expressions on "|"
+	result, err := payload.QuerySelector("li[data-sku|=C3] a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "Pad" {
+		t.Errorf("got %#v, want %q", result.Value, "Pad")
+	}
+	if _, err := payload.QuerySelector("li["); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+}
+
+func TestCSSToXPath(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     string
+		wantErr  bool
+	}{
+		{selector: "div", want: "//div"},
+		{selector: "*", want: "//*"},
+		{selector: "DIV#a", want: "//div[@id='a']"},
+		{selector: "a > b", want: "//a/b"},
+		{selector: "a b", want: "//a//b"},
+		{selector: "a+b", want: "//a/following-sibling::*[1][self::b]"},
+		{selector: "a ~ b", want: "//a/following-sibling::b"},
+		{selector: "[title=\"it's\"]", want: "//*[@title=\"it's\"]"},
+		{selector: "a, b", want: "//a | //b"},
+		{selector: "", wantErr: true},
+		{selector: "a,", wantErr: true},
+		{selector: "#", wantErr: true},
+		{selector: "[a", wantErr: true},
+		{selector: "[a=]", wantErr: true},
+		{selector: "[a='x]", wantErr: true},
+		{selector: "a:nth-child(2n+1)", wantErr: true},
+		{selector: "a!b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			got, err := cssToXPath(tt.selector)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXPathLiteral(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{in: "plain", want: "'plain'"},
+		{in: "it's", want: `"it's"`},
+		{in: `it's "x"`, want: `concat('it', "'", 's "x"')`},
+	}
+	for _, tt := range tests {
+		if got := xpathLiteral(tt.in); got != tt.want {
+			t.Errorf("xpathLiteral(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,663 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RegisterJoltSpec compiles a Jolt transform spec and stores it under name
+// for the jolt pipe, e.g. "jsonpath:order | jolt(\"orderToInvoice\")".
+// Registering a name again replaces its spec. Engines that have this engine
+// as their fallback can use its specs too.
+//
+// The spec is a chain of operations, as a JSON array, or a single operation
+// object, with "operation" set to "shift", "default" or "remove":
+//
+//   - shift moves the values of input keys matched by the spec keys to the
+//     output paths on the right. Spec keys are literal keys, "a|b"
+//     alternatives or wildcards such as "*" and "price_*". Their values are
+//     an output path, a list of output paths, or a nested spec. In a nested
+//     spec, "$" writes the matched key, "@" the matched value and "#text"
+//     the constant text. Output paths are dot-separated and may refer to
+//     matched keys with "&" (the current key), "&n" (the key n levels up)
+//     and "&(n,m)" (wildcard m of that key). A path segment ending with "[]"
+//     appends to an array, and one ending with "[n]" or "[&n]" sets an array
+//     element. Values written to the same path are collected into an array.
+//   - default adds the keys of the spec that the input lacks or that are
+//     null; nested objects are filled in recursively, and "*" applies a
+//     nested spec to every object or array of the input.
+//   - remove deletes the input keys whose spec value is "", and applies
+//     nested specs to nested objects; "*" and "a|b" match several keys.
+//
+// Input keys of an object are processed in sorted order.
+func (ee *ExpressionEngine) RegisterJoltSpec(name string, spec []byte) error {
+	if name == "" {
+		return fmt.Errorf("Jolt spec name is empty")
+	}
+	chain, err := compileJoltChain(spec)
+	if err != nil {
+		return fmt.Errorf("Jolt spec '%s': %w", name, err)
+	}
+	ee.joltSpecs.Store(name, chain)
+	return nil
+}
+
+// joltChain returns the spec registered as name on this engine or on its
+// fallback chain.
+func (ee *ExpressionEngine) joltChain(name string) ([]joltOperation, bool) {
+	for e := ee; e != nil; e = e.fallback.Load() {
+		if chain, ok := e.joltSpecs.Load(name); ok {
+			return chain.([]joltOperation), true
+		}
+	}
+	return nil, false
+}
+
+// joltTransform applies the spec named by the first argument of the jolt
+// pipe call to the JSON text input, and returns the output as JSON.
+func (ee *ExpressionEngine) joltTransform(call pipeCall, input string) ([]byte, error) {
+	name, err := stringArg(call, 0)
+	if err != nil {
+		return nil, err
+	}
+	chain, ok := ee.joltChain(name)
+	if !ok {
+		return nil, fmt.Errorf("no Jolt spec is registered as '%s'", name)
+	}
+	value, err := decodeJoltJSON([]byte(input))
+	if err != nil {
+		return nil, fmt.Errorf("input is not JSON: %w", err)
+	}
+	for _, operation := range chain {
+		if value, err = operation.apply(value); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(value)
+}
+
+// decodeJoltJSON decodes JSON keeping numbers as json.Number, so that they
+// are written out as they were read.
+func decodeJoltJSON(raw []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// joltOperation is a compiled operation of a Jolt chain.
+type joltOperation interface {
+	apply(value interface{}) (interface{}, error)
+}
+
+// compileJoltChain compiles a Jolt spec: an array of operations or a single
+// operation.
+func compileJoltChain(raw []byte) ([]joltOperation, error) {
+	spec, err := decodeJoltJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+	operations, ok := spec.([]interface{})
+	if !ok {
+		operations = []interface{}{spec}
+	}
+	chain := make([]joltOperation, len(operations))
+	for i, entry := range operations {
+		object, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation %d is not an object", i)
+		}
+		spec, ok := object["spec"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation %d has no spec object", i)
+		}
+		switch object["operation"] {
+		case "shift":
+			chain[i], err = compileJoltShift(spec, 0)
+		case "default":
+			chain[i], err = compileJoltKeyed(spec, false)
+		case "remove":
+			chain[i], err = compileJoltKeyed(spec, true)
+		default:
+			return nil, fmt.Errorf("operation %d: unsupported Jolt operation %v", i, object["operation"])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%v): %w", i, object["operation"], err)
+		}
+	}
+	return chain, nil
+}
+
+// joltKey matches input keys: a literal key, alternatives separated by "|",
+// or a pattern with "*" wildcards.
+type joltKey struct {
+	text    string
+	literal bool
+	pattern *regexp.Regexp // Captures the text matched by each wildcard
+}
+
+func compileJoltKey(key string) joltKey {
+	if !strings.ContainsAny(key, "*|") {
+		return joltKey{text: key, literal: true}
+	}
+	alternatives := strings.Split(key, "|")
+	for i, alternative := range alternatives {
+		pieces := strings.Split(alternative, "*")
+		for j, piece := range pieces {
+			pieces[j] = regexp.QuoteMeta(piece)
+		}
+		alternatives[i] = strings.Join(pieces, "(.*?)")
+	}
+	return joltKey{text: key, pattern: regexp.MustCompile("^(?:" + strings.Join(alternatives, "|") + ")$")}
+}
+
+// match returns the key and the text matched by each wildcard if k matches
+// key.
+func (k joltKey) match(key string) ([]string, bool) {
+	if k.literal {
+		return []string{key}, key == k.text
+	}
+	groups := k.pattern.FindStringSubmatch(key)
+	if groups == nil {
+		return nil, false
+	}
+	matched := []string{key}
+	for _, group := range groups[1:] {
+		if group != "" {
+			matched = append(matched, group)
+		}
+	}
+	return matched, true
+}
+
+// sortJoltKeys orders keys as they are tried: literal keys first, then
+// patterns, with the catch-all "*" last.
+func sortJoltKeys(keys []string) {
+	rank := func(key string) int {
+		switch {
+		case key == "*":
+			return 2
+		case strings.ContainsAny(key, "*|"):
+			return 1
+		}
+		return 0
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if ra, rb := rank(keys[a]), rank(keys[b]); ra != rb {
+			return ra < rb
+		}
+		return keys[a] < keys[b]
+	})
+}
+
+// joltShift is a compiled level of a shift spec.
+type joltShift struct {
+	entries   []joltShiftEntry // Keys matched against the input, in the order they are tried
+	keyPaths  []joltPath       // "$": where the key of this level is written
+	selfPaths []joltPath       // "@": where the value of this level is written
+	constants []joltConstant   // "#text": constants written for this level
+}
+
+type joltShiftEntry struct {
+	key   joltKey
+	paths []joltPath // Where the matched value is written, if spec is nil
+	spec  *joltShift
+}
+
+type joltConstant struct {
+	value string
+	paths []joltPath
+}
+
+// compileJoltShift compiles a shift spec object at depth levels below the
+// top.
+func compileJoltShift(spec map[string]interface{}, depth int) (*joltShift, error) {
+	shift := &joltShift{}
+	keys := make([]string, 0, len(spec))
+	for key := range spec {
+		keys = append(keys, key)
+	}
+	sortJoltKeys(keys)
+	for _, key := range keys {
+		value := spec[key]
+		switch {
+		case key == "$" || key == "@" || strings.HasPrefix(key, "#"):
+			if depth == 0 && key == "$" {
+				return nil, fmt.Errorf("'$' needs a matched key")
+			}
+			paths, err := compileJoltPaths(value, depth)
+			if err != nil {
+				return nil, fmt.Errorf("'%s': %w", key, err)
+			}
+			switch key {
+			case "$":
+				shift.keyPaths = append(shift.keyPaths, paths...)
+			case "@":
+				shift.selfPaths = append(shift.selfPaths, paths...)
+			default:
+				shift.constants = append(shift.constants, joltConstant{value: key[1:], paths: paths})
+			}
+		default:
+			entry := joltShiftEntry{key: compileJoltKey(key)}
+			var err error
+			if nested, ok := value.(map[string]interface{}); ok {
+				entry.spec, err = compileJoltShift(nested, depth+1)
+			} else {
+				entry.paths, err = compileJoltPaths(value, depth+1)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("'%s': %w", key, err)
+			}
+			shift.entries = append(shift.entries, entry)
+		}
+	}
+	return shift, nil
+}
+
+func (s *joltShift) apply(value interface{}) (interface{}, error) {
+	output := map[string]interface{}{}
+	if err := s.shift(value, nil, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// shift writes value, the input at the level of s, to output. matched holds
+// the keys matched on the way to value, the innermost last.
+func (s *joltShift) shift(value interface{}, matched [][]string, output map[string]interface{}) error {
+	for _, path := range s.selfPaths {
+		if err := path.write(output, matched, value); err != nil {
+			return err
+		}
+	}
+	for _, path := range s.keyPaths {
+		if err := path.write(output, matched, matched[len(matched)-1][0]); err != nil {
+			return err
+		}
+	}
+	for _, constant := range s.constants {
+		for _, path := range constant.paths {
+			if err := path.write(output, matched, constant.value); err != nil {
+				return err
+			}
+		}
+	}
+
+	var keys []string
+	children := map[string]interface{}{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			keys = append(keys, key)
+			children[key] = child
+		}
+		sort.Strings(keys)
+	case []interface{}:
+		for i, child := range v {
+			keys = append(keys, strconv.Itoa(i))
+			children[keys[i]] = child
+		}
+	default:
+		// Spec keys match a scalar value itself, e.g. {"true": {"#yes": "flag"}}
+		keys = []string{scalarText(value)}
+		children[keys[0]] = value
+	}
+	for _, key := range keys {
+		for _, entry := range s.entries {
+			groups, ok := entry.key.match(key)
+			if !ok {
+				continue
+			}
+			inner := append(matched[:len(matched):len(matched)], groups)
+			if entry.spec != nil {
+				if err := entry.spec.shift(children[key], inner, output); err != nil {
+					return err
+				}
+			}
+			for _, path := range entry.paths {
+				if err := path.write(output, inner, children[key]); err != nil {
+					return err
+				}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// scalarText returns the text spec keys match a scalar value against.
+func scalarText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// joltPath is a compiled output path of a shift spec.
+type joltPath []joltSegment
+
+// joltSegment is a key of an output path, optionally followed by an array
+// index, or by "[]" to append to an array.
+type joltSegment struct {
+	key    joltTemplate
+	index  joltTemplate // nil without an index
+	append bool
+}
+
+// joltTemplate is text with references to matched keys.
+type joltTemplate []joltPart
+
+// joltPart is literal text, or a reference to wildcard group of the key
+// matched level levels up if ref is set.
+type joltPart struct {
+	text         string
+	ref          bool
+	level, group int
+}
+
+// compileJoltPaths compiles the output paths of a shift spec value, a path
+// or a list of paths, for a key depth levels below the top.
+func compileJoltPaths(value interface{}, depth int) ([]joltPath, error) {
+	var texts []string
+	switch v := value.(type) {
+	case string:
+		texts = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			text, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("output path %v is not a string", item)
+			}
+			texts = append(texts, text)
+		}
+	default:
+		return nil, fmt.Errorf("output path %v is not a string, a list of strings or a spec", value)
+	}
+	paths := make([]joltPath, len(texts))
+	for i, text := range texts {
+		if text == "" {
+			return nil, fmt.Errorf("output path is empty")
+		}
+		for _, segment := range strings.Split(text, ".") {
+			compiled, err := compileJoltSegment(segment, depth)
+			if err != nil {
+				return nil, fmt.Errorf("output path '%s': %w", text, err)
+			}
+			paths[i] = append(paths[i], compiled)
+		}
+	}
+	return paths, nil
+}
+
+func compileJoltSegment(segment string, depth int) (joltSegment, error) {
+	var compiled joltSegment
+	key := segment
+	if open := strings.IndexByte(segment, '['); open >= 0 {
+		if !strings.HasSuffix(segment, "]") {
+			return compiled, fmt.Errorf("unclosed '[' in '%s'", segment)
+		}
+		key = segment[:open]
+		if index := segment[open+1 : len(segment)-1]; index == "" {
+			compiled.append = true
+		} else {
+			var err error
+			if compiled.index, err = compileJoltTemplate(index, depth); err != nil {
+				return compiled, err
+			}
+		}
+	}
+	if key == "" {
+		return compiled, fmt.Errorf("empty key in '%s'", segment)
+	}
+	var err error
+	compiled.key, err = compileJoltTemplate(key, depth)
+	return compiled, err
+}
+
+// referencePattern matches "&", "&n" and "&(n,m)".
+var referencePattern = regexp.MustCompile(`&(?:\((\d+),(\d+)\)|(\d+))?`)
+
+func compileJoltTemplate(text string, depth int) (joltTemplate, error) {
+	var template joltTemplate
+	last := 0
+	for _, loc := range referencePattern.FindAllStringSubmatchIndex(text, -1) {
+		template = append(template, joltPart{text: text[last:loc[0]]})
+		part := joltPart{ref: true}
+		switch {
+		case loc[2] >= 0:
+			part.level, _ = strconv.Atoi(text[loc[2]:loc[3]])
+			part.group, _ = strconv.Atoi(text[loc[4]:loc[5]])
+		case loc[6] >= 0:
+			part.level, _ = strconv.Atoi(text[loc[6]:loc[7]])
+		}
+		if part.level >= depth {
+			return nil, fmt.Errorf("'%s' refers to a key %d levels up, but the spec is %d levels deep", text[loc[0]:loc[1]], part.level, depth)
+		}
+		template = append(template, part)
+		last = loc[1]
+	}
+	return append(template, joltPart{text: text[last:]}), nil
+}
+
+// resolve returns the text of the template with the matched keys.
+func (t joltTemplate) resolve(matched [][]string) string {
+	var b strings.Builder
+	for _, part := range t {
+		if !part.ref {
+			b.WriteString(part.text)
+			continue
+		}
+		if groups := matched[len(matched)-1-part.level]; part.group < len(groups) {
+			b.WriteString(groups[part.group])
+		}
+	}
+	return b.String()
+}
+
+// write sets the path in output to value. A value written to a path that
+// already has one is collected with it into an array.
+func (p joltPath) write(output map[string]interface{}, matched [][]string, value interface{}) error {
+	current := output
+	for i, segment := range p {
+		last := i == len(p)-1
+		key := segment.key.resolve(matched)
+		switch {
+		case segment.append:
+			array, _ := current[key].([]interface{})
+			if last {
+				current[key] = append(array, value)
+				return nil
+			}
+			child := map[string]interface{}{}
+			current[key] = append(array, child)
+			current = child
+		case segment.index != nil:
+			index, err := strconv.Atoi(segment.index.resolve(matched))
+			if err != nil || index < 0 {
+				return fmt.Errorf("array index '%s' is not a non-negative integer", segment.index.resolve(matched))
+			}
+			array, _ := current[key].([]interface{})
+			for len(array) <= index {
+				array = append(array, nil)
+			}
+			current[key] = array
+			if last {
+				array[index] = collect(array[index], value)
+				return nil
+			}
+			child, ok := array[index].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				array[index] = child
+			}
+			current = child
+		case last:
+			existing, ok := current[key]
+			if !ok {
+				current[key] = value
+				return nil
+			}
+			current[key] = collect(existing, value)
+		default:
+			child, ok := current[key].(map[string]interface{})
+			if !ok {
+				if _, exists := current[key]; exists {
+					return fmt.Errorf("output key '%s' holds a value, not an object", key)
+				}
+				child = map[string]interface{}{}
+				current[key] = child
+			}
+			current = child
+		}
+	}
+	return nil
+}
+
+// collect adds value to existing, a previous value or an array of values
+// collected before.
+func collect(existing, value interface{}) interface{} {
+	switch v := existing.(type) {
+	case nil:
+		return value
+	case []interface{}:
+		return append(v, value)
+	}
+	return []interface{}{existing, value}
+}
+
+// joltKeyed is a compiled default or remove spec: keys of the input with the
+// constant to default them to or the key to remove, or a nested spec.
+type joltKeyed struct {
+	remove  bool
+	entries []joltKeyedEntry
+}
+
+type joltKeyedEntry struct {
+	key   joltKey
+	value interface{} // Default value, unless spec is set
+	spec  *joltKeyed
+}
+
+func compileJoltKeyed(spec map[string]interface{}, remove bool) (*joltKeyed, error) {
+	keyed := &joltKeyed{remove: remove}
+	keys := make([]string, 0, len(spec))
+	for key := range spec {
+		keys = append(keys, key)
+	}
+	sortJoltKeys(keys)
+	for _, key := range keys {
+		entry := joltKeyedEntry{key: compileJoltKey(key), value: spec[key]}
+		if nested, ok := spec[key].(map[string]interface{}); ok && (remove || key == "*" || len(nested) > 0) {
+			var err error
+			if entry.spec, err = compileJoltKeyed(nested, remove); err != nil {
+				return nil, fmt.Errorf("'%s': %w", key, err)
+			}
+		} else if remove && spec[key] != "" {
+			return nil, fmt.Errorf("'%s': remove values must be \"\" or a nested spec", key)
+		} else if !remove && !entry.key.literal {
+			return nil, fmt.Errorf("'%s': patterns need a nested spec", key)
+		}
+		keyed.entries = append(keyed.entries, entry)
+	}
+	return keyed, nil
+}
+
+func (k *joltKeyed) apply(value interface{}) (interface{}, error) {
+	return k.update(value), nil
+}
+
+// update applies the spec to value, an object or array, in place and returns
+// it.
+func (k *joltKeyed) update(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if !k.remove {
+			k.fillDefaults(v)
+		}
+		for key, child := range v {
+			entry, ok := k.entry(key)
+			switch {
+			case !ok:
+			case entry.spec != nil:
+				v[key] = entry.spec.update(child)
+			case k.remove:
+				delete(v, key)
+			}
+		}
+	case []interface{}:
+		kept := v[:0]
+		for i, child := range v {
+			entry, ok := k.entry(strconv.Itoa(i))
+			switch {
+			case ok && entry.spec != nil:
+				kept = append(kept, entry.spec.update(child))
+			case ok && k.remove:
+			default:
+				kept = append(kept, child)
+			}
+		}
+		return kept
+	}
+	return value
+}
+
+// fillDefaults adds the literal keys of a default spec that object lacks or
+// that are null.
+func (k *joltKeyed) fillDefaults(object map[string]interface{}) {
+	for _, entry := range k.entries {
+		if !entry.key.literal {
+			continue
+		}
+		if current, ok := object[entry.key.text]; ok && current != nil {
+			continue
+		}
+		if entry.spec != nil {
+			object[entry.key.text] = entry.spec.update(map[string]interface{}{})
+		} else {
+			object[entry.key.text] = copyJoltValue(entry.value)
+		}
+	}
+}
+
+// entry returns the first entry matching key.
+func (k *joltKeyed) entry(key string) (joltKeyedEntry, bool) {
+	for _, entry := range k.entries {
+		if _, ok := entry.key.match(key); ok {
+			return entry, true
+		}
+	}
+	return joltKeyedEntry{}, false
+}
+
+// copyJoltValue copies a default value, so that outputs do not share it.
+func copyJoltValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			copied[key] = copyJoltValue(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, child := range v {
+			copied[i] = copyJoltValue(child)
+		}
+		return copied
+	}
+	return value
+}
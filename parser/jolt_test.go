@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJoltPipe(t *testing.T) {
+	const order = `{"order": {
+		"id": "A-7",
+		"customer": {"name": "Acme", "vip": true, "note": null},
+		"lines": [
+			{"sku": "pen", "qty": 2, "price_net": 3.5, "price_gross": 4.2},
+			{"sku": "ink", "qty": 1, "price_net": 12, "price_gross": 14.4}
+		],
+		"tags": ["rush", "gift"],
+		"internal": {"margin": 0.3, "cost": 10}
+	}}`
+	tests := []struct {
+		name string
+		spec string
+		want string // JSON of the whole output
+	}{
+		{
+			name: "rename and nest",
+			spec: `[{"operation": "shift", "spec": {"order": {"id": "invoice.ref", "customer": {"name": "invoice.buyer"}}}}]`,
+			want: `{"invoice": {"ref": "A-7", "buyer": "Acme"}}`,
+		},
+		{
+			name: "array elements by index reference",
+			spec: `{"operation": "shift", "spec": {"order": {"lines": {"*": {"sku": "items[&1].code", "qty": "items[&1].count"}}}}}`,
+			want: `{"items": [{"code": "pen", "count": 2}, {"code": "ink", "count": 1}]}`,
+		},
+		{
+			name: "wildcard groups and key references",
+			spec: `[{"operation": "shift", "spec": {"order": {"lines": {"*": {"price_*": "prices.&(0,1).&2[]"}}}}}]`,
+			want: `{"prices": {"gross": {"lines": [4.2, 14.4]}, "net": {"lines": [3.5, 12]}}}`,
+		},
+		{
+			name: "collected values, constants, keys and self",
+			spec: `[{"operation": "shift", "spec": {"order": {
+				"tags": {"*": "labels"},
+				"customer": {"vip": {"true": {"#gold": "tier"}}, "$": "sections[]", "@": "copy"},
+				"id|missing": "ids"
+			}}}]`,
+			want: `{"labels": ["rush", "gift"], "tier": "gold", "sections": ["customer"], "copy": {"name": "Acme", "vip": true, "note": null}, "ids": "A-7"}`,
+		},
+		{
+			name: "default",
+			spec: `[{"operation": "shift", "spec": {"order": {"customer": "customer", "lines": "lines"}}},
+				{"operation": "default", "spec": {"currency": "EUR", "customer": {"note": "none", "vip": false}, "lines": {"*": {"discount": 0}}}}]`,
+			want: `{"currency": "EUR", "customer": {"name": "Acme", "vip": true, "note": "none"},
+				"lines": [{"sku": "pen", "qty": 2, "price_net": 3.5, "price_gross": 4.2, "discount": 0}, {"sku": "ink", "qty": 1, "price_net": 12, "price_gross": 14.4, "discount": 0}]}`,
+		},
+		{
+			name: "remove",
+			spec: `[{"operation": "remove", "spec": {"order": {"internal": "", "customer": {"note|vip": ""}, "lines": {"*": {"price_*": ""}}, "tags": {"0": ""}}}}]`,
+			want: `{"order": {"id": "A-7", "customer": {"name": "Acme"}, "lines": [{"sku": "pen", "qty": 2}, {"sku": "ink", "qty": 1}], "tags": ["gift"]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			if err := engine.RegisterJoltSpec("spec", []byte(tt.spec)); err != nil {
+				t.Fatalf("RegisterJoltSpec: %v", err)
+			}
+			result, err := NewMessageContext([]byte(order), "application/json", engine).EvaluateExpression(`jsonpath:@this | jolt("spec")`)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal([]byte(result.Value.(string)), &got); err != nil {
+				t.Fatalf("output is not JSON: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %s, want %s", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoltPipeStages(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterJoltSpec("orderToInvoice", []byte(`[{"operation": "shift", "spec": {"id": "invoice.ref", "total": "invoice.total"}}]`)); err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext([]byte(`{"order": {"id": "A-7", "total": 12.5}, "orderJson": "{\"id\": \"A-8\", \"total\": 12345678901234567890}", "text": "plain"}`), "application/json", engine)
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "query of the output", expression: `jsonpath:order | jolt("orderToInvoice") | jsonpath:invoice.ref`, want: "A-7"},
+		{name: "numbers keep their digits", expression: `jsonpath:orderJson | jolt("orderToInvoice")`, want: `{"invoice":{"ref":"A-8","total":12345678901234567890}}`},
+		{name: "unknown spec", expression: `jsonpath:order | jolt("missing")`, wantErr: "no Jolt spec is registered as 'missing'"},
+		{name: "input not JSON", expression: `jsonpath:text | jolt("orderToInvoice")`, wantErr: "input is not JSON"},
+		{name: "missing name", expression: `jsonpath:order | jolt`, wantErr: "jolt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterJoltSpecErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr string
+	}{
+		{name: "not JSON", spec: `[{`, wantErr: "Jolt spec 'spec'"},
+		{name: "operation not an object", spec: `["shift"]`, wantErr: "operation 0 is not an object"},
+		{name: "missing spec", spec: `[{"operation": "shift"}]`, wantErr: "has no spec object"},
+		{name: "unsupported operation", spec: `[{"operation": "cardinality", "spec": {}}]`, wantErr: "unsupported Jolt operation cardinality"},
+		{name: "output path type", spec: `[{"operation": "shift", "spec": {"a": 1}}]`, wantErr: "is not a string"},
+		{name: "reference too far up", spec: `[{"operation": "shift", "spec": {"a": "x.&1"}}]`, wantErr: "refers to a key 1 levels up"},
+		{name: "key at the top", spec: `[{"operation": "shift", "spec": {"$": "key"}}]`, wantErr: "'$' needs a matched key"},
+		{name: "unclosed index", spec: `[{"operation": "shift", "spec": {"a": "x[0"}}]`, wantErr: "unclosed '['"},
+		{name: "remove value", spec: `[{"operation": "remove", "spec": {"a": "yes"}}]`, wantErr: "remove values must be"},
+		{name: "default pattern", spec: `[{"operation": "default", "spec": {"a*": 1}}]`, wantErr: "patterns need a nested spec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewEngine().RegisterJoltSpec("spec", []byte(tt.spec))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
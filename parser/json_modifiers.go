@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// JSONPathModifierFunc transforms the JSON of the value a registered modifier
+// is applied to, as a gjson modifier does: json is the raw JSON of the value
+// and arg the raw argument after the ':' of "@name:arg", or "". It returns
+// the raw JSON of the new value, or "" if there is none.
+type JSONPathModifierFunc func(json, arg string) string
+
+// RegisterJSONPathModifier adds a modifier that jsonpath expressions
+// evaluated by this engine can apply like gjson's built-in ones, e.g.
+// "jsonpath:payment.@maskPAN" or "jsonpath:payment.@maskPAN:{\"keep\":4}.card"
+// after RegisterJSONPathModifier("maskPAN", fn). Names consist of letters,
+// digits, '_' and '-', and cannot replace a gjson modifier. Registering
+// a name again replaces its function; passing a nil fn removes it. Engines
+// that have this engine as their fallback can use its modifiers too.
+//
+// gjson keeps its modifiers in a global table, so registered modifiers are
+// applied by the engine instead: the path before a registered modifier is
+// selected with gjson, the modifier transforms the value it selects, and the
+// rest of the path is selected from the new value. Registered modifiers
+// inside "#(...)" queries are not applied.
+func (ee *ExpressionEngine) RegisterJSONPathModifier(name string, fn JSONPathModifierFunc) error {
+	if !isExpressionName(name) || strings.Contains(name, ".") {
+		return fmt.Errorf("invalid JSONPath modifier name '%s'", name)
+	}
+	if gjson.ModifierExists(name, nil) {
+		return fmt.Errorf("JSONPath modifier '%s' is built in", name)
+	}
+	if fn == nil {
+		ee.jsonModifiers.Delete(name)
+		return nil
+	}
+	ee.jsonModifiers.Store(name, fn)
+	return nil
+}
+
+// jsonPathModifier returns the modifier registered as name on this engine or
+// on its fallback chain.
+func (ee *ExpressionEngine) jsonPathModifier(name string) (JSONPathModifierFunc, bool) {
+	for e := ee; e != nil; e = e.fallback.Load() {
+		if fn, ok := e.jsonModifiers.Load(name); ok {
+			return fn.(JSONPathModifierFunc), true
+		}
+	}
+	return nil, false
+}
+
+// selectModifiedJSON returns the value path selects in doc, applying the
+// registered modifiers it uses. It reports false, without selecting
+// anything, if path uses none.
+func (ee *ExpressionEngine) selectModifiedJSON(doc gjson.Result, path string) (gjson.Result, bool) {
+	if !strings.Contains(path, "@") {
+		return gjson.Result{}, false
+	}
+	start, end, arg, fn := ee.findJSONPathModifier(path)
+	if fn == nil {
+		return gjson.Result{}, false
+	}
+	base := doc
+	if start > 0 {
+		base = selectJSONView(doc, path[:start-1])
+	}
+	if !base.Exists() {
+		return gjson.Result{}, true
+	}
+	out := fn(base.Raw, arg)
+	if out == "" {
+		return gjson.Result{}, true
+	}
+	result := gjson.Parse(out)
+	if end == len(path) {
+		return result, true
+	}
+	rest := path[end+1:]
+	if modified, ok := ee.selectModifiedJSON(result, rest); ok {
+		return modified, true
+	}
+	return selectJSONView(result, rest), true
+}
+
+// findJSONPathModifier finds the first component of path, outside brackets,
+// quotes and escapes, that applies a registered modifier. It returns the
+// bounds of the component, the argument of the modifier and its function,
+// or a nil function if there is none.
+func (ee *ExpressionEngine) findJSONPathModifier(path string) (start, end int, arg string, fn JSONPathModifierFunc) {
+	depth := 0
+	var quote byte
+	componentStart := 0
+	for i := 0; i <= len(path); i++ {
+		if i == len(path) || quote == 0 && depth == 0 && (path[i] == '.' || path[i] == '|') {
+			component := path[componentStart:i]
+			if strings.HasPrefix(component, "@") {
+				name, arg, _ := strings.Cut(component[1:], ":")
+				if fn, ok := ee.jsonPathModifier(name); ok {
+					return componentStart, i, arg, fn
+				}
+			}
+			componentStart = i + 1
+			continue
+		}
+		switch c := path[i]; {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		}
+	}
+	return 0, 0, "", nil
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// maskPAN masks all but the last digits of a card number, 4 or the "keep"
+// of its argument.
+func maskPAN(raw, arg string) string {
+	pan := gjson.Parse(raw).String()
+	keep := 4
+	if arg != "" {
+		keep = int(gjson.Get(arg, "keep").Int())
+	}
+	if keep > len(pan) {
+		keep = len(pan)
+	}
+	masked, _ := json.Marshal(strings.Repeat("*", len(pan)-keep) + pan[len(pan)-keep:])
+	return string(masked)
+}
+
+func TestRegisterJSONPathModifier(t *testing.T) {
+	body := []byte(`{"payment": {"card": "4111111111111111", "cvv": "123", "holder": "Ann"}, "raw": "{\"card\": \"5500000000000004\"}"}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "last component", expression: "jsonpath:payment.card.@maskPAN", want: "************1111"},
+		{name: "with argument", expression: `jsonpath:payment.card.@maskPAN:{"keep":2}`, want: "**************11"},
+		{name: "path after modifier", expression: "jsonpath:payment.@redact:cvv.holder", want: "Ann"},
+		{name: "gjson modifier after", expression: "jsonpath:payment.@redact:cvv.@keys", want: []interface{}{"card", "holder"}},
+		{name: "two modifiers", expression: "jsonpath:payment.@redact:cvv.@redact:holder.@keys", want: []interface{}{"card"}},
+		{name: "whole document", expression: "jsonpath:@redact:raw.payment.holder", want: "Ann"},
+		{name: "intermediate payload", expression: "jsonpath:raw | extractAsJSON | jsonpath:card.@maskPAN", want: "************0004"},
+		{name: "missing base", expression: "jsonpath:payment.missing.@maskPAN", wantErr: "path not found"},
+		{name: "no value", expression: "jsonpath:payment.@nothing", wantErr: "path not found"},
+		{name: "unregistered", expression: "jsonpath:payment.@unknown", wantErr: "path not found"},
+	}
+
+	engine := NewEngine()
+	modifiers := map[string]JSONPathModifierFunc{
+		"maskPAN": maskPAN,
+		"redact": func(raw, arg string) string {
+			var object map[string]interface{}
+			if err := json.Unmarshal([]byte(raw), &object); err != nil {
+				return ""
+			}
+			delete(object, arg)
+			out, _ := json.Marshal(object)
+			return string(out)
+		},
+		"nothing": func(string, string) string { return "" },
+	}
+	for name, fn := range modifiers {
+		if err := engine.RegisterJSONPathModifier(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterJSONPathModifierNamesAndFallback(t *testing.T) {
+	base := NewEngine()
+	engine := NewEngine()
+	if err := engine.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "reverse", "mask.pan", "mask pan"} {
+		if err := engine.RegisterJSONPathModifier(name, maskPAN); err == nil {
+			t.Errorf("RegisterJSONPathModifier(%q) succeeded, want an error", name)
+		}
+	}
+
+	if err := base.RegisterJSONPathModifier("maskPAN", maskPAN); err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext([]byte(`{"card": "4111111111111111"}`), "application/json", engine)
+	if result, err := msgCtx.EvaluateExpression("jsonpath:card.@maskPAN"); err != nil || result.Value != "************1111" {
+		t.Fatalf("got %v, %v, want the fallback engine's modifier applied", result.Value, err)
+	}
+	if err := base.RegisterJSONPathModifier("maskPAN", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msgCtx.EvaluateExpression("jsonpath:card.@maskPAN"); err == nil {
+		t.Error("expected an error after the modifier was removed")
+	}
+}
@@ -1,7 +1,13 @@
 package parser
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
 	"github.com/tidwall/gjson"
 )
 
@@ -10,6 +16,8 @@ type JSONPayload struct {
 	rawContent  []byte
 	jsonResult  gjson.Result // Store the parsed gjson.Result
 	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
 }
 
 // NewJSONPayload creates a new JSONPayload.
@@ -38,16 +46,93 @@ func (jp *JSONPayload) GetContentType() string {
 func (jp *JSONPayload) Query(expression string) (QueryResult, error) {
 	// gjson.Path directly uses the raw JSON string/bytes.
 	// result := gjson.GetBytes(jp.rawContent, expression)
-	result := jp.jsonResult.Get(expression) // Use the parsed result
+	return queryJSONView(jp.jsonResult, expression) // Use the parsed result
+}
+
+// QueryContext is like Query but stops walking the elements of an array or
+// object result once ctx is done. For EvaluateAll it returns every match of
+// the path as an array, and for EvaluateStream it sends the elements of an
+// array result one at a time.
+func (jp *JSONPayload) QueryContext(ctx context.Context, expression string) (QueryResult, error) {
+	if wantsAllMatches(ctx) {
+		matches := queryAllJSON(jp.jsonResult, expression)
+		values := make([]interface{}, 0, len(matches))
+		for _, match := range matches {
+			if err := ctx.Err(); err != nil {
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "JSON evaluation interrupted", InnerError: err}
+			}
+			values = append(values, match.Value())
+		}
+		return QueryResult{Value: values, Type: ArrayResult}, nil
+	}
+	if sink := finalStageOptions(ctx).sink; sink != nil {
+		if array := selectJSONView(jp.jsonResult, expression); array.IsArray() {
+			return streamJSONArray(ctx, array, expression, sink)
+		}
+	}
+	return queryJSONViewContext(ctx, jp.jsonResult, expression)
+}
+
+func (jp *JSONPayload) jsonView() gjson.Result {
+	return jp.jsonResult
+}
+
+func (jp *JSONPayload) AsString() (string, error) {
+	return string(jp.rawContent), nil
+}
+
+func (jp *JSONPayload) GetUnderlying() interface{} {
+	return jp.jsonResult // Return the gjson.Result
+}
+
+// jsonViewPayload is implemented by payloads decoded from other formats into a
+// JSON-compatible document. They can be queried with jsonpath expressions.
+type jsonViewPayload interface {
+	PayloadObject
+	jsonView() gjson.Result
+}
 
+// queryJSONView evaluates a gjson path against a parsed document. An empty
+// path or "$" selects the document root, which may be any JSON value
+// (including a bare array).
+func queryJSONView(doc gjson.Result, expression string) (QueryResult, error) {
+	return queryJSONViewContext(context.Background(), doc, expression)
+}
+
+// queryJSONViewContext is like queryJSONView but stops converting the
+// elements of the result once ctx is done.
+func queryJSONViewContext(ctx context.Context, doc gjson.Result, expression string) (QueryResult, error) {
+	return convertGJSONResultContext(ctx, selectJSONView(doc, expression), expression)
+}
+
+// selectJSONView returns the value a gjson path selects in doc.
+func selectJSONView(doc gjson.Result, expression string) gjson.Result {
+	if path := strings.TrimSpace(expression); path == "" || path == "$" {
+		return doc
+	}
+	return doc.Get(expression)
+}
+
+// convertGJSONResult maps a gjson.Result onto a QueryResult.
+// It is shared by every payload type that exposes a JSON view of its document.
+func convertGJSONResult(result gjson.Result, expression string) (QueryResult, error) {
+	return convertGJSONResultContext(context.Background(), result, expression)
+}
+
+// convertGJSONResultContext is like convertGJSONResult but checks ctx before
+// converting each element of an array or object, so that walking a huge
+// result can be interrupted.
+func convertGJSONResultContext(ctx context.Context, result gjson.Result, expression string) (QueryResult, error) {
 	if !result.Exists() {
 		// Check if the path was intended to return a null that exists vs a path that doesn't exist
 		// gjson distinction: result.Type == gjson.Null vs !result.Exists()
 		// For simplicity, if it doesn't exist, we treat it as "not found".
-		return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: expression, Reason: "path not found or value does not exist"}
+		return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: expression, Reason: "path not found or value does not exist", InnerError: ErrNoMatch}
 	}
 
 	var qr QueryResult
+	var ctxErr, limitErr error
+	maxElements := maxResultElements(ctx)
 	switch result.Type {
 	case gjson.String:
 		qr = QueryResult{Value: result.String(), Type: StringResult}
@@ -57,8 +142,14 @@ func (jp *JSONPayload) Query(expression string) (QueryResult, error) {
 		qr = QueryResult{Value: result.Bool(), Type: BooleanResult}
 	case gjson.JSON: // This means it's an object or array
 		if result.IsArray() {
-			var arr []interface{}
+			arr := []interface{}{} // Empty arrays stay arrays when serialized
 			result.ForEach(func(key, value gjson.Result) bool {
+				if ctxErr = ctx.Err(); ctxErr != nil {
+					return false
+				}
+				if limitErr = checkResultElements(maxElements, len(arr)+1, expression); limitErr != nil {
+					return false
+				}
 				arr = append(arr, value.Value()) // gjson.Result.Value() gives basic types
 				return true
 			})
@@ -67,6 +158,12 @@ func (jp *JSONPayload) Query(expression string) (QueryResult, error) {
 			// Convert map[string]gjson.Result to map[string]interface{}
 			objMap := make(map[string]interface{})
 			result.ForEach(func(key, value gjson.Result) bool {
+				if ctxErr = ctx.Err(); ctxErr != nil {
+					return false
+				}
+				if limitErr = checkResultElements(maxElements, len(objMap)+1, expression); limitErr != nil {
+					return false
+				}
 				objMap[key.String()] = value.Value()
 				return true
 			})
@@ -80,13 +177,56 @@ func (jp *JSONPayload) Query(expression string) (QueryResult, error) {
 	default: // Should not be reached if gjson types are handled
 		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("unexpected gjson result type: %s", result.Type.String())}
 	}
+	if ctxErr != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "JSON evaluation interrupted", InnerError: ctxErr}
+	}
+	if limitErr != nil {
+		return QueryResult{}, limitErr
+	}
 	return qr, nil
 }
 
-func (jp *JSONPayload) AsString() (string, error) {
-	return string(jp.rawContent), nil
+// normalizeJSONValue converts decoded documents from other formats (YAML, etc.)
+// into values encoding/json can marshal: map keys become strings, non-finite
+// floats (which JSON cannot represent) become "+Inf", "-Inf" or "NaN", and
+// nested maps/slices are normalized recursively.
+func normalizeJSONValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = normalizeJSONValue(item)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[fmt.Sprint(k)] = normalizeJSONValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalizeJSONValue(item)
+		}
+		return out
+	case float64:
+		if math.IsInf(val, 0) || math.IsNaN(val) {
+			return strconv.FormatFloat(val, 'g', -1, 64)
+		}
+		return val
+	case float32:
+		if f := float64(val); math.IsInf(f, 0) || math.IsNaN(f) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return val
+	default:
+		return val
+	}
 }
 
-func (jp *JSONPayload) GetUnderlying() interface{} {
-	return jp.jsonResult // Return the gjson.Result
+// marshalJSONView normalizes a decoded document and serializes it to JSON so
+// that it can be queried with gjson paths.
+func marshalJSONView(doc interface{}) ([]byte, error) {
+	return json.Marshal(normalizeJSONValue(doc))
 }
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRootArrays(t *testing.T) {
+	const orders = `[{"id": 1, "items": ["pen"]}, {"id": 2, "items": []}]`
+	tests := []struct {
+		name        string
+		raw         string
+		contentType string
+		expression  string
+		want        interface{}
+		wantType    ResultType
+		wantErr     bool
+	}{
+		{name: "count", raw: orders, expression: "jsonpath:#", want: float64(2), wantType: NumberResult},
+		{name: "index", raw: orders, expression: "jsonpath:0.id", want: float64(1), wantType: NumberResult},
+		{name: "map over root", raw: orders, expression: "jsonpath:#.id", want: []interface{}{float64(1), float64(2)}, wantType: ArrayResult},
+		{name: "empty path selects root", raw: `[1, 2]`, expression: "jsonpath:", want: []interface{}{float64(1), float64(2)}, wantType: ArrayResult},
+		{name: "dollar selects root", raw: `[1, 2]`, expression: "jsonpath:$", want: []interface{}{float64(1), float64(2)}, wantType: ArrayResult},
+		{name: "empty root array", raw: `[]`, expression: "jsonpath:$", want: []interface{}{}, wantType: ArrayResult},
+		{name: "empty nested array", raw: orders, expression: "jsonpath:1.items", want: []interface{}{}, wantType: ArrayResult},
+		{name: "index past the end", raw: `[]`, expression: "jsonpath:0", wantErr: true},
+		{name: "scalar root", raw: `"x"`, expression: "jsonpath:$", want: "x", wantType: StringResult},
+		{name: "root array piped", raw: orders, expression: "jsonpath:$ | extractAsJSON | jsonpath:1.id", want: float64(2), wantType: NumberResult},
+		{name: "root object piped", raw: `{"a": {"b": 1}}`, expression: "jsonpath:a | extractAsJSON | jsonpath:b", want: float64(1), wantType: NumberResult},
+		{name: "array piped to text", raw: `[1, 2]`, expression: "jsonpath:$ | text:", want: "[1,2]", wantType: StringResult},
+		{name: "yaml root sequence", raw: "- a\n- b\n", contentType: "application/yaml", expression: "yamlpath:$", want: []interface{}{"a", "b"}, wantType: ArrayResult},
+		{name: "ndjson records", raw: "1\n2\n", contentType: "application/x-ndjson", expression: "jsonpath:$", want: []interface{}{float64(1), float64(2)}, wantType: ArrayResult},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType := tt.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			result, err := NewMessageContext([]byte(tt.raw), contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tidwall/gjson"
+)
+
+// JSONPathEvaluator evaluates standard (RFC 9535) JSONPath queries such as
+// "$['items'][0]['sku']" against a decoded JSON document and returns the
+// selected nodes.
+type JSONPathEvaluator interface {
+	Select(document interface{}, path string) ([]interface{}, error)
+}
+
+// JSONPathDiscrepancy describes a jsonpath expression for which a standard
+// JSONPath evaluator disagreed with the gjson-based result.
+type JSONPathDiscrepancy struct {
+	Expression   string // The gjson path
+	StandardPath string // Its JSONPath translation
+	Legacy       QueryResult
+	LegacyErr    error
+	Standard     QueryResult // Node list coerced to the shape gjson would return
+	StandardErr  error
+}
+
+// DualRunOptions configures SetJSONPathDualRun.
+type DualRunOptions struct {
+	Evaluator JSONPathEvaluator
+	// Report is called for every discrepancy. It defaults to a warning to the
+	// Logger of the engine (see SetLogger). It may be called concurrently.
+	Report func(JSONPathDiscrepancy)
+	// MaxPending bounds the comparisons running in the background; queries
+	// evaluated while that many are running are not compared. It defaults to
+	// the number of CPUs.
+	MaxPending int
+
+	running atomic.Int64   // Comparisons running in the background
+	pending sync.WaitGroup // Done when they have all finished
+}
+
+// SetJSONPathDualRun enables the verification mode for migrating jsonpath
+// expressions from gjson paths to standard JSONPath: every jsonpath expression
+// that can be translated is also evaluated with options.Evaluator, and
+// differing outcomes are reported without affecting the result, which always
+// comes from gjson. Comparisons run in the background, after the gjson result
+// has been returned, and are skipped while options.MaxPending of them are
+// running, so that the mode does not slow evaluations down. Expressions using
+// gjson-only syntax (queries, modifiers, "#" counts, wildcards in keys) are
+// not compared. Passing nil disables it.
+func (ee *ExpressionEngine) SetJSONPathDualRun(options *DualRunOptions) {
+	ee.jsonPathDualRun.Store(options)
+}
+
+// jsonValueCache decodes the JSON view of a payload for the standard
+// evaluator once, when the first jsonpath query against it is compared.
+type jsonValueCache struct {
+	decodeOnce sync.Once
+	decoded    interface{}
+}
+
+// decodedJSON returns view decoded to Go values, decoding it on the first
+// call only.
+func (c *jsonValueCache) decodedJSON(view gjson.Result) interface{} {
+	c.decodeOnce.Do(func() { c.decoded = view.Value() })
+	return c.decoded
+}
+
+// compareInBackground runs compareJSONPath in a new goroutine, unless
+// MaxPending comparisons are already running. legacy is not modified after
+// it is returned, so the comparison can share it.
+func (options *DualRunOptions) compareInBackground(ee *ExpressionEngine, pld PayloadObject, expression string, legacy QueryResult, legacyErr error) {
+	limit := options.MaxPending
+	if limit <= 0 {
+		limit = runtime.NumCPU()
+	}
+	if options.running.Add(1) > int64(limit) {
+		options.running.Add(-1)
+		return
+	}
+	options.pending.Add(1)
+	go func() {
+		defer options.pending.Done()
+		defer options.running.Add(-1)
+		options.compareJSONPath(ee, pld, expression, legacy, legacyErr)
+	}()
+}
+
+// compareJSONPath re-evaluates a jsonpath expression with the standard
+// evaluator and reports a discrepancy with the gjson outcome to the Report
+// function of options or, without one, to the Logger of ee.
+func (options *DualRunOptions) compareJSONPath(ee *ExpressionEngine, pld PayloadObject, expression string, legacy QueryResult, legacyErr error) {
+	path, wildcard, ok := gjsonToJSONPath(expression)
+	if !ok {
+		return
+	}
+	var document interface{}
+	switch view := pld.(type) {
+	case interface {
+		jsonViewPayload
+		decodedJSON(gjson.Result) interface{}
+	}:
+		document = view.decodedJSON(view.jsonView())
+	case jsonViewPayload:
+		document = view.jsonView().Value()
+	default:
+		document = gjson.ParseBytes(pld.GetRawBytes()).Value()
+	}
+
+	discrepancy := JSONPathDiscrepancy{Expression: expression, StandardPath: path, Legacy: legacy, LegacyErr: legacyErr}
+	nodes, err := options.Evaluator.Select(document, path)
+	if err == nil {
+		discrepancy.Standard, err = coerceJSONPathNodes(nodes, wildcard, expression)
+	}
+	discrepancy.StandardErr = err
+
+	if (legacyErr == nil) == (err == nil) && (err != nil || sameResult(legacy, discrepancy.Standard)) {
+		return
+	}
+	if options.Report != nil {
+		options.Report(discrepancy)
+		return
+	}
+	ee.logWarn("jsonpath dual-run discrepancy", "expression", expression, "standardPath", path,
+		"gjson", legacy.Value, "gjsonErr", legacyErr, "standard", discrepancy.Standard.Value, "standardErr", err)
+}
+
+// coerceJSONPathNodes shapes a JSONPath node list like the equivalent gjson
+// result: no nodes is "not found", a path with a wildcard yields an array, and
+// otherwise the single node is the value.
+func coerceJSONPathNodes(nodes []interface{}, wildcard bool, expression string) (QueryResult, error) {
+	var value interface{}
+	switch {
+	case wildcard:
+		if nodes == nil {
+			nodes = []interface{}{}
+		}
+		value = nodes
+	case len(nodes) == 0:
+		return convertGJSONResult(gjson.Result{}, expression)
+	default:
+		value = nodes[0]
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "failed to convert JSONPath nodes", InnerError: err}
+	}
+	return convertGJSONResult(gjson.ParseBytes(raw), expression)
+}
+
+// gjsonToJSONPath translates a plain gjson path ("items.#.sku", "a\.b.0") into
+// JSONPath ("$['items'][*]['sku']", "$['a.b'][0,'0']"). A number selects an
+// array element or, as in gjson, an object member of that name. It reports
+// whether the path contains a wildcard, and fails for gjson-only syntax.
+func gjsonToJSONPath(path string) (string, bool, bool) {
+	if path == "" {
+		return "", false, false
+	}
+	var sb strings.Builder
+	sb.WriteString("$")
+	wildcard := false
+	segments := splitGJSONPath(path)
+	for i, segment := range segments {
+		switch {
+		case segment == "#":
+			if i == len(segments)-1 {
+				return "", false, false // A trailing "#" is a count
+			}
+			sb.WriteString("[*]")
+			wildcard = true
+		case segment == "" || strings.ContainsAny(segment, "*?#@|!=<>%()[]{}"):
+			return "", false, false
+		case isArrayIndex(segment):
+			sb.WriteString("[" + segment + ",'" + segment + "']")
+		default:
+			sb.WriteString("['" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(segment) + "']")
+		}
+	}
+	return sb.String(), wildcard, true
+}
+
+// splitGJSONPath splits a gjson path on unescaped dots and unescapes the keys.
+func splitGJSONPath(path string) []string {
+	var segments []string
+	var current strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path):
+			i++
+			current.WriteByte(path[i])
+		case path[i] == '.':
+			segments = append(segments, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(path[i])
+		}
+	}
+	return append(segments, current.String())
+}
+
+func isArrayIndex(segment string) bool {
+	n, err := strconv.Atoi(segment)
+	return err == nil && n >= 0 && strconv.Itoa(n) == segment
+}
@@ -0,0 +1,225 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// normalizedPathEvaluator is a minimal standard JSONPath evaluator for the
+// paths produced by gjsonToJSONPath ($['a'][0,'0'][*]).
+type normalizedPathEvaluator struct {
+	overrides map[string][]interface{} // Canned results to simulate behavior differences
+}
+
+func (e normalizedPathEvaluator) Select(document interface{}, path string) ([]interface{}, error) {
+	if nodes, ok := e.overrides[path]; ok {
+		return nodes, nil
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid path %s", path)
+	}
+	nodes := []interface{}{document}
+	for rest := path[1:]; rest != ""; {
+		end := strings.Index(rest, "]")
+		selector := rest[1:end]
+		rest = rest[end+1:]
+		var next []interface{}
+		for _, node := range nodes {
+			for _, selector := range strings.Split(selector, ",") {
+				switch {
+				case selector == "*":
+					if arr, ok := node.([]interface{}); ok {
+						next = append(next, arr...)
+					}
+				case strings.HasPrefix(selector, "'"):
+					if obj, ok := node.(map[string]interface{}); ok {
+						if v, ok := obj[strings.Trim(selector, "'")]; ok {
+							next = append(next, v)
+						}
+					}
+				default:
+					i, _ := strconv.Atoi(selector)
+					if arr, ok := node.([]interface{}); ok && i < len(arr) {
+						next = append(next, arr[i])
+					}
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+func TestGJSONToJSONPath(t *testing.T) {
+	tests := []struct {
+		path         string
+		want         string
+		wantWildcard bool
+		wantOK       bool
+	}{
+		{path: "a", want: "$['a']", wantOK: true},
+		{path: "items.0.sku", want: "$['items'][0,'0']['sku']", wantOK: true},
+		{path: "items.#.sku", want: "$['items'][*]['sku']", wantWildcard: true, wantOK: true},
+		{path: `a\.b.it's`, want: `$['a.b']['it\'s']`, wantOK: true},
+		{path: "01", want: "$['01']", wantOK: true},
+		{path: "items.#"},
+		{path: "items.#(sku==pen)"},
+		{path: "a.@reverse"},
+		{path: "a*"},
+		{path: "a..b"},
+		{path: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, wildcard, ok := gjsonToJSONPath(tt.path)
+			if ok != tt.wantOK || got != tt.want || wildcard != tt.wantWildcard {
+				t.Errorf("got (%q, %v, %v), want (%q, %v, %v)", got, wildcard, ok, tt.want, tt.wantWildcard, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestJSONPathDualRun(t *testing.T) {
+	const doc = `{"id": "A-1", "items": [{"sku": "pen", "qty": 2}, {"sku": "ink"}], "meta": {"tags": []}, "codes": {"0": "zero"}}`
+	tests := []struct {
+		name           string
+		expression     string
+		overrides      map[string][]interface{}
+		want           interface{}
+		wantErr        bool
+		wantDiscrepant bool
+	}{
+		{name: "scalar agrees", expression: "jsonpath:id", want: "A-1"},
+		{name: "object agrees", expression: "jsonpath:items.1", want: map[string]interface{}{"sku": "ink"}},
+		{name: "wildcard agrees", expression: "jsonpath:items.#.sku", want: []interface{}{"pen", "ink"}},
+		{name: "empty array agrees", expression: "jsonpath:meta.tags", want: []interface{}{}},
+		{name: "numeric key of an object agrees", expression: "jsonpath:codes.0", want: "zero"},
+		{name: "both missing", expression: "jsonpath:nope", wantErr: true},
+		{name: "untranslatable is not compared", expression: "jsonpath:items.#", overrides: map[string][]interface{}{}, want: float64(2)},
+		{
+			name: "value differs", expression: "jsonpath:id",
+			overrides: map[string][]interface{}{"$['id']": {"B-2"}}, want: "A-1", wantDiscrepant: true,
+		},
+		{
+			name: "standard finds nothing", expression: "jsonpath:items.0.qty",
+			overrides: map[string][]interface{}{"$['items'][0,'0']['qty']": nil}, want: float64(2), wantDiscrepant: true,
+		},
+		{
+			name: "standard finds what gjson does not", expression: "jsonpath:missing",
+			overrides: map[string][]interface{}{"$['missing']": {1.0}}, wantErr: true, wantDiscrepant: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var reported []JSONPathDiscrepancy
+			options := &DualRunOptions{
+				Evaluator: normalizedPathEvaluator{overrides: tt.overrides},
+				Report: func(d JSONPathDiscrepancy) {
+					mu.Lock()
+					reported = append(reported, d)
+					mu.Unlock()
+				},
+			}
+			engine := NewEngine()
+			engine.SetJSONPathDualRun(options)
+			result, err := NewMessageContext([]byte(doc), "application/json", engine).EvaluateExpression(tt.expression)
+			options.pending.Wait()
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !sameResult(result, QueryResult{Value: tt.want, Type: result.Type}) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+			if (len(reported) > 0) != tt.wantDiscrepant {
+				t.Errorf("discrepancies %+v, want discrepant %v", reported, tt.wantDiscrepant)
+			}
+		})
+	}
+}
+
+// documentRecorder records the documents a standard evaluator is given.
+type documentRecorder struct {
+	normalizedPathEvaluator
+	mu        sync.Mutex
+	documents []interface{}
+}
+
+func (r *documentRecorder) Select(document interface{}, path string) ([]interface{}, error) {
+	r.mu.Lock()
+	r.documents = append(r.documents, document)
+	r.mu.Unlock()
+	return r.normalizedPathEvaluator.Select(document, path)
+}
+
+func TestJSONPathDualRunLoggerAndDecoding(t *testing.T) {
+	var buf bytes.Buffer
+	engine := NewEngine(WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	recorder := &documentRecorder{normalizedPathEvaluator: normalizedPathEvaluator{overrides: map[string][]interface{}{"$['id']": {"B-2"}}}}
+	options := &DualRunOptions{Evaluator: recorder}
+	engine.SetJSONPathDualRun(options)
+
+	msgCtx := NewMessageContext([]byte(`{"id": "A-1", "items": [{"sku": "pen"}]}`), "application/json", engine)
+	for _, expression := range []string{"jsonpath:id", "jsonpath:items.0.sku", "jsonpath:items.0"} {
+		if _, err := msgCtx.EvaluateExpression(expression); err != nil {
+			t.Fatal(err)
+		}
+		options.pending.Wait()
+	}
+
+	want := `level=WARN msg="jsonpath dual-run discrepancy" expression=id standardPath=$['id'] gjson=A-1 gjsonErr=<nil> standard=B-2 standardErr=<nil>`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, buf.String())
+	}
+	if len(recorder.documents) != 3 {
+		t.Fatalf("compared %d queries, want 3", len(recorder.documents))
+	}
+	first := reflect.ValueOf(recorder.documents[0]).Pointer()
+	for i, document := range recorder.documents[1:] {
+		if reflect.ValueOf(document).Pointer() != first {
+			t.Errorf("query %d got a document decoded again", i+2)
+		}
+	}
+}
+
+// blockingEvaluator blocks every query until release is closed.
+type blockingEvaluator struct {
+	normalizedPathEvaluator
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e blockingEvaluator) Select(document interface{}, path string) ([]interface{}, error) {
+	e.started <- struct{}{}
+	<-e.release
+	return e.normalizedPathEvaluator.Select(document, path)
+}
+
+func TestJSONPathDualRunInBackground(t *testing.T) {
+	evaluator := blockingEvaluator{started: make(chan struct{}, 2), release: make(chan struct{})}
+	options := &DualRunOptions{Evaluator: evaluator, MaxPending: 1, Report: func(JSONPathDiscrepancy) {}}
+	engine := NewEngine()
+	engine.SetJSONPathDualRun(options)
+	msgCtx := NewMessageContext([]byte(`{"id": "A-1"}`), "application/json", engine)
+
+	// The evaluation returns while its comparison is blocked
+	if result, err := msgCtx.EvaluateExpression("jsonpath:id"); err != nil || result.Value != "A-1" {
+		t.Fatalf("got %v, %v, want A-1", result.Value, err)
+	}
+	<-evaluator.started
+	// Over MaxPending, the next query is not compared
+	if _, err := msgCtx.EvaluateExpression("jsonpath:id"); err != nil {
+		t.Fatal(err)
+	}
+	close(evaluator.release)
+	options.pending.Wait()
+	if started := len(evaluator.started); started != 0 {
+		t.Errorf("%d more comparisons started, want none", started)
+	}
+}
@@ -0,0 +1,252 @@
+package parser
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// JWTVerifier checks the signature of a JWT. header and claims are the
+// decoded JSON objects, signingInput is "<header>.<claims>" as it appears in
+// the token and signature the decoded third segment. Implementations may also
+// validate claims such as "exp" or "aud". A nil error accepts the token.
+type JWTVerifier interface {
+	VerifyJWT(header, claims map[string]interface{}, signingInput, signature []byte) error
+}
+
+// JWTVerifierFunc adapts a function to a JWTVerifier.
+type JWTVerifierFunc func(header, claims map[string]interface{}, signingInput, signature []byte) error
+
+func (f JWTVerifierFunc) VerifyJWT(header, claims map[string]interface{}, signingInput, signature []byte) error {
+	return f(header, claims, signingInput, signature)
+}
+
+// JWTPayload handles compact JWS tokens ("application/jwt"). An optional
+// "Bearer " prefix is ignored. The token is exposed as a JSON view:
+//
+//	{"header": {...}, "claims": {...}, "signature": "<base64url>", "verified": true}
+//
+// so it is queried with gjson paths, e.g. "jwt:claims.sub" or "jwt:header.alg",
+// or with jsonpath. "verified" is true only when a JWTVerifier accepted the
+// signature; when the engine has a verifier, tokens it rejects fail to parse.
+type JWTPayload struct {
+	rawContent  []byte
+	header      map[string]interface{}
+	claims      map[string]interface{}
+	verified    bool
+	jsonResult  gjson.Result
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// NewJWTPayload decodes a token without verifying its signature.
+func NewJWTPayload(content []byte) (*JWTPayload, error) {
+	return newJWTPayload(content, nil)
+}
+
+// NewVerifiedJWTPayload decodes a token and fails unless verifier accepts it.
+func NewVerifiedJWTPayload(content []byte, verifier JWTVerifier) (*JWTPayload, error) {
+	if verifier == nil {
+		return nil, fmt.Errorf("no JWT verifier")
+	}
+	return newJWTPayload(content, verifier)
+}
+
+func newJWTPayload(content []byte, verifier JWTVerifier) (*JWTPayload, error) {
+	token := strings.TrimSpace(string(content))
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	segments := strings.Split(token, ".")
+	if len(segments) == 5 {
+		return nil, fmt.Errorf("encrypted JWTs (JWE) are not supported")
+	}
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("invalid JWT: expected 3 segments, found %d", len(segments))
+	}
+
+	jp := &JWTPayload{rawContent: content, contentType: "application/jwt"}
+	var err error
+	if jp.header, err = decodeJWTSegment(segments[0], "header"); err != nil {
+		return nil, err
+	}
+	if jp.claims, err = decodeJWTSegment(segments[1], "claims"); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature encoding: %w", err)
+	}
+	if verifier != nil {
+		if err := verifier.VerifyJWT(jp.header, jp.claims, []byte(segments[0]+"."+segments[1]), signature); err != nil {
+			return nil, &ErrEvaluationFailed{Reason: "JWT verification failed", InnerError: err}
+		}
+		jp.verified = true
+	}
+
+	view, err := json.Marshal(map[string]interface{}{"header": jp.header, "claims": jp.claims, "signature": segments[2], "verified": jp.verified})
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert JWT to JSON view", InnerError: err}
+	}
+	jp.jsonResult = gjson.ParseBytes(view)
+	return jp, nil
+}
+
+// decodeJWTSegment decodes a base64url segment holding a JSON object.
+func decodeJWTSegment(segment, name string) (map[string]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT %s encoding: %w", name, err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(raw, &object); err != nil || object == nil {
+		return nil, fmt.Errorf("invalid JWT %s: not a JSON object", name)
+	}
+	return object, nil
+}
+
+func (jp *JWTPayload) GetRawBytes() []byte {
+	return jp.rawContent
+}
+
+func (jp *JWTPayload) GetContentType() string {
+	return jp.contentType
+}
+
+// Query evaluates a gjson path against the JSON view of the token.
+func (jp *JWTPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(jp.jsonResult, expression)
+}
+
+func (jp *JWTPayload) jsonView() gjson.Result {
+	return jp.jsonResult
+}
+
+// Header returns the decoded JOSE header.
+func (jp *JWTPayload) Header() map[string]interface{} {
+	return jp.header
+}
+
+// Claims returns the decoded claims.
+func (jp *JWTPayload) Claims() map[string]interface{} {
+	return jp.claims
+}
+
+// Verified reports whether a JWTVerifier accepted the token.
+func (jp *JWTPayload) Verified() bool {
+	return jp.verified
+}
+
+func (jp *JWTPayload) AsString() (string, error) {
+	return jp.jsonResult.Raw, nil
+}
+
+func (jp *JWTPayload) GetUnderlying() interface{} {
+	return jp.claims
+}
+
+// HMACVerifier verifies HS256, HS384 and HS512 signatures with a shared secret.
+type HMACVerifier struct {
+	Secret []byte
+}
+
+func (hv HMACVerifier) VerifyJWT(header, claims map[string]interface{}, signingInput, signature []byte) error {
+	newHash, err := jwtHash(header, "HS")
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(newHash, hv.Secret)
+	mac.Write(signingInput)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// PublicKeyVerifier verifies signatures with a public key: RS* and PS* with
+// an *rsa.PublicKey, ES* with an *ecdsa.PublicKey and EdDSA with an
+// ed25519.PublicKey.
+type PublicKeyVerifier struct {
+	Key crypto.PublicKey
+}
+
+func (pv PublicKeyVerifier) VerifyJWT(header, claims map[string]interface{}, signingInput, signature []byte) error {
+	alg, _ := header["alg"].(string)
+	switch key := pv.Key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") && !strings.HasPrefix(alg, "PS") {
+			return fmt.Errorf("algorithm '%s' cannot be verified with an RSA key", alg)
+		}
+		newHash, err := jwtHash(header, alg[:2])
+		if err != nil {
+			return err
+		}
+		digest, hashID := jwtDigest(newHash, signingInput)
+		if alg[:2] == "PS" {
+			return rsa.VerifyPSS(key, hashID, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(key, hashID, digest, signature)
+	case *ecdsa.PublicKey:
+		newHash, err := jwtHash(header, "ES")
+		if err != nil {
+			return err
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+		digest, _ := jwtDigest(newHash, signingInput)
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("algorithm '%s' cannot be verified with an Ed25519 key", alg)
+		}
+		if !ed25519.Verify(key, signingInput, signature) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", pv.Key)
+}
+
+// jwtHash returns the hash of the header's algorithm, which must belong to
+// family (e.g. "HS" for HS256).
+func jwtHash(header map[string]interface{}, family string) (func() hash.Hash, error) {
+	alg, _ := header["alg"].(string)
+	if !strings.HasPrefix(alg, family) {
+		return nil, fmt.Errorf("unexpected algorithm '%s'", alg)
+	}
+	switch strings.TrimPrefix(alg, family) {
+	case "256":
+		return sha256.New, nil
+	case "384":
+		return sha512.New384, nil
+	case "512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm '%s'", alg)
+}
+
+func jwtDigest(newHash func() hash.Hash, data []byte) ([]byte, crypto.Hash) {
+	h := newHash()
+	h.Write(data)
+	hashID := map[int]crypto.Hash{32: crypto.SHA256, 48: crypto.SHA384, 64: crypto.SHA512}[h.Size()]
+	return h.Sum(nil), hashID
+}
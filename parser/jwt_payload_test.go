@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// signTestJWT builds a compact JWS with header and claims signed by sign.
+func signTestJWT(header, claims string, sign func(signingInput []byte) []byte) string {
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signingInput)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(signingInput []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signingInput)
+		return mac.Sum(nil)
+	}
+}
+
+const testJWTClaims = `{"sub":"user-42","iss":"https://idp.example.com","aud":["orders","billing"],"exp":1900000000,"roles":["admin"]}`
+
+func TestJWTPayloadQuery(t *testing.T) {
+	token := signTestJWT(`{"alg":"HS256","typ":"JWT","kid":"k1"}`, testJWTClaims, hs256("secret"))
+
+	tests := []struct {
+		name       string
+		content    string
+		expression string
+		want       interface{}
+	}{
+		{name: "subject", content: token, expression: "jwt:claims.sub", want: "user-42"},
+		{name: "algorithm", content: token, expression: "jwt:header.alg", want: "HS256"},
+		{name: "key id", content: token, expression: "jwt:header.kid", want: "k1"},
+		{name: "number claim", content: token, expression: "jwt:claims.exp", want: float64(1900000000)},
+		{name: "array claim", content: token, expression: "jwt:claims.aud.1", want: "billing"},
+		{name: "not verified", content: token, expression: "jwt:verified", want: false},
+		{name: "signature", content: token, expression: "jwt:signature", want: token[strings.LastIndex(token, ".")+1:]},
+		{name: "jsonpath", content: token, expression: "jsonpath:claims.roles.0", want: "admin"},
+		{name: "bearer prefix", content: "Bearer " + token + "\n", expression: "jwt:claims.iss", want: "https://idp.example.com"},
+		{name: "unsigned", content: signTestJWT(`{"alg":"none"}`, `{"sub":"x"}`, func([]byte) []byte { return nil }), expression: "jwt:claims.sub", want: "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(tt.content), "application/jwt", NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJWTPayloadInvalid(t *testing.T) {
+	encode := base64.RawURLEncoding.EncodeToString
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "empty", content: "", wantErr: "expected 3 segments, found 1"},
+		{name: "two segments", content: "a.b", wantErr: "expected 3 segments, found 2"},
+		{name: "encrypted", content: "a.b.c.d.e", wantErr: "JWE"},
+		{name: "bad header encoding", content: "a+b." + encode([]byte("{}")) + ".", wantErr: "invalid JWT header encoding"},
+		{name: "header not an object", content: encode([]byte("[1]")) + "." + encode([]byte("{}")) + ".", wantErr: "invalid JWT header: not a JSON object"},
+		{name: "claims not JSON", content: encode([]byte("{}")) + "." + encode([]byte("sub")) + ".", wantErr: "invalid JWT claims: not a JSON object"},
+		{name: "bad signature encoding", content: encode([]byte("{}")) + "." + encode([]byte("{}")) + ".***", wantErr: "invalid JWT signature encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJWTPayload([]byte(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTVerification(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs256 := func(signingInput []byte) []byte {
+		digest := sha256.Sum256(signingInput)
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		return signature
+	}
+	ps256 := func(signingInput []byte) []byte {
+		digest := sha256.Sum256(signingInput)
+		signature, _ := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		return signature
+	}
+	es256 := func(signingInput []byte) []byte {
+		digest := sha256.Sum256(signingInput)
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	eddsa := func(signingInput []byte) []byte { return ed25519.Sign(edPrivate, signingInput) }
+	rejectExpired := JWTVerifierFunc(func(header, claims map[string]interface{}, signingInput, signature []byte) error {
+		if exp, _ := claims["exp"].(float64); exp < 1700000000 {
+			return fmt.Errorf("token expired")
+		}
+		return HMACVerifier{Secret: []byte("secret")}.VerifyJWT(header, claims, signingInput, signature)
+	})
+
+	tests := []struct {
+		name     string
+		verifier JWTVerifier
+		token    string
+		wantErr  string
+	}{
+		{name: "HS256", verifier: HMACVerifier{Secret: []byte("secret")}, token: signTestJWT(`{"alg":"HS256"}`, testJWTClaims, hs256("secret"))},
+		{name: "HS256 wrong secret", verifier: HMACVerifier{Secret: []byte("other")}, token: signTestJWT(`{"alg":"HS256"}`, testJWTClaims, hs256("secret")), wantErr: "invalid signature"},
+		{name: "HS512 unsupported by signature", verifier: HMACVerifier{Secret: []byte("secret")}, token: signTestJWT(`{"alg":"HS512"}`, testJWTClaims, hs256("secret")), wantErr: "invalid signature"},
+		{name: "none rejected", verifier: HMACVerifier{Secret: []byte("secret")}, token: signTestJWT(`{"alg":"none"}`, testJWTClaims, func([]byte) []byte { return nil }), wantErr: "unexpected algorithm 'none'"},
+		{name: "RS256", verifier: PublicKeyVerifier{Key: &rsaKey.PublicKey}, token: signTestJWT(`{"alg":"RS256"}`, testJWTClaims, rs256)},
+		{name: "PS256", verifier: PublicKeyVerifier{Key: &rsaKey.PublicKey}, token: signTestJWT(`{"alg":"PS256"}`, testJWTClaims, ps256)},
+		{name: "RS256 tampered claims", verifier: PublicKeyVerifier{Key: &rsaKey.PublicKey}, token: tamperTestJWT(signTestJWT(`{"alg":"RS256"}`, testJWTClaims, rs256)), wantErr: "verification error"},
+		{name: "HS256 with RSA key", verifier: PublicKeyVerifier{Key: &rsaKey.PublicKey}, token: signTestJWT(`{"alg":"HS256"}`, testJWTClaims, hs256("secret")), wantErr: "cannot be verified with an RSA key"},
+		{name: "ES256", verifier: PublicKeyVerifier{Key: &ecKey.PublicKey}, token: signTestJWT(`{"alg":"ES256"}`, testJWTClaims, es256)},
+		{name: "ES256 short signature", verifier: PublicKeyVerifier{Key: &ecKey.PublicKey}, token: signTestJWT(`{"alg":"ES256"}`, testJWTClaims, hs256("x")), wantErr: "invalid signature"},
+		{name: "EdDSA", verifier: PublicKeyVerifier{Key: edPublic}, token: signTestJWT(`{"alg":"EdDSA"}`, testJWTClaims, eddsa)},
+		{name: "unsupported key", verifier: PublicKeyVerifier{Key: "key"}, token: signTestJWT(`{"alg":"RS256"}`, testJWTClaims, rs256), wantErr: "unsupported public key type"},
+		{name: "custom hook", verifier: rejectExpired, token: signTestJWT(`{"alg":"HS256"}`, testJWTClaims, hs256("secret"))},
+		{name: "custom hook rejects", verifier: rejectExpired, token: signTestJWT(`{"alg":"HS256"}`, `{"exp":1600000000}`, hs256("secret")), wantErr: "token expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetJWTVerifier(tt.verifier)
+			result, err := NewMessageContext([]byte(tt.token), "application/jwt", engine).EvaluateExpression("jwt:verified")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || result.Value != true {
+				t.Fatalf("got %v, %v, want verified", result.Value, err)
+			}
+		})
+	}
+}
+
+// tamperTestJWT replaces the claims of a token, keeping its signature.
+func tamperTestJWT(token string) string {
+	segments := strings.Split(token, ".")
+	segments[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"attacker"}`))
+	return strings.Join(segments, ".")
+}
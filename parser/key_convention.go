@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// KeyConvention converts a field name written in an expression to the naming
+// convention of payload keys, e.g. "orderId" to "order_id".
+type KeyConvention func(key string) string
+
+// Built-in key conventions. They split a key into words at underscores,
+// hyphens and case changes ("HTTPServerURL" is "http", "server", "url") and
+// join them in their style.
+var (
+	SnakeCase  KeyConvention = func(key string) string { return joinKeyWords(splitKeyWords(key), "_", strings.ToLower) }
+	KebabCase  KeyConvention = func(key string) string { return joinKeyWords(splitKeyWords(key), "-", strings.ToLower) }
+	CamelCase  KeyConvention = func(key string) string { return camelKey(key, false) }
+	PascalCase KeyConvention = func(key string) string { return camelKey(key, true) }
+)
+
+// SetKeyConvention makes jsonpath and yamlpath expressions match payload keys
+// written in another naming convention: each plain key of a path is converted
+// with convention before the path is evaluated, so "jsonpath:order.lineItems"
+// reads "order.line_items" with SnakeCase. Array indexes, "#", modifiers and
+// the contents of queries ("#(...)") are left alone. Passing nil disables
+// the conversion.
+func (ee *ExpressionEngine) SetKeyConvention(convention KeyConvention) {
+	if convention == nil {
+		ee.keyConvention.Store(nil)
+		return
+	}
+	ee.keyConvention.Store(&convention)
+}
+
+// convertPathKeys applies the engine's key convention, if any, to a gjson path.
+func (ee *ExpressionEngine) convertPathKeys(path string) string {
+	convention := ee.keyConvention.Load()
+	if convention == nil {
+		return path
+	}
+	return convertGJSONPathKeys(path, *convention)
+}
+
+// convertGJSONPathKeys converts the plain keys of a gjson path, splitting it
+// on dots outside of queries, brackets and quotes.
+func convertGJSONPathKeys(path string, convert KeyConvention) string {
+	var sb strings.Builder
+	start, depth := 0, 0
+	inQuote := false
+	flush := func(end int) {
+		segment := path[start:end]
+		if isPlainKey(segment) {
+			segment = convert(segment)
+		}
+		sb.WriteString(segment)
+	}
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\':
+			i++ // Escaped character, e.g. a dot in a key
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == '.' && depth == 0:
+			flush(i)
+			sb.WriteByte('.')
+			start = i + 1
+		}
+	}
+	flush(len(path))
+	return sb.String()
+}
+
+// isPlainKey reports whether a path segment is an identifier-like key, as
+// opposed to an index, a wildcard, a query or a modifier.
+func isPlainKey(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	for i, r := range segment {
+		if !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r) && r != '_' && r != '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitKeyWords splits a key into its words.
+func splitKeyWords(key string) []string {
+	var words []string
+	runes := []rune(key)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '_' || r == '-' {
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i > start && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// "orderId" splits before "I"; "HTTPServer" splits before "S"
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+func joinKeyWords(words []string, separator string, transform func(string) string) string {
+	for i, word := range words {
+		words[i] = transform(word)
+	}
+	return strings.Join(words, separator)
+}
+
+// camelKey joins the words of key in camelCase, or PascalCase if upperFirst.
+func camelKey(key string, upperFirst bool) string {
+	words := splitKeyWords(key)
+	var sb strings.Builder
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if i > 0 || upperFirst {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			word = string(runes)
+		}
+		sb.WriteString(word)
+	}
+	return sb.String()
+}
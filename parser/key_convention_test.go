@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyConventions(t *testing.T) {
+	tests := []struct {
+		key                         string
+		snake, kebab, camel, pascal string
+	}{
+		{key: "orderId", snake: "order_id", kebab: "order-id", camel: "orderId", pascal: "OrderId"},
+		{key: "order_id", snake: "order_id", kebab: "order-id", camel: "orderId", pascal: "OrderId"},
+		{key: "line-items", snake: "line_items", kebab: "line-items", camel: "lineItems", pascal: "LineItems"},
+		{key: "HTTPServerURL", snake: "http_server_url", kebab: "http-server-url", camel: "httpServerUrl", pascal: "HttpServerUrl"},
+		{key: "address2Line", snake: "address2_line", kebab: "address2-line", camel: "address2Line", pascal: "Address2Line"},
+		{key: "id", snake: "id", kebab: "id", camel: "id", pascal: "Id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			for name, got := range map[string][2]string{
+				"snake":  {SnakeCase(tt.key), tt.snake},
+				"kebab":  {KebabCase(tt.key), tt.kebab},
+				"camel":  {CamelCase(tt.key), tt.camel},
+				"pascal": {PascalCase(tt.key), tt.pascal},
+			} {
+				if got[0] != got[1] {
+					t.Errorf("%s: got %q, want %q", name, got[0], got[1])
+				}
+			}
+		})
+	}
+}
+
+func TestConvertGJSONPathKeys(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "order.lineItems.0.unitPrice", want: "order.line_items.0.unit_price"},
+		{path: "lineItems.#.skuCode", want: "line_items.#.sku_code"},
+		{path: `lineItems.#(skuCode=="A.b").unitPrice`, want: `line_items.#(skuCode=="A.b").unit_price`},
+		{path: "lineItems.@reverse", want: "line_items.@reverse"},
+		{path: `some\.dottedKey.innerKey`, want: `some\.dottedKey.inner_key`},
+		{path: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := convertGJSONPathKeys(tt.path, SnakeCase); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetKeyConvention(t *testing.T) {
+	const snakePayload = `{"order_id": "A-7", "line_items": [{"unit_price": 2.5}, {"unit_price": 4}]}`
+	const camelPayload = `{"orderId": "A-7", "lineItems": [{"unitPrice": 2.5}, {"unitPrice": 4}]}`
+	const yamlPayload = "order_id: A-7\nline_items:\n  - unit_price: 2.5\n"
+
+	tests := []struct {
+		name        string
+		convention  KeyConvention
+		payload     string
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     bool
+	}{
+		{name: "camel expression on snake payload", convention: SnakeCase, payload: snakePayload, contentType: "application/json", expression: "jsonpath:orderId", want: "A-7"},
+		{name: "nested and wildcard", convention: SnakeCase, payload: snakePayload, contentType: "application/json", expression: "jsonpath:lineItems.#.unitPrice", want: []interface{}{2.5, float64(4)}},
+		{name: "snake expression on camel payload", convention: CamelCase, payload: camelPayload, contentType: "application/json", expression: "jsonpath:line_items.1.unit_price", want: float64(4)},
+		{name: "yamlpath", convention: SnakeCase, payload: yamlPayload, contentType: "application/yaml", expression: "yamlpath:lineItems.0.unitPrice", want: 2.5},
+		{name: "after pipe", convention: SnakeCase, payload: `{"doc": "{\"order_id\": 9}"}`, contentType: "application/json", expression: "jsonpath:doc | extractAsJSON | jsonpath:orderId", want: float64(9)},
+		{name: "no convention", payload: snakePayload, contentType: "application/json", expression: "jsonpath:orderId", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetKeyConvention(tt.convention)
+			result, err := NewMessageContext([]byte(tt.payload), tt.contentType, engine).EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// EvaluatorFunc evaluates a query of a registered expression language
+// against the payload of its stage: the message payload, or the payload a
+// conversion pipe created. query is the stage without the prefix and the
+// whitespace after it.
+type EvaluatorFunc func(ctx context.Context, payload PayloadObject, query string) (QueryResult, error)
+
+// RegisterLanguage adds an expression language, written with the prefix
+// name + ":", that expressions evaluated by this engine can use like the
+// built-in ones, e.g. "acme:order/total | toNumber" after
+// RegisterLanguage("acme", fn). Names consist of letters, digits, '_', '-'
+// and '.', and cannot replace a built-in language. Registering a name again
+// replaces its function; passing a nil fn removes it. Engines that have this
+// engine as their fallback can use its languages too.
+func (ee *ExpressionEngine) RegisterLanguage(name string, fn EvaluatorFunc) error {
+	if !isExpressionName(name) {
+		return fmt.Errorf("invalid language name '%s'", name)
+	}
+	if _, builtIn := queryPrefix(name + ":"); builtIn {
+		return fmt.Errorf("language '%s' is built in", name)
+	}
+	if fn == nil {
+		ee.languages.Delete(name + ":")
+		return nil
+	}
+	ee.languages.Store(name+":", fn)
+	return nil
+}
+
+// registeredLanguage returns the function of a language registered with
+// prefix on this engine or on its fallback chain.
+func (ee *ExpressionEngine) registeredLanguage(prefix string) (EvaluatorFunc, bool) {
+	for e := ee; e != nil; e = e.fallback.Load() {
+		if fn, ok := e.languages.Load(prefix); ok {
+			return fn.(EvaluatorFunc), true
+		}
+	}
+	return nil, false
+}
+
+// languagePrefix returns the prefix of a stage in a language that is not
+// built in, e.g. "acme:" for "acme:order/total".
+func languagePrefix(text string) (string, bool) {
+	name, _, found := strings.Cut(text, ":")
+	if !found || !isExpressionName(name) {
+		return "", false
+	}
+	return name + ":", true
+}
+
+// registeredLanguageSignature is the signature of every registered
+// language: it may query any payload, and its output is not known ahead of
+// evaluation.
+var registeredLanguageSignature = stageSignature{output: KindAny, keepsPayload: true}
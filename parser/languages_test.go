@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// queryKeyValues evaluates the "kv:" language of the tests: the value of a
+// key in "key=value" lines.
+func queryKeyValues(_ context.Context, payload PayloadObject, query string) (QueryResult, error) {
+	for _, line := range strings.Split(string(payload.GetRawBytes()), "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok && key == query {
+			return QueryResult{Value: value, Type: StringResult}, nil
+		}
+	}
+	return QueryResult{}, &ErrEvaluationFailed{Expression: query, Reason: "key not found"}
+}
+
+func TestRegisterLanguage(t *testing.T) {
+	body := []byte("id=A-7\nitems=[\"a\",\"b\"]\nnote=<n><to>Tove</to></n>\n")
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "query", expression: "kv:id", want: "A-7"},
+		{name: "space after prefix", expression: "kv: id | lower", want: "a-7"},
+		{name: "conversion after query", expression: "kv:items | extractAsJSON | jsonpath:1", want: "b"},
+		{name: "query after conversion", expression: "kv:note | extractAsXML | xpath:string(/n/to) | payloadType:", want: "application/xml"},
+		{name: "sub-expression", expression: "kv:items | extractAsJSON | jsonpath:@this | map(payloadType:)", want: []interface{}{"application/json", "application/json"}},
+		{name: "language error", expression: "kv:missing", wantErr: "key not found"},
+		{name: "unregistered", expression: "acme:id", wantErr: "unsupported expression: acme:id"},
+	}
+
+	engine := NewEngine()
+	languages := map[string]EvaluatorFunc{
+		"kv": queryKeyValues,
+		"payloadType": func(_ context.Context, payload PayloadObject, _ string) (QueryResult, error) {
+			return QueryResult{Value: payload.GetContentType(), Type: StringResult}, nil
+		},
+	}
+	for name, fn := range languages {
+		if err := engine.RegisterLanguage(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "text/plain", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterLanguageInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+	}{
+		{name: "empty name", language: ""},
+		{name: "name with colon", language: "kv:"},
+		{name: "name with space", language: "k v"},
+		{name: "built-in language", language: "jsonpath"},
+		{name: "built-in short prefix", language: "md"},
+	}
+	engine := NewEngine()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := engine.RegisterLanguage(tt.language, queryKeyValues); err == nil {
+				t.Error("engine accepted the language")
+			}
+		})
+	}
+}
+
+func TestRegisteredLanguageRemovalAndFallback(t *testing.T) {
+	base := NewEngine()
+	if err := base.RegisterLanguage("kv", queryKeyValues); err != nil {
+		t.Fatal(err)
+	}
+	product := NewEngine()
+	if err := product.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+	body := []byte("id=7")
+
+	result, err := NewMessageContext(body, "text/plain", product).EvaluateExpression("kv:id | toNumber")
+	if err != nil || result.Value != float64(7) {
+		t.Fatalf("got %#v, %v, want the fallback's language to run", result.Value, err)
+	}
+	if warnings := product.Lint("kv:id | toNumber"); len(warnings) != 0 {
+		t.Errorf("got lint warnings %v", warnings)
+	}
+	if desc := base.Describe(); desc.Languages[len(desc.Languages)-1].Prefix != "kv:" {
+		t.Errorf("Describe does not list the registered language: %+v", desc.Languages)
+	}
+
+	if err := base.RegisterLanguage("kv", nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewMessageContext(body, "text/plain", product).EvaluateExpression("kv:id")
+	var unsupported *ErrUnsupportedExpression
+	if !errors.As(err, &unsupported) {
+		t.Errorf("got %v, want ErrUnsupportedExpression after removal", err)
+	}
+}
+
+func TestRegisteredLanguageLintAndCompile(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterLanguage("kv", queryKeyValues); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expression string
+		wantRules  []string
+		wantKind   ValueKind
+		wantErr    bool
+	}{
+		{expression: "kv:id", wantKind: KindAny},
+		{expression: "kv:id | toNumber", wantKind: KindNumber},
+		{expression: "jsonpath:blob | extractAsXML | kv:id", wantKind: KindAny},
+		{expression: "jsonpath:blob | kv:id", wantRules: []string{LintRuleDeprecated}, wantKind: KindAny},
+		{expression: "acme:id", wantRules: []string{LintRuleSyntax}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			var rules []string
+			for _, w := range engine.Lint(tt.expression) {
+				rules = append(rules, w.Rule)
+			}
+			if !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Errorf("got lint rules %v, want %v", rules, tt.wantRules)
+			}
+			compiled, err := engine.Compile(tt.expression)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got compile error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && compiled.ResultKind() != tt.wantKind {
+				t.Errorf("got %v, want %v", compiled.ResultKind(), tt.wantKind)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// EngineLimits bounds the resources a single evaluation may use, so that one
+// oversized or hostile message cannot exhaust an engine shared by many
+// tenants. A zero field means no limit. Exceeding a limit fails the
+// evaluation with ErrLimitExceeded.
+type EngineLimits struct {
+	MaxPayloadBytes         int // Bytes of a message payload (compressed and decompressed), of gunzip and inflate output, and of each intermediate payload
+	MaxStages               int // Stages of a pipeline or sub-expression, after references are expanded
+	MaxResultElements       int // Elements of an array, object or node-set result of any stage
+	MaxIntermediatePayloads int // Payloads parsed from stage results (e.g. by extractAsJSON or part:) in one evaluation
+}
+
+// SetLimits sets the resource limits of evaluations (see EngineLimits).
+// Running evaluations keep the limits they started with.
+func (ee *ExpressionEngine) SetLimits(limits EngineLimits) {
+	ee.limits.Store(&limits)
+}
+
+// Limits returns the resource limits of evaluations.
+func (ee *ExpressionEngine) Limits() EngineLimits {
+	if limits := ee.limits.Load(); limits != nil {
+		return *limits
+	}
+	return EngineLimits{}
+}
+
+// evaluationBudgetKey is the context key of the evaluationBudget of an evaluation.
+type evaluationBudgetKey struct{}
+
+// evaluationBudget tracks what an evaluation, including its sub-expressions
+// and computed fields, has used of the limits it started with.
+type evaluationBudget struct {
+	limits               EngineLimits
+	intermediatePayloads atomic.Int64
+}
+
+// withEvaluationBudget starts accounting an evaluation against the limits of
+// the engine, unless ctx already belongs to an evaluation.
+func (ee *ExpressionEngine) withEvaluationBudget(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(evaluationBudgetKey{}).(*evaluationBudget); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, evaluationBudgetKey{}, &evaluationBudget{limits: ee.Limits()})
+}
+
+// evaluationLimits returns the limits of the evaluation ctx belongs to.
+func evaluationLimits(ctx context.Context) EngineLimits {
+	if budget, ok := ctx.Value(evaluationBudgetKey{}).(*evaluationBudget); ok {
+		return budget.limits
+	}
+	return EngineLimits{}
+}
+
+// checkLimit returns ErrLimitExceeded if actual is over max, unless max is 0.
+func checkLimit(limit string, max, actual int, expression string) error {
+	if max > 0 && actual > max {
+		return &ErrLimitExceeded{Limit: limit, Max: max, Actual: actual, Expression: expression}
+	}
+	return nil
+}
+
+// maxResultElements returns the MaxResultElements limit of the evaluation
+// ctx belongs to. Stages that build an array, object or node-set check it as
+// they add elements, so that an oversized result fails before it is built.
+func maxResultElements(ctx context.Context) int {
+	return evaluationLimits(ctx).MaxResultElements
+}
+
+// checkResultElements returns ErrLimitExceeded once a result being built has
+// more than max elements, unless max is 0.
+func checkResultElements(max, count int, expression string) error {
+	return checkLimit("MaxResultElements", max, count, expression)
+}
+
+// useIntermediatePayload accounts for an intermediate payload of size bytes
+// parsed by the evaluation ctx belongs to.
+func useIntermediatePayload(ctx context.Context, size int, expression string) error {
+	budget, ok := ctx.Value(evaluationBudgetKey{}).(*evaluationBudget)
+	if !ok {
+		return nil
+	}
+	if err := checkLimit("MaxPayloadBytes", budget.limits.MaxPayloadBytes, size, expression); err != nil {
+		return err
+	}
+	count := budget.intermediatePayloads.Add(1)
+	return checkLimit("MaxIntermediatePayloads", budget.limits.MaxIntermediatePayloads, int(count), expression)
+}
+
+// resultElements returns the number of elements of an array, object or
+// node-set result, and 0 for anything else.
+func resultElements(result QueryResult) int {
+	switch value := result.Value.(type) {
+	case []interface{}:
+		return len(value)
+	case []string:
+		return len(value)
+	case map[string]interface{}:
+		return len(value)
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEngineLimits(t *testing.T) {
+	const payload = `{"items": [1, 2, 3], "doc": "{\"a\": {\"b\": \"<x>y</x>\"}}"}`
+	const nested = "jsonpath:doc | extractAsJSON | jsonpath:a.b | extractAsXML | xpath:/x/text()"
+
+	tests := []struct {
+		name       string
+		payload    string // Defaults to payload
+		limits     EngineLimits
+		expression string
+		wantLimit  string
+		wantActual int
+	}{
+		{name: "no limits", expression: nested},
+		{name: "generous limits", limits: EngineLimits{MaxPayloadBytes: 1 << 20, MaxStages: 10, MaxResultElements: 10, MaxIntermediatePayloads: 10}, expression: nested},
+		{name: "payload too large", limits: EngineLimits{MaxPayloadBytes: 10}, expression: "jsonpath:items", wantLimit: "MaxPayloadBytes", wantActual: len(payload)},
+		{name: "too many stages", limits: EngineLimits{MaxStages: 4}, expression: nested, wantLimit: "MaxStages", wantActual: 5},
+		{name: "too many elements", limits: EngineLimits{MaxResultElements: 2}, expression: "jsonpath:items", wantLimit: "MaxResultElements", wantActual: 3},
+		{name: "too many intermediate payloads", limits: EngineLimits{MaxIntermediatePayloads: 1}, expression: nested, wantLimit: "MaxIntermediatePayloads", wantActual: 2},
+		{name: "intermediate payload too large", payload: `{"a": [1, 1, 1, 1, 1, 1, 1, 1]}`, limits: EngineLimits{MaxPayloadBytes: 40}, expression: "jsonpath:$ | jsonToXml", wantLimit: "MaxPayloadBytes", wantActual: 77}, // jsonToXml expands the document
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetLimits(tt.limits)
+			raw := tt.payload
+			if raw == "" {
+				raw = payload
+			}
+			result, err := NewMessageContext([]byte(raw), "application/json", engine).EvaluateExpression(tt.expression)
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.Value == nil {
+					t.Errorf("got no value")
+				}
+				return
+			}
+			var limitErr *ErrLimitExceeded
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected ErrLimitExceeded, got %v", err)
+			}
+			if limitErr.Limit != tt.wantLimit || limitErr.Actual != tt.wantActual {
+				t.Errorf("got %s = %d, want %s = %d", limitErr.Limit, limitErr.Actual, tt.wantLimit, tt.wantActual)
+			}
+		})
+	}
+}
+
+func TestResultElementsLimitStopsEarly(t *testing.T) {
+	const n = 100000
+	numbers := strings.TrimSuffix(strings.Repeat("1,", n), ",")
+	jsonBody := `{"items": [` + numbers + `], "csv": "` + numbers + `", "nested": [[1, 2, 3, 4, 5, 6], [7, 8, 9, 10, 11, 12]], "object": {`
+	var object strings.Builder
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			object.WriteByte(',')
+		}
+		fmt.Fprintf(&object, `"k%d": %d`, i, i)
+	}
+	jsonBody += object.String() + `}}`
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		expression  string
+	}{
+		{name: "JSON array", body: jsonBody, contentType: "application/json", expression: "jsonpath:items"},
+		{name: "JSON object", body: jsonBody, contentType: "application/json", expression: "jsonpath:object"},
+		{name: "XML node-set", body: "<r>" + strings.Repeat("<a>x</a>", n) + "</r>", contentType: "application/xml", expression: "xpath://a"},
+		{name: "HTML node-set", body: "<ul>" + strings.Repeat("<li>x</li>", n) + "</ul>", contentType: "text/html", expression: "xpath://li"},
+		{name: "split", body: jsonBody, contentType: "application/json", expression: `jsonpath:csv | split(",")`},
+		{name: "flatten", body: jsonBody, contentType: "application/json", expression: "jsonpath:nested | flatten"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetLimits(EngineLimits{MaxResultElements: 10})
+			_, err := NewMessageContext([]byte(tt.body), tt.contentType, engine).EvaluateExpression(tt.expression)
+			var limitErr *ErrLimitExceeded
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected ErrLimitExceeded, got %v", err)
+			}
+			// Building stops at the first element over the limit
+			if limitErr.Limit != "MaxResultElements" || limitErr.Actual != 11 {
+				t.Errorf("got %s = %d, want MaxResultElements = 11", limitErr.Limit, limitErr.Actual)
+			}
+		})
+	}
+}
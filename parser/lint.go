@@ -0,0 +1,241 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/antchfx/xpath"
+)
+
+// Severity ranks lint warnings.
+type Severity int
+
+const (
+	SeverityInfo    Severity = iota // Style or robustness advice
+	SeverityWarning                 // Likely to misbehave or perform badly
+	SeverityError                   // The expression cannot be evaluated
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Lint rule names, reported in LintWarning.Rule.
+const (
+	LintRuleSyntax           = "syntax"            // Unknown, empty or invalid stages
+	LintRuleDeprecated       = "deprecated-syntax" // Forms kept for compatibility
+	LintRuleRecursiveDescent = "recursive-descent" // Unanchored scans of the whole document
+	LintRuleRegexBacktrack   = "regex-backtracking"
+	LintRuleMissingDefault   = "missing-default" // Optional paths whose absence fails the evaluation
+)
+
+// LintWarning is one finding of Lint.
+type LintWarning struct {
+	Rule       string
+	Severity   Severity
+	StageIndex int    // Zero-based position of the stage in the pipeline
+	Stage      string // The stage (pipe part) the warning is about
+	Message    string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s: stage %d ('%s'): %s [%s]", w.Severity, w.StageIndex, w.Stage, w.Message, w.Rule)
+}
+
+// Lint checks fullExpression against best-practice rules without evaluating
+// it, and returns its findings in stage order. Warnings of SeverityError
+// mean evaluation would fail whatever the payload.
+func (ee *ExpressionEngine) Lint(fullExpression string) []LintWarning {
+	return ee.lint(parseExpression(fullExpression))
+}
+
+// lint checks the stages of a parsed expression.
+func (ee *ExpressionEngine) lint(expression *Expression) []LintWarning {
+	return ee.lintWith(expression, ee.registeredExpressions())
+}
+
+// lintWith is like lint but finds the expressions that references name with
+// names.
+func (ee *ExpressionEngine) lintWith(expression *Expression, names expressionLookup) []LintWarning {
+	var warnings []LintWarning
+	afterConversion := false // The previous stage replaced the payload queried next
+	for i, parsed := range expression.Stages {
+		stage := parsed.Text
+		report := func(rule string, severity Severity, format string, args ...interface{}) {
+			warnings = append(warnings, LintWarning{Rule: rule, Severity: severity, StageIndex: i, Stage: stage, Message: fmt.Sprintf(format, args...)})
+		}
+
+		call, err := parsed.call(), parsed.Err
+		_, builtIn := pipeArity[call.name]
+		_, registered := ee.registeredPipe(call.name)
+		if parsed.Pipe == fixedWidthToJSONPipe {
+			if i == 0 {
+				report(LintRuleSyntax, SeverityError, "pipe operation '%s' needs a previous stage", stage)
+			}
+			if err != nil {
+				report(LintRuleSyntax, SeverityError, "%v", err)
+			}
+			afterConversion = true
+			continue
+		}
+		if parsed.Kind == PipeStage && (builtIn || registered) {
+			if i == 0 {
+				report(LintRuleSyntax, SeverityError, "pipe operation '%s' needs a previous stage", call.name)
+			}
+			if err == nil && builtIn {
+				err = checkPipeArgs(call)
+			}
+			if err != nil {
+				report(LintRuleSyntax, SeverityError, "%v", err)
+			} else if call.name == regexReplacePipe {
+				if pattern, ok := call.args[0].(string); ok {
+					lintRegex(pattern, report)
+				}
+			} else if isCollectionPipe(call.name) {
+				if _, err := sortDescending(call); err != nil {
+					report(LintRuleSyntax, SeverityError, "%v", err)
+				}
+				for _, w := range ee.lintWith(call.sub, names) {
+					report(w.Rule, w.Severity, "in the sub-expression '%s': %s", w.Stage, w.Message)
+				}
+			}
+			afterConversion = !signatureOf(stage, i).keepsPayload
+			continue
+		}
+		if parsed.Kind == ReferenceStage {
+			if _, err := ee.expandStages(names, []*Stage{parsed}, nil); err != nil {
+				report(LintRuleSyntax, SeverityError, "%v", err)
+			}
+			_, isNamed := names(parsed.Name)
+			afterConversion = isNamed // The expression may end with a conversion
+			continue
+		}
+		if parsed.Kind != QueryStage {
+			if parsed.Kind == PipeStage { // Neither built in nor registered
+				err = errors.New("unknown expression language or pipe operation")
+			}
+			report(LintRuleSyntax, SeverityError, "%v", err)
+			continue
+		}
+
+		if _, builtIn := queryPrefix(parsed.Language); !builtIn {
+			if _, registered := ee.registeredLanguage(parsed.Language); !registered {
+				report(LintRuleSyntax, SeverityError, "unknown expression language or pipe operation")
+				continue
+			}
+		}
+
+		// Stages that work on the previous result, select a new payload or
+		// read the original payload on purpose
+		switch parsed.Language {
+		case textPrefix, regexPrefix, partPrefix, computedPrefix:
+		default:
+			if i > 0 && !afterConversion {
+				report(LintRuleDeprecated, SeverityWarning, "a query without a conversion pipe before it queries the original payload, not the previous result; add extractAsJSON, extractAsXML, extractAsYAML or extractAsCSV")
+			}
+		}
+		afterConversion = parsed.Language == partPrefix
+
+		lintStage(stage, i == len(expression.Stages)-1, report)
+	}
+	return warnings
+}
+
+// lintStage applies the language-specific rules to a query stage.
+func lintStage(stage string, last bool, report func(rule string, severity Severity, format string, args ...interface{})) {
+	switch {
+	case strings.HasPrefix(stage, xpathPrefix):
+		expr := strings.TrimSpace(strings.TrimPrefix(stage, xpathPrefix))
+		_, rest, found, err := cutDocumentSelector(expr)
+		if err != nil {
+			report(LintRuleSyntax, SeverityError, "invalid XPath: %v", err)
+			return
+		}
+		if found {
+			if rest == "" {
+				return
+			}
+			expr = rest
+		}
+		// Registered functions are replaced with literals before compiling
+		stubbed, _, err := replaceXPathFunctionCalls(expr, func(string, []string) (string, bool, error) { return "''", true, nil })
+		if err != nil {
+			report(LintRuleSyntax, SeverityError, "invalid XPath: %v", err)
+			return
+		}
+		if _, err := xpath.Compile(stubbed); err != nil {
+			report(LintRuleSyntax, SeverityError, "invalid XPath: %v", err)
+			return
+		}
+		if strings.HasPrefix(expr, "//") || strings.HasPrefix(expr, "descendant") {
+			report(LintRuleRecursiveDescent, SeverityWarning, "unanchored '//' scans every node of the document; start from a known path on large payloads")
+		}
+		if last && strings.Contains(expr, "[") {
+			report(LintRuleMissingDefault, SeverityInfo, "the predicate may match nothing, which fails the evaluation; add '| default(value)' or make sure callers handle a missing value")
+		}
+	case strings.HasPrefix(stage, cssPrefix):
+		if _, err := cssToXPath(strings.TrimPrefix(stage, cssPrefix)); err != nil {
+			report(LintRuleSyntax, SeverityError, "invalid CSS selector: %v", err)
+		}
+	case strings.HasPrefix(stage, jsonpathPrefix):
+		path := strings.TrimSpace(strings.TrimPrefix(stage, jsonpathPrefix))
+		if strings.HasPrefix(path, "$.") || strings.HasPrefix(path, "$[") {
+			report(LintRuleDeprecated, SeverityWarning, "jsonpath expressions are gjson paths; drop the JSONPath-style '$' root, which is read as a key named '$'")
+		}
+		if strings.Contains(path, "..") {
+			report(LintRuleRecursiveDescent, SeverityWarning, "gjson paths have no recursive descent ('..'); name the path to the value")
+		}
+		if last && strings.Contains(path, "#(") && !strings.Contains(path, ")#") {
+			report(LintRuleMissingDefault, SeverityInfo, "the query may match nothing, which fails the evaluation; add '| default(value)' or make sure callers handle a missing value")
+		}
+	case strings.HasPrefix(stage, regexPrefix):
+		lintRegex(strings.TrimPrefix(stage, regexPrefix), report)
+	}
+}
+
+// lintRegex reports invalid patterns and nested quantifiers such as "(a+)+".
+// Go's regexp package runs those in linear time, but they backtrack
+// catastrophically in most other engines, so configurations shared with other
+// runtimes should avoid them.
+func lintRegex(pattern string, report func(rule string, severity Severity, format string, args ...interface{})) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		report(LintRuleSyntax, SeverityError, "invalid regular expression: %v", err)
+		return
+	}
+	if nestedQuantifier(re, false) {
+		report(LintRuleRegexBacktrack, SeverityWarning, "nested quantifiers backtrack catastrophically in backtracking regex engines")
+	}
+}
+
+// nestedQuantifier reports whether re has an unbounded repetition inside
+// another repetition.
+func nestedQuantifier(re *syntax.Regexp, inRepeat bool) bool {
+	repeat := false
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		repeat = true
+	case syntax.OpRepeat:
+		repeat = re.Max == -1 || re.Max > 1
+	}
+	if repeat && inRepeat {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if nestedQuantifier(sub, inRepeat || repeat) {
+			return true
+		}
+	}
+	return false
+}
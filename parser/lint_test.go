@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	type finding struct {
+		Rule       string
+		Severity   Severity
+		StageIndex int
+	}
+	tests := []struct {
+		name       string
+		expression string
+		want       []finding
+	}{
+		{name: "clean jsonpath", expression: "jsonpath:order.id"},
+		{name: "clean pipeline", expression: "jsonpath:order.note | extractAsXML | xpath:/note/to"},
+		{name: "clean regex chain", expression: `jsonpath:message | regex:order (\d+)`},
+		{name: "clean part chain", expression: "part:payload.json | jsonpath:order.id"},
+		{name: "clean fixed-width chain", expression: "jsonpath:records | fixedWidthToJSON:customer | jsonpath:#.ID"},
+		{name: "clean computed chain", expression: "jsonpath:note | extractAsJSON | computed:orderId"},
+		{name: "clean pipes inside stages", expression: `xpath:count(//a[@t='x|y']) | toString | split("|") | join("\\|")`},
+		{name: "empty stage", expression: "jsonpath:a | ", want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "unknown language", expression: "sql:select 1", want: []finding{{LintRuleSyntax, SeverityError, 0}}},
+		{name: "pipe first", expression: "extractAsJSON", want: []finding{{LintRuleSyntax, SeverityError, 0}}},
+		{name: "fixed-width pipe first", expression: "fixedWidthToJSON:customer", want: []finding{{LintRuleSyntax, SeverityError, 0}}},
+		{name: "fixed-width pipe without layout", expression: "jsonpath:records | fixedWidthToJSON:", want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "invalid xpath", expression: "xpath:/a[", want: []finding{{LintRuleSyntax, SeverityError, 0}}},
+		{name: "xpath function with relative argument in predicate", expression: "xpath:/order/item[pp:isEven(.)]", want: []finding{{LintRuleSyntax, SeverityError, 0}}},
+		{name: "invalid css", expression: "css:div >", want: []finding{{LintRuleSyntax, SeverityError, 0}}},
+		{name: "invalid regex", expression: "regex:(a", want: []finding{{LintRuleSyntax, SeverityError, 0}}},
+		{name: "implicit chain", expression: "xpath:/a/b | jsonpath:c", want: []finding{{LintRuleDeprecated, SeverityWarning, 1}}},
+		{name: "jsonpath dollar root", expression: "jsonpath:$.order.id", want: []finding{{LintRuleDeprecated, SeverityWarning, 0}}},
+		{name: "unanchored xpath", expression: "xpath://item/price", want: []finding{{LintRuleRecursiveDescent, SeverityWarning, 0}}},
+		{name: "anchored xpath with descendant step", expression: "xpath:/order//price"},
+		{name: "pipe with arguments", expression: "jsonpath:id | substring(0, 3)"},
+		{name: "pipe argument count", expression: "jsonpath:id | substring()", want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "arguments to a pipe without parameters", expression: "jsonpath:id | hex(2)", want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "malformed arguments", expression: `jsonpath:id | substring(0, "3)`, want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "built-in pipe call without arguments", expression: "jsonpath:note | extractAsXML() | xpath:/a"},
+		{name: "xpath document selector", expression: "xpath:doc(2)/order/id"},
+		{name: "xpath whole document", expression: "xpath:doc(1)"},
+		{name: "unanchored xpath in document", expression: "xpath:doc(2)//id", want: []finding{{LintRuleRecursiveDescent, SeverityWarning, 0}}},
+		{name: "invalid document selector", expression: "xpath:doc(0)/a", want: []finding{{LintRuleSyntax, SeverityError, 0}}},
+		{name: "jsonpath recursive descent", expression: "jsonpath:store..price", want: []finding{{LintRuleRecursiveDescent, SeverityWarning, 0}}},
+		{name: "nested quantifiers", expression: "regex:^(a+)+$", want: []finding{{LintRuleRegexBacktrack, SeverityWarning, 0}}},
+		{name: "bounded nesting", expression: "regex:(ab?){3}"},
+		{name: "regexReplace pattern", expression: `jsonpath:id | regexReplace("(a+)+", "")`, want: []finding{{LintRuleRegexBacktrack, SeverityWarning, 1}}},
+		{name: "invalid regexReplace pattern", expression: `jsonpath:id | regexReplace("(", "")`, want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "regexReplace pattern not a string", expression: `jsonpath:id | regexReplace(1, "")`},
+		{name: "xpath predicate last", expression: "xpath:/orders/order[@id='7']/total", want: []finding{{LintRuleMissingDefault, SeverityInfo, 0}}},
+		{name: "gjson first-match query last", expression: `jsonpath:items.#(sku=="A1").qty`, want: []finding{{LintRuleMissingDefault, SeverityInfo, 0}}},
+		{name: "query followed by default", expression: `jsonpath:items.#(sku=="A1").qty | default(0)`},
+		{name: "map sub-expression", expression: "jsonpath:items | map(jsonpath:sku)"},
+		{name: "map invalid sub-expression", expression: "jsonpath:items | map(xpath:a[)", want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "filter invalid sub-expression", expression: "jsonpath:items | filter(xpath:a[ > 3)", want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "sortBy order", expression: "jsonpath:items | sortBy(jsonpath:qty, down)", want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "sortBy", expression: "jsonpath:items | sortBy(jsonpath:qty, desc)"},
+		{name: "default argument count", expression: "jsonpath:id | default()", want: []finding{{LintRuleSyntax, SeverityError, 1}}},
+		{name: "gjson all-match query", expression: `jsonpath:items.#(qty>1)#.sku`},
+		{name: "several findings", expression: "xpath://a[1] | jsonpath:$.b", want: []finding{
+			{LintRuleRecursiveDescent, SeverityWarning, 0},
+			{LintRuleDeprecated, SeverityWarning, 1},
+			{LintRuleDeprecated, SeverityWarning, 1},
+		}},
+	}
+	engine := NewEngine()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []finding
+			for _, w := range engine.Lint(tt.expression) {
+				got = append(got, finding{w.Rule, w.Severity, w.StageIndex})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLintWarningString(t *testing.T) {
+	warnings := NewEngine().Lint("regex:(x+)*")
+	if len(warnings) != 1 {
+		t.Fatalf("got %v", warnings)
+	}
+	want := "warning: stage 0 ('regex:(x+)*'): nested quantifiers backtrack catastrophically in backtracking regex engines [regex-backtracking]"
+	if got := warnings[0].String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// LogFormat is the line format of a LogPayload.
+type LogFormat string
+
+const (
+	LogFormatSyslog LogFormat = "syslog" // RFC 5424
+	LogFormatLogfmt LogFormat = "logfmt" // key=value pairs
+)
+
+// syslogSeverities names the RFC 5424 severity levels.
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// LogPayload handles structured log lines: RFC 5424 syslog ("text/x-syslog")
+// or logfmt ("text/x-logfmt"). Every non-blank line is parsed into an entry
+// when the payload is created, and the entries are exposed as a JSON array,
+// so queries ("log:" or jsonpath expressions) are gjson paths such as
+// "0.appName" or "#(level==\"error\")#.msg".
+//
+// Syslog entries have the fields priority, facility, severity, severityName,
+// version, timestamp, hostname, appName, procId, msgId, structuredData (an
+// object of SD-IDs to their parameters) and message; nil values ("-") are
+// null. Logfmt entries have a string field per key, and keys without a value
+// are true.
+type LogPayload struct {
+	rawContent  []byte
+	format      LogFormat
+	jsonResult  gjson.Result
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// NewLogPayload parses every line of content in format.
+func NewLogPayload(content []byte, format LogFormat) (*LogPayload, error) {
+	var parseLine func(string) (map[string]interface{}, error)
+	var contentType string
+	switch format {
+	case LogFormatSyslog:
+		parseLine, contentType = parseSyslogLine, "text/x-syslog"
+	case LogFormatLogfmt:
+		parseLine, contentType = parseLogfmtLine, "text/x-logfmt"
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+
+	entries := []map[string]interface{}{}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s line %d: %w", format, i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	view, err := json.Marshal(entries)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert log lines to JSON view", InnerError: err}
+	}
+	return &LogPayload{rawContent: content, format: format, jsonResult: gjson.ParseBytes(view), contentType: contentType}, nil
+}
+
+// parseSyslogLine parses
+//
+//	<PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseSyslogLine(line string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(line, "<") {
+		return nil, fmt.Errorf("missing priority")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("invalid priority")
+	}
+	priority, err := strconv.Atoi(line[1:end])
+	if err != nil || priority < 0 || priority > 191 || line[1] == '+' {
+		return nil, fmt.Errorf("invalid priority '%s'", line[1:end])
+	}
+	rest := line[end+1:]
+
+	header := make([]string, 6) // VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	for i := range header {
+		var ok bool
+		if header[i], rest, ok = strings.Cut(rest, " "); !ok || header[i] == "" {
+			return nil, fmt.Errorf("incomplete header")
+		}
+	}
+	version, err := strconv.Atoi(header[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid version '%s'", header[0])
+	}
+	structuredData, rest, err := parseStructuredData(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := map[string]interface{}{
+		"priority":       priority,
+		"facility":       priority / 8,
+		"severity":       priority % 8,
+		"severityName":   syslogSeverities[priority%8],
+		"version":        version,
+		"timestamp":      syslogNil(header[1]),
+		"hostname":       syslogNil(header[2]),
+		"appName":        syslogNil(header[3]),
+		"procId":         syslogNil(header[4]),
+		"msgId":          syslogNil(header[5]),
+		"structuredData": structuredData,
+		"message":        nil,
+	}
+	if rest != "" {
+		if !strings.HasPrefix(rest, " ") {
+			return nil, fmt.Errorf("expected a space before the message")
+		}
+		entry["message"] = strings.TrimPrefix(rest[1:], "\ufeff") // A UTF-8 message may start with a BOM
+	}
+	return entry, nil
+}
+
+func syslogNil(value string) interface{} {
+	if value == "-" {
+		return nil
+	}
+	return value
+}
+
+// parseStructuredData parses "-" or one or more [SD-ID PARAM="VALUE" ...]
+// elements at the start of s, and returns them with the rest of s.
+func parseStructuredData(s string) (interface{}, string, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, s[1:], nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return nil, "", fmt.Errorf("invalid structured data")
+	}
+	elements := make(map[string]interface{})
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		idEnd := strings.IndexAny(s, " ]")
+		if idEnd <= 0 {
+			return nil, "", fmt.Errorf("invalid structured data element")
+		}
+		params := make(map[string]interface{})
+		elements[s[:idEnd]] = params
+		s = s[idEnd:]
+		for strings.HasPrefix(s, " ") {
+			name, value, ok := strings.Cut(s[1:], "=\"")
+			if !ok || name == "" || strings.ContainsAny(name, " ]") {
+				return nil, "", fmt.Errorf("invalid structured data parameter")
+			}
+			var text strings.Builder
+			i := 0
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) && strings.IndexByte(`"\]`, value[i+1]) >= 0 {
+					i++
+				}
+				text.WriteByte(value[i])
+			}
+			if i == len(value) {
+				return nil, "", fmt.Errorf("unterminated structured data value of %s", name)
+			}
+			params[name] = text.String()
+			s = value[i+1:]
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("unterminated structured data element")
+		}
+		s = s[1:]
+	}
+	return elements, s, nil
+}
+
+// parseLogfmtLine parses key=value pairs separated by spaces. Values may be
+// double-quoted with backslash escapes; a key without '=' is true.
+func parseLogfmtLine(line string) (map[string]interface{}, error) {
+	entry := make(map[string]interface{})
+	s := line
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return entry, nil
+		}
+		keyEnd := strings.IndexAny(s, "= \t")
+		if keyEnd < 0 {
+			keyEnd = len(s)
+		}
+		key := s[:keyEnd]
+		if key == "" || strings.ContainsRune(key, '"') {
+			return nil, fmt.Errorf("invalid key at '%s'", s)
+		}
+		s = s[keyEnd:]
+		if !strings.HasPrefix(s, "=") {
+			entry[key] = true
+			continue
+		}
+		s = s[1:]
+
+		if !strings.HasPrefix(s, `"`) {
+			valueEnd := strings.IndexAny(s, " \t")
+			if valueEnd < 0 {
+				valueEnd = len(s)
+			}
+			entry[key], s = s[:valueEnd], s[valueEnd:]
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("unterminated value of %s", key)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil || !utf8.ValidString(value) {
+			return nil, fmt.Errorf("invalid value of %s", key)
+		}
+		entry[key], s = value, s[len(quoted):]
+	}
+}
+
+func (lp *LogPayload) GetRawBytes() []byte {
+	return lp.rawContent
+}
+
+func (lp *LogPayload) GetContentType() string {
+	return lp.contentType
+}
+
+// Query evaluates a gjson path against the JSON view of the entries.
+func (lp *LogPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(lp.jsonResult, expression)
+}
+
+func (lp *LogPayload) jsonView() gjson.Result {
+	return lp.jsonResult
+}
+
+// Format returns the line format of the payload.
+func (lp *LogPayload) Format() LogFormat {
+	return lp.format
+}
+
+func (lp *LogPayload) AsString() (string, error) {
+	return string(lp.rawContent), nil
+}
+
+func (lp *LogPayload) GetUnderlying() interface{} {
+	return lp.jsonResult.Value()
+}
@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogPayloadSyslog(t *testing.T) {
+	lines := `<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - ` + "\ufeff" + `'su root' failed for lonvick on /dev/pts/8
+<165>1 2003-10-11T22:14:15.003Z host evntslog 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication\]"][origin ip="192.0.2.1"] An application event
+<14>1 - - - - - -
+`
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+	}{
+		{name: "priority", expression: "log:0.priority", want: float64(34)},
+		{name: "facility", expression: "log:0.facility", want: float64(4)},
+		{name: "severity", expression: "log:0.severity", want: float64(2)},
+		{name: "severity name", expression: "log:0.severityName", want: "crit"},
+		{name: "timestamp", expression: "log:0.timestamp", want: "2003-10-11T22:14:15.003Z"},
+		{name: "app name", expression: "log:0.appName", want: "su"},
+		{name: "nil procid", expression: "log:0.procId", want: nil},
+		{name: "message without BOM", expression: "log:0.message", want: "'su root' failed for lonvick on /dev/pts/8"},
+		{name: "structured data param", expression: "log:1.structuredData.exampleSDID@32473.iut", want: "3"},
+		{name: "escaped structured data", expression: "log:1.structuredData.exampleSDID@32473.eventSource", want: `App"lication]`},
+		{name: "second sd element", expression: "log:1.structuredData.origin.ip", want: "192.0.2.1"},
+		{name: "message after structured data", expression: "log:1.message", want: "An application event"},
+		{name: "all nil", expression: "log:2.hostname", want: nil},
+		{name: "no message", expression: "log:2.message", want: nil},
+		{name: "entry count", expression: "log:#", want: float64(3)},
+		{name: "filter", expression: `jsonpath:#(severity<3)#.hostname`, want: []interface{}{"mymachine.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(lines), "text/x-syslog", NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogPayloadLogfmt(t *testing.T) {
+	lines := `ts=2024-05-01T10:00:00Z level=info msg="request served" path=/orders status=200 cached
+ts=2024-05-01T10:00:01Z level=error msg="upstream \"billing\" timed out" err=
+`
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+	}{
+		{name: "bare value", expression: "log:0.level", want: "info"},
+		{name: "quoted value", expression: "log:0.msg", want: "request served"},
+		{name: "values are strings", expression: "log:0.status", want: "200"},
+		{name: "key without value", expression: "log:0.cached", want: true},
+		{name: "escaped quotes", expression: "log:1.msg", want: `upstream "billing" timed out`},
+		{name: "empty value", expression: "log:1.err", want: ""},
+		{name: "filter", expression: `log:#(level=="error")#.ts`, want: []interface{}{"2024-05-01T10:00:01Z"}},
+		{name: "jsonpath", expression: "jsonpath:0.path", want: "/orders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(lines), "text/x-logfmt", NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLogPayloadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  LogFormat
+		content string
+		wantErr string
+	}{
+		{name: "unknown format", format: "clf", content: "x", wantErr: "unsupported log format: clf"},
+		{name: "missing priority", format: LogFormatSyslog, content: "1 - - - - - -", wantErr: "invalid syslog line 1: missing priority"},
+		{name: "priority out of range", format: LogFormatSyslog, content: "<192>1 - - - - - -", wantErr: "invalid priority '192'"},
+		{name: "negative priority", format: LogFormatSyslog, content: "<-1>1 - - - - - -", wantErr: "invalid priority '-1'"},
+		{name: "incomplete header", format: LogFormatSyslog, content: "<14>1 - host app", wantErr: "incomplete header"},
+		{name: "invalid version", format: LogFormatSyslog, content: "<14>v1 - - - - - -", wantErr: "invalid version 'v1'"},
+		{name: "missing structured data", format: LogFormatSyslog, content: "<14>1 - - - - - message", wantErr: "invalid structured data"},
+		{name: "unterminated sd element", format: LogFormatSyslog, content: `<14>1 - - - - - [id a="1"`, wantErr: "unterminated structured data element"},
+		{name: "unterminated sd value", format: LogFormatSyslog, content: `<14>1 - - - - - [id a="1]`, wantErr: "unterminated structured data value of a"},
+		{name: "second line reported", format: LogFormatSyslog, content: "<14>1 - - - - - -\n<14>1 - -", wantErr: "invalid syslog line 2"},
+		{name: "logfmt unterminated quote", format: LogFormatLogfmt, content: `msg="oops`, wantErr: "invalid logfmt line 1: unterminated value of msg"},
+		{name: "logfmt missing key", format: LogFormatLogfmt, content: "=value", wantErr: "invalid key at '=value'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLogPayload([]byte(tt.content), tt.format)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
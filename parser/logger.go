@@ -0,0 +1,41 @@
+package parser
+
+// Logger receives diagnostic messages from an engine: payload parse
+// failures, decisions to delegate to a fallback engine or to continue with a
+// fallback payload, and expression cache evictions. keysAndValues alternate
+// field names and values, as in log/slog; a *slog.Logger is a Logger, and
+// so is a zap.SugaredLogger wrapped to call Debugw and Warnw. Its methods are
+// called from the evaluating goroutines, concurrently.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+}
+
+// SetLogger makes the engine log to logger. Engines log nothing by default;
+// passing nil restores that.
+func (ee *ExpressionEngine) SetLogger(logger Logger) {
+	if logger == nil {
+		ee.logger.Store(nil)
+		return
+	}
+	ee.logger.Store(&logger)
+}
+
+// logDebug logs msg at debug level to the Logger of the engine, if any.
+func (ee *ExpressionEngine) logDebug(msg string, keysAndValues ...interface{}) {
+	if logger := ee.logger.Load(); logger != nil {
+		(*logger).Debug(msg, keysAndValues...)
+	}
+}
+
+// logWarn logs msg at warning level to the Logger of the engine, if any.
+func (ee *ExpressionEngine) logWarn(msg string, keysAndValues ...interface{}) {
+	if logger := ee.logger.Load(); logger != nil {
+		(*logger).Warn(msg, keysAndValues...)
+	}
+}
+
+// logCacheEviction logs the eviction of an expression cache entry.
+func (ee *ExpressionEngine) logCacheEviction(kind int, text string) {
+	ee.logDebug("expression cache eviction", "kind", cacheKindNames[kind], "entry", text)
+}
@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	base := NewEngine()
+	engine := NewEngine(WithLogger(logger), WithCache(1))
+	if err := engine.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+
+	msgCtx := NewMessageContext([]byte(`{"a": "x", "doc": "<a"}`), "application/json", engine)
+	if _, err := msgCtx.EvaluateExpression("jsonpath:a | shout"); err == nil {
+		t.Fatal("expected an error for a pipe neither engine knows")
+	}
+	if _, err := msgCtx.EvaluateExpression("jsonpath:doc | extractAsXML"); err == nil {
+		t.Fatal("expected an error for malformed embedded XML")
+	}
+	_, _ = NewMessageContext([]byte(`{`), "application/json", engine).EvaluateExpression("jsonpath:a")
+
+	for _, want := range []string{
+		`level=DEBUG msg="payload parsed" contentType=application/json bytes=23`,
+		`level=DEBUG msg="delegating pipe to fallback engine" stage=shout expression="jsonpath:a | shout"`,
+		`level=DEBUG msg="expression cache eviction" kind=pipeline entry="jsonpath:a | shout"`,
+		`level=DEBUG msg="failed to parse intermediate payload" pipe=extractAsXML contentType=application/xml`,
+		`level=WARN msg="failed to parse payload" contentType=application/json bytes=1 error=`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log does not contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	engine.SetLogger(nil)
+	if _, err := NewMessageContext([]byte(`{"a": "x"}`), "application/json", engine).EvaluateExpression("jsonpath:a"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("logged after the logger was removed:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,405 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// MarkdownPayload handles Markdown documents parsed into an AST.
+// Queries ("md:" expressions) select document structure step by step:
+//
+//	heading('Pricing')          the section under that heading (its Markdown source)
+//	heading('Pricing').table[0] the first table of the section, as rows keyed by column header
+//	heading / heading[2]        all heading texts / the third one
+//	table, link, paragraph, code, list
+//	                            all elements of that kind in the current scope, or one with [i]
+//	text                        the plain text of the current scope
+//
+// Steps are separated by "."; anything after the structural steps is applied as
+// a gjson path to the selected value, e.g. "table[0].#.Price".
+//
+// Documents may start with YAML front matter, which stays available through the
+// frontmatter: and body: expressions.
+type MarkdownPayload struct {
+	*FrontMatterPayload
+	source   []byte   // Markdown body the AST refers to
+	document ast.Node // Parsed AST
+}
+
+// NewMarkdownPayload parses content (optionally preceded by front matter) as
+// GitHub Flavored Markdown.
+func NewMarkdownPayload(content []byte) (*MarkdownPayload, error) {
+	frontMatter, err := NewFrontMatterPayload(content)
+	if err != nil {
+		return nil, err
+	}
+	source := []byte(frontMatter.Body())
+	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+	return &MarkdownPayload{
+		FrontMatterPayload: frontMatter,
+		source:             source,
+		document:           md.Parser().Parse(text.NewReader(source)),
+	}, nil
+}
+
+// Query evaluates a structural Markdown query against the document.
+func (mp *MarkdownPayload) Query(expression string) (QueryResult, error) {
+	steps, rest, err := parseMarkdownQuery(expression)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "invalid Markdown query", InnerError: err}
+	}
+
+	scope := mdScope{nodes: childNodes(mp.document)}
+	scope.start, scope.stop = 0, len(mp.source)
+	var selected interface{}
+	selectedScope := true // Whether the current selection is still a document scope
+
+	for _, step := range steps {
+		switch step.name {
+		case "heading", "section":
+			if step.arg == nil {
+				headings := collectNodes(scope.nodes, ast.KindHeading)
+				values := make([]interface{}, len(headings))
+				for i, h := range headings {
+					values[i] = nodeText(h, mp.source)
+				}
+				selected, err = pickIndex(values, step)
+				selectedScope = false
+				break
+			}
+			section, ok := findSection(scope.nodes, *step.arg, mp.source)
+			if !ok {
+				return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("heading '%s' not found", *step.arg), InnerError: ErrNoMatch}
+			}
+			scope = section
+		case "text":
+			selected = scopeText(scope.nodes, mp.source)
+			selectedScope = false
+		case "table", "link", "paragraph", "code", "list":
+			values := mp.collectElements(scope.nodes, step.name)
+			selected, err = pickIndex(values, step)
+			selectedScope = false
+		}
+		if err != nil { // An index past the elements in scope
+			return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: expression, Reason: err.Error(), InnerError: ErrNoMatch}
+		}
+	}
+	if selectedScope {
+		selected = strings.TrimSpace(string(mp.source[scope.start:scope.stop]))
+	}
+
+	view, err := json.Marshal(selected)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "failed to convert Markdown selection", InnerError: err}
+	}
+	if rest == "" {
+		rest = "@this"
+	}
+	return convertGJSONResult(gjson.GetBytes(view, rest), expression)
+}
+
+// QueryFrontMatter evaluates a gjson path against the document's front matter.
+func (mp *MarkdownPayload) QueryFrontMatter(expression string) (QueryResult, error) {
+	return mp.FrontMatterPayload.Query(expression)
+}
+
+func (mp *MarkdownPayload) GetUnderlying() interface{} {
+	return mp.document
+}
+
+// mdStep is one structural step of a Markdown query, e.g. heading('Pricing') or table[0].
+type mdStep struct {
+	name  string
+	arg   *string
+	index *int
+}
+
+// selectsSection reports whether the step narrows the scope to a section rather
+// than selecting element values.
+func (s mdStep) selectsSection() bool {
+	return (s.name == "heading" || s.name == "section") && s.arg != nil
+}
+
+var markdownStepNames = map[string]bool{
+	"heading": true, "section": true, "text": true,
+	"table": true, "link": true, "paragraph": true, "code": true, "list": true,
+}
+
+// parseMarkdownQuery splits an expression into structural steps and the
+// remaining gjson path.
+func parseMarkdownQuery(expression string) ([]mdStep, string, error) {
+	var steps []mdStep
+	rest := strings.TrimSpace(expression)
+	for rest != "" {
+		nameEnd := strings.IndexAny(rest, "(.[")
+		if nameEnd < 0 {
+			nameEnd = len(rest)
+		}
+		name := rest[:nameEnd]
+		if !markdownStepNames[name] {
+			break // Remaining segments form the gjson path
+		}
+		step := mdStep{name: name}
+		rest = rest[nameEnd:]
+
+		if strings.HasPrefix(rest, "(") {
+			if len(rest) < 2 || (rest[1] != '\'' && rest[1] != '"') {
+				return nil, "", fmt.Errorf("%s(...) takes a quoted argument", name)
+			}
+			quote := rest[1]
+			end := strings.IndexByte(rest[2:], quote)
+			if end < 0 || !strings.HasPrefix(rest[2+end+1:], ")") {
+				return nil, "", fmt.Errorf("unterminated argument to %s", name)
+			}
+			arg := rest[2 : 2+end]
+			step.arg = &arg
+			rest = rest[2+end+2:]
+		}
+		if strings.HasPrefix(rest, "[") {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, "", fmt.Errorf("unterminated index on %s", name)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, "", fmt.Errorf("invalid index on %s: %s", name, rest[1:end])
+			}
+			step.index = &index
+			rest = rest[end+1:]
+		}
+		steps = append(steps, step)
+
+		if rest != "" && !strings.HasPrefix(rest, ".") {
+			return nil, "", fmt.Errorf("unexpected '%s' after %s", rest, name)
+		}
+		rest = strings.TrimPrefix(rest, ".")
+		if !step.selectsSection() {
+			break // An element was selected; the rest addresses its value (e.g. link[0].text)
+		}
+	}
+	return steps, rest, nil
+}
+
+// mdScope is a run of sibling block nodes together with the source range they cover.
+type mdScope struct {
+	nodes       []ast.Node
+	start, stop int
+}
+
+func childNodes(parent ast.Node) []ast.Node {
+	var nodes []ast.Node
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		nodes = append(nodes, c)
+	}
+	return nodes
+}
+
+// findSection locates the first heading whose text equals title (case-insensitive)
+// and returns the nodes up to the next heading of the same or a higher level.
+func findSection(nodes []ast.Node, title string, source []byte) (mdScope, bool) {
+	for i, n := range nodes {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !strings.EqualFold(strings.TrimSpace(nodeText(heading, source)), strings.TrimSpace(title)) {
+			continue
+		}
+		end := len(nodes)
+		for j := i + 1; j < len(nodes); j++ {
+			if next, ok := nodes[j].(*ast.Heading); ok && next.Level <= heading.Level {
+				end = j
+				break
+			}
+		}
+		section := mdScope{nodes: nodes[i+1 : end], start: len(source), stop: len(source)}
+		if len(section.nodes) > 0 {
+			section.start = blockStart(section.nodes[0], source)
+			section.stop = len(source)
+			if end < len(nodes) {
+				section.stop = blockStart(nodes[end], source)
+			}
+		}
+		return section, true
+	}
+	return mdScope{}, false
+}
+
+// blockStart returns the source offset of the beginning of the line a block node starts on.
+func blockStart(n ast.Node, source []byte) int {
+	for c := n; c != nil; c = c.FirstChild() {
+		if c.Type() == ast.TypeBlock && c.Lines().Len() > 0 {
+			start := c.Lines().At(0).Start
+			return bytes.LastIndexByte(source[:start], '\n') + 1
+		}
+		if t, ok := c.(*ast.Text); ok {
+			return bytes.LastIndexByte(source[:t.Segment.Start], '\n') + 1
+		}
+	}
+	return len(source)
+}
+
+// collectNodes returns all descendants of nodes (including themselves) of the given kind.
+func collectNodes(nodes []ast.Node, kind ast.NodeKind) []ast.Node {
+	var found []ast.Node
+	for _, n := range nodes {
+		ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+			if entering && c.Kind() == kind {
+				found = append(found, c)
+			}
+			return ast.WalkContinue, nil
+		})
+	}
+	return found
+}
+
+// collectElements returns the JSON-friendly values of all elements of a kind.
+func (mp *MarkdownPayload) collectElements(nodes []ast.Node, kind string) []interface{} {
+	var values []interface{}
+	switch kind {
+	case "table":
+		for _, n := range collectNodes(nodes, extast.KindTable) {
+			values = append(values, tableRows(n, mp.source))
+		}
+	case "link":
+		for _, n := range nodes {
+			ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+				if !entering {
+					return ast.WalkContinue, nil
+				}
+				switch link := c.(type) {
+				case *ast.Link:
+					values = append(values, map[string]interface{}{"text": nodeText(link, mp.source), "url": string(link.Destination), "title": string(link.Title)})
+				case *ast.AutoLink:
+					url := string(link.URL(mp.source))
+					values = append(values, map[string]interface{}{"text": url, "url": url, "title": ""})
+				}
+				return ast.WalkContinue, nil
+			})
+		}
+	case "paragraph":
+		for _, n := range collectNodes(nodes, ast.KindParagraph) {
+			values = append(values, nodeText(n, mp.source))
+		}
+	case "code":
+		for _, n := range nodes {
+			ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+				if entering && (c.Kind() == ast.KindFencedCodeBlock || c.Kind() == ast.KindCodeBlock) {
+					values = append(values, string(blockLines(c, mp.source)))
+				}
+				return ast.WalkContinue, nil
+			})
+		}
+	case "list":
+		for _, n := range collectNodes(nodes, ast.KindList) {
+			var items []interface{}
+			for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+				items = append(items, nodeText(item, mp.source))
+			}
+			values = append(values, items)
+		}
+	}
+	return values
+}
+
+// pickIndex applies an optional [i] index to the collected values.
+func pickIndex(values []interface{}, step mdStep) (interface{}, error) {
+	if step.index == nil {
+		if values == nil {
+			values = []interface{}{}
+		}
+		return values, nil
+	}
+	if *step.index >= len(values) {
+		return nil, fmt.Errorf("%s[%d] not found: %d element(s) in scope", step.name, *step.index, len(values))
+	}
+	return values[*step.index], nil
+}
+
+// tableRows converts a GFM table into one object per body row, keyed by header cell text.
+func tableRows(table ast.Node, source []byte) []interface{} {
+	var headers []string
+	rows := []interface{}{}
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, strings.TrimSpace(nodeText(cell, source)))
+		}
+		if row.Kind() == extast.KindTableHeader {
+			headers = cells
+			continue
+		}
+		obj := make(map[string]interface{}, len(cells))
+		for i, cell := range cells {
+			key := strconv.Itoa(i)
+			if i < len(headers) {
+				key = headers[i]
+			}
+			obj[key] = cell
+		}
+		rows = append(rows, obj)
+	}
+	return rows
+}
+
+// scopeText returns the plain text of the block nodes, one block per line.
+func scopeText(nodes []ast.Node, source []byte) string {
+	var parts []string
+	for _, n := range nodes {
+		if t := nodeText(n, source); t != "" {
+			parts = append(parts, t)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// nodeText returns the plain text content of a node and its descendants.
+func nodeText(n ast.Node, source []byte) string {
+	var sb strings.Builder
+	writeNodeText(&sb, n, source)
+	return strings.TrimSpace(sb.String())
+}
+
+func writeNodeText(sb *strings.Builder, n ast.Node, source []byte) {
+	switch node := n.(type) {
+	case *ast.Text:
+		sb.Write(node.Segment.Value(source))
+		if node.SoftLineBreak() || node.HardLineBreak() {
+			sb.WriteByte(' ')
+		}
+		return
+	case *ast.String:
+		sb.Write(node.Value)
+		return
+	case *ast.AutoLink:
+		sb.Write(node.URL(source))
+		return
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		sb.Write(blockLines(n, source))
+		return
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		writeNodeText(sb, c, source)
+		if c.Type() == ast.TypeBlock && c.NextSibling() != nil {
+			sb.WriteByte('\n')
+		}
+	}
+}
+
+// blockLines returns the raw source lines of a block node (e.g. code block contents).
+func blockLines(n ast.Node, source []byte) []byte {
+	var buf bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		buf.Write(segment.Value(source))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+const markdownDoc = `---
+title: Release notes
+---
+# Overview
+
+Intro with a [guide](https://example.com/guide "Guide").
+
+## Pricing
+
+| Plan | Price |
+|------|-------|
+| Free | 0     |
+| Pro  | 10    |
+
+- first
+- second
+
+## Install
+
+` + "```sh\ngo get example.com/x\n```" + `
+
+See <https://example.com/docs>.
+`
+
+func TestMarkdownPayloadQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "heading texts", expression: "md:heading", want: []interface{}{"Overview", "Pricing", "Install"}},
+		{name: "heading by index", expression: "md:heading[1]", want: "Pricing"},
+		{name: "section source", expression: "md:heading('install').code[0]", want: "go get example.com/x\n"},
+		{name: "table cell in section", expression: "md:heading('Pricing').table[0].1.Price", want: "10"},
+		{name: "table column", expression: "md:section('Pricing').table[0].#.Plan", want: []interface{}{"Free", "Pro"}},
+		{name: "list items", expression: "md:heading('Pricing').list[0]", want: []interface{}{"first", "second"}},
+		{name: "link fields", expression: "md:link[0].url", want: "https://example.com/guide"},
+		{name: "link text is not a step", expression: "md:link[0].text", want: "guide"},
+		{name: "autolink", expression: "md:heading('Install').link[0].url", want: "https://example.com/docs"},
+		{name: "nested section keeps subsections", expression: "md:heading('Overview').heading", want: []interface{}{"Pricing", "Install"}},
+		{name: "section text", expression: "md:heading('Install').text", want: "go get example.com/x\nSee https://example.com/docs."},
+		{name: "paragraph count", expression: "md:paragraph.#", want: float64(2)},
+		{name: "front matter still available", expression: "frontmatter:title", want: "Release notes"},
+		{name: "unknown heading", expression: "md:heading('Missing')", wantErr: true},
+		{name: "index out of range", expression: "md:table[3]", wantErr: true},
+		{name: "unquoted argument", expression: "md:heading(Pricing)", wantErr: true},
+		{name: "invalid index", expression: "md:table[x]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(markdownDoc), "text/markdown", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownPrefixRequiresMarkdown(t *testing.T) {
+	msgCtx := NewMessageContext([]byte("Just text"), "text/plain", NewEngine())
+	if _, err := msgCtx.EvaluateExpression("md:heading"); err == nil {
+		t.Fatal("expected an error for md: on a text/plain payload")
+	}
+}
@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"mime"
+	"strings"
+)
+
+// mediaType returns the lower-cased media type of contentType without its
+// parameters, e.g. "application/json" for "Application/JSON; charset=utf-8".
+// Content types mime.ParseMediaType rejects (e.g. with malformed parameters)
+// are reduced to the text before the first ';'.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt, _, _ = strings.Cut(contentType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// isJSONMediaType reports whether mt is application/json or uses the +json
+// structured syntax suffix (RFC 6839), e.g. application/problem+json.
+func isJSONMediaType(mt string) bool {
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// isXMLMediaType reports whether mt is application/xml, text/xml or uses the
+// +xml structured syntax suffix (RFC 7303), e.g. application/atom+xml.
+func isXMLMediaType(mt string) bool {
+	return mt == "application/xml" || mt == "text/xml" || strings.HasSuffix(mt, "+xml")
+}
@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMediaType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+	}{
+		{contentType: "application/json", want: "application/json"},
+		{contentType: "application/json; charset=utf-8", want: "application/json"},
+		{contentType: "Application/JSON;Charset=UTF-8", want: "application/json"},
+		{contentType: "  text/xml ; charset=\"iso-8859-1\"", want: "text/xml"},
+		{contentType: "text/csv; header", want: "text/csv"}, // Malformed parameter
+		{contentType: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			if got := mediaType(tt.contentType); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// parameterizedPayload reports the content type of a payload with
+// parameters, as custom PayloadObject implementations may do.
+type parameterizedPayload struct {
+	PayloadObject
+	contentType string
+}
+
+func (pp parameterizedPayload) GetContentType() string {
+	return pp.contentType
+}
+
+func TestEvaluateParameterizedContentTypes(t *testing.T) {
+	factory := NewPayloadFactory()
+	tests := []struct {
+		name        string
+		raw         string
+		baseType    string
+		contentType string
+		expression  string
+		want        interface{}
+	}{
+		{name: "json with charset", raw: `{"id":"A-1"}`, baseType: "application/json", contentType: "application/json; charset=utf-8", expression: "jsonpath:id", want: "A-1"},
+		{name: "xml upper case", raw: "<a><id>A-2</id></a>", baseType: "application/xml", contentType: "Application/XML", expression: "xpath:/a/id/text()", want: "A-2"},
+		{name: "yaml alias", raw: "id: A-3", baseType: "application/yaml", contentType: "text/yaml; charset=utf-8", expression: "yamlpath:id", want: "A-3"},
+		{name: "csv with header parameter", raw: "id\nA-4\n", baseType: "text/csv", contentType: "text/csv; header=present", expression: "csv:0.id", want: "A-4"},
+		{name: "json suffix", raw: `{"title":"Not Found"}`, baseType: "application/json", contentType: "application/problem+json", expression: "jsonpath:title", want: "Not Found"},
+		{name: "xml suffix", raw: "<feed><title>News</title></feed>", baseType: "application/xml", contentType: "application/atom+xml; charset=utf-8", expression: "xpath:/feed/title/text()", want: "News"},
+		{name: "ndjson alias", raw: "{\"id\":\"A-5\"}\n", baseType: "application/x-ndjson", contentType: "application/jsonl", expression: "ndjson:record(0).id", want: "A-5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := factory.CreatePayload([]byte(tt.raw), tt.baseType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := NewEngine().Evaluate(parameterizedPayload{payload, tt.contentType}, tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatePayloadMediaTypes(t *testing.T) {
+	tests := []struct {
+		contentType string
+		wantType    string
+	}{
+		{contentType: "APPLICATION/JSON", wantType: "*parser.JSONPayload"},
+		{contentType: "application/json ; charset=UTF-8", wantType: "*parser.JSONPayload"},
+		{contentType: "application/json;charset", wantType: "*parser.JSONPayload"},
+		{contentType: "Text/Plain; format=flowed", wantType: "*parser.TextPayload"},
+		{contentType: "application/vnd.api+json", wantType: "*parser.JSONPayload"},
+		{contentType: "application/problem+json; charset=utf-8", wantType: "*parser.JSONPayload"},
+		{contentType: "Application/HAL+JSON", wantType: "*parser.JSONPayload"},
+		{contentType: "application/atom+xml", wantType: "*parser.XMLPayload"},
+		{contentType: "image/svg+xml; charset=utf-8", wantType: "*parser.XMLPayload"},
+		{contentType: "application/soap+xml", wantType: "*parser.SOAPPayload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			raw := []byte(`{"id":1}`)
+			if strings.HasSuffix(tt.wantType, "XMLPayload") || strings.HasSuffix(tt.wantType, "SOAPPayload") {
+				raw = []byte(`<Envelope xmlns="http://www.w3.org/2003/05/soap-envelope"><Body/></Envelope>`)
+			}
+			payload, err := NewPayloadFactory().CreatePayload(raw, tt.contentType)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fmt.Sprintf("%T", payload); got != tt.wantType {
+				t.Errorf("got %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestJSONSuffixMediaTypes(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     bool
+	}{
+		{contentType: "application/vnd.api+json", expression: "jsonpath:data.0.type", want: "articles"},
+		{contentType: "application/hal+json; charset=utf-8", expression: "jsonpath:_links.self.href", want: "/orders/1"},
+		{contentType: "application/problem+json", expression: "jsonpath:status", want: float64(404)},
+		{contentType: "application/problem+json", expression: "xpath:/status", wantErr: true},
+		{contentType: "application/x-protobuf+json", expression: "jsonpath:status", wantErr: true}, // Still needs a message type
+	}
+	raw := []byte(`{"data":[{"type":"articles"}],"_links":{"self":{"href":"/orders/1"}},"status":404}`)
+	for _, tt := range tests {
+		t.Run(tt.contentType+" "+tt.expression, func(t *testing.T) {
+			result, err := NewMessageContext(raw, tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %#v, want error", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestXMLSuffixMediaTypes(t *testing.T) {
+	atom := `<feed xmlns="http://www.w3.org/2005/Atom"><title>News</title><entry><title>First</title></entry><entry><title>Second</title></entry></feed>`
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="10"><circle r="4"/><circle r="2"/></svg>`
+	tests := []struct {
+		raw         string
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     bool
+	}{
+		{raw: atom, contentType: "application/atom+xml", expression: "xpath:count(//*[local-name()='entry'])", want: float64(2)},
+		{raw: atom, contentType: "application/rss+xml; charset=utf-8", expression: "xpath:string(/*[local-name()='feed']/*[local-name()='title'])", want: "News"},
+		{raw: svg, contentType: "image/svg+xml", expression: "xpath:string(/*/@width)", want: "10"},
+		{raw: svg, contentType: "image/svg+xml", expression: "jsonpath:width", wantErr: true},
+		{raw: "<a><b>", contentType: "application/atom+xml", expression: "xpath:/a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType+" "+tt.expression, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(tt.raw), tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %#v, want error", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
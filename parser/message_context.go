@@ -1,27 +1,60 @@
 package parser
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"mime"
 	"sync"
 )
 
 // MessageContext holds the message payload and provides methods to interact with it.
+// It is safe for concurrent use: the payload is parsed once, by the first
+// evaluation, and shared by the others. RawPayload, ContentType and
+// ContentEncoding must not be changed after the first evaluation.
 type MessageContext struct {
 	RawPayload       []byte
 	ContentType      string
+	ContentEncoding  string        // HTTP Content-Encoding of RawPayload, e.g. "gzip"; empty if not compressed
 	processedPayload PayloadObject // Cached parsed payload
 	payloadLock      sync.RWMutex
 	engine           *ExpressionEngine // Reference to the expression engine
-	payloadFactory   *PayloadFactory        // To create the initial payload object
+	payloadFactory   *PayloadFactory   // To create the initial payload object
+
+	computedLock   sync.Mutex
+	computedFields map[string]string      // Fields registered on this context only
+	computedCache  map[string]QueryResult // Computed field values of this payload
 }
 
-func NewMessageContext(rawPayload []byte, contentType string, engine *ExpressionEngine) *MessageContext {
-	return &MessageContext{
+// NewMessageContext creates a MessageContext for a payload of contentType,
+// evaluated by engine and configured by opts.
+func NewMessageContext(rawPayload []byte, contentType string, engine *ExpressionEngine, opts ...MessageOption) *MessageContext {
+	mc := &MessageContext{
 		RawPayload:     rawPayload,
 		ContentType:    contentType,
 		engine:         engine,
-		payloadFactory: NewPayloadFactory(), // Each context gets its own factory instance or use a global one
+		payloadFactory: engine.payloadFactory, // Shared so payload types registered on the engine (e.g. protobuf descriptors) are available
+	}
+	for _, opt := range opts {
+		opt(mc)
 	}
+	return mc
+}
+
+// NewProtobufMessageContext creates a MessageContext for a binary protobuf payload
+// of the given fully-qualified message type. The type must be registered on the
+// engine with RegisterDescriptorSet.
+func NewProtobufMessageContext(rawPayload []byte, messageType string, engine *ExpressionEngine) *MessageContext {
+	contentType := mime.FormatMediaType("application/x-protobuf", map[string]string{"messageType": messageType})
+	return NewMessageContext(rawPayload, contentType, engine)
+}
+
+// NewEncodedMessageContext creates a MessageContext for a payload compressed
+// with the HTTP Content-Encoding contentEncoding (gzip, deflate, br, or a
+// comma-separated list of them). The payload is decompressed when it is first
+// parsed.
+func NewEncodedMessageContext(rawPayload []byte, contentType, contentEncoding string, engine *ExpressionEngine) *MessageContext {
+	return NewMessageContext(rawPayload, contentType, engine, WithContentEncoding(contentEncoding))
 }
 
 // ensurePayloadParsed lazily parses the payload if not already done.
@@ -41,30 +74,91 @@ func (mc *MessageContext) ensurePayloadParsed() error {
 		return nil
 	}
 
-	var err error
-	mc.processedPayload, err = mc.payloadFactory.CreatePayload(mc.RawPayload, mc.ContentType)
+	// Only cache on success: a failed constructor returns a typed nil that would
+	// otherwise look like a parsed payload to later calls
+	maxBytes := mc.engine.Limits().MaxPayloadBytes
+	if err := checkLimit("MaxPayloadBytes", maxBytes, len(mc.RawPayload), ""); err != nil {
+		return err
+	}
+	raw, err := decodeContentEncoding(mc.RawPayload, mc.ContentEncoding, maxBytes)
+	var limitErr *ErrLimitExceeded
+	if errors.As(err, &limitErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	payload, err := mc.engine.parsePayload(mc.payloadFactory, raw, mc.ContentType)
 	if err != nil {
+		mc.engine.logWarn("failed to parse payload", "contentType", mc.ContentType, "bytes", len(raw), "error", err)
 		return fmt.Errorf("failed to parse payload: %w", err)
 	}
-	fmt.Printf("DEBUG: Payload (type: %s) parsed and cached.\n", mc.ContentType) // For demo
+	mc.processedPayload = payload
+	mc.engine.logDebug("payload parsed", "contentType", mc.ContentType, "bytes", len(raw))
 	return nil
 }
 
 // EvaluateExpression evaluates an expression against the message payload.
 // It handles lazy parsing of the payload.
 func (mc *MessageContext) EvaluateExpression(fullExpression string) (QueryResult, error) {
+	return mc.EvaluateExpressionContext(context.Background(), fullExpression)
+}
+
+// EvaluateExpressionContext is like EvaluateExpression but honors the
+// cancellation and deadline of ctx (see ExpressionEngine.EvaluateContext).
+func (mc *MessageContext) EvaluateExpressionContext(ctx context.Context, fullExpression string) (QueryResult, error) {
+	// Observed here rather than by the engine so that the full content type
+	// (including parameters) and parse failures are captured
+	return mc.engine.observeEvaluation(mc.RawPayload, mc.ContentType, mc.ContentEncoding, fullExpression, func() (QueryResult, error) {
+		if err := mc.ensurePayloadParsed(); err != nil {
+			return QueryResult{}, err
+		}
+		return mc.evaluate(ctx, mc.engine.parse(fullExpression))
+	})
+}
+
+// EvaluateBatch evaluates expressions against the message payload, parsing
+// it at most once, and returns their results in the same order. Every
+// expression is evaluated even if others fail: the result of a failed one is
+// the zero QueryResult, and the error joins an *ExpressionError for each
+// failure. If the payload cannot be parsed, no expression is evaluated.
+func (mc *MessageContext) EvaluateBatch(expressions []string) ([]QueryResult, error) {
+	return mc.EvaluateBatchContext(context.Background(), expressions)
+}
+
+// EvaluateBatchContext is like EvaluateBatch but honors the cancellation and
+// deadline of ctx. Expressions not evaluated when ctx is done fail with its
+// error.
+func (mc *MessageContext) EvaluateBatchContext(ctx context.Context, expressions []string) ([]QueryResult, error) {
+	if err := mc.ensurePayloadParsed(); err != nil {
+		return nil, err
+	}
+	results := make([]QueryResult, len(expressions))
+	var errs []error
+	for i, expression := range expressions {
+		result, err := mc.EvaluateExpressionContext(ctx, expression)
+		if err != nil {
+			errs = append(errs, &ExpressionError{Index: i, Expression: expression, Err: err})
+			continue
+		}
+		results[i] = result
+	}
+	return results, errors.Join(errs...)
+}
+
+func (mc *MessageContext) evaluate(ctx context.Context, expression *Expression) (QueryResult, error) {
 	if err := mc.ensurePayloadParsed(); err != nil {
 		return QueryResult{}, err
 	}
-	// The engine's Evaluate method now takes the PayloadObject directly
-	return mc.engine.Evaluate(mc.processedPayload, fullExpression)
+	ctx = withComputedScope(ctx, mc, mc.processedPayload)
+	return mc.engine.runPipeline(ctx, mc.processedPayload, expression)
 }
 
 // GetProcessedPayload returns the processed payload object, ensuring it's parsed.
 // Useful if other parts of the system need direct access to the PayloadObject.
 func (mc *MessageContext) GetProcessedPayload() (PayloadObject, error) {
-    if err := mc.ensurePayloadParsed(); err != nil {
-        return nil, err
-    }
-    return mc.processedPayload, nil
+	if err := mc.ensurePayloadParsed(); err != nil {
+		return nil, err
+	}
+	return mc.processedPayload, nil
 }
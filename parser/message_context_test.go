@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMessageContextParseFailureIsNotCached(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		contentType string
+	}{
+		{name: "invalid JSON", raw: `{`, contentType: "application/json"},
+		{name: "invalid XML", raw: `<a>`, contentType: "application/xml"},
+		{name: "unsupported content type", raw: `x`, contentType: "application/x-unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(tt.raw), tt.contentType, NewEngine())
+			for i := 0; i < 2; i++ {
+				if _, err := msgCtx.EvaluateExpression("jsonpath:a"); err == nil {
+					t.Fatalf("call %d: expected a parse error", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	engine := NewEngine()
+	parses := 0
+	engine.RegisterPayloadType("application/x-counted+json", func(raw []byte) (PayloadObject, error) {
+		parses++
+		return NewJSONPayload(raw)
+	})
+	msgCtx := NewMessageContext([]byte(`{"id": "7", "total": 12.5}`), "application/x-counted+json", engine)
+
+	results, err := msgCtx.EvaluateBatch([]string{"jsonpath:id", "jsonpath:missing", "jsonpath:total"})
+	if parses != 1 {
+		t.Errorf("payload parsed %d times, want 1", parses)
+	}
+	if len(results) != 3 || results[0].Value != "7" || results[1].Value != nil || results[2].Value != 12.5 {
+		t.Errorf("got %v", results)
+	}
+	var exprErr *ExpressionError
+	if !errors.As(err, &exprErr) || exprErr.Index != 1 || !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected an ExpressionError for expression 1, got %v", err)
+	}
+
+	results, err = NewMessageContext([]byte(`{`), "application/json", engine).EvaluateBatch([]string{"jsonpath:id"})
+	if err == nil || results != nil {
+		t.Errorf("expected a parse error and no results, got %v, %v", results, err)
+	}
+	if errors.As(err, &exprErr) {
+		t.Errorf("a parse failure must not be reported per expression")
+	}
+}
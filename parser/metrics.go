@@ -0,0 +1,82 @@
+package parser
+
+import "time"
+
+// MetricsSink receives measurements from an engine, e.g. to export them to
+// Prometheus or OpenTelemetry. Its methods are called from the evaluating
+// goroutines, concurrently, and should return quickly.
+type MetricsSink interface {
+	// Evaluation reports a top-level evaluation of expression (by Evaluate,
+	// EvaluateExpression, a CompiledExpression, ...); err is nil if it
+	// succeeded. Sub-expressions of pipes are not reported on their own.
+	Evaluation(expression string, duration time.Duration, err error)
+	// CacheLookup reports a lookup in the expression cache (see
+	// SetExpressionCacheSize) of a "pipeline", "xpath" or "regex" entry.
+	CacheLookup(kind string, hit bool)
+	// PayloadParsed reports the parsing of a message payload or of an
+	// intermediate payload; mediaType is its content type without
+	// parameters, e.g. "application/json".
+	PayloadParsed(mediaType string, duration time.Duration, err error)
+}
+
+// cacheKindNames are the kinds of expressionCache entries reported to a
+// MetricsSink.
+var cacheKindNames = map[int]string{cachedPipeline: "pipeline", cachedXPath: "xpath", cachedRegex: "regex"}
+
+// SetMetricsSink makes the engine report its evaluations, expression cache
+// lookups and payload parsing to sink. Passing nil stops reporting.
+func (ee *ExpressionEngine) SetMetricsSink(sink MetricsSink) {
+	if sink == nil {
+		ee.metrics.Store(nil)
+		return
+	}
+	ee.metrics.Store(&sink)
+}
+
+// metricsSink returns the MetricsSink of the engine, or nil.
+func (ee *ExpressionEngine) metricsSink() MetricsSink {
+	if sink := ee.metrics.Load(); sink != nil {
+		return *sink
+	}
+	return nil
+}
+
+// observeEvaluation runs evaluate, an evaluation of expression against raw,
+// and reports it to the recorder and the MetricsSink of the engine, if any.
+func (ee *ExpressionEngine) observeEvaluation(raw []byte, contentType, contentEncoding, expression string, evaluate func() (QueryResult, error)) (QueryResult, error) {
+	recorder, metrics := ee.recorder.Load(), ee.metricsSink()
+	if recorder == nil && metrics == nil {
+		return evaluate()
+	}
+	start := time.Now()
+	result, err := evaluate()
+	duration := time.Since(start)
+	if recorder != nil {
+		recorder.record(raw, contentType, contentEncoding, expression, result, err, duration)
+	}
+	if metrics != nil {
+		metrics.Evaluation(expression, duration, err)
+	}
+	return result, err
+}
+
+// parsePayload creates a payload of contentType with pf and reports the
+// parsing to the MetricsSink of the engine, if any.
+func (ee *ExpressionEngine) parsePayload(pf *PayloadFactory, raw []byte, contentType string) (PayloadObject, error) {
+	metrics := ee.metricsSink()
+	if metrics == nil {
+		return pf.CreatePayload(raw, contentType)
+	}
+	start := time.Now()
+	payload, err := pf.CreatePayload(raw, contentType)
+	metrics.PayloadParsed(mediaType(contentType), time.Since(start), err)
+	return payload, err
+}
+
+// reportCacheLookup reports a lookup in the expression cache to the
+// MetricsSink of the engine, if any.
+func (ee *ExpressionEngine) reportCacheLookup(kind int, hit bool) {
+	if metrics := ee.metricsSink(); metrics != nil {
+		metrics.CacheLookup(cacheKindNames[kind], hit)
+	}
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingSink counts the measurements it receives by a description.
+type countingSink struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (s *countingSink) add(event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[event]++
+}
+
+func (s *countingSink) Evaluation(expression string, _ time.Duration, err error) {
+	s.add(fmt.Sprintf("evaluation %s (err %t)", expression, err != nil))
+}
+
+func (s *countingSink) CacheLookup(kind string, hit bool) {
+	s.add(fmt.Sprintf("cache %s (hit %t)", kind, hit))
+}
+
+func (s *countingSink) PayloadParsed(mediaType string, _ time.Duration, err error) {
+	s.add(fmt.Sprintf("parsed %s (err %t)", mediaType, err != nil))
+}
+
+func TestMetricsSink(t *testing.T) {
+	sink := &countingSink{}
+	engine := NewEngine(WithMetricsSink(sink))
+	msgCtx := NewMessageContext([]byte(`{"doc": "<a><b>1</b></a>"}`), "application/json; charset=utf-8", engine)
+	for i := 0; i < 2; i++ {
+		if _, err := msgCtx.EvaluateExpression("jsonpath:doc | extractAsXML | xpath:/a/b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := msgCtx.EvaluateExpression("jsonpath:missing"); err == nil {
+		t.Fatal("expected an error for a missing path")
+	}
+	_, _ = NewMessageContext([]byte(`{`), "application/json", engine).EvaluateExpression("jsonpath:missing")
+
+	want := map[string]int{
+		"evaluation jsonpath:doc | extractAsXML | xpath:/a/b (err false)": 2,
+		"evaluation jsonpath:missing (err true)":                          2,
+		"cache pipeline (hit false)":                                      2,
+		"cache pipeline (hit true)":                                       1, // The unparsable payload fails before its expression is parsed
+		"cache xpath (hit false)":                                         1,
+		"cache xpath (hit true)":                                          1,
+		"parsed application/json (err false)":                             1,
+		"parsed application/json (err true)":                              1,
+		"parsed application/xml (err false)":                              2,
+	}
+	if !reflect.DeepEqual(sink.counts, want) {
+		t.Errorf("counts = %v, want %v", sink.counts, want)
+	}
+
+	engine.SetMetricsSink(nil)
+	if _, err := msgCtx.EvaluateExpression("jsonpath:doc"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.counts["evaluation jsonpath:doc (err false)"]; got != 0 {
+		t.Errorf("evaluations reported after the sink was removed: %d", got)
+	}
+}
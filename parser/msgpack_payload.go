@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tidwall/gjson"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// MessagePackPayload handles MessagePack data.
+// The document is decoded into the same gjson representation JSONPayload uses,
+// so jsonpath expressions work unchanged. Binary values appear base64-encoded
+// and timestamps as RFC 3339 strings.
+type MessagePackPayload struct {
+	rawContent  []byte
+	jsonResult  gjson.Result
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// NewMessagePackPayload decodes a single MessagePack value.
+func NewMessagePackPayload(content []byte) (*MessagePackPayload, error) {
+	reader := bytes.NewReader(content)
+	decoder := msgpack.NewDecoder(reader)
+	doc, err := decodeMessagePackValue(decoder, reader)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "MessagePack decoding failed", InnerError: err}
+	}
+	if _, err := decoder.DecodeInterface(); !errors.Is(err, io.EOF) {
+		return nil, &ErrEvaluationFailed{Reason: "MessagePack payload contains trailing data"}
+	}
+	jsonView, err := marshalJSONView(doc)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert MessagePack document to JSON view", InnerError: err}
+	}
+	return &MessagePackPayload{
+		rawContent:  content,
+		jsonResult:  gjson.ParseBytes(jsonView),
+		contentType: "application/msgpack",
+	}, nil
+}
+
+// decodeMessagePackValue decodes the value at the position of reader, which
+// d reads. Maps and arrays are decoded here rather than by the library, which
+// allocates them with the length of their header: a few bytes claiming
+// billions of elements would exhaust memory before any element is read.
+// Lengths that the bytes left cannot hold, at least one per element, are
+// rejected instead. Keys need not be strings (e.g. integer-keyed maps); they
+// are stringified, as in the JSON view.
+func decodeMessagePackValue(d *msgpack.Decoder, reader *bytes.Reader) (interface{}, error) {
+	code, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case msgpcode.IsFixedMap(code) || code == msgpcode.Map16 || code == msgpcode.Map32:
+		n, err := d.DecodeMapLen()
+		if err != nil || n == -1 {
+			return nil, err
+		}
+		if n > reader.Len()/2 {
+			return nil, fmt.Errorf("map of %d entries exceeds the %d bytes left", n, reader.Len())
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := decodeMessagePackValue(d, reader)
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeMessagePackValue(d, reader)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = value
+		}
+		return m, nil
+	case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+		n, err := d.DecodeArrayLen()
+		if err != nil || n == -1 {
+			return nil, err
+		}
+		if n > reader.Len() {
+			return nil, fmt.Errorf("array of %d elements exceeds the %d bytes left", n, reader.Len())
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], err = decodeMessagePackValue(d, reader); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return d.DecodeInterface()
+}
+
+func (mp *MessagePackPayload) GetRawBytes() []byte {
+	return mp.rawContent
+}
+
+func (mp *MessagePackPayload) GetContentType() string {
+	return mp.contentType
+}
+
+// Query evaluates a gjson path against the decoded document.
+func (mp *MessagePackPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(mp.jsonResult, expression)
+}
+
+func (mp *MessagePackPayload) jsonView() gjson.Result {
+	return mp.jsonResult
+}
+
+// AsString returns the decoded document as JSON, since the raw bytes are binary.
+func (mp *MessagePackPayload) AsString() (string, error) {
+	return mp.jsonResult.Raw, nil
+}
+
+func (mp *MessagePackPayload) GetUnderlying() interface{} {
+	return mp.jsonResult
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestMessagePackPayloadQuery(t *testing.T) {
+	doc, err := msgpack.Marshal(map[string]interface{}{
+		"id":    "A-1",
+		"total": 42.5,
+		"count": int64(3),
+		"paid":  true,
+		"items": []interface{}{"pen", "ink"},
+		"meta":  map[interface{}]interface{}{1: "one"},
+		"blob":  []byte("hi"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "string", expression: "jsonpath:id", want: "A-1"},
+		{name: "float", expression: "jsonpath:total", want: 42.5},
+		{name: "integer", expression: "jsonpath:count", want: float64(3)},
+		{name: "boolean", expression: "jsonpath:paid", want: true},
+		{name: "array element", expression: "jsonpath:items.1", want: "ink"},
+		{name: "array", expression: "jsonpath:items", want: []interface{}{"pen", "ink"}},
+		{name: "non-string map key", expression: "jsonpath:meta.1", want: "one"},
+		{name: "binary is base64", expression: "jsonpath:blob", want: "aGk="},
+		{name: "missing path", expression: "jsonpath:nope", wantErr: true},
+		{name: "xpath is not supported", expression: "xpath:/id", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext(doc, "application/msgpack", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessagePackPayloadInvalid(t *testing.T) {
+	valid, err := msgpack.Marshal("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "empty", content: nil},
+		{name: "truncated", content: []byte{0x92, 0x01}},
+		{name: "trailing data", content: append(valid, 0x01)},
+		// Headers claiming 2^31-1 entries, which must not be allocated
+		{name: "hostile map header", content: []byte{0xdf, 0x7f, 0xff, 0xff, 0xff}},
+		{name: "hostile array header", content: []byte{0xdd, 0x7f, 0xff, 0xff, 0xff}},
+		{name: "hostile nested header", content: []byte{0x81, 0xa1, 'a', 0xdf, 0x7f, 0xff, 0xff, 0xff}},
+		{name: "hostile string header", content: []byte{0xdb, 0x7f, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMessagePackPayload(tt.content); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// MultipartPart is one body part of a multipart payload.
+type MultipartPart struct {
+	Name        string // Form field name (multipart/form-data)
+	FileName    string
+	ContentID   string // Without the angle brackets (multipart/related)
+	ContentType string // Defaults to "text/plain"
+	Header      textproto.MIMEHeader
+	Body        []byte // Decoded from base64 or quoted-printable if needed
+}
+
+// MultipartPayload handles "multipart/form-data" and "multipart/related"
+// bodies. A "part:" expression selects a part by form field name, file name,
+// Content-ID or zero-based index and returns its body:
+//
+//	part:payload.json
+//	part:1
+//	part:                 the root part (the "start" parameter, or the first part)
+//
+// Subsequent pipeline stages query the selected part as a payload of its own
+// content type, e.g. "part:payload.json | jsonpath:order.id".
+type MultipartPayload struct {
+	rawContent  []byte
+	parts       []MultipartPart
+	start       string // Content-ID of the root part, if given
+	contentType string
+}
+
+// NewMultipartPayload splits content into parts using the boundary parameter
+// of contentType.
+func NewMultipartPayload(content []byte, contentType string) (*MultipartPayload, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %s: %w", contentType, err)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("multipart content type %s has no boundary", contentType)
+	}
+
+	var parts []MultipartPart
+	reader := multipart.NewReader(bytes.NewReader(content), boundary)
+	for {
+		p, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Reason: fmt.Sprintf("failed to read part %d of multipart payload", len(parts)), InnerError: err}
+		}
+		part, err := readMultipartPart(p)
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Reason: fmt.Sprintf("failed to read part %d of multipart payload", len(parts)), InnerError: err}
+		}
+		parts = append(parts, part)
+	}
+	return &MultipartPayload{
+		rawContent:  content,
+		parts:       parts,
+		start:       strings.Trim(params["start"], "<>"),
+		contentType: strings.ToLower(mediaType),
+	}, nil
+}
+
+// readMultipartPart reads a raw part, decoding its body according to the
+// Content-Transfer-Encoding header.
+func readMultipartPart(p *multipart.Part) (MultipartPart, error) {
+	var body io.Reader = p
+	switch strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, p) // Line breaks are ignored
+	case "quoted-printable":
+		body = quotedprintable.NewReader(p)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return MultipartPart{}, err
+	}
+	contentType := p.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	return MultipartPart{
+		Name:        p.FormName(),
+		FileName:    p.FileName(),
+		ContentID:   strings.Trim(strings.TrimSpace(p.Header.Get("Content-Id")), "<>"),
+		ContentType: contentType,
+		Header:      p.Header,
+		Body:        data,
+	}, nil
+}
+
+func (mp *MultipartPayload) GetRawBytes() []byte {
+	return mp.rawContent
+}
+
+// GetContentType returns "multipart/form-data" or "multipart/related" (without
+// the boundary).
+func (mp *MultipartPayload) GetContentType() string {
+	return mp.contentType
+}
+
+// Parts returns the parts in document order.
+func (mp *MultipartPayload) Parts() []MultipartPart {
+	return mp.parts
+}
+
+// Part selects a part by form field name, file name, Content-ID or index.
+// An empty selector selects the root part.
+func (mp *MultipartPayload) Part(selector string) (*MultipartPart, error) {
+	selector = strings.TrimSpace(selector)
+	if len(mp.parts) == 0 {
+		return nil, &ErrEvaluationFailed{Expression: selector, Reason: "multipart payload has no parts", InnerError: ErrNoMatch}
+	}
+	if selector == "" {
+		if mp.start == "" {
+			return &mp.parts[0], nil
+		}
+		selector = mp.start
+	}
+	for i := range mp.parts {
+		part := &mp.parts[i]
+		if selector == part.Name || selector == part.FileName || selector == part.ContentID {
+			return part, nil
+		}
+	}
+	if index, err := strconv.Atoi(selector); err == nil {
+		if index < 0 || index >= len(mp.parts) {
+			return nil, &ErrEvaluationFailed{Expression: selector, Reason: fmt.Sprintf("part %d not found: %d part(s)", index, len(mp.parts)), InnerError: ErrNoMatch}
+		}
+		return &mp.parts[index], nil
+	}
+	return nil, &ErrEvaluationFailed{Expression: selector, Reason: fmt.Sprintf("no part named '%s'", selector), InnerError: ErrNoMatch}
+}
+
+// Query returns the body of the selected part (see MultipartPayload).
+func (mp *MultipartPayload) Query(expression string) (QueryResult, error) {
+	part, err := mp.Part(expression)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	return QueryResult{Value: string(part.Body), Type: StringResult}, nil
+}
+
+func (mp *MultipartPayload) AsString() (string, error) {
+	return string(mp.rawContent), nil
+}
+
+func (mp *MultipartPayload) GetUnderlying() interface{} {
+	return mp.parts
+}
@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMultipartPayloadQuery(t *testing.T) {
+	const form = "--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"comment\"\r\n" +
+		"\r\n" +
+		"rush order\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"order\"; filename=\"payload.json\"\r\n" +
+		"Content-Type: application/json\r\n" +
+		"\r\n" +
+		"{\"order\": {\"id\": 42, \"items\": [\"a\", \"b\"]}}\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"invoice\"; filename=\"invoice.xml\"\r\n" +
+		"Content-Type: application/xml\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"PGludm9pY2U+PHRvdGFs\r\nPjkuNTA8L3RvdGFsPjwvaW52b2ljZT4=\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"photo\"; filename=\"photo.png\"\r\n" +
+		"Content-Type: image/png\r\n" +
+		"\r\n" +
+		"PNG...\r\n" +
+		"--XyZ--\r\n"
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "by form name", expression: "part:comment", want: "rush order"},
+		{name: "by file name then jsonpath", expression: "part:payload.json | jsonpath:order.id", want: float64(42)},
+		{name: "by index then jsonpath", expression: "part:1 | jsonpath:order.items", want: []interface{}{"a", "b"}},
+		{name: "base64 part then xpath", expression: "part:invoice.xml | xpath://total", want: "9.50"},
+		{name: "root part", expression: "part:", want: "rush order"},
+		{name: "unsupported part type continues as text", expression: "part:photo | text:lines[0]", want: "PNG..."},
+		{name: "text part continues as text", expression: "part:comment | regex:(\\w+) order", want: "rush"},
+		{name: "unknown part", expression: "part:missing", wantErr: true},
+		{name: "index out of range", expression: "part:4", wantErr: true},
+		{name: "query language mismatch", expression: "part:comment | jsonpath:a", wantErr: true},
+		{name: "unknown part with default", expression: `part:missing | default("none")`, want: "none"},
+		{name: "index out of range with ifEmpty", expression: "part:4 | ifEmpty | part:comment", want: "rush order"},
+		{name: "regex no match with default", expression: `part:comment | regex:FATAL | default("ok")`, want: "ok"},
+		{name: "regex no match with ifEmpty", expression: `part:comment | regex:FATAL | ifEmpty | default("ok")`, want: "ok"},
+		{name: "part on non-multipart stage", expression: "part:payload.json | part:0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(form), "multipart/form-data; boundary=XyZ", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultipartRelatedRootPart(t *testing.T) {
+	const related = "--MIME\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-ID: <logo@example.com>\r\n" +
+		"\r\n" +
+		"PNG\r\n" +
+		"--MIME\r\n" +
+		"Content-Type: application/xml\r\n" +
+		"Content-ID: <root@example.com>\r\n" +
+		"\r\n" +
+		"<envelope><logo>cid:logo@example.com</logo></envelope>\r\n" +
+		"--MIME--\r\n"
+
+	tests := []struct {
+		name        string
+		contentType string
+		expression  string
+		want        interface{}
+	}{
+		{name: "start parameter", contentType: `multipart/related; boundary=MIME; start="<root@example.com>"`, expression: "part: | xpath://logo", want: "cid:logo@example.com"},
+		{name: "first part without start", contentType: "multipart/related; boundary=MIME", expression: "part:", want: "PNG"},
+		{name: "by Content-ID", contentType: "multipart/related; boundary=MIME", expression: "part:logo@example.com", want: "PNG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(related), tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMultipartPayloadInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		wantErr     string
+	}{
+		{name: "no boundary", content: "--a--\r\n", contentType: "multipart/form-data", wantErr: "has no boundary"},
+		{name: "invalid content type", content: "", contentType: "multipart/form-data; boundary", wantErr: "invalid content type"},
+		{name: "unterminated", content: "--a\r\nContent-Type: text/plain\r\n\r\nhello", contentType: "multipart/form-data; boundary=a", wantErr: "failed to read part 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMultipartPayload([]byte(tt.content), tt.contentType)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// referencePrefix starts a stage that stands for a registered expression, e.g. "@orderTotal".
+const referencePrefix = "@"
+
+// RegisterExpression stores expression under name, so that expressions can
+// manage it centrally: other expressions use it as a stage written "@name",
+// and EvaluateNamed evaluates it by name. The stage stands for the stages of
+// expression: with "orderTotal" registered as "jsonpath:order.total",
+// "@orderTotal | toNumber" is evaluated as "jsonpath:order.total | toNumber".
+// Expressions that end in a conversion serve as aliases of a prefix many
+// expressions share: with "embeddedOrder" registered as
+// "xpath:/order/details/text() | extractAsJSON", "@embeddedOrder | jsonpath:id"
+// queries the embedded document.
+//
+// Names are letters, digits, '_', '-' and '.'. The expressions a registered
+// expression uses, also in the sub-expressions of collection pipes, must be
+// registered first, and none may use the expression itself in turn.
+// Registering a name again replaces its expression, also where it is
+// used. Engines that have this engine as their fallback can use its
+// expressions too.
+func (ee *ExpressionEngine) RegisterExpression(name, expression string) error {
+	ee.expressionsLock.Lock()
+	defer ee.expressionsLock.Unlock()
+	if err := ee.checkNamedExpression(ee.registeredExpressions(), name, expression); err != nil {
+		return err
+	}
+	expressions := make(map[string]string)
+	if current := ee.expressions.Load(); current != nil {
+		for n, e := range *current {
+			expressions[n] = e
+		}
+	}
+	expressions[name] = expression
+	ee.expressions.Store(&expressions)
+	return nil
+}
+
+// checkNamedExpression checks that expression can be registered as name when
+// names finds the other registered expressions.
+func (ee *ExpressionEngine) checkNamedExpression(names expressionLookup, name, expression string) error {
+	if !isExpressionName(name) {
+		return fmt.Errorf("invalid expression name '%s'", name)
+	}
+	parsed := ee.parse(expression)
+	if err := ee.checkReferences(names, parsed.Stages, []string{name}); err != nil {
+		return fmt.Errorf("invalid expression '%s': %w", name, err)
+	}
+	for _, w := range ee.lintWith(parsed, names) {
+		if w.Severity == SeverityError {
+			return fmt.Errorf("invalid expression '%s': %s", name, w)
+		}
+	}
+	return nil
+}
+
+// EvaluateNamed evaluates the expression registered as name against payload.
+func (ee *ExpressionEngine) EvaluateNamed(currentPayload PayloadObject, name string) (QueryResult, error) {
+	return ee.Evaluate(currentPayload, referencePrefix+name)
+}
+
+// EvaluateNamed evaluates the expression registered on the engine as name.
+func (mc *MessageContext) EvaluateNamed(name string) (QueryResult, error) {
+	return mc.EvaluateExpression(referencePrefix + name)
+}
+
+// namedExpression returns the expression registered as name on this engine
+// or on its fallback chain.
+func (ee *ExpressionEngine) namedExpression(name string) (string, bool) {
+	return ee.registeredExpressions()(name)
+}
+
+// expressionLookup returns the expression registered as name.
+type expressionLookup func(name string) (string, bool)
+
+// registeredExpressions returns the lookup of the expressions registered now
+// on this engine and on its fallback chain; sets swapped in later are not
+// seen, so that one evaluation expands all its references from the same sets.
+func (ee *ExpressionEngine) registeredExpressions() expressionLookup {
+	var sets []map[string]string
+	for e := ee; e != nil; e = e.fallback.Load() {
+		if set := e.expressions.Load(); set != nil {
+			sets = append(sets, *set)
+		}
+	}
+	return lookupExpressions(sets)
+}
+
+// lookupExpressions finds names in the first of sets that has them.
+func lookupExpressions(sets []map[string]string) expressionLookup {
+	return func(name string) (string, bool) {
+		for _, set := range sets {
+			if expression, ok := set[name]; ok {
+				return expression, true
+			}
+		}
+		return "", false
+	}
+}
+
+// reference returns the name a stage refers to if it is a reference.
+func reference(stage string) (string, bool) {
+	name, ok := strings.CutPrefix(stage, referencePrefix)
+	return name, ok && isExpressionName(name)
+}
+
+func isExpressionName(name string) bool {
+	return name != "" && strings.IndexFunc(name, func(r rune) bool { return !isPipeNameRune(r) }) < 0
+}
+
+// expandReferences returns stages with each reference replaced by the stages
+// of the expression it names, recursively. chain holds the names being
+// expanded, to detect cycles. stages is returned as it is when it has no
+// references.
+func (ee *ExpressionEngine) expandReferences(stages []*Stage, chain []string) ([]*Stage, error) {
+	if !slices.ContainsFunc(stages, func(stage *Stage) bool { return stage.Kind == ReferenceStage }) {
+		return stages, nil
+	}
+	return ee.expandStages(ee.registeredExpressions(), stages, chain)
+}
+
+// expandStages is like expandReferences but finds the expressions with names.
+func (ee *ExpressionEngine) expandStages(names expressionLookup, stages []*Stage, chain []string) ([]*Stage, error) {
+	if !slices.ContainsFunc(stages, func(stage *Stage) bool { return stage.Kind == ReferenceStage }) {
+		return stages, nil
+	}
+	var expanded []*Stage
+	for _, stage := range stages {
+		if stage.Kind != ReferenceStage {
+			expanded = append(expanded, stage)
+			continue
+		}
+		name := stage.Name
+		if slices.Contains(chain, name) {
+			return nil, fmt.Errorf("expression '%s' refers to itself through %s", name, strings.Join(append(chain, name), " -> "))
+		}
+		expression, ok := names(name)
+		if !ok {
+			return nil, fmt.Errorf("no expression is registered as '%s'", name)
+		}
+		inner, err := ee.expandStages(names, ee.parse(expression).Stages, append(chain[:len(chain):len(chain)], name))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, inner...)
+	}
+	return expanded, nil
+}
+
+// checkReferences checks that the references of stages, and those of the
+// expressions they name and of the sub-expressions of collection pipes, are
+// registered in names and do not refer back to a name of chain. Sub-expressions
+// are only expanded when they are evaluated, so expandStages does not see
+// their references.
+func (ee *ExpressionEngine) checkReferences(names expressionLookup, stages []*Stage, chain []string) error {
+	for _, stage := range stages {
+		switch {
+		case stage.Kind == ReferenceStage:
+			name := stage.Name
+			if slices.Contains(chain, name) {
+				return fmt.Errorf("expression '%s' refers to itself through %s", name, strings.Join(append(chain, name), " -> "))
+			}
+			expression, ok := names(name)
+			if !ok {
+				return fmt.Errorf("no expression is registered as '%s'", name)
+			}
+			if err := ee.checkReferences(names, ee.parse(expression).Stages, append(chain[:len(chain):len(chain)], name)); err != nil {
+				return err
+			}
+		case stage.Sub != nil:
+			if err := ee.checkReferences(names, stage.Sub.Stages, chain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNamedExpressions(t *testing.T) {
+	engine := NewEngine()
+	for _, def := range []struct{ name, expression string }{
+		{"orderTotal", "jsonpath:order.total"},
+		{"items", "jsonpath:order.items"},
+		{"skus", "@items | map(jsonpath:sku)"},
+		{"note.to", "jsonpath:order.note | extractAsXML | xpath:/note/to"},
+		{"sku", "jsonpath:sku"},
+		{"embeddedNote", "jsonpath:order.note | extractAsXML"}, // An alias for a shared prefix
+	} {
+		if err := engine.RegisterExpression(def.name, def.expression); err != nil {
+			t.Fatalf("RegisterExpression(%s): %v", def.name, err)
+		}
+	}
+	msgCtx := NewMessageContext([]byte(`{"order": {"total": "42.5", "items": [{"sku": "A"}, {"sku": "B"}], "note": "<note><to>Tove</to></note>"}}`), "application/json", engine)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "reference", expression: "@orderTotal", want: "42.5"},
+		{name: "reference then pipe", expression: "@orderTotal | toNumber", want: 42.5},
+		{name: "reference to a reference", expression: "@skus | join(\",\")", want: "A,B"},
+		{name: "reference with conversion", expression: "@note.to", want: "Tove"},
+		{name: "alias ending in a conversion", expression: "@embeddedNote | xpath:/note/to", want: "Tove"},
+		{name: "alias then pipes", expression: "@embeddedNote | xpath:/note/to | upper", want: "TOVE"},
+		{name: "reference inside collection pipe", expression: "jsonpath:order.items | map(@sku) | join(\"\")", want: "AB"},
+		{name: "unknown name", expression: "@missing", wantErr: "no expression is registered as 'missing'"},
+		{name: "email address is not a reference", expression: "jsonpath:order | default(\"a@b\")", want: map[string]interface{}{"total": "42.5", "items": []interface{}{map[string]interface{}{"sku": "A"}, map[string]interface{}{"sku": "B"}}, "note": "<note><to>Tove</to></note>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+
+	if _, err := engine.Compile("@embeddedNote | xpath:/note/to"); err != nil {
+		t.Errorf("Compile of a query after an alias ending in a conversion: %v", err)
+	}
+	if warnings := engine.Lint("@embeddedNote | xpath:/note/to"); len(warnings) != 0 {
+		t.Errorf("Lint of a query after an alias ending in a conversion: %v", warnings)
+	}
+
+	if result, err := msgCtx.EvaluateNamed("orderTotal"); err != nil || result.Value != "42.5" {
+		t.Errorf("MessageContext.EvaluateNamed: got %v, %v", result.Value, err)
+	}
+	payload, err := NewJSONPayload([]byte(`{"order": {"total": 7}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := engine.EvaluateNamed(payload, "orderTotal"); err != nil || result.Value != float64(7) {
+		t.Errorf("ExpressionEngine.EvaluateNamed: got %v, %v", result.Value, err)
+	}
+}
+
+func TestRegisterExpression(t *testing.T) {
+	tests := []struct {
+		name       string
+		register   [][2]string // Registered before name
+		expression string
+		wantErr    string
+	}{
+		{name: "total", expression: "jsonpath:order.total"},
+		{name: "with-dash_and.dot", expression: "jsonpath:a"},
+		{name: "", expression: "jsonpath:a", wantErr: "invalid expression name ''"},
+		{name: "a b", expression: "jsonpath:a", wantErr: "invalid expression name 'a b'"},
+		{name: "unknown", expression: "@later | trim", wantErr: "no expression is registered as 'later'"},
+		{name: "self", expression: "@self", wantErr: "expression 'self' refers to itself through self -> self"},
+		{name: "a", register: [][2]string{{"a", "jsonpath:x"}, {"b", "@a | trim"}}, expression: "@b", wantErr: "expression 'a' refers to itself through a -> b -> a"},
+		{name: "a", register: [][2]string{{"a", "jsonpath:x"}}, expression: "jsonpath:items | map(@a)", wantErr: "expression 'a' refers to itself through a -> a"},
+		{name: "a", register: [][2]string{{"a", "jsonpath:x"}, {"b", "jsonpath:items | filter(@a > 1)"}}, expression: "@b | trim", wantErr: "expression 'a' refers to itself through a -> b -> a"},
+		{name: "sorted", expression: "jsonpath:items | sortBy(@later, desc)", wantErr: "no expression is registered as 'later'"},
+		{name: "syntax", expression: "jsonpath:a | ", wantErr: "invalid expression 'syntax'"},
+		{name: "pipeFirst", expression: "trim", wantErr: "invalid expression 'pipeFirst'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			for _, r := range tt.register {
+				if err := engine.RegisterExpression(r[0], r[1]); err != nil {
+					t.Fatalf("RegisterExpression(%s): %v", r[0], err)
+				}
+			}
+			err := engine.RegisterExpression(tt.name, tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNamedExpressionsRegisteredAgain(t *testing.T) {
+	base := NewEngine()
+	if err := base.RegisterExpression("id", "jsonpath:order.id"); err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine()
+	if err := engine.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterExpression("label", "@id | upper"); err != nil {
+		t.Fatalf("reference to a fallback expression: %v", err)
+	}
+	compiled, err := engine.Compile("@label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext([]byte(`{"order": {"id": "a1", "ref": "r2"}}`), "application/json", engine)
+	evaluate := func(want string) {
+		t.Helper()
+		if result, err := msgCtx.EvaluateNamed("label"); err != nil || result.Value != want {
+			t.Errorf("EvaluateNamed: got %v, %v, want %s", result.Value, err, want)
+		}
+		if result, err := compiled.EvaluateMessage(msgCtx); err != nil || result.Value != want {
+			t.Errorf("compiled: got %v, %v, want %s", result.Value, err, want)
+		}
+	}
+	evaluate("A1")
+	if err := base.RegisterExpression("id", "jsonpath:order.ref"); err != nil {
+		t.Fatal(err)
+	}
+	evaluate("R2")
+}
+
+func TestNamedExpressionsCheckedAsTheirStages(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterExpression("note", "jsonpath:order.note | extractAsXML"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterExpression("count", "xpath:count(//item)"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		expression string
+		lintErr    bool
+		wantKind   ValueKind
+		compileErr string
+	}{
+		{name: "reference", expression: "@count", wantKind: KindNumber},
+		{name: "query after conversion", expression: "@note | xpath:/note/to | toString", wantKind: KindString},
+		{name: "pipe output", expression: "@count | toString", wantKind: KindString},
+		{name: "unknown", expression: "@missing | trim", lintErr: true, compileErr: "no expression is registered as 'missing'"},
+		{name: "type error through reference", expression: "@count | substring(1)", compileErr: "substring"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lintErr bool
+			for _, w := range engine.Lint(tt.expression) {
+				lintErr = lintErr || w.Severity == SeverityError
+			}
+			if lintErr != tt.lintErr {
+				t.Errorf("Lint: got errors %v, want %v (%v)", lintErr, tt.lintErr, engine.Lint(tt.expression))
+			}
+			compiled, err := engine.Compile(tt.expression)
+			if tt.compileErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.compileErr) {
+					t.Fatalf("Compile: got %v, want error containing %q", err, tt.compileErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if compiled.ResultKind() != tt.wantKind {
+				t.Errorf("ResultKind: got %v, want %v", compiled.ResultKind(), tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestNamedExpressionsWithVars(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterExpression("tenantLimit", "xpath://tenant[@id=$tenant]/limit"); err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext([]byte(`<tenants><tenant id="acme"><limit>10</limit></tenant></tenants>`), "application/xml", engine)
+	result, err := msgCtx.EvaluateExpressionWithVars("@tenantLimit | toNumber", map[string]interface{}{"tenant": "acme"})
+	if err != nil || result.Value != float64(10) {
+		t.Errorf("got %v, %v", result.Value, err)
+	}
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// NDJSONPayload handles newline-delimited JSON: one JSON value per line.
+// Blank lines are ignored. Queries ("ndjson:" expressions) are:
+//
+//	count               the number of records
+//	record(i)           the record at zero-based index i
+//	record(i).path      a gjson path within that record
+//	map(path)           path evaluated on every record, as an array (null where missing)
+//	path                any other gjson path, applied to the array of all records
+//
+// The records are also exposed as a JSON array, so jsonpath expressions work too.
+type NDJSONPayload struct {
+	rawContent  []byte
+	records     []gjson.Result
+	jsonResult  gjson.Result // All records as one array
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// NewNDJSONPayload splits content into records, validating each line.
+func NewNDJSONPayload(content []byte) (*NDJSONPayload, error) {
+	var records []gjson.Result
+	var array bytes.Buffer
+	array.WriteByte('[')
+	for lineNumber, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !gjson.ValidBytes(line) {
+			return nil, &ErrEvaluationFailed{Reason: fmt.Sprintf("invalid JSON record on line %d", lineNumber+1)}
+		}
+		if len(records) > 0 {
+			array.WriteByte(',')
+		}
+		array.Write(line)
+		records = append(records, gjson.ParseBytes(line))
+	}
+	array.WriteByte(']')
+	return &NDJSONPayload{
+		rawContent:  content,
+		records:     records,
+		jsonResult:  gjson.ParseBytes(array.Bytes()),
+		contentType: "application/x-ndjson",
+	}, nil
+}
+
+func (np *NDJSONPayload) GetRawBytes() []byte {
+	return np.rawContent
+}
+
+func (np *NDJSONPayload) GetContentType() string {
+	return np.contentType
+}
+
+// Len returns the number of records.
+func (np *NDJSONPayload) Len() int {
+	return len(np.records)
+}
+
+// Query evaluates an NDJSON query (see NDJSONPayload).
+func (np *NDJSONPayload) Query(expression string) (QueryResult, error) {
+	expression = strings.TrimSpace(expression)
+	switch {
+	case expression == "count":
+		return QueryResult{Value: float64(len(np.records)), Type: NumberResult}, nil
+	case strings.HasPrefix(expression, "record("):
+		end := strings.IndexByte(expression, ')')
+		if end < 0 {
+			return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "unterminated record("}
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(expression[len("record("):end]))
+		if err != nil || index < 0 {
+			return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "invalid record index"}
+		}
+		if index >= len(np.records) {
+			return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("record %d not found: %d record(s)", index, len(np.records)), InnerError: ErrNoMatch}
+		}
+		rest := expression[end+1:]
+		if rest == "" {
+			return convertGJSONResult(np.records[index], expression)
+		}
+		if !strings.HasPrefix(rest, ".") {
+			return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("unexpected '%s' after record(%d)", rest, index)}
+		}
+		return convertGJSONResult(np.records[index].Get(rest[1:]), expression)
+	case strings.HasPrefix(expression, "map(") && strings.HasSuffix(expression, ")"):
+		path := strings.TrimSpace(expression[len("map(") : len(expression)-1])
+		values := make([]interface{}, len(np.records))
+		for i, record := range np.records {
+			values[i] = record.Get(path).Value() // Nil when missing
+		}
+		return QueryResult{Value: values, Type: ArrayResult}, nil
+	}
+	return queryJSONView(np.jsonResult, expression)
+}
+
+func (np *NDJSONPayload) jsonView() gjson.Result {
+	return np.jsonResult
+}
+
+func (np *NDJSONPayload) AsString() (string, error) {
+	return string(np.rawContent), nil
+}
+
+func (np *NDJSONPayload) GetUnderlying() interface{} {
+	return np.records
+}
@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNDJSONPayloadQuery(t *testing.T) {
+	const events = `{"id": 1, "status": "ok", "user": {"name": "ann"}}
+
+{"id": 2, "status": "failed"}
+{"id": 3, "status": "failed", "user": {"name": "bob"}}` + "\r\n"
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "count", expression: "ndjson:count", want: float64(3)},
+		{name: "record", expression: "ndjson:record(1)", want: map[string]interface{}{"id": float64(2), "status": "failed"}},
+		{name: "record field", expression: "ndjson:record(2).user.name", want: "bob"},
+		{name: "map keeps positions", expression: "ndjson:map(user.name)", want: []interface{}{"ann", nil, "bob"}},
+		{name: "gjson over all records", expression: `ndjson:#(status=="failed")#.id`, want: []interface{}{float64(2), float64(3)}},
+		{name: "jsonpath view", expression: "jsonpath:#.id", want: []interface{}{float64(1), float64(2), float64(3)}},
+		{name: "record out of range", expression: "ndjson:record(3)", wantErr: true},
+		{name: "invalid record index", expression: "ndjson:record(x)", wantErr: true},
+		{name: "missing field in record", expression: "ndjson:record(1).user", wantErr: true},
+		{name: "garbage after record", expression: "ndjson:record(0)x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(events), "application/x-ndjson", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNDJSONPayloadInvalid(t *testing.T) {
+	_, err := NewNDJSONPayload([]byte("{\"a\": 1}\n{\"b\": \n"))
+	if err == nil || err.Error() != "evaluation failed for expression '': invalid JSON record on line 2" {
+		t.Fatalf("got %v", err)
+	}
+}
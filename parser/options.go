@@ -0,0 +1,80 @@
+package parser
+
+import "time"
+
+// Option configures an ExpressionEngine created by NewEngine. Each option
+// has the effect of the corresponding Set method.
+type Option func(*ExpressionEngine)
+
+// WithStrictMode controls whether queries that match nothing fail or yield
+// an AbsentResult (see SetStrictMode).
+func WithStrictMode(strict bool) Option {
+	return func(ee *ExpressionEngine) { ee.SetStrictMode(strict) }
+}
+
+// WithCache sets how many expressions the engine keeps parsed (see
+// SetExpressionCacheSize).
+func WithCache(size int) Option {
+	return func(ee *ExpressionEngine) { ee.SetExpressionCacheSize(size) }
+}
+
+// WithNamespaces binds namespace prefixes in XPath expressions (see
+// SetXPathNamespaces).
+func WithNamespaces(namespaces map[string]string) Option {
+	return func(ee *ExpressionEngine) { ee.SetXPathNamespaces(namespaces) }
+}
+
+// WithLimits sets the resource limits of evaluations (see SetLimits).
+func WithLimits(limits EngineLimits) Option {
+	return func(ee *ExpressionEngine) { ee.SetLimits(limits) }
+}
+
+// WithEvaluationTimeout limits the time of each evaluation (see
+// SetEvaluationTimeout).
+func WithEvaluationTimeout(timeout time.Duration) Option {
+	return func(ee *ExpressionEngine) { ee.SetEvaluationTimeout(timeout) }
+}
+
+// WithStageTimeout limits the time of each pipeline stage (see
+// SetStageTimeout).
+func WithStageTimeout(timeout time.Duration) Option {
+	return func(ee *ExpressionEngine) { ee.SetStageTimeout(timeout) }
+}
+
+// WithContentSniffing controls how payloads without a specific content type
+// are parsed (see SetContentSniffing).
+func WithContentSniffing(enabled bool) Option {
+	return func(ee *ExpressionEngine) { ee.SetContentSniffing(enabled) }
+}
+
+// WithXMLOptions sets how XML payloads are parsed (see SetXMLOptions).
+func WithXMLOptions(options XMLOptions) Option {
+	return func(ee *ExpressionEngine) { ee.SetXMLOptions(options) }
+}
+
+// WithKeyConvention converts the keys of jsonpath and yamlpath expressions
+// (see SetKeyConvention).
+func WithKeyConvention(convention KeyConvention) Option {
+	return func(ee *ExpressionEngine) { ee.SetKeyConvention(convention) }
+}
+
+// WithMetricsSink reports the measurements of the engine to sink (see
+// SetMetricsSink).
+func WithMetricsSink(sink MetricsSink) Option {
+	return func(ee *ExpressionEngine) { ee.SetMetricsSink(sink) }
+}
+
+// WithLogger makes the engine log diagnostic messages to logger (see
+// SetLogger).
+func WithLogger(logger Logger) Option {
+	return func(ee *ExpressionEngine) { ee.SetLogger(logger) }
+}
+
+// MessageOption configures a MessageContext created by NewMessageContext.
+type MessageOption func(*MessageContext)
+
+// WithContentEncoding marks the payload as compressed with the HTTP
+// Content-Encoding contentEncoding (see NewEncodedMessageContext).
+func WithContentEncoding(contentEncoding string) MessageOption {
+	return func(mc *MessageContext) { mc.ContentEncoding = contentEncoding }
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+func TestEngineOptions(t *testing.T) {
+	engine := NewEngine(
+		WithCache(0),
+		WithNamespaces(map[string]string{"o": "urn:orders"}),
+		WithLimits(EngineLimits{MaxStages: 2}),
+	)
+	if size := engine.expressionCache.len(); size != 0 {
+		t.Errorf("cache holds %d entries, want 0", size)
+	}
+	if got := engine.Limits().MaxStages; got != 2 {
+		t.Errorf("MaxStages = %d, want 2", got)
+	}
+
+	msgCtx := NewMessageContext([]byte(`<order xmlns="urn:orders"><id>7</id></order>`), "application/xml", engine)
+	result, err := msgCtx.EvaluateExpression("xpath:/o:order/o:id/text()")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Value != "7" {
+		t.Errorf("got %v, want 7", result.Value)
+	}
+	if engine.expressionCache.len() != 0 {
+		t.Errorf("WithCache(0) did not disable the cache")
+	}
+
+	var limitErr *ErrLimitExceeded
+	if _, err := msgCtx.EvaluateExpression("xpath:/o:order/o:id/text() | trim | upper"); !errors.As(err, &limitErr) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+}
+
+func TestXPathNamespacesAreCachedApart(t *testing.T) {
+	engine := NewEngine()
+	msgCtx := NewMessageContext([]byte(`<a:r xmlns:a="urn:a" xmlns:b="urn:b"><a:v>A</a:v><b:v>B</b:v></a:r>`), "application/xml", engine)
+	for _, tt := range []struct{ uri, want string }{{"urn:a", "A"}, {"urn:b", "B"}} {
+		engine.SetXPathNamespaces(map[string]string{"x": "urn:a", "y": tt.uri})
+		result, err := msgCtx.EvaluateExpression("xpath:/x:r/y:v/text()")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Value != tt.want {
+			t.Errorf("with y = %s got %v, want %s", tt.uri, result.Value, tt.want)
+		}
+	}
+}
+
+func TestMessageOptions(t *testing.T) {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write([]byte(`{"a": "b"}`))
+	w.Close()
+
+	msgCtx := NewMessageContext(compressed.Bytes(), "application/json", NewEngine(), WithContentEncoding("gzip"))
+	result, err := msgCtx.EvaluateExpression("jsonpath:a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Value != "b" {
+		t.Errorf("got %v, want b", result.Value)
+	}
+}
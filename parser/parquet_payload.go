@@ -0,0 +1,888 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/tidwall/gjson"
+)
+
+// parquetMagic starts and ends every Parquet file.
+const parquetMagic = "PAR1"
+
+// Parquet physical types.
+const (
+	parquetBoolean = iota
+	parquetInt32
+	parquetInt64
+	parquetInt96
+	parquetFloat
+	parquetDouble
+	parquetByteArray
+	parquetFixedLenByteArray
+)
+
+var parquetTypeNames = []string{"BOOLEAN", "INT32", "INT64", "INT96", "FLOAT", "DOUBLE", "BYTE_ARRAY", "FIXED_LEN_BYTE_ARRAY"}
+
+// ParquetPayload handles Apache Parquet files. The footer (schema and row
+// groups) is read when the payload is created; column chunks are decoded the
+// first time a query needs them and then cached. Queries ("parquet:"
+// expressions) narrow the rows step by step and then select values:
+//
+//	rowGroup(i)       the rows of row group i
+//	rows(a..b)        rows a (inclusive) to b (exclusive) of the current rows; b
+//	                  may be omitted, and is clipped to the rows available
+//	rows(i)           the single row i
+//	col("name")       the values of a column (dotted path for nested groups)
+//	count             the number of rows
+//	columns, schema   the column names / the columns with their types
+//	rowGroups         the row groups, with their first row and row count
+//
+// Without a column, rows are objects keyed by column name. As in md:
+// queries, anything after the steps is a gjson path applied to the selection,
+// e.g. `rows(0..10).col("amount").#` or `rows(0..100).#(amount>500)#.id`.
+//
+// Flat and nested non-repeated columns are supported, with the PLAIN and
+// dictionary encodings and the uncompressed, Snappy and gzip codecs. Strings,
+// dates, timestamps and integer decimals are converted according to their
+// logical type; other byte arrays are base64-encoded.
+type ParquetPayload struct {
+	rawContent  []byte
+	contentType string
+	columns     []parquetColumn
+	rowGroups   []parquetRowGroup
+	numRows     int64
+
+	mu     sync.Mutex
+	chunks map[[2]int][]interface{} // Decoded values by row group and column
+}
+
+// parquetColumn is a leaf of the schema.
+type parquetColumn struct {
+	path       string
+	physical   int64
+	typeLength int
+	maxDef     int // Number of optional fields along the path
+	maxRep     int // Number of repeated fields along the path
+	converted  int64
+	logical    thriftFields
+	scale      int
+}
+
+type parquetRowGroup struct {
+	firstRow, numRows int64
+	chunks            []thriftFields // ColumnMetaData, in column order
+}
+
+// NewParquetPayload reads the footer of a Parquet file.
+func NewParquetPayload(content []byte) (*ParquetPayload, error) {
+	if len(content) < 12 || string(content[:4]) != parquetMagic || string(content[len(content)-4:]) != parquetMagic {
+		return nil, fmt.Errorf("invalid Parquet file: missing %s magic", parquetMagic)
+	}
+	footerLength := int64(binary.LittleEndian.Uint32(content[len(content)-8:]))
+	if footerLength > int64(len(content)-12) {
+		return nil, fmt.Errorf("invalid Parquet file: footer length %d exceeds file size", footerLength)
+	}
+	footer, _, err := readThriftStruct(content[int64(len(content)-8)-footerLength : len(content)-8])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Parquet footer: %w", err)
+	}
+
+	pp := &ParquetPayload{rawContent: content, contentType: "application/vnd.apache.parquet", chunks: make(map[[2]int][]interface{})}
+	pp.numRows, _ = footer.int(3)
+	schema := footer.list(2)
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("invalid Parquet footer: empty schema")
+	}
+	root, _ := schema[0].(thriftFields)
+	numChildren, _ := root.int(5)
+	next := 1
+	for i := int64(0); i < numChildren; i++ {
+		if next, err = pp.addSchemaElement(schema, next, "", 0, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	var firstRow int64
+	for i, value := range footer.list(4) {
+		rg, _ := value.(thriftFields)
+		group := parquetRowGroup{firstRow: firstRow}
+		group.numRows, _ = rg.int(3)
+		for _, chunk := range rg.list(1) {
+			cc, _ := chunk.(thriftFields)
+			group.chunks = append(group.chunks, cc.strct(3))
+		}
+		if len(group.chunks) != len(pp.columns) || group.numRows < 0 {
+			return nil, fmt.Errorf("invalid Parquet footer: row group %d has %d column chunk(s) for %d column(s)", i, len(group.chunks), len(pp.columns))
+		}
+		pp.rowGroups = append(pp.rowGroups, group)
+		firstRow += group.numRows
+	}
+	if firstRow != pp.numRows {
+		return nil, fmt.Errorf("invalid Parquet footer: row groups hold %d row(s), file declares %d", firstRow, pp.numRows)
+	}
+	return pp, nil
+}
+
+// addSchemaElement adds the leaf columns of the schema element at index and
+// returns the index of the next element.
+func (pp *ParquetPayload) addSchemaElement(schema []interface{}, index int, parent string, maxDef, maxRep int) (int, error) {
+	if index >= len(schema) {
+		return 0, fmt.Errorf("invalid Parquet schema: missing element %d", index)
+	}
+	element, _ := schema[index].(thriftFields)
+	path := string(element.bytes(4))
+	if parent != "" {
+		path = parent + "." + path
+	}
+	switch repetition, _ := element.int(3); repetition {
+	case 1: // OPTIONAL
+		maxDef++
+	case 2: // REPEATED
+		maxDef++
+		maxRep++
+	}
+
+	numChildren, isGroup := element.int(5)
+	if isGroup && numChildren > 0 {
+		next := index + 1
+		var err error
+		for i := int64(0); i < numChildren; i++ {
+			if next, err = pp.addSchemaElement(schema, next, path, maxDef, maxRep); err != nil {
+				return 0, err
+			}
+		}
+		return next, nil
+	}
+
+	column := parquetColumn{path: path, maxDef: maxDef, maxRep: maxRep, converted: -1, logical: element.strct(10)}
+	var ok bool
+	if column.physical, ok = element.int(1); !ok || column.physical < 0 || column.physical >= int64(len(parquetTypeNames)) {
+		return 0, fmt.Errorf("invalid Parquet schema: column %s has no valid type", path)
+	}
+	if converted, ok := element.int(6); ok {
+		column.converted = converted
+	}
+	typeLength, _ := element.int(2)
+	scale, _ := element.int(7)
+	if decimal := column.logical.strct(5); decimal != nil {
+		scale, _ = decimal.int(1)
+	}
+	column.typeLength, column.scale = int(typeLength), int(scale)
+	pp.columns = append(pp.columns, column)
+	return index + 1, nil
+}
+
+func (pp *ParquetPayload) GetRawBytes() []byte {
+	return pp.rawContent
+}
+
+func (pp *ParquetPayload) GetContentType() string {
+	return pp.contentType
+}
+
+// Query evaluates a Parquet query (see ParquetPayload).
+func (pp *ParquetPayload) Query(expression string) (QueryResult, error) {
+	steps, rest, err := parseParquetQuery(expression)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "invalid Parquet query", InnerError: err}
+	}
+
+	first, last := int64(0), pp.numRows
+	single := false // Whether rows(i) selected one row
+	var selected interface{}
+	selectedRows := true
+	for i, step := range steps {
+		switch step.name {
+		case "rowGroup":
+			if i > 0 {
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "rowGroup must be the first step"}
+			}
+			if step.start >= int64(len(pp.rowGroups)) {
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("row group %d out of range (%d row group(s))", step.start, len(pp.rowGroups))}
+			}
+			rg := pp.rowGroups[step.start]
+			first, last = rg.firstRow, rg.firstRow+rg.numRows
+		case "rows":
+			if single {
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "rows cannot follow the selection of a single row"}
+			}
+			size := last - first
+			switch {
+			case step.single:
+				if step.start >= size {
+					return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("row %d out of range (%d row(s))", step.start, size)}
+				}
+				first, last, single = first+step.start, first+step.start+1, true
+			case step.start > size:
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("row %d out of range (%d row(s))", step.start, size)}
+			default:
+				if step.end >= 0 && step.end < size {
+					last = first + step.end
+				}
+				first += step.start
+			}
+		case "col":
+			column, ok := pp.columnIndex(step.arg)
+			if !ok {
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("column '%s' not found", step.arg), InnerError: ErrNoMatch}
+			}
+			values, err := pp.columnValues(column, first, last)
+			if err != nil {
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("failed to read column '%s'", step.arg), InnerError: err}
+			}
+			selected, selectedRows = values, false
+			if single {
+				selected = values[0]
+			}
+		case "count":
+			selected, selectedRows = last-first, false
+		case "columns":
+			selected, selectedRows = pp.Columns(), false
+		case "schema":
+			fields := make([]map[string]interface{}, len(pp.columns))
+			for i, c := range pp.columns {
+				fields[i] = map[string]interface{}{"name": c.path, "type": c.typeName(), "nullable": c.maxDef > 0, "repeated": c.maxRep > 0}
+			}
+			selected, selectedRows = fields, false
+		case "rowGroups":
+			groups := make([]map[string]int64, len(pp.rowGroups))
+			for i, rg := range pp.rowGroups {
+				groups[i] = map[string]int64{"firstRow": rg.firstRow, "rows": rg.numRows}
+			}
+			selected, selectedRows = groups, false
+		}
+	}
+	if selectedRows {
+		rows, err := pp.rows(first, last)
+		if err != nil {
+			return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "failed to read rows", InnerError: err}
+		}
+		selected = rows
+		if single {
+			selected = rows[0]
+		}
+	}
+
+	view, err := json.Marshal(selected)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "failed to convert Parquet selection", InnerError: err}
+	}
+	if rest == "" {
+		rest = "@this"
+	}
+	return convertGJSONResult(gjson.GetBytes(view, rest), expression)
+}
+
+// AsString returns all rows as a JSON array, since the file is not text.
+func (pp *ParquetPayload) AsString() (string, error) {
+	result, err := pp.Query("")
+	if err != nil {
+		return "", err
+	}
+	view, err := json.Marshal(result.Value)
+	return string(view), err
+}
+
+func (pp *ParquetPayload) GetUnderlying() interface{} {
+	return pp.rawContent
+}
+
+// NumRows returns the number of rows in the file.
+func (pp *ParquetPayload) NumRows() int64 {
+	return pp.numRows
+}
+
+// Columns returns the column names, with nested fields as dotted paths.
+func (pp *ParquetPayload) Columns() []string {
+	names := make([]string, len(pp.columns))
+	for i, c := range pp.columns {
+		names[i] = c.path
+	}
+	return names
+}
+
+func (pp *ParquetPayload) columnIndex(name string) (int, bool) {
+	for i, c := range pp.columns {
+		if c.path == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// rows returns rows first to last as objects keyed by column name.
+func (pp *ParquetPayload) rows(first, last int64) ([]map[string]interface{}, error) {
+	rows := make([]map[string]interface{}, last-first)
+	for i := range rows {
+		rows[i] = make(map[string]interface{}, len(pp.columns))
+	}
+	for column, c := range pp.columns {
+		values, err := pp.columnValues(column, first, last)
+		if err != nil {
+			return nil, fmt.Errorf("column '%s': %w", c.path, err)
+		}
+		for i, v := range values {
+			rows[i][c.path] = v
+		}
+	}
+	return rows, nil
+}
+
+// columnValues returns the values of a column for rows first to last,
+// decoding only the row groups they span.
+func (pp *ParquetPayload) columnValues(column int, first, last int64) ([]interface{}, error) {
+	values := make([]interface{}, 0, last-first)
+	for i, rg := range pp.rowGroups {
+		if rg.firstRow+rg.numRows <= first || rg.firstRow >= last {
+			continue
+		}
+		chunk, err := pp.chunkValues(i, column)
+		if err != nil {
+			return nil, err
+		}
+		from, to := max(first-rg.firstRow, 0), min(last, rg.firstRow+rg.numRows)-rg.firstRow
+		values = append(values, chunk[from:to]...)
+	}
+	return values, nil
+}
+
+// chunkValues decodes (once) the column chunk of a row group.
+func (pp *ParquetPayload) chunkValues(rowGroup, column int) ([]interface{}, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	key := [2]int{rowGroup, column}
+	if values, ok := pp.chunks[key]; ok {
+		return values, nil
+	}
+	rg := pp.rowGroups[rowGroup]
+	// Columns are not repeated, so a chunk has a value for every row
+	if numValues, _ := rg.chunks[column].int(5); numValues != rg.numRows {
+		return nil, fmt.Errorf("row group %d: column chunk declares %d value(s) for %d row(s)", rowGroup, numValues, rg.numRows)
+	}
+	values, err := pp.readChunk(pp.columns[column], rg.chunks[column])
+	if err != nil {
+		return nil, fmt.Errorf("row group %d: %w", rowGroup, err)
+	}
+	if int64(len(values)) != rg.numRows {
+		return nil, fmt.Errorf("row group %d: column chunk has %d value(s) for %d row(s)", rowGroup, len(values), rg.numRows)
+	}
+	pp.chunks[key] = values
+	return values, nil
+}
+
+// readChunk decodes every page of a column chunk.
+func (pp *ParquetPayload) readChunk(c parquetColumn, meta thriftFields) ([]interface{}, error) {
+	if c.maxRep > 0 {
+		return nil, fmt.Errorf("repeated column '%s' is not supported", c.path)
+	}
+	codec, _ := meta.int(4)
+	numValues, _ := meta.int(5)
+	size, _ := meta.int(7)
+	offset, _ := meta.int(9)
+	if dictOffset, ok := meta.int(11); ok && dictOffset > 0 && dictOffset < offset {
+		offset = dictOffset
+	}
+	if offset < 4 || size < 0 || offset+size > int64(len(pp.rawContent)) {
+		return nil, fmt.Errorf("column chunk [%d,%d) out of bounds", offset, offset+size)
+	}
+	if numValues < 0 {
+		return nil, fmt.Errorf("invalid column chunk value count %d", numValues)
+	}
+	data := pp.rawContent[offset : offset+size]
+
+	var dictionary []interface{}
+	values := make([]interface{}, 0, min(numValues, int64(len(data))*8))
+	for int64(len(values)) < numValues {
+		header, n, err := readThriftStruct(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page header: %w", err)
+		}
+		data = data[n:]
+		pageSize, _ := header.int(3)
+		if pageSize < 0 || pageSize > int64(len(data)) {
+			return nil, fmt.Errorf("page of %d byte(s) exceeds the column chunk", pageSize)
+		}
+		page := data[:pageSize]
+		data = data[pageSize:]
+
+		switch pageType, _ := header.int(1); pageType {
+		case 2: // DICTIONARY_PAGE
+			dph := header.strct(7)
+			count, _ := dph.int(1)
+			if count < 0 {
+				return nil, fmt.Errorf("invalid dictionary page value count %d", count)
+			}
+			if page, err = decompressParquet(codec, page); err != nil {
+				return nil, err
+			}
+			if dictionary, err = decodeParquetPlain(page, c, count); err != nil {
+				return nil, fmt.Errorf("invalid dictionary page: %w", err)
+			}
+		case 0: // DATA_PAGE
+			dph := header.strct(5)
+			count, _ := dph.int(1)
+			encoding, _ := dph.int(2)
+			if err := checkParquetPageCount(count, numValues-int64(len(values))); err != nil {
+				return nil, err
+			}
+			if page, err = decompressParquet(codec, page); err != nil {
+				return nil, err
+			}
+			var defs []int
+			if c.maxDef > 0 {
+				if len(page) < 4 {
+					return nil, fmt.Errorf("truncated definition levels")
+				}
+				length := binary.LittleEndian.Uint32(page)
+				if uint64(length) > uint64(len(page)-4) {
+					return nil, fmt.Errorf("truncated definition levels")
+				}
+				if defs, err = decodeParquetRLE(page[4:4+length], bits.Len(uint(c.maxDef)), count); err != nil {
+					return nil, fmt.Errorf("invalid definition levels: %w", err)
+				}
+				page = page[4+length:]
+			}
+			if values, err = appendParquetValues(values, page, c, encoding, count, defs, dictionary); err != nil {
+				return nil, err
+			}
+		case 3: // DATA_PAGE_V2
+			dph := header.strct(8)
+			count, _ := dph.int(1)
+			encoding, _ := dph.int(4)
+			if err := checkParquetPageCount(count, numValues-int64(len(values))); err != nil {
+				return nil, err
+			}
+			defLength, _ := dph.int(5)
+			repLength, _ := dph.int(6)
+			if defLength < 0 || repLength < 0 || defLength+repLength > int64(len(page)) {
+				return nil, fmt.Errorf("truncated levels")
+			}
+			var defs []int
+			if c.maxDef > 0 {
+				if defs, err = decodeParquetRLE(page[repLength:repLength+defLength], bits.Len(uint(c.maxDef)), count); err != nil {
+					return nil, fmt.Errorf("invalid definition levels: %w", err)
+				}
+			}
+			page = page[repLength+defLength:]
+			if compressed, ok := dph[7].(bool); !ok || compressed {
+				if page, err = decompressParquet(codec, page); err != nil {
+					return nil, err
+				}
+			}
+			if values, err = appendParquetValues(values, page, c, encoding, count, defs, dictionary); err != nil {
+				return nil, err
+			}
+		}
+		if len(data) == 0 && int64(len(values)) < numValues {
+			return nil, fmt.Errorf("column chunk ends after %d of %d value(s)", len(values), numValues)
+		}
+	}
+	return values, nil
+}
+
+// checkParquetPageCount checks the value count of a data page against the
+// values its column chunk has left, so that a corrupt header cannot make
+// the page decoders produce more values than the chunk holds.
+func checkParquetPageCount(count, left int64) error {
+	if count < 0 || count > left {
+		return fmt.Errorf("invalid data page value count %d, %d value(s) left in the column chunk", count, left)
+	}
+	return nil
+}
+
+// appendParquetValues decodes the values of a data page; defs are its
+// definition levels (nil for required columns).
+func appendParquetValues(values []interface{}, page []byte, c parquetColumn, encoding, count int64, defs []int, dictionary []interface{}) ([]interface{}, error) {
+	present := count
+	if defs != nil {
+		present = 0
+		for _, d := range defs {
+			if d == c.maxDef {
+				present++
+			}
+		}
+	}
+
+	var decoded []interface{}
+	var err error
+	switch encoding {
+	case 0: // PLAIN
+		decoded, err = decodeParquetPlain(page, c, present)
+	case 2, 8: // PLAIN_DICTIONARY, RLE_DICTIONARY
+		if dictionary == nil {
+			return nil, fmt.Errorf("dictionary-encoded page without a dictionary")
+		}
+		if len(page) == 0 {
+			if present > 0 {
+				return nil, fmt.Errorf("truncated dictionary indices")
+			}
+			break
+		}
+		var indices []int
+		if indices, err = decodeParquetRLE(page[1:], int(page[0]), present); err != nil {
+			return nil, fmt.Errorf("invalid dictionary indices: %w", err)
+		}
+		decoded = make([]interface{}, len(indices))
+		for i, index := range indices {
+			if index < 0 || index >= len(dictionary) {
+				return nil, fmt.Errorf("dictionary index %d out of range", index)
+			}
+			decoded[i] = dictionary[index]
+		}
+	case 3: // RLE, used for booleans
+		if c.physical != parquetBoolean || len(page) < 4 {
+			return nil, fmt.Errorf("unsupported RLE page")
+		}
+		var levels []int
+		if levels, err = decodeParquetRLE(page[4:], 1, present); err != nil {
+			return nil, err
+		}
+		decoded = make([]interface{}, len(levels))
+		for i, l := range levels {
+			decoded[i] = l == 1
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Parquet encoding %d", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	next := 0
+	for i := int64(0); i < count; i++ {
+		if defs != nil && defs[i] != c.maxDef {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, c.convert(decoded[next]))
+		next++
+	}
+	return values, nil
+}
+
+func decompressParquet(codec int64, data []byte) ([]byte, error) {
+	switch codec {
+	case 0: // UNCOMPRESSED
+		return data, nil
+	case 1: // SNAPPY
+		// The decoded length comes from the header of the block, and Snappy
+		// elements expand at most 64 bytes from 3
+		if n, err := snappy.DecodedLen(data); err != nil || n > len(data)*22 {
+			return nil, fmt.Errorf("invalid Snappy page: decoded length exceeds the page")
+		}
+		out, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Snappy page: %w", err)
+		}
+		return out, nil
+	case 2: // GZIP
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip page: %w", err)
+		}
+		out, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip page: %w", err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported Parquet compression codec %d", codec)
+}
+
+// decodeParquetRLE decodes count values of the RLE/bit-packing hybrid encoding.
+// RLE runs let few bytes stand for many values, so count is not checked
+// against the length of data, but the values are only allocated as they are
+// decoded.
+func decodeParquetRLE(data []byte, bitWidth int, count int64) ([]int, error) {
+	if bitWidth < 0 || bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("invalid value count %d", count)
+	}
+	values := make([]int, 0, min(count, int64(len(data))*8+1))
+	byteWidth := (bitWidth + 7) / 8
+	for int64(len(values)) < count {
+		header, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("truncated RLE run")
+		}
+		data = data[n:]
+		if header&1 == 0 { // RLE run
+			if len(data) < byteWidth {
+				return nil, fmt.Errorf("truncated RLE run")
+			}
+			var v int
+			for i := 0; i < byteWidth; i++ {
+				v |= int(data[i]) << (8 * i)
+			}
+			data = data[byteWidth:]
+			for run := header >> 1; run > 0 && int64(len(values)) < count; run-- {
+				values = append(values, v)
+			}
+			continue
+		}
+		groups := header >> 1 // Bit-packed groups of 8 values, least significant bit first
+		if groups > uint64(len(data)) || int(groups)*bitWidth > len(data) {
+			return nil, fmt.Errorf("truncated bit-packed run")
+		}
+		packed := data[:int(groups)*bitWidth]
+		data = data[int(groups)*bitWidth:]
+		for i := 0; i < int(groups)*8 && int64(len(values)) < count; i++ {
+			var v int
+			for b := 0; b < bitWidth; b++ {
+				bit := i*bitWidth + b
+				v |= int(packed[bit/8]>>(bit%8)&1) << b
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// decodeParquetPlain decodes count PLAIN-encoded values of a column's physical
+// type. Every value takes at least a bit of data, so larger counts fail
+// before anything is allocated.
+func decodeParquetPlain(data []byte, c parquetColumn, count int64) ([]interface{}, error) {
+	width, fixed := map[int64]int{parquetInt32: 4, parquetInt64: 8, parquetInt96: 12, parquetFloat: 4, parquetDouble: 8, parquetFixedLenByteArray: c.typeLength}[c.physical]
+	switch {
+	case count < 0:
+		return nil, fmt.Errorf("invalid value count %d", count)
+	case fixed && width <= 0:
+		return nil, fmt.Errorf("invalid %s width %d", parquetTypeNames[c.physical], width)
+	case !fixed && c.physical != parquetBoolean && c.physical != parquetByteArray:
+		return nil, fmt.Errorf("unsupported Parquet physical type %d", c.physical)
+	case c.physical == parquetBoolean && count > int64(len(data))*8,
+		width > 0 && count > int64(len(data)/width),
+		c.physical == parquetByteArray && count > int64(len(data)/4):
+		return nil, fmt.Errorf("truncated %s values", parquetTypeNames[c.physical])
+	}
+
+	values := make([]interface{}, count)
+	for i := range values {
+		switch c.physical {
+		case parquetBoolean:
+			values[i] = data[i/8]>>(i%8)&1 == 1
+		case parquetInt32:
+			values[i] = int64(int32(binary.LittleEndian.Uint32(data[4*i:])))
+		case parquetInt64:
+			values[i] = int64(binary.LittleEndian.Uint64(data[8*i:]))
+		case parquetInt96: // Legacy timestamps: nanoseconds of the day, then the Julian day
+			nanos := int64(binary.LittleEndian.Uint64(data[12*i:]))
+			day := int64(int32(binary.LittleEndian.Uint32(data[12*i+8:])))
+			values[i] = time.Unix((day-2440588)*86400, nanos).UTC().Format(time.RFC3339Nano)
+		case parquetFloat:
+			values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])))
+		case parquetDouble:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+		case parquetByteArray:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated BYTE_ARRAY values")
+			}
+			length := binary.LittleEndian.Uint32(data)
+			if uint64(length) > uint64(len(data)-4) {
+				return nil, fmt.Errorf("truncated BYTE_ARRAY values")
+			}
+			values[i] = data[4 : 4+length]
+			data = data[4+length:]
+		case parquetFixedLenByteArray:
+			values[i] = data[width*i : width*(i+1)]
+		}
+	}
+	return values, nil
+}
+
+// Parquet converted types, and the logical type union fields, used by convert.
+const (
+	parquetConvertedUTF8            = 0
+	parquetConvertedEnum            = 4
+	parquetConvertedDecimal         = 5
+	parquetConvertedDate            = 6
+	parquetConvertedTimestampMillis = 9
+	parquetConvertedTimestampMicros = 10
+	parquetConvertedJSON            = 19
+
+	parquetLogicalString    = 1
+	parquetLogicalEnum      = 4
+	parquetLogicalDecimal   = 5
+	parquetLogicalDate      = 6
+	parquetLogicalTimestamp = 8
+	parquetLogicalJSON      = 12
+)
+
+// hasLogical reports whether the column has the logical type field id or the
+// equivalent converted type.
+func (c parquetColumn) hasLogical(field int16, converted ...int64) bool {
+	if _, ok := c.logical[field]; ok {
+		return true
+	}
+	for _, ct := range converted {
+		if c.converted == ct {
+			return true
+		}
+	}
+	return false
+}
+
+// timestampUnit returns the duration of one unit of a timestamp column, or 0.
+func (c parquetColumn) timestampUnit() time.Duration {
+	if ts := c.logical.strct(parquetLogicalTimestamp); ts != nil {
+		unit := ts.strct(2)
+		switch {
+		case unit.strct(1) != nil:
+			return time.Millisecond
+		case unit.strct(2) != nil:
+			return time.Microsecond
+		case unit.strct(3) != nil:
+			return time.Nanosecond
+		}
+	}
+	switch c.converted {
+	case parquetConvertedTimestampMillis:
+		return time.Millisecond
+	case parquetConvertedTimestampMicros:
+		return time.Microsecond
+	}
+	return 0
+}
+
+// convert turns a physical value into its logical value.
+func (c parquetColumn) convert(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if c.hasLogical(parquetLogicalString, parquetConvertedUTF8) || c.hasLogical(parquetLogicalEnum, parquetConvertedEnum) || c.hasLogical(parquetLogicalJSON, parquetConvertedJSON) {
+			return string(v)
+		}
+		return append([]byte(nil), v...) // Marshalled as base64
+	case int64:
+		switch {
+		case c.hasLogical(parquetLogicalDate, parquetConvertedDate):
+			return time.Unix(v*86400, 0).UTC().Format("2006-01-02")
+		case c.timestampUnit() != 0:
+			return time.Unix(0, 0).Add(time.Duration(v) * c.timestampUnit()).UTC().Format(time.RFC3339Nano)
+		case c.hasLogical(parquetLogicalDecimal, parquetConvertedDecimal):
+			return float64(v) / math.Pow10(c.scale)
+		}
+	}
+	return v
+}
+
+// typeName describes the column type for the schema query.
+func (c parquetColumn) typeName() string {
+	switch {
+	case c.hasLogical(parquetLogicalString, parquetConvertedUTF8):
+		return "string"
+	case c.hasLogical(parquetLogicalEnum, parquetConvertedEnum):
+		return "enum"
+	case c.hasLogical(parquetLogicalJSON, parquetConvertedJSON):
+		return "json"
+	case c.hasLogical(parquetLogicalDate, parquetConvertedDate):
+		return "date"
+	case c.timestampUnit() != 0:
+		return "timestamp"
+	case c.hasLogical(parquetLogicalDecimal, parquetConvertedDecimal):
+		return fmt.Sprintf("decimal(scale=%d)", c.scale)
+	}
+	return strings.ToLower(parquetTypeNames[c.physical])
+}
+
+// pqStep is one step of a Parquet query, e.g. rows(0..10) or col("amount").
+type pqStep struct {
+	name       string
+	arg        string // Column name of col
+	start, end int64  // Index of rowGroup and rows(i), range of rows(a..b); end is -1 when open
+	single     bool   // rows(i)
+}
+
+// parquetTerminalSteps select values; the rest of the query addresses them.
+var parquetTerminalSteps = map[string]bool{"col": true, "count": true, "columns": true, "schema": true, "rowGroups": true}
+
+// parseParquetQuery splits an expression into steps and the remaining gjson path.
+func parseParquetQuery(expression string) ([]pqStep, string, error) {
+	var steps []pqStep
+	rest := strings.TrimSpace(expression)
+	for rest != "" {
+		nameEnd := strings.IndexAny(rest, "(.")
+		if nameEnd < 0 {
+			nameEnd = len(rest)
+		}
+		step := pqStep{name: rest[:nameEnd]}
+		if step.name != "rowGroup" && step.name != "rows" && !parquetTerminalSteps[step.name] {
+			break // Remaining segments form the gjson path
+		}
+		rest = rest[nameEnd:]
+
+		takesArg := step.name == "rowGroup" || step.name == "rows" || step.name == "col"
+		if takesArg != strings.HasPrefix(rest, "(") {
+			return nil, "", fmt.Errorf("%s takes %s", step.name, map[bool]string{true: "an argument", false: "no argument"}[takesArg])
+		}
+		if takesArg {
+			end := strings.IndexByte(rest, ')')
+			if step.name == "col" && len(rest) > 1 && (rest[1] == '"' || rest[1] == '\'') {
+				end = strings.IndexByte(rest[2:], rest[1]) + 3 // Names may contain ')'
+				if end == 2 || end >= len(rest) || rest[end] != ')' {
+					return nil, "", fmt.Errorf("unterminated argument to col")
+				}
+			}
+			if end < 0 {
+				return nil, "", fmt.Errorf("unterminated argument to %s", step.name)
+			}
+			if err := step.parseArg(strings.TrimSpace(rest[1:end])); err != nil {
+				return nil, "", err
+			}
+			rest = rest[end+1:]
+		}
+		steps = append(steps, step)
+
+		if rest != "" && !strings.HasPrefix(rest, ".") {
+			return nil, "", fmt.Errorf("unexpected '%s' after %s", rest, step.name)
+		}
+		rest = strings.TrimPrefix(rest, ".")
+		if parquetTerminalSteps[step.name] {
+			break
+		}
+	}
+	return steps, rest, nil
+}
+
+func (s *pqStep) parseArg(arg string) error {
+	switch s.name {
+	case "col":
+		if len(arg) < 2 || (arg[0] != '"' && arg[0] != '\'') || arg[len(arg)-1] != arg[0] {
+			return fmt.Errorf("col takes a quoted column name")
+		}
+		s.arg = arg[1 : len(arg)-1]
+		return nil
+	case "rows":
+		from, to, isRange := strings.Cut(arg, "..")
+		start, err := strconv.ParseInt(strings.TrimSpace(from), 10, 64)
+		if err != nil || start < 0 {
+			return fmt.Errorf("invalid rows argument '%s'", arg)
+		}
+		s.start, s.end, s.single = start, -1, !isRange
+		if to = strings.TrimSpace(to); to != "" {
+			if s.end, err = strconv.ParseInt(to, 10, 64); err != nil || s.end < start {
+				return fmt.Errorf("invalid rows argument '%s'", arg)
+			}
+		}
+		return nil
+	}
+	index, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || index < 0 {
+		return fmt.Errorf("invalid row group '%s'", arg)
+	}
+	s.start = index
+	return nil
+}
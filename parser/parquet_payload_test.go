@@ -0,0 +1,491 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// The tests write their own Parquet files: tField/tStruct describe Thrift
+// structs for the compact protocol, and writeTestParquet lays out pages and
+// the footer the way parquet-mr and pyarrow do.
+
+type tField struct {
+	id    int16
+	value interface{} // bool, int32, int64, string, []int32, []string, tStruct or []tStruct
+}
+
+type tStruct []tField
+
+func encodeThrift(buf *bytes.Buffer, s tStruct) {
+	var last int16
+	for _, f := range s {
+		typ, body := thriftValue(f.value)
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			buf.WriteByte(byte(delta)<<4 | typ)
+		} else {
+			buf.WriteByte(typ)
+			buf.Write(zigzagTest(int64(f.id)))
+		}
+		buf.Write(body)
+		last = f.id
+	}
+	buf.WriteByte(thriftStop)
+}
+
+func zigzagTest(n int64) []byte {
+	return binary.AppendUvarint(nil, uint64(n<<1^n>>63))
+}
+
+func thriftValue(v interface{}) (byte, []byte) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return thriftTrue, nil
+		}
+		return thriftFalse, nil
+	case int32:
+		return thriftI32, zigzagTest(int64(v))
+	case int64:
+		return thriftI64, zigzagTest(v)
+	case string:
+		return thriftBinary, append(binary.AppendUvarint(nil, uint64(len(v))), v...)
+	case tStruct:
+		var buf bytes.Buffer
+		encodeThrift(&buf, v)
+		return thriftStruct, buf.Bytes()
+	case []int32, []string, []tStruct:
+		items := reflect.ValueOf(v)
+		var body []byte
+		var elemType byte = thriftI32
+		for i := 0; i < items.Len(); i++ {
+			typ, b := thriftValue(items.Index(i).Interface())
+			elemType, body = typ, append(body, b...)
+		}
+		if _, ok := v.([]tStruct); ok {
+			elemType = thriftStruct
+		}
+		if _, ok := v.([]string); ok {
+			elemType = thriftBinary
+		}
+		header := []byte{byte(min(items.Len(), 15))<<4 | elemType}
+		if items.Len() >= 15 {
+			header = binary.AppendUvarint(header, uint64(items.Len()))
+		}
+		return thriftList, append(header, body...)
+	}
+	panic("unsupported thrift value")
+}
+
+type testParquetColumn struct {
+	name       string
+	group      string // Optional group the column is nested in
+	physical   int32
+	optional   bool
+	converted  int32 // -1 for none
+	scale      int32
+	logical    tStruct
+	dictionary bool
+	values     []interface{} // One per row; nil is null
+}
+
+type testParquetOptions struct {
+	rowGroupSize int
+	codec        int32 // 0 uncompressed, 1 Snappy, 2 gzip
+	pageV2       bool
+}
+
+func compressTestPage(codec int32, data []byte) []byte {
+	switch codec {
+	case 1:
+		return snappy.Encode(nil, data)
+	case 2:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+	return data
+}
+
+func plainTestValues(physical int32, values []interface{}) []byte {
+	var buf []byte
+	for i, v := range values {
+		switch physical {
+		case parquetBoolean:
+			if i%8 == 0 {
+				buf = append(buf, 0)
+			}
+			if v.(bool) {
+				buf[len(buf)-1] |= 1 << (i % 8)
+			}
+		case parquetInt32:
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(v.(int32)))
+		case parquetInt64:
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(v.(int64)))
+		case parquetDouble:
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.(float64)))
+		case parquetByteArray:
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.(string))))
+			buf = append(buf, v.(string)...)
+		}
+	}
+	return buf
+}
+
+// rleTestLevels encodes definition levels (bit width 1 or 2) as RLE runs.
+func rleTestLevels(levels []int) []byte {
+	var buf []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		buf = append(buf, byte(levels[i]))
+		i = j
+	}
+	return buf
+}
+
+// bitPackedTestIndices encodes dictionary indices as one bit-packed run.
+func bitPackedTestIndices(indices []int, bitWidth int) []byte {
+	groups := (len(indices) + 7) / 8
+	buf := binary.AppendUvarint([]byte{byte(bitWidth)}, uint64(groups)<<1|1)
+	packed := make([]byte, groups*bitWidth)
+	for i, v := range indices {
+		for b := 0; b < bitWidth; b++ {
+			if v>>b&1 == 1 {
+				bit := i*bitWidth + b
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+	return append(buf, packed...)
+}
+
+func writeTestParquet(columns []testParquetColumn, options testParquetOptions) []byte {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+	numRows := len(columns[0].values)
+
+	schema := []tStruct{{{4, "schema"}, {5, int32(len(columns))}}}
+	for _, c := range columns {
+		if c.group != "" {
+			schema = append(schema, tStruct{{3, int32(1)}, {4, c.group}, {5, int32(1)}})
+		}
+		repetition := int32(0)
+		if c.optional {
+			repetition = 1
+		}
+		element := tStruct{{1, c.physical}, {3, repetition}, {4, c.name}}
+		if c.converted >= 0 {
+			element = append(element, tField{6, c.converted}, tField{7, c.scale})
+		}
+		if c.logical != nil {
+			element = append(element, tField{10, c.logical})
+		}
+		schema = append(schema, element)
+	}
+
+	var rowGroups []tStruct
+	for first := 0; first < numRows; first += options.rowGroupSize {
+		last := min(first+options.rowGroupSize, numRows)
+		var chunks []tStruct
+		for _, c := range columns {
+			start := int64(file.Len())
+			rows := c.values[first:last]
+			var levels []int
+			var present []interface{}
+			for _, v := range rows {
+				if v == nil {
+					levels = append(levels, 0)
+				} else {
+					levels = append(levels, 1)
+					present = append(present, v)
+				}
+			}
+			if c.group != "" && c.optional {
+				for i := range levels {
+					levels[i] *= 2
+				}
+			}
+
+			encoding := int32(0)
+			values := plainTestValues(c.physical, present)
+			var dictOffset int64
+			if c.dictionary {
+				var dict []interface{}
+				var indices []int
+				for _, v := range present {
+					index := len(dict)
+					for i, d := range dict {
+						if d == v {
+							index = i
+						}
+					}
+					if index == len(dict) {
+						dict = append(dict, v)
+					}
+					indices = append(indices, index)
+				}
+				page := compressTestPage(options.codec, plainTestValues(c.physical, dict))
+				encodeThrift(&file, tStruct{{1, int32(2)}, {2, int32(len(plainTestValues(c.physical, dict)))}, {3, int32(len(page))}, {7, tStruct{{1, int32(len(dict))}, {2, int32(0)}}}})
+				file.Write(page)
+				dictOffset = start
+				encoding = 8
+				values = bitPackedTestIndices(indices, 3)
+			}
+
+			dataOffset := int64(file.Len())
+			maxDef := 0
+			if c.optional {
+				maxDef++
+			}
+			if c.group != "" {
+				maxDef++
+			}
+			var defs []byte
+			if maxDef > 0 {
+				defs = rleTestLevels(levels)
+			}
+			if options.pageV2 {
+				page := append(append([]byte(nil), defs...), compressTestPage(options.codec, values)...)
+				encodeThrift(&file, tStruct{{1, int32(3)}, {2, int32(len(defs) + len(values))}, {3, int32(len(page))}, {8, tStruct{
+					{1, int32(len(rows))}, {2, int32(len(rows) - len(present))}, {3, int32(len(rows))}, {4, encoding},
+					{5, int32(len(defs))}, {6, int32(0)}, {7, options.codec != 0},
+				}}})
+				file.Write(page)
+			} else {
+				var raw []byte
+				if maxDef > 0 {
+					raw = binary.LittleEndian.AppendUint32(raw, uint32(len(defs)))
+					raw = append(raw, defs...)
+				}
+				raw = append(raw, values...)
+				page := compressTestPage(options.codec, raw)
+				encodeThrift(&file, tStruct{{1, int32(0)}, {2, int32(len(raw))}, {3, int32(len(page))}, {5, tStruct{
+					{1, int32(len(rows))}, {2, encoding}, {3, int32(3)}, {4, int32(3)},
+				}}})
+				file.Write(page)
+			}
+
+			path := []string{c.name}
+			if c.group != "" {
+				path = []string{c.group, c.name}
+			}
+			meta := tStruct{{1, c.physical}, {2, []int32{0, 3}}, {3, path}, {4, options.codec}, {5, int64(len(rows))},
+				{6, int64(file.Len()) - start}, {7, int64(file.Len()) - start}, {9, dataOffset}}
+			if dictOffset > 0 {
+				meta = append(meta, tField{11, dictOffset})
+			}
+			chunks = append(chunks, tStruct{{2, start}, {3, meta}})
+		}
+		rowGroups = append(rowGroups, tStruct{{1, chunks}, {2, int64(0)}, {3, int64(last - first)}})
+	}
+
+	var footer bytes.Buffer
+	encodeThrift(&footer, tStruct{{1, int32(1)}, {2, schema}, {3, int64(numRows)}, {4, rowGroups}})
+	file.Write(footer.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(footer.Len())))
+	file.WriteString(parquetMagic)
+	return file.Bytes()
+}
+
+func testOrdersParquet(options testParquetOptions) []byte {
+	microsUnit := tStruct{{8, tStruct{{1, true}, {2, tStruct{{2, tStruct{}}}}}}}
+	return writeTestParquet([]testParquetColumn{
+		{name: "id", physical: parquetInt64, converted: -1, values: []interface{}{int64(1), int64(2), int64(3), int64(4), int64(5)}},
+		{name: "status", physical: parquetByteArray, converted: 0, dictionary: true, values: []interface{}{"paid", "open", "paid", "void", "paid"}},
+		{name: "amount", physical: parquetInt64, optional: true, converted: 5, scale: 2, values: []interface{}{int64(1250), nil, int64(99), int64(50000), nil}},
+		{name: "rush", physical: parquetBoolean, converted: -1, values: []interface{}{true, false, false, true, false}},
+		{name: "placed", physical: parquetInt64, converted: -1, logical: microsUnit, values: []interface{}{int64(0), int64(1500000), int64(86400000000), int64(1), int64(2)}},
+		{name: "day", physical: parquetInt32, converted: 6, values: []interface{}{int32(0), int32(1), int32(19000), int32(-1), int32(2)}},
+		{name: "name", group: "customer", physical: parquetByteArray, optional: true, converted: 0, values: []interface{}{"Ada", nil, "Grace", "Linus", nil}},
+		{name: "score", physical: parquetDouble, converted: -1, values: []interface{}{0.5, 1.0, 1.5, 2.0, 2.5}},
+	}, options)
+}
+
+func TestParquetPayloadQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "count", expression: "parquet:count", want: float64(5)},
+		{name: "columns", expression: "parquet:columns", want: []interface{}{"id", "status", "amount", "rush", "placed", "day", "customer.name", "score"}},
+		{name: "column", expression: `parquet:col("id")`, want: []interface{}{float64(1), float64(2), float64(3), float64(4), float64(5)}},
+		{name: "row range column", expression: `parquet:rows(0..3).col("amount")`, want: []interface{}{12.5, nil, 0.99}},
+		{name: "open range", expression: `parquet:rows(3..).col("status")`, want: []interface{}{"void", "paid"}},
+		{name: "clipped range", expression: `parquet:rows(4..100).col("id")`, want: []interface{}{float64(5)}},
+		{name: "empty range at end", expression: `parquet:rows(5..).col("id")`, want: []interface{}{}},
+		{name: "single row", expression: "parquet:rows(2).customer\\.name", want: "Grace"},
+		{name: "single row column", expression: `parquet:rows(1).col("rush")`, want: false},
+		{name: "nested column", expression: `parquet:col("customer.name")`, want: []interface{}{"Ada", nil, "Grace", "Linus", nil}},
+		{name: "timestamp", expression: `parquet:rows(1).col("placed")`, want: "1970-01-01T00:00:01.5Z"},
+		{name: "date", expression: `parquet:col("day")`, want: []interface{}{"1970-01-01", "1970-01-02", "2022-01-08", "1969-12-31", "1970-01-03"}},
+		{name: "double", expression: `parquet:rows(2..4).col("score")`, want: []interface{}{1.5, 2.0}},
+		{name: "gjson on rows", expression: "parquet:rows(0..5).#(amount>100)#.id", want: []interface{}{float64(4)}},
+		{name: "gjson on column", expression: `parquet:col("status").#`, want: float64(5)},
+		{name: "row group", expression: `parquet:rowGroup(1).col("id")`, want: []interface{}{float64(3), float64(4)}},
+		{name: "row group rows", expression: `parquet:rowGroup(1).rows(1).id`, want: float64(4)},
+		{name: "row group count", expression: "parquet:rowGroup(2).count", want: float64(1)},
+		{name: "row groups", expression: "parquet:rowGroups.#.rows", want: []interface{}{float64(2), float64(2), float64(1)}},
+		{name: "schema", expression: "parquet:schema.#.type", want: []interface{}{"int64", "string", "decimal(scale=2)", "boolean", "timestamp", "date", "string", "double"}},
+		{name: "piped to json", expression: "parquet:rows(0) | extractAsJSON | jsonpath:status", want: "paid"},
+		{name: "unknown column", expression: `parquet:col("total")`, wantErr: "column 'total' not found"},
+		{name: "row out of range", expression: "parquet:rows(5)", wantErr: "row 5 out of range"},
+		{name: "row group out of range", expression: "parquet:rowGroup(3)", wantErr: "row group 3 out of range"},
+		{name: "row group not first", expression: "parquet:rows(0..2).rowGroup(0)", wantErr: "rowGroup must be the first step"},
+		{name: "unquoted column", expression: "parquet:col(id)", wantErr: "col takes a quoted column name"},
+		{name: "reversed range", expression: "parquet:rows(3..1)", wantErr: "invalid rows argument"},
+		{name: "count with argument", expression: "parquet:count(1)", wantErr: "count takes no argument"},
+	}
+	variants := []struct {
+		name    string
+		options testParquetOptions
+	}{
+		{name: "uncompressed v1", options: testParquetOptions{rowGroupSize: 2}},
+		{name: "snappy v1", options: testParquetOptions{rowGroupSize: 2, codec: 1}},
+		{name: "gzip v2", options: testParquetOptions{rowGroupSize: 2, codec: 2, pageV2: true}},
+		{name: "uncompressed v2", options: testParquetOptions{rowGroupSize: 2, pageV2: true}},
+	}
+	for _, variant := range variants {
+		content := testOrdersParquet(variant.options)
+		for _, tt := range tests {
+			t.Run(variant.name+"/"+tt.name, func(t *testing.T) {
+				result, err := NewMessageContext(content, "application/vnd.apache.parquet", NewEngine()).EvaluateExpression(tt.expression)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(result.Value, tt.want) {
+					t.Errorf("got %#v, want %#v", result.Value, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestNewParquetPayloadInvalid(t *testing.T) {
+	valid := testOrdersParquet(testParquetOptions{rowGroupSize: 2})
+	truncatedFooter := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(truncatedFooter[len(truncatedFooter)-8:], uint32(len(valid)))
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr string
+	}{
+		{name: "empty", content: nil, wantErr: "missing PAR1 magic"},
+		{name: "not parquet", content: []byte("id,amount\n1,2\n"), wantErr: "missing PAR1 magic"},
+		{name: "footer too long", content: truncatedFooter, wantErr: "footer length"},
+		{name: "garbage footer", content: []byte("PAR1\xff\xff\xff\xff\x04\x00\x00\x00PAR1"), wantErr: "invalid Parquet footer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewParquetPayload(tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParquetPayloadCorruptChunk(t *testing.T) {
+	content := testOrdersParquet(testParquetOptions{rowGroupSize: 5, codec: 1})
+	corrupt := append([]byte(nil), content...)
+	for i := 4; i < 40; i++ {
+		corrupt[i] = 0xff // Overwrites the first page header and data
+	}
+	payload, err := NewParquetPayload(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payload.Query(`col("id")`); err == nil {
+		t.Fatal("expected an error for a corrupt column chunk")
+	}
+	if result, err := payload.Query("count"); err != nil || result.Value != float64(5) {
+		t.Errorf("footer queries should still work: %v, %v", result.Value, err)
+	}
+}
+
+func TestParquetPayloadMutatedBytes(t *testing.T) {
+	// Every byte of each file is replaced in turn; values that come from page
+	// and chunk headers, such as value counts, must fail the query, never
+	// panic or allocate what the file cannot hold
+	variants := []testParquetOptions{
+		{rowGroupSize: 2},
+		{rowGroupSize: 5, codec: 1},
+		{rowGroupSize: 2, codec: 2, pageV2: true},
+		{rowGroupSize: 5, pageV2: true},
+	}
+	queries := []string{"rows(0..)", "schema"}
+	failed := 0
+	for _, options := range variants {
+		content := testOrdersParquet(options)
+		for i := 4; i < len(content)-8; i++ {
+			for _, b := range []byte{0x00, 0xff, content[i] ^ 0x10} {
+				mutated := append([]byte(nil), content...)
+				mutated[i] = b
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Fatalf("%+v: byte %d set to %#x: panic: %v", options, i, b, r)
+						}
+					}()
+					payload, err := NewParquetPayload(mutated)
+					if err != nil {
+						return
+					}
+					for _, query := range queries {
+						if _, err := payload.Query(query); err != nil {
+							failed++
+						}
+					}
+				}()
+			}
+		}
+	}
+	if failed == 0 {
+		t.Error("no mutation was reported as an error")
+	}
+}
+
+func TestParquetPayloadNegativeCount(t *testing.T) {
+	content := testOrdersParquet(testParquetOptions{rowGroupSize: 5})
+	payload, err := NewParquetPayload(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first page header of the id column: field 5, the data page
+	// header, starts with its value count, a zigzag varint
+	header, _, err := readThriftStruct(content[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := header.strct(5).int(1); count != 5 {
+		t.Fatalf("unexpected test file: page count %d", count)
+	}
+	at := bytes.Index(content[4:], []byte{0x15, 0x0a}) + 5 // Field 1 (i32) = 5
+	if at < 5 {
+		t.Fatal("page count not found")
+	}
+	content[at] = 0x09 // -5
+	if _, err := payload.Query(`col("id")`); err == nil || !strings.Contains(err.Error(), "invalid data page value count -5") {
+		t.Errorf("got %v, want an invalid count error", err)
+	}
+}
@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Parquet metadata (the file footer and page headers) is serialized with the
+// Thrift compact protocol. thriftReader decodes it generically: a struct
+// becomes a thriftStruct keyed by field ID, and callers pick the fields of the
+// parquet.thrift definitions they need. Maps are skipped, since no field used
+// here is one.
+
+// Thrift compact protocol type IDs.
+const (
+	thriftStop      = 0
+	thriftTrue      = 1
+	thriftFalse     = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+	thriftMaxDepth  = 64 // Nesting limit, against malicious metadata
+	thriftMaxLength = 1 << 28
+)
+
+var errThriftTruncated = errors.New("truncated thrift data")
+
+// thriftFields holds the fields of a decoded struct. Values are bool, int64
+// (all integer types), float64, []byte, []interface{} or thriftFields.
+type thriftFields map[int16]interface{}
+
+func (tf thriftFields) int(id int16) (int64, bool) {
+	v, ok := tf[id].(int64)
+	return v, ok
+}
+
+func (tf thriftFields) bytes(id int16) []byte {
+	v, _ := tf[id].([]byte)
+	return v
+}
+
+func (tf thriftFields) strct(id int16) thriftFields {
+	v, _ := tf[id].(thriftFields)
+	return v
+}
+
+func (tf thriftFields) list(id int16) []interface{} {
+	v, _ := tf[id].([]interface{})
+	return v
+}
+
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+// readThriftStruct decodes the struct at the start of buf and returns it with
+// the number of bytes it occupies.
+func readThriftStruct(buf []byte) (thriftFields, int, error) {
+	r := &thriftReader{buf: buf}
+	fields, err := r.readStruct(0)
+	return fields, r.pos, err
+}
+
+func (r *thriftReader) readByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errThriftTruncated
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) readUvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) readVarint() (int64, error) {
+	v, err := r.readUvarint()
+	return int64(v>>1) ^ -int64(v&1), err // Zigzag
+}
+
+func (r *thriftReader) readStruct(depth int) (thriftFields, error) {
+	if depth > thriftMaxDepth {
+		return nil, fmt.Errorf("thrift data nested deeper than %d levels", thriftMaxDepth)
+	}
+	fields := make(thriftFields)
+	var id int16
+	for {
+		header, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		typ := header & 0x0f
+		if typ == thriftStop {
+			return fields, nil
+		}
+		if delta := header >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			v, err := r.readVarint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		value, err := r.readValue(typ, depth)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			fields[id] = value
+		}
+	}
+}
+
+func (r *thriftReader) readValue(typ byte, depth int) (interface{}, error) {
+	switch typ {
+	case thriftTrue:
+		return true, nil
+	case thriftFalse:
+		return false, nil
+	case thriftByte:
+		b, err := r.readByte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.readVarint()
+	case thriftDouble:
+		if len(r.buf)-r.pos < 8 {
+			return nil, errThriftTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.buf[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case thriftBinary:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.buf)-r.pos) {
+			return nil, errThriftTruncated
+		}
+		v := r.buf[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return v, nil
+	case thriftList, thriftSet:
+		header, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = r.readUvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if size > thriftMaxLength || size > uint64(len(r.buf)-r.pos) { // Every element takes at least a byte
+			return nil, errThriftTruncated
+		}
+		elemType := header & 0x0f
+		values := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			var v interface{}
+			if elemType == thriftTrue || elemType == thriftFalse {
+				b, err := r.readByte() // Booleans in collections take a byte each
+				if err != nil {
+					return nil, err
+				}
+				v = b == thriftTrue
+			} else if v, err = r.readValue(elemType, depth+1); err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case thriftMap:
+		size, err := r.readUvarint()
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		if size > thriftMaxLength {
+			return nil, errThriftTruncated
+		}
+		types, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < 2*size; i++ {
+			typ := types >> 4
+			if i%2 == 1 {
+				typ = types & 0x0f
+			}
+			if typ == thriftTrue || typ == thriftFalse {
+				typ = thriftByte // Booleans in collections take a byte each
+			}
+			if _, err := r.readValue(typ, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftStruct:
+		return r.readStruct(depth + 1)
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", typ)
+}
@@ -1,5 +1,7 @@
 package parser
 
+import "context"
+
 // ResultType defines the type of the query result.
 type ResultType string
 
@@ -11,13 +13,18 @@ const (
 	StringResult  ResultType = "string"
 	BooleanResult ResultType = "boolean"
 	NumberResult  ResultType = "number"
+	BytesResult   ResultType = "bytes"  // Binary data ([]byte)
+	AbsentResult  ResultType = "absent" // Nothing matched a query, in lenient mode (see SetStrictMode)
 	UnknownResult ResultType = "unknown"
 )
 
 // QueryResult holds the outcome of an expression evaluation.
 type QueryResult struct {
-	Value interface{} // Can be string, float64, bool, []interface{}, map[string]interface{}, or a custom Node type
-	Type  ResultType  // Type of the result
+	Value interface{} `json:"value"` // Can be string, float64, bool, []interface{}, map[string]interface{}, or a custom Node type
+	Type  ResultType  `json:"type"`  // Type of the result
+
+	nodes    nodeSet // The nodes behind an XPath node-set, for map, filter and sortBy
+	streamed bool    // The elements were sent to the sink of a streamed evaluation instead
 }
 
 // PayloadObject is the interface for different payload types (XML, JSON, etc.).
@@ -25,6 +32,20 @@ type PayloadObject interface {
 	GetRawBytes() []byte
 	GetContentType() string
 	Query(expression string) (QueryResult, error)
-	AsString() (string, error)      // Get the whole payload as a string
+	AsString() (string, error)  // Get the whole payload as a string
 	GetUnderlying() interface{} // Access to the raw parsed object (e.g., *xmlquery.Node, gjson.Result)
 }
+
+// contextQuerier is implemented by payloads whose queries can observe the
+// cancellation and deadline of a context while they run.
+type contextQuerier interface {
+	QueryContext(ctx context.Context, expression string) (QueryResult, error)
+}
+
+// queryPayload queries pld, passing ctx along when the payload supports it.
+func queryPayload(ctx context.Context, pld PayloadObject, expression string) (QueryResult, error) {
+	if cq, ok := pld.(contextQuerier); ok {
+		return cq.QueryContext(ctx, expression)
+	}
+	return pld.Query(expression)
+}
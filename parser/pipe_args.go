@@ -0,0 +1,331 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// pipeCall is a pipe operation with its arguments, e.g. `substring(0, 5)`
+// or `dateFormat("2006-01-02")`. A pipe without parentheses has no
+// arguments.
+type pipeCall struct {
+	name string
+	args []interface{} // string, float64 or bool
+	sub  *Expression   // Parsed sub-expression of a collection pipe
+}
+
+// pipeArity lists the number of arguments the built-in pipes accept.
+var pipeArity = map[string]struct{ min, max int }{
+	extractAsJSONPipe: {0, 0},
+	extractAsXMLPipe:  {0, 0},
+	extractAsYAMLPipe: {0, 0},
+	extractAutoPipe:   {0, 0},
+	csvToJSONPipe:     {0, 0},
+	extractAsCSVPipe:  {0, 2},
+	jsonToXMLPipe:     {0, 2},
+	xmlToJSONPipe:     {0, 1},
+	xsltPipe:          {1, 1},
+	joltPipe:          {1, 1},
+	c14nPipe:          {0, 1},
+	prettyPrintPipe:   {0, 1},
+	minifyPipe:        {0, 0},
+	hexPipe:           {0, 0},
+	toHexPipe:         {0, 0},
+	base64EncodePipe:  {0, 0},
+	base64DecodePipe:  {0, 0},
+	gunzipPipe:        {0, 0},
+	inflatePipe:       {0, 0},
+	sha256Pipe:        {0, 0},
+	sha1Pipe:          {0, 0},
+	md5Pipe:           {0, 0},
+	crc32Pipe:         {0, 0},
+	hmacSHA256Pipe:    {1, 1},
+	hmacSHA1Pipe:      {1, 1},
+	aesEncryptPipe:    {1, 2},
+	aesDecryptPipe:    {1, 2},
+	urlEncodePipe:     {0, 1},
+	urlDecodePipe:     {0, 1},
+	trimPipe:          {0, 1},
+	upperPipe:         {0, 0},
+	lowerPipe:         {0, 0},
+	replacePipe:       {2, 2},
+	regexReplacePipe:  {2, 2},
+	splitPipe:         {1, 1},
+	joinPipe:          {1, 1},
+	substringPipe:     {1, 2},
+	defaultPipe:       {1, 1},
+	ifPresentPipe:     {0, 0},
+	ifEmptyPipe:       {0, 0},
+	mapPipe:           {1, 1},
+	filterPipe:        {1, 1},
+	sortByPipe:        {1, 2},
+	flattenPipe:       {0, 1},
+	uniquePipe:        {0, 0},
+	dedupePipe:        {0, 0},
+	slicePipe:         {1, 2},
+	limitPipe:         {1, 1},
+	offsetPipe:        {1, 1},
+	firstPipe:         {0, 0},
+	lastPipe:          {0, 0},
+	toNumberPipe:      {0, 0},
+	toIntPipe:         {0, 0},
+	toBooleanPipe:     {0, 0},
+	toStringPipe:      {0, 0},
+	// extractAsProtobuf(messageType)
+	extractAsProtobufPipe: {1, 1},
+}
+
+// parsePipeCall parses stage as a pipe name, optionally followed by a
+// parenthesized argument list. Arguments are double- or single-quoted
+// strings with backslash escapes, numbers, or true and false, except for
+// pipes taking a sub-expression. ok is false
+// when stage does not have the form of a pipe call, e.g. a query such as
+// "xpath:count(//a)".
+func parsePipeCall(stage string) (call pipeCall, ok bool, err error) {
+	nameEnd := strings.IndexFunc(stage, func(r rune) bool { return !isPipeNameRune(r) })
+	if nameEnd < 0 {
+		return pipeCall{name: stage}, stage != "", nil
+	}
+	list := strings.TrimLeftFunc(stage[nameEnd:], unicode.IsSpace) // Spaces may separate the name and the arguments
+	if nameEnd == 0 || !strings.HasPrefix(list, "(") {
+		return pipeCall{}, false, nil
+	}
+	call.name = stage[:nameEnd]
+	if !strings.HasSuffix(list, ")") {
+		return call, true, fmt.Errorf("missing ')' after the arguments of '%s'", call.name)
+	}
+	list = list[1 : len(list)-1]
+	if isCollectionPipe(call.name) {
+		call.args = expressionArgs(call.name, list)
+		return call, true, nil
+	}
+	call.args, err = parsePipeArgs(list)
+	if err != nil {
+		return call, true, fmt.Errorf("invalid arguments of '%s': %w", call.name, err)
+	}
+	return call, true, nil
+}
+
+// isPipeNameRune reports whether r can be part of a pipe name.
+func isPipeNameRune(r rune) bool {
+	return r == '_' || r == '-' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// expressionArgs returns the arguments of a pipe whose first argument is a
+// sub-expression, e.g. `map(jsonpath:title)`, which is taken as it is. The
+// sub-expression of sortBy may be followed by an order, a word or a quoted
+// string after the last comma outside brackets and quotes.
+func expressionArgs(name, list string) []interface{} {
+	expression := strings.TrimSpace(list)
+	if expression == "" {
+		return nil
+	}
+	if name == sortByPipe {
+		if i := lastTopLevelComma(expression); i >= 0 {
+			order := strings.TrimSpace(expression[i+1:])
+			if arg, rest, err := parsePipeArg(order); err == nil && rest == "" {
+				if s, ok := arg.(string); ok {
+					order = s
+				}
+			}
+			if order != "" && strings.IndexFunc(order, func(r rune) bool { return !unicode.IsLetter(r) }) < 0 {
+				return []interface{}{strings.TrimSpace(expression[:i]), order}
+			}
+		}
+	}
+	return []interface{}{expression}
+}
+
+// lastTopLevelComma returns the index of the last comma of s outside
+// brackets and quotes, or -1.
+func lastTopLevelComma(s string) int {
+	var quote byte
+	depth, last := 0, -1
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			last = i
+		}
+	}
+	return last
+}
+
+// parsePipeArgs parses a comma-separated argument list.
+func parsePipeArgs(list string) ([]interface{}, error) {
+	var args []interface{}
+	rest := strings.TrimSpace(list)
+	if rest == "" {
+		return nil, nil
+	}
+	for {
+		var arg interface{}
+		var err error
+		arg, rest, err = parsePipeArg(rest)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			return args, nil
+		}
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("expected ',' before %q", rest)
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+}
+
+// parsePipeArg parses the argument at the start of s and returns the text
+// after it.
+func parsePipeArg(s string) (interface{}, string, error) {
+	if s == "" || s[0] == ',' {
+		return nil, "", fmt.Errorf("missing argument")
+	}
+	if quote := s[0]; quote == '"' || quote == '\'' {
+		var sb strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == quote:
+				return sb.String(), s[i+1:], nil
+			case c == '\\' && i+1 < len(s):
+				i++
+				switch e := s[i]; e {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				case 'r':
+					sb.WriteByte('\r')
+				default:
+					sb.WriteByte(e) // \\, \" and \' stand for themselves
+				}
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string %s", s)
+	}
+
+	end := strings.IndexAny(s, ", \t")
+	if end < 0 {
+		end = len(s)
+	}
+	token := s[:end]
+	switch token {
+	case "true":
+		return true, s[end:], nil
+	case "false":
+		return false, s[end:], nil
+	}
+	n, err := strconv.ParseFloat(token, 64)
+	if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+		return nil, "", fmt.Errorf("%q is not a quoted string, a number or a boolean", token)
+	}
+	return n, s[end:], nil
+}
+
+// checkPipeArgs checks the number of arguments of a built-in pipe.
+func checkPipeArgs(call pipeCall) error {
+	arity := pipeArity[call.name]
+	switch {
+	case len(call.args) >= arity.min && len(call.args) <= arity.max:
+		return nil
+	case arity.max == 0:
+		return fmt.Errorf("pipe operation '%s' takes no arguments", call.name)
+	case arity.min == arity.max:
+		return fmt.Errorf("pipe operation '%s' takes %d argument(s), got %d", call.name, arity.min, len(call.args))
+	}
+	return fmt.Errorf("pipe operation '%s' takes %d to %d arguments, got %d", call.name, arity.min, arity.max, len(call.args))
+}
+
+// intArg returns argument i of call as an integer.
+func intArg(call pipeCall, i int) (int, error) {
+	n, ok := call.args[i].(float64)
+	if !ok || n != math.Trunc(n) || n < 0 || n > math.MaxInt32 {
+		return 0, fmt.Errorf("argument %d of '%s' must be a non-negative integer, got %v", i+1, call.name, call.args[i])
+	}
+	return int(n), nil
+}
+
+// stringArg returns argument i of call as a string.
+func stringArg(call pipeCall, i int) (string, error) {
+	s, ok := call.args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("argument %d of '%s' must be a string, got %v", i+1, call.name, call.args[i])
+	}
+	return s, nil
+}
+
+// substring returns the runes of s from start (inclusive) to end
+// (exclusive), clamped to the length of s.
+func substring(s string, start, end int) string {
+	runes := []rune(s)
+	start, end = min(start, len(runes)), min(end, len(runes))
+	if end < start {
+		return ""
+	}
+	return string(runes[start:end])
+}
+
+// csvPipeContentType returns the content type extractAsCSV parses its input
+// as: the first argument is the delimiter and the second whether the first
+// record is a header row (the default).
+func csvPipeContentType(call pipeCall) (string, error) {
+	params := map[string]string{"header": "present"}
+	if len(call.args) > 0 {
+		delimiter, ok := call.args[0].(string)
+		if !ok {
+			return "", fmt.Errorf("argument 1 of '%s' must be a delimiter string, got %v", call.name, call.args[0])
+		}
+		params["delimiter"] = delimiter
+	}
+	if len(call.args) > 1 {
+		header, ok := call.args[1].(bool)
+		if !ok {
+			return "", fmt.Errorf("argument 2 of '%s' must be true or false, got %v", call.name, call.args[1])
+		}
+		if !header {
+			params["header"] = "absent"
+		}
+	}
+	return mime.FormatMediaType("text/csv", params), nil
+}
+
+// protobufPipeInput returns the wire bytes an extractAsProtobuf pipe call
+// decodes, and the content type naming its message type: a bytes result is
+// used as it is, and text is decoded as standard or URL-safe base64 when it
+// is base64, and taken as wire bytes otherwise (base64Decode passes on wire
+// bytes that happen to be UTF-8 as text).
+func protobufPipeInput(call pipeCall, result QueryResult, text string) ([]byte, string, error) {
+	messageType, err := stringArg(call, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.TrimSpace(messageType) == "" {
+		return nil, "", fmt.Errorf("argument 1 of '%s' must name a message type", call.name)
+	}
+	contentType := mime.FormatMediaType("application/x-protobuf", map[string]string{"messageType": strings.TrimSpace(messageType)})
+	if raw, ok := result.Value.([]byte); ok {
+		return raw, contentType, nil
+	}
+	if raw, err := decodeBase64(text); err == nil {
+		return raw, contentType, nil
+	}
+	return []byte(text), contentType, nil
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePipeCall(t *testing.T) {
+	tests := []struct {
+		stage    string
+		wantName string
+		wantArgs []interface{}
+		wantCall bool
+		wantErr  string
+	}{
+		{stage: "extractAsJSON", wantName: "extractAsJSON", wantCall: true},
+		{stage: "extractAsJSON()", wantName: "extractAsJSON", wantCall: true},
+		{stage: "substring(0,5)", wantName: "substring", wantArgs: []interface{}{float64(0), float64(5)}, wantCall: true},
+		{stage: `dateFormat("2006-01-02")`, wantName: "dateFormat", wantArgs: []interface{}{"2006-01-02"}, wantCall: true},
+		{stage: `pad( 'a, b' , -1.5, true ,false)`, wantName: "pad", wantArgs: []interface{}{"a, b", -1.5, true, false}, wantCall: true},
+		{stage: `quote("say \"hi\"\n", 'it\'s')`, wantName: "quote", wantArgs: []interface{}{"say \"hi\"\n", "it's"}, wantCall: true},
+		{stage: `wrap("(", ")")`, wantName: "wrap", wantArgs: []interface{}{"(", ")"}, wantCall: true},
+		{stage: "substring (0, 5)", wantName: "substring", wantArgs: []interface{}{float64(0), float64(5)}, wantCall: true},
+		{stage: "substring\n\t(\n0,\n5\n)", wantName: "substring", wantArgs: []interface{}{float64(0), float64(5)}, wantCall: true},
+		{stage: "upper lower"},
+		{stage: "xpath:count(//a)"},
+		{stage: "fixedWidthToJSON:customer"},
+		{stage: "(1)"},
+		{stage: "substring(0,5", wantName: "substring", wantCall: true, wantErr: "missing ')'"},
+		{stage: "substring(0,,5)", wantName: "substring", wantCall: true, wantErr: "missing argument"},
+		{stage: "substring(0 5)", wantName: "substring", wantCall: true, wantErr: "expected ','"},
+		{stage: `trim("abc)`, wantName: "trim", wantCall: true, wantErr: "unterminated string"},
+		{stage: "mask(four)", wantName: "mask", wantCall: true, wantErr: `"four" is not a quoted string, a number or a boolean`},
+		{stage: "map(jsonpath:items.#(qty>1)#.sku)", wantName: "map", wantArgs: []interface{}{"jsonpath:items.#(qty>1)#.sku"}, wantCall: true},
+		{stage: "sortBy(jsonpath:price, desc)", wantName: "sortBy", wantArgs: []interface{}{"jsonpath:price", "desc"}, wantCall: true},
+		{stage: "sortBy(xpath:concat(a, b))", wantName: "sortBy", wantArgs: []interface{}{"xpath:concat(a, b)"}, wantCall: true},
+		{stage: `sortBy(jsonpath:price, "asc")`, wantName: "sortBy", wantArgs: []interface{}{"jsonpath:price", "asc"}, wantCall: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stage, func(t *testing.T) {
+			call, ok, err := parsePipeCall(tt.stage)
+			if ok != tt.wantCall {
+				t.Fatalf("got call %v, want %v", ok, tt.wantCall)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok && (call.name != tt.wantName || !reflect.DeepEqual(call.args, tt.wantArgs)) {
+				t.Errorf("got %s%#v, want %s%#v", call.name, call.args, tt.wantName, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestPipeArguments(t *testing.T) {
+	body := []byte(`{"id":"ORD-2024-0042","city":"Zürich","count":3}`)
+	engine := NewEngine()
+	err := engine.RegisterPipe("mask", func(_ context.Context, input PipeInput) (QueryResult, error) {
+		keep, ok := input.Args[0].(float64)
+		if len(input.Args) != 2 || !ok {
+			return QueryResult{}, &ErrEvaluationFailed{Reason: "mask(keep, char) expected"}
+		}
+		n := len(input.Text) - int(keep)
+		return QueryResult{Value: strings.Repeat(input.Args[1].(string), n) + input.Text[n:], Type: StringResult}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "substring", expression: "jsonpath:id | substring(0, 3)", want: "ORD"},
+		{name: "substring to the end", expression: "jsonpath:id | substring(9)", want: "0042"},
+		{name: "substring in characters", expression: "jsonpath:city | substring(1, 3)", want: "ür"},
+		{name: "substring past the end", expression: "jsonpath:id | substring(10, 99)", want: "042"},
+		{name: "substring empty range", expression: "jsonpath:id | substring(5, 2)", want: ""},
+		{name: "registered pipe arguments", expression: `jsonpath:id | mask(4, "*")`, want: "*********0042"},
+		{name: "chained", expression: `jsonpath:id | substring(4) | mask(2, '#')`, want: "#######42"},
+		{name: "built-in without parameters", expression: "jsonpath:id | hex()", want: "4f52442d323032342d30303432"},
+		{name: "missing argument", expression: "jsonpath:id | substring()", wantErr: "takes 1 to 2 arguments, got 0"},
+		{name: "unexpected argument", expression: "jsonpath:id | hex(1)", wantErr: "pipe operation 'hex' takes no arguments"},
+		{name: "negative index", expression: "jsonpath:id | substring(-1)", wantErr: "must be a non-negative integer"},
+		{name: "string index", expression: `jsonpath:id | substring("1")`, wantErr: "must be a non-negative integer"},
+		{name: "malformed arguments", expression: "jsonpath:id | substring(1,", wantErr: "invalid pipe operation"},
+		{name: "registered pipe rejects arguments", expression: "jsonpath:id | mask(4)", wantErr: "mask(keep, char) expected"},
+		{name: "unknown pipe with arguments", expression: "jsonpath:id | dateFormat(\"2006-01-02\")", wantErr: "unsupported pipe operation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// splitPipeline splits an expression into its stages at each "|" that is
+// outside quotes and brackets, so that XPath unions in predicates, gjson
+// queries and pipe arguments such as join("|") stay in their stage:
+//
+//	xpath://order[@type='a' or @type='b|c']/id | trim
+//	jsonpath:items.#(tag=="x|y")#.id
+//	jsonpath:tags | join("|")
+//
+// Quotes open a string only where a string literal can start, i.e. not right
+// after a letter or digit (as in "o'brien"), and are not special in regex:
+// and text: stages. Brackets of each kind must match; a bracket or quote
+// that is never closed is an ordinary character, so that e.g. a regex with
+// an escaped parenthesis does not swallow the rest of the expression.
+//
+// "\|" is a "|" that does not split the expression, e.g. for a top-level
+// XPath union: "xpath://a\|//b". In jsonpath:, yamlpath: and regex: stages,
+// whose languages read "\|" as an escaped pipe themselves, the backslash is
+// kept; in other stages it is removed.
+func splitPipeline(expression string) []string {
+	return splitStages(expression, true)
+}
+
+// splitStages splits expression like splitPipeline, removing the backslash
+// of "\|" where splitPipeline does if unescape is set, and keeping the
+// stages as they are written otherwise.
+func splitStages(expression string, unescape bool) []string {
+	tokens := scanStages(expression, unescape)
+	stages := make([]string, len(tokens))
+	for i, token := range tokens {
+		stages[i] = token.text
+	}
+	return stages
+}
+
+// stageToken is a stage of an expression and where it starts.
+type stageToken struct {
+	text   string
+	offset int // Byte offset of text in the expression
+}
+
+// tokenizePipeline splits expression into stages like splitPipeline, with
+// the whitespace around each stage removed, so that any spacing and line
+// breaks can separate stages. Whitespace escaped with a backslash is kept,
+// e.g. the space a regular expression ends with in "regex:total:\ | trim".
+func tokenizePipeline(expression string) []stageToken {
+	tokens := scanStages(expression, true)
+	for i, token := range tokens {
+		text := strings.TrimLeftFunc(token.text, unicode.IsSpace)
+		offset := token.offset + len(token.text) - len(text)
+		trimmed := strings.TrimRightFunc(text, unicode.IsSpace)
+		if trailing := text[len(trimmed):]; trailing != "" && escapesNext(trimmed) {
+			_, size := utf8.DecodeRuneInString(trailing)
+			trimmed += trailing[:size]
+		}
+		tokens[i] = stageToken{text: trimmed, offset: offset}
+	}
+	return tokens
+}
+
+// escapesNext reports whether s ends with a backslash that escapes the
+// character after it, i.e. with an odd number of backslashes.
+func escapesNext(s string) bool {
+	n := len(s) - len(strings.TrimRight(s, `\`))
+	return n%2 == 1
+}
+
+// scanStages splits expression into stages, reading the brackets and quotes
+// that are never closed as ordinary characters.
+func scanStages(expression string, unescape bool) []stageToken {
+	ignored := map[int]bool{} // Positions of brackets and quotes read as ordinary characters
+	for {
+		stages, unclosed := scanPipeline(expression, ignored, unescape)
+		if unclosed < 0 {
+			return stages
+		}
+		ignored[unclosed] = true
+	}
+}
+
+// scanPipeline splits expression into stages, treating the positions in
+// ignored as ordinary characters. It returns the position of a bracket or
+// quote that is not closed instead if it finds one.
+func scanPipeline(expression string, ignored map[int]bool, unescape bool) ([]stageToken, int) {
+	var stages []stageToken
+	var stage strings.Builder
+	start := 0     // Where the stage starts
+	var open []int // Positions of the open brackets, innermost last
+	quote := -1    // Position of the open quote
+	quotes, keepEscapes := true, false
+	for i := 0; i < len(expression); i++ {
+		c := expression[i]
+		if stage.Len() == 0 {
+			quotes, keepEscapes = stageSyntax(expression[i:])
+		}
+		switch {
+		case c == '\\' && i+1 < len(expression):
+			i++
+			if expression[i] != '|' || keepEscapes || !unescape {
+				stage.WriteByte(c)
+			}
+			stage.WriteByte(expression[i])
+			continue
+		case quote >= 0:
+			if c == expression[quote] {
+				quote = -1
+			}
+		case ignored[i]:
+		case (c == '\'' || c == '"') && quotes && (i == 0 || !isWordByte(expression[i-1])):
+			quote = i
+		case c == '(' || c == '[' || c == '{':
+			open = append(open, i)
+		case c == ')' || c == ']' || c == '}':
+			if len(open) > 0 {
+				top := open[len(open)-1]
+				if expression[top] != openingBracket(c) {
+					return nil, top
+				}
+				open = open[:len(open)-1]
+			}
+		case c == '|' && len(open) == 0:
+			stages = append(stages, stageToken{stage.String(), start})
+			stage.Reset()
+			start = i + 1
+			continue
+		}
+		stage.WriteByte(c)
+	}
+	switch {
+	case quote >= 0:
+		return nil, quote
+	case len(open) > 0:
+		return nil, open[len(open)-1]
+	}
+	return append(stages, stageToken{stage.String(), start}), -1
+}
+
+// stageSyntax reports whether quotes delimit strings in the stage starting
+// at the beginning of rest, and whether its language reads "\|" itself.
+func stageSyntax(rest string) (quotes, keepEscapes bool) {
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	quotes = !strings.HasPrefix(rest, regexPrefix) && !strings.HasPrefix(rest, textPrefix)
+	keepEscapes = strings.HasPrefix(rest, jsonpathPrefix) || strings.HasPrefix(rest, yamlpathPrefix) || strings.HasPrefix(rest, regexPrefix)
+	return quotes, keepEscapes
+}
+
+// openingBracket returns the bracket closed by c.
+func openingBracket(c byte) byte {
+	switch c {
+	case ')':
+		return '('
+	case ']':
+		return '['
+	}
+	return '{'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPipeline(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       []string
+	}{
+		{name: "plain stages", expression: "xpath:/a | trim | toUpper", want: []string{"xpath:/a ", " trim ", " toUpper"}},
+		{name: "union in predicate", expression: "xpath://a[b|c] | trim", want: []string{"xpath://a[b|c] ", " trim"}},
+		{name: "union in function call", expression: "xpath:count(//a|//b)", want: []string{"xpath:count(//a|//b)"}},
+		{name: "quoted pipe", expression: `xpath://a[@t='x|y'] | trim`, want: []string{`xpath://a[@t='x|y'] `, " trim"}},
+		{name: "pipe argument", expression: `jsonpath:tags | join("|")`, want: []string{"jsonpath:tags ", ` join("|")`}},
+		{name: "gjson query", expression: `jsonpath:items.#(tag=="a|b")#.id`, want: []string{`jsonpath:items.#(tag=="a|b")#.id`}},
+		{name: "gjson multipath", expression: "jsonpath:{a,b|c}", want: []string{"jsonpath:{a,b|c}"}},
+		{name: "escaped pipe kept for jsonpath", expression: `jsonpath:a\|b | trim`, want: []string{`jsonpath:a\|b `, " trim"}},
+		{name: "escaped pipe kept for regex", expression: `regex:a\|b`, want: []string{`regex:a\|b`}},
+		{name: "escaped pipe unescaped for xpath", expression: `xpath://a\|//b | count`, want: []string{"xpath://a|//b ", " count"}},
+		{name: "other escapes kept", expression: `regex:\d+\( | trim`, want: []string{`regex:\d+\( `, " trim"}},
+		{name: "regex alternation in group", expression: "regex:(a|b)c", want: []string{"regex:(a|b)c"}},
+		{name: "regex quotes are literal", expression: `regex:'(a) | trim`, want: []string{"regex:'(a) ", " trim"}},
+		{name: "unclosed bracket", expression: "regex:[(] | trim", want: []string{"regex:[(] ", " trim"}},
+		{name: "unclosed quote", expression: `xpath:/a[@t="x] | trim`, want: []string{`xpath:/a[@t="x] `, " trim"}},
+		{name: "apostrophe in a word", expression: "text:o'brien | trim", want: []string{"text:o'brien ", " trim"}},
+		{name: "stray closer", expression: "xpath:/a) | trim", want: []string{"xpath:/a) ", " trim"}},
+		{name: "mismatched brackets", expression: "xpath:/a[(b] | trim", want: []string{"xpath:/a[(b] ", " trim"}},
+		{name: "empty stage", expression: "xpath:/a ||trim", want: []string{"xpath:/a ", "", "trim"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitPipeline(tt.expression); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitPipeline(%q) = %q, want %q", tt.expression, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizePipeline(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       []stageToken
+	}{
+		{name: "no spaces", expression: "jsonpath:msg|extractAsJSON", want: []stageToken{{"jsonpath:msg", 0}, {"extractAsJSON", 13}}},
+		{name: "any whitespace", expression: " jsonpath:msg \n\t|\n  extractAsJSON\r\n", want: []stageToken{{"jsonpath:msg", 1}, {"extractAsJSON", 20}}},
+		{name: "quoted spaces kept", expression: `jsonpath:s | split(" | ")`, want: []stageToken{{"jsonpath:s", 0}, {`split(" | ")`, 13}}},
+		{name: "bracketed pipe", expression: "xpath:count(//a | //b) | toString", want: []stageToken{{"xpath:count(//a | //b)", 0}, {"toString", 25}}},
+		{name: "escaped trailing space kept", expression: `jsonpath:s | regex:total:\  | trim`, want: []stageToken{{"jsonpath:s", 0}, {`regex:total:\ `, 13}, {"trim", 30}}},
+		{name: "escaped backslash before space", expression: `regex:a\\  | trim`, want: []stageToken{{`regex:a\\`, 0}, {"trim", 13}}},
+		{name: "escaped pipe", expression: `xpath://a \| //b | last`, want: []stageToken{{"xpath://a | //b", 0}, {"last", 19}}},
+		{name: "empty stages", expression: " | ", want: []stageToken{{"", 1}, {"", 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenizePipeline(tt.expression); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenizePipeline(%q) = %+v, want %+v", tt.expression, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipesInsideExpressions(t *testing.T) {
+	engine := NewEngine()
+	xmlCtx := NewMessageContext([]byte(`<orders><order status="new|open"><id>1</id></order><order status="closed"><id>2</id></order><invoice><id>3</id></invoice></orders>`), "application/xml", engine)
+	jsonCtx := NewMessageContext([]byte(`{"items": [{"sku": "A", "tag": "rush|gift"}, {"sku": "B", "tag": "none"}], "headers": {"a|b": "both"}, "path": "x|y|z"}`), "application/json", engine)
+	tests := []struct {
+		name       string
+		msgCtx     *MessageContext
+		expression string
+		want       interface{}
+	}{
+		{name: "quoted pipe in predicate", msgCtx: xmlCtx, expression: `xpath:count(//order[@status='new|open'])`, want: float64(1)},
+		{name: "union in predicate", msgCtx: xmlCtx, expression: `xpath:count(//orders/*[self::invoice|self::order[@status='closed']])`, want: float64(2)},
+		{name: "escaped top-level union", msgCtx: xmlCtx, expression: `xpath://order/id\|//invoice/id | last`, want: "3"},
+		{name: "gjson query", msgCtx: jsonCtx, expression: `jsonpath:items.#(tag=="rush|gift").sku`, want: "A"},
+		{name: "escaped gjson key", msgCtx: jsonCtx, expression: `jsonpath:headers.a\|b | upper`, want: "BOTH"},
+		{name: "split and join on pipes", msgCtx: jsonCtx, expression: `jsonpath:path | split("|") | join("/")`, want: "x/y/z"},
+		{name: "regex alternation", msgCtx: jsonCtx, expression: `jsonpath:path | regex:(y|z)$`, want: "z"},
+		{name: "no spaces around pipes", msgCtx: jsonCtx, expression: `jsonpath:path|split("|")|join("/")`, want: "x/y/z"},
+		{name: "line breaks around pipes", msgCtx: jsonCtx, expression: "jsonpath:path\n\t| split(\"|\")\n\t| last", want: "z"},
+		{name: "space after prefix", msgCtx: jsonCtx, expression: "jsonpath: items.1.sku | lower", want: "b"},
+		{name: "space before arguments", msgCtx: jsonCtx, expression: `jsonpath:path | substring (0, 3)`, want: "x|y"},
+		{name: "spaces in quoted argument", msgCtx: jsonCtx, expression: `jsonpath:path | replace("|", " | ")`, want: "x | y | z"},
+		{name: "escaped trailing space in regex", msgCtx: xmlCtx, expression: `xpath:string(//order/@status) | regex:^new\|`, want: "new|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.msgCtx.EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
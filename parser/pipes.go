@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PipeInput is what a registered pipe receives from the previous stage.
+type PipeInput struct {
+	Result QueryResult   // The previous stage's result
+	Text   string        // The result as text: bytes as they are, arrays and objects as JSON
+	Args   []interface{} // Arguments of the call, e.g. `dateFormat("2006-01-02")`: string, float64 or bool
+}
+
+// PipeFunc transforms the previous stage's result in a pipeline. The result
+// it returns is passed on to the next stage, and later query stages keep
+// querying the current payload: follow the pipe with a conversion such as
+// extractAsJSON or extractAsCSV to query its output instead. Errors fail
+// the evaluation.
+type PipeFunc func(ctx context.Context, input PipeInput) (QueryResult, error)
+
+// RegisterPipe adds a pipe operation that expressions evaluated by this
+// engine can use after any stage, e.g.
+// "jsonpath:order.blob | decodeOrderBlob | extractAsJSON | jsonpath:id".
+// Names consist of letters, digits, '_', '-' and '.', and cannot replace a
+// built-in pipe. Expressions may pass arguments, e.g. `| mask(4, "*")`,
+// which fn receives unchecked in PipeInput.Args. Registering a name again
+// replaces its function; passing a nil fn removes it. Engines that have this
+// engine as their fallback can use its pipes too.
+func (ee *ExpressionEngine) RegisterPipe(name string, fn PipeFunc) error {
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isPipeNameRune(r) }) >= 0 {
+		return fmt.Errorf("invalid pipe name '%s'", name)
+	}
+	if _, builtIn := pipeArity[name]; builtIn {
+		return fmt.Errorf("pipe '%s' is built in", name)
+	}
+	if fn == nil {
+		ee.pipes.Delete(name)
+		return nil
+	}
+	ee.pipes.Store(name, fn)
+	return nil
+}
+
+// registeredPipe returns the function of a pipe registered on this engine or
+// on its fallback chain.
+func (ee *ExpressionEngine) registeredPipe(name string) (PipeFunc, bool) {
+	for e := ee; e != nil; e = e.fallback.Load() {
+		if fn, ok := e.pipes.Load(name); ok {
+			return fn.(PipeFunc), true
+		}
+	}
+	return nil, false
+}
+
+// registeredPipeSignature is the signature of every registered pipe: its
+// output is not known ahead of evaluation.
+var registeredPipeSignature = stageSignature{input: pipeInputKinds, output: KindAny, keepsPayload: true}
@@ -0,0 +1,166 @@
+package parser
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeOrderBlob decodes the base64 order blobs of the tests.
+func decodeOrderBlob(_ context.Context, input PipeInput) (QueryResult, error) {
+	decoded, err := base64.StdEncoding.DecodeString(input.Text)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	return QueryResult{Value: string(decoded), Type: StringResult}, nil
+}
+
+func TestRegisterPipe(t *testing.T) {
+	blob := base64.StdEncoding.EncodeToString([]byte(`{"id":"A-7","lines":3}`))
+	body := []byte(`{"order":{"blob":"` + blob + `","tags":["a","b"]},"count":2}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "decode and query", expression: "jsonpath:order.blob | decodeOrderBlob | extractAsJSON | jsonpath:id", want: "A-7"},
+		{name: "result passed on", expression: "jsonpath:order.blob | decodeOrderBlob", want: `{"id":"A-7","lines":3}`},
+		{name: "chained pipes", expression: "jsonpath:order.blob | decodeOrderBlob | shout", want: `{"ID":"A-7","LINES":3}`},
+		{name: "array input as json", expression: "jsonpath:order.tags | describeInput", want: `array ["a","b"]`},
+		{name: "non-string result", expression: "jsonpath:order.tags | describeInput | length", want: float64(15)},
+		{name: "pipe error", expression: "jsonpath:order.tags | decodeOrderBlob", wantErr: "pipe operation 'decodeOrderBlob' failed"},
+		{name: "number input rejected", expression: "jsonpath:count | shout", wantErr: "requires string, array or object input"},
+		{name: "unregistered", expression: "jsonpath:order.blob | encodeOrderBlob", wantErr: "unsupported pipe operation: encodeOrderBlob"},
+	}
+
+	engine := NewEngine()
+	pipes := map[string]PipeFunc{
+		"decodeOrderBlob": decodeOrderBlob,
+		"shout": func(_ context.Context, input PipeInput) (QueryResult, error) {
+			return QueryResult{Value: strings.ToUpper(input.Text), Type: StringResult}, nil
+		},
+		"describeInput": func(_ context.Context, input PipeInput) (QueryResult, error) {
+			return QueryResult{Value: string(input.Result.Type) + " " + input.Text, Type: StringResult}, nil
+		},
+		"length": func(_ context.Context, input PipeInput) (QueryResult, error) {
+			return QueryResult{Value: float64(len(input.Text)), Type: NumberResult}, nil
+		},
+	}
+	for name, fn := range pipes {
+		if err := engine.RegisterPipe(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterPipeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		pipe string
+	}{
+		{name: "empty name", pipe: ""},
+		{name: "name with pipe", pipe: "a|b"},
+		{name: "name with space", pipe: "a b"},
+		{name: "name with colon", pipe: "xpath:a"},
+		{name: "name with parentheses", pipe: "f(x)"},
+		{name: "built-in pipe", pipe: extractAsJSONPipe},
+	}
+	engine := NewEngine()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := engine.RegisterPipe(tt.pipe, decodeOrderBlob); err == nil {
+				t.Error("engine accepted the pipe")
+			}
+		})
+	}
+}
+
+func TestRegisteredPipeRemovalAndFallback(t *testing.T) {
+	base := NewEngine()
+	if err := base.RegisterPipe("decodeOrderBlob", decodeOrderBlob); err != nil {
+		t.Fatal(err)
+	}
+	product := NewEngine()
+	if err := product.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"blob":"` + base64.StdEncoding.EncodeToString([]byte("x")) + `"}`)
+
+	result, err := NewMessageContext(body, "application/json", product).EvaluateExpression("jsonpath:blob | decodeOrderBlob")
+	if err != nil || result.Value != "x" {
+		t.Fatalf("got %#v, %v, want the fallback's pipe to run", result.Value, err)
+	}
+	if warnings := product.Lint("jsonpath:blob | decodeOrderBlob"); len(warnings) != 0 {
+		t.Errorf("got lint warnings %v", warnings)
+	}
+
+	if err := base.RegisterPipe("decodeOrderBlob", nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewMessageContext(body, "application/json", product).EvaluateExpression("jsonpath:blob | decodeOrderBlob")
+	var unsupported *ErrUnsupportedExpression
+	if !errors.As(err, &unsupported) {
+		t.Errorf("got %v, want ErrUnsupportedExpression after removal", err)
+	}
+}
+
+func TestRegisteredPipeLintAndCompile(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.RegisterPipe("decodeOrderBlob", decodeOrderBlob); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		wantRules  []string
+		wantErr    bool
+	}{
+		{name: "after a query", expression: "jsonpath:blob | decodeOrderBlob | extractAsJSON | jsonpath:id"},
+		{name: "first stage", expression: "decodeOrderBlob", wantRules: []string{LintRuleSyntax}, wantErr: true},
+		{name: "query without conversion", expression: "jsonpath:blob | decodeOrderBlob | jsonpath:id", wantRules: []string{LintRuleDeprecated}},
+		{name: "number input", expression: "xpath:count(//a) | decodeOrderBlob", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules []string
+			for _, w := range engine.Lint(tt.expression) {
+				rules = append(rules, w.Rule)
+			}
+			if !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Errorf("got lint rules %v, want %v", rules, tt.wantRules)
+			}
+			if _, err := engine.Compile(tt.expression); (err != nil) != tt.wantErr {
+				t.Errorf("got compile error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	desc := engine.Describe()
+	last := desc.Pipes[len(desc.Pipes)-1]
+	if last.Name != "decodeOrderBlob" || last.Input != pipeInputKinds || last.Output != KindAny {
+		t.Errorf("got %+v, want the registered pipe described last", last)
+	}
+}
@@ -0,0 +1,349 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PropertiesDialect selects the syntax of a PropertiesPayload.
+type PropertiesDialect int
+
+const (
+	JavaProperties PropertiesDialect = iota // java.util.Properties files
+	DotEnv                                  // .env files
+)
+
+// PropertiesPayload handles Java properties ("text/x-java-properties") and
+// .env files ("text/x-dotenv"). Values may reference other keys as ${key},
+// or ${key:default} to use a default when the key is missing; references
+// are resolved when queried. Unresolvable references without a default are
+// kept as written. Single-quoted .env values are not interpolated.
+//
+// The query language ("prop:" expressions) is:
+//
+//	key       the interpolated value of key
+//	prefix.*  the keys starting with "prefix.", without it, as an object
+//	(empty)   all keys, as an object
+type PropertiesPayload struct {
+	rawContent  []byte
+	keys        []string // In document order, without duplicates
+	values      map[string]propertyValue
+	contentType string
+}
+
+// propertyValue is a value as written, before interpolation.
+type propertyValue struct {
+	raw     string
+	literal bool // Not interpolated (single-quoted .env values)
+}
+
+// NewPropertiesPayload parses content in the given dialect. Later
+// definitions of a key override earlier ones.
+func NewPropertiesPayload(content []byte, dialect PropertiesDialect) (*PropertiesPayload, error) {
+	pp := &PropertiesPayload{rawContent: content, values: map[string]propertyValue{}}
+	var err error
+	switch dialect {
+	case JavaProperties:
+		pp.contentType = "text/x-java-properties"
+		err = pp.parseJavaProperties(content)
+	case DotEnv:
+		pp.contentType = "text/x-dotenv"
+		err = pp.parseDotEnv(content)
+	default:
+		return nil, fmt.Errorf("unknown properties dialect %d", dialect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pp, nil
+}
+
+func (pp *PropertiesPayload) set(key string, value propertyValue) {
+	if _, exists := pp.values[key]; !exists {
+		pp.keys = append(pp.keys, key)
+	}
+	pp.values[key] = value
+}
+
+// parseJavaProperties follows java.util.Properties.load: "#" and "!"
+// comments, "=", ":" or whitespace separators, backslash line continuations
+// and escapes including \uXXXX.
+func (pp *PropertiesPayload) parseJavaProperties(content []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		startLine := lineNumber
+		for continuesOnNextLine(line) && scanner.Scan() {
+			lineNumber++
+			line = line[:len(line)-1] + strings.TrimLeft(scanner.Text(), " \t\f")
+		}
+
+		// The key ends at the first unescaped separator
+		end := 0
+		for end < len(line) && !strings.ContainsRune("=: \t\f", rune(line[end])) {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		end = min(end, len(line))
+		rest := strings.TrimLeft(line[end:], " \t\f")
+		if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+			rest = strings.TrimLeft(rest[1:], " \t\f")
+		}
+		key, err := unescapeProperty(line[:end])
+		if err != nil {
+			return &ErrEvaluationFailed{Reason: fmt.Sprintf("invalid key on line %d", startLine), InnerError: err}
+		}
+		value, err := unescapeProperty(rest)
+		if err != nil {
+			return &ErrEvaluationFailed{Reason: fmt.Sprintf("invalid value for key '%s' on line %d", key, startLine), InnerError: err}
+		}
+		pp.set(key, propertyValue{raw: value})
+	}
+	return scanner.Err()
+}
+
+// continuesOnNextLine reports whether line ends with an odd number of backslashes.
+func continuesOnNextLine(line string) bool {
+	backslashes := len(line) - len(strings.TrimRight(line, "\\"))
+	return backslashes%2 == 1
+}
+
+// unescapeProperty resolves the escapes of a properties key or value.
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("truncated \\u escape")
+			}
+			code, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("invalid \\u escape '%s'", s[i-1:i+5])
+			}
+			sb.WriteRune(rune(code))
+			i += 4
+		default:
+			sb.WriteByte(s[i]) // \=, \:, \\, \# etc. stand for the character itself
+		}
+	}
+	return sb.String(), nil
+}
+
+// parseDotEnv reads KEY=value lines with an optional "export " prefix.
+// Double-quoted values may span lines and use \n, \t, \" and \\ escapes;
+// single-quoted values are taken literally; unquoted values end at " #".
+func (pp *PropertiesPayload) parseDotEnv(content []byte) error {
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, rest, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			return &ErrEvaluationFailed{Reason: fmt.Sprintf("invalid line %d: expected KEY=value", lineNumber)}
+		}
+		rest = strings.TrimLeft(rest, " \t")
+
+		switch {
+		case strings.HasPrefix(rest, "'"):
+			end := strings.IndexByte(rest[1:], '\'')
+			if end < 0 {
+				return &ErrEvaluationFailed{Reason: fmt.Sprintf("unterminated single-quoted value for key '%s' on line %d", key, lineNumber)}
+			}
+			pp.set(key, propertyValue{raw: rest[1 : end+1], literal: true})
+		case strings.HasPrefix(rest, `"`):
+			value, consumed, ok := readDoubleQuoted(rest[1:], lines[i+1:])
+			if !ok {
+				return &ErrEvaluationFailed{Reason: fmt.Sprintf("unterminated double-quoted value for key '%s' on line %d", key, lineNumber)}
+			}
+			i += consumed
+			pp.set(key, propertyValue{raw: value})
+		default:
+			if comment := strings.Index(rest, " #"); comment >= 0 {
+				rest = rest[:comment]
+			}
+			pp.set(key, propertyValue{raw: strings.TrimSpace(rest)})
+		}
+	}
+	return nil
+}
+
+// readDoubleQuoted reads a double-quoted .env value that starts in first and
+// may continue on the following lines. It returns the value and the number of
+// following lines consumed.
+func readDoubleQuoted(first string, following []string) (string, int, bool) {
+	var sb strings.Builder
+	text := first
+	for consumed := 0; ; consumed++ {
+		for i := 0; i < len(text); i++ {
+			switch c := text[i]; {
+			case c == '"':
+				return sb.String(), consumed, true
+			case c == '\\' && i+1 < len(text):
+				i++
+				switch text[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				case 'r':
+					sb.WriteByte('\r')
+				default:
+					sb.WriteByte(text[i])
+				}
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		if consumed == len(following) {
+			return "", consumed, false
+		}
+		sb.WriteByte('\n')
+		text = following[consumed]
+	}
+}
+
+func (pp *PropertiesPayload) GetRawBytes() []byte {
+	return pp.rawContent
+}
+
+func (pp *PropertiesPayload) GetContentType() string {
+	return pp.contentType
+}
+
+// Keys returns the keys in document order.
+func (pp *PropertiesPayload) Keys() []string {
+	return pp.keys
+}
+
+// Raw returns the value of key as written, without interpolation.
+func (pp *PropertiesPayload) Raw(key string) (string, bool) {
+	value, ok := pp.values[key]
+	return value.raw, ok
+}
+
+// Get returns the interpolated value of key.
+func (pp *PropertiesPayload) Get(key string) (string, error) {
+	if _, ok := pp.values[key]; !ok {
+		return "", &ErrEvaluationFailed{Expression: key, Reason: fmt.Sprintf("property '%s' not found", key), InnerError: ErrNoMatch}
+	}
+	return pp.resolve(key, nil)
+}
+
+// resolve interpolates the value of key; chain holds the keys being resolved.
+func (pp *PropertiesPayload) resolve(key string, chain []string) (string, error) {
+	for _, outer := range chain {
+		if outer == key {
+			return "", &ErrEvaluationFailed{Expression: key, Reason: fmt.Sprintf("property reference cycle: %s -> %s", strings.Join(chain, " -> "), key)}
+		}
+	}
+	value := pp.values[key]
+	if value.literal || !strings.Contains(value.raw, "${") {
+		return value.raw, nil
+	}
+	chain = append(chain, key)
+
+	var sb strings.Builder
+	raw := value.raw
+	for {
+		start := strings.Index(raw, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(raw[start:], '}')
+		if end < 0 {
+			break
+		}
+		sb.WriteString(raw[:start])
+		reference := raw[start+2 : start+end]
+		name, defaultValue, hasDefault := strings.Cut(reference, ":")
+		switch _, exists := pp.values[name]; {
+		case exists:
+			resolved, err := pp.resolve(name, chain)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(resolved)
+		case hasDefault:
+			sb.WriteString(defaultValue)
+		default:
+			sb.WriteString(raw[start : start+end+1]) // Kept as written
+		}
+		raw = raw[start+end+1:]
+	}
+	sb.WriteString(raw)
+	return sb.String(), nil
+}
+
+// Query evaluates a properties query (see PropertiesPayload).
+func (pp *PropertiesPayload) Query(expression string) (QueryResult, error) {
+	expression = strings.TrimSpace(expression)
+	if expression != "" && expression != "*" && !strings.HasSuffix(expression, ".*") {
+		value, err := pp.Get(expression)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		return QueryResult{Value: value, Type: StringResult}, nil
+	}
+
+	prefix := strings.TrimSuffix(expression, "*")
+	values := map[string]interface{}{}
+	for _, key := range pp.keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		value, err := pp.resolve(key, nil)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		values[strings.TrimPrefix(key, prefix)] = value
+	}
+	if len(values) == 0 && prefix != "" {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("no properties start with '%s'", prefix), InnerError: ErrNoMatch}
+	}
+	return QueryResult{Value: values, Type: ObjectResult}, nil
+}
+
+func (pp *PropertiesPayload) AsString() (string, error) {
+	return string(pp.rawContent), nil
+}
+
+// GetUnderlying returns the values as written, without interpolation.
+func (pp *PropertiesPayload) GetUnderlying() interface{} {
+	raw := make(map[string]string, len(pp.values))
+	for key, value := range pp.values {
+		raw[key] = value.raw
+	}
+	return raw
+}
@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJavaPropertiesQuery(t *testing.T) {
+	const properties = `# Service configuration
+! also a comment
+app.name = orders
+app.host:db.internal
+app.port 5432
+app.url = jdbc:postgresql://${app.host}:${app.port}/${app.name}
+app.pool = ${app.poolSize:10}
+app.missing = ${nowhere}
+app.banner = first line \
+             second line
+key\ with\ spaces = spaced
+greeting = café\tbar
+empty =
+cycle.a = ${cycle.b}
+cycle.b = ${cycle.a}
+app.name = orders-v2
+`
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "equals separator", expression: "prop:app.name", want: "orders-v2"},
+		{name: "colon separator", expression: "prop:app.host", want: "db.internal"},
+		{name: "whitespace separator", expression: "prop:app.port", want: "5432"},
+		{name: "interpolation", expression: "prop:app.url", want: "jdbc:postgresql://db.internal:5432/orders-v2"},
+		{name: "default", expression: "prop:app.pool", want: "10"},
+		{name: "unresolved kept", expression: "prop:app.missing", want: "${nowhere}"},
+		{name: "continuation", expression: "prop:app.banner", want: "first line second line"},
+		{name: "escaped key", expression: "prop:key with spaces", want: "spaced"},
+		{name: "escapes", expression: "prop:greeting", want: "café\tbar"},
+		{name: "empty value", expression: "prop:empty", want: ""},
+		{name: "prefix", expression: "prop:app.*", want: map[string]interface{}{
+			"name": "orders-v2", "host": "db.internal", "port": "5432", "url": "jdbc:postgresql://db.internal:5432/orders-v2",
+			"pool": "10", "missing": "${nowhere}", "banner": "first line second line",
+		}},
+		{name: "prefix piped to json", expression: "prop:app.* | extractAsJSON | jsonpath:port", want: "5432"},
+		{name: "missing key", expression: "prop:app.user", wantErr: true},
+		{name: "missing prefix", expression: "prop:db.*", wantErr: true},
+		{name: "cycle", expression: "prop:cycle.a", wantErr: true},
+		{name: "all keys with cycle", expression: "prop:", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(properties), "text/x-java-properties", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestDotEnvQuery(t *testing.T) {
+	const env = "# Local settings\r\n" +
+		"export DB_HOST=localhost\n" +
+		"DB_PORT = 5432 # default port\n" +
+		"DB_URL=\"postgres://${DB_HOST}:${DB_PORT}/app\"\n" +
+		"PASSWORD='p@ss ${not} #kept'\n" +
+		"MOTD=\"line one\nline \\\"two\\\"\"\n" +
+		"ESCAPED=\"a\\tb\\n\"\n" +
+		"EMPTY=\n"
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+	}{
+		{name: "export prefix", expression: "prop:DB_HOST", want: "localhost"},
+		{name: "inline comment", expression: "prop:DB_PORT", want: "5432"},
+		{name: "interpolated double quotes", expression: "prop:DB_URL", want: "postgres://localhost:5432/app"},
+		{name: "literal single quotes", expression: "prop:PASSWORD", want: "p@ss ${not} #kept"},
+		{name: "multi-line double quotes", expression: "prop:MOTD", want: "line one\nline \"two\""},
+		{name: "escapes", expression: "prop:ESCAPED", want: "a\tb\n"},
+		{name: "empty", expression: "prop:EMPTY", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(env), "text/x-dotenv", NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPropertiesPayloadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		dialect PropertiesDialect
+		wantErr string
+	}{
+		{name: "bad unicode escape", content: `a = \u00zz`, dialect: JavaProperties, wantErr: "invalid value for key 'a' on line 1"},
+		{name: "truncated unicode escape", content: `a\u00 = 1`, dialect: JavaProperties, wantErr: "invalid key on line 1"},
+		{name: "dotenv without equals", content: "A=1\nB", dialect: DotEnv, wantErr: "invalid line 2"},
+		{name: "dotenv key with space", content: "A B=1", dialect: DotEnv, wantErr: "invalid line 1"},
+		{name: "unterminated single quote", content: "A='x", dialect: DotEnv, wantErr: "unterminated single-quoted value for key 'A'"},
+		{name: "unterminated double quote", content: "A=\"x\nB=2", dialect: DotEnv, wantErr: "unterminated double-quoted value for key 'A'"},
+		{name: "unknown dialect", content: "", dialect: PropertiesDialect(9), wantErr: "unknown properties dialect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPropertiesPayload([]byte(tt.content), tt.dialect)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
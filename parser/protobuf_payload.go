@@ -0,0 +1,237 @@
+package parser
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tidwall/gjson"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// DescriptorRegistry holds the protobuf file descriptors used to decode
+// ProtobufPayloads. It is safe for concurrent use.
+type DescriptorRegistry struct {
+	mu    sync.RWMutex
+	files *protoregistry.Files
+}
+
+func NewDescriptorRegistry() *DescriptorRegistry {
+	return &DescriptorRegistry{files: new(protoregistry.Files)}
+}
+
+// RegisterFileDescriptorSet adds every file of a FileDescriptorSet (as produced by
+// `protoc --descriptor_set_out --include_imports`) to the registry.
+// Imports must either be part of the set or already registered.
+func (dr *DescriptorRegistry) RegisterFileDescriptorSet(set *descriptorpb.FileDescriptorSet) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	// Resolve against the existing files plus the new set so files may depend on each other
+	pending := make(map[string]*descriptorpb.FileDescriptorProto, len(set.GetFile()))
+	for _, fd := range set.GetFile() {
+		pending[fd.GetName()] = fd
+	}
+	var register func(name string) error
+	register = func(name string) error {
+		fd, ok := pending[name]
+		if !ok {
+			return nil // Already registered, or left for protodesc to report as missing
+		}
+		delete(pending, name)
+		for _, dep := range fd.GetDependency() {
+			if err := register(dep); err != nil {
+				return err
+			}
+		}
+		if _, err := dr.files.FindFileByPath(name); err == nil {
+			return nil
+		}
+		file, err := protodesc.NewFile(fd, dr.files)
+		if err != nil {
+			return fmt.Errorf("invalid file descriptor %s: %w", name, err)
+		}
+		return dr.files.RegisterFile(file)
+	}
+	for _, fd := range set.GetFile() {
+		if err := register(fd.GetName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterFileDescriptorSetBytes decodes a serialized FileDescriptorSet and registers it.
+func (dr *DescriptorRegistry) RegisterFileDescriptorSetBytes(raw []byte) error {
+	set := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(raw, set); err != nil {
+		return fmt.Errorf("invalid FileDescriptorSet: %w", err)
+	}
+	return dr.RegisterFileDescriptorSet(set)
+}
+
+// FindMessage looks up a message descriptor by its fully-qualified name (e.g. "com.acme.Order").
+func (dr *DescriptorRegistry) FindMessage(messageType string) (protoreflect.MessageDescriptor, error) {
+	dr.mu.RLock()
+	defer dr.mu.RUnlock()
+	desc, err := dr.files.FindDescriptorByName(protoreflect.FullName(messageType))
+	if err != nil {
+		return nil, fmt.Errorf("unknown protobuf message type %s: %w", messageType, err)
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a protobuf message type", messageType)
+	}
+	return msgDesc, nil
+}
+
+// resolver returns a type resolver for google.protobuf.Any contents, backed by
+// the registered files and falling back to the globally linked types.
+func (dr *DescriptorRegistry) resolver() protoregistry.MessageTypeResolver {
+	return registryResolver{dr: dr}
+}
+
+// registryResolver looks up message types under the registry lock, since
+// protoregistry.Files is not safe for concurrent registration and lookup.
+type registryResolver struct {
+	dr *DescriptorRegistry
+}
+
+func (rr registryResolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	rr.dr.mu.RLock()
+	mt, err := dynamicpb.NewTypes(rr.dr.files).FindMessageByName(name)
+	rr.dr.mu.RUnlock()
+	if err != nil {
+		return protoregistry.GlobalTypes.FindMessageByName(name)
+	}
+	return mt, nil
+}
+
+func (rr registryResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	rr.dr.mu.RLock()
+	mt, err := dynamicpb.NewTypes(rr.dr.files).FindMessageByURL(url)
+	rr.dr.mu.RUnlock()
+	if err != nil {
+		return protoregistry.GlobalTypes.FindMessageByURL(url)
+	}
+	return mt, nil
+}
+
+// ProtobufEncoding identifies how a protobuf message is serialized.
+type ProtobufEncoding int
+
+const (
+	ProtobufBinary ProtobufEncoding = iota // Wire format
+	ProtobufText                           // Text format (textproto)
+	ProtobufJSON                           // Protobuf JSON mapping
+)
+
+// ProtobufPayload handles protobuf data decoded into a dynamic message.
+// Binary, text-format and JSON bodies all decode to the same message, so one
+// set of expressions handles every encoding. Queries use gjson paths over the
+// protobuf JSON mapping of the message, keyed by the field names declared in
+// the .proto file (e.g. "items.0.sku"). As in protojson, 64-bit integers are
+// strings, enums are value names, bytes are base64, and well-known types use
+// their JSON forms (Timestamp as RFC 3339, Duration as "1.5s", wrappers as
+// their plain value).
+type ProtobufPayload struct {
+	rawContent  []byte
+	message     *dynamicpb.Message
+	resolver    protoregistry.MessageTypeResolver // Resolves google.protobuf.Any contents
+	contentType string
+
+	viewOnce   sync.Once
+	jsonResult gjson.Result
+	viewErr    error
+}
+
+// NewProtobufPayload decodes binary content as a message of the given descriptor.
+// google.protobuf.Any fields are resolved against the globally linked types.
+func NewProtobufPayload(content []byte, descriptor protoreflect.MessageDescriptor) (*ProtobufPayload, error) {
+	return newProtobufPayload(content, descriptor, protoregistry.GlobalTypes, ProtobufBinary)
+}
+
+// NewProtobufPayloadWithEncoding decodes content serialized with the given
+// encoding as a message of the given descriptor.
+func NewProtobufPayloadWithEncoding(content []byte, descriptor protoreflect.MessageDescriptor, encoding ProtobufEncoding) (*ProtobufPayload, error) {
+	return newProtobufPayload(content, descriptor, protoregistry.GlobalTypes, encoding)
+}
+
+func newProtobufPayload(content []byte, descriptor protoreflect.MessageDescriptor, resolver protoregistry.MessageTypeResolver, encoding ProtobufEncoding) (*ProtobufPayload, error) {
+	message := dynamicpb.NewMessage(descriptor)
+	var err error
+	switch encoding {
+	case ProtobufText:
+		err = prototext.UnmarshalOptions{Resolver: protoResolver(resolver)}.Unmarshal(content, message)
+	case ProtobufJSON:
+		err = protojson.UnmarshalOptions{Resolver: protoResolver(resolver)}.Unmarshal(content, message)
+	default:
+		err = proto.Unmarshal(content, message)
+	}
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: fmt.Sprintf("protobuf decoding failed for message type %s", descriptor.FullName()), InnerError: err}
+	}
+	return &ProtobufPayload{
+		rawContent:  content,
+		message:     message,
+		resolver:    resolver,
+		contentType: "application/x-protobuf",
+	}, nil
+}
+
+func (pp *ProtobufPayload) GetRawBytes() []byte {
+	return pp.rawContent
+}
+
+func (pp *ProtobufPayload) GetContentType() string {
+	return pp.contentType
+}
+
+// MessageType returns the fully-qualified name of the decoded message.
+func (pp *ProtobufPayload) MessageType() string {
+	return string(pp.message.Descriptor().FullName())
+}
+
+// Query evaluates a gjson path against the decoded message.
+func (pp *ProtobufPayload) Query(expression string) (QueryResult, error) {
+	pp.viewOnce.Do(func() {
+		jsonView, err := protojson.MarshalOptions{UseProtoNames: true, Resolver: protoResolver(pp.resolver)}.Marshal(pp.message)
+		if err != nil {
+			pp.viewErr = &ErrEvaluationFailed{Reason: "failed to build view of protobuf message", InnerError: err}
+			return
+		}
+		pp.jsonResult = gjson.ParseBytes(jsonView)
+	})
+	if pp.viewErr != nil {
+		return QueryResult{}, pp.viewErr
+	}
+	return queryJSONView(pp.jsonResult, expression)
+}
+
+// protoResolver adapts a message resolver to the interface the protojson and
+// prototext codecs expect. Extensions are not supported by dynamic messages
+// decoded here.
+func protoResolver(resolver protoregistry.MessageTypeResolver) interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+} {
+	return struct {
+		protoregistry.MessageTypeResolver
+		protoregistry.ExtensionTypeResolver
+	}{resolver, protoregistry.GlobalTypes}
+}
+
+// AsString returns the message in protobuf text format, whatever encoding it arrived in.
+func (pp *ProtobufPayload) AsString() (string, error) {
+	return prototext.Format(pp.message), nil
+}
+
+func (pp *ProtobufPayload) GetUnderlying() interface{} {
+	return pp.message
+}
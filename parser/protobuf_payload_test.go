@@ -0,0 +1,332 @@
+package parser
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func protoField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Type:   typ.Enum(),
+		Label:  label.Enum(),
+	}
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+// testOrderDescriptorSet returns common.proto (Item, Status) and order.proto
+// (Order, importing common.proto and well-known types), with order.proto listed
+// before its dependencies.
+func testOrderDescriptorSet() *descriptorpb.FileDescriptorSet {
+	common := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("acme/common.proto"),
+		Package: proto.String("com.acme"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Item"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoField("sku", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+				protoField("qty", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", false),
+			},
+		}},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("STATUS_UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("STATUS_SHIPPED"), Number: proto.Int32(1)},
+			},
+		}},
+	}
+	order := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("acme/order.proto"),
+		Package: proto.String("com.acme"),
+		Syntax:  proto.String("proto3"),
+		Dependency: []string{
+			"acme/common.proto",
+			"google/protobuf/timestamp.proto",
+			"google/protobuf/duration.proto",
+			"google/protobuf/wrappers.proto",
+		},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Order"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				protoField("order_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+				protoField("amount_micros", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", false),
+				protoField("status", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".com.acme.Status", false),
+				protoField("items", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".com.acme.Item", true),
+				protoField("created_at", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp", false),
+				protoField("ttl", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Duration", false),
+				protoField("priority", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Int64Value", false),
+				protoField("signature", 8, descriptorpb.FieldDescriptorProto_TYPE_BYTES, "", false),
+			},
+		}},
+	}
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		order,
+		protodesc.ToFileDescriptorProto(wrapperspb.File_google_protobuf_wrappers_proto),
+		common,
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		protodesc.ToFileDescriptorProto(durationpb.File_google_protobuf_duration_proto),
+	}}
+}
+
+func TestDescriptorRegistryRegisterFileDescriptorSet(t *testing.T) {
+	full := testOrderDescriptorSet()
+	tests := []struct {
+		name    string
+		sets    []*descriptorpb.FileDescriptorSet
+		wantErr bool
+	}{
+		{name: "dependencies listed after dependents", sets: []*descriptorpb.FileDescriptorSet{full}},
+		{name: "registering the same set twice", sets: []*descriptorpb.FileDescriptorSet{full, full}},
+		{
+			name: "dependencies registered by an earlier set",
+			sets: []*descriptorpb.FileDescriptorSet{
+				{File: full.GetFile()[1:]},
+				{File: full.GetFile()[:1]},
+			},
+		},
+		{name: "missing dependency", sets: []*descriptorpb.FileDescriptorSet{{File: full.GetFile()[:1]}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewDescriptorRegistry()
+			var err error
+			for _, set := range tt.sets {
+				if err = registry.RegisterFileDescriptorSet(set); err != nil {
+					break
+				}
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := registry.FindMessage("com.acme.Order"); err != nil {
+				t.Errorf("FindMessage: %v", err)
+			}
+		})
+	}
+}
+
+func TestDescriptorRegistryFindMessage(t *testing.T) {
+	registry := NewDescriptorRegistry()
+	if err := registry.RegisterFileDescriptorSet(testOrderDescriptorSet()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"com.acme.Missing", "com.acme.Status"} {
+		if _, err := registry.FindMessage(name); err == nil {
+			t.Errorf("FindMessage(%s): expected an error", name)
+		}
+	}
+}
+
+func TestProtobufPayloadQuery(t *testing.T) {
+	setBytes, err := proto.Marshal(testOrderDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine()
+	if err := engine.RegisterDescriptorSet(setBytes); err != nil {
+		t.Fatal(err)
+	}
+	descriptor, err := engine.Descriptors().FindMessage("com.acme.Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := dynamicpb.NewMessage(descriptor)
+	if err := protojson.Unmarshal([]byte(`{
+		"order_id": "A-1",
+		"amount_micros": "9007199254740993",
+		"status": "STATUS_SHIPPED",
+		"items": [{"sku": "pen", "qty": 2}, {"sku": "ink", "qty": 1}],
+		"created_at": "2024-01-02T03:04:05Z",
+		"ttl": "1.500s",
+		"priority": "5",
+		"signature": "AQID"
+	}`), message); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewProtobufMessageContext(raw, "com.acme.Order", engine)
+
+	tests := []struct {
+		expression string
+		want       interface{}
+	}{
+		{"protopath:order_id", "A-1"},
+		{"protopath:amount_micros", "9007199254740993"},
+		{"protopath:status", "STATUS_SHIPPED"},
+		{"protopath:items.1.qty", float64(1)},
+		{"protopath:items.#.sku", []interface{}{"pen", "ink"}},
+		{"protopath:created_at", "2024-01-02T03:04:05Z"},
+		{"protopath:ttl", "1.500s"},
+		{"protopath:priority", "5"},
+		{"protopath:signature", "AQID"},
+	}
+	for _, tt := range tests {
+		result, err := msgCtx.EvaluateExpression(tt.expression)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.expression, err)
+			continue
+		}
+		if !reflect.DeepEqual(result.Value, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.expression, result.Value, tt.want)
+		}
+	}
+}
+
+func TestProtobufPayloadErrors(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.Descriptors().RegisterFileDescriptorSet(testOrderDescriptorSet()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		raw         []byte
+		contentType string
+	}{
+		{name: "no message type", raw: nil, contentType: "application/x-protobuf"},
+		{name: "unknown message type", raw: nil, contentType: "application/x-protobuf; messageType=com.acme.Missing"},
+		{name: "invalid wire data", raw: []byte{0xff, 0xff}, contentType: "application/x-protobuf; messageType=com.acme.Order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMessageContext(tt.raw, tt.contentType, engine).EvaluateExpression("protopath:order_id"); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestProtobufPayloadEncodings(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.Descriptors().RegisterFileDescriptorSet(testOrderDescriptorSet()); err != nil {
+		t.Fatal(err)
+	}
+	const textBody = `order_id: "A-1" amount_micros: 9007199254740993 status: STATUS_SHIPPED items { sku: "pen" qty: 2 } created_at { seconds: 1704164645 }`
+	const jsonBody = `{"orderId": "A-1", "amount_micros": "9007199254740993", "status": 1, "items": [{"sku": "pen", "qty": 2}], "created_at": "2024-01-02T03:04:05Z"}`
+
+	tests := []struct {
+		name        string
+		raw         string
+		contentType string
+		wantErr     bool
+	}{
+		{name: "textproto media type", raw: textBody, contentType: "text/x-protobuf; messageType=com.acme.Order"},
+		{name: "textproto alternate media type", raw: textBody, contentType: "application/x-protobuf-text; messageType=com.acme.Order"},
+		{name: "encoding parameter", raw: textBody, contentType: "application/x-protobuf; messageType=com.acme.Order; encoding=text"},
+		{name: "proto-JSON media type", raw: jsonBody, contentType: "application/x-protobuf+json; messageType=com.acme.Order"},
+		{name: "proto-JSON encoding parameter", raw: jsonBody, contentType: "application/protobuf; proto=com.acme.Order; encoding=json"},
+		{name: "unknown encoding", raw: jsonBody, contentType: "application/x-protobuf; messageType=com.acme.Order; encoding=xml", wantErr: true},
+		{name: "unknown text field", raw: `nope: 1`, contentType: "text/x-protobuf; messageType=com.acme.Order", wantErr: true},
+		{name: "invalid JSON", raw: `{"order_id": 1}`, contentType: "application/x-protobuf+json; messageType=com.acme.Order", wantErr: true},
+	}
+	expressions := map[string]interface{}{
+		"protopath:order_id":      "A-1",
+		"protopath:amount_micros": "9007199254740993",
+		"protopath:status":        "STATUS_SHIPPED",
+		"protopath:items.0.qty":   float64(2),
+		"protopath:created_at":    "2024-01-02T03:04:05Z",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(tt.raw), tt.contentType, engine)
+			for expression, want := range expressions {
+				result, err := msgCtx.EvaluateExpression(expression)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("expected an error, got %v", result.Value)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", expression, err)
+				}
+				if !reflect.DeepEqual(result.Value, want) {
+					t.Errorf("%s = %#v, want %#v", expression, result.Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractAsProtobufPipe(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.Descriptors().RegisterFileDescriptorSet(testOrderDescriptorSet()); err != nil {
+		t.Fatal(err)
+	}
+	descriptor, err := engine.Descriptors().FindMessage("com.acme.Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := dynamicpb.NewMessage(descriptor)
+	if err := protojson.Unmarshal([]byte(`{"order_id": "A-1", "status": "STATUS_SHIPPED", "items": [{"sku": "pen", "qty": 2}]}`), message); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := fmt.Sprintf(`{"standard": %q, "urlSafe": %q, "text": "plain"}`,
+		base64.StdEncoding.EncodeToString(raw), base64.RawURLEncoding.EncodeToString(raw))
+	msgCtx := NewMessageContext([]byte(envelope), "application/json", engine)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "standard base64", expression: `jsonpath:standard | extractAsProtobuf("com.acme.Order") | protopath:order_id`, want: "A-1"},
+		{name: "URL-safe base64", expression: `jsonpath:urlSafe | extractAsProtobuf("com.acme.Order") | protopath:status`, want: "STATUS_SHIPPED"},
+		{name: "decoded bytes", expression: `jsonpath:standard | base64Decode | extractAsProtobuf("com.acme.Order") | protopath:items.0.qty`, want: float64(2)},
+		{name: "unknown message type", expression: `jsonpath:standard | extractAsProtobuf("com.acme.Missing") | protopath:order_id`, wantErr: true},
+		{name: "not wire data", expression: `jsonpath:text | extractAsProtobuf("com.acme.Order") | protopath:order_id`, wantErr: true},
+		{name: "empty message type", expression: `jsonpath:standard | extractAsProtobuf("")`, wantErr: true},
+		{name: "missing message type", expression: `jsonpath:standard | extractAsProtobuf`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %#v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
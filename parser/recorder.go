@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Recording is one captured evaluation, stored as a line of JSON.
+type Recording struct {
+	PayloadHash string        `json:"payloadHash"` // Hex SHA-256 of the raw payload
+	ContentType string        `json:"contentType"`
+	Encoding    string        `json:"contentEncoding,omitempty"` // Content-Encoding of a compressed payload
+	Payload     []byte        `json:"payload,omitempty"`         // Only kept with RecorderOptions.IncludePayloads
+	Expression  string        `json:"expression"`
+	Result      *QueryResult  `json:"result,omitempty"` // Nil when evaluation failed
+	Error       string        `json:"error,omitempty"`
+	Duration    time.Duration `json:"durationNs"`
+}
+
+// RecorderOptions configures a Recorder.
+type RecorderOptions struct {
+	// IncludePayloads records raw payloads as well as their hash, so that
+	// Replay can re-run the recordings. Payloads often contain personal data
+	// or secrets, which then end up in the recording.
+	IncludePayloads bool
+}
+
+// Recorder captures evaluations made by an engine (see SetRecorder) as JSON
+// lines. It is safe for concurrent use.
+//
+// By default only a hash of each payload is recorded, and Replay skips such
+// recordings. Set RecorderOptions.IncludePayloads to record the payloads
+// themselves; the recording must then be protected like the messages it
+// copies.
+type Recorder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	options RecorderOptions
+	err     error
+}
+
+// NewRecorder creates a Recorder writing to w.
+func NewRecorder(w io.Writer, options RecorderOptions) *Recorder {
+	return &Recorder{encoder: json.NewEncoder(w), options: options}
+}
+
+// record writes one evaluation. Write errors are kept and reported by Err, so
+// that recording never fails an evaluation.
+func (r *Recorder) record(raw []byte, contentType, contentEncoding, expression string, result QueryResult, evalErr error, duration time.Duration) {
+	hash := sha256.Sum256(raw)
+	rec := Recording{
+		PayloadHash: hex.EncodeToString(hash[:]),
+		ContentType: contentType,
+		Encoding:    contentEncoding,
+		Expression:  expression,
+		Duration:    duration,
+	}
+	if r.options.IncludePayloads {
+		rec.Payload = raw
+	}
+	if evalErr != nil {
+		rec.Error = evalErr.Error()
+	} else {
+		rec.Result = &result
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := r.encoder.Encode(rec); err != nil {
+		r.err = err
+	}
+}
+
+// Err returns the first error encountered while writing recordings.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// ReplayDiff describes a recording whose replayed outcome differs from the
+// recorded one.
+type ReplayDiff struct {
+	Recording Recording
+	Result    *QueryResult // Nil when the replay failed
+	Error     string
+	Reason    string
+}
+
+// ReplayReport summarizes a replay.
+type ReplayReport struct {
+	Total    int // Recordings read
+	Replayed int
+	Skipped  int // Recordings without a payload
+	Diffs    []ReplayDiff
+}
+
+// Replay re-evaluates the recordings read from r with engine and reports
+// semantic differences: a changed result type or value (compared after JSON
+// normalization, as recorded), or an evaluation that now fails or now
+// succeeds. Differing error messages alone are not reported.
+func Replay(ctx context.Context, r io.Reader, engine *ExpressionEngine) (*ReplayReport, error) {
+	report := &ReplayReport{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Recording
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return report, fmt.Errorf("invalid recording on line %d: %w", line, err)
+		}
+		report.Total++
+		if rec.Payload == nil {
+			report.Skipped++
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Replayed++
+
+		result, err := NewEncodedMessageContext(rec.Payload, rec.ContentType, rec.Encoding, engine).EvaluateExpressionContext(ctx, rec.Expression)
+		if diff, ok := compareReplay(rec, result, err); !ok {
+			report.Diffs = append(report.Diffs, diff)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// compareReplay compares a replayed outcome with a recording.
+func compareReplay(rec Recording, result QueryResult, err error) (ReplayDiff, bool) {
+	diff := ReplayDiff{Recording: rec}
+	if err != nil {
+		diff.Error = err.Error()
+	} else {
+		diff.Result = &result
+	}
+
+	switch {
+	case rec.Result == nil && err == nil:
+		diff.Reason = "recorded evaluation failed but replay succeeded"
+	case rec.Result != nil && err != nil:
+		diff.Reason = "recorded evaluation succeeded but replay failed"
+	case rec.Result == nil:
+		return diff, true
+	case rec.Result.Type != result.Type:
+		diff.Reason = fmt.Sprintf("result type changed from %s to %s", rec.Result.Type, result.Type)
+	case !sameResult(*rec.Result, result):
+		diff.Reason = "result value changed"
+	default:
+		return diff, true
+	}
+	return diff, false
+}
+
+// sameResult reports whether two results have the same type and, after JSON
+// normalization, the same value.
+func sameResult(a, b QueryResult) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	aValue, errA := normalizeResultValue(a.Value)
+	bValue, errB := normalizeResultValue(b.Value)
+	return errA == nil && errB == nil && reflect.DeepEqual(aValue, bValue)
+}
+
+// normalizeResultValue round-trips a value through JSON so recorded and live
+// values compare alike (e.g. []string and []interface{}).
+func normalizeResultValue(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(normalizeJSONValue(v))
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(raw, &out)
+	return out, err
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf, RecorderOptions{IncludePayloads: true})
+	engine := NewEngine()
+	engine.SetRecorder(recorder)
+
+	evaluations := []struct {
+		raw, contentType, expression string
+	}{
+		{raw: `{"a": "x", "n": [1, 2]}`, contentType: "application/json; charset=utf-8", expression: "jsonpath:a"},
+		{raw: `{"a": "x", "n": [1, 2]}`, contentType: "application/json", expression: "jsonpath:n"},
+		{raw: `<r><b>1</b><b>2</b></r>`, contentType: "application/xml", expression: "xpath://b"},
+		{raw: `{"a": "x"}`, contentType: "application/json", expression: "jsonpath:missing"},
+		{raw: `{`, contentType: "application/json", expression: "jsonpath:a"},
+	}
+	for _, e := range evaluations {
+		_, _ = NewMessageContext([]byte(e.raw), e.contentType, engine).EvaluateExpression(e.expression)
+	}
+	payload, err := NewJSONPayload([]byte(`{"direct": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Evaluate(payload, "jsonpath:direct"); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(evaluations)+1 {
+		t.Fatalf("got %d recordings, want %d", len(lines), len(evaluations)+1)
+	}
+	var first Recording
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.ContentType != "application/json; charset=utf-8" || first.Result == nil || first.Result.Value != "x" || len(first.PayloadHash) != 64 {
+		t.Errorf("unexpected recording %+v", first)
+	}
+
+	// A tampered recording: the replayed result no longer matches
+	tampered := strings.Replace(lines[0], `"value":"x"`, `"value":"y"`, 1)
+	retyped := strings.Replace(lines[1], `"type":"array"`, `"type":"string"`, 1)
+	nowFails := strings.Replace(lines[0], `"expression":"jsonpath:a"`, `"expression":"jsonpath:zzz"`, 1)
+	nowSucceeds := strings.Replace(lines[3], `"expression":"jsonpath:missing"`, `"expression":"jsonpath:a"`, 1)
+
+	tests := []struct {
+		name         string
+		input        string
+		wantReplayed int
+		wantSkipped  int
+		wantReasons  []string
+	}{
+		{name: "unchanged engine", input: buf.String(), wantReplayed: 6},
+		{name: "changed value", input: tampered, wantReplayed: 1, wantReasons: []string{"result value changed"}},
+		{name: "changed type", input: retyped, wantReplayed: 1, wantReasons: []string{"result type changed from string to array"}},
+		{name: "now fails", input: nowFails, wantReplayed: 1, wantReasons: []string{"recorded evaluation succeeded but replay failed"}},
+		{name: "now succeeds", input: nowSucceeds, wantReplayed: 1, wantReasons: []string{"recorded evaluation failed but replay succeeded"}},
+		{name: "payload omitted", input: `{"payloadHash": "00", "contentType": "application/json", "expression": "jsonpath:a"}`, wantSkipped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Replay(context.Background(), strings.NewReader(tt.input), NewEngine())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Replayed != tt.wantReplayed || report.Skipped != tt.wantSkipped {
+				t.Errorf("replayed %d, skipped %d; want %d, %d", report.Replayed, report.Skipped, tt.wantReplayed, tt.wantSkipped)
+			}
+			if len(report.Diffs) != len(tt.wantReasons) {
+				t.Fatalf("got diffs %+v, want reasons %v", report.Diffs, tt.wantReasons)
+			}
+			for i, diff := range report.Diffs {
+				if diff.Reason != tt.wantReasons[i] {
+					t.Errorf("diff %d reason = %q, want %q", i, diff.Reason, tt.wantReasons[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecorderPayloadsAreOptIn(t *testing.T) {
+	var buf bytes.Buffer
+	engine := NewEngine()
+	engine.SetRecorder(NewRecorder(&buf, RecorderOptions{}))
+	if _, err := NewMessageContext([]byte(`{"secret": 1}`), "application/json", engine).EvaluateExpression("jsonpath:secret"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), `"payload"`) {
+		t.Errorf("payload recorded without IncludePayloads: %s", buf.String())
+	}
+	report, err := Replay(context.Background(), &buf, NewEngine())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Replayed != 0 || report.Skipped != 1 {
+		t.Errorf("got %+v, want the recording skipped", report)
+	}
+}
+
+func TestReplayInvalidRecording(t *testing.T) {
+	if _, err := Replay(context.Background(), strings.NewReader("not json\n"), NewEngine()); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sniffContentType guesses the content type of raw from its leading bytes:
+// markup (HTML documents, or XML including SOAP envelopes), JSON or NDJSON,
+// YAML documents starting with a marker ("---" or "%YAML") and Parquet files.
+// It returns "" when raw matches none of them. A guess is only a candidate:
+// the caller still has to parse raw with it.
+func sniffContentType(raw []byte) string {
+	if len(raw) >= 12 && bytes.HasPrefix(raw, []byte(parquetMagic)) && bytes.HasSuffix(raw, []byte(parquetMagic)) {
+		return "application/vnd.apache.parquet"
+	}
+	content := bytes.TrimLeft(bytes.TrimPrefix(raw, []byte("\ufeff")), " \t\r\n")
+	if len(content) == 0 {
+		return ""
+	}
+	lowered := bytes.ToLower(content[:min(len(content), 16)])
+
+	switch {
+	case bytes.HasPrefix(lowered, []byte("<!doctype html")) || bytes.HasPrefix(lowered, []byte("<html")):
+		return "text/html"
+	case content[0] == '<':
+		return "text/xml" // Also detects SOAP 1.1 and 1.2 envelopes
+	case content[0] == '{' || content[0] == '[':
+		if json.Valid(content) {
+			return "application/json"
+		}
+		if isNDJSON(content) {
+			return "application/x-ndjson"
+		}
+	case bytes.HasPrefix(content, []byte("---")) || bytes.HasPrefix(content, []byte("%YAML")):
+		return "application/yaml"
+	}
+	return ""
+}
+
+// isNDJSON reports whether every non-blank line of content is a JSON value.
+func isNDJSON(content []byte) bool {
+	lines := 0
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return false
+		}
+		lines++
+	}
+	return lines > 1
+}
+
+// sniffEmbeddedFormat guesses the format of a result embedded in a payload,
+// for the extractAuto pipe: JSON, XML, a YAML mapping or sequence, or base64
+// of one of them. It returns the content to parse, decoded from base64 if it
+// was encoded, with its content type and the name of its format.
+func sniffEmbeddedFormat(text string) (content []byte, contentType, formatName string, err error) {
+	if contentType, formatName := sniffTextFormat([]byte(text)); contentType != "" {
+		return []byte(text), contentType, formatName, nil
+	}
+	if decoded, err := decodeBase64(text); err == nil {
+		if contentType, formatName := sniffTextFormat(decoded); contentType != "" {
+			return decoded, contentType, formatName, nil
+		}
+	}
+	return nil, "", "", errors.New("input is not JSON, XML, YAML or base64 of them")
+}
+
+// sniffTextFormat returns the content type and format name of content if it
+// looks like JSON, XML or a YAML mapping or sequence, or "" otherwise.
+func sniffTextFormat(content []byte) (contentType, formatName string) {
+	switch sniffContentType(content) {
+	case "application/json":
+		return "application/json", "JSON"
+	case "text/xml":
+		return "application/xml", "XML"
+	case "application/yaml":
+		return "application/yaml", "YAML"
+	}
+	// Without a document marker, only collections are taken as YAML: any
+	// other text is a valid YAML scalar
+	var document interface{}
+	if yaml.Unmarshal(content, &document) == nil {
+		switch document.(type) {
+		case map[string]interface{}, []interface{}:
+			return "application/yaml", "YAML"
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestContentSniffing(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		disabled    bool
+		wantType    string // Payload type, or "" for ErrUnsupportedContentType
+	}{
+		{name: "json object", content: `  {"id": 1}`, wantType: "*parser.JSONPayload"},
+		{name: "json array with bom", content: "\ufeff[1, 2]", contentType: "application/octet-stream", wantType: "*parser.JSONPayload"},
+		{name: "ndjson", content: "{\"id\":1}\n{\"id\":2}\n", wantType: "*parser.NDJSONPayload"},
+		{name: "xml", content: `<?xml version="1.0"?><order/>`, wantType: "*parser.XMLPayload"},
+		{name: "soap envelope", content: `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body/></soap:Envelope>`, wantType: "*parser.SOAPPayload"},
+		{name: "html", content: "<!DOCTYPE html><html><body><p>hi</p></body></html>", contentType: "application/octet-stream", wantType: "*parser.HTMLPayload"},
+		{name: "yaml document marker", content: "---\nkind: Deployment\n", wantType: "*parser.YAMLPayload"},
+		{name: "yaml directive", content: "%YAML 1.1\n---\na: 1\n", wantType: "*parser.YAMLPayload"},
+		{name: "invalid json is binary", content: `{"id": `, wantType: "*parser.BinaryPayload"},
+		{name: "malformed xml is binary", content: "<a><b></a>", contentType: "application/octet-stream", wantType: "*parser.BinaryPayload"},
+		{name: "plain text is binary", content: "hello", wantType: "*parser.BinaryPayload"},
+		{name: "png is binary", content: "\x89PNG\r\n\x1a\n", contentType: "application/octet-stream", wantType: "*parser.BinaryPayload"},
+		{name: "declared type wins", content: `{"id": 1}`, contentType: "text/plain", wantType: "*parser.TextPayload"},
+		{name: "disabled octet-stream", content: `{"id": 1}`, contentType: "application/octet-stream", disabled: true, wantType: "*parser.BinaryPayload"},
+		{name: "disabled empty type", content: `{"id": 1}`, disabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.SetContentSniffing(!tt.disabled)
+			payload, err := NewMessageContext([]byte(tt.content), tt.contentType, engine).GetProcessedPayload()
+			if tt.wantType == "" {
+				var unsupported *ErrUnsupportedContentType
+				if !errors.As(err, &unsupported) {
+					t.Fatalf("got %T, %v, want ErrUnsupportedContentType", payload, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fmt.Sprintf("%T", payload); got != tt.wantType {
+				t.Errorf("got %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestSniffedPayloadQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		expression string
+		want       interface{}
+	}{
+		{name: "json", content: `{"order": {"id": "A-1"}}`, expression: "jsonpath:order.id", want: "A-1"},
+		{name: "xml", content: "<order><id>A-2</id></order>", expression: "xpath:/order/id/text()", want: "A-2"},
+		{name: "yaml", content: "---\norder:\n  id: A-3\n", expression: "yamlpath:order.id", want: "A-3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(tt.content), "application/octet-stream", NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractAutoPipe(t *testing.T) {
+	msgCtx := NewMessageContext([]byte(`{
+		"json": "{\"order\": {\"id\": \"A-1\"}}",
+		"xml": "<order><id>A-2</id></order>",
+		"yaml": "order:\n  id: A-3\n",
+		"yamlMarker": "---\n- A-4\n",
+		"base64Json": "eyJvcmRlciI6IHsiaWQiOiAiQS01In19",
+		"base64Xml": "PG9yZGVyPjxpZD5BLTY8L2lkPjwvb3JkZXI-",
+		"order": {"id": "A-7"},
+		"plain": "hello",
+		"html": "<html><body>hi</body></html>",
+		"brokenXml": "<order><id>"
+	}`), "application/json", NewEngine())
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "json", expression: "jsonpath:json | extractAuto | jsonpath:order.id", want: "A-1"},
+		{name: "xml", expression: "jsonpath:xml | extractAuto | xpath:/order/id/text()", want: "A-2"},
+		{name: "yaml mapping", expression: "jsonpath:yaml | extractAuto | yamlpath:order.id", want: "A-3"},
+		{name: "yaml document marker", expression: "jsonpath:yamlMarker | extractAuto | yamlpath:0", want: "A-4"},
+		{name: "base64 json", expression: "jsonpath:base64Json | extractAuto | jsonpath:order.id", want: "A-5"},
+		{name: "url-safe base64 xml", expression: "jsonpath:base64Xml | extractAuto | xpath:/order/id/text()", want: "A-6"},
+		{name: "object result", expression: "jsonpath:order | extractAuto | jsonpath:id", want: "A-7"},
+		{name: "decoded text is the result", expression: "jsonpath:base64Json | extractAuto", want: `{"order": {"id": "A-5"}}`},
+		{name: "plain text", expression: "jsonpath:plain | extractAuto", wantErr: "input is not JSON, XML, YAML or base64 of them"},
+		{name: "html", expression: "jsonpath:html | extractAuto", wantErr: "input is not JSON, XML, YAML or base64 of them"},
+		{name: "malformed xml", expression: "jsonpath:brokenXml | extractAuto", wantErr: "failed to create intermediate XML payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
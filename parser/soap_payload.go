@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+)
+
+// SOAP envelope namespaces.
+const (
+	SOAP11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	SOAP12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// SOAPPayload handles SOAP 1.1 and 1.2 envelopes. XPath expressions can use
+//
+//	$body     the soapenv:Body element
+//	$header   the soapenv:Header element
+//
+// and the "soapenv" prefix, which is bound to the envelope namespace of the
+// document's SOAP version, e.g. "xpath:$body//order/id". Namespace prefixes
+// declared on the Envelope, Header and Body elements and on their child
+// elements (e.g. the operation element) are registered as well; the outermost
+// declaration of a prefix wins.
+type SOAPPayload struct {
+	*XMLPayload
+	version     string // "1.1" or "1.2"
+	envelope    *xmlquery.Node
+	namespaces  map[string]string // Prefix -> namespace URI, for compiling expressions
+	contentType string
+}
+
+// NewSOAPPayload parses a SOAP envelope. It fails if the document element is
+// not a SOAP 1.1 or 1.2 Envelope.
+func NewSOAPPayload(content []byte) (*SOAPPayload, error) {
+	xp, err := NewXMLPayload(content)
+	if err != nil {
+		return nil, err
+	}
+	return newSOAPPayload(xp)
+}
+
+// newSOAPPayload wraps a parsed XML document as a SOAP envelope.
+func newSOAPPayload(xp *XMLPayload) (*SOAPPayload, error) {
+	envelope := soapEnvelope(xp.parsedDoc)
+	if envelope == nil {
+		return nil, &ErrEvaluationFailed{Reason: "document is not a SOAP envelope"}
+	}
+	sp := &SOAPPayload{
+		XMLPayload: xp,
+		version:    "1.1",
+		envelope:   envelope,
+		namespaces: map[string]string{},
+	}
+	sp.contentType = "text/xml"
+	if envelope.NamespaceURI == SOAP12Namespace {
+		sp.version = "1.2"
+		sp.contentType = "application/soap+xml"
+	}
+	sp.namespaces["soapenv"] = envelope.NamespaceURI
+	sp.registerNamespaces(envelope)
+	for _, section := range []*xmlquery.Node{sp.Header(), sp.Body()} {
+		if section == nil {
+			continue
+		}
+		sp.registerNamespaces(section)
+		for n := section.FirstChild; n != nil; n = n.NextSibling {
+			if n.Type == xmlquery.ElementNode {
+				sp.registerNamespaces(n)
+			}
+		}
+	}
+	return sp, nil
+}
+
+// registerNamespaces adds the prefixes declared on element that are not yet known.
+func (sp *SOAPPayload) registerNamespaces(element *xmlquery.Node) {
+	for _, attr := range element.Attr {
+		if _, known := sp.namespaces[attr.Name.Local]; attr.Name.Space == "xmlns" && !known {
+			sp.namespaces[attr.Name.Local] = attr.Value
+		}
+	}
+}
+
+// soapEnvelope returns the document element if it is a SOAP Envelope.
+func soapEnvelope(doc *xmlquery.Node) *xmlquery.Node {
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type != xmlquery.ElementNode {
+			continue
+		}
+		if n.Data == "Envelope" && (n.NamespaceURI == SOAP11Namespace || n.NamespaceURI == SOAP12Namespace) {
+			return n
+		}
+		return nil
+	}
+	return nil
+}
+
+// GetContentType returns "text/xml" for SOAP 1.1 and "application/soap+xml"
+// for SOAP 1.2.
+func (sp *SOAPPayload) GetContentType() string {
+	return sp.contentType
+}
+
+// Version returns the SOAP version, "1.1" or "1.2".
+func (sp *SOAPPayload) Version() string {
+	return sp.version
+}
+
+// Header returns the Header element, or nil if the envelope has none.
+func (sp *SOAPPayload) Header() *xmlquery.Node {
+	return sp.envelopeChild("Header")
+}
+
+// Body returns the Body element, or nil if the envelope has none.
+func (sp *SOAPPayload) Body() *xmlquery.Node {
+	return sp.envelopeChild("Body")
+}
+
+func (sp *SOAPPayload) envelopeChild(name string) *xmlquery.Node {
+	for n := sp.envelope.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == xmlquery.ElementNode && n.Data == name && n.NamespaceURI == sp.envelope.NamespaceURI {
+			return n
+		}
+	}
+	return nil
+}
+
+// Query evaluates an XPath expression, expanding $body and $header.
+func (sp *SOAPPayload) Query(expression string) (QueryResult, error) {
+	return sp.QueryContext(context.Background(), expression)
+}
+
+// QueryContext is like Query but stops iterating over a node-set result once ctx is done.
+func (sp *SOAPPayload) QueryContext(ctx context.Context, expression string) (QueryResult, error) {
+	expanded := strings.NewReplacer(
+		"$body", "/soapenv:Envelope/soapenv:Body",
+		"$header", "/soapenv:Envelope/soapenv:Header",
+	).Replace(expression)
+	exprCompiled, err := xpath.CompileWithNS(expanded, sp.namespaces)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("XPath compilation failed for '%s'", expanded), InnerError: err}
+	}
+	return sp.evaluateXPath(ctx, exprCompiled, expression)
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	soap11Envelope = `<?xml version="1.0"?>
+<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/" xmlns:o="urn:orders">
+  <S:Header><o:auth><o:token>t-1</o:token></o:auth></S:Header>
+  <S:Body>
+    <order><id>42</id><line>a</line><line>b</line></order>
+  </S:Body>
+</S:Envelope>`
+
+	soap12Envelope = `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope">
+  <env:Body><o:order xmlns:o="urn:orders"><o:id>7</o:id></o:order></env:Body>
+</env:Envelope>`
+)
+
+func TestSOAPPayloadQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     string
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     bool
+	}{
+		{name: "body descendant", payload: soap11Envelope, contentType: "text/xml", expression: "xpath:$body//order/id", want: "42"},
+		{name: "body child", payload: soap11Envelope, contentType: "text/xml", expression: "xpath:$body/order/line", want: []string{"a", "b"}},
+		{name: "envelope prefix from document", payload: soap11Envelope, contentType: "text/xml", expression: "xpath:$header/o:auth/o:token", want: "t-1"},
+		{name: "soapenv prefix", payload: soap11Envelope, contentType: "text/xml", expression: "xpath:count(/soapenv:Envelope/soapenv:Body/*)", want: float64(1)},
+		{name: "soap 1.2", payload: soap12Envelope, contentType: "application/soap+xml; charset=utf-8", expression: "xpath:$body/o:order/o:id", want: "7"},
+		{name: "soap 1.2 sent as text/xml", payload: soap12Envelope, contentType: "text/xml", expression: "xpath:$body//o:id", want: "7"},
+		{name: "missing header selects nothing", payload: soap12Envelope, contentType: "application/soap+xml", expression: "xpath:$header/*", want: nil},
+		{name: "unknown prefix", payload: soap11Envelope, contentType: "text/xml", expression: "xpath:$body/x:order", wantErr: true},
+		{name: "pipe to json", payload: soap11Envelope, contentType: "text/xml", expression: `xpath:concat('{"id":', $body//id, '}') | extractAsJSON | jsonpath:id`, want: float64(42)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(tt.payload), tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestSOAPPayloadDetection(t *testing.T) {
+	tests := []struct {
+		name            string
+		payload         string
+		contentType     string
+		wantSOAP        bool
+		wantVersion     string
+		wantContentType string
+		wantErr         string
+	}{
+		{name: "soap 1.1", payload: soap11Envelope, contentType: "text/xml", wantSOAP: true, wantVersion: "1.1", wantContentType: "text/xml"},
+		{name: "soap 1.2", payload: soap12Envelope, contentType: "application/soap+xml", wantSOAP: true, wantVersion: "1.2", wantContentType: "application/soap+xml"},
+		{name: "plain text/xml", payload: "<order><id>1</id></order>", contentType: "text/xml", wantContentType: "application/xml"},
+		{name: "envelope in other namespace", payload: `<Envelope xmlns="urn:x"/>`, contentType: "text/xml", wantContentType: "application/xml"},
+		{name: "application/xml stays XML", payload: soap11Envelope, contentType: "application/xml", wantContentType: "application/xml"},
+		{name: "soap+xml without envelope", payload: "<order/>", contentType: "application/soap+xml", wantErr: "not a SOAP envelope"},
+		{name: "malformed", payload: "<S:Envelope", contentType: "text/xml", wantErr: "XML parsing failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pld, err := NewPayloadFactory().CreatePayload([]byte(tt.payload), tt.contentType)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sp, isSOAP := pld.(*SOAPPayload)
+			if isSOAP != tt.wantSOAP {
+				t.Fatalf("got %T", pld)
+			}
+			if isSOAP && sp.Version() != tt.wantVersion {
+				t.Errorf("got version %s, want %s", sp.Version(), tt.wantVersion)
+			}
+			if pld.GetContentType() != tt.wantContentType {
+				t.Errorf("got content type %s, want %s", pld.GetContentType(), tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestSOAPPayloadHeaderAndBody(t *testing.T) {
+	sp, err := NewSOAPPayload([]byte(soap11Envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Header() == nil || sp.Header().Data != "Header" {
+		t.Errorf("got header %v", sp.Header())
+	}
+	if sp.Body() == nil || sp.Body().SelectElement("order") == nil {
+		t.Errorf("got body %v", sp.Body())
+	}
+}
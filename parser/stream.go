@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"context"
+
+	"github.com/tidwall/gjson"
+)
+
+// EvaluateStream evaluates an expression against the message payload and
+// sends the elements of its result one at a time: the elements of an array,
+// the nodes of a node-set, or the result itself if it is neither. When the
+// final stage is a jsonpath: query selecting an array of a JSON payload or an
+// xpath: query selecting nodes of an XML payload, elements are sent as they
+// are read, without building the whole result. Absent results send nothing.
+//
+// Both channels are closed when the evaluation is done; errs receives at
+// most one error, after results is closed. Callers must drain results, or use
+// EvaluateStreamContext and cancel the context to stop early.
+func (mc *MessageContext) EvaluateStream(fullExpression string) (<-chan QueryResult, <-chan error) {
+	return mc.EvaluateStreamContext(context.Background(), fullExpression)
+}
+
+// EvaluateStreamContext is like EvaluateStream but honors the cancellation
+// and deadline of ctx, which also stops the evaluation when the caller no
+// longer reads results.
+func (mc *MessageContext) EvaluateStreamContext(ctx context.Context, fullExpression string) (<-chan QueryResult, <-chan error) {
+	results := make(chan QueryResult)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(results)
+		send := func(result QueryResult) bool {
+			select {
+			case results <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		result, err := mc.EvaluateExpressionContext(withFinalStage(ctx, finalStage{sink: send}), fullExpression)
+		if err == nil && !result.streamed {
+			err = sendElements(result, send)
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return results, errs
+}
+
+// sendElements sends the elements of a result that was not streamed as it
+// was evaluated.
+func sendElements(result QueryResult, send func(QueryResult) bool) error {
+	if result.Type == AbsentResult {
+		return nil
+	}
+	if result.Type != NodeSetResult && result.Type != ArrayResult {
+		send(result)
+		return nil
+	}
+	elements, err := collectionValues(result)
+	if err != nil {
+		return err
+	}
+	for i, value := range elements {
+		element := elementResult(value)
+		if result.nodes != nil {
+			element.nodes = result.nodes.subset([]int{i})
+		}
+		if !send(element) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// streamJSONArray sends the elements of array to sink until it returns false
+// or ctx is done.
+func streamJSONArray(ctx context.Context, array gjson.Result, expression string, sink func(QueryResult) bool) (QueryResult, error) {
+	var err error
+	array.ForEach(func(_, value gjson.Result) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		var element QueryResult
+		if element, err = convertGJSONResultContext(ctx, value, expression); err != nil {
+			return false
+		}
+		return sink(element)
+	})
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "JSON evaluation interrupted", InnerError: err}
+	}
+	return QueryResult{Value: nil, Type: ArrayResult, streamed: true}, nil
+}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateStream(t *testing.T) {
+	engine := NewEngine()
+	jsonCtx := NewMessageContext([]byte(`{"items": [{"sku": "A"}, {"sku": "B"}, {"sku": "C"}], "name": "Ann"}`), "application/json", engine)
+	xmlCtx := NewMessageContext([]byte(`<r><a>1</a><a>2</a></r>`), "application/xml", engine)
+
+	tests := []struct {
+		name       string
+		msgCtx     *MessageContext
+		expression string
+		want       []interface{}
+		wantErr    string
+	}{
+		{name: "JSON array", msgCtx: jsonCtx, expression: "jsonpath:items", want: []interface{}{map[string]interface{}{"sku": "A"}, map[string]interface{}{"sku": "B"}, map[string]interface{}{"sku": "C"}}},
+		{name: "mapped array", msgCtx: jsonCtx, expression: "jsonpath:items | map(jsonpath:sku)", want: []interface{}{"A", "B", "C"}},
+		{name: "scalar", msgCtx: jsonCtx, expression: "jsonpath:name", want: []interface{}{"Ann"}},
+		{name: "node-set", msgCtx: xmlCtx, expression: "xpath://a", want: []interface{}{"1", "2"}},
+		{name: "error", msgCtx: jsonCtx, expression: "jsonpath:missing", wantErr: "path not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, errs := tt.msgCtx.EvaluateStream(tt.expression)
+			var got []interface{}
+			for result := range results {
+				got = append(got, result.Value)
+			}
+			err := <-errs
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateStreamStopsWhenCanceled(t *testing.T) {
+	var items strings.Builder
+	for i := 0; i < 100000; i++ {
+		if i > 0 {
+			items.WriteByte(',')
+		}
+		fmt.Fprintf(&items, `{"n": %d}`, i)
+	}
+	msgCtx := NewMessageContext([]byte(`{"items": [`+items.String()+`]}`), "application/json", NewEngine())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results, errs := msgCtx.EvaluateStreamContext(ctx, "jsonpath:items")
+	first := <-results
+	cancel()
+	for range results {
+	}
+	if first.Value.(map[string]interface{})["n"] != 0.0 {
+		t.Errorf("first element = %v", first.Value)
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// transformString applies the string pipe call to s.
+func transformString(call pipeCall, s string) (string, error) {
+	switch call.name {
+	case trimPipe:
+		if len(call.args) == 0 {
+			return strings.TrimSpace(s), nil
+		}
+		cutset, err := stringArg(call, 0)
+		if err != nil {
+			return "", err
+		}
+		return strings.Trim(s, cutset), nil
+	case upperPipe:
+		return strings.ToUpper(s), nil
+	case lowerPipe:
+		return strings.ToLower(s), nil
+	case replacePipe:
+		old, err := stringArg(call, 0)
+		if err != nil {
+			return "", err
+		}
+		replacement, err := stringArg(call, 1)
+		if err != nil {
+			return "", err
+		}
+		if old == "" {
+			return "", fmt.Errorf("the string to replace is empty")
+		}
+		return strings.ReplaceAll(s, old, replacement), nil
+	case regexReplacePipe:
+		pattern, err := stringArg(call, 0)
+		if err != nil {
+			return "", err
+		}
+		replacement, err := stringArg(call, 1)
+		if err != nil {
+			return "", err
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, replacement), nil
+	}
+	return percentEncoding(call, s)
+}
+
+// percentEncoding applies urlEncode or urlDecode to s. The optional argument
+// selects query escaping ("query", the default: spaces are '+') or path
+// escaping ("path": spaces are "%20", '+' is kept).
+func percentEncoding(call pipeCall, s string) (string, error) {
+	mode := "query"
+	if len(call.args) > 0 {
+		var err error
+		if mode, err = stringArg(call, 0); err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case mode == "query" && call.name == urlEncodePipe:
+		return url.QueryEscape(s), nil
+	case mode == "query":
+		return url.QueryUnescape(s)
+	case mode == "path" && call.name == urlEncodePipe:
+		return url.PathEscape(s), nil
+	case mode == "path":
+		return url.PathUnescape(s)
+	}
+	return "", fmt.Errorf("unknown escaping %q: use \"query\" or \"path\"", mode)
+}
+
+// joinArray joins the elements of an array result with the separator of the
+// join pipe call. Elements that are not strings are written as JSON.
+func joinArray(call pipeCall, result QueryResult) (string, error) {
+	values, ok := result.Value.([]interface{})
+	if result.Type != ArrayResult || !ok {
+		return "", fmt.Errorf("requires an array, got %s", result.Type)
+	}
+	separator, err := stringArg(call, 0)
+	if err != nil {
+		return "", err
+	}
+	elements := make([]string, len(values))
+	for i, value := range values {
+		if s, ok := value.(string); ok {
+			elements[i] = s
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("element %d: %w", i, err)
+		}
+		elements[i] = string(raw)
+	}
+	return strings.Join(elements, separator), nil
+}
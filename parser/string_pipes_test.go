@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestURLPipes(t *testing.T) {
+	body := []byte(`{"callbackUrl":"state=a%2Fb&token=t%C3%A9st+42&x=1","search":"café & bar/1+1","path":"a%20b+c","bad":"100%","count":3}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "decode then regex", expression: "jsonpath:callbackUrl | urlDecode | regex:token=([^&]+)", want: "tést 42"},
+		{name: "decode query", expression: "jsonpath:callbackUrl | urlDecode", want: "state=a/b&token=tést 42&x=1"},
+		{name: "encode query", expression: "jsonpath:search | urlEncode", want: "caf%C3%A9+%26+bar%2F1%2B1"},
+		{name: "encode path", expression: `jsonpath:search | urlEncode("path")`, want: "caf%C3%A9%20&%20bar%2F1+1"},
+		{name: "decode path keeps plus", expression: `jsonpath:path | urlDecode("path")`, want: "a b+c"},
+		{name: "decode query plus is space", expression: `jsonpath:path | urlDecode("query")`, want: "a b c"},
+		{name: "round trip", expression: "jsonpath:search | urlEncode | urlDecode", want: "café & bar/1+1"},
+		{name: "invalid escape", expression: "jsonpath:bad | urlDecode", wantErr: "pipe operation 'urlDecode' failed"},
+		{name: "unknown mode", expression: `jsonpath:search | urlEncode("form")`, wantErr: `unknown escaping "form"`},
+		{name: "mode not a string", expression: "jsonpath:search | urlEncode(1)", wantErr: "must be a string"},
+		{name: "too many arguments", expression: `jsonpath:search | urlEncode("path", "query")`, wantErr: "takes 0 to 1 arguments"},
+		{name: "number input", expression: "jsonpath:count | urlEncode", wantErr: "requires string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != StringResult {
+				t.Errorf("got %#v (%s), want %#v", result.Value, result.Type, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringPipes(t *testing.T) {
+	body := []byte(`{"customer":{"name":"  Ada Lovelace \n","email":" Ada@Example.COM "},"sku":"ab_12_c","code":"**7**","tags":["a","b"],"count":3}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "trim whitespace", expression: "jsonpath:customer.name | trim", want: "Ada Lovelace"},
+		{name: "trim cutset", expression: `jsonpath:code | trim("*")`, want: "7"},
+		{name: "upper", expression: "jsonpath:sku | upper", want: "AB_12_C"},
+		{name: "lower after trim", expression: "jsonpath:customer.email | trim | lower", want: "ada@example.com"},
+		{name: "replace", expression: `jsonpath:sku | replace("_", "-")`, want: "ab-12-c"},
+		{name: "replace with nothing", expression: `jsonpath:sku | replace("_", "")`, want: "ab12c"},
+		{name: "replace comma", expression: `jsonpath:sku | replace("_", ", ")`, want: "ab, 12, c"},
+		{name: "array as json", expression: "jsonpath:tags | upper", want: `["A","B"]`},
+		{name: "then regex", expression: `jsonpath:sku | upper | regex:(\d+)`, want: "12"},
+		{name: "empty old", expression: `jsonpath:sku | replace("", "-")`, wantErr: "pipe operation 'replace' failed"},
+		{name: "replace needs two arguments", expression: `jsonpath:sku | replace("_")`, wantErr: "takes 2 argument(s), got 1"},
+		{name: "upper takes no arguments", expression: `jsonpath:sku | upper("x")`, wantErr: "takes no arguments"},
+		{name: "cutset not a string", expression: "jsonpath:code | trim(1)", wantErr: "must be a string"},
+		{name: "number input", expression: "jsonpath:count | trim", wantErr: "requires string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != StringResult {
+				t.Errorf("got %#v (%s), want %#v", result.Value, result.Type, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegexReplacePipe(t *testing.T) {
+	body := []byte(`{"phone":"+44 20 7946 0958","date":"2024-03-09","card":"4111111111111111","count":3}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "strip country code", expression: `jsonpath:phone | regexReplace("^\\+44\\s*", "0")`, want: "020 7946 0958"},
+		{name: "remove all matches", expression: `jsonpath:phone | regexReplace("\\D", "")`, want: "442079460958"},
+		{name: "numbered groups", expression: `jsonpath:date | regexReplace("(\\d+)-(\\d+)-(\\d+)", "$3/$2/$1")`, want: "09/03/2024"},
+		{name: "named group", expression: `jsonpath:card | regexReplace("^\\d{12}(?P<last>\\d{4})$", "****${last}")`, want: "****1111"},
+		{name: "single quotes", expression: `jsonpath:date | regexReplace('-', '.')`, want: "2024.03.09"},
+		{name: "no match", expression: `jsonpath:date | regexReplace("x", "y")`, want: "2024-03-09"},
+		{name: "then another pipe", expression: `jsonpath:phone | regexReplace("\\s", "") | substring(0, 3)`, want: "+44"},
+		{name: "invalid pattern", expression: `jsonpath:date | regexReplace("(", "")`, wantErr: "pipe operation 'regexReplace' failed"},
+		{name: "missing replacement", expression: `jsonpath:date | regexReplace("-")`, wantErr: "takes 2 argument(s), got 1"},
+		{name: "number input", expression: `jsonpath:count | regexReplace("3", "4")`, wantErr: "requires string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != StringResult {
+				t.Errorf("got %#v (%s), want %#v", result.Value, result.Type, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitAndJoinPipes(t *testing.T) {
+	body := []byte(`{"tags":"red, green ,blue","path":"a/b/c","ids":[7,"x",true,null,{"k":1}],"empty":"","name":"ada"}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantType   ResultType
+		wantErr    string
+	}{
+		{name: "split", expression: `jsonpath:path | split("/")`, want: []interface{}{"a", "b", "c"}, wantType: ArrayResult},
+		{name: "split keeps spaces", expression: `jsonpath:tags | split(",")`, want: []interface{}{"red", " green ", "blue"}, wantType: ArrayResult},
+		{name: "split without separator", expression: `jsonpath:path | split("-")`, want: []interface{}{"a/b/c"}, wantType: ArrayResult},
+		{name: "split empty", expression: `jsonpath:empty | split(",")`, want: []interface{}{""}, wantType: ArrayResult},
+		{name: "split into characters", expression: `jsonpath:name | split("")`, want: []interface{}{"a", "d", "a"}, wantType: ArrayResult},
+		{name: "split and join", expression: `jsonpath:path | split("/") | join("-")`, want: "a-b-c", wantType: StringResult},
+		{name: "split then query", expression: `jsonpath:path | split("/") | extractAsJSON | jsonpath:1`, want: "b", wantType: StringResult},
+		{name: "join mixed elements", expression: `jsonpath:ids | join(";")`, want: `7;x;true;null;{"k":1}`, wantType: StringResult},
+		{name: "join string", expression: `jsonpath:path | join("-")`, wantErr: "requires an array, got string"},
+		{name: "separator not a string", expression: "jsonpath:path | split(1)", wantErr: "must be a string"},
+		{name: "missing separator", expression: "jsonpath:path | split", wantErr: "takes 1 argument(s), got 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
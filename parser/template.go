@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// EvaluateTemplate renders template with each "{{expression}}" in it
+// replaced by the value of the expression against the payload of mc, e.g.
+// "Order {{jsonpath:id}} from {{xpath:/o/cust/name/text()}}". Expressions are
+// evaluated by this engine, with the computed fields of mc, and may be whole
+// pipelines; the first "}}" ends an expression. Values are rendered as the
+// toString pipe renders them; a result without a value (an empty node-set,
+// or an AbsentResult when strict mode is off) renders as an empty string.
+// A failing expression fails the template with an *ExpressionError that
+// gives its position among the template's expressions.
+func (ee *ExpressionEngine) EvaluateTemplate(mc *MessageContext, template string) (string, error) {
+	return ee.EvaluateTemplateContext(context.Background(), mc, template)
+}
+
+// EvaluateTemplateContext is like EvaluateTemplate but honors the
+// cancellation and deadline of ctx.
+func (ee *ExpressionEngine) EvaluateTemplateContext(ctx context.Context, mc *MessageContext, template string) (string, error) {
+	literals, expressions, err := parseTemplate(template)
+	if err != nil {
+		return "", err
+	}
+	payload, err := mc.GetProcessedPayload()
+	if err != nil {
+		return "", err
+	}
+	ctx = withComputedScope(ctx, mc, payload)
+
+	var b strings.Builder
+	for i, expression := range expressions {
+		b.WriteString(literals[i])
+		result, err := ee.evaluateRecorded(ctx, payload, ee.parse(expression))
+		if err != nil {
+			return "", &ExpressionError{Index: i, Expression: expression, Err: err}
+		}
+		if result.Value == nil {
+			continue
+		}
+		text, err := toText(result)
+		if err != nil {
+			return "", &ExpressionError{Index: i, Expression: expression, Err: err}
+		}
+		b.WriteString(text)
+	}
+	b.WriteString(literals[len(expressions)])
+	return b.String(), nil
+}
+
+// parseTemplate splits template into its expressions and the literal text
+// around them, one more literal than expressions.
+func parseTemplate(template string) (literals, expressions []string, err error) {
+	rest := template
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			return append(literals, rest), expressions, nil
+		}
+		end := strings.Index(rest[start+2:], "}}")
+		if end < 0 {
+			offset := len(template) - len(rest) + start
+			return nil, nil, &ErrEvaluationFailed{Expression: template, Reason: fmt.Sprintf("unclosed '{{' at offset %d", offset)}
+		}
+		expression := strings.TrimSpace(rest[start+2 : start+2+end])
+		if expression == "" {
+			offset := len(template) - len(rest) + start
+			return nil, nil, &ErrEvaluationFailed{Expression: template, Reason: fmt.Sprintf("empty expression at offset %d", offset)}
+		}
+		literals = append(literals, rest[:start])
+		expressions = append(expressions, expression)
+		rest = rest[start+2+end+2:]
+	}
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateTemplate(t *testing.T) {
+	body := []byte(`{"id": 42, "paid": true, "items": ["a", "b"], "cust": "<c><name>Ann</name></c>"}`)
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  string
+	}{
+		{name: "no expressions", template: "plain text", want: "plain text"},
+		{name: "values", template: "Order {{jsonpath:id}} paid={{ jsonpath:paid }} items={{jsonpath:items}}", want: `Order 42 paid=true items=["a","b"]`},
+		{name: "pipeline", template: "Order {{jsonpath:id}} from {{jsonpath:cust | extractAsXML | xpath:/c/name/text()}}", want: "Order 42 from Ann"},
+		{name: "URL", template: "https://api.example.com/orders/{{jsonpath:id}}?who={{jsonpath:cust | extractAsXML | xpath:/c/name/text() | urlEncode}}", want: "https://api.example.com/orders/42?who=Ann"},
+		{name: "computed field", template: "{{computed:total}}!", want: "2!"},
+		{name: "empty node-set", template: "[{{jsonpath:cust | extractAsXML | xpath:/c/missing}}]", want: "[]"},
+		{name: "failing expression", template: "{{jsonpath:id}} {{jsonpath:missing}}", wantErr: "expression 1 ('jsonpath:missing')"},
+		{name: "unclosed", template: "Order {{jsonpath:id", wantErr: "unclosed '{{' at offset 6"},
+		{name: "empty expression", template: "Order {{ }}", wantErr: "empty expression at offset 6"},
+	}
+
+	engine := NewEngine()
+	if err := engine.RegisterComputedField("total", "jsonpath:items.#"); err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext(body, "application/json", engine)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.EvaluateTemplate(msgCtx, tt.template)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateTemplateLenient(t *testing.T) {
+	engine := NewEngine(WithStrictMode(false))
+	msgCtx := NewMessageContext([]byte(`{"id": 1}`), "application/json", engine)
+	got, err := engine.EvaluateTemplate(msgCtx, "{{jsonpath:id}}-{{jsonpath:missing}}-{{jsonpath:missing | default(\"none\")}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "1--none" {
+		t.Errorf("got %q, want %q", got, "1--none")
+	}
+
+	var exprErr *ExpressionError
+	if _, err := engine.EvaluateTemplate(NewMessageContext([]byte(`{`), "application/json", engine), "{{jsonpath:id}}"); err == nil || errors.As(err, &exprErr) {
+		t.Errorf("got %v, want a payload error that is not an ExpressionError", err)
+	}
+}
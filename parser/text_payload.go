@@ -0,0 +1,113 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TextPayload handles plain text ("text/plain"). An optional YAML front-matter
+// block stays available through frontmatter: and body: expressions; text:
+// and regex: expressions work on the body.
+//
+// The text query language ("text:" expressions) is:
+//
+//	(empty)     the whole body
+//	lines       all lines, as an array
+//	lines[i]    the line at zero-based index i
+//
+// Regex queries ("regex:" expressions) return the first match of a Go regular
+// expression: the whole match when the pattern has no groups, the group when it
+// has one, and an array of groups otherwise.
+type TextPayload struct {
+	*FrontMatterPayload
+}
+
+// NewTextPayload creates a TextPayload, splitting off front matter if present.
+func NewTextPayload(content []byte) (*TextPayload, error) {
+	fp, err := newFrontMatterPayload(content, "text/plain")
+	if err != nil {
+		return nil, err
+	}
+	return &TextPayload{FrontMatterPayload: fp}, nil
+}
+
+// newPlainTextPayload wraps a pipeline string as text without looking for
+// front matter.
+func newPlainTextPayload(content string) *TextPayload {
+	return &TextPayload{FrontMatterPayload: &FrontMatterPayload{
+		rawContent:  []byte(content),
+		body:        content,
+		contentType: "text/plain",
+	}}
+}
+
+// Query evaluates a text query against the body.
+func (tp *TextPayload) Query(expression string) (QueryResult, error) {
+	expression = strings.TrimSpace(expression)
+	switch {
+	case expression == "":
+		return QueryResult{Value: tp.Body(), Type: StringResult}, nil
+	case expression == "lines":
+		lines := textLines(tp.Body())
+		values := make([]interface{}, len(lines))
+		for i, line := range lines {
+			values[i] = line
+		}
+		return QueryResult{Value: values, Type: ArrayResult}, nil
+	case strings.HasPrefix(expression, "lines[") && strings.HasSuffix(expression, "]"):
+		index, err := strconv.Atoi(expression[len("lines[") : len(expression)-1])
+		if err != nil || index < 0 {
+			return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "invalid line index"}
+		}
+		lines := textLines(tp.Body())
+		if index >= len(lines) {
+			return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("line %d not found: text has %d line(s)", index, len(lines)), InnerError: ErrNoMatch}
+		}
+		return QueryResult{Value: lines[index], Type: StringResult}, nil
+	}
+	return QueryResult{}, &ErrUnsupportedExpression{Expression: expression}
+}
+
+// QueryRegex returns the first match of pattern in the body.
+func (tp *TextPayload) QueryRegex(pattern string) (QueryResult, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: pattern, Reason: "regex compilation failed", InnerError: err}
+	}
+	return tp.queryRegex(re, pattern)
+}
+
+// queryRegex returns the first match of re, compiled from pattern, in the body.
+func (tp *TextPayload) queryRegex(re *regexp.Regexp, pattern string) (QueryResult, error) {
+	match := re.FindStringSubmatch(tp.Body())
+	if match == nil {
+		return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: pattern, Reason: "no match", InnerError: ErrNoMatch}
+	}
+	switch len(match) {
+	case 1:
+		return QueryResult{Value: match[0], Type: StringResult}, nil
+	case 2:
+		return QueryResult{Value: match[1], Type: StringResult}, nil
+	}
+	groups := make([]interface{}, len(match)-1)
+	for i, group := range match[1:] {
+		groups[i] = group
+	}
+	return QueryResult{Value: groups, Type: ArrayResult}, nil
+}
+
+// textLines splits text into lines, accepting "\n" and "\r\n" line endings.
+// A final line ending does not start another line.
+func textLines(text string) []string {
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+	if text == "" {
+		return []string{}
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTextPayloadQuery(t *testing.T) {
+	const logText = "INFO start\r\nWARN disk 91% full\nERROR order 1234 failed: {\"code\": 7}\n"
+	const withFrontMatter = "---\nsource: batch\n---\nline one\nline two"
+
+	tests := []struct {
+		name       string
+		content    string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "whole body", content: "hello", expression: "text:", want: "hello"},
+		{name: "lines", content: logText, expression: "text:lines", want: []interface{}{"INFO start", "WARN disk 91% full", "ERROR order 1234 failed: {\"code\": 7}"}},
+		{name: "line by index", content: logText, expression: "text:lines[1]", want: "WARN disk 91% full"},
+		{name: "no lines", content: "", expression: "text:lines", want: []interface{}{}},
+		{name: "line out of range", content: logText, expression: "text:lines[3]", wantErr: true},
+		{name: "invalid line index", content: logText, expression: "text:lines[x]", wantErr: true},
+		{name: "unknown text query", content: logText, expression: "text:words", wantErr: true},
+		{name: "regex whole match", content: logText, expression: `regex:\d+%`, want: "91%"},
+		{name: "regex single group", content: logText, expression: `regex:order (\d+)`, want: "1234"},
+		{name: "regex several groups", content: logText, expression: `regex:(\w+) order (\d+)`, want: []interface{}{"ERROR", "1234"}},
+		{name: "regex no match", content: logText, expression: `regex:FATAL`, wantErr: true},
+		{name: "invalid regex", content: logText, expression: `regex:(`, wantErr: true},
+		{name: "regex pipe", content: logText, expression: `text:lines[2] | regex:order (\d+)`, want: "1234"},
+		{name: "promote to JSON", content: logText, expression: `regex:failed: (\{.*\}) | extractAsJSON | jsonpath:code`, want: float64(7)},
+		{name: "front matter", content: withFrontMatter, expression: "frontmatter:source", want: "batch"},
+		{name: "text skips front matter", content: withFrontMatter, expression: "text:lines[0]", want: "line one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(tt.content), "text/plain", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextStagesOnOtherPayloads(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "regex pipe", expression: `jsonpath:message | regex:order (\d+)`, want: "42"},
+		{name: "text pipe", expression: `jsonpath:message | text:lines[0]`, want: "order 42 shipped"},
+		{name: "text query on JSON payload", expression: "text:", wantErr: true},
+		{name: "regex query on JSON payload", expression: "regex:order", wantErr: true},
+		{name: "regex no match with default", expression: `jsonpath:message | regex:y=(\d+) | default("none")`, want: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(`{"message": "order 42 shipped"}`), "application/json", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegexNoMatch(t *testing.T) {
+	_, err := NewMessageContext([]byte("INFO start"), "text/plain", NewEngine()).EvaluateExpression("regex:FATAL")
+	if !errors.Is(err, ErrNoMatch) {
+		t.Errorf("got %v, want an error wrapping ErrNoMatch", err)
+	}
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tidwall/gjson"
+)
+
+// TOMLPayload handles TOML documents.
+// The document is decoded into a JSON view, so it is queried with jsonpath
+// expressions (e.g. "servers.alpha.ip") and its tables can be piped into the
+// JSON transforms. Offset date-times appear as RFC 3339 strings; local
+// date-times, dates and times keep their TOML form ("1979-05-27T07:32:00",
+// "1979-05-27", "07:32:00").
+type TOMLPayload struct {
+	rawContent  []byte
+	document    map[string]interface{}
+	jsonResult  gjson.Result
+	contentType string
+
+	jsonValueCache // jsonResult decoded for JSONPath dual runs
+}
+
+// NewTOMLPayload decodes a TOML document.
+func NewTOMLPayload(content []byte) (*TOMLPayload, error) {
+	var document map[string]interface{}
+	if _, err := toml.Decode(string(content), &document); err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "TOML parsing failed", InnerError: err}
+	}
+	jsonView, err := marshalJSONView(tomlToJSONValue(document))
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert TOML document to JSON view", InnerError: err}
+	}
+	return &TOMLPayload{
+		rawContent:  content,
+		document:    document,
+		jsonResult:  gjson.ParseBytes(jsonView),
+		contentType: "application/toml",
+	}, nil
+}
+
+// tomlToJSONValue formats TOML date and time values for the JSON view. The
+// decoder returns local ones as time.Time values in marker locations, which
+// would otherwise be rendered with the machine's offset.
+func tomlToJSONValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = tomlToJSONValue(item)
+		}
+		return out
+	case []map[string]interface{}: // Arrays of tables
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = tomlToJSONValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = tomlToJSONValue(item)
+		}
+		return out
+	case time.Time:
+		switch val.Location().String() { // Zone names used by the decoder
+		case "datetime-local":
+			return val.Format("2006-01-02T15:04:05.999999999")
+		case "date-local":
+			return val.Format("2006-01-02")
+		case "time-local":
+			return val.Format("15:04:05.999999999")
+		}
+		return val.Format(time.RFC3339Nano)
+	default:
+		return val
+	}
+}
+
+func (tp *TOMLPayload) GetRawBytes() []byte {
+	return tp.rawContent
+}
+
+func (tp *TOMLPayload) GetContentType() string {
+	return tp.contentType
+}
+
+// Query evaluates a gjson path against the JSON view of the TOML document.
+func (tp *TOMLPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(tp.jsonResult, expression)
+}
+
+func (tp *TOMLPayload) jsonView() gjson.Result {
+	return tp.jsonResult
+}
+
+func (tp *TOMLPayload) AsString() (string, error) {
+	return string(tp.rawContent), nil
+}
+
+func (tp *TOMLPayload) GetUnderlying() interface{} {
+	return tp.document
+}
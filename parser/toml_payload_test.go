@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTOMLPayloadQuery(t *testing.T) {
+	const config = `
+title = "orders"
+retries = 3
+ratio = 0.5
+enabled = true
+created = 1979-05-27T07:32:00-08:00
+local = 1979-05-27T07:32:00
+day = 1979-05-27
+alarm = 07:32:00
+ports = [8000, 8001]
+
+[database]
+server = "192.168.1.1"
+settings = { timeout = "5s", pool = 10 }
+
+[[products]]
+name = "Hammer"
+sku = 738594937
+
+[[products]]
+name = "Nail"
+sku = 284758393
+note = '{"fragile": false}'
+`
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{name: "string", expression: "jsonpath:title", want: "orders"},
+		{name: "integer", expression: "jsonpath:retries", want: float64(3)},
+		{name: "float", expression: "jsonpath:ratio", want: 0.5},
+		{name: "boolean", expression: "jsonpath:enabled", want: true},
+		{name: "offset date-time", expression: "jsonpath:created", want: "1979-05-27T07:32:00-08:00"},
+		{name: "local date-time", expression: "jsonpath:local", want: "1979-05-27T07:32:00"},
+		{name: "local date", expression: "jsonpath:day", want: "1979-05-27"},
+		{name: "local time", expression: "jsonpath:alarm", want: "07:32:00"},
+		{name: "array", expression: "jsonpath:ports", want: []interface{}{float64(8000), float64(8001)}},
+		{name: "table", expression: "jsonpath:database.server", want: "192.168.1.1"},
+		{name: "inline table", expression: "jsonpath:database.settings.pool", want: float64(10)},
+		{name: "array of tables", expression: "jsonpath:products.#.name", want: []interface{}{"Hammer", "Nail"}},
+		{name: "table piped to json", expression: "jsonpath:database.settings | extractAsJSON | jsonpath:timeout", want: "5s"},
+		{name: "string piped to json", expression: "jsonpath:products.1.note | extractAsJSON | jsonpath:fragile", want: false},
+		{name: "missing key", expression: "jsonpath:database.port", wantErr: true},
+		{name: "wrong language", expression: "yamlpath:title", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(config), "application/toml", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTOMLPayloadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "unterminated string", content: `a = "x`},
+		{name: "duplicate key", content: "a = 1\na = 2"},
+		{name: "missing value", content: "a ="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTOMLPayload([]byte(tt.content))
+			if err == nil || !strings.Contains(err.Error(), "TOML parsing failed") {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
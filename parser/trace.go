@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"context"
+	"time"
+)
+
+// EvaluationTracer observes the stages of evaluations, e.g. to diagnose
+// multi-pipe expressions from production traces. Attach one to the
+// evaluations under a context with ContextWithTracer. Stages of
+// sub-expressions (of collection pipes or computed fields) are traced too,
+// with their own Expression. Calls come from the evaluating goroutine.
+type EvaluationTracer interface {
+	OnStageStart(event StageEvent)
+	OnStageEnd(event StageEvent)
+}
+
+// StageEvent describes a pipeline stage to an EvaluationTracer. Output, Err
+// and Duration are only set in OnStageEnd.
+type StageEvent struct {
+	Expression string // The pipeline the stage belongs to
+	StageIndex int    // Zero-based position of the stage in the pipeline
+	Stage      string
+	Input      QueryResult // Result of the previous stage; the zero QueryResult for the first stage
+	Output     QueryResult
+	Err        error
+	Duration   time.Duration
+}
+
+// tracerKey is the context key of the EvaluationTracer of evaluations.
+type tracerKey struct{}
+
+// ContextWithTracer returns a copy of ctx that makes the evaluations it is
+// passed to (e.g. with EvaluateExpressionContext) report their stages to
+// tracer.
+func ContextWithTracer(ctx context.Context, tracer EvaluationTracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// traceStage reports the start of a stage to the tracer of ctx, if any, and
+// returns the function that reports its end.
+func traceStage(ctx context.Context, fullExpression string, index int, stage *Stage, input QueryResult) func(QueryResult, error) {
+	tracer, _ := ctx.Value(tracerKey{}).(EvaluationTracer)
+	if tracer == nil {
+		return func(QueryResult, error) {}
+	}
+	event := StageEvent{Expression: fullExpression, StageIndex: index, Stage: stage.Text, Input: input}
+	tracer.OnStageStart(event)
+	start := time.Now()
+	return func(output QueryResult, err error) {
+		event.Output, event.Err, event.Duration = output, err, time.Since(start)
+		tracer.OnStageEnd(event)
+	}
+}
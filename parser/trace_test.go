@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// recordingTracer records the events it receives as strings.
+type recordingTracer struct {
+	events []string
+}
+
+func (r *recordingTracer) OnStageStart(e StageEvent) {
+	r.events = append(r.events, fmt.Sprintf("start %s #%d %s <- %v", e.Expression, e.StageIndex, e.Stage, e.Input.Value))
+}
+
+func (r *recordingTracer) OnStageEnd(e StageEvent) {
+	if e.Duration < 0 {
+		panic("negative duration")
+	}
+	r.events = append(r.events, fmt.Sprintf("end %s #%d -> %v (err %t)", e.Expression, e.StageIndex, e.Output.Value, e.Err != nil))
+}
+
+func TestEvaluationTracer(t *testing.T) {
+	msgCtx := NewMessageContext([]byte(`{"items": [{"n": "a"}], "x": "1"}`), "application/json", NewEngine())
+	tracer := &recordingTracer{}
+	ctx := ContextWithTracer(context.Background(), tracer)
+
+	if _, err := msgCtx.EvaluateExpressionContext(ctx, "jsonpath:items | map(jsonpath:n) | join(\",\")"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := msgCtx.EvaluateExpressionContext(ctx, "jsonpath:x | toNumber | extractAsXML"); err == nil {
+		t.Fatal("expected an error")
+	}
+	want := []string{
+		"start jsonpath:items | map(jsonpath:n) | join(\",\") #0 jsonpath:items <- <nil>",
+		"end jsonpath:items | map(jsonpath:n) | join(\",\") #0 -> [map[n:a]] (err false)",
+		"start jsonpath:items | map(jsonpath:n) | join(\",\") #1 map(jsonpath:n) <- [map[n:a]]",
+		"start jsonpath:n #0 jsonpath:n <- <nil>",
+		"end jsonpath:n #0 -> a (err false)",
+		"end jsonpath:items | map(jsonpath:n) | join(\",\") #1 -> [a] (err false)",
+		"start jsonpath:items | map(jsonpath:n) | join(\",\") #2 join(\",\") <- [a]",
+		"end jsonpath:items | map(jsonpath:n) | join(\",\") #2 -> a (err false)",
+		"start jsonpath:x | toNumber | extractAsXML #0 jsonpath:x <- <nil>",
+		"end jsonpath:x | toNumber | extractAsXML #0 -> 1 (err false)",
+		"start jsonpath:x | toNumber | extractAsXML #1 toNumber <- 1",
+		"end jsonpath:x | toNumber | extractAsXML #1 -> 1 (err false)",
+		"start jsonpath:x | toNumber | extractAsXML #2 extractAsXML <- 1",
+		"end jsonpath:x | toNumber | extractAsXML #2 -> <nil> (err true)",
+	}
+	if !reflect.DeepEqual(tracer.events, want) {
+		t.Errorf("got events\n%q\nwant\n%q", tracer.events, want)
+	}
+}
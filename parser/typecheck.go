@@ -0,0 +1,370 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ValueKind is a set of result types a pipeline stage may produce or accept.
+type ValueKind uint
+
+const (
+	KindString ValueKind = 1 << iota
+	KindNumber
+	KindBoolean
+	KindArray
+	KindObject
+	KindNodeSet // Several XPath nodes
+	KindBytes
+
+	KindNone ValueKind = 0
+	KindAny            = KindString | KindNumber | KindBoolean | KindArray | KindObject | KindNodeSet | KindBytes
+)
+
+// kindNames lists the kinds in the order String prints them.
+var kindNames = []struct {
+	kind ValueKind
+	name string
+}{
+	{KindString, "string"}, {KindNumber, "number"}, {KindBoolean, "boolean"},
+	{KindArray, "array"}, {KindObject, "object"}, {KindNodeSet, "nodeset"}, {KindBytes, "bytes"},
+}
+
+func (k ValueKind) String() string {
+	if k == KindNone {
+		return "none"
+	}
+	if k == KindAny {
+		return "any"
+	}
+	var names []string
+	for _, kn := range kindNames {
+		if k&kn.kind != 0 {
+			names = append(names, kn.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// MarshalText encodes the kind as its String form, e.g. in Describe output.
+func (k ValueKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// PayloadKind is the static type of the payload a stage queries.
+type PayloadKind string
+
+const (
+	PayloadUnknown   PayloadKind = ""             // Depends on the message (e.g. the first stage, or a multipart part)
+	PayloadJSON      PayloadKind = "json-payload" // JSON, or a document with a JSON view
+	PayloadXML       PayloadKind = "xml-payload"  // XML, SOAP or HTML
+	PayloadYAML      PayloadKind = "yaml-payload"
+	PayloadCSV       PayloadKind = "csv-payload"
+	PayloadText      PayloadKind = "text-payload"
+	PayloadMultipart PayloadKind = "multipart-payload"
+	PayloadBinary    PayloadKind = "binary-payload"
+	PayloadProtobuf  PayloadKind = "protobuf-payload"
+)
+
+// stageSignature declares the types of a pipeline stage.
+type stageSignature struct {
+	input         ValueKind     // Accepted kinds of the previous result; KindNone if it is not used
+	payloads      []PayloadKind // Payloads the stage can query; nil if it does not query the payload
+	output        ValueKind
+	outputPayload PayloadKind // Payload for the next stages
+	keepsPayload  bool        // The next stages query the same payload
+	keepsResult   bool        // The previous result may be passed on unchanged
+}
+
+// pipeInputKinds are the results pipeInput can pass to a conversion.
+const pipeInputKinds = KindString | KindArray | KindObject | KindBytes
+
+// CompiledExpression is an expression that has been parsed and type checked
+// by ExpressionEngine.Compile. It is immutable and safe for concurrent use.
+type CompiledExpression struct {
+	engine     *ExpressionEngine
+	expression string
+	parsed     *Expression
+	resultKind ValueKind
+}
+
+// Compile checks that expression can be evaluated: every stage must be
+// valid (see Lint) and every stage must accept the kind of result and payload
+// the stages before it can produce. For example "xpath:count(//item) |
+// extractAsJSON" is rejected, because a number cannot be parsed as JSON, and
+// so is "jsonpath:doc | extractAsXML | jsonpath:id", which queries XML with a
+// JSON path. The payload of the first stage is not known until evaluation,
+// so only the stages after a conversion are checked against payload kinds.
+// Type errors are reported as *ErrPipelineType.
+func (ee *ExpressionEngine) Compile(expression string) (*CompiledExpression, error) {
+	parsed := parseExpression(expression)
+	for _, w := range ee.lint(parsed) {
+		if w.Severity == SeverityError {
+			return nil, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("invalid stage %d ('%s'): %s", w.StageIndex, w.Stage, w.Message)}
+		}
+	}
+
+	// References are checked as the stages they stand for, and expanded again
+	// at each evaluation in case they are registered again
+	expanded, err := ee.expandReferences(parsed.Stages, nil)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Expression: expression, Reason: err.Error()}
+	}
+	_, result, err := ee.planStages(expression, expanded)
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledExpression{engine: ee, expression: expression, parsed: parsed, resultKind: result}, nil
+}
+
+// signatureOf returns the declared types of a stage at position index.
+func signatureOf(stage string, index int) stageSignature {
+	name := stage
+	if call, isCall, _ := parsePipeCall(stage); isCall {
+		name = call.name
+	}
+	switch name {
+	case extractAsJSONPipe:
+		return stageSignature{input: pipeInputKinds, output: KindString, outputPayload: PayloadJSON}
+	case extractAsXMLPipe:
+		return stageSignature{input: KindString, output: KindString, outputPayload: PayloadXML}
+	case extractAsYAMLPipe:
+		return stageSignature{input: pipeInputKinds, output: KindString, outputPayload: PayloadYAML}
+	case extractAutoPipe: // The payload depends on the sniffed format
+		return stageSignature{input: pipeInputKinds, output: KindString, outputPayload: PayloadUnknown}
+	case extractAsProtobufPipe:
+		return stageSignature{input: KindString | KindBytes, output: KindString, outputPayload: PayloadProtobuf}
+	case joltPipe:
+		return stageSignature{input: pipeInputKinds, output: KindString, outputPayload: PayloadJSON}
+	case xsltPipe: // The payload depends on the stylesheet's output
+		return stageSignature{input: KindString, output: KindString, outputPayload: PayloadUnknown}
+	case csvToJSONPipe:
+		return stageSignature{input: KindString, output: KindString, outputPayload: PayloadJSON}
+	case extractAsCSVPipe:
+		return stageSignature{input: KindString, output: KindString, outputPayload: PayloadCSV}
+	case jsonToXMLPipe:
+		return stageSignature{input: pipeInputKinds, output: KindString, outputPayload: PayloadXML}
+	case xmlToJSONPipe:
+		return stageSignature{input: KindString | KindNodeSet, output: KindString, outputPayload: PayloadJSON}
+	case c14nPipe:
+		return stageSignature{input: KindString | KindNodeSet, output: KindString, keepsPayload: true}
+	case prettyPrintPipe, minifyPipe:
+		return stageSignature{input: pipeInputKinds, output: KindString, keepsPayload: true}
+	case hexPipe, toHexPipe, base64EncodePipe:
+		return stageSignature{input: KindString | KindBytes, output: KindString, keepsPayload: true}
+	case sha256Pipe, sha1Pipe, md5Pipe, crc32Pipe, hmacSHA256Pipe, hmacSHA1Pipe, aesEncryptPipe:
+		return stageSignature{input: pipeInputKinds, output: KindBytes, keepsPayload: true}
+	case aesDecryptPipe:
+		return stageSignature{input: KindString | KindBytes, output: KindString | KindBytes, keepsPayload: true}
+	case base64DecodePipe, gunzipPipe, inflatePipe:
+		return stageSignature{input: KindString | KindBytes, output: KindString | KindBytes, keepsPayload: true}
+	case trimPipe, upperPipe, lowerPipe, replacePipe, regexReplacePipe, urlEncodePipe, urlDecodePipe:
+		return stageSignature{input: KindString, output: KindString, keepsPayload: true}
+	case splitPipe:
+		return stageSignature{input: KindString, output: KindArray, keepsPayload: true}
+	case joinPipe:
+		return stageSignature{input: KindArray, output: KindString, keepsPayload: true}
+	case substringPipe:
+		return stageSignature{input: KindString, output: KindString, keepsPayload: true}
+	case toNumberPipe, toIntPipe:
+		return stageSignature{input: KindString | KindNumber | KindBoolean, output: KindNumber, keepsPayload: true}
+	case toBooleanPipe:
+		return stageSignature{input: KindString | KindNumber | KindBoolean, output: KindBoolean, keepsPayload: true}
+	case toStringPipe:
+		return stageSignature{input: KindAny, output: KindString, keepsPayload: true}
+	case mapPipe:
+		return stageSignature{input: KindArray | KindNodeSet | KindString, output: KindArray, keepsPayload: true}
+	case firstPipe, lastPipe:
+		return stageSignature{input: KindArray | KindNodeSet | KindString, output: KindAny &^ KindNodeSet, keepsPayload: true}
+	case flattenPipe:
+		return stageSignature{input: KindArray | KindNodeSet | KindString, output: KindArray, keepsPayload: true}
+	case filterPipe, sortByPipe, uniquePipe, dedupePipe, slicePipe, limitPipe, offsetPipe:
+		return stageSignature{input: KindArray | KindNodeSet | KindString, output: KindArray | KindNodeSet | KindString, keepsPayload: true}
+	case defaultPipe:
+		return stageSignature{input: KindAny, output: defaultKind(stage), keepsPayload: true, keepsResult: true}
+	case ifPresentPipe:
+		return stageSignature{input: KindAny, output: KindNone, keepsPayload: true, keepsResult: true}
+	case ifEmptyPipe: // An empty string when the next stages run
+		return stageSignature{input: KindAny, output: KindString, keepsPayload: true, keepsResult: true}
+	}
+
+	switch {
+	case strings.HasPrefix(stage, fixedWidthToJSONPipe):
+		return stageSignature{input: KindString, output: KindString, outputPayload: PayloadJSON}
+	case strings.HasPrefix(stage, textPrefix), strings.HasPrefix(stage, regexPrefix):
+		output := KindString | KindArray
+		if expr := strings.TrimSpace(strings.TrimPrefix(stage, textPrefix)); strings.HasPrefix(stage, textPrefix) {
+			switch {
+			case expr == "lines":
+				output = KindArray
+			case expr == "" || strings.HasPrefix(expr, "lines["):
+				output = KindString
+			}
+		}
+		if index > 0 { // Works on the previous result
+			return stageSignature{input: pipeInputKinds, output: output, outputPayload: PayloadText}
+		}
+		return stageSignature{payloads: []PayloadKind{PayloadText}, output: output, keepsPayload: true}
+	case strings.HasPrefix(stage, partPrefix):
+		return stageSignature{payloads: []PayloadKind{PayloadMultipart}, output: KindString, outputPayload: PayloadUnknown}
+	case strings.HasPrefix(stage, xpathPrefix):
+		return stageSignature{payloads: []PayloadKind{PayloadXML}, output: xpathOutputKind(strings.TrimPrefix(stage, xpathPrefix)), keepsPayload: true}
+	case strings.HasPrefix(stage, cssPrefix):
+		return stageSignature{payloads: []PayloadKind{PayloadXML}, output: KindString | KindNodeSet, keepsPayload: true}
+	case strings.HasPrefix(stage, jsonpathPrefix):
+		return stageSignature{payloads: []PayloadKind{PayloadJSON}, output: KindAny &^ KindNodeSet, keepsPayload: true}
+	case strings.HasPrefix(stage, yamlpathPrefix):
+		return stageSignature{payloads: []PayloadKind{PayloadYAML}, output: KindAny &^ KindNodeSet, keepsPayload: true}
+	case strings.HasPrefix(stage, protopathPrefix):
+		return stageSignature{payloads: []PayloadKind{PayloadProtobuf}, output: KindAny &^ KindNodeSet, keepsPayload: true}
+	case strings.HasPrefix(stage, csvPrefix):
+		return stageSignature{payloads: []PayloadKind{PayloadCSV}, output: KindAny &^ KindNodeSet, keepsPayload: true}
+	case strings.HasPrefix(stage, bytesPrefix):
+		output := KindString // Digests
+		switch expr := strings.TrimSpace(strings.TrimPrefix(stage, bytesPrefix)); {
+		case expr == "all" || strings.HasPrefix(expr, "range("):
+			output = KindBytes
+		case expr == "length":
+			output = KindNumber
+		}
+		return stageSignature{payloads: []PayloadKind{PayloadBinary}, output: output, keepsPayload: true}
+	case strings.HasPrefix(stage, computedPrefix):
+		return stageSignature{output: KindAny, keepsPayload: true}
+	}
+	// Other languages query payloads no conversion produces
+	return stageSignature{payloads: []PayloadKind{}, output: KindAny, keepsPayload: true}
+}
+
+// defaultKind returns the kind of the constant of a default pipe stage.
+func defaultKind(stage string) ValueKind {
+	call, _, err := parsePipeCall(stage)
+	if err != nil || len(call.args) != 1 {
+		return KindAny
+	}
+	switch call.args[0].(type) {
+	case float64:
+		return KindNumber
+	case bool:
+		return KindBoolean
+	}
+	return KindString
+}
+
+// xpathOutputKind infers the result of an XPath expression: a location path
+// selects nodes, and a call of a core function spanning the whole expression
+// has the function's type. Anything else may be of any kind.
+func xpathOutputKind(expr string) ValueKind {
+	expr = strings.TrimSpace(expr)
+	if _, rest, found, err := cutDocumentSelector(expr); found && err == nil {
+		if rest == "" {
+			return KindString // The document as XML
+		}
+		expr = rest
+	}
+	name, _, isCall := strings.Cut(expr, "(")
+	if strings.ContainsAny(name, "/[@") && !strings.ContainsAny(name, " +=<>|") {
+		return KindString | KindNodeSet // A location path, possibly with predicates or node tests
+	}
+	if !isCall || !spansExpression(expr, len(name)) {
+		return KindAny
+	}
+	switch strings.TrimSpace(name) {
+	case "count", "sum", "number", "string-length", "floor", "ceiling", "round":
+		return KindNumber
+	case "boolean", "not", "true", "false", "contains", "starts-with", "ends-with", "lang":
+		return KindBoolean
+	case "string", "concat", "substring", "substring-before", "substring-after", "normalize-space", "translate", "name", "local-name", "lower-case", "upper-case":
+		return KindString
+	}
+	return KindAny
+}
+
+// spansExpression reports whether the parenthesis at open closes at the end
+// of expr.
+func spansExpression(expr string, open int) bool {
+	depth := 0
+	var quote byte
+	for i := open; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i == len(expr)-1
+			}
+		}
+	}
+	return false
+}
+
+func containsPayloadKind(kinds []PayloadKind, kind PayloadKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPayloadKinds(kinds []PayloadKind) string {
+	if len(kinds) == 0 {
+		return "payload no pipe produces"
+	}
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		names[i] = string(k)
+	}
+	return strings.Join(names, " or ")
+}
+
+// String returns the source expression.
+func (ce *CompiledExpression) String() string {
+	return ce.expression
+}
+
+// ResultKind returns the kinds of result the expression can produce.
+func (ce *CompiledExpression) ResultKind() ValueKind {
+	return ce.resultKind
+}
+
+// Evaluate evaluates the expression against payload. The expression is not
+// parsed again: a compiled expression can be evaluated any number of times,
+// from any number of goroutines.
+func (ce *CompiledExpression) Evaluate(payload PayloadObject) (QueryResult, error) {
+	return ce.EvaluateContext(context.Background(), payload)
+}
+
+// EvaluateContext is like Evaluate but honors the cancellation and deadline of ctx.
+func (ce *CompiledExpression) EvaluateContext(ctx context.Context, payload PayloadObject) (QueryResult, error) {
+	return ce.engine.evaluateRecorded(ctx, payload, ce.parsed)
+}
+
+// EvaluateMessage evaluates the expression against the payload of mc, like
+// mc.EvaluateExpression does, parsing the payload first if needed.
+func (ce *CompiledExpression) EvaluateMessage(mc *MessageContext) (QueryResult, error) {
+	return ce.EvaluateMessageContext(context.Background(), mc)
+}
+
+// EvaluateMessageContext is like EvaluateMessage but honors the cancellation
+// and deadline of ctx.
+func (ce *CompiledExpression) EvaluateMessageContext(ctx context.Context, mc *MessageContext) (QueryResult, error) {
+	return ce.engine.observeEvaluation(mc.RawPayload, mc.ContentType, mc.ContentEncoding, ce.expression, func() (QueryResult, error) {
+		return ce.evaluateMessage(ctx, mc)
+	})
+}
+
+func (ce *CompiledExpression) evaluateMessage(ctx context.Context, mc *MessageContext) (QueryResult, error) {
+	if err := mc.ensurePayloadParsed(); err != nil {
+		return QueryResult{}, err
+	}
+	ctx = withComputedScope(ctx, mc, mc.processedPayload)
+	return ce.engine.runPipeline(ctx, mc.processedPayload, ce.parsed)
+}
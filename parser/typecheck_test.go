@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileTypeChecks(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantKind   ValueKind
+		wantStage  int    // Stage of the expected *ErrPipelineType, or -1
+		wantErr    string // Expected error text for other failures
+	}{
+		{name: "single query", expression: "jsonpath:order.id", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "json to xml", expression: "jsonpath:order.note | extractAsXML | xpath:/note/to", wantKind: KindString | KindNodeSet, wantStage: -1},
+		{name: "xml to json", expression: "xpath:/a/json/text() | extractAsJSON | jsonpath:id", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "array to json", expression: "jsonpath:items | extractAsJSON | jsonpath:#", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "regex on result", expression: `jsonpath:message | regex:order (\d+)`, wantKind: KindString | KindArray, wantStage: -1},
+		{name: "text lines", expression: "jsonpath:message | text:lines", wantKind: KindArray, wantStage: -1},
+		{name: "xpath count", expression: "xpath:count(//item)", wantKind: KindNumber, wantStage: -1},
+		{name: "substring", expression: "jsonpath:id | substring(0, 3)", wantKind: KindString, wantStage: -1},
+		{name: "substring of number", expression: "xpath:count(//item) | substring(1)", wantStage: 1},
+		{name: "default of same kind", expression: `jsonpath:id | default("none")`, wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "default adds its kind", expression: "xpath:count(//item) | default(\"none\")", wantKind: KindNumber | KindString, wantStage: -1},
+		{name: "default of number", expression: "xpath:count(//item) | default(0) | substring(1)", wantStage: 2},
+		{name: "map", expression: "xpath://book | map(xpath:title)", wantKind: KindArray, wantStage: -1},
+		{name: "map of number", expression: "xpath:count(//book) | map(xpath:title)", wantStage: 1},
+		{name: "filter", expression: "jsonpath:books | filter(jsonpath:price > 10)", wantKind: KindArray | KindNodeSet | KindString, wantStage: -1},
+		{name: "flatten then join", expression: `jsonpath:books.#.tags | flatten | join(",")`, wantKind: KindString, wantStage: -1},
+		{name: "first then extractAsJSON", expression: "jsonpath:books | first | extractAsJSON | jsonpath:title", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "toNumber", expression: "jsonpath:price | toNumber", wantKind: KindNumber, wantStage: -1},
+		{name: "toBoolean of count", expression: "xpath:count(//item) | toBoolean", wantKind: KindBoolean, wantStage: -1},
+		{name: "toString of nodes", expression: "xpath://item | toString | substring(1)", wantKind: KindString, wantStage: -1},
+		{name: "toInt result to extractAsJSON", expression: "jsonpath:doc | extractAsJSON | jsonpath:a | toString | toInt | extractAsJSON", wantStage: 5},
+		{name: "urlDecode of number", expression: "xpath:count(//item) | urlDecode", wantStage: 1},
+		{name: "lower", expression: "jsonpath:email | trim | lower", wantKind: KindString, wantStage: -1},
+		{name: "split", expression: `jsonpath:tags | split(",")`, wantKind: KindArray, wantStage: -1},
+		{name: "join of string", expression: "jsonpath:tags | trim | join(\"-\")", wantStage: 2},
+		{name: "xpath document path", expression: "xpath:doc(2)//id", wantKind: KindString | KindNodeSet, wantStage: -1},
+		{name: "xpath whole document", expression: "xpath:doc(1) | extractAsXML | xpath:/a", wantKind: KindString | KindNodeSet, wantStage: -1},
+		{name: "xpath comparison", expression: "xpath:string(/a) = count(/b)", wantKind: KindAny, wantStage: -1},
+		{name: "part then anything", expression: "part:payload.json | jsonpath:id", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "computed then pipe", expression: "computed:doc | extractAsJSON | jsonpath:id", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "number to json", expression: "xpath:count(//item) | extractAsJSON", wantStage: 1},
+		{name: "boolean to regex", expression: "xpath:contains(/a, 'x') | regex:x", wantStage: 1},
+		{name: "array to xml", expression: "jsonpath:message | text:lines | extractAsXML", wantStage: 2},
+		{name: "jsonToXml then xpath", expression: "jsonpath:order | jsonToXml | xpath:/root/id", wantKind: KindString | KindNodeSet, wantStage: -1},
+		{name: "jsonToXml then jsonpath", expression: "jsonpath:order | jsonToXml | jsonpath:id", wantStage: 2},
+		{name: "xmlToJson then jsonpath", expression: "jsonpath:orderXml | xmlToJson | jsonpath:order.@id", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "xmlToJson of array", expression: "jsonpath:message | text:lines | xmlToJson", wantStage: 2},
+		{name: "c14n keeps payload", expression: "jsonpath:signedInfo | c14n", wantKind: KindString, wantStage: -1},
+		{name: "prettyPrint of number", expression: "xpath:count(//item) | prettyPrint", wantStage: 1},
+		{name: "digest", expression: "jsonpath:payload | sha256", wantKind: KindBytes, wantStage: -1},
+		{name: "digest is not a string", expression: "jsonpath:payload | sha256 | upper", wantStage: 2},
+		{name: "hmac", expression: `jsonpath:payload | hmacSHA256("webhook") | hex`, wantKind: KindString, wantStage: -1},
+		{name: "jsonpath on xml", expression: "jsonpath:doc | extractAsXML | jsonpath:id", wantStage: 2},
+		{name: "xpath on json", expression: "xpath:/a/text() | extractAsJSON | xpath:/b", wantStage: 2},
+		{name: "yamlpath on json", expression: "jsonpath:doc | extractAsJSON | yamlpath:a", wantStage: 2},
+		{name: "csv on converted payload", expression: "jsonpath:doc | extractAsJSON | csv:0.name", wantStage: 2},
+		{name: "part on json", expression: "jsonpath:doc | extractAsJSON | part:0", wantStage: 2},
+		{name: "bytes range to hex", expression: "bytes:range(0,4) | hex", wantKind: KindString, wantStage: -1},
+		{name: "hex of bytes length", expression: "bytes:length | hex", wantStage: 1},
+		{name: "fixed-width to json", expression: "xpath:/batch/text() | fixedWidthToJSON:customer | jsonpath:#.ID", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "csv extraction", expression: `jsonpath:export | extractAsCSV(";") | csv:#.sku`, wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "jsonpath on csv", expression: "jsonpath:export | extractAsCSV | jsonpath:0.sku", wantStage: 2},
+		{name: "yaml extraction", expression: "jsonpath:config | extractAsYAML | yamlpath:replicas", wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "fixed-width of number", expression: "xpath:count(//record) | fixedWidthToJSON:customer", wantStage: 1},
+		{name: "protobuf extraction", expression: `jsonpath:body | extractAsProtobuf("com.acme.Order") | protopath:order_id`, wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "protopath on json", expression: "jsonpath:doc | extractAsJSON | protopath:order_id", wantStage: 2},
+		{name: "xslt then xpath", expression: `xpath:/invoice | xslt("invoiceToSummary") | xpath:/summary/total`, wantKind: KindString | KindNodeSet, wantStage: -1},
+		{name: "xslt of number", expression: `xpath:count(//line) | xslt("invoiceToSummary")`, wantStage: 1},
+		{name: "jolt then jsonpath", expression: `jsonpath:order | jolt("orderToInvoice") | jsonpath:invoice.total`, wantKind: KindAny &^ KindNodeSet, wantStage: -1},
+		{name: "xpath on jolt output", expression: `jsonpath:order | jolt("orderToInvoice") | xpath:/invoice`, wantStage: 2},
+		{name: "extractAuto then xpath", expression: "jsonpath:note | extractAuto | xpath:/note/to", wantKind: KindString | KindNodeSet, wantStage: -1},
+		{name: "extractAuto of number", expression: "xpath:count(//note) | extractAuto", wantStage: 1},
+		{name: "syntax error", expression: "jsonpath:a | nosuchpipe", wantStage: -1, wantErr: "invalid stage 1 ('nosuchpipe')"},
+	}
+	engine := NewEngine()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := engine.Compile(tt.expression)
+			var typeErr *ErrPipelineType
+			switch {
+			case tt.wantStage >= 0:
+				if !errors.As(err, &typeErr) {
+					t.Fatalf("got %v, want *ErrPipelineType", err)
+				}
+				if typeErr.StageIndex != tt.wantStage {
+					t.Errorf("got stage %d (%v), want %d", typeErr.StageIndex, err, tt.wantStage)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || errors.As(err, &typeErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case compiled.ResultKind() != tt.wantKind:
+				t.Errorf("got result kind %s, want %s", compiled.ResultKind(), tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestCompiledExpressionEvaluate(t *testing.T) {
+	engine := NewEngine()
+	compiled, err := engine.Compile("jsonpath:doc | extractAsJSON | jsonpath:id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := NewJSONPayload([]byte(`{"doc": "{\"id\": 7}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := compiled.Evaluate(payload)
+	if err != nil || result.Value != float64(7) {
+		t.Fatalf("got %v, %v", result.Value, err)
+	}
+	if compiled.String() != "jsonpath:doc | extractAsJSON | jsonpath:id" {
+		t.Errorf("got %q", compiled.String())
+	}
+}
+
+func TestCompiledExpressionEvaluateMessage(t *testing.T) {
+	engine := NewEngine()
+	var recorded bytes.Buffer
+	engine.SetRecorder(NewRecorder(&recorded, RecorderOptions{}))
+	compiled, err := engine.Compile("jsonpath:doc | extractAuto | jsonpath:id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		payload     string
+		contentType string
+		want        interface{}
+		wantErr     string
+	}{
+		{name: "json document", payload: `{"doc": "{\"id\": 7}"}`, contentType: "application/json", want: float64(7)},
+		{name: "base64 document", payload: `{"doc": "eyJpZCI6IDh9"}`, contentType: "application/json", want: float64(8)},
+		{name: "unparsable payload", payload: `{"doc": `, contentType: "application/json", wantErr: "JSON"},
+		{name: "missing field", payload: `{}`, contentType: "application/json", wantErr: "doc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(tt.payload), tt.contentType, engine)
+			result, err := compiled.EvaluateMessage(msgCtx)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+	if got := strings.Count(recorded.String(), "\n"); got != len(tests) {
+		t.Errorf("recorded %d evaluations, want %d", got, len(tests))
+	}
+	if got := engine.expressionCache.len(); got != 0 {
+		t.Errorf("compiled evaluations added %d cache entries, want 0", got)
+	}
+}
+
+func TestValueKindString(t *testing.T) {
+	tests := []struct {
+		kind ValueKind
+		want string
+	}{
+		{KindNone, "none"},
+		{KindAny, "any"},
+		{KindNumber, "number"},
+		{KindString | KindArray | KindObject, "string|array|object"},
+	}
+	for _, tt := range tests {
+		if got := tt.kind.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// isValuePipe reports whether name is a pipe that takes a previous result of
+// any type rather than text.
+func isValuePipe(name string) bool {
+	switch name {
+	case defaultPipe, ifPresentPipe, ifEmptyPipe, toNumberPipe, toIntPipe, toBooleanPipe, toStringPipe, flattenPipe, uniquePipe, dedupePipe, slicePipe, limitPipe, offsetPipe, firstPipe, lastPipe:
+		return true
+	}
+	return false
+}
+
+// valuePipe evaluates default, a conditional, a coercion or an array pipe on
+// result.
+func valuePipe(ctx context.Context, call pipeCall, result QueryResult) (QueryResult, error) {
+	switch call.name {
+	case flattenPipe:
+		depth := -1
+		if len(call.args) > 0 {
+			var err error
+			if depth, err = intArg(call, 0); err != nil {
+				return QueryResult{}, err
+			}
+		}
+		return flatten(result, depth, maxResultElements(ctx))
+	case uniquePipe, dedupePipe:
+		return unique(result)
+	case firstPipe, lastPipe:
+		return pickElement(result, call.name == lastPipe)
+	case slicePipe, limitPipe, offsetPipe:
+		n, err := intArg(call, 0)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		switch call.name {
+		case limitPipe:
+			return sliceCollection(result, 0, n)
+		case offsetPipe:
+			return sliceCollection(result, n, -1)
+		}
+		end := -1
+		if len(call.args) > 1 {
+			if end, err = intArg(call, 1); err != nil {
+				return QueryResult{}, err
+			}
+		}
+		return sliceCollection(result, n, end)
+	case defaultPipe:
+		if isAbsent(result) {
+			return defaultResult(call), nil
+		}
+		return result, nil
+	case ifPresentPipe:
+		if isAbsent(result) {
+			return QueryResult{Value: nil, Type: AbsentResult}, nil // Skips the next stages
+		}
+		return result, nil
+	case ifEmptyPipe:
+		if isAbsent(result) {
+			return QueryResult{Value: "", Type: StringResult}, nil // Input of the next stages, e.g. queries
+		}
+		return result, nil
+	case toNumberPipe:
+		n, err := toNumber(result)
+		return QueryResult{Value: n, Type: NumberResult}, err
+	case toIntPipe:
+		n, err := toNumber(result)
+		if err == nil && math.Abs(n) >= 1<<63 {
+			err = fmt.Errorf("%v is out of the integer range", n)
+		}
+		return QueryResult{Value: math.Trunc(n), Type: NumberResult}, err
+	case toBooleanPipe:
+		b, err := toBoolean(result)
+		return QueryResult{Value: b, Type: BooleanResult}, err
+	}
+	s, err := toText(result)
+	return QueryResult{Value: s, Type: StringResult}, err
+}
+
+// toNumber converts a number, a numeric string with optional surrounding
+// whitespace, or a boolean (1 or 0) to a number. Infinities and NaN are
+// rejected.
+func toNumber(result QueryResult) (float64, error) {
+	switch value := result.Value.(type) {
+	case float64:
+		return value, nil
+	case bool:
+		if value {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return 0, fmt.Errorf("cannot convert %q to a number", value)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("cannot convert %s result to a number", result.Type)
+}
+
+// toBoolean converts a boolean, a number (zero is false) or one of the
+// strings true, yes, on, 1, false, no, off and 0, in any case and with
+// optional surrounding whitespace, to a boolean.
+func toBoolean(result QueryResult) (bool, error) {
+	switch value := result.Value.(type) {
+	case bool:
+		return value, nil
+	case float64:
+		return value != 0, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "yes", "on", "1":
+			return true, nil
+		case "false", "no", "off", "0":
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot convert %q to a boolean", value)
+	}
+	return false, fmt.Errorf("cannot convert %s result to a boolean", result.Type)
+}
+
+// toText converts a result to a string: numbers in their shortest form
+// without an exponent, booleans as true or false, UTF-8 bytes as text, and
+// arrays, objects and node-sets as JSON.
+func toText(result QueryResult) (string, error) {
+	switch value := result.Value.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case []byte:
+		if !utf8.Valid(value) {
+			return "", fmt.Errorf("bytes are not UTF-8 text")
+		}
+		return string(value), nil
+	case nil:
+		return "", fmt.Errorf("no value to convert")
+	}
+	raw, err := marshalJSONView(result.Value)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// defaultResult returns the constant of a default pipe call as a result.
+func defaultResult(call pipeCall) QueryResult {
+	switch value := call.args[0].(type) {
+	case float64:
+		return QueryResult{Value: value, Type: NumberResult}
+	case bool:
+		return QueryResult{Value: value, Type: BooleanResult}
+	}
+	return QueryResult{Value: call.args[0], Type: StringResult}
+}
+
+// isAbsent reports whether result is missing or empty: no value, an empty
+// string, no bytes, or an empty array or node-set. An empty object is a
+// value.
+func isAbsent(result QueryResult) bool {
+	switch value := result.Value.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []byte:
+		return len(value) == 0
+	case []interface{}:
+		return len(value) == 0
+	case []string:
+		return len(value) == 0
+	}
+	return false
+}
+
+// isDefaultStage reports whether stage is a valid default pipe.
+func isDefaultStage(stage *Stage) bool {
+	return stage.Kind == PipeStage && stage.Err == nil && stage.Pipe == defaultPipe
+}
+
+// isIfEmptyStage reports whether stage is a valid ifEmpty pipe.
+func isIfEmptyStage(stage *Stage) bool {
+	return stage.Kind == PipeStage && stage.Err == nil && stage.Pipe == ifEmptyPipe
+}
+
+// handlesAbsence reports whether stage is a valid pipe that takes a missing
+// result: default, ifPresent or ifEmpty.
+func handlesAbsence(stage *Stage) bool {
+	return isDefaultStage(stage) || isIfEmptyStage(stage) || stage.Kind == PipeStage && stage.Err == nil && stage.Pipe == ifPresentPipe
+}
+
+// defaultReplaces reports whether the stage after index is a default or
+// conditional pipe that replaces err, the failure of stage index, with a
+// missing result. Only a query that matches nothing is missing: any other
+// failure, e.g. malformed JSON or an invalid regex, is not replaced.
+func defaultReplaces(stages []*Stage, index int, err error) bool {
+	if index+1 >= len(stages) {
+		return false
+	}
+	return handlesAbsence(stages[index+1]) && errors.Is(err, ErrNoMatch)
+}
@@ -0,0 +1,270 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultPipe(t *testing.T) {
+	body := []byte(`{"customer":{"name":"Ada","nickname":"","tags":[]},"count":0,"active":false,"items":[{"sku":"A1","qty":2}]}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantType   ResultType
+		wantErr    string
+	}{
+		{name: "missing path", expression: `jsonpath:customer.title | default("N/A")`, want: "N/A", wantType: StringResult},
+		{name: "present value passes through", expression: `jsonpath:customer.name | default("N/A")`, want: "Ada", wantType: StringResult},
+		{name: "empty string", expression: `jsonpath:customer.nickname | default("none")`, want: "none", wantType: StringResult},
+		{name: "empty array", expression: `jsonpath:customer.tags | default("untagged")`, want: "untagged", wantType: StringResult},
+		{name: "zero is a value", expression: "jsonpath:count | default(5)", want: float64(0), wantType: NumberResult},
+		{name: "false is a value", expression: "jsonpath:active | default(true)", want: false, wantType: BooleanResult},
+		{name: "number constant", expression: `jsonpath:items.#(sku=="B2").qty | default(0)`, want: float64(0), wantType: NumberResult},
+		{name: "boolean constant", expression: "jsonpath:customer.vip | default(false)", want: false, wantType: BooleanResult},
+		{name: "pipe failure", expression: `jsonpath:customer.name | extractAsJSON | default("{}")`, wantErr: "Invalid JSON content"},
+		{name: "invalid regex", expression: `jsonpath:customer.name | regexReplace("(", "x") | default("n/a")`, wantErr: "missing closing )"},
+		{name: "unknown key", expression: `jsonpath:customer.name | hmacSHA256("nokey") | default("n/a")`, wantErr: "nokey"},
+		{name: "later stages continue", expression: `jsonpath:customer.title | default("n/a") | upper`, want: "N/A", wantType: StringResult},
+		{name: "payload kept after a failure", expression: `jsonpath:customer.title | default("x") | jsonpath:customer.name`, want: "Ada", wantType: StringResult},
+		{name: "only the previous stage", expression: `jsonpath:customer.title | upper | default("N/A")`, wantErr: "path not found"},
+		{name: "without default", expression: "jsonpath:customer.title | upper", wantErr: "path not found"},
+		{name: "missing argument", expression: "jsonpath:customer.title | default()", wantErr: "takes 1 argument(s), got 0"},
+		{name: "too many arguments", expression: `jsonpath:customer.name | default("a", "b")`, wantErr: "takes 1 argument(s), got 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestDefaultPipeXPath(t *testing.T) {
+	body := []byte(`<order><id>7</id><note></note></order>`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+	}{
+		{name: "no nodes", expression: `xpath:/order/discount | default("0")`, want: "0"},
+		{name: "empty element", expression: `xpath:/order/note | default("none")`, want: "none"},
+		{name: "node text passes through", expression: `xpath:/order/id | default("0")`, want: "7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/xml", NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultPipeKeepsCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	payload, err := NewJSONPayload([]byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewEngine().EvaluateContext(ctx, payload, `jsonpath:b | default("x")`)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want cancellation", err)
+	}
+
+	engine := NewEngine()
+	engine.SetEvaluationTimeout(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	_, err = engine.Evaluate(payload, `jsonpath:b | default("x")`)
+	var timeoutErr *ErrTimeout
+	if err != nil && !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want nil or *ErrTimeout", err)
+	}
+}
+
+func TestConditionalPipes(t *testing.T) {
+	body := []byte(`{"customer":{"name":"Ada","nickname":"","alias":"Countess"},"attachment":"{\"id\": 7}","tags":[]}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantType   ResultType
+		wantErr    string
+	}{
+		{name: "present", expression: "jsonpath:attachment | ifPresent | extractAsJSON | jsonpath:id", want: float64(7), wantType: NumberResult},
+		{name: "missing path", expression: "jsonpath:missing | ifPresent | extractAsJSON | jsonpath:id", want: nil, wantType: AbsentResult},
+		{name: "empty array", expression: "jsonpath:tags | ifPresent | first", want: nil, wantType: AbsentResult},
+		{name: "default after skipped stages", expression: `jsonpath:missing | ifPresent | extractAsJSON | jsonpath:id | default(0)`, want: float64(0), wantType: NumberResult},
+		{name: "failure before ifPresent", expression: "jsonpath:customer.name | extractAsJSON | ifPresent", wantErr: "failed to create intermediate JSON payload"},
+		{name: "failure before ifEmpty", expression: "jsonpath:customer.name | extractAsJSON | ifEmpty | jsonpath:customer.alias", wantErr: "failed to create intermediate JSON payload"},
+		{name: "failure after ifPresent", expression: "jsonpath:customer.name | ifPresent | extractAsJSON", wantErr: "failed to create intermediate JSON payload"},
+		{name: "ifEmpty with a value", expression: "jsonpath:customer.alias | ifEmpty | jsonpath:customer.name | upper", want: "Countess", wantType: StringResult},
+		{name: "ifEmpty with an empty string", expression: "jsonpath:customer.nickname | ifEmpty | jsonpath:customer.name", want: "Ada", wantType: StringResult},
+		{name: "ifEmpty with a missing path", expression: "jsonpath:customer.title | ifEmpty | jsonpath:customer.nickname | ifEmpty | jsonpath:customer.alias", want: "Countess", wantType: StringResult},
+		{name: "nothing after ifEmpty", expression: "jsonpath:customer.title | ifEmpty", want: "", wantType: StringResult},
+		{name: "failure after ifEmpty", expression: "jsonpath:customer.title | ifEmpty | jsonpath:customer.missing", wantErr: "path not found"},
+		{name: "arguments", expression: "jsonpath:customer.name | ifPresent(1)", wantErr: "takes no arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestConditionalPipesLenientAndCompiled(t *testing.T) {
+	engine := NewEngine(WithStrictMode(false))
+	msgCtx := NewMessageContext([]byte(`{"name": "Ada"}`), "application/json", engine)
+	if result, err := msgCtx.EvaluateExpression("jsonpath:nickname | ifEmpty | jsonpath:name"); err != nil || result.Value != "Ada" {
+		t.Errorf("got %v, %v, want the stages after ifEmpty to use the absent result", result.Value, err)
+	}
+
+	compiled, err := engine.Compile("jsonpath:nickname | ifEmpty | jsonpath:name | toNumber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind := compiled.ResultKind(); kind&KindString == 0 || kind&KindNumber == 0 {
+		t.Errorf("ResultKind() = %s, want the kinds ifEmpty can end with too", kind)
+	}
+	if _, err := engine.Compile("xpath:count(//a) | ifPresent | extractAsJSON"); err == nil {
+		t.Error("expected a type error for a number passed on by ifPresent")
+	}
+}
+
+func TestCoercionPipes(t *testing.T) {
+	body := []byte(`{"price":" 12.50 ","qty":"7","neg":"-3.9","big":"1e300","flag":"TRUE","no":"off","one":1,"zero":0,"yes":true,"id":1234567,"ratio":0.25,"word":"maybe","tags":["a","b"],"address":{"zip":"10115"},"blob":"aGk="}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantType   ResultType
+		wantErr    string
+	}{
+		{name: "number from padded string", expression: "jsonpath:price | toNumber", want: 12.5, wantType: NumberResult},
+		{name: "number from number", expression: "jsonpath:ratio | toNumber", want: 0.25, wantType: NumberResult},
+		{name: "number from boolean", expression: "jsonpath:yes | toNumber", want: float64(1), wantType: NumberResult},
+		{name: "number from word", expression: "jsonpath:word | toNumber", wantErr: `cannot convert "maybe" to a number`},
+		{name: "number from array", expression: "jsonpath:tags | toNumber", wantErr: "cannot convert array result to a number"},
+		{name: "int from string", expression: "jsonpath:qty | toInt", want: float64(7), wantType: NumberResult},
+		{name: "int truncates toward zero", expression: "jsonpath:neg | toInt", want: float64(-3), wantType: NumberResult},
+		{name: "int out of range", expression: "jsonpath:big | toInt", wantErr: "out of the integer range"},
+		{name: "boolean from upper case", expression: "jsonpath:flag | toBoolean", want: true, wantType: BooleanResult},
+		{name: "boolean from off", expression: "jsonpath:no | toBoolean", want: false, wantType: BooleanResult},
+		{name: "boolean from one", expression: "jsonpath:one | toBoolean", want: true, wantType: BooleanResult},
+		{name: "boolean from zero", expression: "jsonpath:zero | toBoolean", want: false, wantType: BooleanResult},
+		{name: "boolean from word", expression: "jsonpath:word | toBoolean", wantErr: `cannot convert "maybe" to a boolean`},
+		{name: "string from integer", expression: "jsonpath:id | toString", want: "1234567", wantType: StringResult},
+		{name: "string from fraction", expression: "jsonpath:ratio | toString", want: "0.25", wantType: StringResult},
+		{name: "string from boolean", expression: "jsonpath:yes | toString", want: "true", wantType: StringResult},
+		{name: "string from object", expression: "jsonpath:address | toString", want: `{"zip":"10115"}`, wantType: StringResult},
+		{name: "string from array", expression: "jsonpath:tags | toString", want: `["a","b"]`, wantType: StringResult},
+		{name: "string from bytes", expression: "jsonpath:blob | base64Decode | toString", want: "hi", wantType: StringResult},
+		{name: "chained", expression: "jsonpath:qty | toInt | toString", want: "7", wantType: StringResult},
+		{name: "after default", expression: "jsonpath:missing | default(0) | toBoolean", want: false, wantType: BooleanResult},
+		{name: "missing value", expression: "jsonpath:id | toString | jsonpath:missing | toString", wantErr: "path not found"},
+		{name: "arguments", expression: "jsonpath:qty | toInt(10)", wantErr: "takes no arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) || result.Type != tt.wantType {
+				t.Errorf("got %#v (%s), want %#v (%s)", result.Value, result.Type, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestCoercionPipesXPath(t *testing.T) {
+	body := []byte(`<order><qty> 3 </qty><gift>yes</gift><item>a</item><item>b</item></order>`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+	}{
+		{name: "count to string", expression: "xpath:count(//item) | toString", want: "2"},
+		{name: "text to int", expression: "xpath:/order/qty | toInt", want: float64(3)},
+		{name: "text to boolean", expression: "xpath:/order/gift | toBoolean", want: true},
+		{name: "node-set to string", expression: "xpath://item | toString", want: `["a","b"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/xml", NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultAfterNothingSelected(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		expression  string
+	}{
+		{name: "markdown index", content: "# Only\n", contentType: "text/markdown", expression: "md:heading[3]"},
+		{name: "front matter", content: "Just text", contentType: "text/plain", expression: "frontmatter:title"},
+		{name: "properties prefix", content: "app.name=x\n", contentType: "text/x-java-properties", expression: "prop:db.*"},
+		{name: "byte range", content: "abc", contentType: "application/octet-stream", expression: "bytes:range(1,9)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(tt.content), tt.contentType, NewEngine())
+			if _, err := msgCtx.EvaluateExpression(tt.expression); !errors.Is(err, ErrNoMatch) {
+				t.Fatalf("got %v, want an error wrapping ErrNoMatch", err)
+			}
+			if result, err := msgCtx.EvaluateExpression(tt.expression + ` | default("none")`); err != nil || result.Value != "none" {
+				t.Errorf("with default: got %v, %v", result.Value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// EvaluateWithVars is like Evaluate, with each $name reference in expression
+// whose name is a key of vars replaced by that value (see
+// MessageContext.EvaluateExpressionWithVars).
+func (ee *ExpressionEngine) EvaluateWithVars(currentPayload PayloadObject, expression string, vars map[string]interface{}) (QueryResult, error) {
+	bound, err := ee.bindVariables(expression, vars)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	return ee.evaluateRecorded(context.Background(), currentPayload, bound)
+}
+
+// EvaluateExpressionWithVars is like EvaluateExpression, with each $name
+// reference in expression whose name is a key of vars replaced by that value,
+// written as a literal of the stage's language:
+//
+//	xpath://tenant[@id=$tenant]/limit     an XPath string, number or true()/false()
+//	css:tr[data-id=$id]                   a CSS string
+//	jsonpath:items.$index.sku             an escaped gjson key
+//	jsonpath:items.#(qty>$min)#.sku       a JSON value after a comparison
+//	regex:order $prefix(\d+)              the value matching itself
+//	default($fallback)                    a pipe argument
+//
+// so that values are never parsed as part of the expression. References to
+// registered expressions ("@name" stages) bind their variables too. Values may be
+// strings, booleans and numbers. References inside quoted strings are left as
+// they are, and so are references to names that are not in vars, such as
+// $body in SOAP XPath expressions. Other query stages cannot use variables.
+// Variables are bound in the parsed expression, which is cached once
+// whatever their values.
+func (mc *MessageContext) EvaluateExpressionWithVars(expression string, vars map[string]interface{}) (QueryResult, error) {
+	bound, err := mc.engine.bindVariables(expression, vars)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	return mc.engine.observeEvaluation(mc.RawPayload, mc.ContentType, mc.ContentEncoding, bound.Source, func() (QueryResult, error) {
+		return mc.evaluate(context.Background(), bound)
+	})
+}
+
+// variable is the value of a variable as text, with its kind.
+type variable struct {
+	text string
+	kind ResultType // StringResult, NumberResult or BooleanResult
+}
+
+// bindVariables returns the syntax tree of expression with the references to
+// vars replaced by literals of their values. The tree of expression itself is
+// not modified: bound stages are copies.
+func (ee *ExpressionEngine) bindVariables(expression string, vars map[string]interface{}) (*Expression, error) {
+	parsed := ee.parse(expression)
+	if len(vars) == 0 {
+		return parsed, nil
+	}
+	values := make(map[string]variable, len(vars))
+	for name, value := range vars {
+		v, err := newVariable(value)
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("variable $%s: %v", name, err)}
+		}
+		values[name] = v
+	}
+	bound, err := ee.bindExpression(parsed, values, nil)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Expression: expression, Reason: err.Error()}
+	}
+	return bound, nil
+}
+
+func newVariable(value interface{}) (variable, error) {
+	switch v := value.(type) {
+	case string:
+		return variable{v, StringResult}, nil
+	case bool:
+		return variable{strconv.FormatBool(v), BooleanResult}, nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return variable{fmt.Sprint(v), NumberResult}, nil
+	case float32:
+		return variable{strconv.FormatFloat(float64(v), 'f', -1, 32), NumberResult}, nil
+	case float64:
+		return variable{strconv.FormatFloat(v, 'f', -1, 64), NumberResult}, nil
+	case json.Number:
+		if _, err := v.Float64(); err != nil {
+			return variable{}, err
+		}
+		return variable{v.String(), NumberResult}, nil
+	}
+	return variable{}, fmt.Errorf("unsupported type %T: values must be strings, booleans or numbers", value)
+}
+
+// bindExpression binds the variables of each stage of expression. Stages
+// that refer to a registered expression are replaced by its bound stages;
+// chain holds the names being replaced, to detect cycles. It returns
+// expression itself if no stage uses a variable.
+func (ee *ExpressionEngine) bindExpression(expression *Expression, vars map[string]variable, chain []string) (*Expression, error) {
+	var stages []*Stage
+	changed := false
+	for _, stage := range expression.Stages {
+		if stage.Kind == ReferenceStage {
+			if named, ok := ee.namedExpression(stage.Name); ok && !slices.Contains(chain, stage.Name) {
+				bound, err := ee.bindExpression(ee.parse(named), vars, append(chain[:len(chain):len(chain)], stage.Name))
+				if err != nil {
+					return nil, err
+				}
+				stages = append(stages, bound.Stages...)
+				changed = true
+				continue
+			}
+			// Evaluation reports the missing or recursive expression
+		}
+		bound, err := ee.bindStage(stage, vars)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, bound)
+		changed = changed || bound != stage
+	}
+	if !changed {
+		return expression, nil
+	}
+	texts := make([]string, len(stages))
+	for i, stage := range stages {
+		texts[i] = escapeStageText(stage.Text)
+	}
+	return &Expression{Source: strings.Join(texts, " | "), Stages: stages}, nil
+}
+
+// bindStage binds the variables of one stage in the syntax of its language.
+// It returns stage itself if the stage uses no variable.
+func (ee *ExpressionEngine) bindStage(stage *Stage, vars map[string]variable) (*Stage, error) {
+	switch stage.Kind {
+	case QueryStage:
+		var literal func(variable, string) (string, error)
+		quotes := true
+		switch stage.Language {
+		case xpathPrefix:
+			literal = func(v variable, _ string) (string, error) { return xpathValue(v), nil }
+		case cssPrefix:
+			literal = func(v variable, _ string) (string, error) { return cssString(v.text) }
+		case jsonpathPrefix, yamlpathPrefix:
+			literal = gjsonLiteral
+		case regexPrefix:
+			literal, quotes = func(v variable, _ string) (string, error) { return regexp.QuoteMeta(v.text), nil }, false
+		}
+		query, err := replaceVariables(stage.Language, stage.Query, vars, quotes, literal)
+		if err != nil || query == stage.Query {
+			return stage, err
+		}
+		bound := *stage
+		bound.Query, bound.Text = query, stage.Language+query
+		return &bound, nil
+	case PipeStage:
+		if isCollectionPipe(stage.Pipe) {
+			return ee.bindCollectionPipe(stage, vars)
+		}
+		text, err := replaceVariables("", stage.Text, vars, true, func(v variable, _ string) (string, error) { return pipeArgLiteral(v), nil })
+		if err != nil || text == stage.Text {
+			return stage, err
+		}
+		// The arguments are parsed again with the literals in place
+		bound := parseStage(text)
+		bound.Offset = stage.Offset
+		return bound, nil
+	}
+	return stage, nil
+}
+
+// bindCollectionPipe binds the variables of the sub-expression of a
+// collection pipe, which is an expression of its own.
+func (ee *ExpressionEngine) bindCollectionPipe(stage *Stage, vars map[string]variable) (*Stage, error) {
+	if stage.Err != nil || stage.Sub == nil {
+		return stage, nil // Evaluation reports the invalid call
+	}
+	// The sub-expression of filter is without its comparison, which may use
+	// variables too
+	sub := stage.Sub
+	if stage.Pipe == filterPipe {
+		sub = parseExpression(stage.Args[0].(string))
+	}
+	boundSub, err := ee.bindExpression(sub, vars, nil)
+	if err != nil || boundSub == sub {
+		return stage, err
+	}
+	bound := *stage
+	bound.Args = slices.Clone(stage.Args)
+	bound.Args[0] = boundSub.Source
+	bound.Sub = boundSub
+	if stage.Pipe == filterPipe {
+		bound.Sub = parseExpression(parsePredicate(boundSub.Source).expression)
+	}
+	args := []string{boundSub.Source}
+	for _, arg := range stage.Args[1:] {
+		text, err := formatPipeArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, text)
+	}
+	bound.Text = stage.Pipe + "(" + strings.Join(args, ", ") + ")"
+	return &bound, nil
+}
+
+// replaceVariables replaces the references to vars in stage, the query of a
+// stage in language or a pipe call, outside quoted strings, if quotes delimit
+// strings in its language, by literal(value, text before the reference). A
+// nil literal means the stage cannot use variables.
+func replaceVariables(language, stage string, vars map[string]variable, quotes bool, literal func(variable, string) (string, error)) (string, error) {
+	var sb strings.Builder
+	var quote byte
+	for i := 0; i < len(stage); i++ {
+		c := stage[i]
+		switch {
+		case c == '\\' && i+1 < len(stage):
+			sb.WriteString(stage[i : i+2])
+			i++
+			continue
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '\'' || c == '"') && quotes && (i == 0 || !isWordByte(stage[i-1])):
+			quote = c
+		case c == '$':
+			end := i + 1
+			for end < len(stage) && isWordByte(stage[end]) {
+				end++
+			}
+			name := stage[i+1 : end]
+			if v, ok := vars[name]; ok && name != "" && !isDigit(name[0]) {
+				if literal == nil {
+					return "", fmt.Errorf("variable $%s cannot be used in %s stages", name, language)
+				}
+				text, err := literal(v, sb.String())
+				if err != nil {
+					return "", fmt.Errorf("variable $%s: %w", name, err)
+				}
+				sb.WriteString(text)
+				i = end - 1
+				continue
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String(), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// xpathValue writes v as an XPath literal.
+func xpathValue(v variable) string {
+	switch v.kind {
+	case NumberResult:
+		return v.text
+	case BooleanResult:
+		return v.text + "()"
+	}
+	return xpathLiteral(v.text)
+}
+
+// cssString quotes s as a CSS attribute value. Selectors have no escapes, so
+// s cannot contain both kinds of quotes.
+func cssString(s string) (string, error) {
+	switch {
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`, nil
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'", nil
+	}
+	return "", fmt.Errorf("a CSS attribute value cannot contain both kinds of quotes")
+}
+
+// gjsonLiteral writes v as a JSON value when it follows a comparison in a
+// query, e.g. "#(qty>$min)", and as an escaped path key otherwise.
+func gjsonLiteral(v variable, before string) (string, error) {
+	if last := strings.TrimRight(before, " \t"); last != "" && strings.ContainsRune("=<>%", rune(last[len(last)-1])) {
+		if v.kind != StringResult {
+			return v.text, nil
+		}
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(v.text) // Strings always encode
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	}
+	return gjson.Escape(v.text), nil
+}
+
+// pipeArgLiteral writes v as a pipe argument.
+func pipeArgLiteral(v variable) string {
+	if v.kind != StringResult {
+		return v.text
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(v.text) + `"`
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBindVariables(t *testing.T) {
+	vars := map[string]interface{}{
+		"tenant": "acme",
+		"quoted": `it's "x"`,
+		"index":  2,
+		"min":    1.5,
+		"flag":   true,
+		"key":    "a.b|c",
+		"big":    json.Number("12345678901234567890"),
+	}
+	tests := []struct {
+		name       string
+		expression string
+		want       string
+		wantErr    string
+	}{
+		{name: "xpath string", expression: "xpath://tenant[@id=$tenant]", want: "xpath://tenant[@id='acme']"},
+		{name: "xpath string with both quotes", expression: "xpath://a[@t=$quoted]", want: `xpath://a[@t=concat('it', "'", 's "x"')]`},
+		{name: "xpath number and boolean", expression: "xpath://a[$index][$flag]", want: "xpath://a[2][true()]"},
+		{name: "quoted reference kept", expression: "xpath://a[@t='$tenant']", want: "xpath://a[@t='$tenant']"},
+		{name: "unknown name kept", expression: "xpath:$body/order", want: "xpath:$body/order"},
+		{name: "css", expression: "css:tr[data-t=$tenant]", want: `css:tr[data-t="acme"]`},
+		{name: "css with both quotes", expression: "css:tr[data-t=$quoted]", wantErr: "variable $quoted: a CSS attribute value cannot contain both kinds of quotes"},
+		{name: "gjson key", expression: "jsonpath:items.$index.$key", want: `jsonpath:items.2.a\.b\|c`},
+		{name: "gjson query value", expression: "jsonpath:items.#(qty>$min)#.#(sku==$tenant)", want: `jsonpath:items.#(qty>1.5)#.#(sku=="acme")`},
+		{name: "yamlpath", expression: "yamlpath:tenants.$tenant", want: "yamlpath:tenants.acme"},
+		{name: "regex", expression: "regex:id=$key", want: `regex:id=a\.b\|c`},
+		{name: "regex anchor", expression: "regex:x$", want: "regex:x$"},
+		{name: "pipe arguments", expression: `jsonpath:a | default($quoted) | substring($index)`, want: `jsonpath:a | default("it's \"x\"") | substring(2)`},
+		{name: "sub-expression", expression: "jsonpath:items | filter(jsonpath:qty > $min) | map(jsonpath:$key)", want: `jsonpath:items | filter(jsonpath:qty > 1.5) | map(jsonpath:a\.b\|c)`},
+		{name: "exact numbers", expression: "jsonpath:#(id==$big)", want: "jsonpath:#(id==12345678901234567890)"},
+		{name: "escaped pipe kept", expression: `xpath://a\|//b[@id=$tenant]`, want: `xpath://a\|//b[@id='acme']`},
+		{name: "unsupported stage", expression: "csv:$index.id", wantErr: "variable $index cannot be used in csv: stages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEngine().bindVariables(tt.expression, vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Source != tt.want {
+				t.Errorf("got %s, want %s", got.Source, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateExpressionWithVars(t *testing.T) {
+	engine := NewEngine()
+	xmlCtx := NewMessageContext([]byte(`<tenants><tenant id="acme"><limit>10</limit></tenant><tenant id="x' or '1'='1"><limit>99</limit></tenant></tenants>`), "application/xml", engine)
+	jsonCtx := NewMessageContext([]byte(`{"items": [{"sku": "A", "qty": 1}, {"sku": "B", "qty": 5}], "by.key": {"x": 1}}`), "application/json", engine)
+	htmlCtx := NewMessageContext([]byte(`<html><body><p data-id="7">seven</p><p data-id='say "hi"'>quoted</p></body></html>`), "text/html", engine)
+	tests := []struct {
+		name       string
+		msgCtx     *MessageContext
+		expression string
+		vars       map[string]interface{}
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "xpath", msgCtx: xmlCtx, expression: "xpath://tenant[@id=$tenant]/limit", vars: map[string]interface{}{"tenant": "acme"}, want: "10"},
+		{name: "xpath injection is a literal", msgCtx: xmlCtx, expression: "xpath:count(//tenant[@id=$tenant])", vars: map[string]interface{}{"tenant": "x' or '1'='1"}, want: float64(1)},
+		{name: "xpath missing value", msgCtx: xmlCtx, expression: "xpath:count(//tenant[@id=$tenant])", vars: map[string]interface{}{"tenant": "']"}, want: float64(0)},
+		{name: "array index", msgCtx: jsonCtx, expression: "jsonpath:items.$i.sku", vars: map[string]interface{}{"i": 1}, want: "B"},
+		{name: "threshold", msgCtx: jsonCtx, expression: "jsonpath:items.#(qty>$min)#.sku", vars: map[string]interface{}{"min": 2}, want: []interface{}{"B"}},
+		{name: "key with dots", msgCtx: jsonCtx, expression: "jsonpath:$key.x", vars: map[string]interface{}{"key": "by.key"}, want: float64(1)},
+		{name: "css", msgCtx: htmlCtx, expression: "css:p[data-id=$id]", vars: map[string]interface{}{"id": `say "hi"`}, want: "quoted"},
+		{name: "pipe argument", msgCtx: jsonCtx, expression: "jsonpath:missing | default($fallback)", vars: map[string]interface{}{"fallback": "none"}, want: "none"},
+		{name: "filter comparison", msgCtx: jsonCtx, expression: "jsonpath:items | filter(jsonpath:qty >= $min) | map(jsonpath:$field)", vars: map[string]interface{}{"min": 2, "field": "sku"}, want: []interface{}{"B"}},
+		{name: "map without arguments", msgCtx: jsonCtx, expression: "jsonpath:items | map", vars: map[string]interface{}{"i": 1}, wantErr: "map"},
+		{name: "sortBy without arguments", msgCtx: jsonCtx, expression: "jsonpath:items | sortBy", vars: map[string]interface{}{"i": 1}, wantErr: "sortBy"},
+		{name: "no variables", msgCtx: jsonCtx, expression: "jsonpath:items.0.sku", want: "A"},
+		{name: "unsupported value", msgCtx: jsonCtx, expression: "jsonpath:$v", vars: map[string]interface{}{"v": []string{"a"}}, wantErr: "variable $v: unsupported type []string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.msgCtx.EvaluateExpressionWithVars(tt.expression, tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+
+	// Values are bound in the cached syntax tree, so they add no cache entries
+	cached := NewEngine()
+	cachedCtx := NewMessageContext([]byte(`{"items": [{"sku": "A"}, {"sku": "B"}]}`), "application/json", cached)
+	for i := 0; i < 5; i++ {
+		if _, err := cachedCtx.EvaluateExpressionWithVars("jsonpath:items.$i.sku", map[string]interface{}{"i": i % 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cached.expressionCache.len(); n != 1 {
+		t.Errorf("%d cache entries, want 1", n)
+	}
+
+	payload, err := NewJSONPayload([]byte(`{"a": {"b": 7}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := engine.EvaluateWithVars(payload, "jsonpath:a.$k", map[string]interface{}{"k": "b"}); err != nil || result.Value != float64(7) {
+		t.Errorf("EvaluateWithVars: got %v, %v", result.Value, err)
+	}
+}
@@ -0,0 +1,294 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"golang.org/x/net/html/charset"
+)
+
+// xmlNamespace is the namespace bound to the "xml" prefix.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// c14nFrame is an open element during canonicalization.
+type c14nFrame struct {
+	name     string            // Qualified name, for the end tag
+	inScope  map[string]string // Namespace declarations in scope, by prefix ("" is the default namespace)
+	rendered map[string]string // Namespace declarations in effect in the output
+}
+
+// c14nAttr is an attribute or namespace declaration of a canonical start tag.
+type c14nAttr struct {
+	name, namespace, local, value string
+}
+
+// inclusivePrefixes returns the InclusiveNamespaces PrefixList of a c14n pipe
+// call: prefixes separated by spaces, with "#default" for the default
+// namespace.
+func inclusivePrefixes(call pipeCall) (map[string]bool, error) {
+	prefixes := map[string]bool{}
+	if len(call.args) == 0 {
+		return prefixes, nil
+	}
+	list, err := stringArg(call, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, prefix := range strings.Fields(list) {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		prefixes[prefix] = true
+	}
+	return prefixes, nil
+}
+
+// canonicalXMLNodes returns the canonical XML of the elements or documents
+// nodes, one after the other. Each element is serialized with the namespace
+// declarations of its ancestors, so the prefixes it uses stay bound.
+func canonicalXMLNodes(nodes []*xmlquery.Node, inclusive map[string]bool) (string, error) {
+	var out strings.Builder
+	for _, node := range nodes {
+		if node.Type == xmlquery.ElementNode {
+			node = withInScopeNamespaces(node)
+		}
+		canonical, err := canonicalXML([]byte(node.OutputXML(true)), nil, inclusive)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(canonical)
+	}
+	return out.String(), nil
+}
+
+// withInScopeNamespaces returns a copy of element that declares the
+// namespaces its ancestors declare and it does not redeclare.
+func withInScopeNamespaces(element *xmlquery.Node) *xmlquery.Node {
+	copied := deepCopy(element)
+	declared := map[string]bool{}
+	for n := element; n != nil; n = n.Parent {
+		for _, attr := range n.Attr {
+			prefix, ok := namespaceDeclaration(attr)
+			if !ok || declared[prefix] {
+				continue
+			}
+			declared[prefix] = true
+			if n != element {
+				copied.Attr = append(copied.Attr, attr)
+			}
+		}
+	}
+	return copied
+}
+
+// namespaceDeclaration returns the prefix attr declares if it is a namespace
+// declaration, with "" for the default namespace.
+func namespaceDeclaration(attr xmlquery.Attr) (string, bool) {
+	switch {
+	case attr.Name.Space == "xmlns":
+		return attr.Name.Local, true
+	case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+		return "", true
+	}
+	return "", false
+}
+
+// canonicalXML returns the Exclusive XML Canonicalization (without comments)
+// of content: the XML declaration, DOCTYPE and comments are dropped, empty
+// elements get end tags, attributes are sorted, character and entity
+// references are replaced, and an element declares only the namespaces it
+// or its attributes use, unless an output ancestor already declares them.
+// Prefixes in inclusive are declared like in inclusive canonicalization,
+// whenever they are in scope. entities are the entities the DTD declares.
+func canonicalXML(content []byte, entities map[string]string, inclusive map[string]bool) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.Entity = entities
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	var out strings.Builder
+	var stack []*c14nFrame
+	root := &c14nFrame{inScope: map[string]string{"": ""}, rendered: map[string]string{"": ""}}
+	seenRoot := false
+	for {
+		// RawToken keeps namespace prefixes, which canonicalization must
+		// preserve; element nesting is checked here instead
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		parent := root
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 && seenRoot {
+				return "", fmt.Errorf("more than one document element")
+			}
+			seenRoot = true
+			frame, err := writeCanonicalStartTag(&out, token, parent, inclusive)
+			if err != nil {
+				return "", err
+			}
+			stack = append(stack, frame)
+		case xml.EndElement:
+			name := qualifiedName(token.Name.Space, token.Name.Local)
+			if len(stack) == 0 || stack[len(stack)-1].name != name {
+				return "", fmt.Errorf("unexpected end element </%s>", name)
+			}
+			stack = stack[:len(stack)-1]
+			out.WriteString("</" + name + ">")
+		case xml.CharData:
+			if len(stack) > 0 {
+				writeCanonicalText(&out, string(token), false)
+			}
+		case xml.ProcInst:
+			if token.Target == "xml" {
+				continue
+			}
+			if len(stack) == 0 && seenRoot {
+				out.WriteByte('\n')
+			}
+			out.WriteString("<?" + token.Target)
+			if inst := strings.TrimLeft(string(token.Inst), " \t\r\n"); inst != "" {
+				out.WriteString(" " + inst)
+			}
+			out.WriteString("?>")
+			if len(stack) == 0 && !seenRoot {
+				out.WriteByte('\n')
+			}
+		}
+	}
+	if !seenRoot {
+		return "", fmt.Errorf("the document has no element")
+	}
+	if len(stack) > 0 {
+		return "", fmt.Errorf("element <%s> is not closed", stack[len(stack)-1].name)
+	}
+	return out.String(), nil
+}
+
+// writeCanonicalStartTag writes the canonical start tag of element and
+// returns its frame.
+func writeCanonicalStartTag(out *strings.Builder, element xml.StartElement, parent *c14nFrame, inclusive map[string]bool) (*c14nFrame, error) {
+	frame := &c14nFrame{
+		name:     qualifiedName(element.Name.Space, element.Name.Local),
+		inScope:  make(map[string]string, len(parent.inScope)),
+		rendered: make(map[string]string, len(parent.rendered)),
+	}
+	for prefix, uri := range parent.inScope {
+		frame.inScope[prefix] = uri
+	}
+	for prefix, uri := range parent.rendered {
+		frame.rendered[prefix] = uri
+	}
+	var attrs []xml.Attr
+	for _, attr := range element.Attr {
+		switch {
+		case attr.Name.Space == "xmlns":
+			frame.inScope[attr.Name.Local] = attr.Value
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			frame.inScope[""] = attr.Value
+		default:
+			attrs = append(attrs, attr)
+		}
+	}
+
+	// Namespaces visibly utilized by the element and its attributes
+	utilized := map[string]bool{element.Name.Space: true}
+	for _, attr := range attrs {
+		if attr.Name.Space != "" {
+			utilized[attr.Name.Space] = true
+		}
+	}
+	for prefix := range inclusive {
+		if _, ok := frame.inScope[prefix]; ok {
+			utilized[prefix] = true
+		}
+	}
+
+	var namespaces, sorted []c14nAttr
+	for prefix := range utilized {
+		if prefix == "xml" {
+			continue
+		}
+		uri, ok := frame.inScope[prefix]
+		if !ok {
+			return nil, fmt.Errorf("namespace prefix %q of <%s> is not declared", prefix, frame.name)
+		}
+		if current, ok := frame.rendered[prefix]; ok && current == uri {
+			continue
+		}
+		if prefix != "" && uri == "" {
+			continue // An undeclaration, only allowed in XML 1.1
+		}
+		frame.rendered[prefix] = uri
+		name := "xmlns"
+		if prefix != "" {
+			name += ":" + prefix
+		}
+		namespaces = append(namespaces, c14nAttr{name: name, local: prefix, value: uri})
+	}
+	for _, attr := range attrs {
+		namespace := ""
+		switch {
+		case attr.Name.Space == "xml":
+			namespace = xmlNamespace
+		case attr.Name.Space != "":
+			namespace = frame.inScope[attr.Name.Space]
+		}
+		sorted = append(sorted, c14nAttr{name: qualifiedName(attr.Name.Space, attr.Name.Local), namespace: namespace, local: attr.Name.Local, value: attr.Value})
+	}
+	// Namespace declarations are sorted by prefix, the default first, and
+	// attributes by namespace URI, then local name
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].local < namespaces[j].local })
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].namespace != sorted[j].namespace {
+			return sorted[i].namespace < sorted[j].namespace
+		}
+		return sorted[i].local < sorted[j].local
+	})
+
+	out.WriteString("<" + frame.name)
+	for _, attr := range append(namespaces, sorted...) {
+		out.WriteString(" " + attr.name + `="`)
+		writeCanonicalText(out, attr.value, true)
+		out.WriteString(`"`)
+	}
+	out.WriteString(">")
+	return frame, nil
+}
+
+// writeCanonicalText writes s escaped as canonical XML text, or as an
+// attribute value.
+func writeCanonicalText(out *strings.Builder, s string, attribute bool) {
+	for _, r := range s {
+		switch {
+		case r == '&':
+			out.WriteString("&amp;")
+		case r == '<':
+			out.WriteString("&lt;")
+		case r == '>' && !attribute:
+			out.WriteString("&gt;")
+		case r == '"' && attribute:
+			out.WriteString("&quot;")
+		case r == '\t' && attribute:
+			out.WriteString("&#x9;")
+		case r == '\n' && attribute:
+			out.WriteString("&#xA;")
+		case r == '\r':
+			out.WriteString("&#xD;")
+		default:
+			out.WriteRune(r)
+		}
+	}
+}
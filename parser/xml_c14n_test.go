@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestC14NPipe(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		pipe    string
+		options XMLOptions
+		want    string
+		wantErr string
+	}{
+		{name: "declaration, comments and empty elements", xml: "<?xml version=\"1.0\"?>\n<!-- c -->\n<doc b=\"2\" a='1'><e/><!-- x --><f>a &amp; b &gt; &#x41;</f></doc>\n", want: `<doc a="1" b="2"><e></e><f>a &amp; b &gt; A</f></doc>`},
+		{name: "unused namespaces pushed down", xml: `<a:r xmlns:a="urn:a" xmlns:b="urn:b" xmlns="urn:d"><a:c/><b:d/><e/></a:r>`, want: `<a:r xmlns:a="urn:a"><a:c></a:c><b:d xmlns:b="urn:b"></b:d><e xmlns="urn:d"></e></a:r>`},
+		{name: "redundant declarations dropped", xml: `<a:r xmlns:a="urn:a"><a:c xmlns:a="urn:a"><a:d xmlns:a="urn:other"/></a:c></a:r>`, want: `<a:r xmlns:a="urn:a"><a:c><a:d xmlns:a="urn:other"></a:d></a:c></a:r>`},
+		{name: "attributes sorted by namespace", xml: `<r xmlns:z="urn:a" xmlns:a="urn:z" a:x="1" z:y="2" b="3"/>`, want: `<r xmlns:a="urn:z" xmlns:z="urn:a" b="3" z:y="2" a:x="1"></r>`},
+		{name: "xml attributes", xml: `<r xml:lang="en" id="1"/>`, want: `<r id="1" xml:lang="en"></r>`},
+		{name: "default namespace reset", xml: `<r xmlns="urn:d"><c xmlns=""/></r>`, want: `<r xmlns="urn:d"><c xmlns=""></c></r>`},
+		{name: "inclusive prefix", xml: `<r xmlns:s="urn:s"><c/></r>`, pipe: `c14n("s #default")`, want: `<r xmlns:s="urn:s"><c></c></r>`},
+		{name: "exclusive by default", xml: `<r xmlns:s="urn:s"><c/></r>`, want: `<r><c></c></r>`},
+		{name: "attribute escaping", xml: "<r a=\"x&quot;y&#9;z&#10;&lt;&gt;\"/>", want: `<r a="x&quot;y&#x9;z&#xA;&lt;>"></r>`},
+		{name: "cdata", xml: `<r><![CDATA[<a> & b]]></r>`, want: `<r>&lt;a&gt; &amp; b</r>`},
+		{name: "whitespace kept", xml: "<r>\r\n  <c> x </c>\n</r>", want: "<r>\n  <c> x </c>\n</r>"},
+		{name: "processing instructions", xml: "<?pi one?>\n<r><?in x?></r><?after?>", want: "<?pi one?>\n<r><?in x?></r>\n<?after?>"},
+		{name: "internal subset entity", xml: `<!DOCTYPE r [<!ENTITY co "ACME">]><r>&co;</r>`, options: XMLOptions{DTD: DTDInternalSubset}, want: `<r>ACME</r>`},
+		{name: "doctype forbidden", xml: `<!DOCTYPE r><r/>`, options: XMLOptions{DTD: DTDForbid}, wantErr: "not allowed"},
+		{name: "mismatched end tag", xml: `<r><c></r>`, wantErr: "unexpected end element </r>"},
+		{name: "unclosed element", xml: `<r><c/>`, wantErr: "pipe operation 'c14n' failed"},
+		{name: "undeclared prefix", xml: `<p:r/>`, wantErr: `namespace prefix "p" of <p:r> is not declared`},
+		{name: "two document elements", xml: `<a/><b/>`, wantErr: "more than one document element"},
+		{name: "not xml", xml: "plain text", wantErr: "the document has no element"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(map[string]string{"xml": tt.xml})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pipe := tt.pipe
+			if pipe == "" {
+				pipe = c14nPipe
+			}
+			engine := NewEngine()
+			engine.SetXMLOptions(tt.options)
+			result, err := NewMessageContext(body, "application/json", engine).EvaluateExpression("jsonpath:xml | " + pipe)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got  %q\nwant %q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestC14NPipeOnNodeSets(t *testing.T) {
+	const body = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="urn:s" xmlns:ds="urn:ds"><s:Body><x b="2" a="1"><ds:v/></x><x xmlns:e="urn:e"><y xmlns="urn:d"/></x><z xmlns="urn:d"><w/></z></s:Body></s:Envelope>`
+
+	tests := []struct {
+		name       string
+		expression string
+		want       string
+	}{
+		{name: "one element", expression: "xpath://x[1] | c14n", want: `<x a="1" b="2"><ds:v xmlns:ds="urn:ds"></ds:v></x>`},
+		{name: "several elements", expression: "xpath://x | c14n", want: `<x a="1" b="2"><ds:v xmlns:ds="urn:ds"></ds:v></x><x><y xmlns="urn:d"></y></x>`},
+		{name: "default namespace of an ancestor", expression: "xpath://*[local-name()='w'] | c14n", want: `<w xmlns="urn:d"></w>`},
+		{name: "inclusive prefix", expression: `xpath://x[2] | c14n("s")`, want: `<x xmlns:s="urn:s"><y xmlns="urn:d"></y></x>`},
+		{name: "document", expression: "xpath:/ | c14n", want: `<s:Envelope xmlns:s="urn:s"><s:Body><x a="1" b="2"><ds:v xmlns:ds="urn:ds"></ds:v></x><x><y xmlns="urn:d"></y></x><z xmlns="urn:d"><w></w></z></s:Body></s:Envelope>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(body), "application/xml", NewEngine()).EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %q, want %q", result.Value, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"golang.org/x/net/html/charset"
+)
+
+// DTDMode controls how XML payloads treat a document type declaration.
+// External DTDs and external entities are never fetched, whatever the mode.
+type DTDMode int
+
+const (
+	// DTDIgnore skips the DOCTYPE declaration (the default). References to
+	// entities it declares fail to parse; the five predefined entities and
+	// character references work as usual.
+	DTDIgnore DTDMode = iota
+	// DTDInternalSubset expands the general entities declared with a literal
+	// value in the internal subset, e.g. <!ENTITY company "ACME Corp">.
+	// External and parameter entities are not resolved.
+	DTDInternalSubset
+	// DTDForbid rejects documents that have a DOCTYPE declaration.
+	DTDForbid
+)
+
+// XMLOptions configures how XML payloads, including SOAP envelopes, are parsed.
+type XMLOptions struct {
+	DTD DTDMode
+	// Repair re-parses content that is not well-formed leniently instead of
+	// failing, e.g. HTML fragments passed to extractAsXML. See parseTagSoup.
+	Repair bool
+	// MultiDocument parses the content as a stream of concatenated
+	// documents, each addressable with "doc(n)", e.g. "xpath:doc(2)//id".
+	MultiDocument bool
+}
+
+// Limits on internal subset entities, against "billion laughs" documents.
+const (
+	maxEntityValueSize = 64 << 10 // Bytes of a single expanded entity
+	maxEntityExpansion = 8 << 20  // Bytes added to a document by entity references
+)
+
+// entityDeclPattern matches general entity declarations with a literal
+// value. Parameter entities ("<!ENTITY % name ...>") and external entities
+// ("<!ENTITY name SYSTEM ...>") do not match.
+var entityDeclPattern = regexp.MustCompile(`<!ENTITY\s+([^\s%"'>]+)\s+(?:"([^"]*)"|'([^']*)')\s*>`)
+
+// entityRefPattern matches entity and character references.
+var entityRefPattern = regexp.MustCompile(`&(#x[0-9a-fA-F]+|#[0-9]+|[^\s&;#]+);`)
+
+// predefinedEntities are the entities every XML document may reference.
+var predefinedEntities = map[string]string{"lt": "<", "gt": ">", "amp": "&", "apos": "'", "quot": `"`}
+
+// NewXMLPayloadWithOptions is like NewXMLPayload but treats a DOCTYPE
+// declaration as options.DTD says, and splits concatenated documents when
+// options.MultiDocument is set.
+func NewXMLPayloadWithOptions(content []byte, options XMLOptions) (*XMLPayload, error) {
+	if options.MultiDocument {
+		return newMultiDocumentXMLPayload(content, options)
+	}
+
+	entities, err := xmlEntities(content, options)
+	if err != nil {
+		return nil, err
+	}
+	if entities == nil && !options.Repair {
+		return NewXMLPayload(content)
+	}
+
+	doc, err := xmlquery.ParseWithOptions(bytes.NewReader(content), xmlquery.ParserOptions{
+		Decoder: &xmlquery.DecoderOptions{Strict: true, Entity: entities, CharsetReader: charset.NewReaderLabel},
+	})
+	if err != nil && options.Repair {
+		doc, err = parseTagSoup(content, entities)
+	}
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "XML parsing failed", InnerError: err}
+	}
+	return &XMLPayload{
+		rawContent:  content,
+		parsedDoc:   doc,
+		contentType: "application/xml",
+	}, nil
+}
+
+// xmlEntities applies the DTD policy of options to content and returns the
+// entities declared in its internal subset, if options.DTD allows them.
+func xmlEntities(content []byte, options XMLOptions) (map[string]string, error) {
+	if options.DTD == DTDIgnore {
+		return nil, nil
+	}
+	doctype, err := xmlDoctype(content)
+	if err != nil && !options.Repair {
+		return nil, &ErrEvaluationFailed{Reason: "XML parsing failed", InnerError: err}
+	}
+	if doctype == "" {
+		return nil, nil
+	}
+	if options.DTD == DTDForbid {
+		return nil, &ErrEvaluationFailed{Reason: "XML document has a DOCTYPE declaration, which is not allowed"}
+	}
+	entities, err := internalSubsetEntities(doctype)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "XML DTD processing failed", InnerError: err}
+	}
+	expansion := 0
+	for name, value := range entities {
+		expansion += bytes.Count(content, []byte("&"+name+";")) * len(value)
+		if expansion > maxEntityExpansion {
+			return nil, &ErrEvaluationFailed{Reason: "XML DTD processing failed", InnerError: fmt.Errorf("entity references expand to more than %d bytes", maxEntityExpansion)}
+		}
+	}
+	return entities, nil
+}
+
+// xmlDoctype returns the DOCTYPE declaration of content without its "<!"
+// and ">" delimiters, or "" if the prolog has none.
+func xmlDoctype(content []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = charset.NewReaderLabel
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.Directive:
+			if bytes.HasPrefix(t, []byte("DOCTYPE")) {
+				return string(t), nil
+			}
+		case xml.StartElement:
+			return "", nil
+		}
+	}
+}
+
+// internalSubsetEntities returns the general entities declared in the
+// internal subset of doctype, with references in their values expanded.
+func internalSubsetEntities(doctype string) (map[string]string, error) {
+	start := strings.IndexByte(doctype, '[')
+	end := strings.LastIndexByte(doctype, ']')
+	if start < 0 || end < start {
+		return map[string]string{}, nil
+	}
+
+	declared := make(map[string]string)
+	for _, match := range entityDeclPattern.FindAllStringSubmatch(doctype[start+1:end], -1) {
+		if _, ok := declared[match[1]]; ok {
+			continue // The first declaration of an entity is binding
+		}
+		declared[match[1]] = match[2] + match[3]
+	}
+
+	entities := make(map[string]string, len(declared))
+	for name := range declared {
+		value, err := expandEntity(name, declared, entities, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		entities[name] = value
+	}
+	return entities, nil
+}
+
+// expandEntity returns the value of the declared entity name with the
+// references it contains replaced. expanded caches finished entities and
+// active holds the entities being expanded, to detect recursion.
+func expandEntity(name string, declared, expanded map[string]string, active map[string]bool) (string, error) {
+	if value, ok := expanded[name]; ok {
+		return value, nil
+	}
+	if active[name] {
+		return "", fmt.Errorf("entity %q references itself", name)
+	}
+	active[name] = true
+	defer delete(active, name)
+
+	var sb strings.Builder
+	value := declared[name]
+	last := 0
+	for _, loc := range entityRefPattern.FindAllStringSubmatchIndex(value, -1) {
+		sb.WriteString(value[last:loc[0]])
+		last = loc[1]
+
+		ref := value[loc[2]:loc[3]]
+		switch {
+		case strings.HasPrefix(ref, "#"):
+			r, err := parseCharRef(ref)
+			if err != nil {
+				return "", fmt.Errorf("entity %q: %w", name, err)
+			}
+			sb.WriteRune(r)
+		case predefinedEntities[ref] != "":
+			sb.WriteString(predefinedEntities[ref])
+		default:
+			if _, ok := declared[ref]; !ok {
+				return "", fmt.Errorf("entity %q references undeclared entity %q", name, ref)
+			}
+			nested, err := expandEntity(ref, declared, expanded, active)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(nested)
+		}
+		if sb.Len() > maxEntityValueSize {
+			return "", fmt.Errorf("entity %q expands to more than %d bytes", name, maxEntityValueSize)
+		}
+	}
+	sb.WriteString(value[last:])
+	if sb.Len() > maxEntityValueSize {
+		return "", fmt.Errorf("entity %q expands to more than %d bytes", name, maxEntityValueSize)
+	}
+	expanded[name] = sb.String()
+	return expanded[name], nil
+}
+
+// parseCharRef decodes a character reference such as "#38" or "#x26".
+func parseCharRef(ref string) (rune, error) {
+	var n uint64
+	var err error
+	if strings.HasPrefix(ref, "#x") {
+		n, err = strconv.ParseUint(ref[2:], 16, 32)
+	} else {
+		n, err = strconv.ParseUint(ref[1:], 10, 32)
+	}
+	if err != nil || n == 0 || n > 0x10FFFF {
+		return 0, fmt.Errorf("invalid character reference &%s;", ref)
+	}
+	return rune(n), nil
+}
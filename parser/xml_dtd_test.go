@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXMLDTDModes(t *testing.T) {
+	withEntities := `<?xml version="1.0"?>
+<!DOCTYPE order [
+  <!ENTITY company "ACME &amp; Sons">
+  <!ENTITY signature "&company; &#8212; Sales">
+  <!ENTITY logo SYSTEM "file:///etc/passwd">
+  <!ENTITY % shared "ignored">
+]>
+<order><from>&signature;</from><status>open</status></order>`
+	withoutReferences := `<!DOCTYPE order SYSTEM "order.dtd"><order><status>open</status></order>`
+	laughs := `<!DOCTYPE a [<!ENTITY l0 "lololololololololololololololololololololololololololololololololololol">` +
+		`<!ENTITY l1 "&l0;&l0;&l0;&l0;&l0;&l0;&l0;&l0;&l0;&l0;"><!ENTITY l2 "&l1;&l1;&l1;&l1;&l1;&l1;&l1;&l1;&l1;&l1;">` +
+		`<!ENTITY l3 "&l2;&l2;&l2;&l2;&l2;&l2;&l2;&l2;&l2;&l2;">]><a>&l3;</a>`
+
+	tests := []struct {
+		name        string
+		mode        DTDMode
+		raw         string
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     string
+	}{
+		{name: "ignore without references", mode: DTDIgnore, raw: withoutReferences, expression: "xpath:string(/order/status)", want: "open"},
+		{name: "ignore with references", mode: DTDIgnore, raw: withEntities, expression: "xpath:string(/order/from)", wantErr: "XML parsing failed"},
+		{name: "internal subset", mode: DTDInternalSubset, raw: withEntities, expression: "xpath:string(/order/from)", want: "ACME & Sons — Sales"},
+		{name: "internal subset without subset", mode: DTDInternalSubset, raw: withoutReferences, expression: "xpath:string(/order/status)", want: "open"},
+		{name: "internal subset no doctype", mode: DTDInternalSubset, raw: "<order>&amp;</order>", expression: "xpath:string(/order)", want: "&"},
+		{name: "external entity not resolved", mode: DTDInternalSubset, raw: strings.Replace(withEntities, "&signature;", "&logo;", 1), expression: "xpath:/order", wantErr: "XML parsing failed"},
+		{name: "recursive entity", mode: DTDInternalSubset, raw: `<!DOCTYPE a [<!ENTITY x "&y;"><!ENTITY y "&x;">]><a>&x;</a>`, expression: "xpath:/a", wantErr: "references itself"},
+		{name: "billion laughs", mode: DTDInternalSubset, raw: laughs, expression: "xpath:/a", wantErr: "expands to more than"},
+		{name: "soap envelope", mode: DTDInternalSubset, contentType: "application/soap+xml", raw: `<!DOCTYPE e [<!ENTITY id "A-1">]><Envelope xmlns="http://www.w3.org/2003/05/soap-envelope"><Body><id>&id;</id></Body></Envelope>`, expression: "xpath:string(//*[local-name()='id'])", want: "A-1"},
+		{name: "forbid", mode: DTDForbid, raw: withoutReferences, expression: "xpath:/order", wantErr: "DOCTYPE declaration, which is not allowed"},
+		{name: "forbid soap", mode: DTDForbid, contentType: "text/xml", raw: `<!DOCTYPE e><Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/"><Body/></Envelope>`, expression: "xpath:/*", wantErr: "not allowed"},
+		{name: "forbid no doctype", mode: DTDForbid, raw: "<order><status>open</status></order>", expression: "xpath:string(/order/status)", want: "open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType := tt.contentType
+			if contentType == "" {
+				contentType = "application/xml"
+			}
+			engine := NewEngine()
+			engine.SetXMLOptions(XMLOptions{DTD: tt.mode})
+			result, err := NewMessageContext([]byte(tt.raw), contentType, engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
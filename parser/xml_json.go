@@ -0,0 +1,320 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/tidwall/gjson"
+)
+
+// Conventions of the JSON view of XML used by jsonToXml and xmlToJson:
+// members whose name starts with the attribute prefix are attributes, the
+// text member is the element's text, and array elements that are arrays
+// themselves are written as item elements.
+const (
+	defaultXMLRoot      = "root"
+	defaultAttrPrefix   = "@"
+	xmlTextMember       = "#text"
+	xmlArrayItemElement = "item"
+)
+
+// jsonToXMLOptions returns the root element name and attribute prefix of a
+// jsonToXml pipe call.
+func jsonToXMLOptions(call pipeCall) (root, attrPrefix string, err error) {
+	root, attrPrefix = defaultXMLRoot, defaultAttrPrefix
+	if len(call.args) > 0 {
+		if root, err = stringArg(call, 0); err != nil {
+			return "", "", err
+		}
+	}
+	if len(call.args) > 1 {
+		if attrPrefix, err = stringArg(call, 1); err != nil {
+			return "", "", err
+		}
+	}
+	return root, attrPrefix, nil
+}
+
+// jsonToXML converts a JSON document to XML with root as the document
+// element. Object members become child elements in the order of the JSON
+// text (array and object results are serialized with sorted keys), arrays
+// become repeated elements, and null becomes an empty element. Members named
+// with attrPrefix become attributes unless attrPrefix is empty.
+func jsonToXML(raw, root, attrPrefix string) (string, error) {
+	if !gjson.Valid(raw) {
+		return "", fmt.Errorf("input is not valid JSON")
+	}
+	var sb strings.Builder
+	value := gjson.Parse(raw)
+	if value.IsArray() {
+		// A root array is a list of items rather than repeated root elements
+		value = gjson.Parse(`{"` + xmlArrayItemElement + `":` + value.Raw + `}`)
+	}
+	if err := writeXMLElement(&sb, root, value, attrPrefix); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// writeXMLElement writes value as elements named name: one element per
+// element of an array, otherwise one element.
+func writeXMLElement(sb *strings.Builder, name string, value gjson.Result, attrPrefix string) error {
+	if !isXMLName(name) {
+		return fmt.Errorf("%q is not a valid XML element name", name)
+	}
+	if value.IsArray() {
+		var err error
+		value.ForEach(func(_, element gjson.Result) bool {
+			if element.IsArray() {
+				element = gjson.Parse(`{"` + xmlArrayItemElement + `":` + element.Raw + `}`)
+			}
+			err = writeXMLElement(sb, name, element, attrPrefix)
+			return err == nil
+		})
+		return err
+	}
+
+	sb.WriteString("<" + name)
+	if !value.IsObject() {
+		if value.Type == gjson.Null {
+			sb.WriteString("/>")
+			return nil
+		}
+		sb.WriteString(">")
+		writeXMLText(sb, value)
+		sb.WriteString("</" + name + ">")
+		return nil
+	}
+
+	// Attributes are written first, wherever they are in the object
+	var err error
+	value.ForEach(func(key, member gjson.Result) bool {
+		attr, isAttr := strings.CutPrefix(key.String(), attrPrefix)
+		if attrPrefix == "" || !isAttr {
+			return true
+		}
+		if !isXMLName(attr) {
+			err = fmt.Errorf("%q is not a valid XML attribute name", attr)
+		} else if member.IsObject() || member.IsArray() {
+			err = fmt.Errorf("attribute %q of <%s> must be a string, number, boolean or null", attr, name)
+		}
+		if err != nil {
+			return false
+		}
+		sb.WriteString(" " + attr + `="`)
+		writeXMLText(sb, member)
+		sb.WriteString(`"`)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	sb.WriteString(">")
+	value.ForEach(func(key, member gjson.Result) bool {
+		switch name := key.String(); {
+		case attrPrefix != "" && strings.HasPrefix(name, attrPrefix):
+		case name == xmlTextMember:
+			writeXMLText(sb, member)
+		default:
+			err = writeXMLElement(sb, name, member, attrPrefix)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	sb.WriteString("</" + name + ">")
+	return nil
+}
+
+// writeXMLText writes a scalar as escaped text: strings by their value,
+// numbers and booleans as they appear in the JSON, and null as nothing.
+func writeXMLText(sb *strings.Builder, value gjson.Result) {
+	text := value.Raw
+	switch value.Type {
+	case gjson.String:
+		text = value.String()
+	case gjson.Null:
+		return
+	}
+	xml.EscapeText(sb, []byte(text)) // Writing to a strings.Builder does not fail
+}
+
+// isXMLName reports whether name is a valid XML name without a namespace
+// prefix.
+func isXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && (r == '-' || r == '.' || unicode.IsDigit(r)):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// attrPrefixOption returns the attribute prefix of an xmlToJson pipe call.
+func attrPrefixOption(call pipeCall) (string, error) {
+	if len(call.args) == 0 {
+		return defaultAttrPrefix, nil
+	}
+	return stringArg(call, 0)
+}
+
+// xmlToJSON converts the document element of doc to a JSON object with the
+// element's name as its only member, e.g. {"order": {...}}. Elements with
+// neither attributes nor child elements become their text, or null when they
+// are empty. Other elements become objects: attributes are members named with
+// attrPrefix, text other than whitespace is the "#text" member, and child
+// elements are members in document order, with repeated names collected into
+// arrays. Values are always strings, and namespace declarations are dropped.
+func xmlToJSON(doc *xmlquery.Node, attrPrefix string) (string, error) {
+	root := doc
+	if root.Type == xmlquery.DocumentNode {
+		root = root.FirstChild
+		for root != nil && root.Type != xmlquery.ElementNode {
+			root = root.NextSibling
+		}
+	}
+	if root == nil {
+		return "", fmt.Errorf("the document has no element")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	writeJSONString(&buf, qualifiedName(root.Prefix, root.Data))
+	buf.WriteByte(':')
+	writeElementJSON(&buf, root, attrPrefix)
+	buf.WriteByte('}')
+	return buf.String(), nil
+}
+
+// xmlToJSONSource returns the nodes the xmlToJson pipe converts: the
+// elements or documents the previous result selected, or the previous result
+// parsed as XML.
+func (ee *ExpressionEngine) xmlToJSONSource(result QueryResult, text string) ([]*xmlquery.Node, error) {
+	if nodes, ok := selectedXMLElements(result); ok {
+		return nodes, nil
+	}
+	xmlPayload, err := ee.payloadFactory.createXMLPayload([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	return []*xmlquery.Node{xmlPayload.parsedDoc}, nil
+}
+
+// xmlNodesToJSON converts the elements or documents nodes like xmlToJSON:
+// one node becomes an object, several an array of such objects.
+func xmlNodesToJSON(nodes []*xmlquery.Node, attrPrefix string) (string, error) {
+	if len(nodes) == 1 {
+		return xmlToJSON(nodes[0], attrPrefix)
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, node := range nodes {
+		converted, err := xmlToJSON(node, attrPrefix)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(converted)
+	}
+	buf.WriteByte(']')
+	return buf.String(), nil
+}
+
+// writeElementJSON writes the JSON value of element n.
+func writeElementJSON(buf *bytes.Buffer, n *xmlquery.Node, attrPrefix string) {
+	var text strings.Builder
+	var names []string
+	children := map[string][]*xmlquery.Node{}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case xmlquery.TextNode, xmlquery.CharDataNode:
+			text.WriteString(child.Data)
+		case xmlquery.ElementNode:
+			name := qualifiedName(child.Prefix, child.Data)
+			if _, seen := children[name]; !seen {
+				names = append(names, name)
+			}
+			children[name] = append(children[name], child)
+		}
+	}
+	var attrs []xmlquery.Attr
+	for _, attr := range n.Attr {
+		if attr.Name.Space != "xmlns" && (attr.Name.Space != "" || attr.Name.Local != "xmlns") {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	if len(attrs) == 0 && len(names) == 0 {
+		if text.Len() == 0 {
+			buf.WriteString("null")
+			return
+		}
+		writeJSONString(buf, text.String())
+		return
+	}
+
+	buf.WriteByte('{')
+	comma := false
+	member := func(name string) {
+		if comma {
+			buf.WriteByte(',')
+		}
+		comma = true
+		writeJSONString(buf, name)
+		buf.WriteByte(':')
+	}
+	for _, attr := range attrs {
+		member(attrPrefix + qualifiedName(attr.Name.Space, attr.Name.Local))
+		writeJSONString(buf, attr.Value)
+	}
+	if trimmed := strings.TrimSpace(text.String()); trimmed != "" {
+		member(xmlTextMember)
+		writeJSONString(buf, trimmed)
+	}
+	for _, name := range names {
+		member(name)
+		elements := children[name]
+		if len(elements) == 1 {
+			writeElementJSON(buf, elements[0], attrPrefix)
+			continue
+		}
+		buf.WriteByte('[')
+		for i, element := range elements {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeElementJSON(buf, element, attrPrefix)
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteByte('}')
+}
+
+// qualifiedName returns local with its namespace prefix, if any.
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+// writeJSONString writes s as a JSON string, without escaping HTML characters.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)           // Encoding a string does not fail
+	buf.Truncate(buf.Len() - 1) // Encode ends the value with a newline
+}
@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONToXMLPipe(t *testing.T) {
+	body := []byte(`{
+		"order": {"@id": "A-7", "@rush": true, "customer": "Smith & Sons", "lines": [{"sku": "X1", "qty": 2}, {"sku": "Y2", "qty": 1.50}], "note": null, "total": {"@currency": "EUR", "#text": 99.5}},
+		"list": [1, [2, 3], {"a": "b"}],
+		"embedded": "{\"b\": \"<hi>\", \"a\": 1.50}",
+		"badKey": {"1st": 1},
+		"badAttr": {"@id": {"x": 1}},
+		"prefixed": {"_id": 7, "name": "n"}
+	}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "whole conversion", expression: `jsonpath:order | jsonToXml("order")`, want: `<order id="A-7" rush="true"><customer>Smith &amp; Sons</customer><lines><qty>2</qty><sku>X1</sku></lines><lines><qty>1.5</qty><sku>Y2</sku></lines><note/><total currency="EUR">99.5</total></order>`},
+		{name: "attribute query", expression: `jsonpath:order | jsonToXml("order") | xpath:string(/order/@id)`, want: "A-7"},
+		{name: "repeated elements", expression: `jsonpath:order | jsonToXml("order") | xpath:count(/order/lines)`, want: float64(2)},
+		{name: "text member", expression: `jsonpath:order | jsonToXml("order") | xpath:/order/total[@currency='EUR']`, want: "99.5"},
+		{name: "default root", expression: "jsonpath:order | jsonToXml | xpath:/root/customer", want: "Smith & Sons"},
+		{name: "root array", expression: "jsonpath:list | jsonToXml", want: "<root><item>1</item><item><item>2</item><item>3</item></item><item><a>b</a></item></root>"},
+		{name: "json text in order", expression: "jsonpath:embedded | jsonToXml", want: "<root><b>&lt;hi&gt;</b><a>1.50</a></root>"},
+		{name: "custom attribute prefix", expression: `jsonpath:prefixed | jsonToXml("p", "_")`, want: `<p id="7"><name>n</name></p>`},
+		{name: "attributes disabled", expression: `jsonpath:prefixed | jsonToXml("p", "")`, want: "<p><_id>7</_id><name>n</name></p>"},
+		{name: "attribute without prefix", expression: `jsonpath:order.total | jsonToXml("total", "")`, wantErr: `"@currency" is not a valid XML element name`},
+		{name: "invalid element name", expression: "jsonpath:badKey | jsonToXml", wantErr: `"1st" is not a valid XML element name`},
+		{name: "invalid root name", expression: `jsonpath:order | jsonToXml("a b")`, wantErr: "not a valid XML element name"},
+		{name: "object attribute", expression: "jsonpath:badAttr | jsonToXml", wantErr: `attribute "id" of <root> must be a string`},
+		{name: "not json", expression: "jsonpath:order.customer | jsonToXml", wantErr: "input is not valid JSON"},
+		{name: "root not a string", expression: "jsonpath:order | jsonToXml(1)", wantErr: "must be a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestXMLToJSONPipe(t *testing.T) {
+	body := []byte(`{
+		"order": "<order id=\"A-7\" xmlns=\"urn:o\"><line sku=\"X1\">2</line><line sku=\"Y2\">1</line><note/><customer>Smith &amp; Sons</customer><total currency=\"EUR\">99.5</total></order>",
+		"mixed": "<p>Hello <b>you</b> there</p>",
+		"soap": "<soap:Envelope xmlns:soap=\"urn:s\"><soap:Body><id>7</id></soap:Body></soap:Envelope>",
+		"cdata": "<a><![CDATA[1 < 2]]></a>",
+		"single": "<ids><id>1</id></ids>",
+		"json": {"a": 1}
+	}`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "whole conversion", expression: "jsonpath:order | xmlToJson", want: `{"order":{"@id":"A-7","line":[{"@sku":"X1","#text":"2"},{"@sku":"Y2","#text":"1"}],"note":null,"customer":"Smith & Sons","total":{"@currency":"EUR","#text":"99.5"}}}`},
+		{name: "attribute", expression: "jsonpath:order | xmlToJson | jsonpath:order.@id", want: "A-7"},
+		{name: "text member", expression: `jsonpath:order | xmlToJson | jsonpath:order.total.\#text`, want: "99.5"},
+		{name: "repeated elements", expression: `jsonpath:order | xmlToJson | jsonpath:order.line.#`, want: float64(2)},
+		{name: "single element", expression: "jsonpath:single | xmlToJson", want: `{"ids":{"id":"1"}}`},
+		{name: "mixed content", expression: "jsonpath:mixed | xmlToJson", want: `{"p":{"#text":"Hello  there","b":"you"}}`},
+		{name: "namespace prefixes", expression: "jsonpath:soap | xmlToJson", want: `{"soap:Envelope":{"soap:Body":{"id":"7"}}}`},
+		{name: "cdata", expression: "jsonpath:cdata | xmlToJson", want: `{"a":"1 < 2"}`},
+		{name: "custom attribute prefix", expression: `jsonpath:order | xmlToJson("-") | jsonpath:order.-id`, want: "A-7"},
+		{name: "round trip", expression: `jsonpath:order | xmlToJson | jsonpath:order | jsonToXml("order")`, want: `<order id="A-7"><customer>Smith &amp; Sons</customer><line sku="X1">2</line><line sku="Y2">1</line><note/><total currency="EUR">99.5</total></order>`},
+		{name: "not xml", expression: "jsonpath:json | xmlToJson", wantErr: "failed to create intermediate XML payload"},
+		{name: "prefix not a string", expression: "jsonpath:order | xmlToJson(1)", wantErr: "must be a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/json", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestXMLToJSONPipeOnNodeSets(t *testing.T) {
+	body := []byte(`<orders xmlns:a="urn:a"><order id="1"><a:total>5</a:total></order><order id="2"><a:total>7</a:total></order></orders>`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "document", expression: "xpath:/ | xmlToJson", want: `{"orders":{"order":[{"@id":"1","a:total":"5"},{"@id":"2","a:total":"7"}]}}`},
+		{name: "one element", expression: "xpath:/orders/order[2] | xmlToJson", want: `{"order":{"@id":"2","a:total":"7"}}`},
+		{name: "several elements", expression: "xpath://order | xmlToJson", want: `[{"order":{"@id":"1","a:total":"5"}},{"order":{"@id":"2","a:total":"7"}}]`},
+		{name: "several elements then jsonpath", expression: "xpath://order | xmlToJson | jsonpath:#.order.@id", want: []interface{}{"1", "2"}},
+		{name: "text of an element", expression: "xpath:string(//order[1]) | xmlToJson", wantErr: "failed to create intermediate XML payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/xml", NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+// newMultiDocumentXMLPayload parses content as a stream of concatenated XML
+// documents. Plain XPath expressions see all document elements under one
+// root, so "/*" iterates over the documents, while "doc(n)" addresses a
+// single document.
+func newMultiDocumentXMLPayload(content []byte, options XMLOptions) (*XMLPayload, error) {
+	segments, err := splitXMLDocuments(content)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "XML parsing failed", InnerError: err}
+	}
+
+	options.MultiDocument = false
+	combined := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	documents := make([]*xmlquery.Node, 0, len(segments))
+	for i, segment := range segments {
+		document, err := NewXMLPayloadWithOptions(segment, options)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		documents = append(documents, document.parsedDoc)
+
+		// A second tree, as a node has a single parent and set of siblings
+		copied, err := NewXMLPayloadWithOptions(segment, options)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		for child := copied.parsedDoc.FirstChild; child != nil; {
+			next := child.NextSibling
+			appendXMLChild(combined, child)
+			child = next
+		}
+	}
+	return &XMLPayload{
+		rawContent:  content,
+		parsedDoc:   combined,
+		documents:   documents,
+		contentType: "application/xml",
+	}, nil
+}
+
+// appendXMLChild moves node to the end of parent's children.
+func appendXMLChild(parent, node *xmlquery.Node) {
+	node.Parent = parent
+	node.NextSibling = nil
+	node.PrevSibling = parent.LastChild
+	if parent.LastChild != nil {
+		parent.LastChild.NextSibling = node
+	} else {
+		parent.FirstChild = node
+	}
+	parent.LastChild = node
+}
+
+// splitXMLDocuments splits content after the end of each document element.
+// Comments, processing instructions and DOCTYPE declarations between
+// documents belong to the document that follows them; those after the last
+// document belong to it.
+func splitXMLDocuments(content []byte) ([][]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.Strict = false // Entities are checked when the documents are parsed
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil // Only the structure matters here
+	}
+
+	var segments [][]byte
+	start, depth := 0, 0
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				end := int(decoder.InputOffset())
+				segments = append(segments, content[start:end])
+				start = end
+			}
+		case xml.CharData:
+			if depth == 0 && len(bytes.TrimSpace(t)) > 0 {
+				return nil, fmt.Errorf("text %q outside of a document element", bytes.TrimSpace(t))
+			}
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unexpected end of input in document %d", len(segments)+1)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("no document element")
+	}
+	last := len(segments) - 1
+	segments[last] = content[start-len(segments[last]):] // Keep trailing comments
+	return segments, nil
+}
+
+// cutDocumentSelector splits a "doc(n)" prefix off an XPath expression.
+// found is false when expr does not start with one.
+func cutDocumentSelector(expr string) (index int, rest string, found bool, err error) {
+	if !strings.HasPrefix(expr, "doc(") {
+		return 0, expr, false, nil
+	}
+	end := strings.IndexByte(expr, ')')
+	if end < 0 {
+		return 0, "", true, fmt.Errorf("unterminated doc(")
+	}
+	index, err = strconv.Atoi(strings.TrimSpace(expr[len("doc("):end]))
+	if err != nil || index < 1 {
+		return 0, "", true, fmt.Errorf("invalid document number %q: documents are numbered from 1", expr[len("doc("):end])
+	}
+	rest = strings.TrimSpace(expr[end+1:])
+	if rest != "" && !strings.HasPrefix(rest, "/") {
+		return 0, "", true, fmt.Errorf("unexpected '%s' after doc(%d): expected a location path", rest, index)
+	}
+	return index, rest, true, nil
+}
+
+// queryDocument evaluates "doc(n)" or "doc(n)/path" against the n-th
+// document of a multi-document payload. A bare "doc(n)" returns the
+// document as XML.
+func (xp *XMLPayload) queryDocument(ctx context.Context, expression string) (QueryResult, error) {
+	index, rest, _, err := cutDocumentSelector(expression)
+	if err != nil {
+		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: err.Error()}
+	}
+	if index > len(xp.documents) {
+		return QueryResult{Value: nil, Type: UnknownResult}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("document %d not found: %d document(s)", index, len(xp.documents)), InnerError: ErrNoMatch}
+	}
+	document := xp.documents[index-1]
+	if rest == "" {
+		return QueryResult{Value: document.OutputXML(false), Type: StringResult}, nil
+	}
+	single := &XMLPayload{parsedDoc: document, contentType: xp.contentType}
+	return single.QueryContext(ctx, rest)
+}
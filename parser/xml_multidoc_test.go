@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMultiDocumentXML(t *testing.T) {
+	stream := `<?xml version="1.0"?>
+<order><id>A-1</id></order>
+<?xml version="1.0"?>
+<!-- second -->
+<order><id>A-2</id><note>rush</note></order>
+<invoice><id>I-9</id></invoice>
+`
+
+	tests := []struct {
+		name       string
+		raw        string
+		options    XMLOptions
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "address document", raw: stream, expression: "xpath:doc(2)//id", want: "A-2"},
+		{name: "address with absolute path", raw: stream, expression: "xpath:doc(3)/invoice/id/text()", want: "I-9"},
+		{name: "whole document", raw: stream, expression: "xpath:doc(1)", want: "<?xml version=\"1.0\"?>\n<order><id>A-1</id></order>"},
+		{name: "iterate over documents", raw: stream, expression: "xpath://id", want: []string{"A-1", "A-2", "I-9"}},
+		{name: "count documents", raw: stream, expression: "xpath:count(/*)", want: float64(3)},
+		{name: "paths stay in the document", raw: stream, expression: "xpath:doc(2)//*", want: []string{"A-2rush", "A-2", "rush"}},
+		{name: "document out of range", raw: stream, expression: "xpath:doc(4)//id", wantErr: "document 4 not found: 3 document(s)"},
+		{name: "documents numbered from 1", raw: stream, expression: "xpath:doc(0)//id", wantErr: "numbered from 1"},
+		{name: "single document", raw: "<a><b>x</b></a>", expression: "xpath:doc(1)/a/b", want: "x"},
+		{name: "text between documents", raw: "<a/>junk<b/>", expression: "xpath:/*", wantErr: `text "junk" outside of a document element`},
+		{name: "truncated stream", raw: "<a/><b>", expression: "xpath:/*", wantErr: "unexpected end of input in document 2"},
+		{name: "invalid document", raw: "<a/><b>&undeclared;</b>", expression: "xpath:/*", wantErr: "document 2"},
+		{name: "with dtd options", raw: `<!DOCTYPE a [<!ENTITY v "one">]><a>&v;</a><!DOCTYPE b [<!ENTITY v "two">]><b>&v;</b>`, options: XMLOptions{DTD: DTDInternalSubset}, expression: "xpath:doc(2)/b", want: "two"},
+		{name: "disabled", raw: stream, options: XMLOptions{MultiDocument: false}, expression: "xpath:doc(2)//id", wantErr: "XPath compilation failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			if tt.name != "disabled" {
+				options.MultiDocument = true
+			}
+			engine := NewEngine()
+			engine.SetXMLOptions(options)
+			result, err := NewMessageContext([]byte(tt.raw), "application/xml", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutDocumentSelector(t *testing.T) {
+	tests := []struct {
+		expr      string
+		wantIndex int
+		wantRest  string
+		wantFound bool
+		wantErr   bool
+	}{
+		{expr: "doc(2)//id", wantIndex: 2, wantRest: "//id", wantFound: true},
+		{expr: "doc( 1 ) /a", wantIndex: 1, wantRest: "/a", wantFound: true},
+		{expr: "doc(3)", wantIndex: 3, wantFound: true},
+		{expr: "/a/doc", wantRest: "/a/doc"},
+		{expr: "doc(x)/a", wantFound: true, wantErr: true},
+		{expr: "doc(2", wantFound: true, wantErr: true},
+		{expr: "doc(2) or true()", wantFound: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			index, rest, found, err := cutDocumentSelector(tt.expr)
+			if (err != nil) != tt.wantErr || found != tt.wantFound {
+				t.Fatalf("got found %v, error %v, want found %v, error %v", found, err, tt.wantFound, tt.wantErr)
+			}
+			if !tt.wantErr && (index != tt.wantIndex || rest != tt.wantRest) {
+				t.Errorf("got %d, %q, want %d, %q", index, rest, tt.wantIndex, tt.wantRest)
+			}
+		})
+	}
+}
@@ -2,18 +2,21 @@ package parser
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"strings"
 	// Using antchfx/xpath as it's a common choice.
 	// xmlquery is based on antchfx/xpath and provides a slightly higher-level API.
 	// For direct XPath 1.0, antchfx/xpath is fine.
-	"github.com/antchfx/xpath"
 	"github.com/antchfx/xmlquery" // xmlquery uses antchfx/xpath underneath
+	"github.com/antchfx/xpath"
 )
 
 // XMLPayload handles XML data.
 type XMLPayload struct {
 	rawContent  []byte
-	parsedDoc   *xmlquery.Node // Using xmlquery's Node for easier navigation if needed
+	parsedDoc   *xmlquery.Node   // Using xmlquery's Node for easier navigation if needed
+	documents   []*xmlquery.Node // Each document of a multi-document payload, for doc(n)
 	contentType string
 }
 
@@ -41,16 +44,29 @@ func (xp *XMLPayload) GetContentType() string {
 
 // Query evaluates an XPath expression against the XML payload.
 func (xp *XMLPayload) Query(expression string) (QueryResult, error) {
+	return xp.QueryContext(context.Background(), expression)
+}
+
+// QueryContext is like Query but stops iterating over a node-set result once ctx is done.
+func (xp *XMLPayload) QueryContext(ctx context.Context, expression string) (QueryResult, error) {
 	if xp.parsedDoc == nil {
 		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "XML document not parsed"}
 	}
+	if xp.documents != nil && strings.HasPrefix(expression, "doc(") {
+		return xp.queryDocument(ctx, expression)
+	}
 
 	// Compile the XPath expression
-	exprCompiled, err := xpath.Compile(expression)
+	exprCompiled, release, err := compileXPathAt(ctx, expression, xmlquery.CreateXPathNavigator(xp.parsedDoc))
 	if err != nil {
 		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "XPath compilation failed", InnerError: err}
 	}
+	defer release()
+	return xp.evaluateXPath(ctx, exprCompiled, expression)
+}
 
+// evaluateXPath evaluates a compiled expression against the document.
+func (xp *XMLPayload) evaluateXPath(ctx context.Context, exprCompiled *xpath.Expr, expression string) (QueryResult, error) {
 	// Evaluate the expression
 	// The antchfx/xpath navigator works on an xmlquery.Node
 	nav := xmlquery.CreateXPathNavigator(xp.parsedDoc)
@@ -60,25 +76,51 @@ func (xp *XMLPayload) Query(expression string) (QueryResult, error) {
 	case string:
 		return QueryResult{Value: result, Type: StringResult}, nil
 	case float64:
-		return QueryResult{Value: result, Type:NumberResult}, nil
+		return QueryResult{Value: result, Type: NumberResult}, nil
 	case bool:
 		return QueryResult{Value: result, Type: BooleanResult}, nil
 	case *xpath.NodeIterator:
 		var results []string // For simplicity, collecting text content of nodes
 		var nodes []*xmlquery.Node
+		sink := finalStageOptions(ctx).sink // Nodes are sent to it instead of collected when streaming
+		maxElements := maxResultElements(ctx)
 		for result.MoveNext() {
-			node := result.Current().(*xmlquery.NodeNavigator).Current()
+			if err := ctx.Err(); err != nil {
+				return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: "XPath evaluation interrupted", InnerError: err}
+			}
+			nav := result.Current().(*xmlquery.NodeNavigator)
+			var node *xmlquery.Node
+			var text string
+			if nav.NodeType() == xpath.AttributeNode {
+				// Current is the owner element; the attribute is represented by its value
+				text = nav.Value()
+			} else {
+				node = nav.Current()
+				// For text(), it's often better to get it directly via XPath string() or text()
+				// If the XPath itself returns a string (e.g. /a/b/text()), it's handled above.
+				// If it returns nodes, we might want to return the nodes or their string representations.
+				// For this PoC, if it's a nodeset, we'll try to get the InnerText.
+				text = node.InnerText()
+			}
+			if sink != nil {
+				if !sink(QueryResult{Value: text, Type: StringResult, nodes: &xmlNodeSet{[]*xmlquery.Node{node}}}) {
+					break
+				}
+				continue
+			}
+			if err := checkResultElements(maxElements, len(nodes)+1, expression); err != nil {
+				return QueryResult{}, err
+			}
 			nodes = append(nodes, node)
-			// For text(), it's often better to get it directly via XPath string() or text()
-			// If the XPath itself returns a string (e.g. /a/b/text()), it's handled above.
-			// If it returns nodes, we might want to return the nodes or their string representations.
-			// For this PoC, if it's a nodeset, we'll try to get the InnerText.
-			results = append(results, node.InnerText())
+			results = append(results, text)
+		}
+		if sink != nil {
+			return QueryResult{Value: nil, Type: NodeSetResult, streamed: true}, nil
 		}
 		if len(nodes) == 0 {
-			 // XPath selected nothing, which is not an error but an empty result.
-			 // Depending on strictness, could return nil or empty string/slice.
-			 return QueryResult{Value: nil, Type: NodeSetResult}, nil // Or specific type like StringResult with ""
+			// XPath selected nothing, which is not an error but an empty result.
+			// Depending on strictness, could return nil or empty string/slice.
+			return QueryResult{Value: nil, Type: NodeSetResult}, nil // Or specific type like StringResult with ""
 		}
 		// If the original XPath was like "/a/b/text()", it would directly be a string.
 		// If it was "/a/b", it's a nodeset.
@@ -88,9 +130,9 @@ func (xp *XMLPayload) Query(expression string) (QueryResult, error) {
 			// If the XPath was specific and returned one node, give its text.
 			// If the XPath was like "/a/b[1]/text()", it would be string.
 			// If it was "/a/b[1]", this is reasonable.
-			return QueryResult{Value: results[0], Type: StringResult}, nil
+			return QueryResult{Value: results[0], Type: StringResult, nodes: &xmlNodeSet{nodes}}, nil
 		}
-		return QueryResult{Value: results, Type: NodeSetResult}, nil
+		return QueryResult{Value: results, Type: NodeSetResult, nodes: &xmlNodeSet{nodes}}, nil
 	default:
 		// This case might occur if XPath evaluates to something unexpected by this simplified switch
 		return QueryResult{}, &ErrEvaluationFailed{Expression: expression, Reason: fmt.Sprintf("unexpected XPath result type: %T", val)}
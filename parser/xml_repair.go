@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"github.com/antchfx/xmlquery"
+	"golang.org/x/net/html/charset"
+)
+
+// parseTagSoup parses content that is not well-formed XML the way HTML is
+// usually written: end tags that do not match close the open elements, void
+// elements such as <br> and elements still open at the end are closed, bare
+// ampersands are kept as text, and HTML entities such as &nbsp; and
+// unquoted attribute values made of name characters are accepted. entities
+// adds to the HTML entities. Element names keep their case, so XPath
+// expressions written for the XML still match.
+func parseTagSoup(content []byte, entities map[string]string) (*xmlquery.Node, error) {
+	entity := make(map[string]string, len(xml.HTMLEntity)+len(entities))
+	for name, value := range xml.HTMLEntity {
+		entity[name] = value
+	}
+	for name, value := range entities {
+		entity[name] = value
+	}
+	options := &xmlquery.DecoderOptions{Strict: false, AutoClose: xml.HTMLAutoClose, Entity: entity, CharsetReader: charset.NewReaderLabel}
+	return xmlquery.ParseWithOptions(bytes.NewReader(closeOpenElements(content, options)), xmlquery.ParserOptions{Decoder: options})
+}
+
+// closeOpenElements appends end tags for the elements left open at the end
+// of content, which the lenient decoder still rejects. Other errors are left
+// for the parser to report.
+func closeOpenElements(content []byte, options *xmlquery.DecoderOptions) []byte {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.Strict = options.Strict
+	decoder.AutoClose = options.AutoClose
+	decoder.Entity = options.Entity
+	decoder.CharsetReader = options.CharsetReader
+
+	var open []xml.Name
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return content
+		}
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) && syntaxErr.Msg == "unexpected EOF" && len(open) > 0 {
+			break
+		}
+		if err != nil {
+			return content
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			open = append(open, t.Name)
+		case xml.EndElement:
+			open = open[:len(open)-1]
+		}
+	}
+
+	closed := bytes.NewBuffer(append([]byte(nil), content...))
+	for i := len(open) - 1; i >= 0; i-- {
+		closed.WriteString("</" + open[i].Local + ">")
+	}
+	return closed.Bytes()
+}
@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractAsXMLRepair(t *testing.T) {
+	tests := []struct {
+		name       string
+		fragment   string
+		repair     bool
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "well-formed", fragment: "<Order><ID>7</ID></Order>", expression: "xpath:/Order/ID", want: "7"},
+		{name: "unescaped ampersand", fragment: "<Order><Name>Smith & Sons</Name></Order>", repair: true, expression: "xpath:/Order/Name", want: "Smith & Sons"},
+		{name: "void element", fragment: "<p>one<br>two</p>", repair: true, expression: "xpath:count(/p/br)", want: float64(1)},
+		{name: "mismatched end tag", fragment: "<div><b>bold</div>", repair: true, expression: "xpath:/div/b", want: "bold"},
+		{name: "unclosed at end", fragment: "<ul><li>a</li><li>b", repair: true, expression: "xpath:/ul/li", want: []string{"a", "b"}},
+		{name: "unquoted attribute", fragment: "<a id=order-7>order</a>", repair: true, expression: "xpath:string(/a/@id)", want: "order-7"},
+		{name: "html entities", fragment: "<p>caf&eacute;&nbsp;&copy;</p>", repair: true, expression: "xpath:/p", want: "café ©"},
+		{name: "case preserved", fragment: "<Item><SKU>X-1<br></SKU></Item>", repair: true, expression: "xpath:/Item/SKU/text()", want: "X-1"},
+		{name: "not repaired by default", fragment: "<Order><Name>Smith & Sons</Name></Order>", expression: "xpath:/Order/Name", wantErr: "XML parsing failed"},
+		{name: "not markup", fragment: "plain text", repair: true, expression: "xpath:/a", wantErr: "XML parsing failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(map[string]string{"html": tt.fragment})
+			if err != nil {
+				t.Fatal(err)
+			}
+			engine := NewEngine()
+			engine.SetXMLOptions(XMLOptions{Repair: tt.repair})
+			result, err := NewMessageContext(body, "application/json", engine).EvaluateExpression("jsonpath:html | extractAsXML | " + tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepairKeepsDTDPolicy(t *testing.T) {
+	engine := NewEngine()
+	engine.SetXMLOptions(XMLOptions{DTD: DTDForbid, Repair: true})
+	_, err := NewMessageContext([]byte("<!DOCTYPE p><p>a & b"), "application/xml", engine).EvaluateExpression("xpath:/p")
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("got %v, want the DOCTYPE to be rejected", err)
+	}
+}
@@ -0,0 +1,392 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xpath"
+)
+
+// xpathFunctionPrefix is the prefix of registered functions in XPath
+// expressions, e.g. "pp:uuid()".
+const xpathFunctionPrefix = "pp:"
+
+// XPathFunc computes the value of a function registered with
+// RegisterXPathFunction. args are the values of its arguments: a string,
+// float64 or bool, or a []string of the string values of a node-set. It
+// returns a string, a number (float64 or any Go integer type) or a bool.
+type XPathFunc func(args []interface{}) (interface{}, error)
+
+// xpathFunctionsKey is the context key of the engine whose registered XPath
+// functions an evaluation can call.
+type xpathFunctionsKey struct{}
+
+// RegisterXPathFunction adds a function that XPath expressions evaluated by
+// this engine against XML and HTML payloads can call with the "pp:" prefix, e.g.
+// "xpath:concat(/order/id, '-', pp:uuid())" after
+// RegisterXPathFunction("uuid", fn). Names are XML names without a colon.
+// Registering a name again replaces its function; passing a nil fn removes
+// it. Engines that have this engine as their fallback can call its functions
+// too.
+//
+// The XPath library has no extension functions, so each call is evaluated
+// once per query, before the expression is compiled: its arguments are
+// evaluated against the document root and the call is replaced with a
+// literal of its value. A call inside a predicate therefore cannot take
+// arguments relative to the node the predicate is testing, such as
+// "//item[pp:f(price) > 1]"; such queries fail to compile.
+func (ee *ExpressionEngine) RegisterXPathFunction(name string, fn XPathFunc) error {
+	if !isNCName(name) {
+		return fmt.Errorf("invalid XPath function name '%s'", name)
+	}
+	if fn == nil {
+		ee.xpathFunctions.Delete(name)
+		return nil
+	}
+	ee.xpathFunctions.Store(name, fn)
+	return nil
+}
+
+// xpathFunction returns the function registered as name on this engine or on
+// its fallback chain.
+func (ee *ExpressionEngine) xpathFunction(name string) (XPathFunc, bool) {
+	for e := ee; e != nil; e = e.fallback.Load() {
+		if fn, ok := e.xpathFunctions.Load(name); ok {
+			return fn.(XPathFunc), true
+		}
+	}
+	return nil, false
+}
+
+// withXPathFunctions makes XPath queries under ctx call the functions
+// registered on ee.
+func withXPathFunctions(ctx context.Context, ee *ExpressionEngine) context.Context {
+	return context.WithValue(ctx, xpathFunctionsKey{}, ee)
+}
+
+// expandXPathFunctions replaces the calls of registered functions in
+// expression with literals of their values, evaluating their arguments
+// against root. It reports whether any call was replaced.
+func expandXPathFunctions(ctx context.Context, expression string, root xpath.NodeNavigator) (string, bool, error) {
+	ee, _ := ctx.Value(xpathFunctionsKey{}).(*ExpressionEngine)
+	if ee == nil {
+		return expression, false, nil
+	}
+	return replaceXPathFunctionCalls(expression, func(name string, args []string) (string, bool, error) {
+		fn, ok := ee.xpathFunction(name)
+		if !ok {
+			return "", false, nil
+		}
+		literal, err := callXPathFunction(ctx, name, fn, args, root)
+		return literal, true, err
+	})
+}
+
+// replaceXPathFunctionCalls replaces the "pp:" function calls in expression,
+// outside string literals, with what replace returns for them, unless it
+// returns false. It reports whether any call was replaced, and fails for a
+// call inside a predicate whose arguments depend on the context node.
+func replaceXPathFunctionCalls(expression string, replace func(name string, args []string) (string, bool, error)) (string, bool, error) {
+	if !strings.Contains(expression, xpathFunctionPrefix) {
+		return expression, false, nil
+	}
+	var b strings.Builder
+	replaced := false
+	var quote byte
+	predicates := 0
+	for i := 0; i < len(expression); i++ {
+		c := expression[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			predicates++
+		case c == ']':
+			predicates--
+		case strings.HasPrefix(expression[i:], xpathFunctionPrefix) && (i == 0 || !isXPathNameByte(expression[i-1]) && expression[i-1] != ':'):
+			name, args, end, ok := xpathFunctionCall(expression, i+len(xpathFunctionPrefix))
+			if !ok {
+				break
+			}
+			if predicates > 0 {
+				for n, arg := range args {
+					if isContextRelativeXPath(arg) {
+						return "", false, fmt.Errorf("argument %d of %s%s in a predicate depends on the context node, but registered functions are evaluated once, against the document root", n+1, xpathFunctionPrefix, name)
+					}
+				}
+			}
+			replacement, ok, err := replace(name, args)
+			if err != nil {
+				return "", false, err
+			}
+			if !ok {
+				break
+			}
+			b.WriteString(replacement)
+			replaced = true
+			i = end - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), replaced, nil
+}
+
+// compileXPathAt compiles an expression evaluated against root, calling the
+// registered functions it uses first. Expressions with such calls are not
+// cached, as the values of the calls may differ between evaluations.
+// Variables are rejected: the XPath library cannot bind them and fails when
+// evaluating a reference to one.
+func compileXPathAt(ctx context.Context, expression string, root xpath.NodeNavigator) (*xpath.Expr, func(), error) {
+	if name, ok := xpathVariableReference(expression); ok {
+		return nil, nil, fmt.Errorf("variable $%s is not bound", name)
+	}
+	expanded, ok, err := expandXPathFunctions(ctx, expression, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return compileXPath(ctx, expression)
+	}
+	ns, _ := ctx.Value(xpathNamespacesKey{}).(*xpathNamespaces)
+	expr, err := compileXPathNS(expanded, ns)
+	return expr, func() {}, err
+}
+
+// xpathVariableReference returns the name of the first variable referenced
+// in expression outside string literals.
+func xpathVariableReference(expression string) (string, bool) {
+	var quote byte
+	for i := 0; i < len(expression); i++ {
+		switch c := expression[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '$':
+			end := i + 1
+			for end < len(expression) && (isXPathNameByte(expression[end]) || expression[end] == ':') {
+				end++
+			}
+			return expression[i+1 : end], true
+		}
+	}
+	return "", false
+}
+
+// xpathFunctionCall parses the call whose name starts at start in
+// expression, returning the name, the argument expressions and the end of
+// the call.
+func xpathFunctionCall(expression string, start int) (name string, args []string, end int, ok bool) {
+	i := start
+	for i < len(expression) && isXPathNameByte(expression[i]) {
+		i++
+	}
+	name = expression[start:i]
+	for i < len(expression) && expression[i] == ' ' {
+		i++
+	}
+	if !isNCName(name) || i == len(expression) || expression[i] != '(' {
+		return "", nil, 0, false
+	}
+	open := i
+	depth := 0
+	var quote byte
+	for ; i < len(expression); i++ {
+		switch c := expression[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			if depth--; depth == 0 {
+				if inner := expression[open+1 : i]; strings.TrimSpace(inner) != "" {
+					args = splitTopLevel(inner, ',')
+				}
+				return name, args, i + 1, true
+			}
+		}
+	}
+	return "", nil, 0, false
+}
+
+// callXPathFunction evaluates the arguments of a call of fn against root,
+// calls it and returns its value as an XPath literal.
+func callXPathFunction(ctx context.Context, name string, fn XPathFunc, args []string, root xpath.NodeNavigator) (string, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		expr, release, err := compileXPathAt(ctx, strings.TrimSpace(arg), root)
+		if err != nil {
+			return "", fmt.Errorf("argument %d of %s%s: %w", i+1, xpathFunctionPrefix, name, err)
+		}
+		values[i] = xpathArgument(expr.Evaluate(root.Copy()))
+		release()
+	}
+	value, err := fn(values)
+	if err != nil {
+		return "", fmt.Errorf("%s%s: %w", xpathFunctionPrefix, name, err)
+	}
+	literal, err := xpathValueLiteral(value)
+	if err != nil {
+		return "", fmt.Errorf("%s%s: %w", xpathFunctionPrefix, name, err)
+	}
+	return literal, nil
+}
+
+// xpathArgument converts a value of an XPath expression to an argument of
+// an XPathFunc.
+func xpathArgument(value interface{}) interface{} {
+	iter, ok := value.(*xpath.NodeIterator)
+	if !ok {
+		return value
+	}
+	values := []string{}
+	for iter.MoveNext() {
+		values = append(values, iter.Current().Value())
+	}
+	return values
+}
+
+// xpathValueLiteral renders a value returned by an XPathFunc as an XPath
+// expression.
+func xpathValueLiteral(value interface{}) (string, error) {
+	var number float64
+	switch v := value.(type) {
+	case string:
+		return xpathLiteral(v), nil
+	case bool:
+		if v {
+			return "true()", nil
+		}
+		return "false()", nil
+	case float64:
+		number = v
+	case float32:
+		number = float64(v)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		number, _ = strconv.ParseFloat(fmt.Sprint(v), 64)
+	default:
+		return "", fmt.Errorf("unsupported result type %T", value)
+	}
+	switch {
+	case math.IsNaN(number):
+		return "number('NaN')", nil
+	case math.IsInf(number, 1):
+		return "(1 div 0)", nil
+	case math.IsInf(number, -1):
+		return "(-1 div 0)", nil
+	}
+	return "(" + strconv.FormatFloat(number, 'f', -1, 64) + ")", nil
+}
+
+// isContextRelativeXPath reports whether expression, outside its own
+// predicates, has a location path that does not start at the root or a
+// variable, e.g. "price", "." or "count(@id)".
+func isContextRelativeXPath(expression string) bool {
+	predicates := 0
+	afterStep := false // The previous token is "/" or "//"
+	operand := false   // The previous token ends an operand
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expression[i+1:], c)
+			if end < 0 {
+				return false
+			}
+			i += end + 2
+			afterStep, operand = false, true
+			continue
+		case c == '[':
+			predicates++
+		case c == ']':
+			predicates--
+		}
+		if predicates > 0 || c == ']' {
+			i++
+			continue
+		}
+		switch {
+		case c == '/':
+			i++
+			afterStep, operand = true, false
+			continue
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(expression) && expression[i+1] >= '0' && expression[i+1] <= '9':
+			for i < len(expression) && (expression[i] >= '0' && expression[i] <= '9' || expression[i] == '.') {
+				i++
+			}
+			afterStep, operand = false, true
+			continue
+		case c == '$':
+			i++
+			for i < len(expression) && (isXPathNameByte(expression[i]) || expression[i] == ':') {
+				i++
+			}
+			afterStep, operand = false, true
+			continue
+		case c == '.' || c == '@' || c == '*' && !operand:
+			if !afterStep {
+				return true
+			}
+			i++
+			if c == '@' {
+				continue // The name of the attribute follows
+			}
+			if c == '.' && i < len(expression) && expression[i] == '.' {
+				i++
+			}
+			afterStep, operand = false, true
+			continue
+		case isXPathNameByte(c) && c != '-':
+			start := i
+			for i < len(expression) && (isXPathNameByte(expression[i]) || expression[i] == ':' && !strings.HasPrefix(expression[i:], "::")) {
+				i++
+			}
+			name := expression[start:i]
+			if strings.HasPrefix(expression[i:], "::") {
+				if !afterStep {
+					return true
+				}
+				i += 2
+				continue // The node test of the axis follows
+			}
+			if operand && (name == "and" || name == "or" || name == "div" || name == "mod") {
+				afterStep, operand = false, false
+				continue
+			}
+			next := strings.TrimLeft(expression[i:], " \t\n\r")
+			isFunction := strings.HasPrefix(next, "(") && name != "node" && name != "text" && name != "comment" && name != "processing-instruction"
+			if !isFunction && !afterStep {
+				return true
+			}
+			afterStep, operand = false, !isFunction
+			continue
+		}
+		// An operator, a parenthesis or a comma
+		i++
+		afterStep, operand = false, c == ')'
+	}
+	return false
+}
+
+// isXPathNameByte reports whether c can be part of a name without a colon
+// in an XPath expression.
+func isXPathNameByte(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c > 0x7f
+}
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterXPathFunction(t *testing.T) {
+	body := []byte(`<order><id>42</id><item>a</item><item>b</item></order>`)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+		wantErr    string
+	}{
+		{name: "no arguments", expression: "xpath:concat(/order/id, '-', pp:uuid())", want: "42-id-1"},
+		{name: "node-set argument", expression: "xpath:pp:join(/order/item, '+')", want: "a+b"},
+		{name: "number result", expression: "xpath:pp:add(/order/id, 1) * 2", want: 86.0},
+		{name: "boolean result", expression: "xpath:pp:isEven(/order/id)", want: true},
+		{name: "nested calls", expression: "xpath:pp:join(pp:quote(), '')", want: `it's "quoted"`},
+		{name: "in a predicate", expression: "xpath:/order/item[. = pp:join(/order/item[2], '')]", want: "b"},
+		{name: "relative argument in a predicate", expression: "xpath://item[pp:isEven(.)]", wantErr: "argument 1 of pp:isEven in a predicate depends on the context node"},
+		{name: "nested relative argument in a predicate", expression: "xpath:/order[pp:add(count(item), 1) > 2]/id", wantErr: "argument 1 of pp:add in a predicate depends on the context node"},
+		{name: "relative argument at the root", expression: "xpath:pp:join(order/item, '+')", want: "a+b"},
+		{name: "in a string literal", expression: "xpath:concat('pp:uuid()', '')", want: "pp:uuid()"},
+		{name: "function error", expression: "xpath:pp:fail()", wantErr: "pp:fail: out of order"},
+		{name: "unsupported result", expression: "xpath:pp:slice()", wantErr: "pp:slice: unsupported result type []int"},
+		{name: "unregistered", expression: "xpath:pp:missing()", wantErr: "XPath compilation failed"},
+	}
+
+	engine := NewEngine()
+	calls := 0
+	functions := map[string]XPathFunc{
+		"uuid": func(args []interface{}) (interface{}, error) {
+			calls++
+			return fmt.Sprintf("id-%d", calls), nil
+		},
+		"join": func(args []interface{}) (interface{}, error) {
+			var values []string
+			switch v := args[0].(type) {
+			case []string:
+				values = v
+			case string:
+				values = []string{v}
+			}
+			return strings.Join(values, args[1].(string)), nil
+		},
+		"add": func(args []interface{}) (interface{}, error) {
+			var n float64
+			fmt.Sscan(args[0].([]string)[0], &n)
+			return n + args[1].(float64), nil
+		},
+		"isEven": func(args []interface{}) (interface{}, error) {
+			return args[0].([]string)[0] == "42", nil
+		},
+		"quote": func(args []interface{}) (interface{}, error) { return `it's "quoted"`, nil },
+		"fail":  func(args []interface{}) (interface{}, error) { return nil, errors.New("out of order") },
+		"slice": func(args []interface{}) (interface{}, error) { return []int{1}, nil },
+	}
+	for name, fn := range functions {
+		if err := engine.RegisterXPathFunction(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext(body, "application/xml", engine).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterXPathFunctionRemovalAndFallback(t *testing.T) {
+	base := NewEngine()
+	engine := NewEngine()
+	if err := engine.SetFallback(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.RegisterXPathFunction("answer", func([]interface{}) (interface{}, error) { return 42, nil }); err != nil {
+		t.Fatal(err)
+	}
+	msgCtx := NewMessageContext([]byte(`<a/>`), "application/xml", engine)
+	result, err := msgCtx.EvaluateExpression("xpath:pp:answer() + 1")
+	if err != nil || result.Value != 43.0 {
+		t.Fatalf("got %v, %v, want 43 from the fallback engine's function", result.Value, err)
+	}
+	if warnings := engine.Lint("xpath:pp:answer()"); len(warnings) != 0 {
+		t.Errorf("Lint reported %v for a registered function", warnings)
+	}
+
+	if err := base.RegisterXPathFunction("answer", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msgCtx.EvaluateExpression("xpath:pp:answer()"); err == nil {
+		t.Error("expected an error after the function was removed")
+	}
+	if err := engine.RegisterXPathFunction("pp:answer", nil); err == nil {
+		t.Error("expected an error for a name with a colon")
+	}
+}
+
+func TestUnboundXPathVariable(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		expression  string
+		want        interface{}
+		wantErr     string
+	}{
+		{name: "XML", content: `<order><id>42</id></order>`, contentType: "application/xml", expression: "xpath:$body//id", wantErr: "variable $body is not bound"},
+		{name: "XML in a predicate", content: `<order><id>42</id></order>`, contentType: "application/xml", expression: "xpath://id[. = $id]", wantErr: "variable $id is not bound"},
+		{name: "HTML", content: `<p id="42">x</p>`, contentType: "text/html", expression: "xpath:$body//p/@id", wantErr: "variable $body is not bound"},
+		{name: "in a string literal", content: `<order><id>42</id></order>`, contentType: "application/xml", expression: "xpath:concat('$', /order/id)", want: "$42"},
+		{name: "SOAP body", content: `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><id>42</id></soapenv:Body></soapenv:Envelope>`, contentType: "text/xml", expression: "xpath:$body//id", want: "42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewMessageContext([]byte(tt.content), tt.contentType, NewEngine()).EvaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				var evalErr *ErrEvaluationFailed
+				if !errors.As(err, &evalErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want ErrEvaluationFailed containing %q", result.Value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Value != tt.want {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,1272 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+)
+
+// xsltNamespace is the namespace of XSLT elements.
+const xsltNamespace = "http://www.w3.org/1999/XSL/Transform"
+
+// maxTemplateDepth bounds nested template instantiations, so that a
+// stylesheet that applies templates to the same node again and again fails
+// instead of exhausting the stack.
+const maxTemplateDepth = 1000
+
+// RegisterStylesheet compiles an XSLT 1.0 stylesheet and stores it under name
+// for the xslt pipe, e.g. "xpath:/invoice | xslt(\"invoiceToSummary\")".
+// Registering a name again replaces its stylesheet. Engines that have this
+// engine as their fallback can use its stylesheets too.
+//
+// The stylesheet may use template rules with modes and priorities, named
+// templates, xsl:apply-templates, xsl:call-template, xsl:for-each, xsl:sort,
+// xsl:value-of, xsl:copy-of, xsl:copy, xsl:if, xsl:choose, xsl:text,
+// xsl:element, xsl:attribute, xsl:comment, literal result elements with
+// attribute value templates, and the top-level xsl:output (methods "xml" and
+// "text") and xsl:strip-space elements. Variables, parameters, keys, imports
+// and the other XSLT instructions are rejected. XPath expressions are
+// evaluated as by the xpath: prefix; position() and last() refer to the
+// expression itself, not to the nodes being processed.
+func (ee *ExpressionEngine) RegisterStylesheet(name string, source []byte) error {
+	if name == "" {
+		return fmt.Errorf("stylesheet name is empty")
+	}
+	sheet, err := compileStylesheet(source)
+	if err != nil {
+		return fmt.Errorf("stylesheet '%s': %w", name, err)
+	}
+	// Compiled XPath expressions keep state while they are evaluated, so each
+	// compiled copy of the stylesheet is used by one transformation at a time
+	source = bytes.Clone(source)
+	sheets := &sync.Pool{New: func() interface{} {
+		sheet, _ := compileStylesheet(source) // Compiled successfully above
+		return sheet
+	}}
+	sheets.Put(sheet)
+	ee.stylesheets.Store(name, sheets)
+	return nil
+}
+
+// stylesheet returns the compiled copies of the stylesheet registered as name
+// on this engine or on its fallback chain.
+func (ee *ExpressionEngine) stylesheet(name string) (*sync.Pool, bool) {
+	for e := ee; e != nil; e = e.fallback.Load() {
+		if sheets, ok := e.stylesheets.Load(name); ok {
+			return sheets.(*sync.Pool), true
+		}
+	}
+	return nil, false
+}
+
+// xsltTransform applies the stylesheet named by the first argument of the
+// xslt pipe call to the XML document doc, and returns the output with its
+// content type.
+func (ee *ExpressionEngine) xsltTransform(ctx context.Context, call pipeCall, doc *xmlquery.Node) (string, string, error) {
+	name, err := stringArg(call, 0)
+	if err != nil {
+		return "", "", err
+	}
+	sheets, ok := ee.stylesheet(name)
+	if !ok {
+		return "", "", fmt.Errorf("no stylesheet is registered as '%s'", name)
+	}
+	sheet := sheets.Get().(*stylesheet)
+	defer sheets.Put(sheet)
+	output, err := sheet.transform(ctx, doc)
+	if err != nil {
+		return "", "", err
+	}
+	return output, sheet.contentType(), nil
+}
+
+// xsltSource returns the document the xslt pipe transforms: a copy of the
+// element or document the previous result selected, or the previous result
+// parsed as XML. The copy keeps the selected nodes intact when the
+// stylesheet strips whitespace.
+func (ee *ExpressionEngine) xsltSource(result QueryResult, text string) (*xmlquery.Node, error) {
+	if selected, ok := result.nodes.(*xmlNodeSet); ok && len(selected.nodes) == 1 && selected.nodes[0] != nil {
+		switch node := selected.nodes[0]; node.Type {
+		case xmlquery.DocumentNode:
+			return deepCopy(node), nil
+		case xmlquery.ElementNode:
+			doc := &xmlquery.Node{Type: xmlquery.DocumentNode}
+			xmlquery.AddChild(doc, deepCopy(node))
+			return doc, nil
+		}
+	}
+	xmlPayload, err := ee.payloadFactory.createXMLPayload([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	return xmlPayload.parsedDoc, nil
+}
+
+// stylesheet is a compiled XSLT stylesheet.
+type stylesheet struct {
+	rules      []xsltRule                   // Template rules in document order
+	named      map[string][]xsltInstruction // Bodies of named templates
+	stripSpace []string                     // Elements (or "*") whose whitespace-only text children are removed from the input
+	method     string                       // Output method: "xml" or "text"
+	mediaType  string
+	indent     bool
+	omitDecl   bool        // Omit the XML declaration
+	childNodes *xpath.Expr // "node()", the nodes processed when no rule matches
+}
+
+// xsltRule is one alternative of the match pattern of a template.
+type xsltRule struct {
+	pattern  *xpath.Expr
+	absolute bool // The pattern selects the same nodes from every context
+	test     xsltNodeTest
+	priority float64
+	mode     string
+	body     []xsltInstruction
+}
+
+// contentType returns the content type of the stylesheet's output.
+func (s *stylesheet) contentType() string {
+	switch {
+	case s.mediaType != "":
+		return s.mediaType
+	case s.method == "text":
+		return "text/plain"
+	}
+	return "application/xml"
+}
+
+// compileStylesheet parses and compiles an XSLT stylesheet.
+func compileStylesheet(source []byte) (*stylesheet, error) {
+	doc, err := xmlquery.Parse(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	root := firstElement(doc)
+	if root == nil || root.NamespaceURI != xsltNamespace || (root.Data != "stylesheet" && root.Data != "transform") {
+		return nil, fmt.Errorf("the document element is not xsl:stylesheet or xsl:transform")
+	}
+	sheet := &stylesheet{named: map[string][]xsltInstruction{}, method: "xml", childNodes: xpath.MustCompile("node()")}
+	var calls []string // Names of called templates, checked once all are known
+	for n := root.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type != xmlquery.ElementNode || n.NamespaceURI != xsltNamespace {
+			continue // Top-level elements of other namespaces are ignored
+		}
+		switch n.Data {
+		case "output":
+			if err := sheet.compileOutput(n); err != nil {
+				return nil, err
+			}
+		case "strip-space":
+			sheet.stripSpace = append(sheet.stripSpace, strings.Fields(xmlAttrValue(n, "elements"))...)
+		case "template":
+			if err := sheet.compileTemplate(n, &calls); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported top-level element 'xsl:%s'", n.Data)
+		}
+	}
+	for _, name := range calls {
+		if _, ok := sheet.named[name]; !ok {
+			return nil, fmt.Errorf("xsl:call-template names an unknown template '%s'", name)
+		}
+	}
+	return sheet, nil
+}
+
+// compileOutput reads the settings of an xsl:output element.
+func (s *stylesheet) compileOutput(n *xmlquery.Node) error {
+	if method, ok := xmlAttr(n, "method"); ok {
+		if method != "xml" && method != "text" {
+			return fmt.Errorf("unsupported output method '%s'", method)
+		}
+		s.method = method
+	}
+	s.mediaType = xmlAttrValue(n, "media-type")
+	s.indent = xmlAttrValue(n, "indent") == "yes"
+	s.omitDecl = xmlAttrValue(n, "omit-xml-declaration") == "yes"
+	return nil
+}
+
+// compileTemplate adds the rules and the name of an xsl:template element.
+func (s *stylesheet) compileTemplate(n *xmlquery.Node, calls *[]string) error {
+	match, hasMatch := xmlAttr(n, "match")
+	name, hasName := xmlAttr(n, "name")
+	if !hasMatch && !hasName {
+		return fmt.Errorf("xsl:template needs a match or a name attribute")
+	}
+	body, err := compileBody(n, calls)
+	if err != nil {
+		return err
+	}
+	if hasName {
+		s.named[name] = body
+	}
+	if !hasMatch {
+		return nil
+	}
+	explicitPriority, hasPriority := xmlAttr(n, "priority")
+	priority, err := strconv.ParseFloat(explicitPriority, 64)
+	if hasPriority && err != nil {
+		return fmt.Errorf("invalid template priority '%s'", explicitPriority)
+	}
+	namespaces := inScopeNamespaces(n)
+	for _, alternative := range splitTopLevel(match, '|') {
+		alternative = strings.TrimSpace(alternative)
+		pattern, err := xpath.CompileWithNS(alternative, namespaces)
+		if err != nil {
+			return fmt.Errorf("invalid match pattern '%s': %w", match, err)
+		}
+		rule := xsltRule{
+			pattern:  pattern,
+			absolute: strings.HasPrefix(alternative, "/"),
+			test:     patternNodeTest(alternative),
+			priority: priority,
+			mode:     xmlAttrValue(n, "mode"),
+			body:     body,
+		}
+		if !hasPriority {
+			rule.priority = defaultPriority(alternative)
+		}
+		s.rules = append(s.rules, rule)
+	}
+	return nil
+}
+
+// compileBody compiles the children of a template or instruction.
+func compileBody(parent *xmlquery.Node, calls *[]string) ([]xsltInstruction, error) {
+	var body []xsltInstruction
+	for n := parent.FirstChild; n != nil; n = n.NextSibling {
+		switch n.Type {
+		case xmlquery.TextNode, xmlquery.CharDataNode:
+			if strings.TrimSpace(n.Data) != "" {
+				body = append(body, xsltText(n.Data))
+			}
+		case xmlquery.ElementNode:
+			instruction, err := compileInstruction(n, calls)
+			if err != nil {
+				return nil, err
+			}
+			if instruction != nil {
+				body = append(body, instruction)
+			}
+		}
+	}
+	return body, nil
+}
+
+// compileInstruction compiles an XSLT instruction or a literal result
+// element. xsl:sort elements are compiled by their parent and return nil.
+func compileInstruction(n *xmlquery.Node, calls *[]string) (xsltInstruction, error) {
+	namespaces := inScopeNamespaces(n)
+	if n.NamespaceURI != xsltNamespace {
+		return compileLiteralElement(n, namespaces, calls)
+	}
+	switch n.Data {
+	case "value-of":
+		selectExpr, err := requiredExpr(n, "select", namespaces)
+		return &xsltValueOf{selectExpr}, err
+	case "copy-of":
+		selectExpr, err := requiredExpr(n, "select", namespaces)
+		return &xsltCopyOf{selectExpr}, err
+	case "text":
+		return xsltText(n.InnerText()), nil
+	case "sort":
+		return nil, nil
+	case "apply-templates":
+		apply := &xsltApplyTemplates{selectExpr: xpath.MustCompile("node()"), mode: xmlAttrValue(n, "mode")}
+		if _, ok := xmlAttr(n, "select"); ok {
+			var err error
+			if apply.selectExpr, err = requiredExpr(n, "select", namespaces); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		apply.sorts, err = compileSorts(n)
+		return apply, err
+	case "for-each":
+		forEach := &xsltForEach{}
+		var err error
+		if forEach.selectExpr, err = requiredExpr(n, "select", namespaces); err != nil {
+			return nil, err
+		}
+		if forEach.sorts, err = compileSorts(n); err != nil {
+			return nil, err
+		}
+		forEach.body, err = compileBody(n, calls)
+		return forEach, err
+	case "call-template":
+		name, ok := xmlAttr(n, "name")
+		if !ok {
+			return nil, fmt.Errorf("xsl:call-template needs a name attribute")
+		}
+		if param := firstElement(n); param != nil {
+			return nil, fmt.Errorf("unsupported instruction 'xsl:%s'", param.Data)
+		}
+		*calls = append(*calls, name)
+		return xsltCallTemplate(name), nil
+	case "if":
+		test, err := requiredExpr(n, "test", namespaces)
+		if err != nil {
+			return nil, err
+		}
+		body, err := compileBody(n, calls)
+		return &xsltChoose{{test: test, body: body}}, err
+	case "choose":
+		var choose xsltChoose
+		for branch := n.FirstChild; branch != nil; branch = branch.NextSibling {
+			if branch.Type != xmlquery.ElementNode {
+				continue
+			}
+			var test *xpath.Expr
+			switch {
+			case branch.NamespaceURI == xsltNamespace && branch.Data == "when":
+				var err error
+				if test, err = requiredExpr(branch, "test", namespaces); err != nil {
+					return nil, err
+				}
+			case branch.NamespaceURI == xsltNamespace && branch.Data == "otherwise":
+			default:
+				return nil, fmt.Errorf("xsl:choose may only contain xsl:when and xsl:otherwise")
+			}
+			body, err := compileBody(branch, calls)
+			if err != nil {
+				return nil, err
+			}
+			choose = append(choose, xsltBranch{test: test, body: body})
+		}
+		return &choose, nil
+	case "copy":
+		body, err := compileBody(n, calls)
+		return &xsltCopy{body}, err
+	case "element", "attribute":
+		constructor := &xsltConstructor{attribute: n.Data == "attribute", namespaces: namespaces}
+		name, ok := xmlAttr(n, "name")
+		if !ok {
+			return nil, fmt.Errorf("xsl:%s needs a name attribute", n.Data)
+		}
+		var err error
+		if constructor.name, err = compileAVT(name, namespaces); err != nil {
+			return nil, err
+		}
+		if namespace, ok := xmlAttr(n, "namespace"); ok {
+			if constructor.namespace, err = compileAVT(namespace, namespaces); err != nil {
+				return nil, err
+			}
+		}
+		constructor.body, err = compileBody(n, calls)
+		return constructor, err
+	case "comment":
+		body, err := compileBody(n, calls)
+		return &xsltComment{body}, err
+	}
+	return nil, fmt.Errorf("unsupported instruction 'xsl:%s'", n.Data)
+}
+
+// compileLiteralElement compiles an element that is copied to the output.
+func compileLiteralElement(n *xmlquery.Node, namespaces map[string]string, calls *[]string) (xsltInstruction, error) {
+	literal := &xsltLiteralElement{local: n.Data, prefix: n.Prefix, namespaceURI: n.NamespaceURI}
+	for _, a := range n.Attr {
+		switch {
+		case a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns"):
+			if a.Value != xsltNamespace {
+				literal.declarations = append(literal.declarations, a)
+			}
+		case a.NamespaceURI == xsltNamespace:
+			// XSLT attributes such as xsl:exclude-result-prefixes are not copied
+		default:
+			value, err := compileAVT(a.Value, namespaces)
+			if err != nil {
+				return nil, err
+			}
+			literal.attributes = append(literal.attributes, xsltLiteralAttribute{name: a.Name, value: value})
+		}
+	}
+	var err error
+	literal.body, err = compileBody(n, calls)
+	return literal, err
+}
+
+// compileSorts compiles the xsl:sort children of n.
+func compileSorts(n *xmlquery.Node) ([]xsltSort, error) {
+	var sorts []xsltSort
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != xmlquery.ElementNode || child.NamespaceURI != xsltNamespace {
+			continue
+		}
+		if child.Data != "sort" {
+			if n.Data == "apply-templates" {
+				return nil, fmt.Errorf("unsupported instruction 'xsl:%s'", child.Data)
+			}
+			continue
+		}
+		sortSpec := xsltSort{key: xpath.MustCompile(".")}
+		if _, ok := xmlAttr(child, "select"); ok {
+			var err error
+			if sortSpec.key, err = requiredExpr(child, "select", inScopeNamespaces(child)); err != nil {
+				return nil, err
+			}
+		}
+		switch order := xmlAttrValue(child, "order"); order {
+		case "", "ascending":
+		case "descending":
+			sortSpec.descending = true
+		default:
+			return nil, fmt.Errorf("invalid sort order '%s'", order)
+		}
+		switch dataType := xmlAttrValue(child, "data-type"); dataType {
+		case "", "text":
+		case "number":
+			sortSpec.numeric = true
+		default:
+			return nil, fmt.Errorf("invalid sort data type '%s'", dataType)
+		}
+		sorts = append(sorts, sortSpec)
+	}
+	return sorts, nil
+}
+
+// requiredExpr compiles the XPath expression of the attribute name of n.
+func requiredExpr(n *xmlquery.Node, name string, namespaces map[string]string) (*xpath.Expr, error) {
+	value, ok := xmlAttr(n, name)
+	if !ok {
+		return nil, fmt.Errorf("xsl:%s needs a %s attribute", n.Data, name)
+	}
+	expr, err := xpath.CompileWithNS(value, namespaces)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XPath '%s' in xsl:%s: %w", value, n.Data, err)
+	}
+	return expr, nil
+}
+
+// xmlAttr returns the value of the attribute name of n without a namespace.
+func xmlAttr(n *xmlquery.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// xmlAttrValue returns the value of the attribute name of n, or "".
+func xmlAttrValue(n *xmlquery.Node, name string) string {
+	value, _ := xmlAttr(n, name)
+	return value
+}
+
+// firstElement returns the first element child of n, or nil.
+func firstElement(n *xmlquery.Node) *xmlquery.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xmlquery.ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+// inScopeNamespaces returns the namespace prefixes declared on n and its
+// ancestors, for the XPath expressions of n.
+func inScopeNamespaces(n *xmlquery.Node) map[string]string {
+	namespaces := map[string]string{}
+	for ; n != nil; n = n.Parent {
+		for _, a := range n.Attr {
+			if _, declared := namespaces[a.Name.Local]; a.Name.Space == "xmlns" && !declared {
+				namespaces[a.Name.Local] = a.Value
+			}
+		}
+	}
+	return namespaces
+}
+
+// splitTopLevel splits s at each sep outside brackets, parentheses and
+// quotes.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// xsltNodeTest is the node test of the last step of a pattern, used to skip
+// rules that cannot match a node without evaluating their pattern.
+type xsltNodeTest struct {
+	any   bool // The pattern may match nodes of any type
+	kind  xpath.NodeType
+	local string // The local name the node must have, if not empty
+}
+
+// patternNodeTest returns the node test of the last step of pattern.
+func patternNodeTest(pattern string) xsltNodeTest {
+	steps := splitTopLevel(pattern, '/')
+	step := strings.TrimSpace(steps[len(steps)-1])
+	if i := strings.IndexByte(step, '['); i >= 0 {
+		step = strings.TrimSpace(step[:i])
+	}
+	if step == "" {
+		return xsltNodeTest{kind: xpath.RootNode}
+	}
+	test := xsltNodeTest{kind: xpath.ElementNode}
+	if name, ok := strings.CutPrefix(step, "@"); ok {
+		test.kind, step = xpath.AttributeNode, name
+	} else if name, ok := strings.CutPrefix(step, "attribute::"); ok {
+		test.kind, step = xpath.AttributeNode, name
+	} else {
+		step = strings.TrimPrefix(step, "child::")
+	}
+	switch {
+	case step == "text()":
+		test.kind = xpath.TextNode
+	case step == "comment()":
+		test.kind = xpath.CommentNode
+	case step == "*" || strings.HasSuffix(step, ":*"):
+	case step == "node()" && test.kind == xpath.AttributeNode:
+	case isQName(step):
+		test.local = step[strings.IndexByte(step, ':')+1:]
+	default:
+		return xsltNodeTest{any: true}
+	}
+	return test
+}
+
+// accepts reports whether node passes the test.
+func (test xsltNodeTest) accepts(node xpath.NodeNavigator) bool {
+	if test.any {
+		return true
+	}
+	return node.NodeType() == test.kind && (test.local == "" || node.LocalName() == test.local)
+}
+
+// defaultPriority returns the XSLT default priority of a pattern without
+// unions.
+func defaultPriority(pattern string) float64 {
+	step := strings.TrimPrefix(pattern, "@")
+	for _, axis := range []string{"child::", "attribute::"} {
+		step = strings.TrimPrefix(step, axis)
+	}
+	switch {
+	case isQName(step):
+		return 0
+	case strings.HasSuffix(step, ":*") && isQName(strings.TrimSuffix(step, ":*")):
+		return -0.25
+	case step == "*" || step == "node()" || step == "text()" || step == "comment()" || step == "processing-instruction()":
+		return -0.5
+	}
+	return 0.5
+}
+
+// isQName reports whether s is a name with an optional prefix.
+func isQName(s string) bool {
+	prefix, local, hasPrefix := strings.Cut(s, ":")
+	if hasPrefix {
+		return isNCName(prefix) && isNCName(local)
+	}
+	return isNCName(s)
+}
+
+// isNCName reports whether s is an XML name without a colon.
+func isNCName(s string) bool {
+	for i, r := range s {
+		letter := r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r > 0x7f
+		if !letter && (i == 0 || r != '-' && r != '.' && (r < '0' || r > '9')) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// xsltTransformation is the state of one application of a stylesheet.
+type xsltTransformation struct {
+	ctx      context.Context
+	sheet    *stylesheet
+	depth    int
+	selected map[xsltMatchKey]map[xsltNodeKey]bool // Nodes a rule's pattern selects from a context node
+}
+
+// xsltNodeKey identifies a node of the input; attributes are identified by
+// their owner element and name.
+type xsltNodeKey struct {
+	node      *xmlquery.Node
+	attribute xml.Name
+}
+
+type xsltMatchKey struct {
+	rule    int
+	context xsltNodeKey
+}
+
+func nodeKey(node xpath.NodeNavigator) xsltNodeKey {
+	key := xsltNodeKey{node: node.(*xmlquery.NodeNavigator).Current()}
+	if node.NodeType() == xpath.AttributeNode {
+		key.attribute = xml.Name{Space: node.Prefix(), Local: node.LocalName()}
+	}
+	return key
+}
+
+// transform applies the stylesheet to doc and serializes the output.
+func (s *stylesheet) transform(ctx context.Context, doc *xmlquery.Node) (string, error) {
+	s.stripWhitespace(doc)
+	t := &xsltTransformation{ctx: ctx, sheet: s, selected: map[xsltMatchKey]map[xsltNodeKey]bool{}}
+	result := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	if err := t.applyTemplates(xmlquery.CreateXPathNavigator(doc), result, ""); err != nil {
+		return "", err
+	}
+	if s.method == "text" {
+		return result.InnerText(), nil
+	}
+	var options []xmlquery.OutputOption
+	if s.indent {
+		options = append(options, xmlquery.WithIndentation("  "))
+	}
+	output := strings.TrimLeft(result.OutputXMLWithOptions(options...), "\n")
+	if !s.omitDecl {
+		output = `<?xml version="1.0" encoding="UTF-8"?>` + output
+	}
+	return output, nil
+}
+
+// stripWhitespace removes the whitespace-only text children of the elements
+// named by xsl:strip-space from doc.
+func (s *stylesheet) stripWhitespace(n *xmlquery.Node) {
+	strip := false
+	for _, name := range s.stripSpace {
+		if n.Type == xmlquery.ElementNode && (name == "*" || name == n.Data || name == n.Prefix+":"+n.Data) {
+			strip = true
+		}
+	}
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if strip && child.Type == xmlquery.TextNode && strings.TrimSpace(child.Data) == "" {
+			xmlquery.RemoveFromTree(child)
+		} else {
+			s.stripWhitespace(child)
+		}
+		child = next
+	}
+}
+
+// enter counts a nested template instantiation; the returned function ends
+// it.
+func (t *xsltTransformation) enter() (func(), error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if t.depth >= maxTemplateDepth {
+		return nil, fmt.Errorf("templates are nested more than %d levels deep", maxTemplateDepth)
+	}
+	t.depth++
+	return func() { t.depth-- }, nil
+}
+
+// applyTemplates instantiates the best template rule of mode for node, or
+// the built-in rule if none matches.
+func (t *xsltTransformation) applyTemplates(node xpath.NodeNavigator, out *xmlquery.Node, mode string) error {
+	leave, err := t.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+	if rule := t.match(node, mode); rule != nil {
+		return t.instantiate(rule.body, node, out)
+	}
+	switch node.NodeType() {
+	case xpath.RootNode, xpath.ElementNode:
+		children, _ := t.selectNodes(t.sheet.childNodes, node)
+		for _, child := range children {
+			if err := t.applyTemplates(child, out, mode); err != nil {
+				return err
+			}
+		}
+	case xpath.TextNode, xpath.AttributeNode:
+		appendText(out, nodeString(node))
+	}
+	return nil
+}
+
+// match returns the rule of mode with the highest priority that matches
+// node; of rules with the same priority, the last one wins.
+func (t *xsltTransformation) match(node xpath.NodeNavigator, mode string) *xsltRule {
+	var best *xsltRule
+	for i := range t.sheet.rules {
+		rule := &t.sheet.rules[i]
+		if rule.mode != mode || (best != nil && rule.priority < best.priority) || !rule.test.accepts(node) {
+			continue
+		}
+		if t.matches(i, node) {
+			best = rule
+		}
+	}
+	return best
+}
+
+// matches reports whether the pattern of rule i matches node: whether
+// evaluating it from node or one of its ancestors selects node.
+func (t *xsltTransformation) matches(i int, node xpath.NodeNavigator) bool {
+	rule := &t.sheet.rules[i]
+	key := nodeKey(node)
+	context := node.Copy()
+	if rule.absolute {
+		context.MoveToRoot()
+	}
+	for {
+		matchKey := xsltMatchKey{rule: i, context: nodeKey(context)}
+		selected, ok := t.selected[matchKey]
+		if !ok {
+			selected = map[xsltNodeKey]bool{}
+			iter := rule.pattern.Select(context.Copy())
+			for iter.MoveNext() {
+				selected[nodeKey(iter.Current())] = true
+			}
+			t.selected[matchKey] = selected
+		}
+		if selected[key] {
+			return true
+		}
+		if rule.absolute || !context.MoveToParent() {
+			return false
+		}
+	}
+}
+
+// instantiate instantiates the instructions of body for node into out.
+func (t *xsltTransformation) instantiate(body []xsltInstruction, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	for _, instruction := range body {
+		if err := instruction.instantiate(t, node, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// text instantiates body for node and returns the text it produces.
+func (t *xsltTransformation) text(body []xsltInstruction, node xpath.NodeNavigator) (string, error) {
+	fragment := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	if err := t.instantiate(body, node, fragment); err != nil {
+		return "", err
+	}
+	return fragment.InnerText(), nil
+}
+
+// selectNodes evaluates expr for node and returns the nodes it selects in
+// document order.
+func (t *xsltTransformation) selectNodes(expr *xpath.Expr, node xpath.NodeNavigator) ([]xpath.NodeNavigator, error) {
+	iter, ok := expr.Evaluate(node.Copy()).(*xpath.NodeIterator)
+	if !ok {
+		return nil, fmt.Errorf("'%s' does not select nodes", expr)
+	}
+	var nodes []xpath.NodeNavigator
+	for iter.MoveNext() {
+		nodes = append(nodes, iter.Current().Copy())
+	}
+	return nodes, nil
+}
+
+// sortNodes orders nodes by the sort keys, keeping document order for equal
+// keys.
+func (t *xsltTransformation) sortNodes(nodes []xpath.NodeNavigator, sorts []xsltSort) {
+	if len(sorts) == 0 {
+		return
+	}
+	keys := make([][]string, len(nodes))
+	for i, node := range nodes {
+		for _, s := range sorts {
+			keys[i] = append(keys[i], xpathString(s.key.Evaluate(node.Copy())))
+		}
+	}
+	order := make([]int, len(nodes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		for k, s := range sorts {
+			c := compareSortKeys(keys[order[a]][k], keys[order[b]][k], s.numeric)
+			if s.descending {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	sorted := make([]xpath.NodeNavigator, len(nodes))
+	for i, index := range order {
+		sorted[i] = nodes[index]
+	}
+	copy(nodes, sorted)
+}
+
+// compareSortKeys compares two sort keys as text, or as numbers with keys
+// that are not numbers first.
+func compareSortKeys(a, b string, numeric bool) int {
+	if !numeric {
+		return strings.Compare(a, b)
+	}
+	x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	switch {
+	case errA != nil || errB != nil:
+		return boolRank(errA == nil) - boolRank(errB == nil)
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// xpathString converts an XPath value to a string as the string() function
+// does.
+func xpathString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case *xpath.NodeIterator:
+		if v.MoveNext() {
+			return nodeString(v.Current())
+		}
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// xpathBoolean converts an XPath value to a boolean as the boolean()
+// function does.
+func xpathBoolean(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return v != ""
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case *xpath.NodeIterator:
+		return v.MoveNext()
+	}
+	return false
+}
+
+// nodeString returns the string value of a node.
+func nodeString(node xpath.NodeNavigator) string {
+	if node.NodeType() == xpath.AttributeNode {
+		return node.Value()
+	}
+	return node.(*xmlquery.NodeNavigator).Current().InnerText()
+}
+
+// appendText adds text to out, joining it with a preceding text node.
+func appendText(out *xmlquery.Node, text string) {
+	if text == "" {
+		return
+	}
+	if out.LastChild != nil && out.LastChild.Type == xmlquery.TextNode {
+		out.LastChild.Data += text
+		return
+	}
+	xmlquery.AddChild(out, &xmlquery.Node{Type: xmlquery.TextNode, Data: text})
+}
+
+// setAttribute sets an attribute of the element out; attributes added to
+// anything else are ignored.
+func setAttribute(out *xmlquery.Node, name xml.Name, value string) {
+	if out.Type != xmlquery.ElementNode {
+		return
+	}
+	for i, a := range out.Attr {
+		if a.Name == name {
+			out.Attr[i].Value = value
+			return
+		}
+	}
+	out.Attr = append(out.Attr, xmlquery.Attr{Name: name, Value: value})
+}
+
+// declareNamespace declares prefix as uri on el unless el or one of its
+// ancestors in the output already declares the prefix.
+func declareNamespace(el *xmlquery.Node, prefix, uri string) {
+	if uri == "" {
+		return
+	}
+	name := xml.Name{Space: "xmlns", Local: prefix}
+	if prefix == "" {
+		name = xml.Name{Local: "xmlns"}
+	}
+	for n := el; n != nil; n = n.Parent {
+		for _, a := range n.Attr {
+			if a.Name == name {
+				return
+			}
+		}
+	}
+	el.Attr = append(el.Attr, xmlquery.Attr{Name: name, Value: uri})
+}
+
+// copyNode adds a deep copy of node to out.
+func copyNode(node xpath.NodeNavigator, out *xmlquery.Node) {
+	switch node.NodeType() {
+	case xpath.AttributeNode:
+		setAttribute(out, xml.Name{Space: node.Prefix(), Local: node.LocalName()}, node.Value())
+		return
+	case xpath.RootNode:
+		for child := node.(*xmlquery.NodeNavigator).Current().FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != xmlquery.DeclarationNode {
+				xmlquery.AddChild(out, deepCopy(child))
+			}
+		}
+		return
+	}
+	copied := deepCopy(node.(*xmlquery.NodeNavigator).Current())
+	xmlquery.AddChild(out, copied)
+	declareNamespaces(copied)
+}
+
+// deepCopy copies n and its descendants.
+func deepCopy(n *xmlquery.Node) *xmlquery.Node {
+	copied := &xmlquery.Node{Type: n.Type, Data: n.Data, Prefix: n.Prefix, NamespaceURI: n.NamespaceURI, Attr: append([]xmlquery.Attr(nil), n.Attr...)}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != xmlquery.DeclarationNode {
+			xmlquery.AddChild(copied, deepCopy(child))
+		}
+	}
+	return copied
+}
+
+// declareNamespaces declares the namespaces of the copied element n and its
+// descendants that were declared on ancestors in the input.
+func declareNamespaces(n *xmlquery.Node) {
+	if n.Type != xmlquery.ElementNode {
+		return
+	}
+	declareNamespace(n, n.Prefix, n.NamespaceURI)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		declareNamespaces(child)
+	}
+}
+
+// xsltInstruction is a compiled instruction of a template body.
+type xsltInstruction interface {
+	instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error
+}
+
+// xsltText is literal text, from xsl:text or the stylesheet.
+type xsltText string
+
+func (text xsltText) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	appendText(out, string(text))
+	return nil
+}
+
+// xsltValueOf is xsl:value-of.
+type xsltValueOf struct {
+	selectExpr *xpath.Expr
+}
+
+func (v *xsltValueOf) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	appendText(out, xpathString(v.selectExpr.Evaluate(node.Copy())))
+	return nil
+}
+
+// xsltCopyOf is xsl:copy-of.
+type xsltCopyOf struct {
+	selectExpr *xpath.Expr
+}
+
+func (c *xsltCopyOf) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	value := c.selectExpr.Evaluate(node.Copy())
+	iter, ok := value.(*xpath.NodeIterator)
+	if !ok {
+		appendText(out, xpathString(value))
+		return nil
+	}
+	for iter.MoveNext() {
+		copyNode(iter.Current(), out)
+	}
+	return nil
+}
+
+// xsltSort is an xsl:sort key.
+type xsltSort struct {
+	key        *xpath.Expr
+	descending bool
+	numeric    bool
+}
+
+// xsltApplyTemplates is xsl:apply-templates.
+type xsltApplyTemplates struct {
+	selectExpr *xpath.Expr
+	mode       string
+	sorts      []xsltSort
+}
+
+func (a *xsltApplyTemplates) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	nodes, err := t.selectNodes(a.selectExpr, node)
+	if err != nil {
+		return fmt.Errorf("xsl:apply-templates: %w", err)
+	}
+	t.sortNodes(nodes, a.sorts)
+	for _, selected := range nodes {
+		if err := t.applyTemplates(selected, out, a.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xsltForEach is xsl:for-each.
+type xsltForEach struct {
+	selectExpr *xpath.Expr
+	sorts      []xsltSort
+	body       []xsltInstruction
+}
+
+func (f *xsltForEach) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	nodes, err := t.selectNodes(f.selectExpr, node)
+	if err != nil {
+		return fmt.Errorf("xsl:for-each: %w", err)
+	}
+	t.sortNodes(nodes, f.sorts)
+	for _, selected := range nodes {
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
+		if err := t.instantiate(f.body, selected, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xsltCallTemplate is xsl:call-template with the name of the template.
+type xsltCallTemplate string
+
+func (name xsltCallTemplate) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	leave, err := t.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+	return t.instantiate(t.sheet.named[string(name)], node, out)
+}
+
+// xsltChoose is xsl:choose, or xsl:if as a choice with one branch.
+type xsltChoose []xsltBranch
+
+// xsltBranch is an xsl:when, or an xsl:otherwise without a test.
+type xsltBranch struct {
+	test *xpath.Expr
+	body []xsltInstruction
+}
+
+func (c *xsltChoose) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	for _, branch := range *c {
+		if branch.test == nil || xpathBoolean(branch.test.Evaluate(node.Copy())) {
+			return t.instantiate(branch.body, node, out)
+		}
+	}
+	return nil
+}
+
+// xsltCopy is xsl:copy.
+type xsltCopy struct {
+	body []xsltInstruction
+}
+
+func (c *xsltCopy) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	switch node.NodeType() {
+	case xpath.RootNode:
+		return t.instantiate(c.body, node, out)
+	case xpath.ElementNode:
+		source := node.(*xmlquery.NodeNavigator).Current()
+		el := &xmlquery.Node{Type: xmlquery.ElementNode, Data: source.Data, Prefix: source.Prefix, NamespaceURI: source.NamespaceURI}
+		xmlquery.AddChild(out, el)
+		declareNamespace(el, el.Prefix, el.NamespaceURI)
+		return t.instantiate(c.body, node, el)
+	case xpath.CommentNode:
+		xmlquery.AddChild(out, &xmlquery.Node{Type: xmlquery.CommentNode, Data: node.Value()})
+	default:
+		copyNode(node, out)
+	}
+	return nil
+}
+
+// xsltConstructor is xsl:element or xsl:attribute.
+type xsltConstructor struct {
+	attribute  bool
+	name       attributeValueTemplate
+	namespace  attributeValueTemplate // nil to use the declaration of the name's prefix
+	namespaces map[string]string      // Prefixes in scope in the stylesheet
+	body       []xsltInstruction
+}
+
+func (c *xsltConstructor) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	name := c.name.evaluate(node)
+	if !isQName(name) {
+		return fmt.Errorf("'%s' is not a valid name for xsl:%s", name, c.kind())
+	}
+	prefix, local, _ := strings.Cut(name, ":")
+	if local == "" {
+		prefix, local = "", prefix
+	}
+	uri := c.namespaces[prefix]
+	if c.namespace != nil {
+		uri = c.namespace.evaluate(node)
+	}
+	if c.attribute {
+		value, err := t.text(c.body, node)
+		if err != nil {
+			return err
+		}
+		setAttribute(out, xml.Name{Space: prefix, Local: local}, value)
+		if prefix != "" && out.Type == xmlquery.ElementNode {
+			declareNamespace(out, prefix, uri)
+		}
+		return nil
+	}
+	el := &xmlquery.Node{Type: xmlquery.ElementNode, Data: local, Prefix: prefix, NamespaceURI: uri}
+	xmlquery.AddChild(out, el)
+	declareNamespace(el, prefix, uri)
+	return t.instantiate(c.body, node, el)
+}
+
+func (c *xsltConstructor) kind() string {
+	if c.attribute {
+		return "attribute"
+	}
+	return "element"
+}
+
+// xsltComment is xsl:comment.
+type xsltComment struct {
+	body []xsltInstruction
+}
+
+func (c *xsltComment) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	text, err := t.text(c.body, node)
+	if err != nil {
+		return err
+	}
+	xmlquery.AddChild(out, &xmlquery.Node{Type: xmlquery.CommentNode, Data: text})
+	return nil
+}
+
+// xsltLiteralElement is an element of the stylesheet copied to the output.
+type xsltLiteralElement struct {
+	local, prefix, namespaceURI string
+	declarations                []xmlquery.Attr // Namespace declarations, except the XSLT namespace
+	attributes                  []xsltLiteralAttribute
+	body                        []xsltInstruction
+}
+
+type xsltLiteralAttribute struct {
+	name  xml.Name
+	value attributeValueTemplate
+}
+
+func (l *xsltLiteralElement) instantiate(t *xsltTransformation, node xpath.NodeNavigator, out *xmlquery.Node) error {
+	el := &xmlquery.Node{Type: xmlquery.ElementNode, Data: l.local, Prefix: l.prefix, NamespaceURI: l.namespaceURI}
+	el.Attr = append(el.Attr, l.declarations...)
+	xmlquery.AddChild(out, el)
+	declareNamespace(el, l.prefix, l.namespaceURI)
+	for _, a := range l.attributes {
+		setAttribute(el, a.name, a.value.evaluate(node))
+	}
+	return t.instantiate(l.body, node, el)
+}
+
+// attributeValueTemplate is an attribute value with XPath expressions in
+// braces, e.g. "{@id}-{position}"; "{{" and "}}" stand for braces.
+type attributeValueTemplate []avtPart
+
+// avtPart is literal text, or an expression if expr is not nil.
+type avtPart struct {
+	text string
+	expr *xpath.Expr
+}
+
+// compileAVT compiles an attribute value template.
+func compileAVT(value string, namespaces map[string]string) (attributeValueTemplate, error) {
+	var avt attributeValueTemplate
+	var text strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case (c == '{' || c == '}') && i+1 < len(value) && value[i+1] == c:
+			text.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(value[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed '{' in attribute value template '%s'", value)
+			}
+			expr, err := xpath.CompileWithNS(value[i+1:i+end], namespaces)
+			if err != nil {
+				return nil, fmt.Errorf("invalid XPath in attribute value template '%s': %w", value, err)
+			}
+			avt = append(avt, avtPart{text: text.String()}, avtPart{expr: expr})
+			text.Reset()
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("unmatched '}' in attribute value template '%s'", value)
+		default:
+			text.WriteByte(c)
+		}
+	}
+	return append(avt, avtPart{text: text.String()}), nil
+}
+
+// evaluate returns the value of the template for node.
+func (avt attributeValueTemplate) evaluate(node xpath.NodeNavigator) string {
+	var b strings.Builder
+	for _, part := range avt {
+		if part.expr != nil {
+			b.WriteString(xpathString(part.expr.Evaluate(node.Copy())))
+		} else {
+			b.WriteString(part.text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,215 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testInvoice = `<invoice id="INV-7">
+	<customer>Acme</customer>
+	<line sku="pen"><qty>2</qty><amount>3.5</amount></line>
+	<line sku="ink"><qty>1</qty><amount>12</amount></line>
+	<line sku="pad"><qty>4</qty><amount>6</amount></line>
+	<secret>s3cr3t</secret>
+</invoice>`
+
+var testStylesheets = map[string]string{
+	"invoiceToSummary": `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+		<xsl:output omit-xml-declaration="yes"/>
+		<xsl:template match="/">
+			<summary invoice="{invoice/@id}">
+				<customer><xsl:value-of select="invoice/customer"/></customer>
+				<total><xsl:value-of select="sum(invoice/line/amount)"/></total>
+				<xsl:for-each select="invoice/line">
+					<xsl:sort select="amount" data-type="number" order="descending"/>
+					<item sku="{@sku}"/>
+				</xsl:for-each>
+			</summary>
+		</xsl:template>
+	</xsl:stylesheet>`,
+	"redact": `<xsl:transform version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+		<xsl:output omit-xml-declaration="yes"/>
+		<xsl:strip-space elements="*"/>
+		<xsl:template match="@*|node()">
+			<xsl:copy><xsl:apply-templates select="@*|node()"/></xsl:copy>
+		</xsl:template>
+		<xsl:template match="secret"/>
+		<xsl:template match="line/@sku"><xsl:attribute name="code"><xsl:value-of select="."/></xsl:attribute></xsl:template>
+	</xsl:transform>`,
+	"toJSON": `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+		<xsl:output method="text" media-type="application/json"/>
+		<xsl:template match="/invoice">{"customer": "<xsl:value-of select="customer"/>", "skus": [<xsl:for-each select="line"><xsl:sort select="@sku"/>"<xsl:value-of select="@sku"/>"<xsl:if test="@sku != 'pen'">, </xsl:if></xsl:for-each>]}</xsl:template>
+	</xsl:stylesheet>`,
+	"lines": `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+		<xsl:output method="text"/>
+		<xsl:template match="/"><xsl:apply-templates select="//line" mode="row"/></xsl:template>
+		<xsl:template match="line" mode="row"><xsl:call-template name="label"/><xsl:text>&#10;</xsl:text></xsl:template>
+		<xsl:template name="label">
+			<xsl:value-of select="@sku"/>
+			<xsl:text>=</xsl:text>
+			<xsl:choose>
+				<xsl:when test="qty &gt; 3">many</xsl:when>
+				<xsl:when test="qty &gt; 1">some</xsl:when>
+				<xsl:otherwise>one</xsl:otherwise>
+			</xsl:choose>
+		</xsl:template>
+	</xsl:stylesheet>`,
+	"namespaced": `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform" xmlns:s="urn:summary">
+		<xsl:output omit-xml-declaration="yes"/>
+		<xsl:template match="invoice">
+			<s:summary><xsl:element name="s:{name(*[1])}"><xsl:value-of select="customer"/></xsl:element><xsl:comment>generated</xsl:comment></s:summary>
+		</xsl:template>
+		<xsl:template match="text()"/>
+	</xsl:stylesheet>`,
+	"priorities": `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+		<xsl:output method="text"/>
+		<xsl:template match="/"><xsl:apply-templates select="invoice/*"/></xsl:template>
+		<xsl:template match="*">[any]</xsl:template>
+		<xsl:template match="line">[line]</xsl:template>
+		<xsl:template match="line[@sku='ink']">[ink]</xsl:template>
+		<xsl:template match="customer" priority="-1">[customer]</xsl:template>
+	</xsl:stylesheet>`,
+	"endless": `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+		<xsl:template match="/"><xsl:apply-templates select="."/></xsl:template>
+	</xsl:stylesheet>`,
+}
+
+func newXSLTEngine(t *testing.T) *ExpressionEngine {
+	t.Helper()
+	engine := NewEngine()
+	for name, source := range testStylesheets {
+		if err := engine.RegisterStylesheet(name, []byte(source)); err != nil {
+			t.Fatalf("RegisterStylesheet(%s): %v", name, err)
+		}
+	}
+	return engine
+}
+
+func TestXSLTPipe(t *testing.T) {
+	msgCtx := NewMessageContext([]byte(testInvoice), "application/xml", newXSLTEngine(t))
+	tests := []struct {
+		name       string
+		expression string
+		want       interface{}
+	}{
+		{name: "summary of the selected element", expression: `xpath:/invoice | xslt("invoiceToSummary")`,
+			want: `<summary invoice="INV-7"><customer>Acme</customer><total>21.5</total><item sku="ink"></item><item sku="pad"></item><item sku="pen"></item></summary>`},
+		{name: "query of the output", expression: `xpath:/invoice | xslt("invoiceToSummary") | xpath:/summary/item[1]/@sku`, want: "ink"},
+		{name: "identity with overrides", expression: `xpath:/invoice | xslt("redact")`,
+			want: `<invoice id="INV-7"><customer>Acme</customer><line code="pen"><qty>2</qty><amount>3.5</amount></line><line code="ink"><qty>1</qty><amount>12</amount></line><line code="pad"><qty>4</qty><amount>6</amount></line></invoice>`},
+		{name: "text output as JSON", expression: `xpath:/invoice | xslt("toJSON") | jsonpath:skus.1`, want: "pad"},
+		{name: "text output as text", expression: `xpath:/invoice | xslt("lines") | text:lines`, want: []interface{}{"pen=some", "ink=one", "pad=many"}},
+		{name: "namespaced output", expression: `xpath:/invoice | xslt("namespaced")`,
+			want: `<s:summary xmlns:s="urn:summary"><s:customer>Acme</s:customer><!--generated--></s:summary>`},
+		{name: "template priorities", expression: `xpath:/invoice | xslt("priorities")`, want: "[any][line][ink][line][any]"},
+		{name: "XML text result", expression: `xpath:string(/invoice/customer) | regexReplace(".+", "<invoice><customer>$0</customer></invoice>") | xslt("toJSON") | jsonpath:customer`, want: "Acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestXSLTPipeErrors(t *testing.T) {
+	msgCtx := NewMessageContext([]byte(testInvoice), "application/xml", newXSLTEngine(t))
+	tests := []struct {
+		name       string
+		expression string
+		wantReason string
+	}{
+		{name: "unknown stylesheet", expression: `xpath:/invoice | xslt("missing")`, wantReason: "pipe operation 'xslt' failed"},
+		{name: "input not XML", expression: `xpath:string(/invoice/customer) | xslt("redact")`, wantReason: "failed to create intermediate XML payload"},
+		{name: "endless recursion", expression: `xpath:/invoice | xslt("endless")`, wantReason: "pipe operation 'xslt' failed"},
+		{name: "missing name", expression: `xpath:/invoice | xslt`, wantReason: "xslt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := msgCtx.EvaluateExpression(tt.expression)
+			var evalErr *ErrEvaluationFailed
+			if !errors.As(err, &evalErr) || !strings.Contains(err.Error(), tt.wantReason) {
+				t.Fatalf("got %v, want an evaluation error containing %q", err, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestXSLTPipeObservesCancellation(t *testing.T) {
+	engine := newXSLTEngine(t)
+	payload, err := NewXMLPayload([]byte(testInvoice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := engine.EvaluateContext(ctx, payload, `xpath:/invoice | xslt("redact")`); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestRegisterStylesheetErrors(t *testing.T) {
+	const header = `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">`
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{name: "not XML", source: `<xsl:stylesheet`, wantErr: "stylesheet 'sheet'"},
+		{name: "not a stylesheet", source: `<stylesheet/>`, wantErr: "not xsl:stylesheet"},
+		{name: "variable", source: header + `<xsl:variable name="v" select="1"/></xsl:stylesheet>`, wantErr: "unsupported top-level element 'xsl:variable'"},
+		{name: "instruction", source: header + `<xsl:template match="/"><xsl:number/></xsl:template></xsl:stylesheet>`, wantErr: "unsupported instruction 'xsl:number'"},
+		{name: "parameter", source: header + `<xsl:template match="/"><xsl:call-template name="t"><xsl:with-param name="p"/></xsl:call-template></xsl:template><xsl:template name="t"/></xsl:stylesheet>`, wantErr: "unsupported instruction 'xsl:with-param'"},
+		{name: "unknown template", source: header + `<xsl:template match="/"><xsl:call-template name="nope"/></xsl:template></xsl:stylesheet>`, wantErr: "unknown template 'nope'"},
+		{name: "template without match or name", source: header + `<xsl:template/></xsl:stylesheet>`, wantErr: "needs a match or a name"},
+		{name: "invalid pattern", source: header + `<xsl:template match="a[["/></xsl:stylesheet>`, wantErr: "invalid match pattern"},
+		{name: "invalid select", source: header + `<xsl:template match="/"><xsl:value-of select="(("/></xsl:template></xsl:stylesheet>`, wantErr: "invalid XPath"},
+		{name: "missing select", source: header + `<xsl:template match="/"><xsl:value-of/></xsl:template></xsl:stylesheet>`, wantErr: "needs a select attribute"},
+		{name: "unclosed value template", source: header + `<xsl:template match="/"><a b="{@c"/></xsl:template></xsl:stylesheet>`, wantErr: "unclosed '{'"},
+		{name: "output method", source: header + `<xsl:output method="html"/></xsl:stylesheet>`, wantErr: "unsupported output method 'html'"},
+		{name: "sort order", source: header + `<xsl:template match="/"><xsl:for-each select="*"><xsl:sort order="up"/></xsl:for-each></xsl:template></xsl:stylesheet>`, wantErr: "invalid sort order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewEngine().RegisterStylesheet("sheet", []byte(tt.source))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+	if err := NewEngine().RegisterStylesheet("", []byte(testStylesheets["redact"])); err == nil {
+		t.Error("expected an error for an empty name")
+	}
+}
+
+func TestPatternPriorities(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    float64
+	}{
+		{"line", 0},
+		{"@sku", 0},
+		{"s:line", 0},
+		{"s:*", -0.25},
+		{"*", -0.5},
+		{"node()", -0.5},
+		{"text()", -0.5},
+		{"@*", -0.5},
+		{"invoice/line", 0.5},
+		{"line[@sku]", 0.5},
+		{"/", 0.5},
+	}
+	for _, tt := range tests {
+		if got := defaultPriority(tt.pattern); got != tt.want {
+			t.Errorf("defaultPriority(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
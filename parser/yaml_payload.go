@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLPayload handles YAML data.
+// The document is decoded once and exposed through a JSON view, so queries use
+// the same gjson path syntax as JSONPayload (e.g. "spec.containers.0.image").
+type YAMLPayload struct {
+	rawContent  []byte
+	document    interface{}  // Decoded YAML document(s)
+	jsonResult  gjson.Result // JSON view used for queries
+	contentType string
+}
+
+// NewYAMLPayload creates a new YAMLPayload.
+// A stream with several documents separated by "---" (common for Kubernetes
+// manifests) is exposed as an array with one element per document.
+func NewYAMLPayload(content []byte) (*YAMLPayload, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	var docs []interface{}
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, &ErrEvaluationFailed{Reason: "YAML parsing failed", InnerError: err}
+		}
+		docs = append(docs, doc)
+	}
+
+	var document interface{}
+	switch len(docs) {
+	case 0:
+		document = nil
+	case 1:
+		document = docs[0]
+	default:
+		document = docs
+	}
+
+	jsonView, err := marshalJSONView(document)
+	if err != nil {
+		return nil, &ErrEvaluationFailed{Reason: "failed to convert YAML document to JSON view", InnerError: err}
+	}
+	return &YAMLPayload{
+		rawContent:  content,
+		document:    document,
+		jsonResult:  gjson.ParseBytes(jsonView),
+		contentType: "application/yaml",
+	}, nil
+}
+
+func (yp *YAMLPayload) GetRawBytes() []byte {
+	return yp.rawContent
+}
+
+func (yp *YAMLPayload) GetContentType() string {
+	return yp.contentType
+}
+
+// Query evaluates a gjson path against the JSON view of the YAML document.
+func (yp *YAMLPayload) Query(expression string) (QueryResult, error) {
+	return queryJSONView(yp.jsonResult, expression)
+}
+
+func (yp *YAMLPayload) AsString() (string, error) {
+	return string(yp.rawContent), nil
+}
+
+func (yp *YAMLPayload) GetUnderlying() interface{} {
+	return yp.document
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestYAMLPayloadQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		expression string
+		want       interface{}
+		wantErr    bool
+	}{
+		{
+			name:       "nested scalar",
+			content:    "kind: Pod\nspec:\n  containers:\n    - name: web\n      image: nginx:1.25\n",
+			expression: "yamlpath:spec.containers.0.image",
+			want:       "nginx:1.25",
+		},
+		{
+			name:       "sequence",
+			content:    "ports: [80, 443]\n",
+			expression: "yamlpath:ports",
+			want:       []interface{}{float64(80), float64(443)},
+		},
+		{
+			name:       "multi-document stream becomes an array",
+			content:    "a: 1\n---\na: 2\n",
+			expression: "yamlpath:#.a",
+			want:       []interface{}{float64(1), float64(2)},
+		},
+		{
+			name:       "non-string keys",
+			content:    "codes:\n  200: ok\n",
+			expression: "yamlpath:codes.200",
+			want:       "ok",
+		},
+		{
+			name:       "infinity does not break unrelated keys",
+			content:    "limit: .inf\nname: svc\n",
+			expression: "yamlpath:name",
+			want:       "svc",
+		},
+		{
+			name:       "non-finite floats become strings",
+			content:    "limits: [.inf, -.inf, .nan]\n",
+			expression: "yamlpath:limits",
+			want:       []interface{}{"+Inf", "-Inf", "NaN"},
+		},
+		{
+			name:       "missing path",
+			content:    "a: 1\n",
+			expression: "yamlpath:b",
+			wantErr:    true,
+		},
+		{
+			name:       "jsonpath is rejected",
+			content:    "a: 1\n",
+			expression: "jsonpath:a",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgCtx := NewMessageContext([]byte(tt.content), "application/yaml", NewEngine())
+			result, err := msgCtx.EvaluateExpression(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", result.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractAsYAMLPipe(t *testing.T) {
+	msgCtx := NewMessageContext([]byte(`{"config":"replicas: 3\nimage: app:2\n"}`), "application/json", NewEngine())
+	result, err := msgCtx.EvaluateExpression("jsonpath:config | extractAsYAML | yamlpath:replicas")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Value != float64(3) {
+		t.Errorf("got %v, want 3", result.Value)
+	}
+}
+
+func TestNewYAMLPayloadInvalid(t *testing.T) {
+	if _, err := NewYAMLPayload([]byte("a: [1, 2\n")); err == nil {
+		t.Fatal("expected an error for invalid YAML")
+	}
+}